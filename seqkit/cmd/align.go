@@ -0,0 +1,504 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// alignCmd represents the align command
+var alignCmd = &cobra.Command{
+	Use:   "align",
+	Short: "pairwise global/local/semi-global alignment of sequences",
+	Long: `pairwise alignment of sequences
+
+Sequences are aligned to a reference with affine gap penalties, i.e., a gap
+of length L scores gap_open + L * gap_extend. Alignment modes (-m/--mode):
+
+  global        Needleman-Wunsch alignment of whole sequences
+  local         Smith-Waterman alignment of the best matching regions
+  semi-global   global alignment without penalties of end gaps, e.g., for
+                amplicons or reads in longer references, or overlapping ends
+
+References:
+  1. If -r/--ref-file is given and contains one sequence, all sequences
+     are aligned to it (all-vs-one), otherwise sequences are aligned to
+     reference sequences with the same IDs.
+  2. Without -r/--ref-file, all sequences are aligned to the first sequence
+     of the input.
+
+Output columns (tab-delimited):
+  query, qlen, ref, rlen, score, identity (matches / alignment length),
+  matches, alnLen, qstart, qend, rstart, rend (1-based), CIGAR
+
+Unaligned ends of queries are soft-clipped (S) in CIGAR. Alignments are
+computed with quadratic memory, so it's designed for short sequences like
+genes and amplicons.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		refFile := getFlagString(cmd, "ref-file")
+		mode := getFlagString(cmd, "mode")
+		switch mode {
+		case "global", "local", "semi-global":
+		default:
+			checkError(fmt.Errorf("invalid value of flag -m/--mode: %s, available: global, local, semi-global", mode))
+		}
+		p := parseAlnParams(getFlagString(cmd, "aln-params"))
+		if p.Match <= 0 || p.Mismatch > 0 || p.GapOpen > 0 || p.GapExtend >= 0 {
+			checkError(fmt.Errorf("invalid alignment parameters, positive match score and negative penalties needed: %s", getFlagString(cmd, "aln-params")))
+		}
+		printAln := getFlagBool(cmd, "print-aln")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		var refs map[string]*fastx.Record
+		var ref0 *fastx.Record
+		if refFile != "" {
+			records, err := fastx.GetSeqs(refFile, alphabet, config.Threads, 10, idRegexp)
+			checkError(err)
+			if len(records) == 0 {
+				checkError(fmt.Errorf("no sequences found in reference file: %s", refFile))
+			}
+			if len(records) == 1 {
+				ref0 = records[0]
+			} else {
+				refs = make(map[string]*fastx.Record, len(records))
+				for _, r := range records {
+					refs[string(r.ID)] = r
+				}
+			}
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+		outfh.WriteString("query\tqlen\tref\trlen\tscore\tidentity\tmatches\talnLen\tqstart\tqend\trstart\trend\tcigar\n")
+
+		type alnJob struct {
+			id         uint64
+			query, ref *fastx.Record
+		}
+		type alnResult struct {
+			id   uint64
+			row  string
+			text string
+		}
+
+		jobs := make(chan alnJob, config.Threads)
+		results := make(chan alnResult, config.Threads)
+
+		var wg sync.WaitGroup
+		for i := 0; i < config.Threads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				aligner := newPairAligner(p, mode)
+				for job := range jobs {
+					a := aligner.align(job.ref.Seq.Seq, job.query.Seq.Seq)
+					r := alnResult{id: job.id, row: fmt.Sprintf("%s\t%d\t%s\t%d\t%d\t%.4f\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+						job.query.ID, len(job.query.Seq.Seq), job.ref.ID, len(job.ref.Seq.Seq),
+						a.score, a.identity(), a.matches, a.alnLen, a.qStart+1, a.qEnd, a.rStart+1, a.rEnd, a.cigar)}
+					if printAln {
+						r.text = a.pretty(job.query.ID, job.ref.ID, 60)
+					}
+					results <- r
+				}
+			}()
+		}
+
+		done := make(chan int)
+		go func() {
+			var id uint64 = 1 // for keepping order
+			buf := make(map[uint64]alnResult)
+			var r alnResult
+			var ok bool
+			for result := range results {
+				buf[result.id] = result
+				for {
+					if r, ok = buf[id]; !ok {
+						break
+					}
+					outfh.WriteString(r.row)
+					if printAln {
+						os.Stderr.WriteString(r.text)
+					}
+					delete(buf, id)
+					id++
+				}
+			}
+			done <- 1
+		}()
+
+		var n, missing uint64
+		var ok bool
+		var ref *fastx.Record
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+
+				if refFile == "" && ref0 == nil {
+					ref0 = record.Clone()
+					continue
+				}
+				if ref0 != nil {
+					ref = ref0
+				} else if ref, ok = refs[string(record.ID)]; !ok {
+					missing++
+					continue
+				}
+
+				n++
+				jobs <- alnJob{id: n, query: record.Clone(), ref: ref}
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+		<-done
+
+		if !quiet {
+			if missing > 0 {
+				log.Warningf("%d sequences skipped for no reference sequences with the same IDs", missing)
+			}
+			log.Infof("%d alignments computed", n)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(alignCmd)
+
+	alignCmd.Flags().StringP("ref-file", "r", "", "reference file, see details above")
+	alignCmd.Flags().StringP("mode", "m", "global", "alignment mode: global, local or semi-global")
+	alignCmd.Flags().StringP("aln-params", "p", "2,-3,-5,-2", "alignment parameters in format \"<match>,<mismatch>,<gap_open>,<gap_extend>\"")
+	alignCmd.Flags().BoolP("print-aln", "g", false, "print pretty alignments to stderr")
+}
+
+// pairAligner aligns two sequences with affine gap penalties (Gotoh).
+type pairAligner struct {
+	p    *AlnParams
+	mode string
+
+	tb []byte // traceback of all cells
+}
+
+// pairAlignment is an alignment with 0-based half-open coordinates.
+type pairAlignment struct {
+	score          int
+	rStart, rEnd   int
+	qStart, qEnd   int
+	matches        int
+	alnLen         int
+	cigar          string
+	refAln, qryAln []byte
+}
+
+func (a *pairAlignment) identity() float64 {
+	if a.alnLen == 0 {
+		return 0
+	}
+	return float64(a.matches) / float64(a.alnLen)
+}
+
+func newPairAligner(p *AlnParams, mode string) *pairAligner {
+	return &pairAligner{p: p, mode: mode}
+}
+
+const (
+	tbStop  = 0
+	tbDiag  = 1
+	tbIns   = 2 // from E, gap in the reference
+	tbDel   = 3 // from F, gap in the query
+	tbExtE  = 4
+	tbExtF  = 8
+	alnNInf = -1 << 30
+)
+
+// align aligns a query (b) to a reference (a).
+func (x *pairAligner) align(a0, b0 []byte) *pairAlignment {
+	a, b := bytes.ToUpper(a0), bytes.ToUpper(b0)
+	n, m := len(a), len(b)
+	match, mismatch := x.p.Match, x.p.Mismatch
+	open, ext := x.p.GapOpen, x.p.GapExtend
+	local := x.mode == "local"
+	freeEnds := x.mode != "global"
+
+	w := m + 1
+	if cap(x.tb) < (n+1)*w {
+		x.tb = make([]byte, (n+1)*w)
+	}
+	tb := x.tb[:(n+1)*w]
+
+	// rolling rows of H and E, and a column vector of F
+	H0, H1 := make([]int, w), make([]int, w)
+	E := make([]int, w)
+	F := make([]int, w)
+
+	// the first row
+	tb[0] = tbStop
+	H0[0], E[0], F[0] = 0, alnNInf, alnNInf
+	for j := 1; j <= m; j++ {
+		F[j] = alnNInf
+		if freeEnds {
+			H0[j], E[j], tb[j] = 0, alnNInf, tbStop
+		} else {
+			E[j] = open + j*ext
+			H0[j] = E[j]
+			tb[j] = tbIns
+			if j > 1 {
+				tb[j] |= tbExtE
+			}
+		}
+	}
+
+	bestScore, bestI, bestJ := 0, 0, 0
+	if freeEnds && !local {
+		bestScore = alnNInf
+	}
+	var h, e, f, s, t int
+	var c byte
+	for i := 1; i <= n; i++ {
+		// the first column
+		t = i * w
+		if freeEnds {
+			H1[0], tb[t] = 0, tbStop
+			F[0] = alnNInf
+		} else {
+			F[0] = open + i*ext
+			H1[0] = F[0]
+			tb[t] = tbDel
+			if i > 1 {
+				tb[t] |= tbExtF
+			}
+		}
+		e = alnNInf
+		for j := 1; j <= m; j++ {
+			c = 0
+			// E: gap in the reference
+			if H1[j-1]+open+ext >= e+ext {
+				e = H1[j-1] + open + ext
+			} else {
+				e += ext
+				c |= tbExtE
+			}
+			// F: gap in the query
+			if H0[j]+open+ext >= F[j]+ext {
+				f = H0[j] + open + ext
+			} else {
+				f = F[j] + ext
+				c |= tbExtF
+			}
+			F[j] = f
+
+			if a[i-1] == b[j-1] {
+				s = match
+			} else {
+				s = mismatch
+			}
+			h, c = H0[j-1]+s, c|tbDiag
+			if e > h {
+				h, c = e, c&^3|tbIns
+			}
+			if f > h {
+				h, c = f, c&^3|tbDel
+			}
+			if local && h <= 0 {
+				h, c = 0, c&^3|tbStop
+			}
+			H1[j] = h
+			tb[t+j] = c
+
+			if local && h > bestScore {
+				bestScore, bestI, bestJ = h, i, j
+			}
+		}
+		if freeEnds && !local && (H1[m] > bestScore || (H1[m] == bestScore && i == n)) {
+			bestScore, bestI, bestJ = H1[m], i, m
+		}
+		H0, H1 = H1, H0
+	}
+
+	// H0 is the last row now
+	switch {
+	case !freeEnds:
+		bestScore, bestI, bestJ = H0[m], n, m
+	case !local:
+		for j := 0; j <= m; j++ {
+			if H0[j] > bestScore {
+				bestScore, bestI, bestJ = H0[j], n, j
+			}
+		}
+	}
+
+	return x.traceback(a, b, tb, w, bestScore, bestI, bestJ)
+}
+
+func (x *pairAligner) traceback(a, b, tb []byte, w, score, i, j int) *pairAlignment {
+	aln := &pairAlignment{score: score, rEnd: i, qEnd: j}
+	ops := make([]byte, 0, i+j)
+	refAln := make([]byte, 0, i+j)
+	qryAln := make([]byte, 0, i+j)
+
+	state := byte(0) // 0: H, tbIns: E, tbDel: F
+	var c byte
+	for i > 0 || j > 0 {
+		c = tb[i*w+j]
+		if state == 0 {
+			state = c & 3
+			if state == tbStop {
+				break
+			}
+			if state == tbDiag {
+				if a[i-1] == b[j-1] {
+					aln.matches++
+				}
+				ops = append(ops, 'M')
+				refAln = append(refAln, a[i-1])
+				qryAln = append(qryAln, b[j-1])
+				i--
+				j--
+				state = 0
+				continue
+			}
+		}
+		if state == tbIns {
+			ops = append(ops, 'I')
+			refAln = append(refAln, '-')
+			qryAln = append(qryAln, b[j-1])
+			j--
+			if c&tbExtE == 0 {
+				state = 0
+			}
+			continue
+		}
+		// tbDel
+		ops = append(ops, 'D')
+		refAln = append(refAln, a[i-1])
+		qryAln = append(qryAln, '-')
+		i--
+		if c&tbExtF == 0 {
+			state = 0
+		}
+	}
+	aln.rStart, aln.qStart = i, j
+	aln.alnLen = len(ops)
+
+	ops = reverseBytes(ops)
+	aln.refAln, aln.qryAln = reverseBytes(refAln), reverseBytes(qryAln)
+
+	// CIGAR with soft-clipped ends of the query
+	var buf strings.Builder
+	if aln.qStart > 0 {
+		buf.WriteString(strconv.Itoa(aln.qStart))
+		buf.WriteByte('S')
+	}
+	for k := 0; k < len(ops); {
+		l := k + 1
+		for l < len(ops) && ops[l] == ops[k] {
+			l++
+		}
+		buf.WriteString(strconv.Itoa(l - k))
+		buf.WriteByte(ops[k])
+		k = l
+	}
+	if aln.qEnd < len(b) {
+		buf.WriteString(strconv.Itoa(len(b) - aln.qEnd))
+		buf.WriteByte('S')
+	}
+	aln.cigar = buf.String()
+	return aln
+}
+
+// pretty formats the alignment in blocks of the given width.
+func (a *pairAlignment) pretty(qName, rName []byte, width int) string {
+	var buf strings.Builder
+	buf.WriteString(fmt.Sprintf("# %s vs %s, score: %d, identity: %.2f%%, CIGAR: %s\n",
+		qName, rName, a.score, a.identity()*100, a.cigar))
+	nameLen := len(qName)
+	if len(rName) > nameLen {
+		nameLen = len(rName)
+	}
+	r, q := a.rStart, a.qStart
+	var r1, q1 int
+	var mid []byte
+	for k := 0; k < len(a.refAln); k += width {
+		e := k + width
+		if e > len(a.refAln) {
+			e = len(a.refAln)
+		}
+		r1, q1 = r, q
+		mid = mid[:0]
+		for l := k; l < e; l++ {
+			if a.refAln[l] != '-' {
+				r1++
+			}
+			if a.qryAln[l] != '-' {
+				q1++
+			}
+			switch {
+			case a.refAln[l] == a.qryAln[l]:
+				mid = append(mid, '|')
+			case a.refAln[l] == '-' || a.qryAln[l] == '-':
+				mid = append(mid, ' ')
+			default:
+				mid = append(mid, '.')
+			}
+		}
+		buf.WriteString(fmt.Sprintf("%-*s %9d %s %d\n", nameLen, rName, r+1, a.refAln[k:e], r1))
+		buf.WriteString(fmt.Sprintf("%-*s %9s %s\n", nameLen, "", "", mid))
+		buf.WriteString(fmt.Sprintf("%-*s %9d %s %d\n\n", nameLen, qName, q+1, a.qryAln[k:e], q1))
+		r, q = r1, q1
+	}
+	return buf.String()
+}
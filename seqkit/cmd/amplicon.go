@@ -51,9 +51,17 @@ var ampliconCmd = &cobra.Command{
 Attentions:
   1. Only one (the longest) matching location is returned for every primer pair.
   2. Mismatch is allowed, but the mismatch location (5' or 3') is not controled. 
-  3. Degenerate bases/residues like "RYMM.." are also supported.
+  3. Degenerate bases/residues like "RYMM.." are also supported, also along
+     with mismatches.
      But do not use degenerate bases/residues in regular expression, you need
      convert them to regular expression, e.g., change "N" or "X"  to ".".
+  4. Primer file (-p/--primer-file) could be in 3- or 2-column tab-delimited
+     format (name, forward primer, and optional reverse primer), or FASTA
+     format, where primers with names ending with "_F", "_FW", "_FWD",
+     "_LEFT" and "_R", "_RV", "_REV", "_RIGHT" (case-insensitive, also
+     separated by "-" or ".") are paired by the remaining names.
+  5. Hit numbers of every primer pair on both strands can be saved with
+     -S/--stats-file.
 
 Examples:
   0. no region given.
@@ -130,6 +138,7 @@ Examples:
 		strict := getFlagBool(cmd, "strict-mode")
 		onlyPositiveStrand := getFlagBool(cmd, "only-positive-strand")
 		outFmtBED := getFlagBool(cmd, "bed")
+		statsFile := getFlagString(cmd, "stats-file")
 
 		var list [][3]string
 		var primers [][3][]byte
@@ -190,6 +199,9 @@ Examples:
 		var strand string
 		var tmpSeq *seq.Seq
 		var primer [3][]byte
+		var i int
+		hitsPlus := make([]int, len(primers))
+		hitsMinus := make([]int, len(primers))
 
 		for _, file := range files {
 			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
@@ -217,7 +229,7 @@ Examples:
 						record.Seq.RevComInplace()
 					}
 
-					for _, primer = range primers {
+					for i, primer = range primers {
 						finder, err = NewAmpliconFinder(record.Seq.Seq, primer[1], primer[2], maxMismatch)
 						checkError(err)
 
@@ -232,6 +244,12 @@ Examples:
 							continue
 						}
 
+						if strand == "+" {
+							hitsPlus[i]++
+						} else {
+							hitsMinus[i]++
+						}
+
 						if outFmtBED {
 							outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\t%s\n",
 								record.ID,
@@ -256,6 +274,17 @@ Examples:
 
 			config.LineWidth = lineWidth
 		}
+
+		if statsFile != "" {
+			statsfh, err := xopen.Wopen(statsFile)
+			checkError(err)
+			defer statsfh.Close()
+
+			statsfh.WriteString("primer\tplus\tminus\ttotal\n")
+			for i, primer = range primers {
+				statsfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\n", primer[0], hitsPlus[i], hitsMinus[i], hitsPlus[i]+hitsMinus[i]))
+			}
+		}
 	},
 }
 
@@ -264,14 +293,15 @@ func init() {
 
 	ampliconCmd.Flags().StringP("forward", "F", "", "forward primer (5'-primer-3'), degenerate bases allowed")
 	ampliconCmd.Flags().StringP("reverse", "R", "", "reverse primer (5'-primer-3'), degenerate bases allowed")
-	ampliconCmd.Flags().IntP("max-mismatch", "m", 0, "max mismatch when matching primers, no degenerate bases allowed")
-	ampliconCmd.Flags().StringP("primer-file", "p", "", "3- or 2-column tabular primer file, with first column as primer name")
+	ampliconCmd.Flags().IntP("max-mismatch", "m", 0, "max mismatch when matching primers")
+	ampliconCmd.Flags().StringP("primer-file", "p", "", "3- or 2-column tabular primer file, with first column as primer name, or FASTA file of primers")
 
 	ampliconCmd.Flags().StringP("region", "r", "", `specify region to return. type "seqkit amplicon -h" for detail`)
 	ampliconCmd.Flags().BoolP("flanking-region", "f", false, "region is flanking region")
 	ampliconCmd.Flags().BoolP("strict-mode", "s", false, "strict mode, i.e., discarding seqs not fully matching (shorter) given region range")
 	ampliconCmd.Flags().BoolP("only-positive-strand", "P", false, "only search on positive strand")
 	ampliconCmd.Flags().BoolP("bed", "", false, "output in BED6+1 format with amplicon as 7th columns")
+	ampliconCmd.Flags().StringP("stats-file", "S", "", "save hit numbers of every primer pair on both strands to this file")
 }
 
 func loadPrimers(file string) ([][3]string, error) {
@@ -289,6 +319,10 @@ func loadPrimers(file string) ([][3]string, error) {
 		if text == "" || text[0] == '#' {
 			continue
 		}
+		if text[0] == '>' {
+			fh.Close()
+			return loadPrimersFromFasta(file)
+		}
 
 		items = strings.Split(text, "\t")
 		switch len(items) {
@@ -307,6 +341,56 @@ func loadPrimers(file string) ([][3]string, error) {
 	return lists, nil
 }
 
+var rePrimerSuffix = regexp.MustCompile(`(?i)^(.+)[_\-\.](F|FW|FWD|FORWARD|LEFT|R|RV|REV|REVERSE|RIGHT)$`)
+
+// loadPrimersFromFasta loads primers from a FASTA file, forward and reverse
+// primers are paired by names without suffixes like "_F" and "_R".
+func loadPrimersFromFasta(file string) ([][3]string, error) {
+	fastxReader, err := fastx.NewReader(nil, file, "")
+	if err != nil {
+		return nil, fmt.Errorf("load primers from '%s': %s", file, err)
+	}
+
+	lists := make([][3]string, 0, 100)
+	idx := make(map[string]int, 100)
+	var record *fastx.Record
+	var name string
+	var col, i int
+	var ok bool
+	for {
+		record, err = fastxReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("load primers from '%s': %s", file, err)
+		}
+
+		name, col = string(record.ID), 1
+		if m := rePrimerSuffix.FindStringSubmatch(name); m != nil {
+			name = m[1]
+			switch strings.ToUpper(m[2]) {
+			case "R", "RV", "REV", "REVERSE", "RIGHT":
+				col = 2
+			}
+		}
+
+		if i, ok = idx[name]; !ok || lists[i][col] != "" {
+			idx[name] = len(lists)
+			lists = append(lists, [3]string{name, "", ""})
+			i = len(lists) - 1
+		}
+		lists[i][col] = string(record.Seq.Seq)
+	}
+
+	for _, items := range lists {
+		if items[1] == "" {
+			return nil, fmt.Errorf("load primers from '%s': forward primer missing for: %s", file, items[0])
+		}
+	}
+	return lists, nil
+}
+
 func parsePrimers(primers [][3]string) ([][3][]byte, error) {
 	list := make([][3][]byte, 0, len(primers))
 
@@ -345,6 +429,7 @@ type AmpliconFinder struct {
 	iBegin, iEnd    int // 0-based
 
 	rF, rR *regexp.Regexp
+	mF, mR *approxMatcher // for degenerate primers with mismatches
 }
 
 // NewAmpliconFinder returns a AmpliconFinder struct.
@@ -367,19 +452,32 @@ func NewAmpliconFinder(sequence, forwardPrimer, reversePrimerRC []byte, maxMisma
 		R:   bytes.ToUpper(reversePrimerRC),
 	}
 
-	if maxMismatch > 0 { // using FM-index
-		index := fmi.NewFMIndex()
-		_, err := index.Transform(finder.Seq)
-		if err != nil {
-			return nil, err
-		}
+	if maxMismatch > 0 { // using FM-index, or scanning for degenerate primers
+		var err error
 		finder.MaxMismatch = maxMismatch
-		finder.FMindex = index
+		if seq.DNA.IsValid(finder.F) != nil {
+			finder.mF, err = newApproxMatcher(finder.F, maxMismatch, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(finder.R) > 0 && seq.DNA.IsValid(finder.R) != nil {
+			finder.mR, err = newApproxMatcher(finder.R, maxMismatch, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if finder.mF == nil || (len(finder.R) > 0 && finder.mR == nil) {
+			index := fmi.NewFMIndex()
+			_, err = index.Transform(finder.Seq)
+			if err != nil {
+				return nil, err
+			}
+			finder.FMindex = index
+		}
 	} else {
 		if seq.DNA.IsValid(finder.F) != nil { // containing degenerate base
-			if maxMismatch > 0 {
-				checkError(fmt.Errorf("it does not support both degenerate base and mismatch"))
-			}
 			s, _ := seq.NewSeq(seq.DNA, finder.F)
 			rF, err := regexp.Compile(s.Degenerate2Regexp())
 			if err != nil {
@@ -389,9 +487,6 @@ func NewAmpliconFinder(sequence, forwardPrimer, reversePrimerRC []byte, maxMisma
 		}
 
 		if seq.DNA.IsValid(finder.R) != nil { // containing degenerate base
-			if maxMismatch > 0 {
-				checkError(fmt.Errorf("it does not support both degenerate base and mismatch"))
-			}
 			s, _ := seq.NewSeq(seq.DNA, finder.R)
 			rR, err := regexp.Compile(s.Degenerate2Regexp())
 			if err != nil {
@@ -664,7 +759,7 @@ func (finder *AmpliconFinder) Locate() ([]int, error) {
 	}
 
 	// search F
-	locsI, err := finder.FMindex.Locate(finder.F, finder.MaxMismatch)
+	locsI, err := finder.locateAll(finder.F, finder.mF)
 	if err != nil {
 		return nil, err
 	}
@@ -680,7 +775,7 @@ func (finder *AmpliconFinder) Locate() ([]int, error) {
 	}
 
 	// search R
-	locsJ, err := finder.FMindex.Locate(finder.R, finder.MaxMismatch)
+	locsJ, err := finder.locateAll(finder.R, finder.mR)
 	if err != nil {
 		return nil, err
 	}
@@ -690,9 +785,28 @@ func (finder *AmpliconFinder) Locate() ([]int, error) {
 	}
 	sort.Ints(locsI) // to remain the FIRST location
 	sort.Ints(locsJ) // to remain the LAST location
+	i, j := locsI[0], locsJ[len(locsJ)-1]
+	if j < i { // wrong location of F and R:  5' ---R-----F---- 3'
+		finder.searched, finder.found = true, false
+		return nil, nil
+	}
 	finder.searched, finder.found = true, true
-	finder.iBegin, finder.iEnd = locsI[0], locsI[0]+len(finder.R)-1
-	return []int{locsI[0] + 1, locsJ[len(locsJ)-1] + len(finder.R)}, nil
+	finder.iBegin, finder.iEnd = i, j+len(finder.R)-1
+	return []int{i + 1, j + len(finder.R)}, nil
+}
+
+// locateAll returns 0-based locations of a primer with mismatches,
+// using the approximate matcher if given, or the FM-index.
+func (finder *AmpliconFinder) locateAll(p []byte, m *approxMatcher) ([]int, error) {
+	if m == nil {
+		return finder.FMindex.Locate(p, finder.MaxMismatch)
+	}
+	hits := m.findAll(finder.Seq)
+	locs := make([]int, len(hits))
+	for i, h := range hits {
+		locs[i] = h.start
+	}
+	return locs, nil
 }
 
 // Location returns location of amplicon.
@@ -0,0 +1,233 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+)
+
+// iupacMasks maps nucleotides and IUPAC codes (case-insensitive) to the
+// sets of bases (A: 1, C: 2, G: 4, T/U: 8) they stand for.
+var iupacMasks [256]byte
+
+func init() {
+	for _, m := range []struct {
+		codes string
+		mask  byte
+	}{
+		{"A", 1}, {"C", 2}, {"G", 4}, {"TU", 8},
+		{"R", 1 | 4}, {"Y", 2 | 8}, {"S", 2 | 4}, {"W", 1 | 8}, {"K", 4 | 8}, {"M", 1 | 2},
+		{"B", 2 | 4 | 8}, {"D", 1 | 4 | 8}, {"H", 1 | 2 | 8}, {"V", 1 | 2 | 4},
+		{"N", 1 | 2 | 4 | 8},
+	} {
+		for _, c := range []byte(m.codes) {
+			iupacMasks[c] = m.mask
+			iupacMasks[c+'a'-'A'] = m.mask
+		}
+	}
+}
+
+// iupacMatch tells whether a base of the target matches a base of the
+// pattern, i.e., all bases the target base could be are allowed by the
+// pattern base. So "N" in patterns matches any base, while "N" in targets
+// only matches "N" in patterns.
+func iupacMatch(p, t byte) bool {
+	return iupacMasks[t] != 0 && iupacMasks[t]&^iupacMasks[p] == 0
+}
+
+// approxHit is an approximate occurrence of a pattern, with 0-based
+// half-open coordinates and the number of edits.
+type approxHit struct {
+	start, end int
+	edits      int
+}
+
+// approxMatcher finds approximate occurrences of a short nucleotide pattern
+// with IUPAC codes, allowing up to k mismatches, or k edits (mismatches,
+// insertions and deletions) using Myers' bit-parallel algorithm.
+type approxMatcher struct {
+	pattern []byte
+	k       int
+	indel   bool
+	peq     [256]uint64
+}
+
+func newApproxMatcher(pattern []byte, k int, indel bool) (*approxMatcher, error) {
+	if len(pattern) == 0 {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if indel && len(pattern) > 64 {
+		return nil, fmt.Errorf("pattern longer than 64 bp not supported when allowing indels: %s", pattern)
+	}
+	for _, c := range pattern {
+		if iupacMasks[c] == 0 {
+			return nil, fmt.Errorf("invalid base '%c' in pattern: %s", c, pattern)
+		}
+	}
+	m := &approxMatcher{pattern: pattern, k: k, indel: indel}
+	if indel {
+		for c := 0; c < 256; c++ {
+			for i, p := range pattern {
+				if iupacMatch(p, byte(c)) {
+					m.peq[c] |= 1 << uint(i)
+				}
+			}
+		}
+	}
+	return m, nil
+}
+
+// match tells whether the pattern occurs in the text.
+func (m *approxMatcher) match(text []byte) bool {
+	if m.indel {
+		return len(m.myers(text, true)) > 0
+	}
+	return len(m.mismatches(text, true)) > 0
+}
+
+// findAll returns all occurrences of the pattern in the text. With indels,
+// only the best hit of every run of overlapping end positions is reported.
+func (m *approxMatcher) findAll(text []byte) []approxHit {
+	if m.indel {
+		return m.myers(text, false)
+	}
+	return m.mismatches(text, false)
+}
+
+func (m *approxMatcher) mismatches(text []byte, first bool) []approxHit {
+	var hits []approxHit
+	n := len(m.pattern)
+	var i, j, d int
+	for i = 0; i+n <= len(text); i++ {
+		d = 0
+		for j = 0; j < n; j++ {
+			if !iupacMatch(m.pattern[j], text[i+j]) {
+				d++
+				if d > m.k {
+					break
+				}
+			}
+		}
+		if d <= m.k {
+			hits = append(hits, approxHit{start: i, end: i + n, edits: d})
+			if first {
+				return hits
+			}
+		}
+	}
+	return hits
+}
+
+func (m *approxMatcher) myers(text []byte, first bool) []approxHit {
+	var hits []approxHit
+	n := len(m.pattern)
+	high := uint64(1) << uint(n-1)
+	pv, mv := ^uint64(0), uint64(0)
+	score := n
+	var eq, xv, xh, ph, mh uint64
+
+	bestEnd, bestScore := -1, 0
+	report := func() {
+		if bestEnd < 0 {
+			return
+		}
+		hits = append(hits, approxHit{start: m.hitStart(text, bestEnd, bestScore), end: bestEnd, edits: bestScore})
+		bestEnd = -1
+	}
+	for j, c := range text {
+		eq = m.peq[c]
+		xv = eq | mv
+		xh = (((eq & pv) + pv) ^ pv) | eq
+		ph = mv | ^(xh | pv)
+		mh = pv & xh
+		if ph&high != 0 {
+			score++
+		} else if mh&high != 0 {
+			score--
+		}
+		ph <<= 1
+		mh <<= 1
+		pv = mh | ^(xv | ph)
+		mv = ph & xv
+
+		if score <= m.k {
+			if first {
+				return []approxHit{{start: m.hitStart(text, j+1, score), end: j + 1, edits: score}}
+			}
+			if bestEnd < 0 || score < bestScore {
+				bestEnd, bestScore = j+1, score
+			}
+		} else {
+			report()
+		}
+	}
+	report()
+	return hits
+}
+
+// hitStart finds the start of a hit ending at end with the given number of
+// edits, by aligning the reversed pattern to the text backwards from end.
+func (m *approxMatcher) hitStart(text []byte, end int, edits int) int {
+	n := len(m.pattern)
+	w := n + m.k
+	if w > end {
+		w = end
+	}
+	// d[i]: edits of the last i bases of the pattern aligned to the last j
+	// bases of text[:end]
+	prev, cur := make([]int, n+1), make([]int, n+1)
+	for i := range prev {
+		prev[i] = i
+	}
+	best, bestJ := prev[n], 0
+	for j := 1; j <= w; j++ {
+		cur[0] = j
+		t := text[end-j]
+		for i := 1; i <= n; i++ {
+			cost := 1
+			if iupacMatch(m.pattern[n-i], t) {
+				cost = 0
+			}
+			cur[i] = prev[i-1] + cost
+			if v := prev[i] + 1; v < cur[i] {
+				cur[i] = v
+			}
+			if v := cur[i-1] + 1; v < cur[i] {
+				cur[i] = v
+			}
+		}
+		if cur[n] < best || (cur[n] == best && abs(j-n) < abs(bestJ-n)) {
+			best, bestJ = cur[n], j
+		}
+		prev, cur = cur, prev
+	}
+	if best > edits {
+		bestJ = n
+	}
+	return end - bestJ
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/biogo/hts/sam"
 	"github.com/bsipos/thist"
 	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/seqkit/seqkit/pkg/bamtool"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -535,6 +536,13 @@ var bamCmd = &cobra.Command{
 		toolYaml := getFlagString(cmd, "tool")
 		includeIdList := getFlagString(cmd, "grep-ids")
 		excludeIdList := getFlagString(cmd, "exclude-ids")
+		countOnly := getFlagBool(cmd, "count-only")
+		readerThreads := getFlagInt(cmd, "reader-threads")
+		writerThreads := getFlagInt(cmd, "writer-threads")
+		compressLevel := getFlagInt(cmd, "compress-level")
+		if compressLevel < -1 || compressLevel > 9 {
+			checkError(fmt.Errorf("value of flag --compress-level should be in range of [-1, 9]"))
+		}
 
 		var includeIds map[string]bool
 		var excludeIds map[string]bool
@@ -570,7 +578,14 @@ var bamCmd = &cobra.Command{
 			if len(files) != 1 {
 				log.Fatal("The BAM toolbox takes exactly one input file!")
 			}
-			BamToolbox(toolYaml, files[0], outFile, printQuiet, silentMode, config.Threads)
+			threads := config.Threads
+			if !cmd.Flags().Changed("threads") && os.Getenv("SEQKIT_THREADS") == "" {
+				// the toolbox is mostly limited by BGZF (de)compression,
+				// so use all CPUs unless the number of threads was given
+				threads = runtime.NumCPU()
+				runtime.GOMAXPROCS(threads)
+			}
+			BamToolbox(toolYaml, files[0], outFile, printQuiet, silentMode, threads, readerThreads, writerThreads, compressLevel, countOnly)
 			os.Exit(0)
 		}
 
@@ -821,8 +836,15 @@ var bamCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
+		if countOnly && (printCount == "" || printPass) {
+			log.Fatal("--count-only can only be used with -c/--count (without -x/--pass) or -T/--tool!")
+		}
+
 		bamReader := NewBamReader(files[0], config.Threads)
 		bamHeader := bamReader.Header()
+		if countOnly {
+			bamReader.Omit(bam.AllVariableLengthData)
+		}
 
 		var bamWriter *bam.Writer
 		var topBuffer TopBuffer
@@ -1067,8 +1089,12 @@ func gtThanPerc(h *thist.Hist, percent float64) float64 {
 
 // NewBamReader creates a new BAM reader from file.
 func NewBamReader(bamFile string, nrProc int) *bam.Reader {
-	fh, err := os.Stdin, error(nil)
-	if bamFile != "-" {
+	var fh io.ReadCloser = os.Stdin
+	var err error
+	if bamtool.IsRemote(bamFile) {
+		fh, err = bamtool.OpenRemote(bamFile)
+		checkError(err)
+	} else if bamFile != "-" {
 		fh, err = os.Open(bamFile)
 		checkError(err)
 	}
@@ -1272,5 +1298,9 @@ func init() {
 	bamCmd.Flags().StringP("top-bam", "@", "", "save the top -? records to this bam file")
 	bamCmd.Flags().StringP("grep-ids", "g", "", "only keep records with IDs contained in this file")
 	bamCmd.Flags().StringP("exclude-ids", "G", "", "exclude records with IDs contained in this file")
+	bamCmd.Flags().Bool("count-only", false, "skip decoding sequences, qualities and tags (fast path for -c and -T)")
+	bamCmd.Flags().Int("reader-threads", 0, "number of BGZF decompression threads for the toolbox (default value is all threads for a sink, one third of them otherwise)")
+	bamCmd.Flags().Int("compress-level", -1, "BGZF compression level of the toolbox output (1 for fastest, 9 for smallest, -1 for default)")
+	bamCmd.Flags().Int("writer-threads", 0, "number of BGZF compression threads for the toolbox (default value is two thirds of the threads)")
 	bamCmd.Flags().IntP("top-size", "?", 100, "size of the top-mode buffer")
 }
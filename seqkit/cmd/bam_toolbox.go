@@ -22,441 +22,2751 @@ package cmd
 
 import (
 	"bufio"
+	"container/list"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
+	"os/exec"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fai"
 	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/seqkit/seqkit/pkg/bamtool"
+	"github.com/shenwei356/seqkit/seqkit/pkg/bigwig"
+	"github.com/shenwei356/seqkit/seqkit/pkg/samstats"
+	"github.com/shenwei356/xopen"
 	syaml "github.com/smallfish/simpleyaml"
+	lua "github.com/yuin/gopher-lua"
+	yaml "gopkg.in/yaml.v2"
 )
 
-type BamTool struct {
-	Name string
-	Desc string
-	Use  func(params *BamToolParams)
+func init() {
+	bamtool.ErrorHandler = checkError
+	tools := []bamtool.Tool{
+		{Name: "AlnContext", Desc: "filter records by the sequence context at start and end", Use: BamToolAlnContext,
+			Params: []string{"Tsv", "Ref", "LeftShift", "RightShift", "RegexStart", "RegexEnd", "StrandAware", "Invert", "OutOfRangeNoMatch"}},
+		{Name: "AccStats", Desc: "calculates mean accuracy weighted by aligment lengths", Use: BamToolAccStats,
+			Params: []string{"Tsv"}, NeedsVarData: true},
+		{Name: "Dedup", Desc: "remove duplicate records by read name or alignment signature", Use: BamToolDedup,
+			Params: []string{"By", "UmiTag"}},
+		{Name: "Dump", Desc: "dump various record properties in TSV format", Use: BamToolDump,
+			Params: []string{"Tsv", "Fields"}, NeedsVarData: true},
+		{Name: "EndMismatch", Desc: "count substitution types by distance from read ends", Use: BamToolEndMismatch,
+			Params: []string{"Tsv", "Ref", "Window"}, NeedsVarData: true},
+		{Name: "BaseQualityFilter", Desc: "filter records by mean base quality or qs tag and trim low quality ends", Use: BamToolBaseQualityFilter,
+			Params: []string{"MinMeanQual", "MinQs", "TrimQual", "Invert"}, NeedsVarData: true},
+		{Name: "Exec", Desc: "pipe records in SAM format through an external command", Use: BamToolExec,
+			Params: []string{"Cmd"}, NeedsVarData: true},
+		{Name: "Script", Desc: "filter and modify records using a Lua script", Use: BamToolScript,
+			Params: []string{"Code", "File"}, NeedsVarData: true},
+		{Name: "SizeGuard", Desc: "report the largest records and drop or strip records above a size limit", Use: BamToolSizeGuard,
+			Params: []string{"Tsv", "MaxSize", "Action", "Top"}, NeedsVarData: true},
+		{Name: "MergeMates", Desc: "apply tools to read pairs consistently, dropping all records of a read if any fails", Use: BamToolMergeMates,
+			Params: []string{"Tools"}, NeedsVarData: true},
+		{Name: "StratifiedSample", Desc: "subsample records to a total count preserving or rebalancing per-reference proportions", Use: BamToolStratifiedSample,
+			Params: []string{"Total", "Mode", "Weights", "Seed", "Tsv"}},
+		{Name: "ToPaf", Desc: "convert alignment records to PAF lines", Use: BamToolToPaf,
+			Params: []string{"Tsv", "Cigar", "Cs", "Ref"}, NeedsVarData: true},
+		{Name: "Composition", Desc: "per-read GC content, homopolymer fraction and base composition", Use: BamToolComposition,
+			Params: []string{"Tsv", "MinHomopolymer"}, NeedsVarData: true},
+		{Name: "ToBed", Desc: "write BED intervals of primary alignments", Use: BamToolToBed,
+			Params: []string{"Tsv", "Split", "Bed12"}},
+		{Name: "OnTarget", Desc: "filter records overlapping features of a GTF, GFF or BED annotation", Use: BamToolOnTarget,
+			Params: []string{"Annotation", "Types", "MinOverlap", "Invert"}},
+		{Name: "SpliceStats", Desc: "intron counts, lengths and canonical splice site fraction per read and in aggregate", Use: BamToolSpliceStats,
+			Params: []string{"Tsv", "Ref", "Summary"}},
+		{Name: "ToBigWig", Desc: "write per-base or windowed coverage of the records in bigWig format", Use: BamToolToBigWig,
+			Params: []string{"File", "Window", "MinMapQ"}},
+		{Name: "TripletSpectrum", Desc: "96-category trinucleotide substitution spectrum of the mismatches", Use: BamToolTripletSpectrum,
+			Params: []string{"Tsv", "Ref", "MinBaseQual"}, NeedsVarData: true},
+		{Name: "QualCalibration", Desc: "per-read expected accuracy from base qualities versus observed accuracy, summarized by quality decile", Use: BamToolQualCalibration,
+			Params: []string{"Tsv", "Summary"}, NeedsVarData: true},
+		{Name: "QualBin", Desc: "bin base qualities into a few levels to reduce the output size", Use: BamToolQualBin,
+			Params: []string{"Levels", "Edges", "Values"}, NeedsVarData: true},
+		{Name: "Consensus", Desc: "majority consensus of the references masking positions with low depth or strand bias", Use: BamToolConsensus,
+			Params: []string{"Fasta", "Bed", "MinDepth", "MaxStrandBias", "MinBaseQual", "MinMapQ", "Ref", "MaskN", "LineWidth"}, NeedsVarData: true},
+		{Name: "StripSeq", Desc: "replace SEQ and QUAL by * in secondary and supplementary or all records", Use: BamToolStripSeq,
+			Params: []string{"Records"}},
+		{Name: "MultiQC", Desc: "aggregate QC stats and a pass/fail verdict in MultiQC custom content JSON", Use: BamToolMultiQC,
+			Params: []string{"Json", "Sample", "MinAcc", "MinMappedFrac", "MinYield"}, NeedsVarData: true},
+		{Name: "Rate", Desc: "report records/s, bases/s and mean accuracy over a sliding window in regular intervals", Use: BamToolRate,
+			Params: []string{"Tsv", "Interval", "Window"}, NeedsVarData: true},
+		{Name: "ToFastx", Desc: "write the reads of the records in FASTA or FASTQ format", Use: BamToolToFastx,
+			Params: []string{"File", "Format", "Orig", "Primary", "Tags"}, NeedsVarData: true},
+		{Name: "help", Desc: "list all tools with description", Use: ListTools},
+	}
+	for _, t := range tools {
+		bamtool.Register(t)
+	}
+}
+
+// BamToolbox runs a toolbox pipeline. The BGZF decompression and compression
+// threads are set by readerThreads and writerThreads, or by the ReaderThreads
+// and WriterThreads fields of the config, and default to a split of threads
+// (see splitIOThreads). The BGZF
+// compression level of the output is set by compressLevel or the CompressLevel
+// field, -1 selecting the default level.
+func BamToolbox(toolYaml string, inFile string, outFile string, quiet bool, silent bool, threads int, readerThreads int, writerThreads int, compressLevel int, countOnly bool) {
+	if toolYaml == "help" {
+		toolYaml = "help: true"
+	}
+	ty, err := bamtool.OrderedMapKeys([]byte(toolYaml))
+	checkError(err)
+	confBytes := []byte(toolYaml)
+	if len(ty) > 0 && ty[0] == "Yaml" {
+		y, err := syaml.NewYaml(confBytes)
+		checkError(err)
+		conf, err := y.Get("Yaml").String()
+		checkError(err)
+		confBytes, err = ioutil.ReadFile(conf)
+		checkError(err)
+	}
+	shed := bamtool.NewToolshed()
+	y, tools, err := bamtool.LoadConfig(confBytes, shed)
+	checkError(err)
+	if len(tools) == 0 {
+		log.Fatal("toolbox: not tool specified!")
+	}
+
+	chanCap := 5000
+	ioBuff := 1024 * 128
+
+	sinkConf := y.Get("Sink")
+	sink, err := sinkConf.Bool()
+	if err != nil && sinkConf.IsFound() {
+		log.Fatal("toolbox: Sink must be True or False!")
+	}
+	defReaderThreads, defWriterThreads := splitIOThreads(threads, sink)
+	if readerThreads <= 0 {
+		readerThreads, err = y.Get("ReaderThreads").Int()
+		if err != nil || readerThreads <= 0 {
+			readerThreads = defReaderThreads
+		}
+	}
+	if writerThreads <= 0 {
+		writerThreads, err = y.Get("WriterThreads").Int()
+		if err != nil || writerThreads <= 0 {
+			writerThreads = defWriterThreads
+		}
+	}
+	if compressLevel < 0 {
+		compressLevel, err = y.Get("CompressLevel").Int()
+		if err != nil {
+			compressLevel = -1
+		}
+	}
+
+	var inChan, lastOut chan *sam.Record
+	var doneChan chan bool
+	var header *sam.Header
+	if tools[0] != "help" {
+		omit := bam.None
+		if countOnly {
+			if !sink {
+				log.Fatal("toolbox: --count-only requires Sink: True as the output records are incomplete!")
+			}
+			for _, t := range tools {
+				if shed[t].NeedsVarData {
+					log.Fatalf("toolbox: --count-only can not be used with %s, which needs the sequences, qualities or tags of the records!", t)
+				}
+			}
+			omit = bam.AllVariableLengthData
+		}
+		var bamReader *bam.Reader
+		inChan, bamReader, err = bamtool.NewReaderChan(inFile, chanCap, ioBuff, readerThreads, omit)
+		checkError(err)
+		header = bamReader.Header()
+		if sink {
+			lastOut, doneChan = bamtool.NewSinkChan(chanCap)
+		} else if sortBy, err := y.Get("SortOutput").String(); err == nil {
+			sortChunk, _ := y.Get("SortChunk").Int()
+			lastOut, doneChan, err = bamtool.NewSortingWriterChan(outFile, header, chanCap, ioBuff, writerThreads, compressLevel, sortBy, sortChunk)
+			checkError(err)
+		} else {
+			lastOut, doneChan, err = bamtool.NewWriterChan(outFile, header, chanCap, ioBuff, writerThreads, compressLevel)
+			checkError(err)
+		}
+	}
+	opts := bamtool.Options{Header: header, Quiet: quiet, Silent: silent, Threads: threads, ChanCap: chanCap, CountOnly: countOnly, Sink: sink}
+	checkError(shed.Pipeline(y, tools, inChan, lastOut, opts))
+	<-doneChan
+}
+
+// splitIOThreads divides the threads between BGZF decompression and
+// compression. Compression is several times slower than decompression, so
+// the writer gets two thirds of the threads, while a sink leaves all of them
+// to the reader.
+func splitIOThreads(threads int, sink bool) (int, int) {
+	if threads < 1 {
+		threads = 1
+	}
+	if sink {
+		return threads, 1
+	}
+	reader := threads / 3
+	if reader < 1 {
+		reader = 1
+	}
+	writer := threads - reader
+	if writer < 1 {
+		writer = 1
+	}
+	return reader, writer
+}
+
+func ListTools(p *bamtool.Params) {
+	os.Stderr.WriteString(p.Shed.String())
+	os.Exit(0)
+}
+
+func BamToolAlnContext(p *bamtool.Params) {
+	ref, err := p.Yaml.Get("Ref").String()
+	checkError(err)
+	idx := NewRefWitdFaidx(ref, true, p.Silent)
+	leftShift, err := p.Yaml.Get("LeftShift").Int()
+	checkError(err)
+	rightShift, err := p.Yaml.Get("RightShift").Int()
+	checkError(err)
+	var regStart *regexp.Regexp
+	var regEnd *regexp.Regexp
+	checkError(err)
+	regStrStart, err := p.Yaml.Get("RegexStart").String()
+	if err == nil {
+		regStart = regexp.MustCompile(regStrStart)
+	}
+	regStrEnd, err := p.Yaml.Get("RegexEnd").String()
+	if err == nil {
+		regEnd = regexp.MustCompile(regStrEnd)
+	}
+	stranded, _ := p.Yaml.Get("StrandAware").Bool()
+	invert, _ := p.Yaml.Get("Invert").Bool()
+	outOfRangeNoMatch, _ := p.Yaml.Get("OutOfRangeNoMatch").Bool()
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+	bw := bufio.NewWriter(tsvFh)
+	bw.WriteString("Read\tRef\tStrand\tEnd\tSeq\tMatch\tKept\n")
+
+	for r := range p.InChan {
+		chrom := r.Ref.Name()
+		startPos, endPos := r.Pos, r.End()
+		startSeq, startClamped, err := idx.ClampedSubSeq(chrom, startPos+leftShift, startPos+rightShift)
+		checkError(err)
+		endSeq, endClamped, err := idx.ClampedSubSeq(chrom, endPos+leftShift, endPos+rightShift)
+		checkError(err)
+		strand := 1
+		if GetSamReverse(r) {
+			strand = -1
+			if stranded {
+				startSeq, endSeq = RevCompDNA(endSeq), RevCompDNA(startSeq)
+			}
+		}
+
+		if GetSamReverse(r) && stranded {
+			startClamped, endClamped = endClamped, startClamped
+		}
+
+		startMatch := regStart != nil && regStart.MatchString(startSeq) && !(outOfRangeNoMatch && startClamped)
+		endMatch := regEnd != nil && regEnd.MatchString(endSeq) && !(outOfRangeNoMatch && endClamped)
+		kept := (startMatch || endMatch) != invert
+		keptFlag := 0
+		if kept {
+			keptFlag = 1
+		}
+
+		// one line per end, so the records filtered out can be audited too
+		for _, end := range []struct {
+			name  string
+			seq   string
+			reg   *regexp.Regexp
+			match bool
+		}{{"start", startSeq, regStart, startMatch}, {"end", endSeq, regEnd, endMatch}} {
+			matched := "-"
+			if end.match {
+				matched = end.reg.String()
+			}
+			bw.WriteString(fmt.Sprintf("%s\t%s\t%d\t%s\t%s\t%s\t%d\n", GetSamName(r), GetSamRef(r), strand, end.name, end.seq, matched, keptFlag))
+		}
+
+		if kept {
+			p.OutChan <- r
+		}
+	}
+	checkError(bw.Flush())
+	close(p.OutChan)
+	if tsvFh != os.Stderr {
+		tsvFh.Close()
+	}
+}
+
+// DefaultRefCacheSize is the number of bases of whole reference sequences
+// kept in memory by a RefWithFaidx with Cache set.
+var DefaultRefCacheSize = 1 << 28
+
+// RefWithFaidx gives random access to an indexed reference. With Cache set,
+// whole reference sequences are loaded on first access and the least recently
+// used ones are dropped when CacheSize bases are exceeded, which suits the
+// many small lookups of sorted alignments. It is not safe for concurrent use.
+type RefWithFaidx struct {
+	Fasta     string
+	IdxFile   string
+	idx       fai.Index
+	faidx     refSubSeqer
+	Cache     bool
+	CacheSize int
+	lengths   map[string]int
+	cached    map[string]*list.Element
+	lru       *list.List // of *cachedChrom, the most recently used first
+	cacheLen  int
+}
+
+// refSubSeqer is implemented by fai.Faidx and bgzfFaidx.
+type refSubSeqer interface {
+	SubSeq(chrom string, start, end int) ([]byte, error)
+}
+
+type cachedChrom struct {
+	name string
+	seq  []byte
+}
+
+func (idx *RefWithFaidx) IdxSubSeq(chrom string, start, end int) (string, error) {
+	if !idx.Cache {
+		b, err := idx.faidx.SubSeq(chrom, start, end)
+		return string(b), err
+	}
+	s, err := idx.cachedSeq(chrom)
+	if err != nil {
+		return "", err
+	}
+	start, end, ok := seq.SubLocation(len(s), start, end)
+	if !ok {
+		return "", fmt.Errorf("invalid region %s:%d-%d", chrom, start, end)
+	}
+	return string(s[start-1 : end]), nil
+}
+
+// cachedSeq returns a whole reference sequence from the cache, loading it
+// if needed.
+func (idx *RefWithFaidx) cachedSeq(chrom string) ([]byte, error) {
+	if e, ok := idx.cached[chrom]; ok {
+		idx.lru.MoveToFront(e)
+		return e.Value.(*cachedChrom).seq, nil
+	}
+	l, ok := idx.lengths[chrom]
+	if !ok {
+		return nil, fmt.Errorf("sequence not found in %s: %s", idx.Fasta, chrom)
+	}
+	s, err := idx.faidx.SubSeq(chrom, 1, l)
+	if err != nil {
+		return nil, err
+	}
+	// keep at least the new sequence
+	for idx.lru.Len() > 0 && idx.cacheLen+len(s) > idx.CacheSize {
+		e := idx.lru.Back()
+		c := idx.lru.Remove(e).(*cachedChrom)
+		delete(idx.cached, c.name)
+		idx.cacheLen -= len(c.seq)
+	}
+	idx.cached[chrom] = idx.lru.PushFront(&cachedChrom{name: chrom, seq: s})
+	idx.cacheLen += len(s)
+	return s, nil
+}
+
+// ChromLen returns the length of a reference sequence.
+func (idx *RefWithFaidx) ChromLen(chrom string) (int, bool) {
+	l, ok := idx.lengths[chrom]
+	return l, ok
+}
+
+// ClampedSubSeq is IdxSubSeq with the 1-based, inclusive coordinates clamped
+// to the reference sequence, the returned flag tells if they were changed.
+// An interval completely outside the reference yields an empty sequence.
+func (idx *RefWithFaidx) ClampedSubSeq(chrom string, start, end int) (string, bool, error) {
+	l, ok := idx.ChromLen(chrom)
+	if !ok {
+		return "", false, fmt.Errorf("sequence not found in %s: %s", idx.Fasta, chrom)
+	}
+	clamped := false
+	if start < 1 {
+		start, clamped = 1, true
+	}
+	if end > l {
+		end, clamped = l, true
+	}
+	if start > end {
+		return "", true, nil
+	}
+	s, err := idx.IdxSubSeq(chrom, start, end)
+	return s, clamped, err
+}
+
+func NewRefWitdFaidx(file string, cache bool, quiet bool) *RefWithFaidx {
+	i, err := newRefWithFaidx(file, file+".seqkit.fai", fastx.DefaultIDRegexp, cache, quiet)
+	checkError(err)
+	return i
+}
+
+// newRefWithFaidx opens a plain or bgzip-compressed FASTA file with the
+// FASTA index fileFai, which is created with idRegexp if not existing.
+func newRefWithFaidx(file string, fileFai string, idRegexp string, cache bool, quiet bool) (*RefWithFaidx, error) {
+	var idx fai.Index
+	var err error
+	var faidx refSubSeqer
+	if bgzf, _ := isBgzfFile(file); bgzf {
+		faidx, idx, err = newBgzfFaidx(file, fileFai, idRegexp, quiet)
+		if err != nil {
+			return nil, err
+		}
+	} else if fileNotExists(fileFai) {
+		if !quiet {
+			log.Infof("create FASTA index for %s", file)
+		}
+		idx, err = fai.CreateWithIDRegexp(file, fileFai, idRegexp)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		idx, err = fai.Read(fileFai)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if faidx == nil {
+		faidx, err = fai.NewWithIndex(file, idx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lengths := make(map[string]int, len(idx))
+	for chrom, rec := range idx {
+		lengths[chrom] = rec.Length
+	}
+
+	i := &RefWithFaidx{
+		Fasta:     file,
+		IdxFile:   fileFai,
+		idx:       idx,
+		faidx:     faidx,
+		Cache:     cache,
+		CacheSize: DefaultRefCacheSize,
+		lengths:   lengths,
+		cached:    make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+	return i, nil
+}
+
+func BamToolAccStats(p *bamtool.Params) {
+	totalLen := 0
+	accSum := 0.0
+	wAccSum := 0.0
+	nr := 0.0
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+	}
+	for r := range p.InChan {
+		if GetSamMapped(r) {
+			info := GetSamAlnDetails(r)
+			totalLen += info.Len
+			wAccSum += info.WAcc
+			accSum += info.Acc
+			nr++
+		}
+		p.OutChan <- r
+	}
+	WeightedAcc := wAccSum / float64(totalLen)
+	MeanAcc := accSum / nr
+	tsvFh.WriteString("AccMean\tWeightedAccMean\n")
+	tsvFh.WriteString(fmt.Sprintf("%.3f\t%.3f\n", MeanAcc, WeightedAcc))
+	close(p.OutChan)
+}
+
+func getYamlFloat(y *syaml.Yaml, key string, dflt float64) float64 {
+	f, err := y.Get(key).Float()
+	if err == nil {
+		return f
+	}
+	i, err := y.Get(key).Int()
+	if err == nil {
+		return float64(i)
+	}
+	return dflt
+}
+
+func BamToolBaseQualityFilter(p *bamtool.Params) {
+	minMeanQual := getYamlFloat(p.Yaml, "MinMeanQual", -1)
+	minQs := getYamlFloat(p.Yaml, "MinQs", -1)
+	trimQual := getYamlFloat(p.Yaml, "TrimQual", -1)
+	invert, _ := p.Yaml.Get("Invert").Bool()
+
+	for r := range p.InChan {
+		if trimQual > 0 && !GetSamQualTrim(r, byte(trimQual)) {
+			continue
+		}
+		pass := true
+		if minMeanQual >= 0 && GetSamMeanBaseQual(r) < minMeanQual {
+			pass = false
+		}
+		if minQs >= 0 {
+			qs, ok := GetSamTagNum(r, "qs")
+			if !ok || qs < minQs {
+				pass = false
+			}
+		}
+		if pass != invert {
+			p.OutChan <- r
+		}
+	}
+	close(p.OutChan)
+}
+
+// GetSamTagNum returns the value of a numeric auxiliary tag as float64.
+func GetSamTagNum(r *sam.Record, tag string) (float64, bool) {
+	aux, ok := r.Tag([]byte(tag))
+	if !ok {
+		return 0, false
+	}
+	switch v := aux.Value().(type) {
+	case int8:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// GetSamQualTrim trims bases with quality less than minQual from both ends of
+// a record, by hard clipping them in place. Returns false if no bases are left.
+func GetSamQualTrim(r *sam.Record, minQual byte) bool {
+	n := r.Seq.Length
+	if n == 0 || len(r.Qual) != n || r.Qual[0] == 0xff {
+		return true
+	}
+	left := 0
+	for left < n && r.Qual[left] < minQual {
+		left++
+	}
+	if left == n {
+		return false
+	}
+	right := 0
+	for r.Qual[n-1-right] < minQual {
+		right++
+	}
+	if left == 0 && right == 0 {
+		return true
+	}
+	if len(r.Cigar) > 0 {
+		var shift int
+		var cigar sam.Cigar
+		cigar, shift = hardClipCigar(r.Cigar, left)
+		if GetSamMapped(r) {
+			r.Pos += shift
+		}
+		cigar, _ = hardClipCigar(reverseCigar(cigar), right)
+		r.Cigar = reverseCigar(cigar)
+	}
+	s := r.Seq.Expand()[left : n-right]
+	r.Seq = sam.NewSeq(s)
+	r.Qual = r.Qual[left : n-right]
+	return true
+}
+
+// hardClipCigar converts the first n query bases of a CIGAR into a hard clip,
+// returning the new CIGAR and the number of reference bases removed.
+func hardClipCigar(c sam.Cigar, n int) (sam.Cigar, int) {
+	var hard, shift int
+	res := make(sam.Cigar, 0, len(c)+1)
+	i := 0
+	for ; i < len(c); i++ {
+		t, l := c[i].Type(), c[i].Len()
+		con := t.Consumes()
+		if t == sam.CigarHardClipped {
+			hard += l
+			continue
+		}
+		if con.Query == 0 {
+			// drop reference-only operations left at the edge
+			if t != sam.CigarPadded {
+				shift += l * con.Reference
+			}
+			continue
+		}
+		if n == 0 {
+			break
+		}
+		if l <= n {
+			n -= l
+			hard += l
+			shift += l * con.Reference
+			continue
+		}
+		hard += n
+		shift += n * con.Reference
+		res = append(res, sam.NewCigarOp(t, l-n))
+		n = 0
+		i++
+		break
+	}
+	if hard > 0 {
+		res = append(sam.Cigar{sam.NewCigarOp(sam.CigarHardClipped, hard)}, res...)
+	}
+	return append(res, c[i:]...), shift
+}
+
+func reverseCigar(c sam.Cigar) sam.Cigar {
+	res := make(sam.Cigar, len(c))
+	for i, op := range c {
+		res[len(c)-1-i] = op
+	}
+	return res
+}
+
+var substitutionTypes = []string{"A>C", "A>G", "A>T", "C>A", "C>G", "C>T", "G>A", "G>C", "G>T", "T>A", "T>C", "T>G"}
+
+var baseIndex = map[byte]int{'A': 0, 'C': 1, 'G': 2, 'T': 3}
+
+func BamToolEndMismatch(p *bamtool.Params) {
+	ref, err := p.Yaml.Get("Ref").String()
+	checkError(err)
+	idx := NewRefWitdFaidx(ref, true, p.Silent)
+	window, err := p.Yaml.Get("Window").Int()
+	if err != nil {
+		window = 50
+	}
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+
+	// counts[end][pos][from*4+to], where end 0 is the start and 1 is the end of the read
+	var counts [2][][16]int
+	var aligned [2][]int
+	for e := 0; e < 2; e++ {
+		counts[e] = make([][16]int, window)
+		aligned[e] = make([]int, window)
+	}
+
+	for r := range p.InChan {
+		if !GetSamMapped(r) || r.Seq.Length == 0 {
+			p.OutChan <- r
+			continue
+		}
+		refSeq, err := idx.IdxSubSeq(r.Ref.Name(), r.Pos+1, r.End())
+		checkError(err)
+		refSeq = strings.ToUpper(refSeq)
+		readSeq := r.Seq.Expand()
+		reverse := r.Flags&sam.Reverse != 0
+		readLen := GetSamReadLen(r)
+		leftHard := GetSamLeftHardClip(r)
+
+		qi, ri := 0, 0
+		for _, op := range r.Cigar {
+			con := op.Type().Consumes()
+			switch op.Type() {
+			case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+				for k := 0; k < op.Len(); k++ {
+					readPos := leftHard + qi + k
+					if reverse {
+						readPos = readLen - 1 - readPos
+					}
+					fromDist, toDist := readPos, readLen-1-readPos
+					if fromDist >= window && toDist >= window {
+						continue
+					}
+					if ri+k >= len(refSeq) {
+						break
+					}
+					rb, qb := refSeq[ri+k], readSeq[qi+k]
+					if reverse {
+						rb, qb = complementBase(rb), complementBase(qb)
+					}
+					from, okFrom := baseIndex[rb]
+					to, okTo := baseIndex[qb]
+					for e, dist := range [2]int{fromDist, toDist} {
+						if dist >= window {
+							continue
+						}
+						aligned[e][dist]++
+						if okFrom && okTo && from != to {
+							counts[e][dist][from*4+to]++
+						}
+					}
+				}
+			}
+			qi += op.Len() * con.Query
+			ri += op.Len() * con.Reference
+		}
+		p.OutChan <- r
+	}
+
+	ends := []string{"Start", "End"}
+	tsvFh.WriteString("End\tPos\tType\tCount\tAligned\n")
+	for e, end := range ends {
+		for pos := 0; pos < window; pos++ {
+			for _, st := range substitutionTypes {
+				k := baseIndex[st[0]]*4 + baseIndex[st[2]]
+				tsvFh.WriteString(fmt.Sprintf("%s\t%d\t%s\t%d\t%d\n", end, pos, st, counts[e][pos][k], aligned[e][pos]))
+			}
+		}
+	}
+	close(p.OutChan)
+	if tsvFh != os.Stderr {
+		tsvFh.Close()
+	}
+}
+
+var spectrumSubstitutions = []string{"C>A", "C>G", "C>T", "T>A", "T>C", "T>G"}
+
+// spectrumTypes lists the 96 mutation types in the conventional order, e.g.
+// A[C>A]A, A[C>A]C, ..., T[T>G]T.
+func spectrumTypes() []string {
+	types := make([]string, 0, 96)
+	for _, sub := range spectrumSubstitutions {
+		for _, five := range "ACGT" {
+			for _, three := range "ACGT" {
+				types = append(types, fmt.Sprintf("%c[%s]%c", five, sub, three))
+			}
+		}
+	}
+	return types
+}
+
+func BamToolTripletSpectrum(p *bamtool.Params) {
+	ref, err := p.Yaml.Get("Ref").String()
+	checkError(err)
+	idx := NewRefWitdFaidx(ref, true, p.Silent)
+	minQual, _ := p.Yaml.Get("MinBaseQual").Int()
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+
+	counts := make(map[string]int, 96)
+	for r := range p.InChan {
+		if !GetSamMapped(r) || r.Flags&(sam.Secondary|sam.Supplementary) != 0 || r.Seq.Length == 0 {
+			p.OutChan <- r
+			continue
+		}
+		chrom := r.Ref.Name()
+		chromLen, _ := idx.ChromLen(chrom)
+		// the aligned reference with one flanking base on both sides
+		lo, hi := r.Pos-1, r.End()+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > chromLen {
+			hi = chromLen
+		}
+		refSeq, err := idx.IdxSubSeq(chrom, lo+1, hi)
+		checkError(err)
+		refSeq = strings.ToUpper(refSeq)
+		readSeq := r.Seq.Expand()
+
+		qi, ri := 0, r.Pos
+		for _, op := range r.Cigar {
+			con := op.Type().Consumes()
+			switch op.Type() {
+			case sam.CigarMatch, sam.CigarMismatch:
+				for k := 0; k < op.Len(); k++ {
+					g := ri + k - lo
+					if g < 1 || g+1 >= len(refSeq) {
+						continue
+					}
+					if len(r.Qual) > qi+k && int(r.Qual[qi+k]) < minQual {
+						continue
+					}
+					from, to := refSeq[g], readSeq[qi+k]
+					if from == to {
+						continue
+					}
+					if t, ok := tripletType(refSeq[g-1:g+2], to); ok {
+						counts[t]++
+					}
+				}
+			}
+			qi += op.Len() * con.Query
+			ri += op.Len() * con.Reference
+		}
+		p.OutChan <- r
+	}
+
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	bw := bufio.NewWriter(tsvFh)
+	bw.WriteString("Type\tSubstitution\tCount\tFraction\n")
+	for _, t := range spectrumTypes() {
+		frac := 0.0
+		if total > 0 {
+			frac = float64(counts[t]) / float64(total)
+		}
+		bw.WriteString(fmt.Sprintf("%s\t%s\t%d\t%.6f\n", t, t[2:5], counts[t], frac))
+	}
+	checkError(bw.Flush())
+	// closed before the output channel, so the file is complete when the pipeline ends
+	if tsvFh != os.Stderr {
+		checkError(tsvFh.Close())
+	}
+	close(p.OutChan)
+}
+
+// tripletType returns the mutation type of a substitution of the middle base
+// of a reference triplet, reported on the strand of the pyrimidine reference
+// base. Triplets with non-ACGT bases are rejected.
+func tripletType(triplet string, to byte) (string, bool) {
+	for _, b := range []byte{triplet[0], triplet[1], triplet[2], to} {
+		if _, ok := baseIndex[b]; !ok {
+			return "", false
+		}
+	}
+	five, from, three := triplet[0], triplet[1], triplet[2]
+	if from == 'A' || from == 'G' {
+		five, from, three = complementBase(three), complementBase(from), complementBase(five)
+		to = complementBase(to)
+	}
+	return fmt.Sprintf("%c[%c>%c]%c", five, from, to, three), true
+}
+
+func complementBase(b byte) byte {
+	switch b {
+	case 'A':
+		return 'T'
+	case 'C':
+		return 'G'
+	case 'G':
+		return 'C'
+	case 'T':
+		return 'A'
+	}
+	return b
+}
+
+func BamToolDedup(p *bamtool.Params) {
+	by, err := p.Yaml.Get("By").String()
+	if err != nil {
+		by = "name"
+	}
+	var keyFunc func(r *sam.Record) string
+	switch by {
+	case "name":
+		keyFunc = func(r *sam.Record) string {
+			return r.Name
+		}
+	case "signature":
+		keyFunc = func(r *sam.Record) string {
+			return fmt.Sprintf("%s\t%d\t%d\t%s", GetSamRef(r), r.Pos, r.Flags&sam.Reverse, r.Cigar.String())
+		}
+	default:
+		log.Fatal("Dedup: invalid value for By (name|signature):", by)
+	}
+	umiTag, err := p.Yaml.Get("UmiTag").String()
+	if err == nil && umiTag != "" {
+		if p.CountOnly {
+			log.Fatal("Dedup: UmiTag can not be used with --count-only as tags are not decoded!")
+		}
+		baseKey := keyFunc
+		keyFunc = func(r *sam.Record) string {
+			umi := ""
+			if aux, ok := r.Tag([]byte(umiTag)); ok {
+				umi = fmt.Sprint(aux.Value())
+			}
+			return baseKey(r) + "\t" + umi
+		}
+	}
+
+	index := make(map[string]int)
+	kept := make([]*sam.Record, 0, 1024)
+	for r := range p.InChan {
+		key := keyFunc(r)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(kept)
+			kept = append(kept, r)
+			continue
+		}
+		if betterDedupRecord(r, kept[i]) {
+			kept[i] = r
+		}
+	}
+	for _, r := range kept {
+		p.OutChan <- r
+	}
+	close(p.OutChan)
+}
+
+// BamToolMergeMates runs a nested pipeline of tools and keeps only the records
+// of reads (QNAMEs) for which all records passed, so both mates of a pair are
+// dropped if one of them fails a filter. The nested tools are given as a list
+// to keep their order. Records are kept in memory until the end of the input.
+func BamToolMergeMates(p *bamtool.Params) {
+	arr, err := p.Yaml.Get("Tools").Array()
+	if err != nil {
+		checkError(fmt.Errorf("MergeMates: no Tools specified"))
+	}
+	conf := make(yaml.MapSlice, 0, len(arr))
+	for _, t := range arr {
+		m, ok := t.(map[interface{}]interface{})
+		if !ok || len(m) != 1 {
+			checkError(fmt.Errorf("MergeMates: Tools must be a list of tool configurations"))
+		}
+		for k, v := range m {
+			conf = append(conf, yaml.MapItem{Key: k, Value: v})
+		}
+	}
+	b, err := yaml.Marshal(conf)
+	checkError(err)
+	y, tools, err := bamtool.LoadConfig(b, p.Shed)
+	checkError(err)
+
+	chanCap := cap(p.InChan)
+	subIn := make(chan *sam.Record, chanCap)
+	subOut := make(chan *sam.Record, chanCap)
+	opts := bamtool.Options{Header: p.Header, Quiet: p.Quiet, Silent: p.Silent, Threads: p.Threads, ChanCap: chanCap, Sink: p.Sink, Annotations: p.Annotations}
+	checkError(p.Shed.Pipeline(y, tools, subIn, subOut, opts))
+
+	inCount := make(map[string]int)
+	go func() {
+		for r := range p.InChan {
+			inCount[r.Name]++
+			subIn <- r
+		}
+		close(subIn)
+	}()
+
+	outCount := make(map[string]int)
+	passed := make([]*sam.Record, 0, 1024)
+	for r := range subOut {
+		outCount[r.Name]++
+		passed = append(passed, r)
+	}
+
+	dropped := 0
+	for _, r := range passed {
+		if outCount[r.Name] != inCount[r.Name] {
+			dropped++
+			continue
+		}
+		p.OutChan <- r
+	}
+	if !p.Quiet {
+		log.Infof("MergeMates: dropped %d records of reads failing in the nested tools", dropped)
+	}
+	close(p.OutChan)
+}
+
+// BamToolStratifiedSample subsamples the records to a total count, keeping the
+// proportions of the records across references (Mode: "proportional"), giving
+// the same number of records to all references (Mode: "equal") or using the
+// given per-reference Weights. Unmapped records are in the stratum "*".
+// Records are kept in memory until the end of the input.
+func BamToolStratifiedSample(p *bamtool.Params) {
+	total, err := p.Yaml.Get("Total").Int()
+	if err != nil || total < 0 {
+		checkError(fmt.Errorf("StratifiedSample: Total must be a non-negative integer"))
+	}
+	mode, err := p.Yaml.Get("Mode").String()
+	if err != nil {
+		mode = "proportional"
+	}
+	if mode != "proportional" && mode != "equal" {
+		checkError(fmt.Errorf("StratifiedSample: invalid Mode (proportional|equal): %s", mode))
+	}
+	seed, err := p.Yaml.Get("Seed").Int()
+	if err != nil {
+		seed = 11
+	}
+	var weights map[string]float64
+	if p.Yaml.Get("Weights").IsFound() {
+		keys, err := p.Yaml.Get("Weights").GetMapKeys()
+		checkError(err)
+		weights = make(map[string]float64, len(keys))
+		for _, k := range keys {
+			weights[k] = getYamlFloat(p.Yaml.Get("Weights"), k, 0)
+		}
+	}
+
+	records := make([]*sam.Record, 0, 1024)
+	strata := make(map[string][]int)
+	for r := range p.InChan {
+		ref := GetSamRef(r)
+		strata[ref] = append(strata[ref], len(records))
+		records = append(records, r)
+	}
+
+	counts := make(map[string]int, len(strata))
+	w := make(map[string]float64, len(strata))
+	for ref, idx := range strata {
+		counts[ref] = len(idx)
+		switch {
+		case weights != nil:
+			w[ref] = weights[ref]
+		case mode == "equal":
+			w[ref] = 1
+		default:
+			w[ref] = float64(len(idx))
+		}
+	}
+	targets := stratifiedTargets(counts, w, total)
+
+	rng := rand.New(rand.NewSource(int64(seed)))
+	keep := make([]bool, len(records))
+	refs := make([]string, 0, len(strata))
+	for ref := range strata {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		idx := strata[ref]
+		for _, i := range rng.Perm(len(idx))[:targets[ref]] {
+			keep[idx[i]] = true
+		}
+	}
+	for i, r := range records {
+		if keep[i] {
+			p.OutChan <- r
+		}
+	}
+
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil {
+		tsvFh := os.Stderr
+		if tsvFile != "-" {
+			tsvFh, err = os.Create(tsvFile)
+			checkError(err)
+		}
+		bw := bufio.NewWriter(tsvFh)
+		bw.WriteString("Ref\tCount\tSampled\n")
+		for _, ref := range refs {
+			fmt.Fprintf(bw, "%s\t%d\t%d\n", ref, counts[ref], targets[ref])
+		}
+		// write errors are sticky in bufio and reported by Flush
+		checkError(bw.Flush())
+		// closed before the output channel, so the file is complete when the pipeline ends
+		if tsvFh != os.Stderr {
+			checkError(tsvFh.Close())
+		}
+	}
+	close(p.OutChan)
+}
+
+// stratifiedTargets distributes total among the strata proportionally to the
+// weights, capped by the available counts. The shares of capped strata are
+// redistributed among the rest and the rounding remainders are assigned by the
+// largest remainder method.
+func stratifiedTargets(counts map[string]int, weights map[string]float64, total int) map[string]int {
+	targets := make(map[string]int, len(counts))
+	active := make([]string, 0, len(counts))
+	for ref, c := range counts {
+		if c > 0 && weights[ref] > 0 {
+			active = append(active, ref)
+		}
+	}
+	sort.Strings(active)
+	remaining := total
+	for len(active) > 0 && remaining > 0 {
+		wsum := 0.0
+		for _, ref := range active {
+			wsum += weights[ref]
+		}
+		capped := false
+		next := active[:0:0]
+		for _, ref := range active {
+			if float64(remaining)*weights[ref]/wsum >= float64(counts[ref]) {
+				targets[ref] = counts[ref]
+				capped = true
+			} else {
+				next = append(next, ref)
+			}
+		}
+		if capped {
+			for _, ref := range active {
+				remaining -= targets[ref]
+			}
+			active = next
+			continue
+		}
+
+		type remainder struct {
+			ref  string
+			frac float64
+		}
+		rems := make([]remainder, 0, len(active))
+		assigned := 0
+		for _, ref := range active {
+			share := float64(remaining) * weights[ref] / wsum
+			targets[ref] = int(math.Floor(share))
+			assigned += targets[ref]
+			rems = append(rems, remainder{ref, share - math.Floor(share)})
+		}
+		sort.SliceStable(rems, func(i, j int) bool { return rems[i].frac > rems[j].frac })
+		for i := 0; i < remaining-assigned && i < len(rems); i++ {
+			targets[rems[i].ref]++
+		}
+		break
+	}
+	return targets
+}
+
+// betterDedupRecord decides if record a should replace b: higher mapping
+// quality wins, ties are broken by the longer alignment.
+func betterDedupRecord(a, b *sam.Record) bool {
+	if GetSamMapped(a) != GetSamMapped(b) {
+		return GetSamMapped(a)
+	}
+	if a.MapQ != b.MapQ {
+		return a.MapQ > b.MapQ
+	}
+	return a.Len() > b.Len()
+}
+
+// BamToolExec writes the records in SAM format (with header) to the standard
+// input of a command run by bash and reads back the records from its standard
+// output, so records can be filtered or modified by external programs.
+func BamToolExec(p *bamtool.Params) {
+	command, err := p.Yaml.Get("Cmd").String()
+	checkError(err)
+	c := exec.Command("bash", "-c", command)
+	stdin, err := c.StdinPipe()
+	checkError(err)
+	stdout, err := c.StdoutPipe()
+	checkError(err)
+	c.Stderr = os.Stderr
+	checkError(c.Start())
+
+	go func() {
+		bw := bufio.NewWriter(stdin)
+		w, err := sam.NewWriter(bw, p.Header, sam.FlagDecimal)
+		checkError(err)
+		for r := range p.InChan {
+			checkError(w.Write(r))
+		}
+		checkError(bw.Flush())
+		stdin.Close()
+	}()
+
+	r, err := sam.NewReader(bufio.NewReader(stdout))
+	checkError(err)
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		checkError(err)
+		p.OutChan <- rec
+	}
+	if err = c.Wait(); err != nil {
+		checkError(fmt.Errorf("Exec: command failed: %s: %s", command, err))
+	}
+	close(p.OutChan)
+}
+
+// BamToolScript runs a Lua script on every record. The record is exposed as
+// the global table "r" with the fields name, flag, ref, pos (1-based), mapq,
+// cigar, seq, qual and tags. Changes to name, flag, mapq and tags are written
+// back to the record, and the record is discarded if the script returns false.
+func BamToolScript(p *bamtool.Params) {
+	code, err := p.Yaml.Get("Code").String()
+	if err != nil {
+		file, err := p.Yaml.Get("File").String()
+		if err != nil {
+			checkError(fmt.Errorf("Script: no Code or File specified"))
+		}
+		b, err := ioutil.ReadFile(file)
+		checkError(err)
+		code = string(b)
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	fn, err := L.LoadString(code)
+	if err != nil {
+		checkError(fmt.Errorf("Script: %s", err))
+	}
+
+	for r := range p.InChan {
+		rt := L.NewTable()
+		rt.RawSetString("name", lua.LString(r.Name))
+		rt.RawSetString("flag", lua.LNumber(r.Flags))
+		if r.Ref != nil {
+			rt.RawSetString("ref", lua.LString(r.Ref.Name()))
+		}
+		rt.RawSetString("pos", lua.LNumber(r.Pos+1))
+		rt.RawSetString("mapq", lua.LNumber(r.MapQ))
+		rt.RawSetString("cigar", lua.LString(r.Cigar.String()))
+		rt.RawSetString("seq", lua.LString(r.Seq.Expand()))
+		qual := make([]byte, len(r.Qual))
+		for i, q := range r.Qual {
+			qual[i] = q + 33
+		}
+		rt.RawSetString("qual", lua.LString(qual))
+		tags := L.NewTable()
+		for _, aux := range r.AuxFields {
+			tags.RawSetString(aux.Tag().String(), auxToLua(aux))
+		}
+		rt.RawSetString("tags", tags)
+		L.SetGlobal("r", rt)
+
+		L.Push(fn)
+		if err := L.PCall(0, 1, nil); err != nil {
+			checkError(fmt.Errorf("Script: %s", err))
+		}
+		ret := L.Get(-1)
+		L.Pop(1)
+		if ret == lua.LFalse {
+			continue
+		}
+
+		if name, ok := rt.RawGetString("name").(lua.LString); ok {
+			r.Name = string(name)
+		}
+		if flag, ok := rt.RawGetString("flag").(lua.LNumber); ok {
+			r.Flags = sam.Flags(flag)
+		}
+		if mapq, ok := rt.RawGetString("mapq").(lua.LNumber); ok {
+			r.MapQ = byte(mapq)
+		}
+		if tags, ok := rt.RawGetString("tags").(*lua.LTable); ok {
+			r.AuxFields = luaToAuxFields(r.AuxFields, tags)
+		}
+		p.OutChan <- r
+	}
+	close(p.OutChan)
+}
+
+// auxToLua converts the value of an optional field to a Lua value. Array
+// values are exposed as strings.
+func auxToLua(aux sam.Aux) lua.LValue {
+	switch v := aux.Value().(type) {
+	case int8:
+		return lua.LNumber(v)
+	case uint8:
+		return lua.LNumber(v)
+	case int16:
+		return lua.LNumber(v)
+	case uint16:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	}
+	return lua.LString(fmt.Sprintf("%v", aux.Value()))
+}
+
+// luaToAuxFields rebuilds the optional fields from a Lua table. Unchanged
+// fields are kept as they are, fields set to nil are removed and new or
+// modified fields are appended.
+func luaToAuxFields(orig sam.AuxFields, tags *lua.LTable) sam.AuxFields {
+	res := make(sam.AuxFields, 0, len(orig))
+	seen := make(map[string]bool, len(orig))
+	for _, aux := range orig {
+		tag := aux.Tag().String()
+		seen[tag] = true
+		v := tags.RawGetString(tag)
+		if v == lua.LNil {
+			continue
+		}
+		if lua.LVAsString(v) == lua.LVAsString(auxToLua(aux)) {
+			res = append(res, aux)
+			continue
+		}
+		res = append(res, luaToAux(tag, v))
+	}
+	tags.ForEach(func(k, v lua.LValue) {
+		tag := lua.LVAsString(k)
+		if seen[tag] {
+			return
+		}
+		res = append(res, luaToAux(tag, v))
+	})
+	return res
+}
+
+func luaToAux(tag string, v lua.LValue) sam.Aux {
+	if len(tag) != 2 {
+		checkError(fmt.Errorf("Script: invalid tag: %s", tag))
+	}
+	var val interface{}
+	switch t := v.(type) {
+	case lua.LNumber:
+		if float64(t) == float64(int(t)) {
+			val = int(t)
+		} else {
+			val = float32(t)
+		}
+	default:
+		val = lua.LVAsString(v)
+	}
+	aux, err := sam.NewAux(sam.NewTag(tag), val)
+	checkError(err)
+	return aux
+}
+
+// GetSamRecordSize returns the size of the record in the BAM encoding
+// (excluding the block size field).
+func GetSamRecordSize(r *sam.Record) int {
+	size := 32 + len(r.Name) + 1 + 4*len(r.Cigar) + (r.Seq.Length+1)/2 + len(r.Qual)
+	for _, aux := range r.AuxFields {
+		size += len(aux)
+	}
+	return size
+}
+
+type recordSize struct {
+	Read    string
+	Size    int
+	SeqLen  int
+	AuxSize int
+}
+
+// BamToolSizeGuard reports the largest records by their BAM encoded size and
+// drops records larger than MaxSize (Action: "drop") or strips their largest
+// optional fields until they fit (Action: "strip").
+func BamToolSizeGuard(p *bamtool.Params) {
+	maxSize, err := p.Yaml.Get("MaxSize").Int()
+	if err != nil {
+		maxSize = -1
+	}
+	action, err := p.Yaml.Get("Action").String()
+	if err != nil {
+		action = "drop"
+	}
+	if action != "drop" && action != "strip" {
+		checkError(fmt.Errorf("SizeGuard: invalid Action: %s", action))
+	}
+	top, err := p.Yaml.Get("Top").Int()
+	if err != nil {
+		top = 10
+	}
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+
+	largest := make([]recordSize, 0, top+1)
+	var nAbove, nDropped, nStripped int
+	for r := range p.InChan {
+		size := GetSamRecordSize(r)
+		if top > 0 && (len(largest) < top || size > largest[len(largest)-1].Size) {
+			auxSize := 0
+			for _, aux := range r.AuxFields {
+				auxSize += len(aux)
+			}
+			largest = append(largest, recordSize{r.Name, size, r.Seq.Length, auxSize})
+			sort.SliceStable(largest, func(i, j int) bool { return largest[i].Size > largest[j].Size })
+			if len(largest) > top {
+				largest = largest[:top]
+			}
+		}
+		if maxSize >= 0 && size > maxSize {
+			nAbove++
+			if action == "drop" {
+				nDropped++
+				continue
+			}
+			sort.SliceStable(r.AuxFields, func(i, j int) bool { return len(r.AuxFields[i]) > len(r.AuxFields[j]) })
+			for len(r.AuxFields) > 0 && size > maxSize {
+				size -= len(r.AuxFields[0])
+				r.AuxFields = r.AuxFields[1:]
+			}
+			nStripped++
+		}
+		p.OutChan <- r
+	}
+
+	tsvFh.WriteString("Read\tSize\tSeqLen\tAuxSize\n")
+	for _, l := range largest {
+		tsvFh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\n", l.Read, l.Size, l.SeqLen, l.AuxSize))
+	}
+	if maxSize >= 0 && !p.Quiet {
+		log.Infof("SizeGuard: %d records above %d bytes, %d dropped, %d stripped", nAbove, maxSize, nDropped, nStripped)
+	}
+	close(p.OutChan)
+}
+
+// qualBinSchemes are the built-in quality binning schemes by the number of
+// levels: the lower edges of the bins and the quality assigned to each bin.
+// The 8 and 4 level schemes follow the Illumina HiSeq and NovaSeq binning.
+var qualBinSchemes = map[int][2][]int{
+	2: {{0, 20}, {10, 30}},
+	4: {{0, 3, 15, 31}, {2, 12, 23, 37}},
+	8: {{0, 2, 10, 20, 25, 30, 35, 40}, {2, 6, 15, 22, 27, 33, 37, 40}},
+}
+
+// BamToolQualBin replaces the base qualities by the value of their bin, the
+// bins are given by Levels or by their lower Edges and Values.
+func BamToolQualBin(p *bamtool.Params) {
+	var edges, values []int
+	if _, err := p.Yaml.Get("Edges").Array(); err == nil {
+		edges = yamlIntList(p.Yaml.Get("Edges"), "QualBin: Edges")
+		values = yamlIntList(p.Yaml.Get("Values"), "QualBin: Values")
+	} else {
+		levels, err := p.Yaml.Get("Levels").Int()
+		if err != nil {
+			levels = 8
+		}
+		scheme, ok := qualBinSchemes[levels]
+		if !ok {
+			checkError(fmt.Errorf("QualBin: Levels should be 2, 4 or 8: %d", levels))
+		}
+		edges, values = scheme[0], scheme[1]
+	}
+	if len(edges) == 0 || len(edges) != len(values) {
+		checkError(fmt.Errorf("QualBin: Edges and Values should be non-empty lists of the same length"))
+	}
+	if edges[0] != 0 || !sort.IntsAreSorted(edges) {
+		checkError(fmt.Errorf("QualBin: Edges should be increasing and start with 0"))
+	}
+
+	var table [256]byte
+	bin := 0
+	for q := 0; q < 255; q++ {
+		for bin+1 < len(edges) && q >= edges[bin+1] {
+			bin++
+		}
+		table[q] = byte(values[bin])
+	}
+	table[255] = 255 // missing qualities
+
+	for r := range p.InChan {
+		for i, q := range r.Qual {
+			r.Qual[i] = table[q]
+		}
+		p.OutChan <- r
+	}
+	close(p.OutChan)
+}
+
+// yamlIntList parses a YAML list of integers.
+func yamlIntList(y *syaml.Yaml, name string) []int {
+	arr, err := y.Array()
+	if err != nil {
+		checkError(fmt.Errorf("%s should be a list of integers", name))
+	}
+	res := make([]int, len(arr))
+	for i, v := range arr {
+		n, ok := v.(int)
+		if !ok {
+			checkError(fmt.Errorf("%s should be a list of integers: %v", name, v))
+		}
+		res[i] = n
+	}
+	return res
+}
+
+// BamToolStripSeq replaces SEQ and QUAL by "*" in secondary and
+// supplementary records (Records: "secondary") or in all records
+// (Records: "all"). Unless Records is "all", primary records without
+// sequence are fatal, so the stripped output still holds every read.
+func BamToolStripSeq(p *bamtool.Params) {
+	which, err := p.Yaml.Get("Records").String()
+	if err != nil {
+		which = "secondary"
+	}
+	if which != "secondary" && which != "all" {
+		checkError(fmt.Errorf("StripSeq: invalid Records: %s", which))
+	}
+
+	var nStripped int
+	for r := range p.InChan {
+		secondary := r.Flags&(sam.Secondary|sam.Supplementary) != 0
+		if which == "all" || secondary {
+			if r.Seq.Length > 0 {
+				nStripped++
+			}
+			r.Seq = sam.Seq{}
+			r.Qual = nil
+		} else if r.Seq.Length == 0 {
+			checkError(fmt.Errorf("StripSeq: primary record without sequence: %s", r.Name))
+		}
+		p.OutChan <- r
+	}
+	close(p.OutChan)
+	if !p.Quiet {
+		log.Infof("StripSeq: stripped sequence of %d records", nStripped)
+	}
+}
+
+// multiQCStats are the aggregate stats of a run, the field order is kept
+// as the column order of the MultiQC table.
+type multiQCStats struct {
+	Verdict         string  `json:"Verdict"`
+	Records         int     `json:"Records"`
+	Primary         int     `json:"Primary"`
+	Mapped          int     `json:"Mapped"`
+	MappedFrac      float64 `json:"MappedFrac"`
+	Secondary       int     `json:"Secondary"`
+	Supplementary   int     `json:"Supplementary"`
+	Yield           int     `json:"Yield"`
+	MappedYield     int     `json:"MappedYield"`
+	AccMean         float64 `json:"AccMean"`
+	WeightedAccMean float64 `json:"WeightedAccMean"`
+	MeanCoverage    float64 `json:"MeanCoverage"`
+	RefsCovered     int     `json:"RefsCovered"`
+	Refs            int     `json:"Refs"`
+	Failed          string  `json:"Failed"`
+}
+
+// multiQCSection is a MultiQC custom content section with a table.
+type multiQCSection struct {
+	ID          string                   `json:"id"`
+	SectionName string                   `json:"section_name"`
+	Description string                   `json:"description"`
+	PlotType    string                   `json:"plot_type"`
+	Pconfig     map[string]string        `json:"pconfig"`
+	Data        map[string]*multiQCStats `json:"data"`
+}
+
+// BamToolMultiQC collects the yield, mapping, accuracy (see AccStats) and
+// coverage stats of the primary records and writes them along with a
+// pass/fail verdict as MultiQC custom content, so that the file can be
+// picked up by MultiQC as it is (the file name should end in _mqc.json).
+// The sample name is taken from Sample or the SM tag of the first read group.
+func BamToolMultiQC(p *bamtool.Params) {
+	jsonFile, err := p.Yaml.Get("Json").String()
+	if err != nil {
+		checkError(fmt.Errorf("MultiQC: Json is required"))
+	}
+	sample, err := p.Yaml.Get("Sample").String()
+	if err != nil {
+		sample = "sample"
+		for _, rg := range p.Header.RGs() {
+			if sm := rg.Get(sam.NewTag("SM")); sm != "" {
+				sample = sm
+				break
+			}
+		}
+	}
+	minAcc := getYamlFloat(p.Yaml, "MinAcc", -1)
+	minMappedFrac := getYamlFloat(p.Yaml, "MinMappedFrac", -1)
+	minYield := getYamlFloat(p.Yaml, "MinYield", -1)
+
+	st := &multiQCStats{Refs: len(p.Header.Refs())}
+	covered := make([]bool, st.Refs)
+	var accSum, wAccSum float64
+	var alnLen, refBases int
+	for r := range p.InChan {
+		st.Records++
+		switch {
+		case r.Flags&sam.Secondary != 0:
+			st.Secondary++
+		case r.Flags&sam.Supplementary != 0:
+			st.Supplementary++
+		default:
+			st.Primary++
+			st.Yield += GetSamReadLen(r)
+			if GetSamMapped(r) {
+				st.Mapped++
+				st.MappedYield += GetSamReadLen(r)
+				info := GetSamAlnDetails(r)
+				accSum += info.Acc
+				wAccSum += info.WAcc
+				alnLen += info.Len
+				refBases += info.MatchMismatch + info.Deletion
+				covered[r.Ref.ID()] = true
+			}
+		}
+		p.OutChan <- r
+	}
+
+	if st.Primary > 0 {
+		st.MappedFrac = float64(st.Mapped) / float64(st.Primary)
+	}
+	if st.Mapped > 0 {
+		st.AccMean = accSum / float64(st.Mapped)
+	}
+	if alnLen > 0 {
+		st.WeightedAccMean = wAccSum / float64(alnLen)
+	}
+	totalRefLen := 0
+	for i, ref := range p.Header.Refs() {
+		totalRefLen += ref.Len()
+		if covered[i] {
+			st.RefsCovered++
+		}
+	}
+	if totalRefLen > 0 {
+		st.MeanCoverage = float64(refBases) / float64(totalRefLen)
+	}
+
+	var failed []string
+	if minAcc >= 0 && st.WeightedAccMean < minAcc {
+		failed = append(failed, "MinAcc")
+	}
+	if minMappedFrac >= 0 && st.MappedFrac < minMappedFrac {
+		failed = append(failed, "MinMappedFrac")
+	}
+	if minYield >= 0 && float64(st.Yield) < minYield {
+		failed = append(failed, "MinYield")
+	}
+	st.Verdict = "PASS"
+	st.Failed = "-"
+	if len(failed) > 0 {
+		st.Verdict = "FAIL"
+		st.Failed = strings.Join(failed, ",")
+	}
+
+	section := multiQCSection{
+		ID:          "seqkit_bam",
+		SectionName: "SeqKit bam",
+		Description: "run-level alignment QC by the seqkit bam toolbox",
+		PlotType:    "table",
+		Pconfig:     map[string]string{"id": "seqkit_bam_table", "title": "SeqKit bam: QC"},
+		Data:        map[string]*multiQCStats{sample: st},
+	}
+	out, err := json.MarshalIndent(section, "", "  ")
+	checkError(err)
+	// written before the output channel is closed, so the file is complete when the pipeline ends
+	checkError(ioutil.WriteFile(jsonFile, append(out, '\n'), 0644))
+	if !p.Quiet {
+		log.Infof("MultiQC: %s %s", sample, st.Verdict)
+	}
+	close(p.OutChan)
+}
+
+// rateBucket holds the records seen in one second of the Rate window.
+type rateBucket struct {
+	sec     int64
+	records int
+	bases   int
+	mapped  int
+	accSum  float64
+}
+
+// BamToolRate monitors the throughput of a live stream: every Interval
+// seconds the records/s, bases/s and the mean accuracy of the mapped records
+// in the last Window seconds are reported, along with the total number of
+// records. The last line covers the whole run.
+func BamToolRate(p *bamtool.Params) {
+	interval, err := p.Yaml.Get("Interval").Int()
+	if err != nil || interval < 1 {
+		interval = 10
+	}
+	window, err := p.Yaml.Get("Window").Int()
+	if err != nil || window < 1 {
+		window = 60
+	}
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+
+	var mu sync.Mutex
+	buckets := make([]rateBucket, window)
+	start := time.Now()
+	var total rateBucket
+
+	report := func(now time.Time, all bool) {
+		elapsed := now.Sub(start).Seconds()
+		var sum rateBucket
+		span := float64(window)
+		if all {
+			sum, span = total, elapsed
+		} else {
+			sec := now.Unix()
+			for _, b := range buckets {
+				if b.sec > sec-int64(window) {
+					sum.records += b.records
+					sum.bases += b.bases
+					sum.mapped += b.mapped
+					sum.accSum += b.accSum
+				}
+			}
+			if elapsed < span {
+				span = elapsed
+			}
+		}
+		if span <= 0 {
+			span = 1
+		}
+		acc := math.NaN()
+		if sum.mapped > 0 {
+			acc = sum.accSum / float64(sum.mapped)
+		}
+		tsvFh.WriteString(fmt.Sprintf("%.0f\t%.1f\t%.1f\t%.3f\t%d\n", elapsed, float64(sum.records)/span, float64(sum.bases)/span, acc, total.records))
+	}
+
+	tsvFh.WriteString("Time\tRecordsPerSec\tBasesPerSec\tMeanAcc\tTotalRecords\n")
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				mu.Lock()
+				report(now, false)
+				mu.Unlock()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for r := range p.InChan {
+		var acc float64
+		mapped := GetSamMapped(r)
+		if mapped {
+			acc = GetSamAlnDetails(r).Acc
+		}
+		sec := time.Now().Unix()
+		mu.Lock()
+		b := &buckets[sec%int64(window)]
+		if b.sec != sec {
+			*b = rateBucket{sec: sec}
+		}
+		for _, c := range []*rateBucket{b, &total} {
+			c.records++
+			c.bases += r.Seq.Length
+			if mapped {
+				c.mapped++
+				c.accSum += acc
+			}
+		}
+		mu.Unlock()
+		p.OutChan <- r
+	}
+	ticker.Stop()
+	done <- true
+
+	// the whole-run report is written before the output channel is closed,
+	// so it is complete when the pipeline ends
+	report(time.Now(), true)
+	if tsvFh != os.Stderr {
+		checkError(tsvFh.Close())
+	}
+	close(p.OutChan)
+}
+
+// BamToolToFastx writes the reads of the records to File in FASTQ or FASTA
+// format (Format, guessed from the file name by default). Only primary
+// records are written unless Primary is false, reverse strand reads are
+// restored to their original orientation unless Orig is false, and the aux
+// fields listed in Tags are added to the header as in "samtools fastq -T".
+// Missing base qualities are written as quality 1. File is required, and can
+// only be the standard output ("-") if the pipeline is a sink, which leaves
+// the standard output to the reads.
+func BamToolToFastx(p *bamtool.Params) {
+	file, err := p.Yaml.Get("File").String()
+	if err != nil || file == "" {
+		checkError(fmt.Errorf("ToFastx: File is required"))
+	}
+	if file == "-" && !p.Sink {
+		checkError(fmt.Errorf("ToFastx: File can only be \"-\" (stdout) with Sink: True, as the records are written to stdout otherwise"))
+	}
+	format, err := p.Yaml.Get("Format").String()
+	if err != nil {
+		format = "fastq"
+		name := strings.TrimSuffix(strings.ToLower(file), ".gz")
+		for _, ext := range []string{".fa", ".fasta", ".fna", ".fas"} {
+			if strings.HasSuffix(name, ext) {
+				format = "fasta"
+			}
+		}
+	}
+	if format != "fasta" && format != "fastq" {
+		checkError(fmt.Errorf("ToFastx: invalid Format: %s", format))
+	}
+	orig, err := p.Yaml.Get("Orig").Bool()
+	if err != nil {
+		orig = true
+	}
+	primary, err := p.Yaml.Get("Primary").Bool()
+	if err != nil {
+		primary = true
+	}
+	tags := make(map[string]bool)
+	if arr, err := p.Yaml.Get("Tags").Array(); err == nil {
+		for _, t := range arr {
+			tag, ok := t.(string)
+			if !ok || len(tag) != 2 {
+				checkError(fmt.Errorf("ToFastx: invalid tag: %v", t))
+			}
+			tags[tag] = true
+		}
+	}
+
+	outfh, err := xopen.Wopen(file)
+	checkError(err)
+	var n int
+	for r := range p.InChan {
+		if r.Seq.Length == 0 || (primary && r.Flags&(sam.Secondary|sam.Supplementary) != 0) {
+			p.OutChan <- r
+			continue
+		}
+		readSeq := string(r.Seq.Expand())
+		qual := make([]byte, len(readSeq))
+		for i := range qual {
+			if i < len(r.Qual) && r.Qual[i] != 0xff {
+				qual[i] = r.Qual[i] + 33
+			} else {
+				qual[i] = 34
+			}
+		}
+		if orig && GetSamReverse(r) {
+			readSeq = RevCompDNA(readSeq)
+			for i, j := 0, len(qual)-1; i < j; i, j = i+1, j-1 {
+				qual[i], qual[j] = qual[j], qual[i]
+			}
+		}
+
+		head := r.Name
+		for _, aux := range r.AuxFields {
+			if tags[aux.Tag().String()] {
+				head += "\t" + aux.String()
+			}
+		}
+		if format == "fasta" {
+			outfh.WriteString(fmt.Sprintf(">%s\n%s\n", head, readSeq))
+		} else {
+			outfh.WriteString(fmt.Sprintf("@%s\n%s\n+\n%s\n", head, readSeq, qual))
+		}
+		n++
+		p.OutChan <- r
+	}
+	// closed before the output channel, so the file is complete when the pipeline ends
+	checkError(outfh.Close())
+	if !p.Quiet {
+		log.Infof("ToFastx: wrote %d reads", n)
+	}
+	close(p.OutChan)
+}
+
+// BamToolToPaf writes the mapped records in minimap2-style PAF format. The
+// query coordinates are on the original read strand. The cg tag (CIGAR without
+// clipping) is added if Cigar is true, the cs tag (short form, with splice
+// sites) if Cs is true, which requires the reference in Ref. Records without
+// SEQ get no cs tag.
+func BamToolToPaf(p *bamtool.Params) {
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+	bw := bufio.NewWriter(tsvFh)
+	withCg, _ := p.Yaml.Get("Cigar").Bool()
+	withCs, _ := p.Yaml.Get("Cs").Bool()
+	var idx *RefWithFaidx
+	if withCs {
+		ref, err := p.Yaml.Get("Ref").String()
+		if err != nil {
+			checkError(fmt.Errorf("ToPaf: Ref is required for the cs tag"))
+		}
+		idx = NewRefWitdFaidx(ref, true, p.Silent)
+	}
+
+	for r := range p.InChan {
+		if !GetSamMapped(r) {
+			p.OutChan <- r
+			continue
+		}
+		info := GetSamAlnDetails(r)
+		qlen := GetSamReadLen(r)
+		qstart, qend := GetSamLeftClip(r), qlen-GetSamRightClip(r)
+		strand := "+"
+		if r.Flags&sam.Reverse != 0 {
+			strand = "-"
+			qstart, qend = qlen-qend, qlen-qstart
+		}
+		matches := info.MatchMismatch - (info.Mismatch - info.Insertion - info.Deletion)
+		tp := "P"
+		if r.Flags&sam.Secondary != 0 {
+			tp = "S"
+		}
+		bw.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\ttp:A:%s\tNM:i:%d",
+			r.Name, qlen, qstart, qend, strand, r.Ref.Name(), r.Ref.Len(), r.Pos, r.End(),
+			matches, info.Len, r.MapQ, tp, info.Mismatch))
+		if withCg {
+			bw.WriteString("\tcg:Z:" + pafCigar(r.Cigar))
+		}
+		// secondary alignments often have no SEQ ("*"), so no cs tag for them
+		if withCs && r.Seq.Length > 0 {
+			refSeq, err := idx.IdxSubSeq(r.Ref.Name(), r.Pos+1, r.End())
+			checkError(err)
+			bw.WriteString("\tcs:Z:" + pafCs(r, refSeq))
+		}
+		bw.WriteString("\n")
+		p.OutChan <- r
+	}
+	checkError(bw.Flush())
+	close(p.OutChan)
+	if tsvFh != os.Stderr {
+		tsvFh.Close()
+	}
+}
+
+// pafCigar returns the CIGAR without the clipping operations.
+func pafCigar(c sam.Cigar) string {
+	var b strings.Builder
+	for _, op := range c {
+		if op.Type() == sam.CigarSoftClipped || op.Type() == sam.CigarHardClipped {
+			continue
+		}
+		b.WriteString(op.String())
+	}
+	return b.String()
+}
+
+// pafCs generates the short form of the cs tag of a record given the
+// aligned reference sequence.
+func pafCs(r *sam.Record, refSeq string) string {
+	refSeq = strings.ToLower(refSeq)
+	readSeq := strings.ToLower(string(r.Seq.Expand()))
+	var b strings.Builder
+	qi, ri := 0, 0
+	for _, op := range r.Cigar {
+		n := op.Len()
+		switch op.Type() {
+		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+			same := 0
+			for k := 0; k < n; k++ {
+				if ri+k >= len(refSeq) || qi+k >= len(readSeq) {
+					break
+				}
+				rb, qb := refSeq[ri+k], readSeq[qi+k]
+				if rb == qb {
+					same++
+					continue
+				}
+				if same > 0 {
+					b.WriteString(fmt.Sprintf(":%d", same))
+					same = 0
+				}
+				b.WriteString(fmt.Sprintf("*%c%c", rb, qb))
+			}
+			if same > 0 {
+				b.WriteString(fmt.Sprintf(":%d", same))
+			}
+		case sam.CigarInsertion:
+			b.WriteString("+" + readSeq[qi:qi+n])
+		case sam.CigarDeletion:
+			b.WriteString("-" + refSeq[ri:ri+n])
+		case sam.CigarSkipped:
+			if n >= 4 && ri+n <= len(refSeq) {
+				b.WriteString(fmt.Sprintf("~%s%d%s", refSeq[ri:ri+2], n, refSeq[ri+n-2:ri+n]))
+			} else {
+				b.WriteString(fmt.Sprintf("~%d", n))
+			}
+		}
+		con := op.Type().Consumes()
+		qi += n * con.Query
+		ri += n * con.Reference
+	}
+	return b.String()
+}
+
+// BamToolComposition reports the GC content, the fraction of bases in
+// homopolymer runs of at least MinHomopolymer (default 5) bases and the base
+// counts (in read orientation) of the primary records, using only the SEQ
+// field, so it works on unaligned BAM files too.
+func BamToolComposition(p *bamtool.Params) {
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+	bw := bufio.NewWriter(tsvFh)
+	minHomo, err := p.Yaml.Get("MinHomopolymer").Int()
+	if err != nil {
+		minHomo = 5
+	}
+
+	bw.WriteString("Read\tLen\tGC\tHomopolymer\tA\tC\tG\tT\tN\n")
+	for r := range p.InChan {
+		if r.Flags&(sam.Secondary|sam.Supplementary) != 0 || r.Seq.Length == 0 {
+			p.OutChan <- r
+			continue
+		}
+		s := r.Seq.Expand()
+		var counts [5]int // A, C, G, T, other
+		homo, run := 0, 1
+		for i, b := range s {
+			switch b {
+			case 'A', 'a':
+				counts[0]++
+			case 'C', 'c':
+				counts[1]++
+			case 'G', 'g':
+				counts[2]++
+			case 'T', 't':
+				counts[3]++
+			default:
+				counts[4]++
+			}
+			if i > 0 && b == s[i-1] {
+				run++
+			} else {
+				if run >= minHomo {
+					homo += run
+				}
+				run = 1
+			}
+		}
+		if run >= minHomo {
+			homo += run
+		}
+		if r.Flags&sam.Reverse != 0 {
+			counts[0], counts[1], counts[2], counts[3] = counts[3], counts[2], counts[1], counts[0]
+		}
+		l := float64(len(s))
+		bw.WriteString(fmt.Sprintf("%s\t%d\t%.3f\t%.3f\t%d\t%d\t%d\t%d\t%d\n", r.Name, len(s),
+			float64(counts[1]+counts[2])/l*100, float64(homo)/l, counts[0], counts[1], counts[2], counts[3], counts[4]))
+		p.OutChan <- r
+	}
+	checkError(bw.Flush())
+	close(p.OutChan)
+	if tsvFh != os.Stderr {
+		tsvFh.Close()
+	}
 }
 
-type BamToolParams struct {
-	Yaml    *syaml.Yaml
-	InChan  chan *sam.Record
-	OutChan chan *sam.Record
-	Quiet   bool
-	Silent  bool
-	Threads int
-	Rank    int
-	Shed    Toolshed
+// BamToolOnTarget keeps the mapped records overlapping at least one feature
+// of an annotation by MinOverlap bases, optionally only features of the given
+// Types (GTF/GFF). The annotation is loaded through the shared registry, so
+// several OnTarget stages of a pipeline parse the file once.
+func BamToolOnTarget(p *bamtool.Params) {
+	file, err := p.Yaml.Get("Annotation").String()
+	if err != nil {
+		checkError(fmt.Errorf("OnTarget: Annotation is required"))
+	}
+	ann, err := p.Annotations.Get(file)
+	checkError(err)
+	types := make(map[string]bool)
+	if arr, err := p.Yaml.Get("Types").Array(); err == nil {
+		for _, t := range arr {
+			types[fmt.Sprint(t)] = true
+		}
+	}
+	minOverlap, err := p.Yaml.Get("MinOverlap").Int()
+	if err != nil || minOverlap < 1 {
+		minOverlap = 1
+	}
+	invert, _ := p.Yaml.Get("Invert").Bool()
+
+	var pass, total int
+	for r := range p.InChan {
+		total++
+		onTarget := false
+		if GetSamMapped(r) {
+			start, end := r.Pos, r.End()
+			for _, f := range ann.Query(r.Ref.Name(), start, end) {
+				if len(types) > 0 && !types[f.Type] {
+					continue
+				}
+				s, e := f.Start, f.End
+				if s < start {
+					s = start
+				}
+				if e > end {
+					e = end
+				}
+				if e-s >= minOverlap {
+					onTarget = true
+					break
+				}
+			}
+		}
+		if onTarget != invert {
+			pass++
+			p.OutChan <- r
+		}
+	}
+	close(p.OutChan)
+	if !p.Quiet {
+		log.Infof("OnTarget: %d of %d records passed", pass, total)
+	}
 }
 
-type Toolshed map[string]BamTool
+// BamToolToBed writes the spans of the primary alignments in BED format with
+// the read name, the mapping quality as score and the strand. With Split the
+// alignments are split at skipped regions (CIGAR N operations, i.e. introns)
+// into one interval per block, with Bed12 the blocks are reported in BED12
+// format.
+func BamToolToBed(p *bamtool.Params) {
+	tsvFh := os.Stderr
+	tsvFile, err := p.Yaml.Get("Tsv").String()
+	if err == nil && tsvFile != "-" {
+		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
+	}
+	bw := bufio.NewWriter(tsvFh)
+	split, _ := p.Yaml.Get("Split").Bool()
+	bed12, _ := p.Yaml.Get("Bed12").Bool()
+
+	for r := range p.InChan {
+		if !GetSamMapped(r) || r.Flags&(sam.Secondary|sam.Supplementary) != 0 {
+			p.OutChan <- r
+			continue
+		}
+		strand := "+"
+		if r.Flags&sam.Reverse != 0 {
+			strand = "-"
+		}
+		var blocks [][2]int
+		if split || bed12 {
+			blocks = alignedBlocks(r)
+		}
+		switch {
+		case bed12:
+			sizes := make([]string, len(blocks))
+			starts := make([]string, len(blocks))
+			for i, b := range blocks {
+				sizes[i] = fmt.Sprintf("%d", b[1]-b[0])
+				starts[i] = fmt.Sprintf("%d", b[0]-r.Pos)
+			}
+			bw.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\t%d\t%d\t0\t%d\t%s,\t%s,\n",
+				r.Ref.Name(), r.Pos, r.End(), r.Name, r.MapQ, strand, r.Pos, r.End(),
+				len(blocks), strings.Join(sizes, ","), strings.Join(starts, ",")))
+		case split:
+			for _, b := range blocks {
+				bw.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n", r.Ref.Name(), b[0], b[1], r.Name, r.MapQ, strand))
+			}
+		default:
+			bw.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n", r.Ref.Name(), r.Pos, r.End(), r.Name, r.MapQ, strand))
+		}
+		p.OutChan <- r
+	}
+	checkError(bw.Flush())
+	close(p.OutChan)
+	if tsvFh != os.Stderr {
+		tsvFh.Close()
+	}
+}
 
-func (s Toolshed) String() string {
-	tools := make([]string, 0, len(s))
-	for t, _ := range s {
-		tools = append(tools, t)
+// alignedBlocks returns the reference intervals of an alignment separated by
+// skipped regions (CIGAR N operations). Deletions do not split blocks.
+func alignedBlocks(r *sam.Record) [][2]int {
+	blocks := make([][2]int, 0, 1)
+	start, pos := r.Pos, r.Pos
+	for _, op := range r.Cigar {
+		if op.Type() == sam.CigarSkipped {
+			if pos > start {
+				blocks = append(blocks, [2]int{start, pos})
+			}
+			pos += op.Len()
+			start = pos
+			continue
+		}
+		pos += op.Len() * op.Type().Consumes().Reference
 	}
-	sort.Strings(tools)
-	res := "Tool\tDescription\n"
-	res += "----\t-----------\n"
-	for _, t := range tools {
-		res += fmt.Sprintf("%s\t%s\n", s[t].Name, s[t].Desc)
+	if pos > start {
+		blocks = append(blocks, [2]int{start, pos})
 	}
-	return res
+	return blocks
 }
 
-func NewToolshed() Toolshed {
-	ts := map[string]BamTool{
-		"AlnContext": BamTool{Name: "AlnContext", Desc: "filter records by the sequence context at start and end", Use: BamToolAlnContext},
-		"AccStats":   BamTool{Name: "AccStats", Desc: "calculates mean accuracy weighted by aligment lengths", Use: BamToolAccStats},
-		"Dump":       BamTool{Name: "Dump", Desc: "dump various record properties in TSV format", Use: BamToolDump},
-		"help":       BamTool{Name: "help", Desc: "list all tools with description", Use: ListTools},
+func BamToolToBigWig(p *bamtool.Params) {
+	file, err := p.Yaml.Get("File").String()
+	if err != nil {
+		checkError(fmt.Errorf("ToBigWig: File is required"))
 	}
-	return ts
-}
+	window, err := p.Yaml.Get("Window").Int()
+	if err != nil || window < 1 {
+		window = 1
+	}
+	minMapQ, _ := p.Yaml.Get("MinMapQ").Int()
 
-func NewBamReaderChan(inFile string, cp int, buff int, threads int) (chan *sam.Record, *bam.Reader) {
-	outChan := make(chan *sam.Record, cp)
-	fh, err := os.Stdin, error(nil)
-	if inFile != "-" {
-		fh, err = os.Open(inFile)
-		checkError(err)
+	// coverage changes at the block boundaries, by reference ID
+	refs := p.Header.Refs()
+	events := make([][]covEvent, len(refs))
+	for r := range p.InChan {
+		if !GetSamMapped(r) || r.Flags&sam.Secondary != 0 || int(r.MapQ) < minMapQ {
+			p.OutChan <- r
+			continue
+		}
+		id := r.Ref.ID()
+		for _, b := range alignedBlocks(r) {
+			events[id] = append(events[id], covEvent{b[0], 1}, covEvent{b[1], -1})
+		}
+		p.OutChan <- r
 	}
 
-	r, err := bam.NewReader(bufio.NewReaderSize(fh, buff), threads)
-	go func() {
-		for {
-			rec, err := r.Read()
-			if err == io.EOF {
-				close(outChan)
-				return
-			}
-			if err != nil {
-				close(outChan)
-			}
-			checkError(err)
-			outChan <- rec
+	chroms := make([]bigwig.Chrom, len(refs))
+	data := make(map[string][]bigwig.Interval)
+	for i, ref := range refs {
+		chroms[i] = bigwig.Chrom{Name: ref.Name(), Size: uint32(ref.Len())}
+		if len(events[i]) == 0 {
+			continue
 		}
-	}()
-	return outChan, r
+		runs := coverageRuns(events[i])
+		events[i] = nil
+		if window > 1 {
+			runs = windowCoverage(runs, window, ref.Len())
+		}
+		data[ref.Name()] = runs
+	}
+
+	// written before the output channel is closed, so the file is complete when the pipeline ends
+	fh, err := os.Create(file)
+	checkError(err)
+	checkError(bigwig.Write(fh, chroms, data))
+	checkError(fh.Close())
+	close(p.OutChan)
 }
 
-func NewBamSinkChan(cp int) (chan *sam.Record, chan bool) {
-	outChan := make(chan *sam.Record, cp)
-	doneChan := make(chan bool, 0)
-	go func() {
-		for rec := range outChan {
-			_ = rec
-		}
-		doneChan <- true
-	}()
+// pileupCounts holds the number of A, C, G, T bases and deletions at a
+// reference position and how many of them came from forward strand records.
+type pileupCounts struct {
+	bases [5]uint32
+	fwd   uint32
+}
 
-	return outChan, doneChan
+func (c *pileupCounts) depth() uint32 {
+	return c.bases[0] + c.bases[1] + c.bases[2] + c.bases[3] + c.bases[4]
 }
 
-func NewBamWriterChan(inFile string, head *sam.Header, cp int, buff int, threads int) (chan *sam.Record, chan bool) {
-	outChan := make(chan *sam.Record, buff)
-	doneChan := make(chan bool, 0)
-	fh, err := os.Stdout, error(nil)
-	if inFile != "-" {
-		fh, err = os.Open(inFile)
-		checkError(err)
+func BamToolConsensus(p *bamtool.Params) {
+	fasta, err := p.Yaml.Get("Fasta").String()
+	if err != nil {
+		checkError(fmt.Errorf("Consensus: Fasta is required"))
+	}
+	bedFile, _ := p.Yaml.Get("Bed").String()
+	minDepth, err := p.Yaml.Get("MinDepth").Int()
+	if err != nil {
+		minDepth = 3
+	}
+	maxBias := getYamlFloat(p.Yaml, "MaxStrandBias", 1.0)
+	minQual, _ := p.Yaml.Get("MinBaseQual").Int()
+	minMapQ, _ := p.Yaml.Get("MinMapQ").Int()
+	maskN, _ := p.Yaml.Get("MaskN").Bool()
+	lineWidth, err := p.Yaml.Get("LineWidth").Int()
+	if err != nil {
+		lineWidth = 60
 	}
 
-	bio := bufio.NewWriterSize(fh, buff)
-	w, err := bam.NewWriter(bio, head, threads)
-	go func() {
-		for rec := range outChan {
-			err := w.Write(rec)
-			checkError(err)
-		}
-		w.Close()
-		bio.Flush()
-		fh.Close()
-		doneChan <- true
-	}()
-	return outChan, doneChan
-}
+	refs := p.Header.Refs()
 
-func BamToolbox(toolYaml string, inFile string, outFile string, quiet bool, silent bool, threads int) {
-	if toolYaml == "help" {
-		toolYaml = "help: true"
+	// masked positions take lower case reference bases unless MaskN is set
+	var idx *RefWithFaidx
+	if ref, err := p.Yaml.Get("Ref").String(); err == nil && ref != "" {
+		idx = NewRefWitdFaidx(ref, false, p.Silent)
+		for _, r := range refs {
+			if l, ok := idx.ChromLen(r.Name()); !ok || l != r.Len() {
+				checkError(fmt.Errorf("Consensus: reference sequence missing or of different length in %s: %s", ref, r.Name()))
+			}
+		}
 	}
-	y, err := syaml.NewYaml([]byte(toolYaml))
+	fw, err := xopen.Wopen(fasta)
 	checkError(err)
-	ty, err := y.GetMapKeys()
-	checkError(err)
-	if ty[0] == "Yaml" {
-		conf, err := y.Get("Yaml").String()
-		checkError(err)
-		cb, err := ioutil.ReadFile(conf)
-		checkError(err)
-		y, err = syaml.NewYaml(cb)
+	var bw *bufio.Writer
+	var bedFh *os.File
+	if bedFile != "" {
+		bedFh, err = os.Create(bedFile)
 		checkError(err)
+		bw = bufio.NewWriter(bedFh)
 	}
 
-	chanCap := 5000
-	ioBuff := 1024 * 128
-
-	paramFields := map[string]bool{
-		"Sink": true,
+	writeFasta := func(name string, n int, base func(i int) byte) {
+		fmt.Fprintf(fw, ">%s\n", name)
+		width := lineWidth
+		if width <= 0 {
+			width = n
+		}
+		for j := 0; j < n; j += width {
+			end := j + width
+			if end > n {
+				end = n
+			}
+			for k := j; k < end; k++ {
+				fw.WriteByte(base(k))
+			}
+			fw.WriteByte('\n')
+		}
 	}
-
-	switch len(ty) {
-	case 0:
-		log.Fatal("toolbox: not tool specified!")
-	default:
-		tkeys, err := y.GetMapKeys()
+	refSeq := func(ref *sam.Reference) string {
+		if idx == nil || maskN {
+			return ""
+		}
+		s, err := idx.IdxSubSeq(ref.Name(), 1, ref.Len())
 		checkError(err)
-		shed := NewToolshed()
-		var inChan, outChan, lastOut chan *sam.Record
-		var bamReader *bam.Reader
-		var doneChan chan bool
-		var sink bool
-		if tkeys[0] != "help" {
-			inChan, bamReader = NewBamReaderChan(inFile, chanCap, ioBuff, threads)
-			sink, err = y.Get("Sink").Bool()
-			if err == nil && sink {
-				lastOut, doneChan = NewBamSinkChan(chanCap)
-			} else {
-				lastOut, doneChan = NewBamWriterChan(outFile, bamReader.Header(), chanCap, ioBuff, threads)
+		return s
+	}
+
+	masked, total := 0, 0
+	// writeCovered calls the consensus of a reference from its pileup
+	writeCovered := func(ref *sam.Reference, counts []pileupCounts) {
+		seq := make([]byte, 0, len(counts))
+		rs := refSeq(ref)
+		maskStart, maskReason := -1, ""
+		flush := func(end int) {
+			if maskStart >= 0 && bw != nil {
+				fmt.Fprintf(bw, "%s\t%d\t%d\t%s\n", ref.Name(), maskStart, end, maskReason)
 			}
+			maskStart, maskReason = -1, ""
 		}
-		outChan = make(chan *sam.Record, chanCap)
-		nextIn, nextOut := inChan, outChan
-		clearKeys := make([]string, 0)
-		for _, k := range tkeys {
-			if !paramFields[k] {
-				clearKeys = append(clearKeys, k)
+		for pos := range counts {
+			c := &counts[pos]
+			depth := c.depth()
+			reason := ""
+			if int(depth) < minDepth || depth == 0 {
+				reason = "depth"
+			} else if bias := math.Abs(2*float64(c.fwd)-float64(depth)) / float64(depth); bias > maxBias {
+				reason = "strand_bias"
 			}
-		}
-		for rank, tool := range clearKeys {
-			var wt BamTool
-			var ok bool
-			if wt, ok = shed[tool]; !ok {
-				log.Fatal("Unknown tool:", tool)
+			if reason != maskReason {
+				flush(pos)
+				if reason != "" {
+					maskStart, maskReason = pos, reason
+				}
+			}
+			total++
+			if reason != "" {
+				masked++
+				if rs != "" {
+					seq = append(seq, rs[pos]|0x20)
+				} else {
+					seq = append(seq, 'N')
+				}
+				continue
 			}
-			if rank == (len(clearKeys) - 1) {
-				nextOut = lastOut
+			best := 0
+			for b := 1; b < 5; b++ {
+				if c.bases[b] > c.bases[best] {
+					best = b
+				}
 			}
-			params := &BamToolParams{
-				Yaml:    y.Get(tool),
-				InChan:  nextIn,
-				OutChan: nextOut,
-				Quiet:   quiet,
-				Silent:  silent,
-				Threads: threads,
-				Rank:    rank,
-				Shed:    shed,
+			if best < 4 {
+				seq = append(seq, "ACGT"[best])
 			}
-			nextIn = nextOut
-			nextOut = make(chan *sam.Record, chanCap)
-			go wt.Use(params)
+		}
+		flush(len(counts))
+		writeFasta(ref.Name(), len(seq), func(i int) byte { return seq[i] })
+	}
+	// writeUncovered masks a whole reference without allocating a pileup
+	writeUncovered := func(ref *sam.Reference) {
+		n := ref.Len()
+		if n > 0 && bw != nil {
+			fmt.Fprintf(bw, "%s\t%d\t%d\t%s\n", ref.Name(), 0, n, "depth")
+		}
+		total += n
+		masked += n
+		if rs := refSeq(ref); rs != "" {
+			writeFasta(ref.Name(), n, func(i int) byte { return rs[i] | 0x20 })
+		} else {
+			writeFasta(ref.Name(), n, func(i int) byte { return 'N' })
+		}
+	}
 
+	// the input is sorted by coordinate, so the pileup of a reference is
+	// complete when the first record of the next reference arrives
+	cur, next := -1, 0
+	var counts []pileupCounts
+	advance := func(id int) {
+		for ; next < id; next++ {
+			if next == cur {
+				writeCovered(refs[cur], counts)
+				counts = nil
+			} else {
+				writeUncovered(refs[next])
+			}
+		}
+	}
+	for r := range p.InChan {
+		if !GetSamMapped(r) || r.Flags&(sam.Secondary|sam.Supplementary) != 0 || int(r.MapQ) < minMapQ || r.Seq.Length == 0 {
+			p.OutChan <- r
+			continue
+		}
+		id := r.Ref.ID()
+		if id != cur {
+			if id < cur {
+				checkError(fmt.Errorf("Consensus: the input must be sorted by coordinate, found %s after %s", r.Ref.Name(), refs[cur].Name()))
+			}
+			advance(id)
+			cur = id
+			counts = make([]pileupCounts, refs[id].Len())
+		}
+		fwd := uint32(0)
+		if !GetSamReverse(r) {
+			fwd = 1
+		}
+		readSeq := r.Seq.Expand()
+
+		qi, ri := 0, r.Pos
+		for _, op := range r.Cigar {
+			con := op.Type().Consumes()
+			switch op.Type() {
+			case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual:
+				for k := 0; k < op.Len() && ri+k < len(counts); k++ {
+					if len(r.Qual) > qi+k && int(r.Qual[qi+k]) < minQual {
+						continue
+					}
+					b, ok := baseIndex[readSeq[qi+k]]
+					if !ok {
+						continue
+					}
+					counts[ri+k].bases[b]++
+					counts[ri+k].fwd += fwd
+				}
+			case sam.CigarDeletion:
+				for k := 0; k < op.Len() && ri+k < len(counts); k++ {
+					counts[ri+k].bases[4]++
+					counts[ri+k].fwd += fwd
+				}
+			}
+			qi += op.Len() * con.Query
+			ri += op.Len() * con.Reference
 		}
-		<-doneChan
+		p.OutChan <- r
 	}
+	advance(len(refs))
 
+	// closed before the output channel, so the files are complete when the pipeline ends
+	checkError(fw.Close())
+	if bw != nil {
+		checkError(bw.Flush())
+		checkError(bedFh.Close())
+	}
+	if !p.Quiet {
+		log.Infof("Consensus: masked %d of %d reference positions", masked, total)
+	}
+	close(p.OutChan)
 }
 
-func ListTools(p *BamToolParams) {
-	os.Stderr.WriteString(p.Shed.String())
-	os.Exit(0)
+type covEvent struct {
+	pos   int
+	delta int
 }
 
-func BamToolAlnContext(p *BamToolParams) {
-	ref, err := p.Yaml.Get("Ref").String()
-	checkError(err)
-	idx := NewRefWitdFaidx(ref, false, p.Silent)
-	leftShift, err := p.Yaml.Get("LeftShift").Int()
-	checkError(err)
-	rightShift, err := p.Yaml.Get("RightShift").Int()
-	checkError(err)
-	var regStart *regexp.Regexp
-	var regEnd *regexp.Regexp
-	checkError(err)
-	regStrStart, err := p.Yaml.Get("RegexStart").String()
-	if err == nil {
-		regStart = regexp.MustCompile(regStrStart)
+// coverageRuns turns coverage changes into intervals of constant, non-zero
+// coverage.
+func coverageRuns(events []covEvent) []bigwig.Interval {
+	sort.Slice(events, func(i, j int) bool { return events[i].pos < events[j].pos })
+	var runs []bigwig.Interval
+	cov, start := 0, 0
+	for i := 0; i < len(events); {
+		pos, prev := events[i].pos, cov
+		for ; i < len(events) && events[i].pos == pos; i++ {
+			cov += events[i].delta
+		}
+		if cov == prev {
+			continue
+		}
+		if prev > 0 {
+			runs = append(runs, bigwig.Interval{Start: uint32(start), End: uint32(pos), Value: float32(prev)})
+		}
+		start = pos
 	}
-	regStrEnd, err := p.Yaml.Get("RegexEnd").String()
-	if err == nil {
-		regEnd = regexp.MustCompile(regStrEnd)
+	return runs
+}
+
+// windowCoverage returns the mean coverage of the non-empty windows.
+func windowCoverage(runs []bigwig.Interval, window int, refLen int) []bigwig.Interval {
+	var res []bigwig.Interval
+	w := uint32(window)
+	cur, sum := uint32(0), 0.0
+	flush := func() {
+		if sum > 0 {
+			end := cur + w
+			if end > uint32(refLen) {
+				end = uint32(refLen)
+			}
+			res = append(res, bigwig.Interval{Start: cur, End: end, Value: float32(sum / float64(end-cur))})
+		}
+		sum = 0
+	}
+	for _, r := range runs {
+		for start := r.Start; start < r.End; {
+			if ws := start / w * w; ws != cur {
+				flush()
+				cur = ws
+			}
+			end := r.End
+			if end > cur+w {
+				end = cur + w
+			}
+			sum += float64(r.Value) * float64(end-start)
+			start = end
+		}
 	}
-	stranded, invert := false, false
-	_ = invert
-	stranded, _ = p.Yaml.Get("Stranded").Bool()
-	invert, _ = p.Yaml.Get("Invert").Bool()
+	flush()
+	return res
+}
+
+func BamToolQualCalibration(p *bamtool.Params) {
 	tsvFh := os.Stderr
 	tsvFile, err := p.Yaml.Get("Tsv").String()
 	if err == nil && tsvFile != "-" {
 		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
 	}
+	bw := bufio.NewWriter(tsvFh)
+	bw.WriteString("Read\tAlnQueryLen\tMeanQual\tExpected\tObserved\tResidual\n")
 
-	head := fmt.Sprintf("Read\tRef\tStrand\tStartSeq\tStartMatch\tEndSeq\tEndMatch\n")
-	tsvFh.WriteString(head)
-
+	type calib struct{ expected, observed float64 }
+	var reads []calib
+	skipped := 0
 	for r := range p.InChan {
-		chrom := r.Ref.Name()
-		startPos, endPos := r.Pos, r.End()
-		startSeq, err := idx.IdxSubSeq(chrom, startPos+leftShift, startPos+rightShift)
-		checkError(err)
-		endSeq, err := idx.IdxSubSeq(chrom, endPos+leftShift, endPos+rightShift)
-		checkError(err)
-		strand := 1
-		if GetSamReverse(r) {
-			strand = -1
-			if stranded {
-				startSeq, endSeq = RevCompDNA(endSeq), RevCompDNA(startSeq)
-			}
-		}
-
-		yes, no := -1, 1
-		if invert {
-			no, yes = yes, no
+		if !GetSamMapped(r) || r.Flags&(sam.Secondary|sam.Supplementary) != 0 || len(r.Qual) == 0 || r.Qual[0] == 0xff {
+			p.OutChan <- r
+			continue
 		}
-		startMatch := no
-		endMatch := no
-		if regStart != nil {
-			if regStart.MatchString(startSeq) {
-				startMatch = yes
-			}
+		observed, err := samstats.Accuracy(r)
+		if err != nil {
+			skipped++
+			p.OutChan <- r
+			continue
 		}
-
-		if regEnd != nil {
-			if regEnd.MatchString(endSeq) {
-				endMatch = yes
+		// mean error probability of the aligned query bases
+		qi, n, errSum := 0, 0, 0.0
+		for _, op := range r.Cigar {
+			con := op.Type().Consumes()
+			switch op.Type() {
+			case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch, sam.CigarInsertion:
+				for k := 0; k < op.Len(); k++ {
+					errSum += math.Pow(10, -float64(r.Qual[qi+k])/10)
+				}
+				n += op.Len()
 			}
+			qi += op.Len() * con.Query
 		}
-
-		match := (startMatch == yes) || (endMatch == yes)
-
-		info := fmt.Sprintf("%s\t%s\t%d\t%s\t%d\t%s\t%d\n", GetSamName(r), GetSamRef(r), strand, startSeq, startMatch, endSeq, endMatch)
-
-		if match && !invert {
+		if n == 0 {
 			p.OutChan <- r
-			tsvFh.WriteString(info)
-		} else if !match && invert {
-			p.OutChan <- r
-		} else {
-			tsvFh.WriteString(info)
-
+			continue
 		}
-
+		meanErr := errSum / float64(n)
+		expected := (1 - meanErr) * 100
+		reads = append(reads, calib{expected, observed})
+		bw.WriteString(fmt.Sprintf("%s\t%d\t%.2f\t%.3f\t%.3f\t%.3f\n",
+			r.Name, n, -10*math.Log10(meanErr), expected, observed, observed-expected))
+		p.OutChan <- r
+	}
+	checkError(bw.Flush())
+	if tsvFh != os.Stderr {
+		checkError(tsvFh.Close())
+	}
+	if skipped > 0 && !p.Quiet {
+		log.Warningf("QualCalibration: %d records without NM tag skipped", skipped)
 	}
-	close(p.OutChan)
-	tsvFh.Close()
-}
-
-type RefWithFaidx struct {
-	Fasta   string
-	IdxFile string
-	idx     fai.Index
-	faidx   *fai.Faidx
-	Cache   bool
-}
 
-func (idx *RefWithFaidx) IdxSubSeq(chrom string, start, end int) (string, error) {
-	b, err := idx.faidx.SubSeq(chrom, start, end)
-	return string(b), err
-}
+	// deciles of the reads ranked by expected accuracy
+	sort.Slice(reads, func(i, j int) bool { return reads[i].expected < reads[j].expected })
+	var lines []string
+	meanResidual := 0.0
+	for d := 0; d < 10; d++ {
+		from, to := d*len(reads)/10, (d+1)*len(reads)/10
+		if from == to {
+			continue
+		}
+		var sumExp, sumObs float64
+		for _, c := range reads[from:to] {
+			sumExp += c.expected
+			sumObs += c.observed
+		}
+		meanResidual += sumObs - sumExp
+		n := float64(to - from)
+		lines = append(lines, fmt.Sprintf("%d\t%.3f\t%.3f\t%d\t%.3f\t%.3f\t%.3f\n",
+			d+1, reads[from].expected, reads[to-1].expected, to-from, sumExp/n, sumObs/n, (sumObs-sumExp)/n))
+	}
+	if len(reads) > 0 {
+		meanResidual /= float64(len(reads))
+	}
 
-func NewRefWitdFaidx(file string, cache bool, quiet bool) *RefWithFaidx {
-	fileFai := file + ".seqkit.fai"
-	idRegexp := fastx.DefaultIDRegexp
-	var idx fai.Index
-	var err error
-	if fileNotExists(fileFai) {
-		if !quiet {
-			log.Infof("create FASTA index for %s", file)
+	sumFile, err := p.Yaml.Get("Summary").String()
+	if err != nil {
+		if !p.Quiet {
+			log.Infof("QualCalibration: %d reads, mean residual (observed - expected accuracy) %.3f", len(reads), meanResidual)
 		}
-		idx, err = fai.CreateWithIDRegexp(file, fileFai, idRegexp)
-		checkError(err)
-	} else {
-		idx, err = fai.Read(fileFai)
+		close(p.OutChan)
+		return
+	}
+	sumFh := os.Stderr
+	if sumFile != "-" {
+		sumFh, err = os.Create(sumFile)
 		checkError(err)
 	}
-
-	var faidx *fai.Faidx
-	faidx, err = fai.NewWithIndex(file, idx)
-	checkError(err)
-
-	i := &RefWithFaidx{
-		Fasta:   file,
-		IdxFile: fileFai,
-		idx:     idx,
-		faidx:   faidx,
-		Cache:   cache,
+	sw := bufio.NewWriter(sumFh)
+	sw.WriteString("Decile\tMinExpected\tMaxExpected\tReads\tMeanExpected\tMeanObserved\tMeanResidual\n")
+	for _, line := range lines {
+		sw.WriteString(line)
 	}
-	return i
+	checkError(sw.Flush())
+	// closed before the output channel, so the files are complete when the pipeline ends
+	if sumFh != os.Stderr {
+		checkError(sumFh.Close())
+	}
+	close(p.OutChan)
 }
 
-func BamToolAccStats(p *BamToolParams) {
-	totalLen := 0
-	accSum := 0.0
-	wAccSum := 0.0
-	nr := 0.0
+func BamToolSpliceStats(p *bamtool.Params) {
+	var idx *RefWithFaidx
+	ref, err := p.Yaml.Get("Ref").String()
+	if err == nil {
+		idx = NewRefWitdFaidx(ref, true, p.Silent)
+	}
 	tsvFh := os.Stderr
 	tsvFile, err := p.Yaml.Get("Tsv").String()
 	if err == nil && tsvFile != "-" {
 		tsvFh, err = os.Create(tsvFile)
+		checkError(err)
 	}
+	bw := bufio.NewWriter(tsvFh)
+	bw.WriteString("Read\tRef\tPos\tStrand\tIntrons\tIntronLen\tMeanIntronLen\tCanonical\n")
+
+	reads, spliced, canonical := 0, 0, 0
+	lengths := make([]int, 0, 1024)
 	for r := range p.InChan {
-		if GetSamMapped(r) {
-			info := GetSamAlnDetails(r)
-			totalLen += info.Len
-			wAccSum += info.WAcc
-			accSum += info.Acc
-			nr++
+		if !GetSamMapped(r) || r.Flags&(sam.Secondary|sam.Supplementary) != 0 {
+			p.OutChan <- r
+			continue
 		}
+		reads++
+		introns := spliceIntrons(r)
+		strand := "+"
+		if r.Flags&sam.Reverse != 0 {
+			strand = "-"
+		}
+		totalLen, nCanonical := 0, 0
+		for _, in := range introns {
+			totalLen += in[1] - in[0]
+			lengths = append(lengths, in[1]-in[0])
+			if idx != nil && isCanonicalIntron(idx, r.Ref.Name(), in) {
+				nCanonical++
+			}
+		}
+		canonicalStr := "NA"
+		meanLen := 0.0
+		if len(introns) > 0 {
+			spliced++
+			canonical += nCanonical
+			meanLen = float64(totalLen) / float64(len(introns))
+			if idx != nil {
+				canonicalStr = fmt.Sprintf("%d", nCanonical)
+			}
+		}
+		bw.WriteString(fmt.Sprintf("%s\t%s\t%d\t%s\t%d\t%d\t%.2f\t%s\n",
+			r.Name, r.Ref.Name(), r.Pos+1, strand, len(introns), totalLen, meanLen, canonicalStr))
 		p.OutChan <- r
 	}
-	WeightedAcc := wAccSum / float64(totalLen)
-	MeanAcc := accSum / nr
-	tsvFh.WriteString("AccMean\tWeightedAccMean\n")
-	tsvFh.WriteString(fmt.Sprintf("%.3f\t%.3f\n", MeanAcc, WeightedAcc))
+	checkError(bw.Flush())
+	if tsvFh != os.Stderr {
+		checkError(tsvFh.Close())
+	}
+
+	sort.Ints(lengths)
+	minLen, medianLen, maxLen, meanLen := 0, 0.0, 0, 0.0
+	if len(lengths) > 0 {
+		minLen, maxLen = lengths[0], lengths[len(lengths)-1]
+		medianLen = float64(lengths[len(lengths)/2])
+		if len(lengths)%2 == 0 {
+			medianLen = float64(lengths[len(lengths)/2-1]+lengths[len(lengths)/2]) / 2
+		}
+		sum := 0
+		for _, l := range lengths {
+			sum += l
+		}
+		meanLen = float64(sum) / float64(len(lengths))
+	}
+	canonicalFrac := "NA"
+	if idx != nil && len(lengths) > 0 {
+		canonicalFrac = fmt.Sprintf("%.4f", float64(canonical)/float64(len(lengths)))
+	}
+	summary := fmt.Sprintf("%d\t%d\t%d\t%.2f\t%d\t%.1f\t%d\t%s\n",
+		reads, spliced, len(lengths), meanLen, minLen, medianLen, maxLen, canonicalFrac)
+
+	sumFile, err := p.Yaml.Get("Summary").String()
+	if err != nil {
+		if !p.Quiet {
+			log.Infof("SpliceStats: %d of %d primary alignments spliced, %d introns, mean intron length %.2f, canonical fraction %s",
+				spliced, reads, len(lengths), meanLen, canonicalFrac)
+		}
+		close(p.OutChan)
+		return
+	}
+	sumFh := os.Stderr
+	if sumFile != "-" {
+		sumFh, err = os.Create(sumFile)
+		checkError(err)
+	}
+	_, err = sumFh.WriteString("Reads\tSpliced\tIntrons\tMeanIntronLen\tMinIntronLen\tMedianIntronLen\tMaxIntronLen\tCanonicalFrac\n" + summary)
+	checkError(err)
+	// closed before the output channel, so the files are complete when the pipeline ends
+	if sumFh != os.Stderr {
+		checkError(sumFh.Close())
+	}
 	close(p.OutChan)
 }
 
-type AlnDetails struct {
-	Match         int
-	Mismatch      int
-	MatchMismatch int
-	Insertion     int
-	Deletion      int
-	Skip          int
-	Len           int
-	Acc           float64
-	WAcc          float64
+// spliceIntrons returns the 0-based half-open reference intervals of the
+// skipped regions (CIGAR N operations) of an alignment.
+func spliceIntrons(r *sam.Record) [][2]int {
+	var introns [][2]int
+	pos := r.Pos
+	for _, op := range r.Cigar {
+		if op.Type() == sam.CigarSkipped {
+			introns = append(introns, [2]int{pos, pos + op.Len()})
+		}
+		pos += op.Len() * op.Type().Consumes().Reference
+	}
+	return introns
 }
 
-func GetSamAlnDetails(r *sam.Record) *AlnDetails {
-	var mismatch int
-	res := new(AlnDetails)
-	aux, ok := r.Tag([]byte("NM"))
-	if !ok {
-		panic("no NM tag")
-	}
-	var mm int
-	var ins int
-	var del int
-	var skip int
-	switch aux.Value().(type) {
-	case int:
-		mismatch = int(aux.Value().(int))
-	case int8:
-		mismatch = int(aux.Value().(int8))
-	case int16:
-		mismatch = int(aux.Value().(int16))
-	case int32:
-		mismatch = int(aux.Value().(int32))
-	case int64:
-		mismatch = int(aux.Value().(int64))
-	case uint:
-		mismatch = int(aux.Value().(uint))
-	case uint8:
-		mismatch = int(aux.Value().(uint8))
-	case uint16:
-		mismatch = int(aux.Value().(uint16))
-	case uint32:
-		mismatch = int(aux.Value().(uint32))
-	case uint64:
-		mismatch = int(aux.Value().(uint64))
-	default:
-		panic("Could not parse NM tag: " + aux.String())
+// isCanonicalIntron checks for the GT-AG splice site motif on either strand,
+// as the transcript strand of cDNA reads is unknown.
+func isCanonicalIntron(idx *RefWithFaidx, chrom string, intron [2]int) bool {
+	if intron[1]-intron[0] < 4 {
+		return false
 	}
-	for _, op := range r.Cigar {
-		switch op.Type() {
-		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
-			mm += op.Len()
-		case sam.CigarInsertion:
-			ins += op.Len()
-		case sam.CigarDeletion:
-			del += op.Len()
-		case sam.CigarSkipped:
-			skip += op.Len()
-		default:
-			//fmt.Println(op)
-		}
-	}
-	res.MatchMismatch = mm
-	res.Mismatch = mismatch
-	res.Insertion = ins
-	res.Deletion = del
-	res.Skip = skip
-	res.Len = mm + ins + del
-	res.Match = res.MatchMismatch - res.Mismatch
-	res.Acc = (1.0 - float64(mismatch)/float64(mm+ins+del)) * 100
-	res.WAcc = res.Acc * float64(res.Len)
-	return res
+	donor, err := idx.IdxSubSeq(chrom, intron[0]+1, intron[0]+2)
+	checkError(err)
+	acceptor, err := idx.IdxSubSeq(chrom, intron[1]-1, intron[1])
+	checkError(err)
+	motif := strings.ToUpper(donor + acceptor)
+	return motif == "GTAG" || motif == "CTAC"
+}
+
+// GetSamAlnDetails returns the alignment statistics of a record (see the
+// samstats package for the definitions).
+func GetSamAlnDetails(r *sam.Record) *samstats.AlnDetails {
+	info, err := samstats.GetAlnDetails(r)
+	checkError(err)
+	return info
 }
 
-func BamToolDump(p *BamToolParams) {
+func BamToolDump(p *bamtool.Params) {
 	validFields := []string{"Read", "Ref", "Pos", "EndPos", "MapQual", "Acc", "Match", "Mismatch", "Ins", "Del", "AlnLen", "ReadLen", "RefLen", "RefAln", "RefCov", "ReadAln", "ReadCov", "Strand", "MeanQual", "LeftClip", "RightClip", "Flags", "IsSec", "IsSup", "ReadSeq", "ReadAlnSeq", "LeftSoftClipSeq", "RightSoftClipSeq", "RightSoftClip", "LeftHardClip", "RightHardClip"}
 
 	tsvFh := os.Stderr
@@ -590,7 +2900,7 @@ func GetSamMapped(r *sam.Record) bool {
 }
 
 func GetSamReverse(r *sam.Record) bool {
-	return (r.Flags&sam.Reverse == 0)
+	return r.Flags&sam.Reverse != 0
 }
 
 func GetSamRef(r *sam.Record) string {
@@ -761,52 +3071,7 @@ func GetSamEndPos(r *sam.Record) int {
 }
 
 func GetSamAcc(r *sam.Record) float64 {
-	var mismatch int
-	aux, ok := r.Tag([]byte("NM"))
-	if !ok {
-		panic("no NM tag")
-	}
-	var mm int
-	var ins int
-	var del int
-	var skip int
-	switch aux.Value().(type) {
-	case int:
-		mismatch = int(aux.Value().(int))
-	case int8:
-		mismatch = int(aux.Value().(int8))
-	case int16:
-		mismatch = int(aux.Value().(int16))
-	case int32:
-		mismatch = int(aux.Value().(int32))
-	case int64:
-		mismatch = int(aux.Value().(int64))
-	case uint:
-		mismatch = int(aux.Value().(uint))
-	case uint8:
-		mismatch = int(aux.Value().(uint8))
-	case uint16:
-		mismatch = int(aux.Value().(uint16))
-	case uint32:
-		mismatch = int(aux.Value().(uint32))
-	case uint64:
-		mismatch = int(aux.Value().(uint64))
-	default:
-		panic("Could not parse NM tag: " + aux.String())
-	}
-	for _, op := range r.Cigar {
-		switch op.Type() {
-		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
-			mm += op.Len()
-		case sam.CigarInsertion:
-			ins += op.Len()
-		case sam.CigarDeletion:
-			del += op.Len()
-		case sam.CigarSkipped:
-			skip += op.Len()
-		default:
-			//fmt.Println(op)
-		}
-	}
-	return (1.0 - float64(mismatch)/float64(mm+ins+del)) * 100
+	acc, err := samstats.Accuracy(r)
+	checkError(err)
+	return acc
 }
@@ -0,0 +1,260 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+)
+
+// toolbox fixtures, see tests/toolbox/tiny.sam: read1 and read2 have
+// poly(T)/poly(A) reference context at their start/end
+const (
+	toolboxTestBam = "../../tests/toolbox/tiny.bam"
+	toolboxTestRef = "../../tests/toolbox/tiny_ref.fa"
+)
+
+// toolboxTestDir returns a temporary directory holding a copy of the
+// reference, so the index is not written next to the fixtures.
+func toolboxTestDir(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	b, err := ioutil.ReadFile(toolboxTestRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := filepath.Join(dir, "ref.fa")
+	if err = ioutil.WriteFile(ref, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, ref
+}
+
+func toolboxCtxParams(ref string) string {
+	return `Ref: "` + ref + `", LeftShift: -10, RightShift: 10, RegexStart: "T{4,}", RegexEnd: "A{4,}"`
+}
+
+// readToolboxBam returns the read names of the records in a BAM file.
+func readToolboxBam(t *testing.T, file string) []string {
+	fh, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	br, err := bam.NewReader(fh, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+	var names []string
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+// readToolboxTsv returns the lines of a TSV file, header included.
+func readToolboxTsv(t *testing.T, file string) []string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+}
+
+func TestToolboxPipeline(t *testing.T) {
+	dir, ref := toolboxTestDir(t)
+	tests := []struct {
+		name   string
+		conf   string
+		reads  string
+		acc    string
+		ctxLen int
+	}{
+		{
+			"AlnContext-AccStats",
+			`{AlnContext: {Tsv: "ctx.tsv", ` + toolboxCtxParams(ref) + `}, AccStats: {Tsv: "acc.tsv"}}`,
+			"read1,read2", "95.316\t95.385", 12,
+		},
+		{
+			"AlnContext-AccStats-invert",
+			`{AlnContext: {Tsv: "ctx.tsv", Invert: True, ` + toolboxCtxParams(ref) + `}, AccStats: {Tsv: "acc.tsv"}}`,
+			"read3,read6,read5,read4", "97.890\t97.826", 12,
+		},
+		{
+			// the tools are applied in the order of the config
+			"AccStats-AlnContext",
+			`{AccStats: {Tsv: "acc.tsv"}, AlnContext: {Tsv: "ctx.tsv", ` + toolboxCtxParams(ref) + `}}`,
+			"read1,read2", "97.032\t96.905", 12,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := strings.Replace(test.conf, `"acc.tsv"`, `"`+filepath.Join(dir, "acc.tsv")+`"`, 1)
+			conf = strings.Replace(conf, `"ctx.tsv"`, `"`+filepath.Join(dir, "ctx.tsv")+`"`, 1)
+			out := filepath.Join(dir, "out.bam")
+
+			BamToolbox(conf, toolboxTestBam, out, true, true, 2, 0, 0, -1, false)
+
+			if reads := strings.Join(readToolboxBam(t, out), ","); reads != test.reads {
+				t.Errorf("output records: got %s, want %s", reads, test.reads)
+			}
+			acc := readToolboxTsv(t, filepath.Join(dir, "acc.tsv"))
+			if len(acc) != 2 || acc[0] != "AccMean\tWeightedAccMean" || acc[1] != test.acc {
+				t.Errorf("AccStats: got %q, want %q", acc, test.acc)
+			}
+			if ctx := readToolboxTsv(t, filepath.Join(dir, "ctx.tsv")); len(ctx)-1 != test.ctxLen {
+				t.Errorf("AlnContext: got %d lines, want %d", len(ctx)-1, test.ctxLen)
+			}
+		})
+	}
+}
+
+func TestToolboxSink(t *testing.T) {
+	dir := t.TempDir()
+	acc := filepath.Join(dir, "acc.tsv")
+	out := filepath.Join(dir, "out.bam")
+
+	BamToolbox(`{AccStats: {Tsv: "`+acc+`"}, Sink: True}`, toolboxTestBam, out, true, true, 2, 0, 0, -1, false)
+
+	if lines := readToolboxTsv(t, acc); len(lines) != 2 || lines[1] != "97.032\t96.905" {
+		t.Errorf("AccStats: got %q", lines)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("a sink must not write output")
+	}
+}
+
+func TestToolboxRoundtrip(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.bam")
+	acc1 := filepath.Join(dir, "acc1.tsv")
+	acc2 := filepath.Join(dir, "acc2.tsv")
+
+	BamToolbox(`{AccStats: {Tsv: "`+acc1+`"}}`, toolboxTestBam, out, true, true, 2, 0, 0, -1, false)
+	BamToolbox(`{AccStats: {Tsv: "`+acc2+`"}, Sink: True}`, out, "-", true, true, 2, 0, 0, -1, false)
+
+	if n := len(readToolboxBam(t, out)); n != 6 {
+		t.Errorf("output records: got %d, want 6", n)
+	}
+	a, b := readToolboxTsv(t, acc1), readToolboxTsv(t, acc2)
+	if strings.Join(a, "\n") != strings.Join(b, "\n") {
+		t.Errorf("stats differ after roundtrip: %q vs %q", a, b)
+	}
+}
+
+func TestSplitIOThreads(t *testing.T) {
+	tests := []struct {
+		threads        int
+		sink           bool
+		reader, writer int
+	}{
+		{0, false, 1, 1},
+		{1, false, 1, 1},
+		{2, false, 1, 1},
+		{3, false, 1, 2},
+		{4, false, 1, 3},
+		{12, false, 4, 8},
+		{64, false, 21, 43},
+		{1, true, 1, 1},
+		{2, true, 2, 1},
+		{64, true, 64, 1},
+	}
+	for _, test := range tests {
+		reader, writer := splitIOThreads(test.threads, test.sink)
+		if reader != test.reader || writer != test.writer {
+			t.Errorf("splitIOThreads(%d, %v): got %d, %d, want %d, %d",
+				test.threads, test.sink, reader, writer, test.reader, test.writer)
+		}
+	}
+}
+
+func TestRefWithFaidxCache(t *testing.T) {
+	dir, ref := toolboxTestDir(t)
+	b, err := ioutil.ReadFile(toolboxTestRef + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refGz := filepath.Join(dir, "ref.fa.gz")
+	if err = ioutil.WriteFile(refGz, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := NewRefWitdFaidx(ref, false, true)
+	// ctg1 (200 bp) and ctg2 (150 bp) do not fit in the cache together,
+	// so alternating between them evicts the least recently used one
+	cached := NewRefWitdFaidx(ref, true, true)
+	cached.CacheSize = 200
+	cachedGz := NewRefWitdFaidx(refGz, true, true)
+
+	regions := []struct {
+		chrom      string
+		start, end int
+	}{
+		{"ctg1", 1, 10},
+		{"ctg2", 20, 80},
+		{"ctg1", 150, 200},
+		{"ctg1", 61, 61},
+		{"ctg2", 1, 150},
+		{"ctg1", 1, 200},
+	}
+	for _, r := range regions {
+		want, err := plain.IdxSubSeq(r.chrom, r.start, r.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) != r.end-r.start+1 {
+			t.Fatalf("%s:%d-%d: got %d bases from the index", r.chrom, r.start, r.end, len(want))
+		}
+		for _, idx := range []*RefWithFaidx{cached, cachedGz} {
+			got, err := idx.IdxSubSeq(r.chrom, r.start, r.end)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("%s %s:%d-%d: got %s, want %s", idx.Fasta, r.chrom, r.start, r.end, got, want)
+			}
+		}
+	}
+
+	if cached.lru.Len() != 1 || cached.cacheLen != 200 || cached.lru.Front().Value.(*cachedChrom).name != "ctg1" {
+		t.Errorf("only ctg1 should be cached, got %d sequences of %d bases", cached.lru.Len(), cached.cacheLen)
+	}
+	if cachedGz.lru.Len() != 2 || cachedGz.cacheLen != 350 {
+		t.Errorf("both sequences should be cached, got %d sequences of %d bases", cachedGz.lru.Len(), cachedGz.cacheLen)
+	}
+	if _, err = cached.IdxSubSeq("ctg3", 1, 10); err == nil {
+		t.Error("expected an error for an unknown sequence")
+	}
+}
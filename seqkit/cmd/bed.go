@@ -38,6 +38,7 @@ type BedFeature struct {
 	End    int // end included
 	Name   *string
 	Strand *string
+	Blocks [][2]int // 1based, end included, only for BED12
 }
 
 // Threads for bread.NewBufferedReader()
@@ -102,7 +103,15 @@ func ReadBedFilteredFeatures(file string, chrs []string) ([]BedFeature, error) {
 			strand = &items[5]
 		}
 
-		return BedFeature{items[0], start + 1, end, name, strand}, true, nil
+		var blocks [][2]int
+		if n >= 12 {
+			blocks, err = parseBedBlocks(start, end, items[9], items[10], items[11])
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %s", items[0], err)
+			}
+		}
+
+		return BedFeature{items[0], start + 1, end, name, strand, blocks}, true, nil
 	}
 	reader, err := breader.NewBufferedReader(file, Threads, 100, fn)
 	if err != nil {
@@ -119,3 +128,34 @@ func ReadBedFilteredFeatures(file string, chrs []string) ([]BedFeature, error) {
 	}
 	return BedFeatures, nil
 }
+
+// parseBedBlocks converts blockCount, blockSizes and blockStarts of BED12
+// to 1-based blocks with ends included.
+func parseBedBlocks(start, end int, count, sizes, starts string) ([][2]int, error) {
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad blockCount: %s", count)
+	}
+	_sizes := strings.Split(strings.TrimRight(sizes, ","), ",")
+	_starts := strings.Split(strings.TrimRight(starts, ","), ",")
+	if len(_sizes) != n || len(_starts) != n {
+		return nil, fmt.Errorf("numbers of blockSizes (%s) and blockStarts (%s) should be equal to blockCount (%d)", sizes, starts, n)
+	}
+	blocks := make([][2]int, n)
+	var size, s int
+	for i := 0; i < n; i++ {
+		size, err = strconv.Atoi(_sizes[i])
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("bad blockSize: %s", _sizes[i])
+		}
+		s, err = strconv.Atoi(_starts[i])
+		if err != nil || s < 0 {
+			return nil, fmt.Errorf("bad blockStart: %s", _starts[i])
+		}
+		if start+s+size > end {
+			return nil, fmt.Errorf("block %d (%d-%d) out of range of chromEnd (%d)", i+1, start+s+1, start+s+size, end)
+		}
+		blocks[i] = [2]int{start + s + 1, start + s + size}
+	}
+	return blocks, nil
+}
@@ -0,0 +1,331 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// bisulfiteCmd represents the bisulfite command
+var bisulfiteCmd = &cobra.Command{
+	Use:   "bisulfite",
+	Short: "simulate bisulfite conversion of sequences with methylation rates per context",
+	Long: `simulate bisulfite conversion of sequences with methylation rates per context
+
+Unmethylated cytosines are converted to thymines by bisulfite treatment,
+i.e., C->T on the top strand, and G->A (in the coordinates of the top
+strand) on the bottom strand. Output sequences have IDs with suffixes:
+
+  _CT    converted top strand (original top, OT)
+  _GA    converted bottom strand (original bottom, OB)
+
+For PBAT (post-bisulfite adapter tagging) libraries (--pbat), reads come
+from the complementary strands, and the reverse complements are output:
+
+  _CTOT  complementary to the converted top strand
+  _CTOB  complementary to the converted bottom strand
+
+Methylation:
+  The contexts of cytosines (H = A, C or T) are determined on each strand:
+    CpG  C followed by G
+    CHG  C followed by H and G
+    CHH  C followed by H and H, including the ones at the sequence ends
+  Every cytosine is methylated, i.e., protected from conversion, with the
+  rate of its context. Unmethylated cytosines are converted with the
+  probability of -c/--conversion-rate. Cytosines next to non-ACGT bases are
+  treated as CHH.
+
+  The methylation states of all cytosines can be saved as the ground truth
+  to a tab-delimited file (-m/--meth-file), with columns of seqID,
+  position (1-based, of the top strand), strand, context, methylated
+  (1 or 0) and converted (1 or 0).
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		strand := getFlagString(cmd, "strand")
+		pbat := getFlagBool(cmd, "pbat")
+		var rates [3]float64
+		rates[bsCpG] = getFlagFloat64(cmd, "cpg")
+		rates[bsCHG] = getFlagFloat64(cmd, "chg")
+		rates[bsCHH] = getFlagFloat64(cmd, "chh")
+		conversion := getFlagFloat64(cmd, "conversion-rate")
+		seed := getFlagInt64(cmd, "rand-seed")
+		methFile := getFlagString(cmd, "meth-file")
+
+		var top, bottom bool
+		switch strand {
+		case "top":
+			top = true
+		case "bottom":
+			bottom = true
+		case "both":
+			top, bottom = true, true
+		default:
+			checkError(fmt.Errorf("invalid value of flag -S/--strand: %s, available: top, bottom, both", strand))
+		}
+		for i, r := range rates {
+			if r < 0 || r > 1 {
+				checkError(fmt.Errorf("methylation rate of %s should be in range of [0, 1]", bsContexts[i]))
+			}
+		}
+		if conversion < 0 || conversion > 1 {
+			checkError(fmt.Errorf("value of flag -c/--conversion-rate should be in range of [0, 1]"))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var methfh *xopen.Writer
+		if methFile != "" {
+			methfh, err = xopen.Wopen(methFile)
+			checkError(err)
+			defer methfh.Close()
+			methfh.WriteString("seqID\tpos\tstrand\tcontext\tmethylated\tconverted\n")
+		}
+
+		sim := &bsSimulator{rng: rand.New(rand.NewSource(seed)), rates: rates, conversion: conversion}
+
+		var n, nC, nConverted int
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+
+				for _, s := range [2]bool{false, true} {
+					if !s && !top || s && !bottom {
+						continue
+					}
+					converted := record.Clone()
+					var sites []bsSite
+					if s {
+						sites = sim.convertBottom(converted.Seq.Seq)
+					} else {
+						sites = sim.convertTop(converted.Seq.Seq)
+					}
+					for _, site := range sites {
+						nC++
+						if site.converted {
+							nConverted++
+						}
+					}
+					if methfh != nil {
+						for _, site := range sites {
+							methfh.WriteString(fmt.Sprintf("%s\t%d\t%c\t%s\t%d\t%d\n", record.ID, site.pos+1,
+								"+-"[boolToInt(s)], bsContexts[site.context], boolToInt(site.methylated), boolToInt(site.converted)))
+						}
+					}
+
+					suffix := "_CT"
+					if s {
+						suffix = "_GA"
+					}
+					if pbat {
+						converted.Seq = converted.Seq.RevComInplace()
+						suffix = "_CTOT"
+						if s {
+							suffix = "_CTOB"
+						}
+					}
+					bsRename(converted, suffix)
+					converted.FormatToWriter(outfh, config.LineWidth)
+				}
+				n++
+			}
+			config.LineWidth = lineWidth
+		}
+
+		if !quiet {
+			log.Infof("%d sequences converted, %d of %d cytosines converted", n, nConverted, nC)
+		}
+	},
+}
+
+const (
+	bsCpG = iota
+	bsCHG
+	bsCHH
+)
+
+var bsContexts = [3]string{"CpG", "CHG", "CHH"}
+
+// bsSite is the methylation state of a cytosine.
+type bsSite struct {
+	pos        int // 0-based position on the top strand
+	context    int
+	methylated bool
+	converted  bool
+}
+
+type bsSimulator struct {
+	rng        *rand.Rand
+	rates      [3]float64
+	conversion float64
+}
+
+// convert decides the methylation state of a cytosine of a context.
+func (b *bsSimulator) convert(pos int, context int) bsSite {
+	site := bsSite{pos: pos, context: context}
+	site.methylated = b.rates[context] > 0 && b.rng.Float64() < b.rates[context]
+	site.converted = !site.methylated && b.conversion > 0 && b.rng.Float64() < b.conversion
+	return site
+}
+
+// convertTop converts C to T in place.
+func (b *bsSimulator) convertTop(s []byte) []bsSite {
+	sites := make([]bsSite, 0, len(s)/4)
+	var site bsSite
+	for i, c := range s {
+		if c != 'C' && c != 'c' {
+			continue
+		}
+		site = b.convert(i, bsContext(bsUpperAt(s, i+1), bsUpperAt(s, i+2)))
+		if site.converted {
+			s[i] += 'T' - 'C'
+		}
+		sites = append(sites, site)
+	}
+	return sites
+}
+
+// convertBottom converts G to A in place, i.e., C to T on the bottom strand.
+func (b *bsSimulator) convertBottom(s []byte) []bsSite {
+	sites := make([]bsSite, 0, len(s)/4)
+	var site bsSite
+	for i, c := range s {
+		if c != 'G' && c != 'g' {
+			continue
+		}
+		site = b.convert(i, bsContext(bsComplement(bsUpperAt(s, i-1)), bsComplement(bsUpperAt(s, i-2))))
+		if site.converted {
+			s[i] -= 'G' - 'A'
+		}
+		sites = append(sites, site)
+	}
+	return sites
+}
+
+// bsContext returns the context of a cytosine by the next two bases on the
+// same strand.
+func bsContext(next1, next2 byte) int {
+	if next1 == 'G' {
+		return bsCpG
+	}
+	if next1 == 'A' || next1 == 'C' || next1 == 'T' {
+		if next2 == 'G' {
+			return bsCHG
+		}
+	}
+	return bsCHH
+}
+
+func bsUpperAt(s []byte, i int) byte {
+	if i < 0 || i >= len(s) {
+		return 'N'
+	}
+	return s[i] &^ 0x20
+}
+
+func bsComplement(b byte) byte {
+	switch b {
+	case 'A':
+		return 'T'
+	case 'C':
+		return 'G'
+	case 'G':
+		return 'C'
+	case 'T':
+		return 'A'
+	}
+	return 'N'
+}
+
+// bsRename appends a suffix to the ID of a record.
+func bsRename(record *fastx.Record, suffix string) {
+	name := make([]byte, 0, len(record.Name)+len(suffix))
+	if i := bytes.Index(record.Name, record.ID); i >= 0 {
+		name = append(name, record.Name[:i+len(record.ID)]...)
+		name = append(name, suffix...)
+		name = append(name, record.Name[i+len(record.ID):]...)
+	} else {
+		name = append(name, record.ID...)
+		name = append(name, suffix...)
+		name = append(name, ' ')
+		name = append(name, record.Name...)
+	}
+	record.Name = name
+	record.ID = append(append([]byte{}, record.ID...), suffix...)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func init() {
+	RootCmd.AddCommand(bisulfiteCmd)
+
+	bisulfiteCmd.Flags().StringP("strand", "S", "both", "strands to convert: top, bottom or both")
+	bisulfiteCmd.Flags().BoolP("pbat", "P", false, "output reverse complements of converted strands as PBAT libraries")
+	bisulfiteCmd.Flags().Float64P("cpg", "", 0, "methylation rate of CpG cytosines")
+	bisulfiteCmd.Flags().Float64P("chg", "", 0, "methylation rate of CHG cytosines")
+	bisulfiteCmd.Flags().Float64P("chh", "", 0, "methylation rate of CHH cytosines")
+	bisulfiteCmd.Flags().Float64P("conversion-rate", "c", 1, "conversion rate of unmethylated cytosines")
+	bisulfiteCmd.Flags().Int64P("rand-seed", "s", 11, "rand seed")
+	bisulfiteCmd.Flags().StringP("meth-file", "m", "", "save methylation states of all cytosines to this file")
+}
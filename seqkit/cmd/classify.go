@@ -0,0 +1,235 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// classifyCmd represents the classify command
+var classifyCmd = &cobra.Command{
+	Use:   "classify",
+	Short: "classify reads by shared k-mers with a small set of references",
+	Long: `classify reads by shared k-mers with a small set of references
+
+Reads are assigned to the reference sharing the most canonical k-mers with
+them. K-mers present in more than one reference are ignored. Reads with less
+than -m/--min-hits shared k-mers (or with a fraction of hit k-mers less than
+-f/--min-frac) are reported as "unclassified".
+
+The per-read assignment is written to the output file in TSV format, while
+the per-class counts are written to stderr (or the file given by -s/--summary).
+Use -O/--out-dir to write the reads of each class into separate files.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		refFile := getFlagString(cmd, "ref-file")
+		k := getFlagPositiveInt(cmd, "kmer-size")
+		minHits := getFlagNonNegativeInt(cmd, "min-hits")
+		minFrac := getFlagFloat64(cmd, "min-frac")
+		outDir := getFlagString(cmd, "out-dir")
+		summaryFile := getFlagString(cmd, "summary")
+		force := getFlagBool(cmd, "force")
+
+		if refFile == "" {
+			checkError(fmt.Errorf("flag -r/--ref-file needed"))
+		}
+		if k > 32 {
+			checkError(fmt.Errorf("k-mer size should not be greater than 32"))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		// k-mer index of references
+		classes, index := buildKmerClassIndex(refFile, k, alphabet, idRegexp)
+		if !quiet {
+			log.Infof("%d unique k-mers indexed from %d references", len(index), len(classes))
+		}
+		const unclassified = "unclassified"
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+		outfh.WriteString("Read\tClass\tHits\tKmers\n")
+
+		if outDir != "" {
+			existed, err := pathutil.DirExists(outDir)
+			checkError(err)
+			if existed {
+				empty, err := pathutil.IsEmpty(outDir)
+				checkError(err)
+				if !empty {
+					if force {
+						checkError(os.RemoveAll(outDir))
+						checkError(os.MkdirAll(outDir, 0755))
+					} else {
+						log.Warningf("outdir not empty: %s, you can use --force to overwrite", outDir)
+					}
+				}
+			} else {
+				checkError(os.MkdirAll(outDir, 0755))
+			}
+		}
+		writers := make(map[string]*xopen.Writer)
+		defer func() {
+			for _, w := range writers {
+				w.Close()
+			}
+		}()
+
+		counts := make(map[string]int)
+		hits := make([]int, len(classes))
+
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					lineWidth = 0
+				}
+
+				for i := range hits {
+					hits[i] = 0
+				}
+				var total int
+				ForEachKmer(record.Seq.Seq, k, true, func(code uint64, pos int) {
+					total++
+					if c, ok := index[code]; ok && c >= 0 {
+						hits[c]++
+					}
+				})
+
+				best, bestHits := -1, 0
+				for c, h := range hits {
+					if h > bestHits {
+						best, bestHits = c, h
+					}
+				}
+				class := unclassified
+				if best >= 0 && bestHits >= minHits && float64(bestHits) >= minFrac*float64(total) {
+					class = classes[best]
+				}
+				counts[class]++
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%d\t%d\n", record.ID, class, bestHits, total))
+
+				if outDir != "" {
+					w, ok := writers[class]
+					if !ok {
+						suffix := ".fasta.gz"
+						if fastxReader.IsFastq {
+							suffix = ".fastq.gz"
+						}
+						w, err = xopen.Wopen(filepath.Join(outDir, class+suffix))
+						checkError(err)
+						writers[class] = w
+					}
+					record.FormatToWriter(w, lineWidth)
+				}
+			}
+		}
+
+		sumfh := os.Stderr
+		if summaryFile != "" {
+			sumfh, err = os.Create(summaryFile)
+			checkError(err)
+			defer sumfh.Close()
+		}
+		names := append([]string{}, classes...)
+		sort.Strings(names)
+		names = append(names, unclassified)
+		sumfh.WriteString("Class\tCount\n")
+		for _, c := range names {
+			sumfh.WriteString(fmt.Sprintf("%s\t%d\n", c, counts[c]))
+		}
+	},
+}
+
+// buildKmerClassIndex maps the canonical k-mers of reference sequences to the
+// index of the reference. K-mers shared by references are mapped to -1.
+func buildKmerClassIndex(file string, k int, alphabet *seq.Alphabet, idRegexp string) ([]string, map[uint64]int) {
+	classes := make([]string, 0, 8)
+	index := make(map[uint64]int)
+	fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+	checkError(err)
+	for {
+		record, err := fastxReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			checkError(err)
+			break
+		}
+		c := len(classes)
+		classes = append(classes, string(record.ID))
+		ForEachKmer(record.Seq.Seq, k, true, func(code uint64, pos int) {
+			if prev, ok := index[code]; ok && prev != c {
+				index[code] = -1
+				return
+			}
+			index[code] = c
+		})
+	}
+	return classes, index
+}
+
+func init() {
+	RootCmd.AddCommand(classifyCmd)
+
+	classifyCmd.Flags().StringP("ref-file", "r", "", "FASTA file of reference sequences (one class per sequence)")
+	classifyCmd.Flags().IntP("kmer-size", "k", 21, "k-mer size (<=32)")
+	classifyCmd.Flags().IntP("min-hits", "m", 3, "minimum number of k-mers shared with the best class")
+	classifyCmd.Flags().Float64P("min-frac", "f", 0, "minimum fraction of read k-mers shared with the best class")
+	classifyCmd.Flags().StringP("out-dir", "O", "", "write reads of each class to separate files in this directory")
+	classifyCmd.Flags().StringP("summary", "s", "", "write per-class counts to this file instead of stderr")
+	classifyCmd.Flags().BoolP("force", "", false, "overwrite output directory")
+}
@@ -0,0 +1,521 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// clusterCmd represents the cluster command
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "cluster nucleotide sequences by identity (CD-HIT-like)",
+	Long: `cluster nucleotide sequences by identity (CD-HIT-like)
+
+Sequences are sorted by length in decreasing order, and assigned greedily
+to the first representative with an identity of at least -I/--min-identity,
+otherwise they become new representatives.
+
+Comparisons:
+  1. Representatives are prefiltered by the number of shared words (k-mers),
+     a sequence of length L needs at least (L-k+1) - ceil((1-I)*L)*k
+     (at least 1) shared words.
+  2. Candidates are aligned in decreasing order of the shared words with a
+     banded alignment around the diagonal with the most word hits. The
+     shorter sequence is aligned end-to-end, while the ends of the
+     representative are free.
+  3. The identity is the number of identical bases divided by the length of
+     the shorter sequence (the one to be clustered).
+  4. Both strands are compared unless -P/--only-positive-strand is given.
+
+Performance:
+  1. Sequences are compared with representatives in batches using multiple
+     threads (-j/--threads), sequences unassigned in a batch are then
+     compared with the new representatives of the batch one by one.
+  2. Only the sequences and IDs are kept in memory, and representatives are
+     retrieved by reading the input files again, unless the input is STDIN.
+
+Output:
+  Representatives are written in the input order. Columns of the cluster
+  file (-c/--cluster-file), in the input order:
+    id, cluster, representative, length, strand, identity
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		minIdentity := getFlagFloat64(cmd, "min-identity")
+		k := getFlagPositiveInt(cmd, "word-size")
+		band := getFlagNonNegativeInt(cmd, "band-width")
+		onlyPositive := getFlagBool(cmd, "only-positive-strand")
+		clusterFile := getFlagString(cmd, "cluster-file")
+		batchSize := getFlagPositiveInt(cmd, "batch-size")
+
+		if minIdentity <= 0 || minIdentity > 1 {
+			checkError(fmt.Errorf("value of flag -I/--min-identity should be in range of (0, 1]"))
+		}
+		if k > 16 {
+			checkError(fmt.Errorf("value of flag -k/--word-size should be in range of [1, 16]"))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+		keepRecords := false
+		for _, file := range files {
+			if isStdin(file) {
+				keepRecords = true
+			}
+		}
+
+		// read sequences
+		var seqs [][]byte
+		var ids []string
+		var records []*fastx.Record
+		isFastq := false
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					isFastq = true
+				}
+				seqs = append(seqs, bytes.ToUpper(record.Seq.Seq))
+				ids = append(ids, string(record.ID))
+				if keepRecords {
+					records = append(records, record.Clone())
+				}
+			}
+		}
+		if isFastq {
+			lineWidth = 0
+			fastx.ForcelyOutputFastq = true
+		}
+		if !quiet {
+			log.Infof("%d sequences loaded", len(seqs))
+		}
+
+		idx := newClusterIndex(seqs, k)
+		assignments := idx.cluster(minIdentity, band, !onlyPositive, config.Threads, batchSize)
+		if !quiet {
+			log.Infof("%d clusters", len(idx.reps))
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		if keepRecords {
+			for i, r := range records {
+				if assignments[i].rep == i {
+					r.FormatToWriter(outfh, lineWidth)
+				}
+			}
+		} else {
+			i := 0
+			for _, file := range files {
+				fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+				checkError(err)
+				var record *fastx.Record
+				for {
+					record, err = fastxReader.Read()
+					if err != nil {
+						if err == io.EOF {
+							break
+						}
+						checkError(err)
+						break
+					}
+					if assignments[i].rep == i {
+						record.FormatToWriter(outfh, lineWidth)
+					}
+					i++
+				}
+			}
+		}
+
+		if clusterFile != "" {
+			outfhC, err := xopen.Wopen(clusterFile)
+			checkError(err)
+			defer outfhC.Close()
+
+			outfhC.WriteString("id\tcluster\trepresentative\tlength\tstrand\tidentity\n")
+			var strand byte
+			for i, a := range assignments {
+				strand = '+'
+				if a.rc {
+					strand = '-'
+				}
+				outfhC.WriteString(fmt.Sprintf("%s\t%d\t%s\t%d\t%c\t%.4f\n",
+					ids[i], a.cluster+1, ids[a.rep], len(seqs[i]), strand, a.identity))
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(clusterCmd)
+
+	clusterCmd.Flags().Float64P("min-identity", "I", 0.9, "minimum identity to a representative")
+	clusterCmd.Flags().IntP("word-size", "k", 8, "word (k-mer) size for prefiltering (<= 16)")
+	clusterCmd.Flags().IntP("band-width", "b", 20, "band width of alignment")
+	clusterCmd.Flags().BoolP("only-positive-strand", "P", false, "only compare the positive strands")
+	clusterCmd.Flags().StringP("cluster-file", "c", "", "file to save cluster membership of all sequences")
+	clusterCmd.Flags().IntP("batch-size", "B", 1000, "number of sequences compared with representatives in parallel")
+}
+
+// clusterAssignment is the cluster of a sequence.
+type clusterAssignment struct {
+	cluster  int     // index of the cluster
+	rep      int     // index of the representative sequence
+	rc       bool    // aligned on the negative strand
+	identity float64 // identity to the representative
+}
+
+// clusterIndex holds the representatives and their words.
+type clusterIndex struct {
+	k     int
+	seqs  [][]byte
+	reps  []int              // sequence indexes of representatives
+	words map[uint64][]int32 // word -> clusters
+}
+
+func newClusterIndex(seqs [][]byte, k int) *clusterIndex {
+	return &clusterIndex{k: k, seqs: seqs, words: make(map[uint64][]int32, 1<<16)}
+}
+
+// addRep creates a new cluster with the sequence as the representative.
+func (idx *clusterIndex) addRep(i int) int {
+	c := int32(len(idx.reps))
+	idx.reps = append(idx.reps, i)
+	ForEachKmer(idx.seqs[i], idx.k, false, func(code uint64, pos int) {
+		l := idx.words[code]
+		if len(l) > 0 && l[len(l)-1] == c {
+			return
+		}
+		idx.words[code] = append(l, c)
+	})
+	return int(c)
+}
+
+// cluster assigns all sequences to clusters, and returns the assignments
+// in the input order.
+func (idx *clusterIndex) cluster(minIdentity float64, band int, bothStrands bool, threads int, batchSize int) []clusterAssignment {
+	n := len(idx.seqs)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(idx.seqs[order[a]]) > len(idx.seqs[order[b]]) })
+
+	assignments := make([]clusterAssignment, n)
+	searchers := make([]*clusterSearcher, threads)
+	for i := range searchers {
+		searchers[i] = newClusterSearcher(idx, minIdentity, band, bothStrands)
+	}
+
+	var wg sync.WaitGroup
+	for b := 0; b < n; b += batchSize {
+		e := b + batchSize
+		if e > n {
+			e = n
+		}
+		batch := order[b:e]
+		nReps := len(idx.reps)
+
+		// compare with existing representatives in parallel, the index is
+		// read-only here.
+		results := make([]clusterAssignment, len(batch))
+		if nReps > 0 {
+			chunk := (len(batch) + threads - 1) / threads
+			for t := 0; t < threads; t++ {
+				s, e := t*chunk, (t+1)*chunk
+				if e > len(batch) {
+					e = len(batch)
+				}
+				if s >= e {
+					break
+				}
+				wg.Add(1)
+				go func(searcher *clusterSearcher, s, e int) {
+					defer wg.Done()
+					for j := s; j < e; j++ {
+						results[j] = searcher.search(batch[j], 0, nReps)
+					}
+				}(searchers[t], s, e)
+			}
+			wg.Wait()
+		} else {
+			for j := range results {
+				results[j].cluster = -1
+			}
+		}
+
+		// compare with new representatives of this batch
+		for j, i := range batch {
+			r := results[j]
+			if r.cluster < 0 && len(idx.reps) > nReps {
+				r = searchers[0].search(i, nReps, len(idx.reps))
+			}
+			if r.cluster < 0 {
+				r = clusterAssignment{cluster: idx.addRep(i), rep: i, identity: 1}
+			}
+			assignments[i] = r
+		}
+	}
+	return assignments
+}
+
+// clusterSearcher finds the representative of a sequence, it is not safe
+// for concurrent use.
+type clusterSearcher struct {
+	idx         *clusterIndex
+	minIdentity float64
+	band        int
+	bothStrands bool
+
+	counts  []int32
+	touched []int32
+	qWords  map[uint64][]int
+	diags   map[int]int
+	aligner *bandedAligner
+}
+
+func newClusterSearcher(idx *clusterIndex, minIdentity float64, band int, bothStrands bool) *clusterSearcher {
+	return &clusterSearcher{idx: idx, minIdentity: minIdentity, band: band, bothStrands: bothStrands,
+		qWords: make(map[uint64][]int), diags: make(map[int]int), aligner: &bandedAligner{}}
+}
+
+// search compares the i-th sequence with the clusters in [from, to), and
+// returns an assignment with cluster of -1 if none matched.
+func (s *clusterSearcher) search(i int, from, to int) clusterAssignment {
+	q := s.idx.seqs[i]
+	if c, identity := s.searchStrand(q, from, to); c >= 0 {
+		return clusterAssignment{cluster: c, rep: s.idx.reps[c], identity: identity}
+	}
+	if s.bothStrands {
+		rc := []byte(RevCompDNA(string(q)))
+		if c, identity := s.searchStrand(rc, from, to); c >= 0 {
+			return clusterAssignment{cluster: c, rep: s.idx.reps[c], rc: true, identity: identity}
+		}
+	}
+	return clusterAssignment{cluster: -1}
+}
+
+func (s *clusterSearcher) searchStrand(q []byte, from, to int) (int, float64) {
+	idx := s.idx
+	k := idx.k
+	m := len(q)
+
+	// prefiltering by shared words
+	required := (m - k + 1) - int(math.Ceil((1-s.minIdentity)*float64(m)))*k
+	if required < 1 {
+		required = 1
+	}
+	if len(s.counts) < to {
+		s.counts = append(s.counts, make([]int32, to-len(s.counts))...)
+	}
+	s.touched = s.touched[:0]
+	for code := range s.qWords {
+		delete(s.qWords, code)
+	}
+	ForEachKmer(q, k, false, func(code uint64, pos int) {
+		s.qWords[code] = append(s.qWords[code], pos)
+		for _, c := range idx.words[code] {
+			if int(c) < from || int(c) >= to {
+				continue
+			}
+			if s.counts[c] == 0 {
+				s.touched = append(s.touched, c)
+			}
+			s.counts[c]++
+		}
+	})
+
+	candidates := make([]int32, 0, 8)
+	for _, c := range s.touched {
+		if int(s.counts[c]) >= required {
+			candidates = append(candidates, c)
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool {
+		ca, cb := candidates[a], candidates[b]
+		if s.counts[ca] == s.counts[cb] {
+			return ca < cb
+		}
+		return s.counts[ca] > s.counts[cb]
+	})
+	for _, c := range s.touched {
+		s.counts[c] = 0
+	}
+
+	// alignment
+	var r []byte
+	var identity float64
+	for _, c := range candidates {
+		r = idx.seqs[idx.reps[c]]
+		identity = s.aligner.identity(q, r, s.bestDiagonal(r), s.band)
+		if identity >= s.minIdentity {
+			return int(c), identity
+		}
+	}
+	return -1, 0
+}
+
+// bestDiagonal returns the diagonal (position in r - position in q) with the
+// most word hits.
+func (s *clusterSearcher) bestDiagonal(r []byte) int {
+	for d := range s.diags {
+		delete(s.diags, d)
+	}
+	ForEachKmer(r, s.idx.k, false, func(code uint64, pos int) {
+		for _, p := range s.qWords[code] {
+			s.diags[pos-p]++
+		}
+	})
+	best, bestN := 0, 0
+	for d, n := range s.diags {
+		if n > bestN || (n == bestN && d < best) {
+			best, bestN = d, n
+		}
+	}
+	return best
+}
+
+// bandedAligner aligns a query end-to-end to a reference with free ends,
+// in a band around a diagonal, with linear gap penalties.
+type bandedAligner struct {
+	h0, h1 []int
+	m0, m1 []int // matches of the best paths
+}
+
+const (
+	bandMatch    = 1
+	bandMismatch = -1
+	bandGap      = -2
+	bandNInf     = -1 << 30
+)
+
+// identity returns the number of identical bases of the best alignment
+// divided by the length of q.
+func (x *bandedAligner) identity(q, r []byte, diag int, band int) float64 {
+	m, n := len(q), len(r)
+	if m == 0 {
+		return 0
+	}
+	w := 2*band + 1
+	if cap(x.h0) < w {
+		x.h0, x.h1 = make([]int, w), make([]int, w)
+		x.m0, x.m1 = make([]int, w), make([]int, w)
+	}
+	h0, h1 := x.h0[:w], x.h1[:w]
+	m0, m1 := x.m0[:w], x.m1[:w]
+
+	// cell (i, j) is at column j - (i + diag - band) of row i.
+	// row 0: free leading ends of r.
+	for c := 0; c < w; c++ {
+		j := diag - band + c
+		if j < 0 || j > n {
+			h0[c] = bandNInf
+		} else {
+			h0[c] = 0
+		}
+		m0[c] = 0
+	}
+
+	var j, h, mt, v int
+	var s int
+	for i := 1; i <= m; i++ {
+		for c := 0; c < w; c++ {
+			j = i + diag - band + c
+			if j < 0 || j > n {
+				h1[c], m1[c] = bandNInf, 0
+				continue
+			}
+			h, mt = bandNInf, 0
+			// diagonal: (i-1, j-1), the same column of the previous row
+			if j > 0 && h0[c] > bandNInf {
+				if q[i-1] == r[j-1] {
+					s = bandMatch
+				} else {
+					s = bandMismatch
+				}
+				h, mt = h0[c]+s, m0[c]
+				if s == bandMatch {
+					mt++
+				}
+			}
+			// up: (i-1, j), a gap in r
+			if c+1 < w && h0[c+1] > bandNInf {
+				if v = h0[c+1] + bandGap; v > h {
+					h, mt = v, m0[c+1]
+				}
+			}
+			// left: (i, j-1), a gap in q
+			if c > 0 && h1[c-1] > bandNInf {
+				if v = h1[c-1] + bandGap; v > h {
+					h, mt = v, m1[c-1]
+				}
+			}
+			h1[c], m1[c] = h, mt
+		}
+		h0, h1 = h1, h0
+		m0, m1 = m1, m0
+	}
+
+	// the last row, free trailing ends of r
+	best, matches := bandNInf, 0
+	for c := 0; c < w; c++ {
+		if h0[c] > best {
+			best, matches = h0[c], m0[c]
+		}
+	}
+	if best == bandNInf {
+		return 0
+	}
+	return float64(matches) / float64(m)
+}
@@ -0,0 +1,286 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// collapseCmd represents the collapse command
+var collapseCmd = &cobra.Command{
+	Use:   "collapse",
+	Short: "collapse reads of UMI families into consensus reads",
+	Long: `collapse reads of UMI families into consensus reads
+
+Reads are grouped by UMIs, i.e., the first capture group of -r/--umi-regexp
+in read IDs (default), full names or sequences (-U/--umi-in). UMIs found in
+sequences are removed from the reads along with the whole matches.
+One consensus read is outputted for every family, in the order of first
+appearance, with a header of "UMI size=N".
+
+Consensus methods (-m/--method):
+  plurality  the most frequent base of every position, reads are assumed
+             to start at the same position, and the consensus length is
+             the most frequent read length. Fast, for short reads.
+  poa        heaviest path of a partial order alignment (POA) graph, with
+             reads aligned to the graph in a band (-b/--band), for reads
+             with indels, e.g., long reads.
+
+For FASTQ input, the quality of a consensus base is the mean quality of
+the reads supporting it.
+
+Attentions:
+  1. UMIs are matched exactly, please correct UMI errors in advance.
+  2. All reads are kept in memory.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		umiRegexp := getFlagString(cmd, "umi-regexp")
+		umiIn := getFlagString(cmd, "umi-in")
+		method := getFlagString(cmd, "method")
+		band := getFlagNonNegativeInt(cmd, "band")
+		minSize := getFlagPositiveInt(cmd, "min-size")
+		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
+
+		umiRe, err := regexp.Compile(umiRegexp)
+		checkError(err)
+		if umiRe.NumSubexp() < 1 {
+			checkError(fmt.Errorf("the regular expression of -r/--umi-regexp needs a capture group: %s", umiRegexp))
+		}
+		if umiIn != "id" && umiIn != "name" && umiIn != "seq" {
+			checkError(fmt.Errorf("invalid value of flag -U/--umi-in: %s, available: id, name, seq", umiIn))
+		}
+		if method != "plurality" && method != "poa" {
+			checkError(fmt.Errorf("invalid value of flag -m/--method: %s, available: plurality, poa", method))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		families := make(map[string]*umiFamily)
+		var umis []string
+		var nReads, nNoUMI int
+		isFastq := false
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					isFastq = true
+				}
+				nReads++
+
+				var target []byte
+				switch umiIn {
+				case "id":
+					target = record.ID
+				case "name":
+					target = record.Name
+				default:
+					target = record.Seq.Seq
+				}
+				loc := umiRe.FindSubmatchIndex(target)
+				if loc == nil || loc[2] < 0 {
+					nNoUMI++
+					continue
+				}
+				umi := string(target[loc[2]:loc[3]])
+
+				s := record.Seq.Seq
+				q := record.Seq.Qual
+				if umiIn == "seq" {
+					s = append(append([]byte{}, s[:loc[0]]...), s[loc[1]:]...)
+					if len(q) > 0 {
+						q = append(append([]byte{}, q[:loc[0]]...), q[loc[1]:]...)
+					}
+				} else {
+					s = append([]byte{}, s...)
+					if len(q) > 0 {
+						q = append([]byte{}, q...)
+					}
+				}
+				for i := range q {
+					q[i] -= byte(qBase)
+				}
+
+				f, ok := families[umi]
+				if !ok {
+					f = &umiFamily{}
+					families[umi] = f
+					umis = append(umis, umi)
+				}
+				f.seqs = append(f.seqs, s)
+				if len(q) > 0 {
+					f.quals = append(f.quals, q)
+				}
+			}
+		}
+		if isFastq {
+			lineWidth = 0
+			fastx.ForcelyOutputFastq = true
+		}
+		if !quiet && nNoUMI > 0 {
+			log.Warningf("%d reads without UMIs are skipped", nNoUMI)
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var n int
+		var s []byte
+		var reads, quals []int
+		var record *fastx.Record
+		for _, umi := range umis {
+			f := families[umi]
+			if len(f.seqs) < minSize {
+				continue
+			}
+			if len(f.quals) != len(f.seqs) {
+				f.quals = nil
+			}
+
+			if method == "poa" {
+				g := newPoaGraph(band)
+				for i, x := range f.seqs {
+					if f.quals != nil {
+						g.add(x, f.quals[i])
+					} else {
+						g.add(x, nil)
+					}
+				}
+				s, reads, quals = g.consensus()
+			} else {
+				s, reads, quals = pluralityConsensus(f.seqs, f.quals)
+			}
+
+			name := []byte(fmt.Sprintf("%s size=%d", umi, len(f.seqs)))
+			if isFastq {
+				q := make([]byte, len(s))
+				for i := range s {
+					qv := 0
+					if reads[i] > 0 {
+						qv = quals[i] / reads[i]
+					}
+					if qv > 93 {
+						qv = 93
+					}
+					q[i] = byte(qv + qBase)
+				}
+				record, err = fastx.NewRecordWithQualWithoutValidation(seq.Unlimit,
+					[]byte(umi), name, []byte{}, s, q)
+			} else {
+				record, err = fastx.NewRecordWithoutValidation(seq.Unlimit,
+					[]byte(umi), name, []byte{}, s)
+			}
+			checkError(err)
+			record.FormatToWriter(outfh, lineWidth)
+			n++
+		}
+
+		if !quiet {
+			log.Infof("%d consensus reads outputted from %d UMI families of %d reads", n, len(umis), nReads-nNoUMI)
+		}
+	},
+}
+
+// umiFamily holds sequences and quality values of reads sharing a UMI.
+type umiFamily struct {
+	seqs  [][]byte
+	quals [][]byte // nil for FASTA
+}
+
+// pluralityConsensus returns the most frequent base of every position, along
+// with the number of reads and the sum of qualities supporting it. The
+// consensus length is the most frequent length, the longer one for ties.
+// Ties of bases are broken by the sums of qualities.
+func pluralityConsensus(seqs [][]byte, quals [][]byte) (s []byte, reads []int, qsums []int) {
+	lengths := make(map[int]int)
+	var L int
+	for _, x := range seqs {
+		lengths[len(x)]++
+		if c := lengths[len(x)]; c > lengths[L] || (c == lengths[L] && len(x) > L) {
+			L = len(x)
+		}
+	}
+
+	s = make([]byte, L)
+	reads = make([]int, L)
+	qsums = make([]int, L)
+	var counts, qsum [256]int
+	for i := 0; i < L; i++ {
+		counts, qsum = [256]int{}, [256]int{}
+		for k, x := range seqs {
+			if i >= len(x) {
+				continue
+			}
+			counts[x[i]]++
+			if quals != nil {
+				qsum[x[i]] += int(quals[k][i])
+			}
+		}
+		var best int
+		for b := 1; b < 256; b++ {
+			if counts[b] > counts[best] || (counts[b] == counts[best] && qsum[b] > qsum[best]) {
+				best = b
+			}
+		}
+		s[i], reads[i], qsums[i] = byte(best), counts[best], qsum[best]
+	}
+	return s, reads, qsums
+}
+
+func init() {
+	RootCmd.AddCommand(collapseCmd)
+
+	collapseCmd.Flags().StringP("umi-regexp", "r", `_([ACGTN]+)$`, "regular expression for extracting UMIs, the first capture group is used")
+	collapseCmd.Flags().StringP("umi-in", "U", "id", `where to extract UMIs from, "id", "name" or "seq"`)
+	collapseCmd.Flags().StringP("method", "m", "plurality", `consensus method, "plurality" or "poa"`)
+	collapseCmd.Flags().IntP("band", "b", 100, "half-width of the band of POA alignment (0 for full alignment)")
+	collapseCmd.Flags().IntP("min-size", "s", 1, "minimum family size")
+	collapseCmd.Flags().IntP("qual-ascii-base", "", 33, "ASCII BASE, 33 for Phred+33")
+}
@@ -21,11 +21,19 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/cespare/xxhash"
 	"github.com/shenwei356/bio/seq"
@@ -48,6 +56,24 @@ Note:
   3. Some records in one file may have same sequences/IDs. They will ALL be
      retrieved if the sequence/ID was shared in multiple files.
      So the records number may be larger than that of the smallest file.
+  4. Records are retrieved by reading the first file (all files for union)
+     again, so it should not be STDIN.
+
+Set operations (-O/--set-op):
+  intersection  records of the first file shared by all files (default)
+  union         records of all files, only the first record of every
+                sequence/ID/name is retrieved
+  difference    records of the first file absent in all other files
+
+Membership matrix (-M/--matrix):
+  A tab-delimited table of numbers of records of every unique
+  sequence/ID/name (the first ID/name is shown) in every file.
+
+Disk-backed mode (-d/--disk):
+  Hashes are partitioned into temporary files in --tmp-dir and
+  processed partition by partition, so only the hashes selected by the
+  set operation are kept in memory (8 bytes each). Rows of the
+  membership matrix are not in the order of appearance in this mode.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -64,58 +90,97 @@ Note:
 		bySeq := getFlagBool(cmd, "by-seq")
 		byName := getFlagBool(cmd, "by-name")
 		ignoreCase := getFlagBool(cmd, "ignore-case")
+		setOp := getFlagString(cmd, "set-op")
+		matrixFile := getFlagString(cmd, "matrix")
+		disk := getFlagBool(cmd, "disk")
+		tmpDir := getFlagString(cmd, "tmp-dir")
+		nParts := getFlagPositiveInt(cmd, "partitions")
 
 		if bySeq && byName {
 			checkError(fmt.Errorf("only one/none of the flags -s (--by-seq) and -n (--by-name) is allowed"))
 		}
 
+		var selected func(counts []uint32) bool
+		switch setOp {
+		case "intersection":
+			selected = func(counts []uint32) bool {
+				for _, c := range counts {
+					if c == 0 {
+						return false
+					}
+				}
+				return true
+			}
+		case "union":
+			selected = func(counts []uint32) bool { return true }
+		case "difference":
+			selected = func(counts []uint32) bool {
+				if counts[0] == 0 {
+					return false
+				}
+				for _, c := range counts[1:] {
+					if c > 0 {
+						return false
+					}
+				}
+				return true
+			}
+		default:
+			checkError(fmt.Errorf("invalid value of flag -O/--set-op: %s, available: intersection, union, difference", setOp))
+		}
+
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
 		if len(files) < 2 {
 			checkError(errors.New("at least 2 files needed"))
 		}
+		for i, file := range files {
+			if isStdin(file) && (i == 0 || setOp == "union") {
+				checkError(fmt.Errorf("STDIN is not supported as the first file, or for union"))
+			}
+		}
 
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 		defer outfh.Close()
 
-		// target -> file -> struct{}
-		counter := make(map[uint64]map[string]struct{}, 1000)
+		keyOf := func(record *fastx.Record) uint64 {
+			if bySeq {
+				if ignoreCase {
+					return xxhash.Sum64(bytes.ToLower(record.Seq.Seq))
+				}
+				return xxhash.Sum64(record.Seq.Seq)
+			} else if byName {
+				if ignoreCase {
+					return xxhash.Sum64(bytes.ToLower(record.Name))
+				}
+				return xxhash.Sum64(record.Name)
+			} // byID
+			if ignoreCase {
+				return xxhash.Sum64(bytes.ToLower(record.ID))
+			}
+			return xxhash.Sum64(record.ID)
+		}
 
-		// target -> seqnames in firstFile
-		// note that it's []string, i.e., records may have same sequences
-		names := make(map[uint64][]string, 1000)
+		var counter commonCounter
+		if disk {
+			counter = newDiskCommonCounter(tmpDir, nParts, len(files), matrixFile != "")
+		} else {
+			counter = newMemCommonCounter(len(files), matrixFile != "")
+		}
 
 		var fastxReader *fastx.Reader
 		var record *fastx.Record
 
 		// read all files
-		var subject uint64
-		var checkFirstFile = true
-		var isFirstFile = true
-		var firstFile string
-		var filenames = make(map[string]int)
-		var ok bool
-		for _, file := range files {
+		for i, file := range files {
 			if !quiet {
 				log.Infof("read file: %s", file)
 			}
-			if checkFirstFile && !isStdin(file) {
-				firstFile = file
-				checkFirstFile = false
-			}
 
 			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
 			checkError(err)
 
-			// allowing finding common records in ONE file
-			if _, ok = filenames[file]; !ok {
-				filenames[file] = 1
-			} else {
-				filenames[file]++
-				file = fmt.Sprintf("%s_%d", file, filenames[file])
-			}
-
 			for {
 				record, err = fastxReader.Read()
 				if err != nil {
@@ -126,62 +191,49 @@ Note:
 					break
 				}
 
-				if bySeq {
-					if ignoreCase {
-						subject = xxhash.Sum64(bytes.ToLower(record.Seq.Seq))
-					} else {
-						subject = xxhash.Sum64(record.Seq.Seq)
-					}
-				} else if byName {
-					if ignoreCase {
-						subject = xxhash.Sum64(bytes.ToLower(record.Name))
-					} else {
-						subject = xxhash.Sum64(record.Name)
-					}
-				} else { // byID
-					if ignoreCase {
-						subject = xxhash.Sum64(bytes.ToLower(record.ID))
-					} else {
-						subject = xxhash.Sum64(record.ID)
-					}
+				if byName {
+					counter.add(keyOf(record), i, record.Name)
+				} else {
+					counter.add(keyOf(record), i, record.ID)
 				}
-
-				if _, ok = counter[subject]; !ok {
-					counter[subject] = make(map[string]struct{})
-				}
-				counter[subject][file] = struct{}{}
-
-				if isFirstFile {
-					if _, ok = names[subject]; !ok {
-						names[subject] = make([]string, 0, 1)
-					}
-					names[subject] = append(names[subject], string(record.Name))
-				}
-			}
-			if isFirstFile {
-				isFirstFile = false
 			}
 		}
 
-		// find common seqs
+		// set operation
 		if !quiet {
-			log.Info("find common seqs ...")
-		}
-		fileNum := len(files)
-		namesOK := make(map[string]struct{})
-		var n, n2 int
-		var seqname string
-		for subject, presence := range counter {
-			if len(presence) != fileNum {
-				continue
-			}
+			log.Infof("compute %s of %d files ...", setOp, len(files))
+		}
+		var matrixfh *xopen.Writer
+		if matrixFile != "" {
+			matrixfh, err = xopen.Wopen(matrixFile)
+			checkError(err)
+			defer matrixfh.Close()
 
-			n++
-			for _, seqname = range names[subject] {
-				n2++
-				namesOK[seqname] = struct{}{}
+			matrixfh.WriteString("key")
+			for _, file := range files {
+				matrixfh.WriteString("\t" + file)
 			}
+			matrixfh.WriteString("\n")
 		}
+		keys := make([]uint64, 0, 1024)
+		var line strings.Builder
+		checkError(counter.forEach(func(key uint64, counts []uint32, name string) {
+			if selected(counts) {
+				keys = append(keys, key)
+			}
+			if matrixfh != nil {
+				line.Reset()
+				line.WriteString(name)
+				for _, c := range counts {
+					line.WriteByte('\t')
+					line.WriteString(strconv.Itoa(int(c)))
+				}
+				line.WriteByte('\n')
+				matrixfh.WriteString(line.String())
+			}
+		}))
+		counter.close()
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
 
 		var t string
 		if byName {
@@ -191,41 +243,218 @@ Note:
 		} else {
 			t = "sequence IDs"
 		}
-		if n == 0 {
-			log.Infof("no common %s found", t)
+		if len(keys) == 0 {
+			log.Infof("no %s found for %s", t, setOp)
 			return
 		}
-		if !quiet {
-			log.Infof("%d unique %s found in %d files, which belong to %d records in the first file: %s",
-				n, t, fileNum, n2, firstFile)
+
+		// retrieve
+		retrieveFiles := files[:1]
+		if setOp == "union" {
+			retrieveFiles = files
+		}
+		// for union, only the first record of every key is retrieved
+		retrieved := make([]bool, len(keys))
+		var n2 int
+		var key uint64
+		var k int
+		for _, file := range retrieveFiles {
+			if !quiet {
+				log.Infof("retrieve seqs from file: %s", file)
+			}
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+
+				key = keyOf(record)
+				k = sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+				if k == len(keys) || keys[k] != key {
+					continue
+				}
+				if setOp == "union" {
+					if retrieved[k] {
+						continue
+					}
+					retrieved[k] = true
+				}
+				n2++
+				record.FormatToWriter(outfh, config.LineWidth)
+			}
 		}
 
 		if !quiet {
-			log.Infof("retrieve seqs from the first file: %s", firstFile)
+			log.Infof("%d unique %s found for %s of %d files, %d records retrieved",
+				len(keys), t, setOp, len(files), n2)
 		}
+	},
+}
 
-		// retrieve
-		fastxReader, err = fastx.NewReader(alphabet, firstFile, idRegexp)
+// commonCounter counts records of every key (hash of sequence/ID/name)
+// in every file.
+type commonCounter interface {
+	// add records a key in the i-th file, the name is only used in the
+	// membership matrix.
+	add(key uint64, i int, name []byte)
+	// forEach calls fn for every unique key.
+	forEach(fn func(key uint64, counts []uint32, name string)) error
+	close()
+}
+
+// memCommonCounter keeps all keys in memory.
+type memCommonCounter struct {
+	nFiles    int
+	keepNames bool
+
+	counts map[uint64][]uint32
+	keys   []uint64 // in order of appearance
+	names  map[uint64]string
+}
+
+func newMemCommonCounter(nFiles int, keepNames bool) *memCommonCounter {
+	c := &memCommonCounter{nFiles: nFiles, keepNames: keepNames,
+		counts: make(map[uint64][]uint32, 1000)}
+	if keepNames {
+		c.names = make(map[uint64]string, 1000)
+	}
+	return c
+}
+
+func (c *memCommonCounter) add(key uint64, i int, name []byte) {
+	counts, ok := c.counts[key]
+	if !ok {
+		counts = make([]uint32, c.nFiles)
+		c.counts[key] = counts
+		c.keys = append(c.keys, key)
+		if c.keepNames {
+			c.names[key] = string(name)
+		}
+	}
+	counts[i]++
+}
+
+func (c *memCommonCounter) forEach(fn func(key uint64, counts []uint32, name string)) error {
+	for _, key := range c.keys {
+		fn(key, c.counts[key], c.names[key])
+	}
+	return nil
+}
+
+func (c *memCommonCounter) close() {}
+
+// diskCommonCounter partitions keys into temporary files by their values,
+// and counts them partition by partition.
+type diskCommonCounter struct {
+	nFiles    int
+	keepNames bool
+
+	dir   string
+	files []string
+	fhs   []*os.File
+	bws   []*bufio.Writer
+	buf   []byte
+}
+
+func newDiskCommonCounter(tmpDir string, n int, nFiles int, keepNames bool) *diskCommonCounter {
+	dir, err := ioutil.TempDir(tmpDir, "seqkit-common")
+	checkError(err)
+	c := &diskCommonCounter{nFiles: nFiles, keepNames: keepNames, dir: dir,
+		files: make([]string, n), fhs: make([]*os.File, n), bws: make([]*bufio.Writer, n),
+		buf: make([]byte, 16)}
+	for i := 0; i < n; i++ {
+		c.files[i] = filepath.Join(dir, fmt.Sprintf("part_%d.bin", i))
+		c.fhs[i], err = os.Create(c.files[i])
 		checkError(err)
+		c.bws[i] = bufio.NewWriterSize(c.fhs[i], os.Getpagesize()*16)
+	}
+	return c
+}
+
+// add writes a record of key (8 bytes), file index (4 bytes), name length
+// (4 bytes) and the optional name.
+func (c *diskCommonCounter) add(key uint64, i int, name []byte) {
+	if !c.keepNames {
+		name = nil
+	}
+	binary.LittleEndian.PutUint64(c.buf[0:8], key)
+	binary.LittleEndian.PutUint32(c.buf[8:12], uint32(i))
+	binary.LittleEndian.PutUint32(c.buf[12:16], uint32(len(name)))
+	bw := c.bws[key%uint64(len(c.bws))]
+	_, err := bw.Write(c.buf)
+	checkError(err)
+	if len(name) > 0 {
+		_, err = bw.Write(name)
+		checkError(err)
+	}
+}
+
+func (c *diskCommonCounter) forEach(fn func(key uint64, counts []uint32, name string)) error {
+	var err error
+	for i := range c.bws {
+		if err = c.bws[i].Flush(); err != nil {
+			return err
+		}
+		if err = c.fhs[i].Close(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, 16)
+	var key uint64
+	var idx, l uint32
+	var name []byte
+	for _, file := range c.files {
+		fh, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		br := bufio.NewReaderSize(fh, os.Getpagesize()*16)
+
+		m := newMemCommonCounter(c.nFiles, c.keepNames)
 		for {
-			record, err = fastxReader.Read()
-			if err != nil {
+			if _, err = io.ReadFull(br, buf); err != nil {
 				if err == io.EOF {
 					break
 				}
-				checkError(err)
-				break
+				fh.Close()
+				return err
 			}
-			if fastxReader.IsFastq {
-				config.LineWidth = 0
-				fastx.ForcelyOutputFastq = true
-			}
-
-			if _, ok := namesOK[string(record.Name)]; ok {
-				record.FormatToWriter(outfh, config.LineWidth)
+			key = binary.LittleEndian.Uint64(buf[0:8])
+			idx = binary.LittleEndian.Uint32(buf[8:12])
+			l = binary.LittleEndian.Uint32(buf[12:16])
+			name = name[:0]
+			if l > 0 {
+				if cap(name) < int(l) {
+					name = make([]byte, l)
+				}
+				name = name[:l]
+				if _, err = io.ReadFull(br, name); err != nil {
+					fh.Close()
+					return err
+				}
 			}
+			m.add(key, int(idx), name)
 		}
-	},
+		fh.Close()
+
+		m.forEach(fn)
+	}
+	return nil
+}
+
+func (c *diskCommonCounter) close() {
+	os.RemoveAll(c.dir)
 }
 
 func init() {
@@ -234,4 +463,9 @@ func init() {
 	commonCmd.Flags().BoolP("by-name", "n", false, "match by full name instead of just id")
 	commonCmd.Flags().BoolP("by-seq", "s", false, "match by sequence")
 	commonCmd.Flags().BoolP("ignore-case", "i", false, "ignore case")
+	commonCmd.Flags().StringP("set-op", "O", "intersection", "set operation: intersection, union or difference")
+	commonCmd.Flags().StringP("matrix", "M", "", "save membership matrix to this file")
+	commonCmd.Flags().BoolP("disk", "d", false, "disk-backed mode for huge files, see details above")
+	commonCmd.Flags().StringP("tmp-dir", "", os.TempDir(), "directory for temporary files of -d/--disk")
+	commonCmd.Flags().IntP("partitions", "P", 64, "number of partitions (temporary files) of -d/--disk")
 }
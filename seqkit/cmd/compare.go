@@ -0,0 +1,280 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/cespare/xxhash"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "compare two FASTA/Q files and report added/removed/changed records",
+	Long: `compare two FASTA/Q files and report added/removed/changed records
+
+Records are matched by ID (default) or by sequence (-s/--by-seq). Records of
+the first file not matched in the second file are "removed", records of the
+second file not matched in the first one are "added", and matched records
+with any difference in sequence, header or quality are "changed".
+Records with duplicated keys are matched in the order of appearance.
+
+Output columns (tab-delimited):
+  status    "-" for removed, "+" for added, "~" for changed
+  id1       ID in the first file ("." for added records)
+  id2       ID in the second file ("." for removed records)
+  diff      comma-separated differences of changed records:
+              seq   sequences (when matched by ID)
+              id    IDs (when matched by sequence)
+              desc  descriptions, i.e., full headers (unless -D/--ignore-desc)
+              qual  quality strings
+
+Attention:
+  1. Only hashes of records in the first file are kept in memory.
+  2. The first file is read twice if removed records are saved
+     (-R/--removed-file), so it should not be STDIN.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		bySeq := getFlagBool(cmd, "by-seq")
+		ignoreCase := getFlagBool(cmd, "ignore-case")
+		ignoreDesc := getFlagBool(cmd, "ignore-desc")
+		removedFile := getFlagString(cmd, "removed-file")
+		addedFile := getFlagString(cmd, "added-file")
+		changedFile := getFlagString(cmd, "changed-file")
+		showSame := getFlagBool(cmd, "show-same")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+		if len(files) != 2 {
+			checkError(fmt.Errorf("exactly 2 files needed"))
+		}
+		file1, file2 := files[0], files[1]
+		if removedFile != "" && isStdin(file1) {
+			checkError(fmt.Errorf("the first file should not be STDIN when saving removed records (-R/--removed-file)"))
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var addedfh, changedfh *xopen.Writer
+		if addedFile != "" {
+			addedfh, err = xopen.Wopen(addedFile)
+			checkError(err)
+			defer addedfh.Close()
+		}
+		if changedFile != "" {
+			changedfh, err = xopen.Wopen(changedFile)
+			checkError(err)
+			defer changedfh.Close()
+		}
+
+		digest := func(record *fastx.Record) *cmpEntry {
+			e := &cmpEntry{id: string(record.ID)}
+			if ignoreCase {
+				e.seq = xxhash.Sum64(bytes.ToLower(record.Seq.Seq))
+			} else {
+				e.seq = xxhash.Sum64(record.Seq.Seq)
+			}
+			e.name = xxhash.Sum64(record.Name)
+			e.qual = xxhash.Sum64(record.Seq.Qual)
+			if bySeq {
+				e.key = e.seq
+			} else {
+				e.key = xxhash.Sum64(record.ID)
+			}
+			return e
+		}
+
+		// diff lists differences of two matched records
+		diff := func(a, b *cmpEntry) string {
+			d := make([]string, 0, 3)
+			if bySeq {
+				if a.id != b.id {
+					d = append(d, "id")
+				}
+			} else if a.seq != b.seq {
+				d = append(d, "seq")
+			}
+			if !ignoreDesc && a.name != b.name {
+				d = append(d, "desc")
+			}
+			if a.qual != b.qual {
+				d = append(d, "qual")
+			}
+			return strings.Join(d, ",")
+		}
+
+		var fastxReader *fastx.Reader
+		var record *fastx.Record
+
+		// records of the first file
+		entries := make([]*cmpEntry, 0, 1024)
+		index := make(map[uint64][]*cmpEntry, 1024)
+
+		fastxReader, err = fastx.NewReader(alphabet, file1, idRegexp)
+		checkError(err)
+		var e *cmpEntry
+		for {
+			record, err = fastxReader.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				checkError(err)
+				break
+			}
+			e = digest(record)
+			entries = append(entries, e)
+			index[e.key] = append(index[e.key], e)
+		}
+
+		outfh.WriteString("status\tid1\tid2\tdiff\n")
+
+		var nSame, nAdded, nChanged, nRemoved int
+		var hits []*cmpEntry
+		var d string
+		fastxReader, err = fastx.NewReader(alphabet, file2, idRegexp)
+		checkError(err)
+		for {
+			record, err = fastxReader.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				checkError(err)
+				break
+			}
+			e = digest(record)
+
+			hits = index[e.key]
+			if len(hits) == 0 {
+				nAdded++
+				outfh.WriteString(fmt.Sprintf("+\t.\t%s\t.\n", e.id))
+				if addedfh != nil {
+					record.FormatToWriter(addedfh, cmpLineWidth(record, config.LineWidth))
+				}
+				continue
+			}
+			hits[0].matched = true
+			d = diff(hits[0], e)
+			if d == "" {
+				nSame++
+				if showSame {
+					outfh.WriteString(fmt.Sprintf("=\t%s\t%s\t.\n", hits[0].id, e.id))
+				}
+			} else {
+				nChanged++
+				outfh.WriteString(fmt.Sprintf("~\t%s\t%s\t%s\n", hits[0].id, e.id, d))
+				if changedfh != nil {
+					record.FormatToWriter(changedfh, cmpLineWidth(record, config.LineWidth))
+				}
+			}
+			index[e.key] = hits[1:]
+		}
+
+		removed := make(map[*cmpEntry]struct{})
+		for _, e = range entries {
+			if e.matched {
+				continue
+			}
+			nRemoved++
+			outfh.WriteString(fmt.Sprintf("-\t%s\t.\t.\n", e.id))
+			removed[e] = struct{}{}
+		}
+
+		// retrieve removed records from the first file
+		if removedFile != "" {
+			removedfh, err := xopen.Wopen(removedFile)
+			checkError(err)
+			defer removedfh.Close()
+
+			fastxReader, err = fastx.NewReader(alphabet, file1, idRegexp)
+			checkError(err)
+			i := 0
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if _, ok := removed[entries[i]]; ok {
+					record.FormatToWriter(removedfh, cmpLineWidth(record, config.LineWidth))
+				}
+				i++
+			}
+		}
+
+		if !quiet {
+			log.Infof("%d identical, %d changed, %d removed, %d added records", nSame, nChanged, nRemoved, nAdded)
+		}
+	},
+}
+
+// cmpEntry holds hashes of a record.
+type cmpEntry struct {
+	key     uint64
+	id      string
+	seq     uint64
+	name    uint64
+	qual    uint64
+	matched bool
+}
+
+func cmpLineWidth(record *fastx.Record, lineWidth int) int {
+	if len(record.Seq.Qual) > 0 {
+		return 0
+	}
+	return lineWidth
+}
+
+func init() {
+	RootCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().BoolP("by-seq", "s", false, "match records by sequence instead of ID")
+	compareCmd.Flags().BoolP("ignore-case", "i", false, "compare sequences case-insensitively")
+	compareCmd.Flags().BoolP("ignore-desc", "D", false, "ignore differences in descriptions")
+	compareCmd.Flags().BoolP("show-same", "a", false, "also report identical records with status \"=\"")
+	compareCmd.Flags().StringP("removed-file", "R", "", "save removed records (from the first file) to this file")
+	compareCmd.Flags().StringP("added-file", "A", "", "save added records (from the second file) to this file")
+	compareCmd.Flags().StringP("changed-file", "C", "", "save changed records (from the second file) to this file")
+}
@@ -0,0 +1,236 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// complexityCmd represents the complexity command
+var complexityCmd = &cobra.Command{
+	Use:     "complexity",
+	Aliases: []string{"entropy"},
+	Short:   "compute entropy, linguistic complexity and homopolymer content of sequences",
+	Long: `compute entropy, linguistic complexity and homopolymer content of sequences
+
+Columns (tab-delimited):
+  seqID        sequence ID
+  start, end   1-based window positions, only with -W/--window
+  length       sequence (window) length
+  entropy      Shannon entropy (bits, 0-2) of A, C, G, T/U bases
+  LC           linguistic complexity, i.e., the sum of numbers of distinct
+               k-mers of k from 1 to -K/--max-word divided by the sum of
+               the maximal possible numbers, min(4^k, length-k+1).
+               K-mers with other bases are skipped.
+  maxHP        length of the longest homopolymer (N excluded)
+  HPfrac       fraction of bases in homopolymers of at least
+               -H/--min-homopolymer bases
+
+With -W/--window, statistics of sliding windows of a step of -s/--step
+are outputted instead, the last shorter window is also included.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		maxWord := getFlagPositiveInt(cmd, "max-word")
+		if maxWord > 12 {
+			checkError(fmt.Errorf("value of flag -K/--max-word should be in range of [1, 12]"))
+		}
+		minHP := getFlagPositiveInt(cmd, "min-homopolymer")
+		window := getFlagNonNegativeInt(cmd, "window")
+		step := getFlagPositiveInt(cmd, "step")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		if window > 0 {
+			outfh.WriteString("seqID\tstart\tend\tlength\tentropy\tLC\tmaxHP\tHPfrac\n")
+		} else {
+			outfh.WriteString("seqID\tlength\tentropy\tLC\tmaxHP\tHPfrac\n")
+		}
+
+		lc := newLinguisticComplexity(maxWord)
+		var sequence []byte
+		var e int
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+
+				sequence = record.Seq.Seq
+				if window == 0 {
+					outfh.WriteString(fmt.Sprintf("%s\t%s\n", record.ID, complexityStats(sequence, lc, minHP)))
+					continue
+				}
+				for i := 0; i < len(sequence); i += step {
+					e = i + window
+					if e > len(sequence) {
+						e = len(sequence)
+					}
+					outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\n", record.ID, i+1, e,
+						complexityStats(sequence[i:e], lc, minHP)))
+					if e == len(sequence) {
+						break
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(complexityCmd)
+
+	complexityCmd.Flags().IntP("max-word", "K", 7, "maximum word size (<= 12) for linguistic complexity")
+	complexityCmd.Flags().IntP("min-homopolymer", "H", 5, "minimum length of homopolymers counted in HPfrac")
+	complexityCmd.Flags().IntP("window", "W", 0, "window size, 0 for whole sequences")
+	complexityCmd.Flags().IntP("step", "s", 1, "step size of windows")
+}
+
+// complexityStats returns the formatted length, entropy, linguistic
+// complexity, longest homopolymer and homopolymer fraction of a sequence.
+func complexityStats(s []byte, lc *linguisticComplexity, minHP int) string {
+	if len(s) == 0 {
+		return "0\t0.0000\t0.0000\t0\t0.0000"
+	}
+	maxHP, hp := homopolymers(s, minHP)
+	return fmt.Sprintf("%d\t%.4f\t%.4f\t%d\t%.4f", len(s), baseEntropy(s), lc.compute(s),
+		maxHP, float64(hp)/float64(len(s)))
+}
+
+// baseEntropy returns the Shannon entropy (bits) of A, C, G, T/U bases.
+func baseEntropy(s []byte) float64 {
+	var counts [4]int
+	var n int
+	for _, b := range s {
+		if c := baseCode2bit[b]; c >= 0 {
+			counts[c]++
+			n++
+		}
+	}
+	var entropy, p float64
+	for _, m := range counts {
+		if m > 0 {
+			p = float64(m) / float64(n)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// homopolymers returns the length of the longest homopolymer (case ignored,
+// N excluded) and the number of bases in homopolymers of at least minLen
+// bases.
+func homopolymers(s []byte, minLen int) (longest int, bases int) {
+	var run int
+	var prev byte
+	for i, b := range s {
+		b &^= 32 // upper case
+		if i > 0 && b == prev && b != 'N' {
+			run++
+		} else {
+			if run >= minLen && prev != 'N' {
+				bases += run
+			}
+			run = 1
+		}
+		if b != 'N' && run > longest {
+			longest = run
+		}
+		prev = b
+	}
+	if run >= minLen && prev != 'N' {
+		bases += run
+	}
+	return longest, bases
+}
+
+// linguisticComplexity computes linguistic complexity with reused
+// tables of seen k-mers.
+type linguisticComplexity struct {
+	maxWord int
+	seen    [][]bool
+	touched []uint64
+}
+
+func newLinguisticComplexity(maxWord int) *linguisticComplexity {
+	lc := &linguisticComplexity{maxWord: maxWord, seen: make([][]bool, maxWord+1)}
+	for k := 1; k <= maxWord; k++ {
+		lc.seen[k] = make([]bool, 1<<uint(2*k))
+	}
+	return lc
+}
+
+func (lc *linguisticComplexity) compute(s []byte) float64 {
+	var observed, possible, max int
+	for k := 1; k <= lc.maxWord && k <= len(s); k++ {
+		seen := lc.seen[k]
+		lc.touched = lc.touched[:0]
+		ForEachKmer(s, k, false, func(code uint64, pos int) {
+			if !seen[code] {
+				seen[code] = true
+				lc.touched = append(lc.touched, code)
+			}
+		})
+		observed += len(lc.touched)
+		for _, code := range lc.touched {
+			seen[code] = false
+		}
+
+		max = len(s) - k + 1
+		if k < 32 && 1<<uint(2*k) < max {
+			max = 1 << uint(2*k)
+		}
+		possible += max
+	}
+	if possible == 0 {
+		return 0
+	}
+	return float64(observed) / float64(possible)
+}
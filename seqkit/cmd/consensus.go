@@ -0,0 +1,102 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// consensusCmd represents the consensus command
+var consensusCmd = &cobra.Command{
+	Use:   "consensus",
+	Short: "call consensus sequences of references from a BAM pileup",
+	Long: `call consensus sequences of references from a BAM pileup
+
+The majority base of the primary alignments passing -Q/--min-map-qual is
+called at every reference position, counting bases of a quality of at least
+-q/--min-base-qual. Insertions are ignored, and positions where the majority
+of the reads has a deletion are dropped.
+
+Positions with a depth below -d/--min-depth, or a strand bias (the absolute
+difference of the forward and reverse strand reads over the depth) above
+-B/--max-strand-bias, take the reference bases in lower case, or N with
+-n/--mask-n. These positions are written to -b/--bed along with the reason.
+
+The BAM file must be sorted by coordinate, as the pileup of only one
+reference is kept in memory.
+
+This is a shortcut of the Consensus tool of "seqkit bam -T", which could be
+combined with other tools in one pass of the BAM file.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		runtime.GOMAXPROCS(config.Threads)
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+		if len(files) != 1 {
+			checkError(fmt.Errorf("exactly one BAM file needed"))
+		}
+
+		ref := getFlagString(cmd, "ref")
+		if ref == "" {
+			checkError(fmt.Errorf("flag -r/--ref needed"))
+		}
+		maxBias := getFlagFloat64(cmd, "max-strand-bias")
+		if maxBias < 0 || maxBias > 1 {
+			checkError(fmt.Errorf("value of flag -B/--max-strand-bias should be in range of [0, 1]"))
+		}
+
+		var y strings.Builder
+		y.WriteString("Consensus:\n")
+		fmt.Fprintf(&y, "  Fasta: %s\n", strconv.Quote(config.OutFile))
+		fmt.Fprintf(&y, "  Ref: %s\n", strconv.Quote(ref))
+		if bed := getFlagString(cmd, "bed"); bed != "" {
+			fmt.Fprintf(&y, "  Bed: %s\n", strconv.Quote(bed))
+		}
+		fmt.Fprintf(&y, "  MinDepth: %d\n", getFlagNonNegativeInt(cmd, "min-depth"))
+		fmt.Fprintf(&y, "  MaxStrandBias: %g\n", maxBias)
+		fmt.Fprintf(&y, "  MinBaseQual: %d\n", getFlagNonNegativeInt(cmd, "min-base-qual"))
+		fmt.Fprintf(&y, "  MinMapQ: %d\n", getFlagNonNegativeInt(cmd, "min-map-qual"))
+		fmt.Fprintf(&y, "  MaskN: %t\n", getFlagBool(cmd, "mask-n"))
+		fmt.Fprintf(&y, "  LineWidth: %d\n", config.LineWidth)
+		y.WriteString("Sink: True\n")
+
+		BamToolbox(y.String(), files[0], "-", config.Quiet, config.Quiet, config.Threads, 0, 0, -1, false)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(consensusCmd)
+
+	consensusCmd.Flags().StringP("ref", "r", "", "reference FASTA file (plain or bgzipped)")
+	consensusCmd.Flags().IntP("min-depth", "d", 3, "minimum depth of called positions")
+	consensusCmd.Flags().IntP("min-base-qual", "q", 0, "minimum base quality of counted bases")
+	consensusCmd.Flags().IntP("min-map-qual", "Q", 0, "minimum mapping quality of counted alignments")
+	consensusCmd.Flags().Float64P("max-strand-bias", "B", 1, "maximum strand bias of called positions")
+	consensusCmd.Flags().BoolP("mask-n", "n", false, "mask positions of low depth or strong strand bias with N instead of lower case reference bases")
+	consensusCmd.Flags().StringP("bed", "b", "", "save masked positions to this BED file")
+}
@@ -0,0 +1,317 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// consensusFromVCFCmd represents the consensus-from-vcf command
+var consensusFromVCFCmd = &cobra.Command{
+	Use:   "consensus-from-vcf",
+	Short: "apply VCF variants to reference sequences",
+	Long: `apply VCF variants to reference sequences
+
+Variants of a plain or (b)gzipped VCF file (-v/--vcf) are applied to
+reference sequences with the same IDs as CHROM, and the alternate
+sequences are outputted with the reference IDs. SNVs, MNPs and indels
+are supported, while symbolic (e.g., <DEL>), breakend and spanning
+deletion (*) alleles are skipped.
+
+Alleles to apply:
+  1. Without -s/--sample, the first ALT allele of every record.
+  2. With -s/--sample, the allele of the haplotype (-H/--haplotype) in the
+     GT field of the sample. Records with reference or missing alleles
+     are skipped. For unphased genotypes, alleles are taken in the
+     written order.
+
+Variants with REF unmatched with the reference, or overlapping previously
+applied ones (sorted by positions), are skipped with warnings.
+
+Coordinates of the reference and the alternate sequences can be mapped
+with a chain file (-c/--chain) in the UCSC chain format, where the
+reference sequences are targets and the alternate sequences are queries.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		vcfFile := getFlagString(cmd, "vcf")
+		if vcfFile == "" {
+			checkError(fmt.Errorf("flag -v/--vcf needed"))
+		}
+		sample := getFlagString(cmd, "sample")
+		hap := getFlagPositiveInt(cmd, "haplotype")
+		if hap > 2 {
+			checkError(fmt.Errorf("value of flag -H/--haplotype should be 1 or 2"))
+		}
+		passOnly := getFlagBool(cmd, "pass-only")
+		chainFile := getFlagString(cmd, "chain")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		if !quiet {
+			log.Info("read VCF file ...")
+		}
+		vars, nVars, err := readVCFAlleles(vcfFile, sample, hap, passOnly, quiet)
+		checkError(err)
+		if !quiet {
+			log.Infof("%d variants loaded", nVars)
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var chainfh *xopen.Writer
+		if chainFile != "" {
+			chainfh, err = xopen.Wopen(chainFile)
+			checkError(err)
+			defer chainfh.Close()
+		}
+
+		var nApplied, nChains int
+		var edits, applied []seqEdit
+		var ref []byte
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+
+				ref = record.Seq.Seq
+				edits = edits[:0]
+				for _, v := range vars[string(record.ID)] {
+					if v.check(record.ID, ref) {
+						edits = append(edits, v.edit())
+					}
+				}
+				record.Seq.Seq, applied = applySeqEdits(record.ID, ref, edits)
+				nApplied += len(applied)
+
+				if chainfh != nil {
+					nChains++
+					writeChain(chainfh, nChains, string(record.ID), len(ref), len(record.Seq.Seq), applied)
+				}
+
+				record.FormatToWriter(outfh, lineWidth)
+			}
+		}
+
+		if !quiet {
+			log.Infof("%d variants applied", nApplied)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(consensusFromVCFCmd)
+
+	consensusFromVCFCmd.Flags().StringP("vcf", "v", "", "VCF file (plain or (b)gzipped)")
+	consensusFromVCFCmd.Flags().StringP("sample", "s", "", "apply alleles in the GT field of this sample")
+	consensusFromVCFCmd.Flags().IntP("haplotype", "H", 1, "haplotype (1 or 2) of the genotypes of -s/--sample to apply")
+	consensusFromVCFCmd.Flags().BoolP("pass-only", "P", false, `only apply records with FILTER of "PASS" or "."`)
+	consensusFromVCFCmd.Flags().StringP("chain", "c", "", "save a chain file mapping coordinates of reference sequences to alternate sequences")
+}
+
+// readVCFAlleles reads alleles to apply from a VCF file, grouped by CHROM.
+// The first ALT allele is used if sample is empty, otherwise the allele
+// of the haplotype (1-based) in the GT field of the sample.
+func readVCFAlleles(file string, sample string, hap int, passOnly bool, quiet bool) (map[string][]_mutateVar, int, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer fh.Close()
+
+	vars := make(map[string][]_mutateVar)
+	var n, nSkipped int
+	sampleIdx := -1
+	var items, alts, gt []string
+	var pos, gtIdx, a int
+	var alt string
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 0, 1<<20), 1<<30)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" || strings.HasPrefix(line, "##") {
+			continue
+		}
+		items = strings.Split(line, "\t")
+		if line[0] == '#' { // #CHROM header
+			if sample == "" {
+				continue
+			}
+			for i := 9; i < len(items); i++ {
+				if items[i] == sample {
+					sampleIdx = i
+					break
+				}
+			}
+			if sampleIdx < 0 {
+				return nil, 0, fmt.Errorf("sample not found in VCF: %s", sample)
+			}
+			continue
+		}
+		if len(items) < 8 {
+			return nil, 0, fmt.Errorf("invalid VCF line, at least 8 columns expected: %s", line)
+		}
+		if sample != "" && sampleIdx < 0 {
+			return nil, 0, fmt.Errorf("#CHROM header line missing in VCF")
+		}
+		if passOnly && items[6] != "PASS" && items[6] != "." {
+			continue
+		}
+		pos, err = strconv.Atoi(items[1])
+		if err != nil || pos < 1 {
+			return nil, 0, fmt.Errorf("%s: bad position: %s", items[0], items[1])
+		}
+		alts = strings.Split(items[4], ",")
+
+		if sample == "" {
+			alt = alts[0]
+		} else {
+			if len(items) <= sampleIdx {
+				return nil, 0, fmt.Errorf("%s:%d: genotype of sample missing", items[0], pos)
+			}
+			gtIdx = -1
+			for i, f := range strings.Split(items[8], ":") {
+				if f == "GT" {
+					gtIdx = i
+					break
+				}
+			}
+			if gtIdx < 0 {
+				return nil, 0, fmt.Errorf("%s:%d: GT field missing", items[0], pos)
+			}
+			gt = strings.Split(items[sampleIdx], ":")
+			if gtIdx >= len(gt) {
+				continue
+			}
+			gt = strings.FieldsFunc(gt[gtIdx], func(r rune) bool { return r == '/' || r == '|' })
+			if len(gt) == 0 {
+				continue
+			}
+			if hap > len(gt) { // haploid
+				a, err = strconv.Atoi(gt[0])
+			} else {
+				a, err = strconv.Atoi(gt[hap-1])
+			}
+			if err != nil || a == 0 { // missing or reference
+				continue
+			}
+			if a > len(alts) {
+				return nil, 0, fmt.Errorf("%s:%d: bad GT: %s", items[0], pos, items[sampleIdx])
+			}
+			alt = alts[a-1]
+		}
+
+		if alt == "." || alt == "*" || alt == "" || strings.ContainsAny(alt, "<>[]") {
+			nSkipped++
+			continue
+		}
+		vars[items[0]] = append(vars[items[0]], _mutateVar{pos: pos, ref: []byte(items[3]), alt: []byte(alt)})
+		n++
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if nSkipped > 0 && !quiet {
+		log.Warningf("%d records with unsupported ALT alleles skipped", nSkipped)
+	}
+	return vars, n, nil
+}
+
+// writeChain writes a UCSC chain of a reference sequence (target) and its
+// alternate sequence (query) from applied edits sorted by positions.
+// Substitutions of equal lengths are kept in aligned blocks.
+func writeChain(w io.Writer, id int, name string, tSize, qSize int, edits []seqEdit) {
+	type gap struct {
+		t, dt, dq int
+	}
+	gaps := make([]gap, 0, len(edits))
+	var dt, dq int
+	for _, e := range edits {
+		dt, dq = e.end-e.start, len(e.alt)
+		if dt == dq {
+			continue
+		}
+		if n := len(gaps); n > 0 && gaps[n-1].t+gaps[n-1].dt == e.start { // adjacent
+			gaps[n-1].dt += dt
+			gaps[n-1].dq += dq
+			continue
+		}
+		gaps = append(gaps, gap{e.start, dt, dq})
+	}
+
+	tStart, qStart, tEnd, qEnd := 0, 0, tSize, qSize
+	if len(gaps) > 0 && gaps[0].t == 0 {
+		tStart, qStart = gaps[0].dt, gaps[0].dq
+		gaps = gaps[1:]
+	}
+	if n := len(gaps); n > 0 && gaps[n-1].t+gaps[n-1].dt == tSize {
+		tEnd, qEnd = tEnd-gaps[n-1].dt, qEnd-gaps[n-1].dq
+		gaps = gaps[:n-1]
+	}
+	if tStart >= tEnd || qStart >= qEnd {
+		return
+	}
+
+	score := tEnd - tStart
+	for _, g := range gaps {
+		score -= g.dt
+	}
+	fmt.Fprintf(w, "chain %d %s %d + %d %d %s %d + %d %d %d\n",
+		score, name, tSize, tStart, tEnd, name, qSize, qStart, qEnd, id)
+	t := tStart
+	for _, g := range gaps {
+		fmt.Fprintf(w, "%d\t%d\t%d\n", g.t-t, g.dt, g.dq)
+		t = g.t + g.dt
+	}
+	fmt.Fprintf(w, "%d\n\n", tEnd-t)
+}
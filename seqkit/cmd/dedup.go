@@ -0,0 +1,345 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// dedupCmd represents the dedup command
+var dedupCmd = &cobra.Command{
+	Use:     "dedup",
+	Aliases: []string{"dedup-by-seq"},
+	Short:   "remove near-identical sequences by clustering MinHash sketches",
+	Long: `remove near-identical sequences by clustering MinHash sketches
+
+Unlike "seqkit rmdup -s", which only removes identical sequences, this
+command collapses near-identical sequences, e.g., amplicon or UMI families.
+
+Attentions:
+  1. The records are sorted by length (or mean quality with -b qual) and
+     assigned to the first representative with an estimated identity of at
+     least -I/--min-identity, otherwise they become new representatives.
+  2. The identity is estimated from the Jaccard index of the bottom-s
+     sketches of the (canonical) k-mers as in Mash: 1 + ln(2J/(1+J))/k,
+     so sequences of very different lengths are not clustered together.
+  3. Sequences shorter than the k-mer size are always kept.
+  4. All records are kept in memory, the representatives are written in
+     the input order.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		k := getFlagPositiveInt(cmd, "kmer-size")
+		sketchSize := getFlagPositiveInt(cmd, "sketch-size")
+		minIdentity := getFlagFloat64(cmd, "min-identity")
+		by := getFlagString(cmd, "by")
+		onlyPositive := getFlagBool(cmd, "only-positive-strand")
+		dupFile := getFlagString(cmd, "dup-seqs-file")
+		numFile := getFlagString(cmd, "dup-num-file")
+
+		if k > 32 {
+			checkError(fmt.Errorf("value of flag -k/--kmer-size should be in range of [1, 32]"))
+		}
+		if minIdentity <= 0 || minIdentity > 1 {
+			checkError(fmt.Errorf("value of flag -I/--min-identity should be in range of (0, 1]"))
+		}
+		if by != "length" && by != "qual" {
+			checkError(fmt.Errorf("invalid value of flag -b/--by: %s, available: length, qual", by))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		var records []*dedupRecord
+		isFastq := false
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					isFastq = true
+				}
+				r := &dedupRecord{record: record.Clone(), order: len(records)}
+				if by == "qual" && len(record.Seq.Qual) > 0 {
+					r.score = avgQual(record.Seq, 33)
+				} else {
+					r.score = float64(len(record.Seq.Seq))
+				}
+				r.sketch = minHashSketch(record.Seq.Seq, k, sketchSize, !onlyPositive)
+				records = append(records, r)
+			}
+		}
+		if isFastq {
+			lineWidth = 0
+			fastx.ForcelyOutputFastq = true
+		}
+
+		clusters := clusterSketches(records, k, sketchSize, minIdentity)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+		var outfhDup *xopen.Writer
+		if len(dupFile) > 0 {
+			outfhDup, err = xopen.Wopen(dupFile)
+			checkError(err)
+			defer outfhDup.Close()
+		}
+
+		var removed int
+		for _, r := range records {
+			if r.cluster.rep == r {
+				r.record.FormatToWriter(outfh, lineWidth)
+			} else {
+				removed++
+				if outfhDup != nil {
+					r.record.FormatToWriter(outfhDup, lineWidth)
+				}
+			}
+		}
+
+		if removed > 0 && len(numFile) > 0 {
+			outfhNum, err := xopen.Wopen(numFile)
+			checkError(err)
+			defer outfhNum.Close()
+
+			list := new(listOfStringSlice)
+			for _, c := range clusters {
+				if len(c.members) > 1 {
+					ids := make([]string, len(c.members))
+					for i, m := range c.members {
+						ids[i] = string(m.record.ID)
+					}
+					list.data = append(list.data, ids)
+				}
+			}
+			sort.Stable(list)
+			for _, l := range list.data {
+				outfhNum.WriteString(fmt.Sprintf("%d\t%s\n", len(l), strings.Join(l, ", ")))
+			}
+		}
+
+		if !quiet {
+			log.Infof("%d near-duplicated records removed, %d clusters", removed, len(clusters))
+		}
+	},
+}
+
+type dedupRecord struct {
+	record  *fastx.Record
+	order   int
+	score   float64  // length or mean quality
+	sketch  []uint64 // sorted
+	cluster *dedupCluster
+}
+
+type dedupCluster struct {
+	rep     *dedupRecord
+	members []*dedupRecord // the representative first
+}
+
+// clusterSketches assigns the records to clusters greedily, in decreasing
+// order of their scores. Candidate representatives are looked up by the
+// shared sketch hashes.
+func clusterSketches(records []*dedupRecord, k int, sketchSize int, minIdentity float64) []*dedupCluster {
+	sorted := make([]*dedupRecord, len(records))
+	copy(sorted, records)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].score > sorted[j].score })
+
+	var clusters []*dedupCluster
+	index := make(map[uint64][]int) // hash -> clusters
+	for _, r := range sorted {
+		shared := make(map[int]int)
+		for _, h := range r.sketch {
+			for _, c := range index[h] {
+				shared[c]++
+			}
+		}
+		best, bestIdentity := -1, 0.0
+		for c := range shared {
+			identity := mashIdentity(r.sketch, clusters[c].rep.sketch, k, sketchSize)
+			if identity >= minIdentity && (identity > bestIdentity || (identity == bestIdentity && c < best)) {
+				best, bestIdentity = c, identity
+			}
+		}
+		if best >= 0 {
+			r.cluster = clusters[best]
+			r.cluster.members = append(r.cluster.members, r)
+			continue
+		}
+
+		c := &dedupCluster{rep: r, members: []*dedupRecord{r}}
+		r.cluster = c
+		for _, h := range r.sketch {
+			index[h] = append(index[h], len(clusters))
+		}
+		clusters = append(clusters, c)
+	}
+	return clusters
+}
+
+// minHashSketch returns the sorted bottom-s hashes of the k-mers of a
+// sequence, using canonical k-mers if canonical is true. K-mers with bases
+// other than ACGT are skipped.
+func minHashSketch(s []byte, k int, size int, canonical bool) []uint64 {
+	if len(s) < k {
+		return nil
+	}
+	mask := uint64(1)<<(2*uint(k)) - 1
+	if k == 32 {
+		mask = math.MaxUint64
+	}
+	shift := 2 * uint(k-1)
+	hashes := make([]uint64, 0, len(s)-k+1)
+	var fwd, rev uint64
+	var l int
+	for _, b := range s {
+		var code uint64
+		switch b {
+		case 'A', 'a':
+			code = 0
+		case 'C', 'c':
+			code = 1
+		case 'G', 'g':
+			code = 2
+		case 'T', 't', 'U', 'u':
+			code = 3
+		default:
+			l = 0
+			continue
+		}
+		fwd = (fwd<<2 | code) & mask
+		rev = rev>>2 | (3-code)<<shift
+		if l++; l < k {
+			continue
+		}
+		kmer := fwd
+		if canonical && rev < fwd {
+			kmer = rev
+		}
+		hashes = append(hashes, hash64(kmer))
+	}
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	sketch := make([]uint64, 0, size)
+	for i, h := range hashes {
+		if i > 0 && h == hashes[i-1] {
+			continue
+		}
+		if len(sketch) == size {
+			break
+		}
+		sketch = append(sketch, h)
+	}
+	return sketch
+}
+
+// hash64 is the finalizer of MurmurHash3, spreading k-mer codes.
+func hash64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// mashIdentity estimates the identity of two sequences from the Jaccard index
+// of the bottom-s sketch of their union.
+func mashIdentity(a, b []uint64, k int, size int) float64 {
+	shared, n := sketchShared(a, b, size)
+	if shared == 0 {
+		return 0
+	}
+	return mashANI(float64(shared)/float64(n), k)
+}
+
+// sketchShared returns the number of shared hashes in the bottom-size hashes
+// of the union of two sorted sketches, and the number of these hashes.
+func sketchShared(a, b []uint64, size int) (shared int, n int) {
+	var i, j int
+	for n < size && (i < len(a) || j < len(b)) {
+		switch {
+		case j == len(b) || (i < len(a) && a[i] < b[j]):
+			i++
+		case i == len(a) || b[j] < a[i]:
+			j++
+		default:
+			shared++
+			i++
+			j++
+		}
+		n++
+	}
+	return shared, n
+}
+
+// mashANI converts a Jaccard index to the identity, i.e., 1 - Mash distance.
+func mashANI(jaccard float64, k int) float64 {
+	if jaccard <= 0 {
+		return 0
+	}
+	identity := 1 + math.Log(2*jaccard/(1+jaccard))/float64(k)
+	if identity < 0 {
+		identity = 0
+	}
+	return identity
+}
+
+func init() {
+	RootCmd.AddCommand(dedupCmd)
+
+	dedupCmd.Flags().IntP("kmer-size", "k", 15, "k-mer size (<= 32)")
+	dedupCmd.Flags().IntP("sketch-size", "s", 200, "number of the minimum k-mer hashes in the sketches")
+	dedupCmd.Flags().Float64P("min-identity", "I", 0.95, "minimum estimated identity to a representative")
+	dedupCmd.Flags().StringP("by", "b", "length", `choose the representatives by "length" or "qual" (mean quality of FASTQ)`)
+	dedupCmd.Flags().BoolP("only-positive-strand", "P", false, "only compare the positive strands")
+	dedupCmd.Flags().StringP("dup-seqs-file", "d", "", "file to save removed seqs")
+	dedupCmd.Flags().StringP("dup-num-file", "D", "", "file to save number and list of clustered seqs, representatives first")
+}
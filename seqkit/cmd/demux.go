@@ -0,0 +1,303 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// demuxCmd represents the demux command
+var demuxCmd = &cobra.Command{
+	Use:   "demux",
+	Short: "demultiplex reads by barcodes given in a sample sheet",
+	Long: `demultiplex reads by barcodes given in a sample sheet
+
+The sample sheet (-b/--barcodes) is a tab-delimited file with the sample
+name and one or two barcodes per line, lines starting with "#" are ignored:
+
+  sample1    ACGTACGT
+  sample2    TGCATGCA    GGTTAACC
+
+The first barcode is searched in the 5' window of the reads (--window5),
+the second one, if given, in the 3' window (--window3), as they appear
+in the reads. Samples with two barcodes match only if both barcodes are
+found, so combinatorial dual barcodes are supported. Reads matching no or
+more than one sample equally well are written to the "unassigned" file.
+
+Outputs are written to -O/--out-dir as $sample$ext, where the extension is
+kept from the input file, along with a summary (summary.tsv) of the reads
+and bases of every sample.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		sheet := getFlagString(cmd, "barcodes")
+		maxMismatch := getFlagNonNegativeInt(cmd, "max-mismatch")
+		window5 := getFlagNonNegativeInt(cmd, "window5")
+		window3 := getFlagNonNegativeInt(cmd, "window3")
+		trim := getFlagBool(cmd, "trim")
+		outdir := getFlagString(cmd, "out-dir")
+		force := getFlagBool(cmd, "force")
+
+		if sheet == "" {
+			checkError(fmt.Errorf("flag -b/--barcodes needed"))
+		}
+		samples, err := readBarcodeSheet(sheet)
+		checkError(err)
+
+		prepareOutDir(outdir, force)
+		ext := ".fastx"
+		if !isStdin(files[0]) {
+			_, ext = filepathTrimExtension(files[0])
+		}
+		stats := make([]demuxStats, len(samples)+1) // unassigned last
+		outfhs := make([]*xopen.Writer, len(samples)+1)
+		for i := range outfhs {
+			name := "unassigned"
+			if i < len(samples) {
+				name = samples[i].name
+			}
+			stats[i].name = name
+			outfhs[i], err = xopen.Wopen(filepath.Join(outdir, name+ext))
+			checkError(err)
+		}
+
+		var ambiguous int
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					lineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+
+				i, start, end, ok := demuxRecord(record.Seq.Seq, samples, maxMismatch, window5, window3)
+				if !ok {
+					if i < 0 {
+						ambiguous++
+					}
+					i = len(samples)
+				} else if trim {
+					record.Seq = record.Seq.SubSeq(start+1, end)
+				}
+				stats[i].reads++
+				stats[i].bases += len(record.Seq.Seq)
+				record.FormatToWriter(outfhs[i], lineWidth)
+			}
+		}
+		for _, outfh := range outfhs {
+			checkError(outfh.Close())
+		}
+
+		var total int
+		for _, s := range stats {
+			total += s.reads
+		}
+		fh, err := os.Create(filepath.Join(outdir, "summary.tsv"))
+		checkError(err)
+		w := bufio.NewWriter(fh)
+		w.WriteString("sample\treads\tbases\tfraction\n")
+		for _, s := range stats {
+			frac := 0.0
+			if total > 0 {
+				frac = float64(s.reads) / float64(total)
+			}
+			w.WriteString(fmt.Sprintf("%s\t%d\t%d\t%.4f\n", s.name, s.reads, s.bases, frac))
+		}
+		checkError(w.Flush())
+		checkError(fh.Close())
+
+		if !quiet {
+			log.Infof("%d of %d reads assigned to %d samples, %d ambiguous", total-stats[len(samples)].reads, total, len(samples), ambiguous)
+		}
+	},
+}
+
+type demuxSample struct {
+	name     string
+	barcode5 []byte
+	barcode3 []byte
+}
+
+type demuxStats struct {
+	name  string
+	reads int
+	bases int
+}
+
+// readBarcodeSheet reads the sample names and barcodes.
+func readBarcodeSheet(file string) ([]demuxSample, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var samples []demuxSample
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		items := strings.Fields(line)
+		if len(items) < 2 || len(items) > 3 {
+			return nil, fmt.Errorf("invalid line in sample sheet, sample name and one or two barcodes expected: %s", line)
+		}
+		if items[0] == "unassigned" || names[items[0]] {
+			return nil, fmt.Errorf("invalid or duplicated sample name in sample sheet: %s", items[0])
+		}
+		names[items[0]] = true
+		s := demuxSample{name: items[0], barcode5: []byte(strings.ToUpper(items[1]))}
+		if len(items) == 3 {
+			s.barcode3 = []byte(strings.ToUpper(items[2]))
+		}
+		samples = append(samples, s)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples in sample sheet: %s", file)
+	}
+	return samples, nil
+}
+
+// demuxRecord returns the sample of a read and the region of the read between
+// the barcodes (0-based, end exclusive). The sample index is -1 if several
+// samples match with the same number of mismatches.
+func demuxRecord(s []byte, samples []demuxSample, maxMismatch, window5, window3 int) (int, int, int, bool) {
+	best, bestMis, tie := -1, maxMismatch+1, false
+	var bestStart, bestEnd int
+	for i, smp := range samples {
+		pos5, mis5 := searchBarcode(s, smp.barcode5, 0, window5, maxMismatch)
+		if pos5 < 0 {
+			continue
+		}
+		start, end, mis := pos5+len(smp.barcode5), len(s), mis5
+		if smp.barcode3 != nil {
+			from := len(s) - window3
+			if window3 < len(smp.barcode3) {
+				from = len(s) - len(smp.barcode3)
+			}
+			if from < start {
+				from = start
+			}
+			pos3, mis3 := searchBarcode(s, smp.barcode3, from, len(s)-from, maxMismatch-mis5)
+			if pos3 < 0 {
+				continue
+			}
+			end, mis = pos3, mis5+mis3
+		}
+		if mis < bestMis {
+			best, bestMis, tie = i, mis, false
+			bestStart, bestEnd = start, end
+		} else if mis == bestMis {
+			tie = true
+		}
+	}
+	if best < 0 {
+		return -2, 0, 0, false
+	}
+	if tie {
+		return -1, 0, 0, false
+	}
+	return best, bestStart, bestEnd, true
+}
+
+// searchBarcode returns the position of the best hit of a barcode in the
+// window s[from:from+window] with at most maxMismatch mismatches, or -1.
+// The window is extended to the length of the barcode.
+func searchBarcode(s []byte, barcode []byte, from int, window int, maxMismatch int) (int, int) {
+	if window < len(barcode) {
+		window = len(barcode)
+	}
+	last := from + window - len(barcode)
+	if last > len(s)-len(barcode) {
+		last = len(s) - len(barcode)
+	}
+	bestPos, bestMis := -1, maxMismatch+1
+	for p := from; p <= last; p++ {
+		mis := 0
+		for j, b := range barcode {
+			c := s[p+j]
+			if c >= 'a' {
+				c -= 'a' - 'A'
+			}
+			if c != b {
+				if mis++; mis >= bestMis {
+					break
+				}
+			}
+		}
+		if mis < bestMis {
+			bestPos, bestMis = p, mis
+			if mis == 0 {
+				break
+			}
+		}
+	}
+	return bestPos, bestMis
+}
+
+func init() {
+	RootCmd.AddCommand(demuxCmd)
+
+	demuxCmd.Flags().StringP("barcodes", "b", "", "tab-delimited sample sheet with sample names and one or two barcodes")
+	demuxCmd.Flags().IntP("max-mismatch", "m", 1, "maximum number of mismatches of the barcodes (in total for two barcodes)")
+	demuxCmd.Flags().IntP("window5", "", 0, "length of the 5' window searched for the first barcode (default the barcode length)")
+	demuxCmd.Flags().IntP("window3", "", 0, "length of the 3' window searched for the second barcode (default the barcode length)")
+	demuxCmd.Flags().BoolP("trim", "T", false, "remove the barcodes and the bases outside of them")
+	demuxCmd.Flags().StringP("out-dir", "O", "demux", "output directory")
+	demuxCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+}
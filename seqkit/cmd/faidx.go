@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"regexp"
@@ -28,6 +29,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fai"
 	"github.com/shenwei356/bio/seqio/fastx"
 	"github.com/shenwei356/util/byteutil"
@@ -47,6 +49,13 @@ This command is similar with "samtools faidx" but has some extra features:
   2. support regular expression as sequence ID with flag -r
   3. if you have large number of IDs, you can use:
 		seqkit faidx seqs.fasta --infile-list IDs.txt
+  4. regions are given as arguments or in a file (-l/--region-file) in
+     region strings or BED format, with thousands separators allowed,
+     e.g., chr1:100-200 chr2:5,000-6,000
+  5. reverse complement sequences are returned for regions with strand
+     suffixes ":-" or "(-)", e.g., chr1:100-200:-
+  6. an existing .seqkit.fai created by other seqkit commands is reused,
+     and bgzip-compressed FASTA files are supported
 
 The definition of region is 1-based and with some custom design.
 
@@ -62,6 +71,7 @@ Examples:
 		fullHead := getFlagBool(cmd, "full-head")
 		ignoreCase := getFlagBool(cmd, "ignore-case")
 		useRegexp := getFlagBool(cmd, "use-regexp")
+		regionFile := getFlagString(cmd, "region-file")
 
 		files := getFileListFromArgsAndFile(cmd, args, false, "infile-list", false)
 
@@ -72,53 +82,51 @@ Examples:
 		}
 
 		if strings.HasSuffix(strings.ToLower(file), ".gz") {
-			checkError(fmt.Errorf("gzipped file not supported"))
+			if bgzf, _ := isBgzfFile(file); !bgzf {
+				checkError(fmt.Errorf("gzipped file not supported, please compress it with bgzip"))
+			}
+		}
+
+		if useRegexp && regionFile != "" {
+			checkError(fmt.Errorf("flag -l (--region-file) not allowed when giving flag -r (--use-regexp)"))
 		}
 
 		outfh, err := xopen.Wopen(config.OutFile)
 		checkError(err)
 		defer outfh.Close()
 
-		// create and read .fai
-		var idx fai.Index
+		// create and read .fai, an existing .seqkit.fai is reused
+		// even if full header is not needed.
 		var fileFai string
 		var idRegexp string
+		var parseID bool
 		if fullHead {
 			fileFai = file + ".seqkit.fai"
 			idRegexp = "^(.+)$"
+			parseID = true
+		} else if fileNotExists(file+".fai") && !fileNotExists(file+".seqkit.fai") {
+			fileFai = file + ".seqkit.fai"
+			parseID = true
 		} else {
 			fileFai = file + ".fai"
 			idRegexp = fastx.DefaultIDRegexp
 		}
-		if fileNotExists(fileFai) {
-			if !quiet {
-				log.Infof("create FASTA index for %s", file)
-			}
-			idx, err = fai.CreateWithIDRegexp(file, fileFai, idRegexp)
-			checkError(err)
-		} else {
-			idx, err = fai.Read(fileFai)
-			checkError(err)
-		}
+		ref, err := newRefWithFaidx(file, fileFai, idRegexp, false, quiet)
+		checkError(err)
 
-		if len(files) == 1 { // just creat .fai file
+		if len(files) == 1 && regionFile == "" { // just creat .fai file
 			return
 		}
 
-		var faidx *fai.Faidx
-		faidx, err = fai.NewWithIndex(file, idx)
-		checkError(err)
-		defer faidx.Close()
-
 		// save id and header in a map(id:head)
 		id2head := make(map[string]string)
 		var idRe *regexp.Regexp
-		if fullHead {
+		if parseID {
 			idRe, _ = regexp.Compile(fastx.DefaultIDRegexp)
 		}
 		var id string
-		for head := range idx {
-			if fullHead {
+		for head := range ref.lengths {
+			if parseID {
 				id = string(fastx.ParseHeadID(idRe, []byte(head)))
 			} else {
 				id = head
@@ -131,14 +139,20 @@ Examples:
 
 		// handle queries
 		queries := files[1:]
+		if regionFile != "" {
+			regions, err := readFaidxRegionFile(regionFile)
+			checkError(err)
+			queries = append(queries, regions...)
+		}
 		faidxQueries := make([]faidxQuery, 0, len(queries))
 		var region [2]int
 
 		var ok bool
 		if !useRegexp {
 			var begin, end int
-			for _, query := range files[1:] {
-				id, begin, end = parseRegion(query)
+			var revcom bool
+			for _, query := range queries {
+				id, begin, end, revcom = parseRegionWithStrand(query)
 
 				if ignoreCase {
 					id = strings.ToLower(id)
@@ -148,7 +162,7 @@ Examples:
 					continue
 				}
 
-				faidxQueries = append(faidxQueries, faidxQuery{ID: id, Region: [2]int{begin, end}})
+				faidxQueries = append(faidxQueries, faidxQuery{ID: id, Region: [2]int{begin, end}, RevCom: revcom})
 			}
 		} else {
 			queriesRe := make([]*regexp.Regexp, len(queries))
@@ -170,18 +184,38 @@ Examples:
 			}
 		}
 
-		var head string
+		var head, name, strand string
 		var subseq []byte
+		var s *seq.Seq
+		var alphabet *seq.Alphabet
 		var text []byte
 		var b *bytes.Buffer
 		for _, faidxQ := range faidxQueries {
 			head = id2head[faidxQ.ID]
 			region = faidxQ.Region
-			subseq, _ = faidx.SubSeq(head, region[0], region[1])
+			subseq, _ = ref.faidx.SubSeq(head, region[0], region[1])
+
+			if parseID && !fullHead {
+				name = string(fastx.ParseHeadID(idRe, []byte(head)))
+			} else {
+				name = head
+			}
+			strand = ""
+			if faidxQ.RevCom {
+				alphabet = seq.DNAredundant
+				if bytes.ContainsAny(subseq, "Uu") && !bytes.ContainsAny(subseq, "Tt") {
+					alphabet = seq.RNAredundant
+				}
+				s, err = seq.NewSeqWithoutValidation(alphabet, subseq)
+				checkError(err)
+				s.RevComInplace()
+				subseq = s.Seq
+				strand = "(-)"
+			}
 			if region[0] == 1 && region[1] == -1 {
-				outfh.WriteString(fmt.Sprintf(">%s\n", head))
+				outfh.WriteString(fmt.Sprintf(">%s%s\n", name, strand))
 			} else {
-				outfh.WriteString(fmt.Sprintf(">%s:%d-%d\n", head, region[0], region[1]))
+				outfh.WriteString(fmt.Sprintf(">%s:%d-%d%s\n", name, region[0], region[1], strand))
 			}
 
 			if len(subseq) <= pageSize {
@@ -204,6 +238,7 @@ Examples:
 type faidxQuery struct {
 	ID     string
 	Region [2]int
+	RevCom bool
 }
 
 func init() {
@@ -212,6 +247,7 @@ func init() {
 	faidxCmd.Flags().BoolP("use-regexp", "r", false, "IDs are regular expression. But subseq region is not suppored here.")
 	faidxCmd.Flags().BoolP("ignore-case", "i", false, "ignore case")
 	faidxCmd.Flags().BoolP("full-head", "f", false, "print full header line instead of just ID. New fasta index file ending with .seqkit.fai will be created")
+	faidxCmd.Flags().StringP("region-file", "l", "", "file containing a list of regions, in region strings (one per line) or BED format")
 
 	faidxCmd.SetUsageTemplate(`Usage:{{if .Runnable}}
   {{if .HasAvailableFlags}}{{appendIfNotPresent .UseLine "[flags]"}}{{else}}{{.UseLine}}{{end}}{{end}}{{if .HasAvailableSubCommands}}
@@ -240,36 +276,100 @@ Use "{{.CommandPath}} [command] --help" for more information about a command.{{e
 
 }
 
-var reRegionFull = regexp.MustCompile(`^(.+?):(\-?\d+)\-(\-?\d+)$`)
-var reRegionOneBase = regexp.MustCompile(`^(.+?):(\d+)$`)
-var reRegionOnlyBegin = regexp.MustCompile(`^(.+?):(\-?\d+)\-$`)
-var reRegionOnlyEnd = regexp.MustCompile(`^(.+?):\-(\-?\d+)$`)
+var reRegionFull = regexp.MustCompile(`^(.+?):(\-?[\d,]+)\-(\-?[\d,]+)$`)
+var reRegionOneBase = regexp.MustCompile(`^(.+?):([\d,]+)$`)
+var reRegionOnlyBegin = regexp.MustCompile(`^(.+?):(\-?[\d,]+)\-$`)
+var reRegionOnlyEnd = regexp.MustCompile(`^(.+?):\-(\-?[\d,]+)$`)
+var reRegionStrand = regexp.MustCompile(`^(.+?)(?::([+\-])|\(([+\-])\))$`)
 
 func parseRegion(region string) (id string, begin int, end int) {
 	var found []string
 	if reRegionFull.MatchString(region) {
 		found = reRegionFull.FindStringSubmatch(region)
 		id = found[1]
-		begin, _ = strconv.Atoi(found[2])
-		end, _ = strconv.Atoi(found[3])
+		begin, _ = atoiWithCommas(found[2])
+		end, _ = atoiWithCommas(found[3])
 	} else if reRegionOneBase.MatchString(region) {
 		found = reRegionOneBase.FindStringSubmatch(region)
 		id = found[1]
-		begin, _ = strconv.Atoi(found[2])
+		begin, _ = atoiWithCommas(found[2])
 		end = begin
 	} else if reRegionOnlyBegin.MatchString(region) {
 		found = reRegionOnlyBegin.FindStringSubmatch(region)
 		id = found[1]
-		begin, _ = strconv.Atoi(found[2])
+		begin, _ = atoiWithCommas(found[2])
 		end = -1
 	} else if reRegionOnlyEnd.MatchString(region) {
 		found = reRegionOnlyEnd.FindStringSubmatch(region)
 		id = found[1]
 		begin = 1
-		end, _ = strconv.Atoi(found[2])
+		end, _ = atoiWithCommas(found[2])
 	} else {
 		id = region
 		begin, end = 1, -1
 	}
 	return
 }
+
+// atoiWithCommas parses integers with thousands separators, e.g., 5,000.
+func atoiWithCommas(s string) (int, error) {
+	return strconv.Atoi(strings.Replace(s, ",", "", -1))
+}
+
+// parseRegionWithStrand parses a region with an optional strand suffix,
+// e.g., chr1:101-200:- or chr1:101-200(-).
+func parseRegionWithStrand(region string) (id string, begin int, end int, revcom bool) {
+	if found := reRegionStrand.FindStringSubmatch(region); found != nil {
+		region = found[1]
+		revcom = found[2] == "-" || found[3] == "-"
+	}
+	id, begin, end = parseRegion(region)
+	return
+}
+
+// readFaidxRegionFile reads regions from a file, one region string per line,
+// or BED records with 0-based starts and optional strands in the 6th column.
+func readFaidxRegionFile(file string) ([]string, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var regions []string
+	var items []string
+	var start, end int
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		if !strings.Contains(line, "\t") {
+			regions = append(regions, strings.TrimSpace(line))
+			continue
+		}
+
+		items = strings.Split(line, "\t")
+		if len(items) < 3 {
+			return nil, fmt.Errorf("invalid BED record: %s", line)
+		}
+		start, err = strconv.Atoi(items[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad start: %s", items[0], items[1])
+		}
+		end, err = strconv.Atoi(items[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad end: %s", items[0], items[2])
+		}
+		if start >= end {
+			return nil, fmt.Errorf("%s: start (%d) must be < end (%d)", items[0], start, end)
+		}
+		if len(items) >= 6 && items[5] == "-" {
+			regions = append(regions, fmt.Sprintf("%s:%d-%d:-", items[0], start+1, end))
+		} else {
+			regions = append(regions, fmt.Sprintf("%s:%d-%d", items[0], start+1, end))
+		}
+	}
+	return regions, scanner.Err()
+}
@@ -0,0 +1,298 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/biogo/hts/bgzf"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fai"
+)
+
+// bgzfFaidx gives random access to a bgzip-compressed FASTA file, using a
+// FASTA index with offsets in the uncompressed data and a .gzi index mapping
+// the uncompressed offsets to BGZF blocks (as created by "bgzip -i").
+type bgzfFaidx struct {
+	fh     *os.File
+	reader *bgzf.Reader
+	idx    fai.Index
+	gzi    []gziEntry
+}
+
+// gziEntry maps the start of a BGZF block to its uncompressed offset.
+type gziEntry struct {
+	compressed   uint64
+	uncompressed uint64
+}
+
+// newBgzfFaidx opens a bgzip-compressed FASTA file, reading or creating the
+// FASTA index fileFai and the block index file.gzi.
+func newBgzfFaidx(file string, fileFai string, idRegexp string, quiet bool) (*bgzfFaidx, fai.Index, error) {
+	fileGzi := file + ".gzi"
+	var gzi []gziEntry
+	var err error
+	if fileNotExists(fileGzi) {
+		if !quiet {
+			log.Infof("create BGZF index for %s", file)
+		}
+		gzi, err = createGzi(file, fileGzi)
+	} else {
+		gzi, err = readGzi(fileGzi)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var idx fai.Index
+	if fileNotExists(fileFai) {
+		if !quiet {
+			log.Infof("create FASTA index for %s", file)
+		}
+		idx, err = createBgzfFai(file, fileFai, idRegexp)
+	} else {
+		idx, err = fai.Read(fileFai)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := bgzf.NewReader(fh, 1)
+	if err != nil {
+		fh.Close()
+		return nil, nil, err
+	}
+	return &bgzfFaidx{fh: fh, reader: reader, idx: idx, gzi: gzi}, idx, nil
+}
+
+// SubSeq returns the subsequence of 1-based, inclusive coordinates, negative
+// coordinates count from the end as in fai.Faidx.SubSeq.
+func (f *bgzfFaidx) SubSeq(chrom string, start, end int) ([]byte, error) {
+	r, ok := f.idx[chrom]
+	if !ok {
+		return nil, fmt.Errorf("sequence not found: %s", chrom)
+	}
+	start, end, ok = seq.SubLocation(r.Length, start, end)
+	if !ok {
+		return []byte(""), nil
+	}
+	// byte offsets in the uncompressed file
+	offset := func(i int) uint64 {
+		return uint64(r.Start) + uint64(i/r.BasesPerLine*r.BytesPerLine+i%r.BasesPerLine)
+	}
+	from, to := offset(start-1), offset(end-1)
+
+	k := sort.Search(len(f.gzi), func(i int) bool { return f.gzi[i].uncompressed > from }) - 1
+	err := f.reader.Seek(bgzf.Offset{File: int64(f.gzi[k].compressed), Block: uint16(from - f.gzi[k].uncompressed)})
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, to-from+1)
+	if _, err = io.ReadFull(f.reader, buf); err != nil {
+		return nil, err
+	}
+	res := buf[:0]
+	for _, b := range buf {
+		if b != '\n' && b != '\r' {
+			res = append(res, b)
+		}
+	}
+	return res, nil
+}
+
+// readGzi reads a .gzi file: the number of entries followed by pairs of
+// compressed and uncompressed offsets, all little-endian uint64. The first
+// block (0, 0) is implicit.
+func readGzi(file string) ([]gziEntry, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(fh)
+	var n uint64
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, fmt.Errorf("invalid gzi file %s: %s", file, err)
+	}
+	// every entry takes 16 bytes, check the count before allocating
+	if size := uint64(info.Size()); size < 8 || n != (size-8)/16 {
+		return nil, fmt.Errorf("invalid gzi file %s: %d entries declared for a file of %d bytes", file, n, size)
+	}
+	entries := make([]gziEntry, n+1)
+	for i := uint64(1); i <= n; i++ {
+		if err = binary.Read(r, binary.LittleEndian, &entries[i].compressed); err != nil {
+			return nil, fmt.Errorf("invalid gzi file %s: %s", file, err)
+		}
+		if err = binary.Read(r, binary.LittleEndian, &entries[i].uncompressed); err != nil {
+			return nil, fmt.Errorf("invalid gzi file %s: %s", file, err)
+		}
+	}
+	return entries, nil
+}
+
+// createGzi scans the BGZF block headers of a file and writes the .gzi index.
+func createGzi(file string, fileGzi string) ([]gziEntry, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	r := bufio.NewReader(fh)
+
+	entries := []gziEntry{{0, 0}}
+	var compressed, uncompressed uint64
+	head := make([]byte, 18)
+	for {
+		_, err = io.ReadFull(r, head)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		if head[0] != 0x1f || head[1] != 0x8b || head[3]&0x04 == 0 || head[12] != 'B' || head[13] != 'C' {
+			return nil, fmt.Errorf("%s: not a BGZF file, please compress it with bgzip", file)
+		}
+		blockSize := uint64(binary.LittleEndian.Uint16(head[16:18])) + 1
+		// skip the data and CRC32 to read ISIZE
+		if _, err = r.Discard(int(blockSize) - 18 - 4); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		isize := make([]byte, 4)
+		if _, err = io.ReadFull(r, isize); err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		compressed += blockSize
+		uncompressed += uint64(binary.LittleEndian.Uint32(isize))
+		entries = append(entries, gziEntry{compressed, uncompressed})
+	}
+	// the entry after the last block is not a block start
+	entries = entries[:len(entries)-1]
+
+	outfh, err := os.Create(fileGzi)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(outfh)
+	binary.Write(w, binary.LittleEndian, uint64(len(entries)-1))
+	for _, e := range entries[1:] {
+		binary.Write(w, binary.LittleEndian, e.compressed)
+		binary.Write(w, binary.LittleEndian, e.uncompressed)
+	}
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+	return entries, outfh.Close()
+}
+
+// createBgzfFai scans the decompressed FASTA and writes its index, with the
+// sequence IDs parsed by idRegexp.
+func createBgzfFai(file string, fileFai string, idRegexp string) (fai.Index, error) {
+	re, err := regexp.Compile(idRegexp)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	bz, err := bgzf.NewReader(fh, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer bz.Close()
+	r := bufio.NewReader(bz)
+
+	outfh, err := os.Create(fileFai)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(outfh)
+
+	var rec *fai.Record
+	var offset int64
+	lastLine := false // a shorter line has been seen in the current record
+	flush := func() {
+		if rec != nil {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", rec.Name, rec.Length, rec.Start, rec.BasesPerLine, rec.BytesPerLine)
+		}
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			offset += int64(len(line))
+			if line[0] == '>' {
+				flush()
+				head := bytes.TrimRight(line[1:], "\r\n")
+				id := string(head)
+				if m := re.FindSubmatch(head); len(m) > 1 {
+					id = string(m[1])
+				}
+				rec = &fai.Record{Name: id, Start: offset}
+				lastLine = false
+			} else if rec != nil {
+				bases := len(bytes.TrimRight(line, "\r\n"))
+				if rec.BasesPerLine == 0 {
+					rec.BasesPerLine, rec.BytesPerLine = bases, len(line)
+				} else if lastLine || bases > rec.BasesPerLine {
+					if bases > 0 {
+						outfh.Close()
+						return nil, fmt.Errorf("%s: lines of sequence %s have different lengths", file, rec.Name)
+					}
+				} else if bases < rec.BasesPerLine {
+					lastLine = true
+				}
+				rec.Length += bases
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			outfh.Close()
+			return nil, err
+		}
+	}
+	flush()
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+	if err = outfh.Close(); err != nil {
+		return nil, err
+	}
+	return fai.Read(fileFai)
+}
@@ -0,0 +1,191 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// filterCmd represents the filter command
+var filterCmd = &cobra.Command{
+	Use:   "filter",
+	Short: "filter reads by average quality, length and GC content",
+	Long: `filter reads by average quality, length and GC content
+
+Reads are cropped first (--head-crop, --tail-crop), the filters are then
+applied to the cropped reads:
+
+  1. minimum and maximum length (-m, -M),
+  2. minimum average quality (-Q), computed in probability space, i.e.,
+     the mean of the error probabilities of all bases converted back
+     to a Phred score,
+  3. GC content range in percentage (--min-gc, --max-gc).
+
+A summary of kept and dropped reads and bases of every input file is
+reported in log, or saved to a tab-delimited file by --summary.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		minLen := getFlagInt(cmd, "min-len")
+		maxLen := getFlagInt(cmd, "max-len")
+		minQual := getFlagFloat64(cmd, "min-qual")
+		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
+		headCrop := getFlagNonNegativeInt(cmd, "head-crop")
+		tailCrop := getFlagNonNegativeInt(cmd, "tail-crop")
+		minGC := getFlagFloat64(cmd, "min-gc")
+		maxGC := getFlagFloat64(cmd, "max-gc")
+		summaryFile := getFlagString(cmd, "summary")
+
+		if minLen >= 0 && maxLen >= 0 && minLen > maxLen {
+			checkError(fmt.Errorf("value of flag -m (--min-len) should be <= value of flag -M (--max-len)"))
+		}
+		if minGC < 0 || maxGC > 100 || minGC > maxGC {
+			checkError(fmt.Errorf("values of flag --min-gc and --max-gc should be in range of [0, 100], and --min-gc <= --max-gc"))
+		}
+		filterGC := minGC > 0 || maxGC < 100
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var summaryWriter *bufio.Writer
+		if summaryFile != "" {
+			summaryFh, err := xopen.Wopen(summaryFile)
+			checkError(err)
+			defer summaryFh.Close()
+			summaryWriter = bufio.NewWriter(summaryFh)
+			defer summaryWriter.Flush()
+			summaryWriter.WriteString("file\treads\tbases\tkept_reads\tkept_bases\tdropped_reads\tdropped_bases\n")
+		}
+
+		var start, end int
+		var g, c, gc float64
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+
+			var n, bases, kept, keptBases int
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					lineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+
+				n++
+				bases += len(record.Seq.Seq)
+
+				if headCrop > 0 || tailCrop > 0 {
+					start, end = headCrop, len(record.Seq.Seq)-tailCrop
+					if end < start {
+						end = start
+					}
+					record.Seq.Seq = record.Seq.Seq[start:end]
+					if len(record.Seq.Qual) > 0 {
+						record.Seq.Qual = record.Seq.Qual[start:end]
+					}
+				}
+
+				if minLen >= 0 && len(record.Seq.Seq) < minLen {
+					continue
+				}
+				if maxLen >= 0 && len(record.Seq.Seq) > maxLen {
+					continue
+				}
+
+				if minQual > 0 {
+					if !fastxReader.IsFastq {
+						checkError(fmt.Errorf("flag -Q (--min-qual) only works for FASTQ: %s", file))
+					}
+					if len(record.Seq.Seq) == 0 || avgQual(record.Seq, qBase) < minQual {
+						continue
+					}
+				}
+
+				if filterGC {
+					if len(record.Seq.Seq) == 0 {
+						continue
+					}
+					g = record.Seq.BaseContent("G")
+					c = record.Seq.BaseContent("C")
+					gc = (g + c) * 100
+					if gc < minGC || gc > maxGC {
+						continue
+					}
+				}
+
+				kept++
+				keptBases += len(record.Seq.Seq)
+				record.FormatToWriter(outfh, lineWidth)
+			}
+
+			if summaryWriter != nil {
+				summaryWriter.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+					file, n, bases, kept, keptBases, n-kept, bases-keptBases))
+			} else if !quiet {
+				log.Infof("%s: %d of %d reads kept (%d of %d bases), %d reads dropped",
+					file, kept, n, keptBases, bases, n-kept)
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(filterCmd)
+
+	filterCmd.Flags().IntP("min-len", "m", -1, "minimum length of the cropped reads (-1 for no limit)")
+	filterCmd.Flags().IntP("max-len", "M", -1, "maximum length of the cropped reads (-1 for no limit)")
+	filterCmd.Flags().Float64P("min-qual", "Q", 0, "minimum average quality of the cropped reads (0 for no limit)")
+	filterCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
+	filterCmd.Flags().IntP("head-crop", "", 0, "number of bases removed from the start of the reads")
+	filterCmd.Flags().IntP("tail-crop", "", 0, "number of bases removed from the end of the reads")
+	filterCmd.Flags().Float64P("min-gc", "", 0, "minimum GC content in percentage")
+	filterCmd.Flags().Float64P("max-gc", "", 100, "maximum GC content in percentage")
+	filterCmd.Flags().StringP("summary", "", "", "save the summary of every input file to this tab-delimited file")
+}
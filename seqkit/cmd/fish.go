@@ -119,6 +119,11 @@ var fishCmd = &cobra.Command{
 Attention:
   1. output coordinates are BED-like 0-based, left-close and right-open.
   2. alignment information are printed to STDERR.
+  3. hits can also be saved in PAF format (-P/--out-paf), with the queries as
+     PAF queries and the searched sequences as PAF targets.
+  4. the orientation of each searched sequence can be inferred from the
+     strands of its hits, weighted by alignment scores (-O/--orientation):
+     "+" or "-", "?" for ties, and "." for no hits.
 
 `,
 
@@ -148,6 +153,8 @@ Attention:
 		flagAll := getFlagBool(cmd, "all")
 		flagDesc := getFlagBool(cmd, "print-desc")
 		flagInvert := getFlagBool(cmd, "invert")
+		flagPaf := getFlagString(cmd, "out-paf")
+		flagOrient := getFlagString(cmd, "orientation")
 
 		ranges := parseRanges(flagRange)
 		alnParams := parseAlnParams(flagAlnParams)
@@ -182,6 +189,19 @@ Attention:
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 
+		var paffh, orientfh *xopen.Writer
+		if flagPaf != "" {
+			paffh, err = xopen.Wopen(flagPaf)
+			checkError(err)
+			defer paffh.Close()
+		}
+		if flagOrient != "" {
+			orientfh, err = xopen.Wopen(flagOrient)
+			checkError(err)
+			defer orientfh.Close()
+			orientfh.WriteString("Ref\tPlusHits\tMinusHits\tPlusScore\tMinusScore\tOrientation\n")
+		}
+
 		var checkSeqType bool
 		var isFastq bool
 		var printQual bool
@@ -233,6 +253,11 @@ Attention:
 
 				hits := detector.Detect(&Reference{refId, string(record.Seq.Seq), ranges}, flagAll)
 
+				if orientfh != nil {
+					o, np, nm, sp, sm := InferOrientation(hits)
+					orientfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%.0f\t%.0f\t%s\n", refId, np, nm, sp, sm, o))
+				}
+
 				if !flagInvert {
 					for _, h := range hits {
 						if first {
@@ -243,6 +268,9 @@ Attention:
 						if flagAln {
 							fmt.Fprintf(os.Stderr, "%s\n", h.AlnString())
 						}
+						if paffh != nil {
+							paffh.WriteString(h.PAF(len(record.Seq.Seq)) + "\n")
+						}
 						h.Ref.Seq = ""
 					}
 
@@ -380,6 +408,8 @@ func init() {
 	fishCmd.Flags().BoolP("invert", "i", false, "print out references not matching with any query")
 	fishCmd.Flags().IntP("validate-seq-length", "V", 10000, "length of sequence to validate (0 for whole seq)")
 	fishCmd.Flags().Float64P("min-qual", "q", 5.0, "minimum mapping quality")
+	fishCmd.Flags().StringP("out-paf", "P", "", "save hits to this file in PAF format")
+	fishCmd.Flags().StringP("orientation", "O", "", "save orientations of sequences inferred from hits to this TSV file")
 }
 
 // NewRecordFromAln builds a new SAM record based on the provided local alignment and its reference/query coordinates.
@@ -0,0 +1,200 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/spf13/cobra"
+)
+
+// fq2bamCmd represents the fq2bam command
+var fq2bamCmd = &cobra.Command{
+	Use:   "fq2bam",
+	Short: "convert FASTQ/A to unaligned BAM",
+	Long: `convert FASTQ/A to unaligned BAM
+
+All records are written as unmapped reads, so the toolbox (seqkit bam -T)
+can operate on unaligned data. The records can be assigned to a read group
+and key=value fields of the header comments (as written by the nanopore
+basecallers, e.g. "ch=123 start_time=...") can be mapped to aux tags by
+-t/--tag. Integer and float values are stored as numbers.
+
+Examples:
+  seqkit fq2bam -r run1 -s sample1 -P ONT -t ch:ch,start_time:st reads.fq.gz -o reads.bam
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		rgID := getFlagString(cmd, "read-group")
+		sample := getFlagString(cmd, "sample")
+		platform := getFlagString(cmd, "platform")
+		commentTag := getFlagBool(cmd, "comment-tag")
+
+		tagMap := make(map[string]sam.Tag)
+		for _, m := range getFlagStringSlice(cmd, "tag") {
+			kv := strings.Split(m, ":")
+			if len(kv) != 2 || kv[0] == "" || len(kv[1]) != 2 {
+				checkError(fmt.Errorf("invalid value of flag -t/--tag, it should be key:TG: %s", m))
+			}
+			tagMap[kv[0]] = sam.NewTag(kv[1])
+		}
+		if (sample != "" || platform != "") && rgID == "" {
+			checkError(fmt.Errorf("flag -r/--read-group is needed for -s/--sample and -P/--platform"))
+		}
+
+		header, err := sam.NewHeader(nil, nil)
+		checkError(err)
+		header.AddProgram(sam.NewProgram("seqkit", "seqkit", "seqkit fq2bam", "", VERSION))
+		var rgAux sam.Aux
+		if rgID != "" {
+			rg, err := sam.NewReadGroup(rgID, "", "", "", "", platform, "", sample, "", "", time.Time{}, 0)
+			checkError(err)
+			checkError(header.AddReadGroup(rg))
+			rgAux, err = sam.NewAux(sam.NewTag("RG"), rgID)
+			checkError(err)
+		}
+
+		outfh := os.Stdout
+		if config.OutFile != "-" {
+			outfh, err = os.Create(config.OutFile)
+			checkError(err)
+		}
+		writer, err := bam.NewWriter(outfh, header, config.Threads)
+		checkError(err)
+
+		var n int
+		for _, file := range files {
+			fastxReader, err := NewFastxRecordReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+
+				var aux []sam.Aux
+				if rgAux != nil {
+					aux = append(aux, rgAux)
+				}
+				comment := fastxComment(record.Name)
+				if len(tagMap) > 0 {
+					aux = append(aux, commentFieldsToAux(comment, tagMap)...)
+				}
+				if commentTag && len(comment) > 0 {
+					a, err := sam.NewAux(sam.NewTag("CO"), string(comment))
+					checkError(err)
+					aux = append(aux, a)
+				}
+
+				qual := make([]byte, len(record.Seq.Seq))
+				for i := range qual {
+					if i < len(record.Seq.Qual) {
+						qual[i] = record.Seq.Qual[i] - 33
+					} else {
+						qual[i] = 0xff
+					}
+				}
+				r, err := sam.NewRecord(string(record.ID), nil, nil, -1, -1, 0, 0, nil, record.Seq.Seq, qual, aux)
+				checkError(err)
+				r.Flags = sam.Unmapped
+				checkError(writer.Write(r))
+				n++
+			}
+		}
+
+		checkError(writer.Close())
+		checkError(outfh.Close())
+		if !quiet {
+			log.Infof("%d records converted", n)
+		}
+	},
+}
+
+// fastxComment returns the part of the header after the first whitespace.
+func fastxComment(name []byte) []byte {
+	i := bytes.IndexAny(name, " \t")
+	if i < 0 {
+		return nil
+	}
+	return bytes.TrimSpace(name[i+1:])
+}
+
+// commentFieldsToAux converts the key=value fields of a header comment with a
+// key in tagMap to aux fields, storing integer and float values as numbers.
+func commentFieldsToAux(comment []byte, tagMap map[string]sam.Tag) []sam.Aux {
+	var aux []sam.Aux
+	for _, field := range strings.Fields(string(comment)) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		tag, ok := tagMap[kv[0]]
+		if !ok {
+			continue
+		}
+		var val interface{} = kv[1]
+		if i, err := strconv.Atoi(kv[1]); err == nil {
+			val = i
+		} else if f, err := strconv.ParseFloat(kv[1], 32); err == nil {
+			val = float32(f)
+		}
+		a, err := sam.NewAux(tag, val)
+		checkError(err)
+		aux = append(aux, a)
+	}
+	return aux
+}
+
+func init() {
+	RootCmd.AddCommand(fq2bamCmd)
+
+	fq2bamCmd.Flags().StringP("read-group", "r", "", "read group ID assigned to all records")
+	fq2bamCmd.Flags().StringP("sample", "s", "", "sample name (SM) of the read group")
+	fq2bamCmd.Flags().StringP("platform", "P", "", "platform (PL) of the read group, e.g. ONT or ILLUMINA")
+	fq2bamCmd.Flags().StringSliceP("tag", "t", []string{}, `map key=value fields of the header comment to aux tags, e.g. "ch:ch,start_time:st"`)
+	fq2bamCmd.Flags().BoolP("comment-tag", "C", false, "store the header comment in the CO tag")
+}
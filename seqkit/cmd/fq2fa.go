@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"fmt"
 	"io"
 	"runtime"
 
@@ -36,6 +37,10 @@ var fq2faCmd = &cobra.Command{
 	Short: "convert FASTQ to FASTA",
 	Long: `convert FASTQ to FASTA
 
+Bases with qualities below -q/--min-qual can be soft-masked (lower case),
+or hard-masked with N using -N/--mask-n, so the information is kept after
+dropping quality strings.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -49,6 +54,14 @@ var fq2faCmd = &cobra.Command{
 
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
+		minQual := getFlagNonNegativeInt(cmd, "min-qual")
+		maskN := getFlagBool(cmd, "mask-n")
+		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
+		if maskN && minQual == 0 {
+			checkError(fmt.Errorf("flag -N/--mask-n needs -q/--min-qual"))
+		}
+		threshold := qBase + minQual
+
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 		defer outfh.Close()
@@ -69,6 +82,19 @@ var fq2faCmd = &cobra.Command{
 					break
 				}
 
+				if minQual > 0 && len(record.Seq.Qual) == len(record.Seq.Seq) {
+					for i, q := range record.Seq.Qual {
+						if int(q) >= threshold {
+							continue
+						}
+						if maskN {
+							record.Seq.Seq[i] = 'N'
+						} else if record.Seq.Seq[i] >= 'A' && record.Seq.Seq[i] <= 'Z' {
+							record.Seq.Seq[i] += 32
+						}
+					}
+				}
+
 				record.Seq.Qual = []byte{}
 				record.FormatToWriter(outfh, lineWidth)
 			}
@@ -78,4 +104,8 @@ var fq2faCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(fq2faCmd)
+
+	fq2faCmd.Flags().IntP("min-qual", "q", 0, "mask bases with qualities below this value, 0 for no masking")
+	fq2faCmd.Flags().BoolP("mask-n", "N", false, "mask low-quality bases with N instead of lower case")
+	fq2faCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
 }
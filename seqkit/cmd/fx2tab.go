@@ -21,7 +21,9 @@
 package cmd
 
 import (
+	"crypto/md5"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"math"
 	"runtime"
@@ -45,6 +47,9 @@ like sequence length, GC content/GC skew.
 Attention:
   1. Fixed three columns (ID, sequence, quality) are outputted for either FASTA
      or FASTQ, except when flag -n/--name is on. This is for format compatibility.
+  2. Input files ending with ".tar", ".tar.gz" or ".tgz" are read as
+     archives of FASTA/Q files, extracting one file at a time to a
+     temporary file.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -70,6 +75,11 @@ Attention:
 		printAvgQual := getFlagBool(cmd, "avg-qual")
 		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
 		printSeqHash := getFlagBool(cmd, "seq-hash")
+		printQ2030 := getFlagBool(cmd, "q20-q30")
+		printMaxHP := getFlagBool(cmd, "max-homopolymer")
+		printAmbiguous := getFlagBool(cmd, "ambiguous")
+		printMD5 := getFlagBool(cmd, "md5")
+		printCRC32 := getFlagBool(cmd, "crc32")
 
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
@@ -112,16 +122,33 @@ Attention:
 			if printSeqHash {
 				outfh.WriteString("\tseq.hash")
 			}
+			if printQ2030 {
+				outfh.WriteString("\tQ20\tQ30")
+			}
+			if printMaxHP {
+				outfh.WriteString("\tmax.HP")
+			}
+			if printAmbiguous {
+				outfh.WriteString("\tambiguous")
+			}
+			if printMD5 {
+				outfh.WriteString("\tseq.md5")
+			}
+			if printCRC32 {
+				outfh.WriteString("\tseq.crc32")
+			}
 
 			outfh.WriteString("\n")
 		}
 
 		var name []byte
 		var g, c float64
+		var q20, q30 float64
+		var maxHP int
 		var record *fastx.Record
-		var fastxReader *fastx.Reader
+		var fastxReader FastxRecordReader
 		for _, file := range files {
-			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			fastxReader, err = NewFastxRecordReader(alphabet, file, idRegexp)
 			checkError(err)
 			for {
 				record, err = fastxReader.Read()
@@ -186,6 +213,28 @@ Attention:
 					outfh.WriteString(fmt.Sprintf("\t%d", xxhash.Sum64(record.Seq.Seq)))
 				}
 
+				if printQ2030 {
+					q20, q30 = qualFractions(record.Seq.Qual, qBase)
+					outfh.WriteString(fmt.Sprintf("\t%.4f\t%.4f", q20, q30))
+				}
+
+				if printMaxHP {
+					maxHP, _ = homopolymers(record.Seq.Seq, 1)
+					outfh.WriteString(fmt.Sprintf("\t%d", maxHP))
+				}
+
+				if printAmbiguous {
+					outfh.WriteString(fmt.Sprintf("\t%d", ambiguousBases(record.Seq.Seq)))
+				}
+
+				if printMD5 {
+					outfh.WriteString(fmt.Sprintf("\t%x", md5.Sum(record.Seq.Seq)))
+				}
+
+				if printCRC32 {
+					outfh.WriteString(fmt.Sprintf("\t%08x", crc32.ChecksumIEEE(record.Seq.Seq)))
+				}
+
 				outfh.WriteString("\n")
 			}
 		}
@@ -207,6 +256,11 @@ func init() {
 	fx2tabCmd.Flags().BoolP("avg-qual", "q", false, "print average quality of a read")
 	fx2tabCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
 	fx2tabCmd.Flags().BoolP("seq-hash", "s", false, "print hash of sequence (case sensitive)")
+	fx2tabCmd.Flags().BoolP("q20-q30", "Q", false, "print fractions of bases with qualities >= 20 and >= 30")
+	fx2tabCmd.Flags().BoolP("max-homopolymer", "p", false, "print length of the longest homopolymer (case ignored, N excluded)")
+	fx2tabCmd.Flags().BoolP("ambiguous", "A", false, "print number of bases other than A, C, G, T/U (case ignored)")
+	fx2tabCmd.Flags().BoolP("md5", "M", false, "print MD5 digest of sequence (case sensitive)")
+	fx2tabCmd.Flags().BoolP("crc32", "C", false, "print CRC32 checksum of sequence (case sensitive)")
 
 }
 
@@ -236,3 +290,51 @@ func avgQual(s *seq.Seq, base int) float64 {
 	}
 	return -10 * math.Log10(sum/float64(len(s.QualValue)))
 }
+
+// qualFractions returns the fractions of bases with qualities of at least
+// 20 and 30.
+func qualFractions(qual []byte, base int) (float64, float64) {
+	if len(qual) == 0 {
+		return 0, 0
+	}
+	var n20, n30 int
+	for _, q := range qual {
+		if int(q)-base >= 20 {
+			n20++
+			if int(q)-base >= 30 {
+				n30++
+			}
+		}
+	}
+	return float64(n20) / float64(len(qual)), float64(n30) / float64(len(qual))
+}
+
+// ambiguousBases returns the number of bases other than A, C, G, T/U.
+func ambiguousBases(s []byte) int {
+	var n int
+	for _, b := range s {
+		if baseCode2bit[b] < 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// qualErrorProbs returns the error probabilities of all quality characters
+// with the given ASCII offset.
+func qualErrorProbs(base int) [256]float64 {
+	var probs [256]float64
+	for i := range probs {
+		q := i - base
+		if q < 0 {
+			q = 0
+		}
+		probs[i] = math.Pow(10, float64(q)/-10)
+	}
+	return probs
+}
+
+// errorProbToPhred converts an error probability to a Phred quality.
+func errorProbToPhred(p float64) float64 {
+	return -10 * math.Log10(p)
+}
@@ -0,0 +1,279 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// gcskewCmd represents the gcskew command
+var gcskewCmd = &cobra.Command{
+	Use:   "gcskew",
+	Short: "compute GC skew and cumulative GC skew in sliding windows",
+	Long: `compute GC skew and cumulative GC skew in sliding windows
+
+GC skew of a window is (G-C)/(G+C), and the cumulative GC skew is the
+running sum of GC skews of windows. Windows start every -s/--step bases,
+and windows at the end of sequences are skipped unless -c/--circular is
+given, for which windows span the end and start of sequences, with end
+positions greater than sequence lengths.
+
+The origin and terminus of replication of bacterial genomes are predicted
+as the positions of the minimum and maximum of the cumulative G-C counts
+along the sequence, and saved to -r/--ori-ter-file.
+
+Output columns (tab-delimited):
+  seqID, start (1-based), end, GC skew, cumulative GC skew
+
+With --bed, positions are in BED format (0-based start) without the
+header line.
+
+Plots (-p/--plot) are saved for every sequence, with the sequence ID
+inserted before the file extension, e.g., skew.png -> skew.NC_000913.png.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		window := getFlagPositiveInt(cmd, "window")
+		step := getFlagPositiveInt(cmd, "step")
+		circular := getFlagBool(cmd, "circular")
+		bedFormat := getFlagBool(cmd, "bed")
+		oriTerFile := getFlagString(cmd, "ori-ter-file")
+		plotFile := getFlagString(cmd, "plot")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var oritefh *xopen.Writer
+		if oriTerFile != "" {
+			oritefh, err = xopen.Wopen(oriTerFile)
+			checkError(err)
+			defer oritefh.Close()
+			oritefh.WriteString("seqID\tlength\torigin\tterminus\n")
+		}
+
+		if !bedFormat {
+			outfh.WriteString("seqID\tstart\tend\tskew\tcumSkew\n")
+		}
+
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		var id string
+		var windows []gcSkewWindow
+		var ori, ter int
+		var plots int
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+
+				id = string(record.ID)
+				windows = gcSkewWindows(record.Seq.Seq, window, step, circular)
+				for _, w := range windows {
+					if bedFormat {
+						outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%.6f\t%.6f\n", id, w.start, w.end, w.skew, w.cumSkew))
+					} else {
+						outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%.6f\t%.6f\n", id, w.start+1, w.end, w.skew, w.cumSkew))
+					}
+				}
+
+				if oritefh != nil {
+					ori, ter = predictOriTer(record.Seq.Seq)
+					oritefh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\n", id, len(record.Seq.Seq), ori+1, ter+1))
+				}
+
+				if plotFile != "" {
+					ext := filepath.Ext(plotFile)
+					checkError(saveGCSkewPlot(id, windows, strings.TrimSuffix(plotFile, ext)+"."+id+ext))
+					plots++
+				}
+			}
+		}
+
+		if plotFile != "" && !quiet {
+			log.Infof("%d plots saved", plots)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gcskewCmd)
+
+	gcskewCmd.Flags().IntP("window", "W", 10000, "window size")
+	gcskewCmd.Flags().IntP("step", "s", 1000, "step size")
+	gcskewCmd.Flags().BoolP("circular", "c", false, "circular genome, windows span the end and start of sequences")
+	gcskewCmd.Flags().BoolP("bed", "", false, "output in BED format")
+	gcskewCmd.Flags().StringP("ori-ter-file", "r", "", "save predicted origin and terminus positions (1-based) to this file")
+	gcskewCmd.Flags().StringP("plot", "p", "", "save plots of GC skew and cumulative GC skew to this file (png, svg or pdf)")
+}
+
+// gcSkewWindow is a window with 0-based half-open coordinates. The end
+// might be greater than the sequence length for circular sequences.
+type gcSkewWindow struct {
+	start, end    int
+	skew, cumSkew float64
+}
+
+func gcSkewWindows(s []byte, window, step int, circular bool) []gcSkewWindow {
+	l := len(s)
+	if l == 0 {
+		return nil
+	}
+	// prefix sums of G and C counts
+	g := make([]int, l+1)
+	c := make([]int, l+1)
+	for i, b := range s {
+		g[i+1], c[i+1] = g[i], c[i]
+		switch b {
+		case 'G', 'g':
+			g[i+1]++
+		case 'C', 'c':
+			c[i+1]++
+		}
+	}
+	count := func(b, e int) (int, int) { // e might exceed l for circular sequences
+		if e <= l {
+			return g[e] - g[b], c[e] - c[b]
+		}
+		e -= l
+		return g[l] - g[b] + g[e], c[l] - c[b] + c[e]
+	}
+
+	if window > l {
+		window = l
+	}
+	windows := make([]gcSkewWindow, 0, l/step+1)
+	var nG, nC int
+	var cum float64
+	for b := 0; b < l; b += step {
+		e := b + window
+		if e > l && !circular {
+			break
+		}
+		nG, nC = count(b, e)
+		w := gcSkewWindow{start: b, end: e}
+		if nG+nC > 0 {
+			w.skew = float64(nG-nC) / float64(nG+nC)
+		}
+		cum += w.skew
+		w.cumSkew = cum
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+// predictOriTer returns the 0-based positions of the minimum and maximum of
+// the cumulative G-C counts, i.e., the predicted origin and terminus.
+func predictOriTer(s []byte) (int, int) {
+	var cum, min, max int
+	var ori, ter int
+	for i, b := range s {
+		switch b {
+		case 'G', 'g':
+			cum++
+		case 'C', 'c':
+			cum--
+		default:
+			continue
+		}
+		if cum < min {
+			min, ori = cum, i
+		}
+		if cum > max {
+			max, ter = cum, i
+		}
+	}
+	return ori, ter
+}
+
+func saveGCSkewPlot(title string, windows []gcSkewWindow, file string) error {
+	p, err := plot.New()
+	if err != nil {
+		return err
+	}
+	p.Title.Text = title
+	p.X.Label.Text = "Position"
+	p.Y.Label.Text = "GC skew"
+
+	skews := make(plotter.XYs, len(windows))
+	cumSkews := make(plotter.XYs, len(windows))
+	var max float64
+	for _, w := range windows {
+		if w.cumSkew > max {
+			max = w.cumSkew
+		} else if -w.cumSkew > max {
+			max = -w.cumSkew
+		}
+	}
+	for i, w := range windows {
+		skews[i].X = float64(w.start+w.end) / 2
+		skews[i].Y = w.skew
+		cumSkews[i].X = skews[i].X
+		cumSkews[i].Y = w.cumSkew
+		if max > 0 { // scaled to [-1, 1]
+			cumSkews[i].Y /= max
+		}
+	}
+	line1, err := plotter.NewLine(skews)
+	if err != nil {
+		return err
+	}
+	line2, err := plotter.NewLine(cumSkews)
+	if err != nil {
+		return err
+	}
+	line2.Dashes = []vg.Length{vg.Points(4), vg.Points(2)}
+	p.Add(line1, line2)
+	p.Legend.Add("GC skew", line1)
+	p.Legend.Add("cumulative GC skew (scaled)", line2)
+	return p.Save(8*vg.Inch, 4*vg.Inch, file)
+}
@@ -0,0 +1,231 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+)
+
+// SplicedFeature is a transcript-like feature composed of one or more
+// blocks (exons), which are concatenated when extracting sequences.
+type SplicedFeature struct {
+	Chr    string
+	Name   string
+	Strand string   // "+", "-" or "."
+	Blocks [][2]int // 1based, end included, sorted by start
+	Phase  int      // bases to skip at the 5' end before translation
+}
+
+// Start returns the start position of the first block.
+func (f *SplicedFeature) Start() int {
+	return f.Blocks[0][0]
+}
+
+// End returns the end position of the last block.
+func (f *SplicedFeature) End() int {
+	return f.Blocks[len(f.Blocks)-1][1]
+}
+
+// BedFeature2SplicedFeature converts a BED feature to a SplicedFeature,
+// using blocks of BED12 or the whole region.
+func BedFeature2SplicedFeature(b BedFeature) *SplicedFeature {
+	f := &SplicedFeature{Chr: b.Chr, Strand: "."}
+	if b.Name != nil {
+		f.Name = *b.Name
+	}
+	if b.Strand != nil {
+		f.Strand = *b.Strand
+	}
+	if len(b.Blocks) > 0 {
+		f.Blocks = make([][2]int, len(b.Blocks))
+		copy(f.Blocks, b.Blocks)
+		sortBlocks(f.Blocks)
+	} else {
+		f.Blocks = [][2]int{{b.Start, b.End}}
+	}
+	return f
+}
+
+func sortBlocks(blocks [][2]int) {
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i][0] < blocks[j][0] })
+}
+
+// ReadGFF3SplicedFeatures reads features of the given type (case ignored)
+// from a GFF3 file and groups them by their Parent attributes.
+// Features without Parent are grouped by their IDs. The phase of the
+// 5'-most feature of every group is kept for translation.
+func ReadGFF3SplicedFeatures(file string, chrs []string, featureType string) ([]*SplicedFeature, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	chrsMap := make(map[string]struct{}, len(chrs))
+	for _, chr := range chrs {
+		chrsMap[strings.ToLower(chr)] = struct{}{}
+	}
+
+	type block struct {
+		start, end, phase int
+	}
+	type group struct {
+		feature *SplicedFeature
+		blocks  []block
+	}
+	groups := make(map[string]*group)
+	var order []string
+
+	var items []string
+	var start, end, phase int
+	var parents, key string
+	var g *group
+	var ok bool
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "##FASTA" {
+			break
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		items = strings.Split(line, "\t")
+		if len(items) != 9 {
+			return nil, fmt.Errorf("invalid GFF3 line, 9 columns expected: %s", line)
+		}
+		if !strings.EqualFold(items[2], featureType) {
+			continue
+		}
+		if len(chrs) > 0 {
+			if _, ok = chrsMap[strings.ToLower(items[0])]; !ok {
+				continue
+			}
+		}
+
+		start, err = strconv.Atoi(items[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad start: %s", items[0], items[3])
+		}
+		end, err = strconv.Atoi(items[4])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad end: %s", items[0], items[4])
+		}
+		if start < 1 || start > end {
+			return nil, fmt.Errorf("%s: start (%d) must be >= 1 and <= end (%d)", items[0], start, end)
+		}
+		if items[6] != "+" && items[6] != "-" && items[6] != "." && items[6] != "?" {
+			return nil, fmt.Errorf("bad strand: %s", items[6])
+		}
+		phase = 0
+		if items[7] != "." {
+			phase, err = strconv.Atoi(items[7])
+			if err != nil || phase < 0 || phase > 2 {
+				return nil, fmt.Errorf("%s: bad phase: %s", items[0], items[7])
+			}
+		}
+
+		strand := items[6]
+		if strand == "?" {
+			strand = "."
+		}
+
+		attrs := parseGFF3Attributes(items[8])
+		parents = attrs["Parent"]
+		if parents == "" {
+			parents = attrs["ID"]
+		}
+		if parents == "" {
+			parents = fmt.Sprintf("%s_%d-%d", items[0], start, end)
+		}
+		for _, parent := range strings.Split(parents, ",") {
+			parent = gff3Unescape(parent)
+			key = items[0] + "\t" + parent
+			if g, ok = groups[key]; !ok {
+				g = &group{feature: &SplicedFeature{Chr: items[0], Name: parent, Strand: strand}}
+				groups[key] = g
+				order = append(order, key)
+			} else if g.feature.Strand != strand {
+				return nil, fmt.Errorf("inconsistent strands of features with the same parent: %s", parent)
+			}
+			g.blocks = append(g.blocks, block{start, end, phase})
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	features := make([]*SplicedFeature, 0, len(order))
+	for _, key = range order {
+		g = groups[key]
+		sort.Slice(g.blocks, func(i, j int) bool { return g.blocks[i].start < g.blocks[j].start })
+		g.feature.Blocks = make([][2]int, len(g.blocks))
+		for i, b := range g.blocks {
+			g.feature.Blocks[i] = [2]int{b.start, b.end}
+		}
+		if g.feature.Strand == "-" {
+			g.feature.Phase = g.blocks[len(g.blocks)-1].phase
+		} else {
+			g.feature.Phase = g.blocks[0].phase
+		}
+		features = append(features, g.feature)
+	}
+	return features, nil
+}
+
+// parseGFF3Attributes parses the 9th column of GFF3. Values are kept
+// escaped, as multiple values are separated by commas.
+func parseGFF3Attributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	var i int
+	for _, kv := range strings.Split(s, ";") {
+		kv = strings.TrimSpace(kv)
+		i = strings.Index(kv, "=")
+		if i <= 0 {
+			continue
+		}
+		attrs[kv[:i]] = kv[i+1:]
+	}
+	return attrs
+}
+
+// gff3Escape escapes characters with special meanings in GFF3 attributes.
+func gff3Escape(s string) string {
+	return gff3Escaper.Replace(s)
+}
+
+var gff3Escaper = strings.NewReplacer("%", "%25", ";", "%3B", "=", "%3D", "&", "%26", ",", "%2C", "\t", "%09")
+
+// gff3Unescape decodes percent-encoded characters in GFF3 attribute values.
+func gff3Unescape(s string) string {
+	v, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return v
+}
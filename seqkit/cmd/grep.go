@@ -60,6 +60,9 @@ Attentions:
   3. Degenerate bases/residues like "RYMM.." are also supported by flag -d.
      But do not use degenerate bases/residues in regular expression, you need
      convert them to regular expression, e.g., change "N" or "X"  to ".".
+     Degenerate bases are also supported along with mismatches (-m), and
+     insertions and deletions are also counted with flag -I/--indel,
+     which is suitable for short primers/probes (<= 64 bp).
   4. When providing search patterns (motifs) via flag '-p',
      please use double quotation marks for patterns containing comma, 
      e.g., -p '"A{2,}"' or -p "\"A{2,}\"". Because the command line argument
@@ -69,6 +72,9 @@ Attentions:
      file, not the order of the query patterns. 
      But for FASTA file, you can use:
         seqkit faidx seqs.fasta --infile-list IDs.txt
+  6. Input files ending with ".tar", ".tar.gz" or ".tgz" are read as
+     archives of FASTA/Q files, extracting one file at a time to a
+     temporary file.
 
 You can specify the sequence region for searching with flag -R (--region).
 The definition of region is 1-based and with some custom design.
@@ -99,6 +105,7 @@ Examples:
 		bySeq := getFlagBool(cmd, "by-seq")
 		onlyPositiveStrand := getFlagBool(cmd, "only-positive-strand")
 		mismatches := getFlagNonNegativeInt(cmd, "max-mismatch")
+		indel := getFlagBool(cmd, "indel")
 		byName := getFlagBool(cmd, "by-name")
 		ignoreCase := getFlagBool(cmd, "ignore-case")
 		degenerate := getFlagBool(cmd, "degenerate")
@@ -114,16 +121,24 @@ Examples:
 			bySeq = true
 		}
 
+		if indel && mismatches == 0 {
+			checkError(fmt.Errorf("flag -I (--indel) needs a positive value of flag -m (--max-mismatch)"))
+		}
+		// approximate matching with degenerate bases or indels
+		approx := mismatches > 0 && (degenerate || indel)
+
 		var sfmi *fmi.FMIndex
 		if mismatches > 0 {
-			if useRegexp || degenerate {
-				checkError(fmt.Errorf("flag -r (--use-regexp) or -d (--degenerate) not allowed when giving flag -m (--max-mismatch)"))
+			if useRegexp {
+				checkError(fmt.Errorf("flag -r (--use-regexp) not allowed when giving flag -m (--max-mismatch)"))
 			}
 			if !bySeq {
 				log.Infof("when value of flag -m (--max-mismatch) > 0, flag -s (--by-seq) is automatically on")
 				bySeq = true
 			}
-			sfmi = fmi.NewFMIndex()
+			if !approx {
+				sfmi = fmi.NewFMIndex()
+			}
 			if mismatches > 4 {
 				log.Warningf("large value flag -m/--max-mismatch will slow down the search")
 			}
@@ -160,6 +175,7 @@ Examples:
 
 		// prepare pattern
 		patterns := make(map[string]*regexp.Regexp)
+		var p string
 		var pattern2seq *seq.Seq
 		var pbyte []byte
 		if patternFile != "" {
@@ -178,7 +194,7 @@ Examples:
 						log.Warningf("space found in pattern: %s", p)
 					}
 
-					if degenerate || useRegexp {
+					if (degenerate && !approx) || useRegexp {
 						if degenerate {
 							pattern2seq, err = seq.NewSeq(alphabet, []byte(p))
 							if err != nil {
@@ -222,7 +238,7 @@ Examples:
 				if !quiet && strings.IndexAny(p, "\t ") >= 0 {
 					log.Warningf("space found in pattern: '%s'", p)
 				}
-				if degenerate || useRegexp {
+				if (degenerate && !approx) || useRegexp {
 					if degenerate {
 						pattern2seq, err = seq.NewSeq(alphabet, []byte(p))
 						if err != nil {
@@ -262,6 +278,16 @@ Examples:
 			}
 		}
 
+		var matchers map[string]*approxMatcher
+		if approx {
+			matchers = make(map[string]*approxMatcher, len(patterns))
+			for p = range patterns {
+				m, err := newApproxMatcher([]byte(p), mismatches, indel)
+				checkError(err)
+				matchers[p] = m
+			}
+		}
+
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 		defer outfh.Close()
@@ -270,15 +296,14 @@ Examples:
 		var target []byte
 		var ok, hit bool
 		var record *fastx.Record
-		var fastxReader *fastx.Reader
+		var fastxReader FastxRecordReader
 		var k string
 		var locs []int
 		var re *regexp.Regexp
-		var p string
 		strands := []byte{'+', '-'}
 		var strand byte
 		for _, file := range files {
-			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			fastxReader, err = NewFastxRecordReader(alphabet, file, idRegexp)
 			checkError(err)
 
 			for {
@@ -290,7 +315,7 @@ Examples:
 					checkError(err)
 					break
 				}
-				if fastxReader.IsFastq {
+				if readerIsFastq(fastxReader) {
 					config.LineWidth = 0
 					fastx.ForcelyOutputFastq = true
 				}
@@ -337,7 +362,7 @@ Examples:
 						}
 					}
 
-					if degenerate || useRegexp {
+					if (degenerate && !approx) || useRegexp {
 						for p, re = range patterns {
 							if re.Match(target) {
 								hit = true
@@ -351,7 +376,17 @@ Examples:
 						if ignoreCase {
 							target = bytes.ToLower(target)
 						}
-						if mismatches == 0 {
+						if approx {
+							for k = range patterns {
+								if matchers[k].match(target) {
+									hit = true
+									if deleteMatched && !invertMatch {
+										delete(patterns, k)
+									}
+									break
+								}
+							}
+						} else if mismatches == 0 {
 							for k = range patterns {
 								if bytes.Contains(target, []byte(k)) {
 									hit = true
@@ -425,6 +460,7 @@ func init() {
 	grepCmd.Flags().BoolP("by-seq", "s", false, "search subseq on seq, both positive and negative strand are searched, and mismatch allowed using flag -m/--max-mismatch")
 	grepCmd.Flags().BoolP("only-positive-strand", "P", false, "only search on positive strand")
 	grepCmd.Flags().IntP("max-mismatch", "m", 0, "max mismatch when matching by seq. For large genomes like human genome, using mapping/alignment tools would be faster")
+	grepCmd.Flags().BoolP("indel", "I", false, "also allow insertions and deletions within -m/--max-mismatch edits, for patterns <= 64 bp")
 	grepCmd.Flags().BoolP("ignore-case", "i", false, "ignore case")
 	grepCmd.Flags().BoolP("degenerate", "d", false, "pattern/motif contains degenerate base")
 	grepCmd.Flags().StringP("region", "R", "", "specify sequence region for searching. "+
@@ -36,6 +36,9 @@ var headCmd = &cobra.Command{
 	Short: "print first N FASTA/Q records",
 	Long: `print first N FASTA/Q records
 
+Input files ending with ".tar", ".tar.gz" or ".tgz" are read as archives
+of FASTA/Q files, extracting one file at a time to a temporary file.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -56,10 +59,10 @@ var headCmd = &cobra.Command{
 		defer outfh.Close()
 
 		var record *fastx.Record
-		var fastxReader *fastx.Reader
+		var fastxReader FastxRecordReader
 		i := 0
 		for _, file := range files {
-			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			fastxReader, err = NewFastxRecordReader(alphabet, file, idRegexp)
 			checkError(err)
 
 			for {
@@ -71,7 +74,7 @@ var headCmd = &cobra.Command{
 					checkError(err)
 					break
 				}
-				if fastxReader.IsFastq {
+				if readerIsFastq(fastxReader) {
 					config.LineWidth = 0
 					fastx.ForcelyOutputFastq = true
 				}
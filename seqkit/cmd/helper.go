@@ -36,6 +36,7 @@ import (
 	"github.com/shenwei356/bio/seqio/fai"
 	"github.com/shenwei356/bio/seqio/fastx"
 	"github.com/shenwei356/breader"
+	"github.com/shenwei356/seqkit/seqkit/pkg/bamtool"
 	"github.com/shenwei356/util/byteutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -53,10 +54,15 @@ func getFileList(args []string, checkFile bool) []string {
 	if len(args) == 0 {
 		files = append(files, "-")
 	} else {
-		for _, file := range args {
+		for i, file := range args {
 			if isStdin(file) {
 				continue
 			}
+			if bamtool.IsRemote(file) {
+				// S3 objects are read over HTTPS
+				args[i] = bamtool.S3ToHTTPS(file)
+				continue
+			}
 			if !checkFile {
 				continue
 			}
@@ -105,14 +111,17 @@ func getFileListFromArgsAndFile(cmd *cobra.Command, args []string, checkFileFrom
 		checkError(err)
 		if len(_files) == 0 {
 			log.Warningf("no files found in file list: %s", infileList)
+			checkInputIntegrity(cmd, files)
 			return files
 		}
 
 		if len(files) == 1 && isStdin(files[0]) {
+			checkInputIntegrity(cmd, _files)
 			return _files
 		}
 		files = append(files, _files...)
 	}
+	checkInputIntegrity(cmd, files)
 	return files
 }
 
@@ -0,0 +1,283 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/bio/seqio/fai"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// idxCmd represents the idx command
+var idxCmd = &cobra.Command{
+	Use:   "idx",
+	Short: "create, validate and clean index files of FASTA files",
+	Long: `create, validate and clean index files of FASTA files
+
+This command manages the sidecar index files created by seqkit for FASTA
+files, the arguments can be files or directories, which are searched
+recursively for files with the extensions given by -x/--extension:
+
+  $file.seqkit.fai   FASTA index used by subseq, faidx -f and bam -T
+  $file.fai          FASTA index created by faidx, checked if it exists
+  $file.gzi          block index of bgzip-compressed files
+
+The status of every index is reported in a table:
+
+  ok        the index is valid and not older than the FASTA file
+  missing   the index does not exist
+  stale     the FASTA file was modified after the index was created
+  invalid   the index can not be parsed or does not match the file size
+
+Staleness is detected by comparing modification times only, no checksums
+of the FASTA files are recorded.
+
+Missing and stale indexes are created with -c/--create, all indexes
+are rebuilt with -r/--rebuild, and removed with --clean. Files are
+processed in parallel by -j/--threads.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		runtime.GOMAXPROCS(config.Threads)
+
+		create := getFlagBool(cmd, "create")
+		rebuild := getFlagBool(cmd, "rebuild")
+		clean := getFlagBool(cmd, "clean")
+		fullHead := getFlagBool(cmd, "full-head")
+		exts := getFlagStringSlice(cmd, "extension")
+
+		if clean && (create || rebuild) {
+			checkError(fmt.Errorf("flag --clean is not compatible with -c/--create or -r/--rebuild"))
+		}
+		if len(args) == 0 {
+			checkError(fmt.Errorf("no input files or directories given"))
+		}
+
+		idRegexp := config.IDRegexp
+		if fullHead {
+			idRegexp = "^(.+)$"
+		}
+		action := idxCheck
+		if clean {
+			action = idxClean
+		} else if rebuild {
+			action = idxRebuild
+		} else if create {
+			action = idxCreate
+		}
+
+		files := idxInputFiles(args, exts)
+
+		results := make([][]idxResult, len(files))
+		ch := make(chan int)
+		var wg sync.WaitGroup
+		for t := 0; t < config.Threads; t++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range ch {
+					results[i] = manageIndexes(files[i], idRegexp, action)
+				}
+			}()
+		}
+		for i := range files {
+			ch <- i
+		}
+		close(ch)
+		wg.Wait()
+
+		outfh, err := xopen.Wopen(config.OutFile)
+		checkError(err)
+		defer outfh.Close()
+
+		outfh.WriteString("file\tindex\tstatus\taction\n")
+		for _, res := range results {
+			for _, r := range res {
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\n", r.file, r.index, r.status, r.action))
+			}
+		}
+	},
+}
+
+const (
+	idxCheck = iota
+	idxCreate
+	idxRebuild
+	idxClean
+)
+
+type idxResult struct {
+	file   string
+	index  string
+	status string
+	action string
+}
+
+// idxInputFiles returns the files given as arguments and the files with one
+// of the extensions in the given directories.
+func idxInputFiles(args []string, exts []string) []string {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		checkError(err)
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			name := strings.ToLower(path)
+			for _, ext := range exts {
+				if strings.HasSuffix(name, strings.ToLower(ext)) {
+					files = append(files, path)
+					break
+				}
+			}
+			return nil
+		})
+		checkError(err)
+	}
+	return files
+}
+
+// manageIndexes checks the indexes of a FASTA file and applies the action.
+func manageIndexes(file string, idRegexp string, action int) []idxResult {
+	info, err := os.Stat(file)
+	checkError(err)
+	bgzf, err := isBgzfFile(file)
+	checkError(err)
+	if !bgzf && strings.HasSuffix(strings.ToLower(file), ".gz") {
+		return []idxResult{{file, "-", "unsupported", "not compressed by bgzip"}}
+	}
+
+	type index struct {
+		file     string
+		idRegexp string
+	}
+	indexes := []index{{file + ".seqkit.fai", idRegexp}}
+	if action == idxClean || !fileNotExists(file+".fai") {
+		indexes = append(indexes, index{file + ".fai", fastx.DefaultIDRegexp})
+	}
+	if bgzf {
+		indexes = append(indexes, index{file + ".gzi", ""})
+	}
+
+	var results []idxResult
+	for _, idx := range indexes {
+		status := indexStatus(file, info, idx.file, bgzf)
+		res := idxResult{file, idx.file, status, "-"}
+		switch action {
+		case idxClean:
+			if status == "missing" {
+				continue
+			}
+			checkError(os.Remove(idx.file))
+			res.action = "removed"
+		case idxCreate, idxRebuild:
+			if status == "ok" && action == idxCreate {
+				break
+			}
+			checkError(buildIndex(file, idx.file, idx.idRegexp, bgzf))
+			if status == "missing" {
+				res.action = "created"
+			} else {
+				res.action = "rebuilt"
+			}
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// indexStatus returns the status of an index: ok, missing, stale or invalid.
+func indexStatus(file string, info os.FileInfo, index string, bgzf bool) string {
+	idxInfo, err := os.Stat(index)
+	if err != nil {
+		return "missing"
+	}
+	if idxInfo.ModTime().Before(info.ModTime()) {
+		return "stale"
+	}
+
+	if strings.HasSuffix(index, ".gzi") {
+		entries, err := readGzi(index)
+		if err != nil || entries[len(entries)-1].compressed >= uint64(info.Size()) {
+			return "invalid"
+		}
+		return "ok"
+	}
+
+	idx, err := fai.Read(index)
+	if err != nil {
+		return "invalid"
+	}
+	if bgzf { // the uncompressed size is unknown
+		return "ok"
+	}
+	for _, r := range idx {
+		if r.BasesPerLine <= 0 {
+			return "invalid"
+		}
+		end := r.Start + int64(r.Length/r.BasesPerLine*r.BytesPerLine+r.Length%r.BasesPerLine)
+		if end > info.Size() {
+			return "invalid"
+		}
+	}
+	return "ok"
+}
+
+// buildIndex (re)creates an index of a FASTA file.
+func buildIndex(file string, index string, idRegexp string, bgzf bool) error {
+	var err error
+	switch {
+	case strings.HasSuffix(index, ".gzi"):
+		_, err = createGzi(file, index)
+	case bgzf:
+		_, err = createBgzfFai(file, index, idRegexp)
+	default:
+		_, err = fai.CreateWithIDRegexp(file, index, idRegexp)
+	}
+	return err
+}
+
+func init() {
+	RootCmd.AddCommand(idxCmd)
+
+	idxCmd.Flags().BoolP("create", "c", false, "create missing indexes and rebuild stale or invalid ones")
+	idxCmd.Flags().BoolP("rebuild", "r", false, "rebuild all indexes")
+	idxCmd.Flags().BoolP("clean", "", false, "remove all indexes")
+	idxCmd.Flags().BoolP("full-head", "f", false, "index full header lines in .seqkit.fai, as faidx -f")
+	idxCmd.Flags().StringSliceP("extension", "x", []string{".fa", ".fasta", ".fna", ".fas", ".fa.gz", ".fasta.gz", ".fna.gz", ".fas.gz"}, "extensions of FASTA files searched in directories")
+}
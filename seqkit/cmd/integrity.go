@@ -0,0 +1,174 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// bgzfEOF is the empty BGZF block that terminates a well-formed BGZF
+// stream, as described in the SAM/BAM specification.
+var bgzfEOF = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00,
+	0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00,
+}
+
+// checkInputIntegrity checks the end of input files according to
+// the global flags --eof-check and --eof-check-gzip.
+// Stdin and non-regular files (e.g. named pipes) are skipped.
+func checkInputIntegrity(cmd *cobra.Command, files []string) {
+	if cmd.Flags().Lookup("eof-check") == nil {
+		return
+	}
+	mode := getFlagString(cmd, "eof-check")
+	switch mode {
+	case "off":
+		return
+	case "warn", "fail":
+	default:
+		checkError(fmt.Errorf("invalid value of flag --eof-check: %s. available: off, warn, fail", mode))
+	}
+	checkGzip := getFlagBool(cmd, "eof-check-gzip")
+
+	var err error
+	for _, file := range files {
+		if isStdin(file) {
+			continue
+		}
+		err = checkFileIntegrity(file, checkGzip)
+		if err == nil {
+			continue
+		}
+		if mode == "fail" {
+			checkError(fmt.Errorf("%s: %s", file, err))
+		}
+		log.Warningf("%s: %s", file, err)
+	}
+}
+
+// checkFileIntegrity checks the BGZF EOF marker of BAM and BGZF-compressed
+// files, and, if checkGzip is true, decompresses other gzip files to
+// detect truncation.
+func checkFileIntegrity(file string, checkGzip bool) error {
+	info, err := os.Stat(file)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil // missing files are reported by the readers
+	}
+	lower := strings.ToLower(file)
+	isBam := strings.HasSuffix(lower, ".bam")
+	isGz := strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".bgz")
+	if !isBam && !isGz {
+		return nil
+	}
+
+	bgzf, err := isBgzfFile(file)
+	if err != nil {
+		return err
+	}
+	if bgzf {
+		return checkBgzfEOF(file, info.Size())
+	}
+	if isBam {
+		return fmt.Errorf("not a BGZF-compressed BAM file")
+	}
+	if checkGzip {
+		return checkGzipStream(file)
+	}
+	return nil
+}
+
+// isBgzfFile tells whether the file starts with a gzip header carrying
+// the BGZF "BC" extra subfield.
+func isBgzfFile(file string) (bool, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+
+	head := make([]byte, 16)
+	n, err := io.ReadFull(fh, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if n < 2 || head[0] != 0x1f || head[1] != 0x8b {
+		return false, nil
+	}
+	if n < 16 {
+		return false, fmt.Errorf("truncated gzip header")
+	}
+	return head[2] == 0x08 && head[3]&0x04 != 0 && head[12] == 'B' && head[13] == 'C', nil
+}
+
+// checkBgzfEOF checks whether the file ends with the BGZF EOF block.
+func checkBgzfEOF(file string, size int64) error {
+	if size < int64(len(bgzfEOF)) {
+		return fmt.Errorf("missing BGZF EOF marker, the file may be truncated")
+	}
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	tail := make([]byte, len(bgzfEOF))
+	_, err = fh.ReadAt(tail, size-int64(len(bgzfEOF)))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tail, bgzfEOF) {
+		return fmt.Errorf("missing BGZF EOF marker, the file may be truncated")
+	}
+	return nil
+}
+
+// checkGzipStream decompresses the whole (possibly multi-member) gzip
+// file and reports truncation or checksum errors.
+func checkGzipStream(file string) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	gr, err := gzip.NewReader(fh)
+	if err != nil {
+		return fmt.Errorf("invalid gzip file: %s", err)
+	}
+	defer gr.Close()
+	if _, err = io.Copy(ioutil.Discard, gr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated gzip file")
+		}
+		return fmt.Errorf("corrupted gzip file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// kmerCmd represents the kmer command
+var kmerCmd = &cobra.Command{
+	Use:   "kmer",
+	Short: "count k-mers and compute k-mer spectra",
+	Long: `count k-mers and compute k-mer spectra
+
+K-mers (k <= 32) are 2-bit encoded, and canonical k-mers (the smaller of a
+k-mer and its reverse complement) are counted unless -P/--only-positive-strand
+is given. K-mers containing bases other than A, C, G, T/U are skipped.
+K-mers are hashed into -j/--threads shards counted in parallel.
+
+Output formats:
+  default          tab-delimited k-mers and counts, sorted by k-mers
+  -H/--histogram   k-mer spectrum, i.e., tab-delimited counts and numbers
+                   of distinct k-mers with these counts
+  -J/--jellyfish   FASTA-like format of "jellyfish dump", i.e., counts
+                   as headers followed by k-mers
+
+Attention:
+  1. All distinct k-mers are kept in memory.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		k := getFlagPositiveInt(cmd, "kmer-size")
+		if k > 32 {
+			checkError(fmt.Errorf("k-mer size should not be greater than 32"))
+		}
+		canonical := !getFlagBool(cmd, "only-positive-strand")
+		minCount := getFlagPositiveInt(cmd, "min-count")
+		histogram := getFlagBool(cmd, "histogram")
+		jellyfish := getFlagBool(cmd, "jellyfish")
+		if histogram && jellyfish {
+			checkError(fmt.Errorf("flag -H/--histogram and -J/--jellyfish can't be used at the same time"))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		counter := newKmerCounter(config.Threads)
+		ch := make(chan [][]byte, config.Threads)
+		var wg sync.WaitGroup
+		for i := 0; i < config.Threads; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				counter.count(ch, k, canonical)
+			}()
+		}
+
+		const batchBases = 1 << 20
+		var batch [][]byte
+		var bases, nSeqs int
+		var err error
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				nSeqs++
+				batch = append(batch, append([]byte(nil), record.Seq.Seq...))
+				bases += len(record.Seq.Seq)
+				if bases >= batchBases {
+					ch <- batch
+					batch, bases = nil, 0
+				}
+			}
+		}
+		if len(batch) > 0 {
+			ch <- batch
+		}
+		close(ch)
+		wg.Wait()
+		counter.close()
+
+		var total uint64
+		var distinct int
+		for _, m := range counter.shards {
+			for _, c := range m {
+				total += uint64(c)
+			}
+			distinct += len(m)
+		}
+		if !quiet {
+			log.Infof("%d k-mers (%d distinct) counted from %d sequences", total, distinct, nSeqs)
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		if histogram {
+			spectrum := make(map[uint32]int)
+			for _, m := range counter.shards {
+				for _, c := range m {
+					spectrum[c]++
+				}
+			}
+			keys := make([]uint32, 0, len(spectrum))
+			for c := range spectrum {
+				if int(c) >= minCount {
+					keys = append(keys, c)
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+			for _, c := range keys {
+				fmt.Fprintf(outfh, "%d\t%d\n", c, spectrum[c])
+			}
+			return
+		}
+
+		codes := make([]uint64, 0, distinct)
+		for _, m := range counter.shards {
+			for code, c := range m {
+				if int(c) >= minCount {
+					codes = append(codes, code)
+				}
+			}
+		}
+		sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+		for _, code := range codes {
+			if jellyfish {
+				fmt.Fprintf(outfh, ">%d\n%s\n", counter.get(code), DecodeKmer(code, k))
+			} else {
+				fmt.Fprintf(outfh, "%s\t%d\n", DecodeKmer(code, k), counter.get(code))
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(kmerCmd)
+
+	kmerCmd.Flags().IntP("kmer-size", "k", 21, "k-mer size (<= 32)")
+	kmerCmd.Flags().BoolP("only-positive-strand", "P", false, "count k-mers of the positive strand instead of canonical k-mers")
+	kmerCmd.Flags().IntP("min-count", "m", 1, "only output k-mers with counts of at least this value")
+	kmerCmd.Flags().BoolP("histogram", "H", false, "output k-mer spectrum (count and number of distinct k-mers) instead of k-mers")
+	kmerCmd.Flags().BoolP("jellyfish", "J", false, `output in the FASTA-like format of "jellyfish dump"`)
+}
+
+// kmerCounter counts k-mers in shards, every shard is owned by a goroutine,
+// to which k-mers are dispatched by their hash values.
+type kmerCounter struct {
+	shards []map[uint64]uint32
+	chs    []chan []uint64
+	wg     sync.WaitGroup
+}
+
+const kmerCounterBufSize = 4096
+
+func newKmerCounter(n int) *kmerCounter {
+	c := &kmerCounter{
+		shards: make([]map[uint64]uint32, n),
+		chs:    make([]chan []uint64, n),
+	}
+	for i := 0; i < n; i++ {
+		c.shards[i] = make(map[uint64]uint32)
+		c.chs[i] = make(chan []uint64, 8)
+		c.wg.Add(1)
+		go func(m map[uint64]uint32, ch chan []uint64) {
+			defer c.wg.Done()
+			for codes := range ch {
+				for _, code := range codes {
+					m[code]++
+				}
+			}
+		}(c.shards[i], c.chs[i])
+	}
+	return c
+}
+
+// count hashes k-mers of sequences from ch and dispatches them to shards.
+func (c *kmerCounter) count(ch chan [][]byte, k int, canonical bool) {
+	n := uint64(len(c.shards))
+	bufs := make([][]uint64, n)
+	for i := range bufs {
+		bufs[i] = make([]uint64, 0, kmerCounterBufSize)
+	}
+	var i uint64
+	for batch := range ch {
+		for _, s := range batch {
+			ForEachKmer(s, k, canonical, func(code uint64, pos int) {
+				i = hash64(code) % n
+				bufs[i] = append(bufs[i], code)
+				if len(bufs[i]) == kmerCounterBufSize {
+					c.chs[i] <- bufs[i]
+					bufs[i] = make([]uint64, 0, kmerCounterBufSize)
+				}
+			})
+		}
+	}
+	for i, buf := range bufs {
+		if len(buf) > 0 {
+			c.chs[i] <- buf
+		}
+	}
+}
+
+// close waits for all shards to finish counting.
+func (c *kmerCounter) close() {
+	for _, ch := range c.chs {
+		close(ch)
+	}
+	c.wg.Wait()
+}
+
+// get returns the count of a k-mer.
+func (c *kmerCounter) get(code uint64) uint32 {
+	return c.shards[hash64(code)%uint64(len(c.shards))][code]
+}
@@ -56,6 +56,9 @@ Attentions:
   4. Mismatch is allowed using flag "-m/--max-mismatch",
      but it's not fast enough for large genome like human genome.
      Though, it's fast enough for microbial genomes.
+     Degenerate bases in patterns (-d) are supported along with mismatches,
+     and insertions and deletions are also counted with flag -I/--indel,
+     which is suitable for short primers/probes (<= 64 bp).
   5. When using flag --circular, end position of matched subsequence that 
      crossing genome sequence end would be greater than sequence length.
 
@@ -88,7 +91,9 @@ Attentions:
 		nonGreedy := getFlagBool(cmd, "non-greedy")
 		outFmtGTF := getFlagBool(cmd, "gtf")
 		outFmtBED := getFlagBool(cmd, "bed")
+		outFmtGFF := getFlagBool(cmd, "gff")
 		mismatches := getFlagNonNegativeInt(cmd, "max-mismatch")
+		indel := getFlagBool(cmd, "indel")
 		hideMatched := getFlagBool(cmd, "hide-matched")
 		circular := getFlagBool(cmd, "circular")
 
@@ -100,18 +105,33 @@ Attentions:
 			checkError(fmt.Errorf("one of flags -p (--pattern) and -f (--pattern-file) needed"))
 		}
 
+		var nFmts int
+		for _, f := range []bool{outFmtGTF, outFmtGFF, outFmtBED} {
+			if f {
+				nFmts++
+			}
+		}
+		if nFmts > 1 {
+			checkError(fmt.Errorf("only one of flags --gtf, --gff and --bed allowed"))
+		}
+
+		if indel && mismatches == 0 {
+			checkError(fmt.Errorf("flag -I (--indel) needs a positive value of flag -m (--max-mismatch)"))
+		}
+		// approximate matching with degenerate bases or indels
+		approx := mismatches > 0 && (degenerate || indel)
+
 		var sfmi *fmi.FMIndex
 		if mismatches > 0 {
-			if degenerate {
-				checkError(fmt.Errorf("flag -d (--degenerate) not allowed when giving flag -m (--max-mismatch)"))
-			}
 			if useRegexp {
 				checkError(fmt.Errorf("flag -r (--use-regexp) not allowed when giving flag -m (--use-regexp)"))
 			}
 			if nonGreedy && !quiet {
 				log.Infof("flag -G (--non-greedy) ignored when giving flag -m (--max-mismatch)")
 			}
-			sfmi = fmi.NewFMIndex()
+			if !approx {
+				sfmi = fmi.NewFMIndex()
+			}
 		}
 		if useFMI {
 			if degenerate {
@@ -216,7 +236,6 @@ Attentions:
 						}
 						re, err := regexp.Compile(s)
 						checkError(err)
-						fmt.Println(s, re)
 						regexps[p] = re
 					} else if bytes.Index(patterns[p], []byte(".")) >= 0 ||
 						!(seq.DNAredundant.IsValid(patterns[p]) == nil ||
@@ -228,11 +247,78 @@ Attentions:
 			}
 		}
 
+		var matchers map[string]*approxMatcher
+		if approx {
+			matchers = make(map[string]*approxMatcher, len(patterns))
+			for name, p := range patterns {
+				m, err := newApproxMatcher(p, mismatches, indel)
+				checkError(err)
+				matchers[name] = m
+			}
+		}
+
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 		defer outfh.Close()
 
-		if !(outFmtGTF || outFmtBED) {
+		var nHits int
+		outputHit := func(record *fastx.Record, pName string, strand string, begin, end int, score int, matched []byte) {
+			if outFmtGTF {
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\tgene_id \"%s\"; \n",
+					record.ID,
+					"SeqKit",
+					"location",
+					begin,
+					end,
+					score,
+					strand,
+					".",
+					pName))
+			} else if outFmtGFF {
+				nHits++
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\tID=hit%d;Name=%s;mismatches=%d\n",
+					record.ID,
+					"SeqKit",
+					"nucleotide_motif",
+					begin,
+					end,
+					score,
+					strand,
+					".",
+					nHits,
+					gff3Escape(pName),
+					score))
+			} else if outFmtBED {
+				outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n",
+					record.ID,
+					begin-1,
+					end,
+					pName,
+					score,
+					strand))
+			} else if hideMatched {
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\n",
+					record.ID,
+					pName,
+					patterns[pName],
+					strand,
+					begin,
+					end))
+			} else {
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+					record.ID,
+					pName,
+					patterns[pName],
+					strand,
+					begin,
+					end,
+					matched))
+			}
+		}
+
+		if outFmtGFF {
+			outfh.WriteString("##gff-version 3\n")
+		} else if !(outFmtGTF || outFmtBED) {
 			if hideMatched {
 				outfh.WriteString("seqID\tpatternName\tpattern\tstrand\tstart\tend\n")
 			} else {
@@ -277,6 +363,34 @@ Attentions:
 					seqRP = record.Seq.RevCom()
 				}
 
+				if approx {
+					for pName, m := range matchers {
+						for _, hit := range m.findAll(record.Seq.Seq) {
+							if circular && hit.start >= l { // 2nd clone of original part
+								continue
+							}
+							outputHit(record, pName, "+", hit.start+1, hit.end, hit.edits, record.Seq.Seq[hit.start:hit.end])
+						}
+
+						if onlyPositiveStrand {
+							continue
+						}
+
+						for _, hit := range m.findAll(seqRP.Seq) {
+							if circular && hit.start >= l { // 2nd clone of original part
+								continue
+							}
+							begin, end = l-hit.end+1, l-hit.start
+							if hit.end > l {
+								begin += l
+								end += l
+							}
+							outputHit(record, pName, "-", begin, end, hit.edits, seqRP.Seq[hit.start:hit.end])
+						}
+					}
+					continue
+				}
+
 				if mismatches > 0 || useFMI {
 					_, err = sfmi.Transform(record.Seq.Seq)
 					if err != nil {
@@ -299,45 +413,7 @@ Attentions:
 							if i+len(pSeq) > len(record.Seq.Seq) {
 								continue
 							}
-							if outFmtGTF {
-								outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\tgene_id \"%s\"; \n",
-									record.ID,
-									"SeqKit",
-									"location",
-									begin,
-									end,
-									0,
-									"+",
-									".",
-									pName))
-							} else if outFmtBED {
-								outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n",
-									record.ID,
-									begin-1,
-									end,
-									pName,
-									0,
-									"+"))
-							} else {
-								if hideMatched {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"+",
-										begin,
-										end))
-								} else {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"+",
-										begin,
-										end,
-										record.Seq.Seq[i:i+len(pSeq)]))
-								}
-							}
+							outputHit(record, pName, "+", begin, end, countMismatches(pSeq, record.Seq.Seq[i:i+len(pSeq)]), record.Seq.Seq[i:i+len(pSeq)])
 						}
 					}
 
@@ -364,45 +440,7 @@ Attentions:
 							if i+len(pSeq) > len(record.Seq.Seq) {
 								continue
 							}
-							if outFmtGTF {
-								outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\tgene_id \"%s\"; \n",
-									record.ID,
-									"SeqKit",
-									"location",
-									begin,
-									end,
-									0,
-									"-",
-									".",
-									pName))
-							} else if outFmtBED {
-								outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n",
-									record.ID,
-									begin-1,
-									end,
-									pName,
-									0,
-									"-"))
-							} else {
-								if hideMatched {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"-",
-										begin,
-										end))
-								} else {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"-",
-										begin,
-										end,
-										seqRP.Seq[i:i+len(pSeq)]))
-								}
-							}
+							outputHit(record, pName, "-", begin, end, countMismatches(pSeq, seqRP.Seq[i:i+len(pSeq)]), seqRP.Seq[i:i+len(pSeq)])
 						}
 					}
 
@@ -451,45 +489,7 @@ Attentions:
 						}
 
 						if flag {
-							if outFmtGTF {
-								outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\tgene_id \"%s\"; \n",
-									record.ID,
-									"SeqKit",
-									"location",
-									begin,
-									end,
-									0,
-									"+",
-									".",
-									pName))
-							} else if outFmtBED {
-								outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n",
-									record.ID,
-									begin-1,
-									end,
-									pName,
-									0,
-									"+"))
-							} else {
-								if hideMatched {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"+",
-										begin,
-										end))
-								} else {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"+",
-										begin,
-										end,
-										record.Seq.Seq[begin-1:end]))
-								}
-							}
+							outputHit(record, pName, "+", begin, end, 0, record.Seq.Seq[begin-1:end])
 							locs = append(locs, [2]int{begin, end})
 						}
 
@@ -548,45 +548,7 @@ Attentions:
 						}
 
 						if flag {
-							if outFmtGTF {
-								outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\tgene_id \"%s\"; \n",
-									record.ID,
-									"SeqKit",
-									"location",
-									begin,
-									end,
-									0,
-									"-",
-									".",
-									pName))
-							} else if outFmtBED {
-								outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n",
-									record.ID,
-									begin-1,
-									end,
-									pName,
-									0,
-									"-"))
-							} else {
-								if hideMatched {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"-",
-										begin,
-										end))
-								} else {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
-										record.ID,
-										pName,
-										patterns[pName],
-										"-",
-										begin,
-										end,
-										seqRP.Seq[offset+loc[0]:offset+loc[1]]))
-								}
-							}
+							outputHit(record, pName, "-", begin, end, 0, seqRP.Seq[offset+loc[0]:offset+loc[1]])
 							locsNeg = append(locsNeg, [2]int{begin, end})
 						}
 
@@ -617,9 +579,23 @@ func init() {
 	locateCmd.Flags().BoolP("only-positive-strand", "P", false, "only search on positive strand")
 	locateCmd.Flags().IntP("validate-seq-length", "V", 10000, "length of sequence to validate (0 for whole seq)")
 	locateCmd.Flags().BoolP("non-greedy", "G", false, "non-greedy mode, faster but may miss motifs overlapping with others")
-	locateCmd.Flags().BoolP("gtf", "", false, "output in GTF format")
-	locateCmd.Flags().BoolP("bed", "", false, "output in BED6 format")
+	locateCmd.Flags().BoolP("gtf", "", false, "output in GTF format, with the number of mismatches as score")
+	locateCmd.Flags().BoolP("bed", "", false, "output in BED6 format, with the number of mismatches as score")
+	locateCmd.Flags().BoolP("gff", "", false, "output in GFF3 format, with the number of mismatches as score")
 	locateCmd.Flags().IntP("max-mismatch", "m", 0, "max mismatch when matching by seq. For large genomes like human genome, using mapping/alignment tools would be faster")
+	locateCmd.Flags().BoolP("indel", "I", false, "also allow insertions and deletions within -m/--max-mismatch edits, for patterns <= 64 bp")
 	locateCmd.Flags().BoolP("hide-matched", "M", false, "do not show matched sequences")
 	locateCmd.Flags().BoolP("circular", "c", false, `circular genome. type "seqkit locate -h" for details`)
 }
+
+// countMismatches counts the mismatched bases between a pattern and
+// its matched subsequence of the same length.
+func countMismatches(p, s []byte) int {
+	var n int
+	for i := range p {
+		if p[i] != s[i] {
+			n++
+		}
+	}
+	return n
+}
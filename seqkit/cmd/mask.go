@@ -0,0 +1,453 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// maskCmd represents the mask command
+var maskCmd = &cobra.Command{
+	Use:   "mask",
+	Short: "mask sequences by BED/GFF3 regions or low-complexity regions",
+	Long: `mask sequences by BED/GFF3 regions or low-complexity regions
+
+Regions to mask come from a BED file (-b/--bed, blocks of BED12 are used),
+features of types of --gff-type in a GFF3 file (-g/--gff), and/or
+low-complexity regions detected by -l/--low-complexity:
+
+  dust     a DUST-like triplet score of every window of -W/--window bases,
+           i.e., 10 * sum(c_t * (c_t - 1) / 2) / (l - 1), where c_t is the
+           count of triplet t and l the number of triplets in the window.
+           In every window scoring above -L/--dust-level, the
+           subinterval of the highest score is masked.
+  entropy  Shannon entropy (bits) of A, C, G, T/U bases of every window of
+           -W/--window bases, windows below -E/--min-entropy are masked.
+
+Sequence IDs are matched with chromosome names case-insensitively.
+Regions are soft-masked (lower case) by default, or hard-masked with
+-c/--mask-char using flag -H/--hard. Merged masked regions of every
+sequence can be saved to a BED file (-B/--out-bed).
+
+Attentions:
+  1. BED/GFF3 regions are kept in memory.
+  2. Existing lower case bases are not treated as masked.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		bedFile := getFlagString(cmd, "bed")
+		gffFile := getFlagString(cmd, "gff")
+		gffTypes := getFlagStringSlice(cmd, "gff-type")
+		method := getFlagString(cmd, "low-complexity")
+		window := getFlagPositiveInt(cmd, "window")
+		level := getFlagNonNegativeInt(cmd, "dust-level")
+		minEntropy := getFlagFloat64(cmd, "min-entropy")
+		hard := getFlagBool(cmd, "hard")
+		maskChar := getFlagString(cmd, "mask-char")
+		outBed := getFlagString(cmd, "out-bed")
+
+		switch method {
+		case "", "dust", "entropy":
+		default:
+			checkError(fmt.Errorf("invalid value of flag -l/--low-complexity: %s, available: dust, entropy", method))
+		}
+		if method == "dust" && window < 4 {
+			checkError(fmt.Errorf("value of flag -W/--window should be >= 4 for DUST"))
+		}
+		if bedFile == "" && gffFile == "" && method == "" {
+			checkError(fmt.Errorf("one or more of flags -b/--bed, -g/--gff and -l/--low-complexity needed"))
+		}
+		if len(maskChar) != 1 {
+			checkError(fmt.Errorf("value of flag -c/--mask-char should be a single character: %s", maskChar))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		var err error
+		regions := make(map[string][][2]int)
+		var n int
+		if bedFile != "" {
+			if !quiet {
+				log.Info("read BED file ...")
+			}
+			Threads = config.Threads // threads of ReadBedFeatures
+			var features []BedFeature
+			features, err = ReadBedFeatures(bedFile)
+			checkError(err)
+			var chr string
+			for _, feature := range features {
+				chr = strings.ToLower(feature.Chr)
+				for _, b := range BedFeature2SplicedFeature(feature).Blocks {
+					regions[chr] = append(regions[chr], [2]int{b[0] - 1, b[1]})
+				}
+			}
+			n += len(features)
+		}
+		if gffFile != "" {
+			if !quiet {
+				log.Info("read GFF3 file ...")
+			}
+			var features []*SplicedFeature
+			var chr string
+			for _, gffType := range gffTypes {
+				features, err = ReadGFF3SplicedFeatures(gffFile, nil, gffType)
+				checkError(err)
+				for _, feature := range features {
+					chr = strings.ToLower(feature.Chr)
+					for _, b := range feature.Blocks {
+						regions[chr] = append(regions[chr], [2]int{b[0] - 1, b[1]})
+					}
+				}
+				n += len(features)
+			}
+		}
+		if !quiet && (bedFile != "" || gffFile != "") {
+			log.Infof("%d features loaded", n)
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var bedfh *xopen.Writer
+		if outBed != "" {
+			bedfh, err = xopen.Wopen(outBed)
+			checkError(err)
+			defer bedfh.Close()
+		}
+
+		mc := maskChar[0]
+		var masked, total int
+		var sequence []byte
+		var ivs [][2]int
+		var s, e, i int
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+
+				sequence = record.Seq.Seq
+				ivs = ivs[:0]
+				for _, iv := range regions[strings.ToLower(string(record.ID))] {
+					if iv[1] > len(sequence) {
+						iv[1] = len(sequence)
+					}
+					if iv[0] < iv[1] {
+						ivs = append(ivs, iv)
+					}
+				}
+				switch method {
+				case "dust":
+					ivs = append(ivs, dustIntervals(sequence, window, level)...)
+				case "entropy":
+					ivs = append(ivs, lowEntropyIntervals(sequence, window, minEntropy)...)
+				}
+				ivs = mergeIntervals(ivs)
+
+				for _, iv := range ivs {
+					s, e = iv[0], iv[1]
+					if hard {
+						for i = s; i < e; i++ {
+							sequence[i] = mc
+						}
+					} else {
+						for i = s; i < e; i++ {
+							if sequence[i] >= 'A' && sequence[i] <= 'Z' {
+								sequence[i] += 32
+							}
+						}
+					}
+					masked += e - s
+					if bedfh != nil {
+						fmt.Fprintf(bedfh, "%s\t%d\t%d\n", record.ID, s, e)
+					}
+				}
+				total += len(sequence)
+
+				record.FormatToWriter(outfh, config.LineWidth)
+			}
+
+			config.LineWidth = lineWidth
+		}
+
+		if !quiet {
+			log.Infof("%d of %d bases masked", masked, total)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(maskCmd)
+
+	maskCmd.Flags().StringP("bed", "b", "", "mask regions in this BED file")
+	maskCmd.Flags().StringP("gff", "g", "", "mask features of --gff-type in this GFF3 file")
+	maskCmd.Flags().StringSliceP("gff-type", "", []string{"repeat_region"}, "feature types to mask when using -g/--gff (case ignored), multiple values supported")
+	maskCmd.Flags().StringP("low-complexity", "l", "", "also mask low-complexity regions with this method (dust|entropy)")
+	maskCmd.Flags().IntP("window", "W", 64, "window size for detecting low-complexity regions")
+	maskCmd.Flags().IntP("dust-level", "L", 20, "score threshold of DUST")
+	maskCmd.Flags().Float64P("min-entropy", "E", 1.0, "minimum Shannon entropy (bits, 0-2) of windows not to be masked")
+	maskCmd.Flags().BoolP("hard", "H", false, "hard-mask with -c/--mask-char instead of soft-masking (lower case)")
+	maskCmd.Flags().StringP("mask-char", "c", "N", "character for hard-masking")
+	maskCmd.Flags().StringP("out-bed", "B", "", "save merged masked regions to this BED file")
+}
+
+// mergeIntervals sorts and merges overlapping or adjacent 0-based
+// half-open intervals in place.
+func mergeIntervals(ivs [][2]int) [][2]int {
+	if len(ivs) < 2 {
+		return ivs
+	}
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i][0] < ivs[j][0] })
+	j := 0
+	for _, iv := range ivs[1:] {
+		if iv[0] <= ivs[j][1] {
+			if iv[1] > ivs[j][1] {
+				ivs[j][1] = iv[1]
+			}
+			continue
+		}
+		j++
+		ivs[j] = iv
+	}
+	return ivs[:j+1]
+}
+
+// dustTriplets returns codes (0-63) of triplets starting at every position,
+// -1 for triplets containing bases other than A, C, G, T/U.
+func dustTriplets(s []byte) []int {
+	if len(s) < 3 {
+		return nil
+	}
+	codes := make([]int, len(s)-2)
+	var c, b int
+	valid := 0 // number of preceding valid bases
+	for i, base := range s {
+		switch base {
+		case 'A', 'a':
+			b = 0
+		case 'C', 'c':
+			b = 1
+		case 'G', 'g':
+			b = 2
+		case 'T', 't', 'U', 'u':
+			b = 3
+		default:
+			b = -1
+		}
+		if b < 0 {
+			valid = 0
+		} else {
+			c = (c<<2 | b) & 63
+			valid++
+		}
+		if i >= 2 {
+			if valid >= 3 {
+				codes[i-2] = c
+			} else {
+				codes[i-2] = -1
+			}
+		}
+	}
+	return codes
+}
+
+// dustIntervals returns low-complexity intervals, i.e., the subintervals of
+// the highest score of windows scoring above the level.
+func dustIntervals(s []byte, window, level int) [][2]int {
+	codes := dustTriplets(s)
+	if len(codes) < 2 {
+		return nil
+	}
+	w := window - 2 // triplets per window
+	if w > len(codes) {
+		w = len(codes)
+	}
+
+	var ivs [][2]int
+	var counts [64]int
+	var r int // sum of c_t * (c_t - 1) / 2
+	for i := 0; i < w; i++ {
+		if codes[i] >= 0 {
+			r += counts[codes[i]]
+			counts[codes[i]]++
+		}
+	}
+	for start := 0; ; start++ {
+		if r*10 > level*(w-1) {
+			if iv, ok := dustBestInterval(codes[start:start+w], level); ok {
+				iv[0] += start
+				iv[1] += start + 2
+				if n := len(ivs); n > 0 && iv[0] <= ivs[n-1][1] && iv[1] >= ivs[n-1][0] {
+					if iv[0] < ivs[n-1][0] {
+						ivs[n-1][0] = iv[0]
+					}
+					if iv[1] > ivs[n-1][1] {
+						ivs[n-1][1] = iv[1]
+					}
+				} else {
+					ivs = append(ivs, iv)
+				}
+			}
+		}
+		if start+w >= len(codes) {
+			break
+		}
+		if c := codes[start]; c >= 0 {
+			counts[c]--
+			r -= counts[c]
+		}
+		if c := codes[start+w]; c >= 0 {
+			r += counts[c]
+			counts[c]++
+		}
+	}
+	return ivs
+}
+
+// dustBestInterval returns the triplet interval of the highest score
+// above the level in a window, preferring longer ones for equal scores.
+func dustBestInterval(codes []int, level int) ([2]int, bool) {
+	var best [2]int
+	var bestScore float64
+	var found bool
+	var counts [64]int
+	var r, l int
+	var score float64
+	for a := range codes {
+		counts = [64]int{}
+		r = 0
+		for b := a; b < len(codes); b++ {
+			if codes[b] >= 0 {
+				r += counts[codes[b]]
+				counts[codes[b]]++
+			}
+			l = b - a + 1
+			if l < 2 || r*10 <= level*(l-1) {
+				continue
+			}
+			score = float64(r) / float64(l-1)
+			if !found || score > bestScore || (score == bestScore && l > best[1]-best[0]) {
+				best, bestScore, found = [2]int{a, b + 1}, score, true
+			}
+		}
+	}
+	return best, found
+}
+
+// lowEntropyIntervals returns merged windows with Shannon entropy of
+// A, C, G, T/U bases below the threshold. Windows without these bases
+// are skipped.
+func lowEntropyIntervals(s []byte, window int, minEntropy float64) [][2]int {
+	if len(s) == 0 {
+		return nil
+	}
+	if window > len(s) {
+		window = len(s)
+	}
+	idx := func(b byte) int {
+		switch b {
+		case 'A', 'a':
+			return 0
+		case 'C', 'c':
+			return 1
+		case 'G', 'g':
+			return 2
+		case 'T', 't', 'U', 'u':
+			return 3
+		}
+		return -1
+	}
+
+	var ivs [][2]int
+	var counts [4]int
+	var acgt, k int
+	var entropy, p float64
+	for i := 0; i < window; i++ {
+		if k = idx(s[i]); k >= 0 {
+			counts[k]++
+			acgt++
+		}
+	}
+	for start := 0; ; start++ {
+		if acgt > 0 {
+			entropy = 0
+			for _, m := range counts {
+				if m > 0 {
+					p = float64(m) / float64(acgt)
+					entropy -= p * math.Log2(p)
+				}
+			}
+			if entropy < minEntropy {
+				if n := len(ivs); n > 0 && start <= ivs[n-1][1] {
+					ivs[n-1][1] = start + window
+				} else {
+					ivs = append(ivs, [2]int{start, start + window})
+				}
+			}
+		}
+		if start+window >= len(s) {
+			break
+		}
+		if k = idx(s[start]); k >= 0 {
+			counts[k]--
+			acgt--
+		}
+		if k = idx(s[start+window]); k >= 0 {
+			counts[k]++
+			acgt++
+		}
+	}
+	return ivs
+}
@@ -21,10 +21,13 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -43,9 +46,17 @@ var mutateCmd = &cobra.Command{
 
 Attentions:
 
-  1. Mutiple point mutations (-p/--point) are allowed, but only single 
-     insertion (-i/--insertion) OR single deletion (-d/--deletion) is allowed.
-  2. Point mutation takes place before insertion/deletion.
+  1. Mutiple point mutations (-p/--point), insertions (-i/--insertion) and
+     deletions (-d/--deletion) are allowed, positions of all of them
+     refer to the original sequence.
+  2. Point mutation takes place before insertion/deletion. Insertions and
+     deletions overlapping previous ones are skipped with warnings.
+  3. Variants in a VCF-like file (-m/--mut-file) are applied to sequences
+     with the same IDs, along with insertions/deletions. Lines are either
+     four columns of seqID, POS (1-based), REF and ALT, or VCF records
+     (CHROM, POS, ID, REF, ALT, ...). REF must match the sequence.
+     Common leading bases of REF and ALT (e.g., the padding base of
+     VCF indels) are kept. Multiple ALT alleles are not supported.
 
 Notes:
 
@@ -84,19 +95,16 @@ Examples:
 			mPoints = append(mPoints, _mutatePoint{pos: pos, base: items[1][0]})
 		}
 
-		valDel := getFlagString(cmd, "deletion")
-		valIns := getFlagString(cmd, "insertion")
-
-		if valDel != "" && valIns != "" {
-			checkError(fmt.Errorf("flag -i/--insertion and -d/--deletion can't be used at the same time"))
-		}
-
-		var mDel *_mutateDel
-		if valDel != "" {
-			if !reMutationDel.MatchString(valDel) {
-				checkError(fmt.Errorf("invalid value of flag -id/--deletion : %s", valDel))
+		mDels := []_mutateDel{}
+		for _, val := range getFlagStringSlice(cmd, "deletion") {
+			var items []string
+			if reMutationDelRange.MatchString(val) {
+				items = strings.Split(val, "-")
+			} else if reMutationDel.MatchString(val) {
+				items = strings.Split(val, ":")
+			} else {
+				checkError(fmt.Errorf("invalid value of flag -d/--deletion : %s", val))
 			}
-			items := strings.Split(valDel, ":")
 			start, _ := strconv.Atoi(items[0])
 			end, _ := strconv.Atoi(items[1])
 
@@ -107,18 +115,24 @@ Examples:
 				checkError(fmt.Errorf("when start < 0, end should not > 0"))
 			}
 
-			mDel = &_mutateDel{start: start, end: end}
+			mDels = append(mDels, _mutateDel{start: start, end: end})
 		}
 
-		var mIns *_mutateIns
-		if valIns != "" {
-			if !reMutationIns.MatchString(valIns) {
-				checkError(fmt.Errorf("invalid value of flag -i/--insertion : %s", valIns))
+		mInss := []_mutateIns{}
+		for _, val := range getFlagStringSlice(cmd, "insertion") {
+			if !reMutationIns.MatchString(val) {
+				checkError(fmt.Errorf("invalid value of flag -i/--insertion : %s", val))
 			}
-			items := strings.Split(valIns, ":")
-			pos, _ := strconv.Atoi(items[0])
+			i := strings.Index(val, ":")
+			pos, _ := strconv.Atoi(val[:i])
+
+			mInss = append(mInss, _mutateIns{pos: pos, seq: []byte(val[i+1:])})
+		}
 
-			mIns = &_mutateIns{pos: pos, seq: []byte(items[1])}
+		var mVars map[string][]_mutateVar
+		if mutFile := getFlagString(cmd, "mut-file"); mutFile != "" {
+			mVars, err = readMutateVars(mutFile)
+			checkError(err)
 		}
 
 		// flags for choose which sequences to mutate/edit
@@ -198,6 +212,7 @@ Examples:
 		var hit bool
 		var k string
 		var re *regexp.Regexp
+		var edits []seqEdit
 		for _, file := range files {
 			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
 			checkError(err)
@@ -279,35 +294,42 @@ Examples:
 					record.Seq.Seq[s-1] = mp.base
 				}
 
-				if mDel != nil {
+				edits = edits[:0]
+				for _, mDel := range mDels {
 					s, e, ok = seq.SubLocation(seqLen, mDel.start, mDel.end)
-
 					if !ok {
 						log.Warningf("[%s]: deletion mutation: range (%d-%d) out of sequence length (%d)", record.ID, mDel.start, mDel.end, seqLen)
-					} else {
-						copy(record.Seq.Seq[s-1:seqLen-(e-s+1)], record.Seq.Seq[e:])
-						record.Seq.Seq = record.Seq.Seq[0 : seqLen-(e-s+1)]
+						continue
 					}
+					edits = append(edits, seqEdit{start: s - 1, end: e,
+						desc: fmt.Sprintf("deletion %d:%d", mDel.start, mDel.end)})
 				}
 
-				if mIns != nil {
+				for _, mIns := range mInss {
 					if mIns.pos == 0 {
 						s = 0
-						record.Seq.Seq = append(record.Seq.Seq, mIns.seq...)
-						copy(record.Seq.Seq[s+len(mIns.seq):], record.Seq.Seq[s:seqLen])
-						copy(record.Seq.Seq[s:s+len(mIns.seq)], mIns.seq)
 					} else {
 						s, _, ok = seq.SubLocation(seqLen, mIns.pos, mIns.pos)
 						if !ok {
 							log.Warningf("[%s]: insertion mutation: position (%d) out of sequence length (%d)", record.ID, mIns.pos, seqLen)
-						} else {
-							record.Seq.Seq = append(record.Seq.Seq, mIns.seq...)             // append insert sequence to the end
-							copy(record.Seq.Seq[s+len(mIns.seq):], record.Seq.Seq[s:seqLen]) // move sequence behind the insert location at the end, so leave space for IS.
-							copy(record.Seq.Seq[s:s+len(mIns.seq)], mIns.seq)                // copy IS into desired region.
+							continue
 						}
 					}
+					edits = append(edits, seqEdit{start: s, end: s, alt: mIns.seq,
+						desc: fmt.Sprintf("insertion %d:%s", mIns.pos, mIns.seq)})
+				}
 
+				for _, mVar := range mVars[string(record.ID)] {
+					if !mVar.check(record.ID, record.Seq.Seq) {
+						continue
+					}
+					edits = append(edits, mVar.edit())
 				}
+
+				if len(edits) > 0 {
+					record.Seq.Seq, _ = applySeqEdits(record.ID, record.Seq.Seq, edits)
+				}
+
 				record.FormatToWriter(outfh, lineWidth)
 			}
 		}
@@ -318,8 +340,9 @@ func init() {
 	RootCmd.AddCommand(mutateCmd)
 
 	mutateCmd.Flags().StringSliceP("point", "p", []string{}, `point mutation: changing base at given position. e.g., -p 2:C for setting 2nd base as C, -p -1:A for change last base as A`)
-	mutateCmd.Flags().StringP("deletion", "d", "", `deletion mutation: deleting subsequence in a range. e.g., -d 1:2 for deleting leading two bases, -d -3:-1 for removing last 3 bases`)
-	mutateCmd.Flags().StringP("insertion", "i", "", `insertion mutation: inserting bases behind of given position, e.g., -i 0:ACGT for inserting ACGT at the beginning, -1:* for add * to the end`)
+	mutateCmd.Flags().StringSliceP("deletion", "d", []string{}, `deletion mutation: deleting subsequence in a range. e.g., -d 1:2 or -d 1-2 for deleting leading two bases, -d -3:-1 for removing last 3 bases (multiple values supported)`)
+	mutateCmd.Flags().StringSliceP("insertion", "i", []string{}, `insertion mutation: inserting bases behind of given position, e.g., -i 0:ACGT for inserting ACGT at the beginning, -1:* for add * to the end (multiple values supported)`)
+	mutateCmd.Flags().StringP("mut-file", "m", "", `VCF-like file of variants to apply, with columns of seqID, POS, REF and ALT, or the first five columns of VCF`)
 
 	mutateCmd.Flags().StringSliceP("pattern", "s", []string{""}, `[match seqs to mutate] search pattern (multiple values supported. Attention: use double quotation marks for patterns containing comma, e.g., -p '"A{2,}"'))`)
 	mutateCmd.Flags().StringP("pattern-file", "f", "", "[match seqs to mutate] pattern file (one record per line)")
@@ -332,6 +355,7 @@ func init() {
 
 var reMutationPoint = regexp.MustCompile(`^(\-?\d+)\:(.)$`)
 var reMutationDel = regexp.MustCompile(`^(\-?\d+):(\-?\d+)$`)
+var reMutationDelRange = regexp.MustCompile(`^(\d+)\-(\d+)$`)
 var reMutationIns = regexp.MustCompile(`^(\-?\d+)\:(.+)$`)
 
 type _mutatePoint struct {
@@ -347,3 +371,130 @@ type _mutateIns struct {
 	pos int
 	seq []byte
 }
+
+type _mutateVar struct {
+	pos      int
+	ref, alt []byte
+}
+
+// check returns whether the variant locates in the sequence and its REF
+// matches the sequence, with warnings for failed ones.
+func (v _mutateVar) check(id []byte, s []byte) bool {
+	if v.pos-1+len(v.ref) > len(s) {
+		log.Warningf("[%s]: variant: position (%d) out of sequence length (%d)", id, v.pos, len(s))
+		return false
+	}
+	if !bytes.EqualFold(s[v.pos-1:v.pos-1+len(v.ref)], v.ref) {
+		log.Warningf("[%s]: variant: REF (%s) unmatched with sequence at position %d, skipped", id, v.ref, v.pos)
+		return false
+	}
+	return true
+}
+
+// edit converts a variant to an edit, trimming the common leading bases
+// of REF and ALT, e.g., the padding base of VCF indels.
+func (v _mutateVar) edit() seqEdit {
+	start := v.pos - 1
+	ref, alt := v.ref, v.alt
+	for len(ref) > 0 && len(alt) > 0 && (ref[0]|32) == (alt[0]|32) {
+		start++
+		ref, alt = ref[1:], alt[1:]
+	}
+	return seqEdit{start: start, end: start + len(ref), alt: alt,
+		desc: fmt.Sprintf("variant %d:%s>%s", v.pos, v.ref, v.alt)}
+}
+
+// readMutateVars reads variants of a VCF-like file, grouped by sequence IDs.
+// Lines with 4 columns are seqID, POS, REF and ALT, lines with more
+// columns are treated as VCF records (CHROM, POS, ID, REF, ALT, ...).
+func readMutateVars(file string) (map[string][]_mutateVar, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	vars := make(map[string][]_mutateVar)
+	var items []string
+	var ref, alt string
+	var pos int
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 0, 1<<20), 1<<30)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		items = strings.Split(line, "\t")
+		switch {
+		case len(items) == 4:
+			ref, alt = items[2], items[3]
+		case len(items) > 4:
+			ref, alt = items[3], items[4]
+		default:
+			return nil, fmt.Errorf("invalid variant line, at least 4 columns expected: %s", line)
+		}
+		pos, err = strconv.Atoi(items[1])
+		if err != nil || pos < 1 {
+			return nil, fmt.Errorf("%s: bad position: %s", items[0], items[1])
+		}
+		if alt == "." {
+			continue
+		}
+		if ref == "" || alt == "" || strings.ContainsAny(alt, ",<>*[]") {
+			return nil, fmt.Errorf("%s: unsupported REF/ALT: %s/%s", items[0], ref, alt)
+		}
+		vars[items[0]] = append(vars[items[0]], _mutateVar{pos: pos, ref: []byte(ref), alt: []byte(alt)})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// seqEdit replaces the 0-based half-open range [start, end) of a sequence
+// with alt, i.e., a deletion has an empty alt and an insertion has start
+// equal to end.
+type seqEdit struct {
+	start, end int
+	alt        []byte
+	desc       string
+}
+
+// applySeqEdits applies edits with coordinates of the original sequence
+// and returns the new sequence and the applied edits. Edits overlapping
+// previous ones (sorted by start and end) are skipped with warnings.
+func applySeqEdits(id []byte, s []byte, edits []seqEdit) ([]byte, []seqEdit) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start == edits[j].start {
+			return edits[i].end < edits[j].end
+		}
+		return edits[i].start < edits[j].start
+	})
+
+	n := len(s)
+	for _, e := range edits {
+		n += len(e.alt) - (e.end - e.start)
+	}
+	if n < 0 {
+		n = 0
+	}
+	t := make([]byte, 0, n)
+
+	applied := make([]seqEdit, 0, len(edits))
+	var prev int // end of the last applied edit
+	var last *seqEdit
+	for i := range edits {
+		e := &edits[i]
+		if last != nil && (e.start < prev || (e.start == prev && e.start == e.end && last.start == last.end)) {
+			log.Warningf("[%s]: %s overlaps %s, skipped", id, e.desc, last.desc)
+			continue
+		}
+		t = append(t, s[prev:e.start]...)
+		t = append(t, e.alt...)
+		prev, last = e.end, e
+		applied = append(applied, *e)
+	}
+	t = append(t, s[prev:]...)
+	return t, applied
+}
@@ -0,0 +1,130 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+)
+
+// orfHit is an open reading frame found by findORFs.
+type orfHit struct {
+	Frame   int    // 1, 2, 3, -1, -2, -3
+	Start   int    // 1-based position on the positive strand
+	End     int    // end included, the stop codon is included
+	Strand  string // "+" or "-"
+	Seq     []byte // nucleotides on the ORF strand, including the stop codon
+	Protein []byte // translation without the stop codon
+}
+
+// parseStartCodons parses comma-separated start codons, e.g., ATG,GTG,TTG.
+func parseStartCodons(s string) (map[string]struct{}, error) {
+	codons := make(map[string]struct{})
+	for _, c := range strings.Split(s, ",") {
+		c = strings.Replace(strings.ToUpper(strings.TrimSpace(c)), "U", "T", -1)
+		if len(c) != 3 || strings.Trim(c, "ACGT") != "" {
+			return nil, fmt.Errorf("invalid start codon: %s", c)
+		}
+		codons[c] = struct{}{}
+	}
+	return codons, nil
+}
+
+// isStartCodon checks a codon case-insensitively, U is treated as T.
+func isStartCodon(codon []byte, starts map[string]struct{}) bool {
+	var buf [3]byte
+	for i, b := range codon {
+		if b >= 'a' && b <= 'z' {
+			b -= 32
+		}
+		if b == 'U' {
+			b = 'T'
+		}
+		buf[i] = b
+	}
+	_, ok := starts[string(buf[:])]
+	return ok
+}
+
+// findORFs finds ORFs starting with start codons and ending with stop codons
+// of the translate table in the given frames, ORFs shorter than minLen amino
+// acids are ignored. By default, only the longest ORF, i.e., from the first
+// start codon after the previous stop codon, is reported for every stop codon.
+// With nested, ORFs from all in-frame start codons are reported.
+func findORFs(s *seq.Seq, translTable int, frames []int, starts map[string]struct{}, minLen int, nested bool) ([]orfHit, error) {
+	var hits []orfHit
+	L := len(s.Seq)
+	var strand *seq.Seq
+	var revcom *seq.Seq
+	for _, frame := range frames {
+		offset := frame - 1
+		if frame < 0 {
+			if revcom == nil {
+				revcom = s.RevCom()
+			}
+			strand = revcom
+			offset = -frame - 1
+		} else {
+			strand = s
+		}
+		if L-offset < 3 {
+			continue
+		}
+
+		protein, err := strand.SubSeq(offset+1, L).Translate(translTable, 1, false, false, true, false)
+		if err != nil {
+			return nil, err
+		}
+
+		var begins []int // codon indexes of start codons after the last stop codon
+		var b, p, e int
+		for k, aa := range protein.Seq {
+			p = offset + k*3
+			if aa != '*' {
+				if (nested || len(begins) == 0) && isStartCodon(strand.Seq[p:p+3], starts) {
+					begins = append(begins, k)
+				}
+				continue
+			}
+			for _, b = range begins {
+				if k-b < minLen {
+					break // following starts are even shorter
+				}
+				hit := orfHit{
+					Frame:   frame,
+					Seq:     strand.Seq[offset+b*3 : p+3],
+					Protein: protein.Seq[b:k],
+				}
+				if frame > 0 {
+					hit.Start, hit.End, hit.Strand = offset+b*3+1, p+3, "+"
+				} else {
+					e = offset + b*3 + 1
+					hit.Start, hit.End, hit.Strand = L-(p+3)+1, L-e+1, "-"
+				}
+				hits = append(hits, hit)
+			}
+			begins = begins[:0]
+		}
+	}
+	return hits, nil
+}
@@ -0,0 +1,171 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/util/byteutil"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// orfscanCmd represents the orfscan command
+var orfscanCmd = &cobra.Command{
+	Use:   "orfscan",
+	Short: "find ORFs and output in GFF3/BED format, with nucleotide/protein sequences",
+	Long: `find ORFs and output in GFF3/BED format, with nucleotide/protein sequences
+
+ORFs start with any of the start codons (-s/--start-codons) and end with
+stop codons of the translate table (-T/--transl-table), ORFs without stop
+codons at sequence ends are not reported. Lengths of ORFs are in amino
+acids, with stop codons not included.
+
+By default, only the longest ORF of every stop codon is reported, i.e.,
+from the first start codon after the previous in-frame stop codon. Switch
+on -n/--nested to report nested ORFs from all in-frame start codons.
+
+ORFs are named as $seqID_orf$N and written in GFF3 (default) or BED6 format
+(--bed), where the scores in BED are the ORF lengths. Nucleotide sequences
+(including stop codons) and protein sequences (not including stop codons)
+can be written with --nt-out and --aa-out.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		translTable := getFlagPositiveInt(cmd, "transl-table")
+		if _, ok := seq.CodonTables[translTable]; !ok {
+			checkError(fmt.Errorf("invalid translate table: %d", translTable))
+		}
+		frames := parseFrames(getFlagStringSlice(cmd, "frame"))
+		starts, err := parseStartCodons(getFlagString(cmd, "start-codons"))
+		checkError(err)
+		minLen := getFlagNonNegativeInt(cmd, "min-len")
+		nested := getFlagBool(cmd, "nested")
+		outFmtBED := getFlagBool(cmd, "bed")
+		ntOut := getFlagString(cmd, "nt-out")
+		aaOut := getFlagString(cmd, "aa-out")
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var ntfh, aafh *xopen.Writer
+		if ntOut != "" {
+			ntfh, err = xopen.Wopen(ntOut)
+			checkError(err)
+			defer ntfh.Close()
+		}
+		if aaOut != "" {
+			aafh, err = xopen.Wopen(aaOut)
+			checkError(err)
+			defer aafh.Close()
+		}
+
+		if !outFmtBED {
+			outfh.WriteString("##gff-version 3\n")
+		}
+
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		var orfs []orfHit
+		var name, desc string
+		once := true
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+
+				if once {
+					if !(record.Seq.Alphabet == seq.DNA || record.Seq.Alphabet == seq.DNAredundant ||
+						record.Seq.Alphabet == seq.RNA || record.Seq.Alphabet == seq.RNAredundant) {
+						checkError(fmt.Errorf(`command 'seqkit orfscan' only apply to DNA/RNA sequences`))
+					}
+					once = false
+				}
+
+				orfs, err = findORFs(record.Seq, translTable, frames, starts, minLen, nested)
+				checkError(err)
+
+				for i, orf := range orfs {
+					name = fmt.Sprintf("%s_orf%d", record.ID, i+1)
+					if outFmtBED {
+						outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%s\n",
+							record.ID, orf.Start-1, orf.End, name, len(orf.Protein), orf.Strand))
+					} else {
+						outfh.WriteString(fmt.Sprintf("%s\tSeqKit\tORF\t%d\t%d\t.\t%s\t0\tID=%s;frame=%d;length=%d\n",
+							record.ID, orf.Start, orf.End, orf.Strand, gff3Escape(name), orf.Frame, len(orf.Protein)))
+					}
+
+					if ntfh == nil && aafh == nil {
+						continue
+					}
+					desc = fmt.Sprintf("%s:%d-%d:%s frame=%d length=%d", record.ID, orf.Start, orf.End, orf.Strand, orf.Frame, len(orf.Protein))
+					if ntfh != nil {
+						ntfh.WriteString(fmt.Sprintf(">%s %s\n", name, desc))
+						ntfh.Write(byteutil.WrapByteSlice(orf.Seq, lineWidth))
+						ntfh.WriteString("\n")
+					}
+					if aafh != nil {
+						aafh.WriteString(fmt.Sprintf(">%s %s\n", name, desc))
+						aafh.Write(byteutil.WrapByteSlice(orf.Protein, lineWidth))
+						aafh.WriteString("\n")
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(orfscanCmd)
+
+	orfscanCmd.Flags().IntP("transl-table", "T", 1, `translate table/genetic code, type 'seqkit translate --help' for more details`)
+	orfscanCmd.Flags().StringSliceP("frame", "f", []string{"6"}, "frame(s) to scan, available value: 1, 2, 3, -1, -2, -3, and 6 for all six frames")
+	orfscanCmd.Flags().StringP("start-codons", "s", "ATG", "comma-separated start codons, e.g., ATG,GTG,TTG")
+	orfscanCmd.Flags().IntP("min-len", "m", 30, "minimum length of ORFs (amino acids, stop codon not included)")
+	orfscanCmd.Flags().BoolP("nested", "n", false, "report nested ORFs from all in-frame start codons, not only the longest ones")
+	orfscanCmd.Flags().BoolP("bed", "", false, "output in BED6 format instead of GFF3")
+	orfscanCmd.Flags().StringP("nt-out", "", "", "also write nucleotide sequences of ORFs to this file")
+	orfscanCmd.Flags().StringP("aa-out", "", "", "also write protein sequences of ORFs to this file")
+}
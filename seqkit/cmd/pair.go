@@ -54,6 +54,9 @@ Attensions:
 4. Paired gzipped files may be slightly larger than original files, because
    of using different gzip package/library, don't worry.
 
+Use the subcommands "interleave" and "deinterleave" to convert between
+two files and interleaved paired-end reads.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
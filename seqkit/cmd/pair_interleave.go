@@ -0,0 +1,461 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/cespare/xxhash"
+	"github.com/pkg/errors"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// pairInterleaveCmd represents the pair interleave command
+var pairInterleaveCmd = &cobra.Command{
+	Use:   "interleave",
+	Short: "interleave paired-end reads from two files",
+	Long: `interleave paired-end reads from two files
+
+Mates are matched by their IDs with the suffixes "/1" and "/2" removed.
+Reads are streamed and the two files are expected in the same order,
+the command fails at the first pair with different names, unless
+-r/--repair is given, which buffers unmatched reads to temporary files
+on disk (--tmp-dir) and pairs them after reading the inputs.
+
+Reads without mates (orphans) are reported and could be saved to a file
+with --orphans.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		if len(args) > 0 {
+			checkError(errors.New("no positional arugments are allowed"))
+		}
+		read1 := getFlagString(cmd, "read1")
+		read2 := getFlagString(cmd, "read2")
+		if read1 == "" || read2 == "" {
+			checkError(fmt.Errorf("flag -1/--read1 and -2/--read2 needed"))
+		}
+		repair := getFlagBool(cmd, "repair")
+		orphansFile := getFlagString(cmd, "orphans")
+
+		reader1, err := fastx.NewReader(alphabet, read1, idRegexp)
+		checkError(errors.Wrap(err, read1))
+		reader2, err := fastx.NewReader(alphabet, read2, idRegexp)
+		checkError(errors.Wrap(err, read2))
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		orphans := newOrphanWriter(orphansFile)
+		defer orphans.close()
+
+		var buckets *mateBuckets
+		if repair {
+			buckets = newMateBuckets(getFlagString(cmd, "tmp-dir"), getFlagPositiveInt(cmd, "buckets"))
+			defer buckets.clean()
+		}
+
+		lineWidth := config.LineWidth
+		var record1, record2 *fastx.Record
+		var err1, err2 error
+		var eof1, eof2 bool
+		var n uint64
+		for {
+			if !eof1 {
+				record1, err1 = reader1.Read()
+				if err1 == io.EOF {
+					eof1 = true
+				} else {
+					checkError(errors.Wrap(err1, read1))
+				}
+			}
+			if !eof2 {
+				record2, err2 = reader2.Read()
+				if err2 == io.EOF {
+					eof2 = true
+				} else {
+					checkError(errors.Wrap(err2, read2))
+				}
+			}
+			if eof1 && eof2 {
+				break
+			}
+			if reader1.IsFastq || reader2.IsFastq {
+				lineWidth = 0
+			}
+
+			if !eof1 && !eof2 && pairName(record1) == pairName(record2) {
+				record1.FormatToWriter(outfh, lineWidth)
+				record2.FormatToWriter(outfh, lineWidth)
+				n++
+				continue
+			}
+
+			if buckets == nil {
+				if !eof1 && !eof2 {
+					checkError(fmt.Errorf("mate names differ: %s (%s) and %s (%s), use -r/--repair to pair reads in different orders",
+						record1.ID, read1, record2.ID, read2))
+				}
+				if !eof1 {
+					orphans.add(record1)
+				}
+				if !eof2 {
+					orphans.add(record2)
+				}
+				continue
+			}
+			if !eof1 {
+				buckets.add(0, record1)
+			}
+			if !eof2 {
+				buckets.add(1, record2)
+			}
+		}
+
+		if buckets != nil {
+			n += buckets.pair(alphabet, idRegexp, func(r1, r2 *fastx.Record) {
+				r1.FormatToWriter(outfh, lineWidth)
+				r2.FormatToWriter(outfh, lineWidth)
+			}, func(_ int, r *fastx.Record) { orphans.add(r) })
+		}
+
+		if !config.Quiet {
+			log.Infof("%d read pairs interleaved", n)
+			orphans.report()
+		}
+	},
+}
+
+// pairDeinterleaveCmd represents the pair deinterleave command
+var pairDeinterleaveCmd = &cobra.Command{
+	Use:   "deinterleave",
+	Short: "split interleaved paired-end reads into two files",
+	Long: `split interleaved paired-end reads into two files
+
+Mates are matched by their IDs with the suffixes "/1" and "/2" removed,
+and written to the files given by -1/--read1 and -2/--read2.
+The command fails at the first pair with different names, unless
+-r/--repair is given, which buffers unmatched reads to temporary files
+on disk (--tmp-dir) and pairs them after reading the input.
+
+Reads without mates (orphans) are reported and could be saved to a file
+with --orphans.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		read1 := getFlagString(cmd, "read1")
+		read2 := getFlagString(cmd, "read2")
+		if read1 == "" || read2 == "" {
+			checkError(fmt.Errorf("flag -1/--read1 and -2/--read2 needed"))
+		}
+		if read1 == read2 {
+			checkError(fmt.Errorf("values of flag -1/--read1 and -2/--read2 can not be the same"))
+		}
+		repair := getFlagBool(cmd, "repair")
+		orphansFile := getFlagString(cmd, "orphans")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh1, err := xopen.Wopen(read1)
+		checkError(err)
+		defer outfh1.Close()
+		outfh2, err := xopen.Wopen(read2)
+		checkError(err)
+		defer outfh2.Close()
+
+		orphans := newOrphanWriter(orphansFile)
+		defer orphans.close()
+
+		var buckets *mateBuckets
+		if repair {
+			buckets = newMateBuckets(getFlagString(cmd, "tmp-dir"), getFlagPositiveInt(cmd, "buckets"))
+			buckets.byOrder = true
+			defer buckets.clean()
+		}
+
+		lineWidth := config.LineWidth
+		var n uint64
+		var pending *fastx.Record
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					lineWidth = 0
+				}
+
+				if pending == nil {
+					pending = record.Clone()
+					continue
+				}
+				if pairName(pending) == pairName(record) {
+					pending.FormatToWriter(outfh1, lineWidth)
+					record.FormatToWriter(outfh2, lineWidth)
+					n++
+					pending = nil
+					continue
+				}
+
+				if buckets == nil {
+					checkError(fmt.Errorf("mate names differ: %s and %s, use -r/--repair to pair reads in different orders",
+						pending.ID, record.ID))
+				}
+				buckets.add(mateOfRecord(pending, 0), pending)
+				pending = record.Clone()
+			}
+		}
+		if pending != nil {
+			if buckets != nil {
+				buckets.add(mateOfRecord(pending, 0), pending)
+			} else {
+				orphans.add(pending)
+			}
+		}
+
+		if buckets != nil {
+			n += buckets.pair(alphabet, idRegexp, func(r1, r2 *fastx.Record) {
+				r1.FormatToWriter(outfh1, lineWidth)
+				r2.FormatToWriter(outfh2, lineWidth)
+			}, func(_ int, r *fastx.Record) { orphans.add(r) })
+		}
+
+		if !config.Quiet {
+			log.Infof("%d read pairs saved to %s and %s", n, read1, read2)
+			orphans.report()
+		}
+	},
+}
+
+// pairName returns the ID of a read with the mate suffix removed.
+func pairName(record *fastx.Record) string {
+	name, _ := splitMateSuffix(string(record.ID))
+	return name
+}
+
+// mateOfRecord returns the mate (0 for read1, 1 for read2) of a read by its
+// ID suffix, or the default one for IDs without suffixes.
+func mateOfRecord(record *fastx.Record, mate int) int {
+	switch _, suffix := splitMateSuffix(string(record.ID)); suffix {
+	case "/1":
+		return 0
+	case "/2":
+		return 1
+	}
+	return mate
+}
+
+// orphanWriter counts and optionally saves reads without mates.
+type orphanWriter struct {
+	file string
+	fh   *xopen.Writer
+	n    uint64
+}
+
+func newOrphanWriter(file string) *orphanWriter {
+	w := &orphanWriter{file: file}
+	if file != "" {
+		var err error
+		w.fh, err = xopen.Wopen(file)
+		checkError(err)
+	}
+	return w
+}
+
+func (w *orphanWriter) add(record *fastx.Record) {
+	w.n++
+	if w.fh != nil {
+		record.FormatToWriter(w.fh, 0)
+	}
+}
+
+func (w *orphanWriter) report() {
+	if w.n == 0 {
+		return
+	}
+	if w.fh != nil {
+		log.Warningf("%d reads without mates saved to %s", w.n, w.file)
+	} else {
+		log.Warningf("%d reads without mates discarded, use --orphans to save them", w.n)
+	}
+}
+
+func (w *orphanWriter) close() {
+	if w.fh != nil {
+		w.fh.Close()
+	}
+}
+
+// mateBuckets partitions reads into temporary files by the hashes of
+// their pair names, so mates in different orders could be paired bucket
+// by bucket with a limited memory.
+type mateBuckets struct {
+	dir   string
+	files [2][]string
+	fhs   [2][]*xopen.Writer
+
+	// pairing reads of the same name in read1 buckets in the order of
+	// appearance, for reads without mate suffixes in interleaved files.
+	byOrder bool
+}
+
+func newMateBuckets(tmpDir string, n int) *mateBuckets {
+	dir, err := ioutil.TempDir(tmpDir, "seqkit-pair")
+	checkError(err)
+	b := &mateBuckets{dir: dir}
+	for mate := 0; mate < 2; mate++ {
+		b.files[mate] = make([]string, n)
+		b.fhs[mate] = make([]*xopen.Writer, n)
+		for i := 0; i < n; i++ {
+			b.files[mate][i] = filepath.Join(dir, fmt.Sprintf("bucket_%d_%d.fq", i, mate+1))
+			b.fhs[mate][i], err = xopen.Wopen(b.files[mate][i])
+			checkError(err)
+		}
+	}
+	return b
+}
+
+// add writes a read of mate 0 (read1) or 1 (read2) to its bucket.
+func (b *mateBuckets) add(mate int, record *fastx.Record) {
+	i := xxhash.Sum64String(pairName(record)) % uint64(len(b.fhs[mate]))
+	record.FormatToWriter(b.fhs[mate][i], 0)
+}
+
+// pair pairs the reads in all buckets, calling onPair for every pair and
+// onOrphan for reads without mates, and returns the number of pairs.
+func (b *mateBuckets) pair(alphabet *seq.Alphabet, idRegexp string,
+	onPair func(r1, r2 *fastx.Record), onOrphan func(mate int, r *fastx.Record)) uint64 {
+	for mate := 0; mate < 2; mate++ {
+		for _, fh := range b.fhs[mate] {
+			checkError(fh.Close())
+		}
+	}
+
+	var n uint64
+	var name string
+	for i := range b.files[0] {
+		mates1 := make(map[string]*fastx.Record)
+		readBucket(alphabet, idRegexp, b.files[0][i], func(record *fastx.Record) {
+			name = pairName(record)
+			if r, ok := mates1[name]; ok { // duplicated
+				if b.byOrder {
+					onPair(r, record)
+					n++
+					delete(mates1, name)
+					return
+				}
+				onOrphan(0, r)
+			}
+			mates1[name] = record.Clone()
+		})
+		readBucket(alphabet, idRegexp, b.files[1][i], func(record *fastx.Record) {
+			name = pairName(record)
+			if r, ok := mates1[name]; ok {
+				onPair(r, record)
+				n++
+				delete(mates1, name)
+				return
+			}
+			onOrphan(1, record)
+		})
+		for _, r := range mates1 {
+			onOrphan(0, r)
+		}
+	}
+	return n
+}
+
+func readBucket(alphabet *seq.Alphabet, idRegexp string, file string, fn func(*fastx.Record)) {
+	info, err := os.Stat(file)
+	checkError(err)
+	if info.Size() == 0 {
+		return
+	}
+	reader, err := fastx.NewReader(alphabet, file, idRegexp)
+	checkError(err)
+	var record *fastx.Record
+	for {
+		record, err = reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			checkError(err)
+			break
+		}
+		fn(record)
+	}
+}
+
+func (b *mateBuckets) clean() {
+	if err := os.RemoveAll(b.dir); err != nil {
+		log.Warningf("fail to remove temporary directory: %s", b.dir)
+	}
+}
+
+func init() {
+	pairCmd.AddCommand(pairInterleaveCmd)
+	pairCmd.AddCommand(pairDeinterleaveCmd)
+
+	pairInterleaveCmd.Flags().StringP("read1", "1", "", "(gzipped) read1 file")
+	pairInterleaveCmd.Flags().StringP("read2", "2", "", "(gzipped) read2 file")
+	pairDeinterleaveCmd.Flags().StringP("read1", "1", "", "output read1 file")
+	pairDeinterleaveCmd.Flags().StringP("read2", "2", "", "output read2 file")
+
+	for _, c := range []*cobra.Command{pairInterleaveCmd, pairDeinterleaveCmd} {
+		c.Flags().BoolP("repair", "r", false, "pair reads in different orders by buffering unmatched reads on disk")
+		c.Flags().StringP("orphans", "", "", "save reads without mates to this file")
+		c.Flags().StringP("tmp-dir", "", os.TempDir(), "directory for temporary files of -r/--repair")
+		c.Flags().IntP("buckets", "", 16, "number of temporary files per mate of -r/--repair, more for less memory")
+	}
+}
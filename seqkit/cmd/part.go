@@ -0,0 +1,229 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/util/pathutil"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// partCmd represents the part command
+var partCmd = &cobra.Command{
+	Use:   "part",
+	Short: "partition reads into N files by a stable hash of their IDs (FASTA/Q, BAM)",
+	Long: `partition reads into N files by a stable hash of their IDs
+
+Every record is assigned to the part FNV-1a(ID) mod N, so the same read
+always lands in the same part, regardless of the order and the content of
+the input files. This allows reproducible sharded processing of FASTQ files
+and the matching BAM files (input files ending with ".bam", partitioned by
+the read names).
+
+Output files are named as $outdir/$name.part_NNN$ext, where the extension
+is kept from the input file.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		parts := getFlagPositiveInt(cmd, "parts")
+		outdir := getFlagString(cmd, "out-dir")
+		force := getFlagBool(cmd, "force")
+
+		if parts >= 1000 {
+			log.Warningf(`value of -n/--parts > 1000 may cause error of "too many open files"`)
+		}
+
+		prepared := make(map[string]bool)
+		for _, file := range files {
+			var fileName, fileExt string
+			dir := outdir
+			if isStdin(file) {
+				fileName, fileExt = "stdin", ".fastx"
+				if dir == "" {
+					dir = "stdin.part"
+				}
+			} else {
+				fileName, fileExt = filepathTrimExtension(file)
+				if dir == "" {
+					dir = file + ".part"
+				}
+			}
+			if !prepared[dir] {
+				prepareOutDir(dir, force)
+				prepared[dir] = true
+			}
+
+			outfiles := make([]string, parts)
+			for i := range outfiles {
+				outfiles[i] = filepath.Join(dir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i+1, fileExt))
+			}
+
+			var counts []int
+			if strings.HasSuffix(strings.ToLower(file), ".bam") {
+				counts = partBam(file, outfiles, config.Threads)
+			} else {
+				counts = partFastx(file, outfiles, alphabet, idRegexp, config.LineWidth)
+			}
+
+			if !quiet {
+				for i, outfile := range outfiles {
+					log.Infof("write %d records to file: %s", counts[i], outfile)
+				}
+			}
+		}
+	},
+}
+
+// partitionOf returns the part of a record ID.
+func partitionOf(id []byte, parts int) int {
+	h := fnv.New64a()
+	h.Write(id)
+	return int(h.Sum64() % uint64(parts))
+}
+
+// prepareOutDir creates the output directory, or empties it with force.
+func prepareOutDir(outdir string, force bool) {
+	pwd, _ := os.Getwd()
+	if outdir == "./" || outdir == "." || pwd == filepath.Clean(outdir) {
+		return
+	}
+	existed, err := pathutil.DirExists(outdir)
+	checkError(err)
+	if !existed {
+		checkError(os.MkdirAll(outdir, 0755))
+		return
+	}
+	empty, err := pathutil.IsEmpty(outdir)
+	checkError(err)
+	if !empty {
+		if force {
+			checkError(os.RemoveAll(outdir))
+			checkError(os.MkdirAll(outdir, 0755))
+		} else {
+			log.Warningf("outdir not empty: %s, you can use --force to overwrite", outdir)
+		}
+	}
+}
+
+func partFastx(file string, outfiles []string, alphabet *seq.Alphabet, idRegexp string, lineWidth int) []int {
+	counts := make([]int, len(outfiles))
+	outfhs := make([]*xopen.Writer, len(outfiles))
+	for i, outfile := range outfiles {
+		outfh, err := xopen.Wopen(outfile)
+		checkError(err)
+		outfhs[i] = outfh
+	}
+
+	fastxReader, err := NewFastxRecordReader(alphabet, file, idRegexp)
+	checkError(err)
+	var record *fastx.Record
+	for {
+		record, err = fastxReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			checkError(err)
+			break
+		}
+		if readerIsFastq(fastxReader) {
+			lineWidth = 0
+			fastx.ForcelyOutputFastq = true
+		}
+		i := partitionOf(record.ID, len(outfiles))
+		record.FormatToWriter(outfhs[i], lineWidth)
+		counts[i]++
+	}
+
+	for _, outfh := range outfhs {
+		checkError(outfh.Close())
+	}
+	return counts
+}
+
+func partBam(file string, outfiles []string, threads int) []int {
+	fh, err := os.Open(file)
+	checkError(err)
+	defer fh.Close()
+	reader, err := bam.NewReader(bufio.NewReader(fh), threads)
+	checkError(err)
+	defer reader.Close()
+	header := reader.Header()
+
+	counts := make([]int, len(outfiles))
+	outfhs := make([]*os.File, len(outfiles))
+	writers := make([]*bam.Writer, len(outfiles))
+	for i, outfile := range outfiles {
+		outfhs[i], err = os.Create(outfile)
+		checkError(err)
+		writers[i], err = bam.NewWriter(outfhs[i], header, threads)
+		checkError(err)
+	}
+
+	for {
+		r, err := reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			checkError(err)
+		}
+		i := partitionOf([]byte(r.Name), len(outfiles))
+		checkError(writers[i].Write(r))
+		counts[i]++
+	}
+
+	for i, w := range writers {
+		checkError(w.Close())
+		checkError(outfhs[i].Close())
+	}
+	return counts
+}
+
+func init() {
+	RootCmd.AddCommand(partCmd)
+
+	partCmd.Flags().IntP("parts", "n", 0, "number of parts")
+	partCmd.Flags().StringP("out-dir", "O", "", "output directory (default value is $infile.part)")
+	partCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+}
@@ -0,0 +1,259 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/bsipos/thist"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/spf13/cobra"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/vg"
+)
+
+// plotCmd represents the plot command
+var plotCmd = &cobra.Command{
+	Use:   "plot",
+	Short: "plot QC histograms and yield curves of FASTA/Q files",
+	Long: `plot QC histograms and yield curves of FASTA/Q files
+
+Available plots (-p/--plots):
+
+  length   histogram of read lengths
+  qual     histogram of average read qualities (FASTQ only), computed in
+           probability space
+  gc       histogram of GC contents
+  yield    cumulative yield curve, i.e., total bases in reads no shorter
+           than a given length
+
+Plots of all input files are saved to -O/--out-dir as $plot.$format,
+where the format (-F/--img-format) could be png, svg or pdf. Unicode
+plots are also drawn to stderr with -T/--terminal.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		plots := getFlagStringSlice(cmd, "plots")
+		outdir := getFlagString(cmd, "out-dir")
+		imgFormat := strings.ToLower(getFlagString(cmd, "img-format"))
+		terminal := getFlagBool(cmd, "terminal")
+		bins := getFlagInt(cmd, "bins")
+		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
+		force := getFlagBool(cmd, "force")
+
+		switch imgFormat {
+		case "png", "svg", "pdf":
+		default:
+			checkError(fmt.Errorf("invalid value of flag -F/--img-format: %s, available values: png, svg, pdf", imgFormat))
+		}
+		if len(plots) == 0 {
+			checkError(fmt.Errorf("flag -p/--plots needed"))
+		}
+		binMode := "termfit"
+		if bins > 0 {
+			binMode = "fixed"
+		}
+
+		hists := make(map[string]*thist.Hist, len(plots))
+		var doYield bool
+		for _, p := range plots {
+			switch p {
+			case "length":
+				hists[p] = thist.NewHist([]float64{}, "Read length", binMode, bins, false)
+			case "qual":
+				hists[p] = thist.NewHist([]float64{}, "Average read quality", binMode, bins, false)
+			case "gc":
+				hists[p] = thist.NewHist([]float64{}, "GC content (%)", binMode, bins, false)
+			case "yield":
+				doYield = true
+			default:
+				checkError(fmt.Errorf("invalid plot: %s, available values: length, qual, gc, yield", p))
+			}
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		prepareOutDir(outdir, force)
+
+		lenCounts := make(map[int]int, 1024)
+		var n int
+		var g, c float64
+		for _, file := range files {
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				n++
+
+				if h, ok := hists["length"]; ok {
+					h.Update(float64(len(record.Seq.Seq)))
+				}
+				if h, ok := hists["qual"]; ok && len(record.Seq.Qual) > 0 {
+					h.Update(avgQual(record.Seq, qBase))
+				}
+				if h, ok := hists["gc"]; ok && len(record.Seq.Seq) > 0 {
+					g = record.Seq.BaseContent("G")
+					c = record.Seq.BaseContent("C")
+					h.Update((g + c) * 100)
+				}
+				if doYield {
+					lenCounts[len(record.Seq.Seq)]++
+				}
+			}
+		}
+
+		for _, p := range plots {
+			outFile := filepath.Join(outdir, p+"."+imgFormat)
+			if p == "yield" {
+				lens, yields := cumulativeYield(lenCounts)
+				if len(lens) == 0 {
+					log.Warningf("no data for plot: %s", p)
+					continue
+				}
+				checkError(saveYieldPlot(lens, yields, outFile))
+				if terminal {
+					os.Stderr.WriteString(drawYieldBars(lens, yields, 20))
+				}
+			} else {
+				h := hists[p]
+				if h.DataCount == 0 {
+					log.Warningf("no data for plot: %s", p)
+					continue
+				}
+				h.SaveImage(outFile)
+				if terminal {
+					os.Stderr.WriteString(h.Draw())
+				}
+			}
+			if !quiet {
+				log.Infof("plot saved to %s", outFile)
+			}
+		}
+		if !quiet {
+			log.Infof("%d records processed", n)
+		}
+	},
+}
+
+// cumulativeYield returns read lengths in descending order and the total
+// bases of reads no shorter than them.
+func cumulativeYield(lenCounts map[int]int) ([]int, []int) {
+	lens := make([]int, 0, len(lenCounts))
+	for l := range lenCounts {
+		lens = append(lens, l)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(lens)))
+	yields := make([]int, len(lens))
+	var sum int
+	for i, l := range lens {
+		sum += l * lenCounts[l]
+		yields[i] = sum
+	}
+	return lens, yields
+}
+
+func saveYieldPlot(lens, yields []int, file string) error {
+	p, err := plot.New()
+	if err != nil {
+		return err
+	}
+	p.Title.Text = "Cumulative yield"
+	p.X.Label.Text = "Minimum read length"
+	p.Y.Label.Text = "Bases"
+
+	pts := make(plotter.XYs, len(lens))
+	for i := range lens {
+		pts[i].X = float64(lens[i])
+		pts[i].Y = float64(yields[i])
+	}
+	line, err := plotter.NewLine(pts)
+	if err != nil {
+		return err
+	}
+	p.Add(line)
+	return p.Save(6*vg.Inch, 4*vg.Inch, file)
+}
+
+// drawYieldBars draws the cumulative yield at evenly spaced read lengths
+// as unicode bars.
+func drawYieldBars(lens, yields []int, rows int) string {
+	var b strings.Builder
+	b.WriteString("Cumulative yield\n")
+	if len(lens) == 0 {
+		return b.String()
+	}
+	maxLen, total := lens[0], yields[len(yields)-1]
+	width := 50
+	step := float64(maxLen) / float64(rows)
+	if step < 1 {
+		step = 1
+	}
+	j := len(lens) - 1
+	for t := 0.0; t <= float64(maxLen); t += step {
+		// the yield of reads no shorter than t
+		for j > 0 && float64(lens[j]) < t {
+			j--
+		}
+		y := yields[j]
+		bar := 0
+		if total > 0 {
+			bar = y * width / total
+		}
+		b.WriteString(fmt.Sprintf(">= %-10d %s %d\n", int(t), strings.Repeat("█", bar), y))
+	}
+	return b.String()
+}
+
+func init() {
+	RootCmd.AddCommand(plotCmd)
+
+	plotCmd.Flags().StringSliceP("plots", "p", []string{"length", "qual", "gc", "yield"}, "plots to draw, available values: length, qual, gc, yield")
+	plotCmd.Flags().StringP("out-dir", "O", "plots", "output directory")
+	plotCmd.Flags().StringP("img-format", "F", "png", "image format: png, svg or pdf")
+	plotCmd.Flags().BoolP("terminal", "T", false, "also draw unicode plots to stderr")
+	plotCmd.Flags().IntP("bins", "B", -1, "number of histogram bins")
+	plotCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
+	plotCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+}
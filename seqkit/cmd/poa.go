@@ -0,0 +1,279 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"sort"
+)
+
+// poaGraph is a partial order alignment (POA) graph of sequences, built by
+// aligning sequences to the graph one by one. Nodes of different bases
+// aligned to each other are not merged, which is enough for calling
+// consensus sequences via the heaviest path.
+type poaGraph struct {
+	nodes []*poaNode
+	order []int // topological order of nodes
+
+	match, mismatch, gap int
+	band                 int // half-width of band, 0 for full alignment
+
+	seqs int // number of sequences added
+}
+
+type poaNode struct {
+	base  byte
+	in    []int       // predecessors
+	out   map[int]int // successor -> edge weight
+	reads int         // number of reads through this node
+	qual  int         // sum of qualities of these reads
+}
+
+func newPoaGraph(band int) *poaGraph {
+	return &poaGraph{match: 5, mismatch: -4, gap: -8, band: band}
+}
+
+func (g *poaGraph) addNode(base byte) int {
+	g.nodes = append(g.nodes, &poaNode{base: base, out: make(map[int]int)})
+	return len(g.nodes) - 1
+}
+
+func (g *poaGraph) addEdge(from, to int) {
+	if _, ok := g.nodes[from].out[to]; !ok {
+		g.nodes[to].in = append(g.nodes[to].in, from)
+	}
+	g.nodes[from].out[to]++
+}
+
+// sort computes the topological order of nodes.
+func (g *poaGraph) sort() {
+	indegree := make([]int, len(g.nodes))
+	for i, node := range g.nodes {
+		indegree[i] = len(node.in)
+	}
+	order := make([]int, 0, len(g.nodes))
+	for i := range g.nodes {
+		if indegree[i] == 0 {
+			order = append(order, i)
+		}
+	}
+	for k := 0; k < len(order); k++ {
+		for _, to := range g.nodes[order[k]].successors() {
+			indegree[to]--
+			if indegree[to] == 0 {
+				order = append(order, to)
+			}
+		}
+	}
+	g.order = order
+}
+
+// successors returns successors in ascending order, for deterministic results.
+func (node *poaNode) successors() []int {
+	s := make([]int, 0, len(node.out))
+	for to := range node.out {
+		s = append(s, to)
+	}
+	sort.Ints(s)
+	return s
+}
+
+// add aligns a sequence to the graph and adds it. qual could be nil.
+func (g *poaGraph) add(s []byte, qual []byte) {
+	if len(s) == 0 {
+		return
+	}
+	g.seqs++
+	var path []int // nodes of the sequence
+	if len(g.nodes) == 0 {
+		path = make([]int, len(s))
+		for j := range s {
+			path[j] = g.addNode(s[j])
+		}
+	} else {
+		path = g.align(s)
+		for j, v := range path {
+			if v < 0 || g.nodes[v].base != s[j] {
+				path[j] = g.addNode(s[j])
+			}
+		}
+	}
+	for j, v := range path {
+		g.nodes[v].reads++
+		if qual != nil {
+			g.nodes[v].qual += int(qual[j])
+		}
+		if j > 0 {
+			g.addEdge(path[j-1], v)
+		}
+	}
+	g.sort()
+}
+
+// align globally aligns a sequence to the graph, and returns the aligned
+// node of every base, -1 for insertions.
+func (g *poaGraph) align(s []byte) []int {
+	n, m := len(g.order), len(s)
+	rank := make([]int, len(g.nodes)) // row of a node
+	for i, v := range g.order {
+		rank[v] = i + 1
+	}
+
+	// band around the expected position of a node, by its longest distance
+	// from the start
+	dist := make([]int, n+1)
+	var maxDist int
+	for i, v := range g.order {
+		for _, p := range g.nodes[v].in {
+			if dist[rank[p]]+1 > dist[i+1] {
+				dist[i+1] = dist[rank[p]] + 1
+			}
+		}
+		if dist[i+1] == 0 {
+			dist[i+1] = 1
+		}
+		if dist[i+1] > maxDist {
+			maxDist = dist[i+1]
+		}
+	}
+	inBand := func(i, j int) bool {
+		if g.band <= 0 || i == 0 {
+			return true
+		}
+		e := dist[i] * m / maxDist
+		return j >= e-g.band && j <= e+g.band
+	}
+
+	const (
+		moveDiag = iota + 1
+		moveUp   // base of node deleted
+		moveLeft // base of sequence inserted
+	)
+	negInf := math.MinInt32 / 2
+	H := make([][]int, n+1)
+	move := make([][]byte, n+1)
+	from := make([][]int32, n+1) // row of the predecessor for diag and up
+	for i := range H {
+		H[i] = make([]int, m+1)
+		move[i] = make([]byte, m+1)
+		from[i] = make([]int32, m+1)
+	}
+	for j := 1; j <= m; j++ {
+		H[0][j] = j * g.gap
+		move[0][j] = moveLeft
+	}
+
+	var preds []int
+	for i := 1; i <= n; i++ {
+		node := g.nodes[g.order[i-1]]
+		preds = preds[:0]
+		for _, p := range node.in {
+			preds = append(preds, rank[p])
+		}
+		if len(preds) == 0 {
+			preds = append(preds, 0)
+		}
+		for j := 0; j <= m; j++ {
+			H[i][j] = negInf
+			if !inBand(i, j) {
+				continue
+			}
+			for _, p := range preds {
+				if H[p][j] > negInf && H[p][j]+g.gap > H[i][j] {
+					H[i][j], move[i][j], from[i][j] = H[p][j]+g.gap, moveUp, int32(p)
+				}
+				if j == 0 || H[p][j-1] == negInf {
+					continue
+				}
+				score := g.mismatch
+				if node.base == s[j-1] {
+					score = g.match
+				}
+				if H[p][j-1]+score > H[i][j] {
+					H[i][j], move[i][j], from[i][j] = H[p][j-1]+score, moveDiag, int32(p)
+				}
+			}
+			if j > 0 && H[i][j-1] > negInf && H[i][j-1]+g.gap > H[i][j] {
+				H[i][j], move[i][j] = H[i][j-1]+g.gap, moveLeft
+			}
+		}
+	}
+
+	// end at a sink node
+	best, bestScore := 0, negInf
+	for i := 1; i <= n; i++ {
+		if len(g.nodes[g.order[i-1]].out) == 0 && H[i][m] > bestScore {
+			best, bestScore = i, H[i][m]
+		}
+	}
+
+	path := make([]int, m)
+	i, j := best, m
+	for j > 0 {
+		switch move[i][j] {
+		case moveDiag:
+			path[j-1] = g.order[i-1]
+			i, j = int(from[i][j]), j-1
+		case moveUp:
+			i = int(from[i][j])
+		default:
+			path[j-1] = -1
+			j--
+		}
+	}
+	return path
+}
+
+// consensus returns the heaviest path of the graph, along with the number
+// of reads and the sum of qualities of every node. Edges supported by less
+// than half of the sequences lower the score, so that the path does not
+// extend to bases in the ends of only a few sequences.
+func (g *poaGraph) consensus() (s []byte, reads []int, quals []int) {
+	if len(g.nodes) == 0 {
+		return nil, nil, nil
+	}
+	half := g.seqs / 2
+	score := make([]int, len(g.nodes))
+	prev := make([]int, len(g.nodes))
+	end := -1
+	for _, v := range g.order {
+		prev[v] = -1
+		for _, p := range g.nodes[v].in {
+			if sc := score[p] + g.nodes[p].out[v] - half; sc > score[v] {
+				prev[v], score[v] = p, sc
+			}
+		}
+		if end < 0 || score[v] > score[end] {
+			end = v
+		}
+	}
+	for v := end; v >= 0; v = prev[v] {
+		s = append(s, g.nodes[v].base)
+		reads = append(reads, g.nodes[v].reads)
+		quals = append(quals, g.nodes[v].qual)
+	}
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+		reads[i], reads[j] = reads[j], reads[i]
+		quals[i], quals[j] = quals[j], quals[i]
+	}
+	return s, reads, quals
+}
@@ -0,0 +1,88 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+)
+
+// Quarantine collects the malformed records skipped by a parser, with the
+// file, the line number and the byte offset where they start, so that
+// unattended runs over messy data do not abort on the first bad record.
+// The records are written to a sidecar TSV file, or logged if no file is
+// given, and an error is returned once more than Max records are seen.
+type Quarantine struct {
+	File string
+	Max  int // -1 for no limit
+
+	fh *xopen.Writer
+	n  int
+}
+
+// quarantineEscaper keeps the content of a bad record on a single TSV field.
+var quarantineEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// NewQuarantine creates a Quarantine writing to file, or logging if file
+// is empty.
+func NewQuarantine(file string, max int) (*Quarantine, error) {
+	q := &Quarantine{File: file, Max: max}
+	if file == "" {
+		return q, nil
+	}
+	var err error
+	q.fh, err = xopen.Wopen(file)
+	if err != nil {
+		return nil, err
+	}
+	q.fh.WriteString("File\tLine\tOffset\tError\tContent\n")
+	return q, nil
+}
+
+// Add records a bad record starting at the 1-based line and the 0-based
+// byte offset of a file. It returns an error if the limit is exceeded.
+func (q *Quarantine) Add(file string, line int, offset int64, err error, content string) error {
+	q.n++
+	if q.fh != nil {
+		fmt.Fprintf(q.fh, "%s\t%d\t%d\t%s\t%s\n", file, line, offset, quarantineEscaper.Replace(err.Error()), quarantineEscaper.Replace(content))
+	} else {
+		log.Infof("File: %s\tLine: %d\tOffset: %d\t%s\t%s", file, line, offset, err, content)
+	}
+	if q.Max >= 0 && q.n > q.Max {
+		return fmt.Errorf("more than %d bad records, the last one at %s:%d (byte %d): %s", q.Max, file, line, offset, err)
+	}
+	return nil
+}
+
+// Count returns the number of bad records seen.
+func (q *Quarantine) Count() int {
+	return q.n
+}
+
+// Close closes the sidecar file.
+func (q *Quarantine) Close() error {
+	if q.fh == nil {
+		return nil
+	}
+	return q.fh.Close()
+}
@@ -0,0 +1,180 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bad.tsv")
+	q, err := NewQuarantine(file, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = q.Add("a.fq", 5, 12, errors.New("bad"), "AC\tGT"); err != nil {
+		t.Errorf("unexpected error below the limit: %s", err)
+	}
+	if err = q.Add("a.fq", 9, 40, errors.New("worse"), `\`); err == nil {
+		t.Error("expected an error above the limit")
+	}
+	if q.Count() != 2 {
+		t.Errorf("count: got %d, want 2", q.Count())
+	}
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "File\tLine\tOffset\tError\tContent\na.fq\t5\t12\tbad\tAC\\tGT\na.fq\t9\t40\tworse\t\\\\\n"
+	if string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
+// streamFastqBytes parses FASTQ data with the sana parser, returning the
+// good and bad records and the number of bytes consumed.
+func streamFastqBytes(t *testing.T, data []byte) ([]*simpleSeq, int64) {
+	out := make(chan *simpleSeq, 16)
+	ctrlIn, ctrlOut := make(chan SeqStreamCtrl, 1), make(chan SeqStreamCtrl, 1)
+	var recs []*simpleSeq
+	done := make(chan struct{})
+	go func() {
+		for r := range out {
+			recs = append(recs, r)
+		}
+		close(done)
+	}()
+	lineCounter := 0
+	var offset int64
+	sbuff, err := streamFastq("fuzz.fq", bufio.NewReader(bytes.NewReader(data)), nil, out, ctrlIn, ctrlOut, &lineCounter, &offset, 33, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range sbuff {
+		out <- &simpleSeq{Err: errors.New("Discarded line"), Seq: l.Line, Offset: l.Offset}
+	}
+	close(out)
+	<-done
+	return recs, offset
+}
+
+func TestStreamFastqOffsets(t *testing.T) {
+	data := "@r1\nACGT\n+\nIIII\n\n@r2\nAC!T\n+\nIIII\n@r3\r\nACGT\r\n+\r\nIIII\r\n@r4\nAC"
+	recs, n := streamFastqBytes(t, []byte(data))
+	if n != int64(len(data)) {
+		t.Errorf("consumed %d of %d bytes", n, len(data))
+	}
+	var got []string
+	for _, r := range recs {
+		if r.Err == nil {
+			got = append(got, fmt.Sprintf("@%s:%d", r.Id, r.Offset))
+		} else {
+			got = append(got, fmt.Sprintf("%s:%d", r.Seq, r.Offset))
+		}
+	}
+	want := "@r1:0,@r2:17,AC!T:21,+:26,IIII:28,@r3:33,@r4:53,AC:57"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %s, want %s", strings.Join(got, ","), want)
+	}
+}
+
+func FuzzStreamFastq(f *testing.F) {
+	f.Add([]byte("@r1\nACGT\n+\nIIII\n@r2\nAC!T\n+\nIIII\n@r3\nACGT\n+\nIIII\n"))
+	f.Add([]byte("@r1\r\nACGT\r\n+\r\nIIII\r\n\n\n@r2\nACG"))
+	f.Add([]byte("+\n@\n@r1\nACGT\n+\nII\n  @r2 \n\tACGT\n+\n@@@@"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		recs, n := streamFastqBytes(t, data)
+		if n != int64(len(data)) {
+			t.Fatalf("consumed %d of %d bytes", n, len(data))
+		}
+		for _, r := range recs {
+			if r.Offset < 0 || r.Offset >= int64(len(data)) {
+				t.Fatalf("offset out of range: %d", r.Offset)
+			}
+			content := r.Seq
+			if r.Err == nil {
+				if err := ValidateSeq(r, false); err != nil {
+					t.Fatalf("invalid record passed: %s", err)
+				}
+				content = "@" + r.Id
+			}
+			rest := data[r.Offset:]
+			if !bytes.HasPrefix(rest, []byte(content)) && !bytes.HasPrefix(bytes.TrimLeft(rest, "\r\t "), []byte(content)) {
+				t.Fatalf("record %q not found at offset %d: %q", content, r.Offset, rest)
+			}
+		}
+	})
+}
+
+func FuzzTab2fxRowReader(f *testing.F) {
+	f.Add([]byte("#id\tseq\nr1\tACGT\n\nr2\tAC\r\n// comment\nr3"), false)
+	f.Add([]byte("id,seq\n\"r1\",ACGT\nr\"2,AC\n\"r3\nx\",GG\n"), true)
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte, isCSV bool) {
+		file := filepath.Join(dir, "fuzz.tsv")
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := newTab2fxRowReader(file, isCSV, func(line string) bool { return strings.HasPrefix(line, "#") })
+		if err != nil {
+			return // e.g., empty files
+		}
+		defer rows.close()
+		var offset int64
+		for {
+			items, err := rows.next(true)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				if _, ok := err.(*csv.ParseError); ok {
+					continue
+				}
+				return
+			}
+			if rows.offset < offset || rows.offset >= int64(len(data)) {
+				t.Fatalf("offset out of order or range: %d after %d", rows.offset, offset)
+			}
+			offset = rows.offset
+			if isCSV {
+				continue
+			}
+			if line := strings.Join(items, "\t"); !bytes.HasPrefix(data[offset:], []byte(line)) {
+				t.Fatalf("row %q not found at offset %d", line, offset)
+			}
+			if line := bytes.Count(data[:offset], []byte("\n")) + 1; line != rows.line {
+				t.Fatalf("line number: got %d, want %d", rows.line, line)
+			}
+		}
+	})
+}
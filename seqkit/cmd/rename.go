@@ -23,13 +23,12 @@ package cmd
 import (
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fastx"
-	"github.com/shenwei356/util/pathutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
@@ -43,6 +42,25 @@ var renameCmd = &cobra.Command{
 Attention:
   1. This command only appends "_N" to duplicated sequence IDs to make them unique.
   2. Use "seqkit replace" for editing sequence IDs/headers using regular expression.
+  3. IDs listed in the file given by -r/--reserved-ids (e.g., the output of
+     "seqkit seq -n -i" of another dataset) are treated as already used.
+  4. Paired-end reads given by -1/--read1 and -2/--read2 are renamed
+     consistently: duplicates are detected by the read1 IDs and both mates
+     get the same new ID, keeping the mate suffixes "/1" and "/2". The mates
+     must be in the same order (see "seqkit pair"), the outputs are written
+     to -O/--out-dir with the names of the input files.
+  5. With -T/--template, all records are renamed by the template, where
+     {id} is replaced by the original ID and {nr} by the running number
+     starting from --start-num, zero-padded to --pad digits. Numbers giving
+     reserved IDs are skipped.
+  6. Pairs of old and new IDs of the renamed records can be saved by
+     --out-map, e.g., for reverting the renaming by "seqkit replace -k".
+  7. With --rename-first, the first occurrences of duplicated IDs are
+     renamed too ("_1"), so all duplicates are distinguishable. The input
+     files are read twice, so stdin is not supported.
+  8. Duplicates are detected across all input files. With --prefix-file,
+     the IDs are prefixed with the names of the source files (without
+     extensions, "stdin" for stdin), e.g., "sample1_read1".
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -54,12 +72,70 @@ Attention:
 		seq.ValidateSeq = false
 		runtime.GOMAXPROCS(config.Threads)
 
-		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
-
 		byName := getFlagBool(cmd, "by-name")
 		mOutputs := getFlagBool(cmd, "multiple-outfiles")
 		outdir := getFlagString(cmd, "out-dir")
 		force := getFlagBool(cmd, "force")
+		reservedFile := getFlagString(cmd, "reserved-ids")
+		read1 := getFlagString(cmd, "read1")
+		read2 := getFlagString(cmd, "read2")
+		template := getFlagString(cmd, "template")
+		startNum := getFlagInt(cmd, "start-num")
+		pad := getFlagNonNegativeInt(cmd, "pad")
+		mapFile := getFlagString(cmd, "out-map")
+		renameFirst := getFlagBool(cmd, "rename-first")
+		prefixFile := getFlagBool(cmd, "prefix-file")
+
+		if template != "" && !strings.Contains(template, "{nr}") {
+			checkError(fmt.Errorf(`value of flag -T/--template should contain "{nr}" to make the IDs unique`))
+		}
+
+		renamer := &idRenamer{
+			numbers:  make(map[string]int),
+			reserved: make(map[string]bool),
+			template: template,
+			nr:       startNum,
+			pad:      pad,
+		}
+		// IDs which must not be used for renamed records
+		if reservedFile != "" {
+			renamer.reserved = loadIdList(reservedFile)
+		}
+		if mapFile != "" {
+			mapfh, err := xopen.Wopen(mapFile)
+			checkError(err)
+			defer mapfh.Close()
+			renamer.mapfh = mapfh
+		}
+
+		if read1 != "" || read2 != "" {
+			if read1 == "" || read2 == "" {
+				checkError(fmt.Errorf("flag -1/--read1 and -2/--read2 needed"))
+			}
+			if read1 == read2 {
+				checkError(fmt.Errorf("values of flag -1/--read1 and -2/--read2 can not be the same"))
+			}
+			if len(args) > 0 {
+				checkError(fmt.Errorf("no positional arugments are allowed with flag -1/--read1 and -2/--read2"))
+			}
+			if outdir == "" {
+				checkError(fmt.Errorf("out dir (flag -O/--out-dir) should not be empty"))
+			}
+			if prefixFile {
+				checkError(fmt.Errorf("flag --prefix-file is not supported for paired-end reads"))
+			}
+			prepareOutDir(outdir, force)
+			if renameFirst {
+				renamer.dups = countDupKeys([]string{read1}, alphabet, idRegexp, byName, true, false)
+			}
+			renamePaired(read1, read2, outdir, alphabet, idRegexp, lineWidth, byName, renamer)
+			return
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+		if renameFirst {
+			renamer.dups = countDupKeys(files, alphabet, idRegexp, byName, false, prefixFile)
+		}
 
 		var outfh *xopen.Writer
 		var err error
@@ -78,25 +154,7 @@ Attention:
 				}
 			}
 
-			pwd, _ := os.Getwd()
-			if outdir != "./" && outdir != "." && pwd != filepath.Clean(outdir) {
-				existed, err := pathutil.DirExists(outdir)
-				checkError(err)
-				if existed {
-					empty, err := pathutil.IsEmpty(outdir)
-					checkError(err)
-					if !empty {
-						if force {
-							checkError(os.RemoveAll(outdir))
-							checkError(os.MkdirAll(outdir, 0755))
-						} else {
-							log.Warningf("outdir not empty: %s, you can use --force to overwrite", outdir)
-						}
-					}
-				} else {
-					checkError(os.MkdirAll(outdir, 0755))
-				}
-			}
+			prepareOutDir(outdir, force)
 		}
 
 		var record *fastx.Record
@@ -104,12 +162,14 @@ Attention:
 		var newID string
 		var k string
 		var ok bool
-		var numbers map[string]int
-		numbers = make(map[string]int)
+		var prefix, oldID string
 		for _, file := range files {
 			func(file string) {
 				fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
 				checkError(err)
+				if prefixFile {
+					prefix = renameFilePrefix(file)
+				}
 
 				if mOutputs {
 					outfh, err = xopen.Wopen(filepath.Join(outdir, filepath.Base(file)))
@@ -131,18 +191,23 @@ Attention:
 						fastx.ForcelyOutputFastq = true
 					}
 
+					oldID = string(record.ID)
+					if prefixFile {
+						record.ID = []byte(prefix + oldID)
+						record.Name = []byte(prefix + string(record.Name))
+					}
+
 					if byName {
 						k = string(record.Name)
 					} else {
 						k = string(record.ID)
 					}
 
-					if _, ok = numbers[k]; ok {
-						numbers[k]++
-						newID = fmt.Sprintf("%s_%d", record.ID, numbers[k])
+					if newID, ok = renamer.rename(k, string(record.ID)); ok {
+						renamer.saveMap(oldID, newID)
 						record.Name = []byte(fmt.Sprintf("%s %s", newID, record.Name))
-					} else {
-						numbers[k] = 1
+					} else if prefixFile {
+						renamer.saveMap(oldID, string(record.ID))
 					}
 
 					record.FormatToWriter(outfh, config.LineWidth)
@@ -153,6 +218,175 @@ Attention:
 	},
 }
 
+// idRenamer assigns new IDs to duplicated records, or to all records if a
+// template is given.
+type idRenamer struct {
+	numbers  map[string]int  // occurrences of the duplication keys
+	reserved map[string]bool // IDs not to be used, records having them are renamed too
+	dups     map[string]int  // total occurrences of the keys, for renaming the first ones
+	template string
+	nr       int // running number of the template
+	pad      int
+	mapfh    *xopen.Writer // old and new IDs
+}
+
+// rename returns a new ID for a record with the duplication key k, or false
+// if the record keeps its ID.
+func (r *idRenamer) rename(k string, id string) (string, bool) {
+	if r.template != "" {
+		var newID string
+		for {
+			newID = strings.Replace(r.template, "{id}", id, -1)
+			newID = strings.Replace(newID, "{nr}", fmt.Sprintf("%0*d", r.pad, r.nr), -1)
+			r.nr++
+			if !r.reserved[newID] {
+				break
+			}
+		}
+		return newID, true
+	}
+
+	if _, ok := r.numbers[k]; !ok {
+		switch {
+		case r.dups[k] > 1:
+			r.numbers[k] = 0
+		case r.reserved[id]:
+			r.numbers[k] = 1
+		default:
+			r.numbers[k] = 1
+			return "", false
+		}
+	}
+	var newID string
+	for {
+		r.numbers[k]++
+		newID = fmt.Sprintf("%s_%d", id, r.numbers[k])
+		if !r.reserved[newID] {
+			break
+		}
+	}
+	return newID, true
+}
+
+// countDupKeys counts the occurrences of the duplication keys in the files,
+// the mate suffixes are removed from the IDs if mate is true, and the file
+// prefixes are added if prefixFile is true.
+func countDupKeys(files []string, alphabet *seq.Alphabet, idRegexp string, byName bool, mate bool, prefixFile bool) map[string]int {
+	counts := make(map[string]int)
+	for _, file := range files {
+		if isStdin(file) {
+			checkError(fmt.Errorf("stdin not supported with flag --rename-first"))
+		}
+		fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+		checkError(err)
+		var prefix string
+		if prefixFile {
+			prefix = renameFilePrefix(file)
+		}
+		var record *fastx.Record
+		for {
+			record, err = fastxReader.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				checkError(err)
+				break
+			}
+			k := string(record.ID)
+			if byName {
+				k = string(record.Name)
+			} else if mate {
+				k, _ = splitMateSuffix(k)
+			}
+			counts[prefix+k]++
+		}
+	}
+	return counts
+}
+
+// renameFilePrefix returns the ID prefix of a file: its name without
+// extensions.
+func renameFilePrefix(file string) string {
+	if isStdin(file) {
+		return "stdin_"
+	}
+	name, _ := filepathTrimExtension(filepath.Base(file))
+	return name + "_"
+}
+
+// saveMap writes the old and new ID of a renamed record to the mapping file.
+func (r *idRenamer) saveMap(id, newID string) {
+	if r.mapfh != nil {
+		r.mapfh.WriteString(id + "\t" + newID + "\n")
+	}
+}
+
+// splitMateSuffix splits the mate suffix "/1" or "/2" from a read ID.
+func splitMateSuffix(id string) (string, string) {
+	if n := len(id); n > 2 && id[n-2] == '/' && (id[n-1] == '1' || id[n-1] == '2') {
+		return id[:n-2], id[n-2:]
+	}
+	return id, ""
+}
+
+// renamePaired renames duplicated paired-end reads, applying the same new ID
+// to both mates.
+func renamePaired(read1, read2, outdir string, alphabet *seq.Alphabet, idRegexp string, lineWidth int, byName bool, renamer *idRenamer) {
+	reader1, err := fastx.NewReader(alphabet, read1, idRegexp)
+	checkError(err)
+	reader2, err := fastx.NewReader(alphabet, read2, idRegexp)
+	checkError(err)
+	outfh1, err := xopen.Wopen(filepath.Join(outdir, filepath.Base(read1)))
+	checkError(err)
+	defer outfh1.Close()
+	outfh2, err := xopen.Wopen(filepath.Join(outdir, filepath.Base(read2)))
+	checkError(err)
+	defer outfh2.Close()
+
+	var record1, record2 *fastx.Record
+	var err1, err2 error
+	for {
+		record1, err1 = reader1.Read()
+		record2, err2 = reader2.Read()
+		if err1 == io.EOF && err2 == io.EOF {
+			break
+		}
+		if err1 == io.EOF || err2 == io.EOF {
+			checkError(fmt.Errorf("unequal numbers of reads in %s and %s", read1, read2))
+		}
+		checkError(err1)
+		checkError(err2)
+		if reader1.IsFastq {
+			lineWidth = 0
+			fastx.ForcelyOutputFastq = true
+		}
+
+		base1, suffix1 := splitMateSuffix(string(record1.ID))
+		base2, suffix2 := splitMateSuffix(string(record2.ID))
+		if base1 != base2 {
+			checkError(fmt.Errorf(`mate IDs do not match: %s and %s, please pair the reads with "seqkit pair"`, record1.ID, record2.ID))
+		}
+
+		k := base1
+		if byName {
+			k = string(record1.Name)
+		}
+		if renamer.reserved[string(record1.ID)] || renamer.reserved[string(record2.ID)] {
+			renamer.reserved[base1] = true
+		}
+		if newID, ok := renamer.rename(k, base1); ok {
+			renamer.saveMap(string(record1.ID), newID+suffix1)
+			renamer.saveMap(string(record2.ID), newID+suffix2)
+			record1.Name = []byte(fmt.Sprintf("%s%s %s", newID, suffix1, record1.Name))
+			record2.Name = []byte(fmt.Sprintf("%s%s %s", newID, suffix2, record2.Name))
+		}
+
+		record1.FormatToWriter(outfh1, lineWidth)
+		record2.FormatToWriter(outfh2, lineWidth)
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(renameCmd)
 
@@ -160,4 +394,13 @@ func init() {
 	renameCmd.Flags().BoolP("multiple-outfiles", "m", false, "write results into separated files for multiple input files")
 	renameCmd.Flags().StringP("out-dir", "O", "renamed", "output directory")
 	renameCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+	renameCmd.Flags().StringP("read1", "1", "", "(gzipped) read1 file, for renaming paired-end reads consistently")
+	renameCmd.Flags().StringP("read2", "2", "", "(gzipped) read2 file, for renaming paired-end reads consistently")
+	renameCmd.Flags().StringP("template", "T", "", `rename all records by a template, with {id} for the original ID and {nr} for the running number, e.g., "{id}_{nr}"`)
+	renameCmd.Flags().IntP("start-num", "s", 1, "starting number of {nr} in -T/--template")
+	renameCmd.Flags().IntP("pad", "", 0, "zero-pad {nr} in -T/--template to this number of digits")
+	renameCmd.Flags().StringP("out-map", "", "", "save old and new IDs of the renamed records to this tab-delimited file")
+	renameCmd.Flags().BoolP("prefix-file", "", false, "prefix the IDs with the names of the source files")
+	renameCmd.Flags().BoolP("rename-first", "", false, `also rename the first occurrences of duplicated IDs ("_1")`)
+	renameCmd.Flags().StringP("reserved-ids", "r", "", "file of IDs (one per line) which should not be used by renamed records, records with these IDs are also renamed")
 }
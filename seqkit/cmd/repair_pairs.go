@@ -0,0 +1,171 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// repairPairsCmd represents the repair-pairs command
+var repairPairsCmd = &cobra.Command{
+	Use:   "repair-pairs",
+	Short: "re-synchronize paired-end reads of two files with diverged orders",
+	Long: `re-synchronize paired-end reads of two files with diverged orders
+
+Paired-end files filtered independently may lose their synchronization.
+This command outputs matched pairs into -O/--out-dir with the original
+file names, and reads without mates into $base.singletons$ext.
+
+Mates are matched by their IDs with the suffixes "/1" and "/2" removed.
+Reads are compared in a streaming manner first, and those out of sync are
+partitioned into temporary files on disk (--tmp-dir) by the hashes of
+their names and paired bucket by bucket, so the memory usage is limited
+by the size of the largest bucket rather than the input. Use more
+--buckets for larger inputs.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		if len(args) > 0 {
+			checkError(errors.New("no positional arugments are allowed"))
+		}
+		read1 := getFlagString(cmd, "read1")
+		read2 := getFlagString(cmd, "read2")
+		if read1 == "" || read2 == "" {
+			checkError(fmt.Errorf("flag -1/--read1 and -2/--read2 needed"))
+		}
+		if read1 == read2 {
+			checkError(fmt.Errorf("values of flag -1/--read1 and -2/--read2 can not be the same"))
+		}
+		outdir := getFlagString(cmd, "out-dir")
+		force := getFlagBool(cmd, "force")
+
+		prepareOutDir(outdir, force)
+
+		reader1, err := fastx.NewReader(alphabet, read1, idRegexp)
+		checkError(errors.Wrap(err, read1))
+		reader2, err := fastx.NewReader(alphabet, read2, idRegexp)
+		checkError(errors.Wrap(err, read2))
+
+		var outfhs, singletonFhs [2]*xopen.Writer
+		var singletonFiles [2]string
+		for i, file := range []string{read1, read2} {
+			outfhs[i], err = xopen.Wopen(filepath.Join(outdir, filepath.Base(file)))
+			checkError(err)
+			defer outfhs[i].Close()
+
+			base, suffix := filepathTrimExtension(filepath.Base(file))
+			singletonFiles[i] = filepath.Join(outdir, base+".singletons"+suffix)
+			singletonFhs[i], err = xopen.Wopen(singletonFiles[i])
+			checkError(err)
+			defer singletonFhs[i].Close()
+		}
+
+		buckets := newMateBuckets(getFlagString(cmd, "tmp-dir"), getFlagPositiveInt(cmd, "buckets"))
+		defer buckets.clean()
+
+		lineWidth := config.LineWidth
+		var record1, record2 *fastx.Record
+		var err1, err2 error
+		var eof1, eof2 bool
+		var n, nSynced uint64
+		for {
+			if !eof1 {
+				record1, err1 = reader1.Read()
+				if err1 == io.EOF {
+					eof1 = true
+				} else {
+					checkError(errors.Wrap(err1, read1))
+				}
+			}
+			if !eof2 {
+				record2, err2 = reader2.Read()
+				if err2 == io.EOF {
+					eof2 = true
+				} else {
+					checkError(errors.Wrap(err2, read2))
+				}
+			}
+			if eof1 && eof2 {
+				break
+			}
+			if reader1.IsFastq || reader2.IsFastq {
+				lineWidth = 0
+			}
+
+			if !eof1 && !eof2 && pairName(record1) == pairName(record2) {
+				record1.FormatToWriter(outfhs[0], lineWidth)
+				record2.FormatToWriter(outfhs[1], lineWidth)
+				nSynced++
+				continue
+			}
+			if !eof1 {
+				buckets.add(0, record1)
+			}
+			if !eof2 {
+				buckets.add(1, record2)
+			}
+		}
+
+		var nSingletons [2]uint64
+		n = nSynced + buckets.pair(alphabet, idRegexp, func(r1, r2 *fastx.Record) {
+			r1.FormatToWriter(outfhs[0], lineWidth)
+			r2.FormatToWriter(outfhs[1], lineWidth)
+		}, func(mate int, r *fastx.Record) {
+			r.FormatToWriter(singletonFhs[mate], lineWidth)
+			nSingletons[mate]++
+		})
+
+		if !config.Quiet {
+			log.Infof("%d read pairs saved to %s (%d in sync, %d repaired)", n, outdir, nSynced, n-nSynced)
+			for i, file := range singletonFiles {
+				log.Infof("%d singletons saved to %s", nSingletons[i], file)
+			}
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(repairPairsCmd)
+
+	repairPairsCmd.Flags().StringP("read1", "1", "", "(gzipped) read1 file")
+	repairPairsCmd.Flags().StringP("read2", "2", "", "(gzipped) read2 file")
+	repairPairsCmd.Flags().StringP("out-dir", "O", "repaired", "output directory")
+	repairPairsCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+	repairPairsCmd.Flags().StringP("tmp-dir", "", os.TempDir(), "directory for temporary files")
+	repairPairsCmd.Flags().IntP("buckets", "", 64, "number of temporary files per mate, more for less memory")
+}
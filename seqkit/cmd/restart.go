@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"strings"
 
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fastx"
@@ -34,10 +35,23 @@ import (
 
 // restartCmd represents the sliding command
 var restartCmd = &cobra.Command{
-	Use:   "restart",
-	Short: "reset start position for circular genome",
+	Use:     "restart",
+	Aliases: []string{"rotate"},
+	Short:   "reset start position for circular genome",
 	Long: `reset start position for circular genome
 
+The new start could be a given position (-i/--new-start), or detected from
+start genes like dnaA or repA in a FASTA file (-q/--start-query):
+  1. candidate loci of every query are found by k-mer seeds on both strands,
+     including loci spanning the end and start of the circular sequence.
+  2. candidate loci are aligned with the query using Smith-Waterman local
+     alignment (the same as "seqkit fish"), and the hit with the highest
+     score is kept if its identity (matched bases / query length) is not
+     less than -I/--min-identity.
+  3. sequences with hits on the negative strand are reverse complemented,
+     and then rotated to start with the hit.
+  Sequences without qualified hits are outputted unchanged.
+
 Examples
 
     $ echo -e ">seq\nacgtnACGTN"
@@ -65,15 +79,34 @@ Examples
 
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
+		var err error
 		newstart := getFlagInt(cmd, "new-start")
 		if newstart == 0 {
 			checkError(fmt.Errorf("value of flag -s (--start) should not be 0"))
 		}
+		startQuery := getFlagString(cmd, "start-query")
+		seedK := getFlagPositiveInt(cmd, "seed-kmer")
+		if seedK > 32 {
+			checkError(fmt.Errorf("value of flag -k/--seed-kmer should be in range of [1, 32]"))
+		}
+		minIdentity := getFlagFloat64(cmd, "min-identity")
+		alnParams := parseAlnParams(getFlagString(cmd, "aln-params"))
+
+		var finder *startFinder
+		if startQuery != "" {
+			finder, err = newStartFinder(startQuery, seedK, alnParams)
+			checkError(err)
+			if !config.Quiet {
+				log.Infof("%d start queries loaded", len(finder.queries))
+			}
+		}
 
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 		defer outfh.Close()
 
+		var start int
+		var hit *startHit
 		var sequence, qual []byte
 		var bufSeq, bufQual bytes.Buffer
 		var l int
@@ -97,30 +130,48 @@ Examples
 				}
 
 				l = len(record.Seq.Seq)
-				if newstart > l || newstart < -l {
-					checkError(fmt.Errorf("new start (%d) exceeds length of sequence (%d)", newstart, l))
+				start = newstart
+				if finder != nil {
+					hit = finder.find(record.Seq.Seq)
+					if hit == nil || hit.identity < minIdentity {
+						if !config.Quiet {
+							log.Warningf("no start query found in sequence: %s", record.ID)
+						}
+						record.FormatToWriter(outfh, config.LineWidth)
+						continue
+					}
+					if hit.strand == "-" {
+						record.Seq.RevComInplace()
+					}
+					start = hit.start + 1
+					if !config.Quiet {
+						log.Infof("%s: start query %s found on %s strand with identity of %.2f%%", record.ID, hit.query, hit.strand, hit.identity)
+					}
+				}
+				if start > l || start < -l {
+					checkError(fmt.Errorf("new start (%d) exceeds length of sequence (%d)", start, l))
 				}
 
 				sequence = record.Seq.Seq
 				bufSeq.Reset()
-				if newstart > 0 {
-					bufSeq.Write(sequence[newstart-1:])
-					bufSeq.Write(sequence[0 : newstart-1])
+				if start > 0 {
+					bufSeq.Write(sequence[start-1:])
+					bufSeq.Write(sequence[0 : start-1])
 				} else {
-					bufSeq.Write(sequence[l+newstart:])
-					bufSeq.Write(sequence[0 : l+newstart])
+					bufSeq.Write(sequence[l+start:])
+					bufSeq.Write(sequence[0 : l+start])
 				}
 				record.Seq.Seq = bufSeq.Bytes()
 
 				if len(record.Seq.Qual) > 0 {
 					qual = record.Seq.Qual
 					bufQual.Reset()
-					if newstart > 0 {
-						bufQual.Write(qual[newstart-1:])
-						bufQual.Write(qual[0 : newstart-1])
+					if start > 0 {
+						bufQual.Write(qual[start-1:])
+						bufQual.Write(qual[0 : start-1])
 					} else {
-						bufQual.Write(qual[l+newstart:])
-						bufQual.Write(qual[0 : l+newstart])
+						bufQual.Write(qual[l+start:])
+						bufQual.Write(qual[0 : l+start])
 
 					}
 					record.Seq.Qual = bufQual.Bytes()
@@ -138,4 +189,144 @@ func init() {
 	RootCmd.AddCommand(restartCmd)
 
 	restartCmd.Flags().IntP("new-start", "i", 1, "new start position (1-base, supporting negative value counting from the end)")
+	restartCmd.Flags().StringP("start-query", "q", "", "FASTA file of start genes (e.g., dnaA, repA) to detect the new start, overriding -i/--new-start")
+	restartCmd.Flags().IntP("seed-kmer", "k", 15, "k-mer size of seeds for detecting start genes (<= 32)")
+	restartCmd.Flags().Float64P("min-identity", "I", 70, "minimum identity (percentage) of start gene hits")
+	restartCmd.Flags().StringP("aln-params", "p", "4,-4,-2,-1", "alignment parameters in format \"<match>,<mismatch>,<gap_open>,<gap_extend>\"")
+}
+
+// startFinder detects start genes in circular sequences.
+type startFinder struct {
+	k         int
+	queries   []*Query // queries of both strands
+	seeds     []map[uint64][]int
+	maxLen    int
+	alnParams *AlnParams
+}
+
+// startHit is a hit of a start gene, with the 0-based start position of the
+// gene on the strand it locates.
+type startHit struct {
+	query    string
+	strand   string
+	start    int
+	score    float64
+	identity float64
+}
+
+func newStartFinder(file string, k int, alnParams *AlnParams) (*startFinder, error) {
+	fastxReader, err := fastx.NewReader(nil, file, "")
+	if err != nil {
+		return nil, err
+	}
+	f := &startFinder{k: k, alnParams: alnParams}
+	var record *fastx.Record
+	var s string
+	for {
+		record, err = fastxReader.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if len(record.Seq.Seq) < k {
+			return nil, fmt.Errorf("start query shorter than k-mer size (%d): %s", k, record.ID)
+		}
+		s = strings.ToUpper(string(record.Seq.Seq))
+		for _, q := range []*Query{
+			{Name: string(record.ID), Seq: s, Strand: "+"},
+			{Name: string(record.ID), Seq: RevCompDNA(s), Strand: "-"},
+		} {
+			seeds := make(map[uint64][]int, len(q.Seq))
+			ForEachKmer([]byte(q.Seq), k, false, func(code uint64, pos int) {
+				seeds[code] = append(seeds[code], pos)
+			})
+			f.queries = append(f.queries, q)
+			f.seeds = append(f.seeds, seeds)
+		}
+		if len(s) > f.maxLen {
+			f.maxLen = len(s)
+		}
+	}
+	if len(f.queries) == 0 {
+		return nil, fmt.Errorf("no start queries found in file: %s", file)
+	}
+	return f, nil
+}
+
+// find returns the best hit of start queries in a circular sequence.
+func (f *startFinder) find(sequence []byte) *startHit {
+	l := len(sequence)
+	if l < f.k {
+		return nil
+	}
+	// appending the head to find hits spanning the end and start
+	ext := f.maxLen - 1
+	if ext > l {
+		ext = l
+	}
+	circ := make([]byte, 0, l+ext)
+	circ = append(circ, sequence...)
+	circ = append(circ, sequence[:ext]...)
+	circ = bytes.ToUpper(circ)
+
+	// voting for diagonals of seeds
+	const bin = 32
+	votes := make([]map[int]int, len(f.queries))
+	for i := range votes {
+		votes[i] = make(map[int]int)
+	}
+	ForEachKmer(circ, f.k, false, func(code uint64, pos int) {
+		for i, seeds := range f.seeds {
+			for _, qpos := range seeds[code] {
+				votes[i][(pos-qpos)/bin]++
+			}
+		}
+	})
+
+	var best *startHit
+	var r *Reference
+	var a *AlignedSeq
+	var b, e, matches int
+	for i, q := range f.queries {
+		bestD, bestV := 0, 0
+		for d, v := range votes[i] {
+			if v > bestV || (v == bestV && d < bestD) {
+				bestD, bestV = d, v
+			}
+		}
+		if bestV == 0 {
+			continue
+		}
+
+		b = bestD*bin - bin - len(q.Seq)/5
+		if b < 0 {
+			b = 0
+		}
+		e = bestD*bin + 2*bin + len(q.Seq) + len(q.Seq)/5
+		if e > len(circ) {
+			e = len(circ)
+		}
+		r = &Reference{Name: "ref", Seq: string(circ), Ranges: Ranges{Range{float64(b), float64(e)}}}
+		a = PairwiseAlignSW(r, q, f.alnParams)
+		if best != nil && a.Score <= best.score {
+			continue
+		}
+
+		matches = 0
+		for j := 0; j < len(a.RefAln); j++ {
+			if a.RefAln[j] != '-' && a.RefAln[j] == a.QueryAln[j] {
+				matches++
+			}
+		}
+		best = &startHit{query: q.Name, strand: q.Strand, score: a.Score,
+			identity: float64(matches) * 100 / float64(len(q.Seq))}
+		if q.Strand == "+" {
+			best.start = a.RefStart % l
+		} else { // the start on the negative strand
+			best.start = l - 1 - (a.RefEnd-1)%l
+		}
+	}
+	return best
 }
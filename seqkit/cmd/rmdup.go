@@ -41,6 +41,22 @@ var rmdupCmd = &cobra.Command{
 	Short: "remove duplicated sequences by id/name/sequence",
 	Long: `remove duplicated sequences by id/name/sequence
 
+Strand-aware mode (-b/--both-strands, only for -s/--by-seq):
+  A sequence and its reverse complement are treated as duplicates.
+
+Containment mode (-C/--containment, implies -s/--by-seq):
+  Sequences fully contained in longer ones (including prefixes and
+  suffixes), and duplicated sequences, are removed. All sequences are
+  loaded into memory, and the k-mers of kept sequences are indexed, so
+  it's designed for gene/amplicon/contig sets rather than reads.
+
+Cluster membership (-c/--cluster-file):
+  A tab-delimited file of all records (in the order of appearance) and
+  the representatives (kept records) they belong to. Columns:
+    id, representative, type
+  Types: rep (representatives), dup (duplicates), dup-rc (duplicates in
+  reverse complement), contained, contained-rc.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -58,10 +74,22 @@ var rmdupCmd = &cobra.Command{
 		ignoreCase := getFlagBool(cmd, "ignore-case")
 		dupFile := getFlagString(cmd, "dup-seqs-file")
 		numFile := getFlagString(cmd, "dup-num-file")
+		bothStrands := getFlagBool(cmd, "both-strands")
+		containment := getFlagBool(cmd, "containment")
+		clusterFile := getFlagString(cmd, "cluster-file")
 
 		if bySeq && byName {
 			checkError(fmt.Errorf("only one/none of the flags -s (--by-seq) and -n (--by-name) is allowed"))
 		}
+		if containment {
+			if byName {
+				checkError(fmt.Errorf("flag -C (--containment) is not compatible with -n (--by-name)"))
+			}
+			bySeq = true
+		}
+		if bothStrands && !bySeq {
+			checkError(fmt.Errorf("flag -b (--both-strands) only works with -s (--by-seq) or -C (--containment)"))
+		}
 
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
@@ -76,11 +104,25 @@ var rmdupCmd = &cobra.Command{
 			defer outfhDup.Close()
 		}
 
+		var outfhCluster *xopen.Writer
+		if len(clusterFile) > 0 {
+			outfhCluster, err = xopen.Wopen(clusterFile)
+			checkError(err)
+			defer outfhCluster.Close()
+			outfhCluster.WriteString("id\trepresentative\ttype\n")
+		}
+
 		counter := make(map[uint64]int)
 		names := make(map[uint64][]string)
+		reps := make(map[uint64]string)
+
+		// for containment mode
+		var records []*fastx.Record
+		var widths []int
 
 		var subject uint64
 		var removed int
+		var rc bool
 		var record *fastx.Record
 		var fastxReader *fastx.Reader
 		for _, file := range files {
@@ -100,12 +142,15 @@ var rmdupCmd = &cobra.Command{
 					fastx.ForcelyOutputFastq = true
 				}
 
+				if containment {
+					records = append(records, record.Clone())
+					widths = append(widths, config.LineWidth)
+					continue
+				}
+
+				rc = false
 				if bySeq {
-					if ignoreCase {
-						subject = xxhash.Sum64(bytes.ToLower(record.Seq.Seq))
-					} else {
-						subject = xxhash.Sum64(record.Seq.Seq)
-					}
+					subject, rc = rmdupSeqKey(record.Seq, ignoreCase, bothStrands)
 				} else if byName {
 					if ignoreCase {
 						subject = xxhash.Sum64(bytes.ToLower(record.Name))
@@ -129,6 +174,13 @@ var rmdupCmd = &cobra.Command{
 					if len(numFile) > 0 {
 						names[subject] = append(names[subject], string(record.ID))
 					}
+					if outfhCluster != nil {
+						t := "dup"
+						if rc != (reps[subject][0] == '-') {
+							t = "dup-rc"
+						}
+						outfhCluster.WriteString(fmt.Sprintf("%s\t%s\t%s\n", record.ID, reps[subject][1:], t))
+					}
 				} else { // new one
 					record.FormatToWriter(outfh, config.LineWidth)
 					counter[subject]++
@@ -136,11 +188,80 @@ var rmdupCmd = &cobra.Command{
 					if len(numFile) > 0 {
 						names[subject] = []string{string(record.ID)}
 					}
+					if outfhCluster != nil {
+						// the strand of the key is kept in the first byte
+						if rc {
+							reps[subject] = "-" + string(record.ID)
+						} else {
+							reps[subject] = "+" + string(record.ID)
+						}
+						outfhCluster.WriteString(fmt.Sprintf("%s\t%s\trep\n", record.ID, record.ID))
+					}
 				}
 			}
 
 			config.LineWidth = lineWidth
 		}
+
+		if containment {
+			seqs := make([][]byte, len(records))
+			var revs [][]byte
+			if bothStrands {
+				revs = make([][]byte, len(records))
+			}
+			for i, r := range records {
+				seqs[i] = r.Seq.Seq
+				if bothStrands {
+					revs[i] = r.Seq.RevCom().Seq
+				}
+				if ignoreCase {
+					seqs[i] = bytes.ToLower(seqs[i])
+					if bothStrands {
+						revs[i] = bytes.ToLower(revs[i])
+					}
+				}
+			}
+			parents, rcs := rmdupContained(seqs, revs)
+
+			// number and list of duplicates (or contained sequences) per representative
+			members := make(map[int][]string)
+			var t string
+			for i, r := range records {
+				p := parents[i]
+				if p < 0 {
+					r.FormatToWriter(outfh, widths[i])
+					if outfhCluster != nil {
+						outfhCluster.WriteString(fmt.Sprintf("%s\t%s\trep\n", r.ID, r.ID))
+					}
+					continue
+				}
+				removed++
+				if len(dupFile) > 0 {
+					outfhDup.Write(r.Format(widths[i]))
+				}
+				if len(numFile) > 0 {
+					if _, ok := members[p]; !ok {
+						members[p] = []string{string(records[p].ID)}
+					}
+					members[p] = append(members[p], string(r.ID))
+				}
+				if outfhCluster != nil {
+					if len(seqs[i]) == len(seqs[p]) {
+						t = "dup"
+					} else {
+						t = "contained"
+					}
+					if rcs[i] {
+						t += "-rc"
+					}
+					outfhCluster.WriteString(fmt.Sprintf("%s\t%s\t%s\n", r.ID, records[p].ID, t))
+				}
+			}
+			for p, l := range members {
+				names[uint64(p)] = l
+			}
+		}
+
 		if removed > 0 && len(numFile) > 0 {
 			outfhNum, err := xopen.Wopen(numFile)
 			checkError(err)
@@ -159,7 +280,11 @@ var rmdupCmd = &cobra.Command{
 		}
 
 		if !quiet {
-			log.Infof("%d duplicated records removed", removed)
+			if containment {
+				log.Infof("%d duplicated or contained records removed", removed)
+			} else {
+				log.Infof("%d duplicated records removed", removed)
+			}
 		}
 	},
 }
@@ -172,6 +297,97 @@ func init() {
 	rmdupCmd.Flags().BoolP("ignore-case", "i", false, "ignore case")
 	rmdupCmd.Flags().StringP("dup-seqs-file", "d", "", "file to save duplicated seqs")
 	rmdupCmd.Flags().StringP("dup-num-file", "D", "", "file to save number and list of duplicated seqs")
+	rmdupCmd.Flags().BoolP("both-strands", "b", false, "treat reverse complement sequences as duplicates, see details above")
+	rmdupCmd.Flags().BoolP("containment", "C", false, "also remove sequences contained in longer ones, see details above")
+	rmdupCmd.Flags().StringP("cluster-file", "c", "", "file to save cluster membership of all records, see details above")
+}
+
+// rmdupSeqKey returns the hash of a sequence, or the smaller one of the
+// sequence and its reverse complement for both strands, in which case
+// rc tells whether the reverse complement is used.
+func rmdupSeqKey(s *seq.Seq, ignoreCase bool, bothStrands bool) (key uint64, rc bool) {
+	fwd := s.Seq
+	if ignoreCase {
+		fwd = bytes.ToLower(fwd)
+	}
+	if !bothStrands {
+		return xxhash.Sum64(fwd), false
+	}
+	rev := s.RevCom().Seq
+	if ignoreCase {
+		rev = bytes.ToLower(rev)
+	}
+	if bytes.Compare(rev, fwd) < 0 {
+		return xxhash.Sum64(rev), true
+	}
+	return xxhash.Sum64(fwd), false
+}
+
+// rmdupSeedK is the k-mer size for indexing sequences in containment mode.
+const rmdupSeedK = 16
+
+// rmdupContained finds sequences contained in longer (or earlier identical)
+// ones. Reverse complement sequences (revs) are also checked if given.
+// parents[i] is the index of the containing sequence, or -1 for kept ones,
+// and rcs[i] tells whether the reverse complement is contained.
+func rmdupContained(seqs [][]byte, revs [][]byte) (parents []int, rcs []bool) {
+	n := len(seqs)
+	parents = make([]int, n)
+	rcs = make([]bool, n)
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(seqs[order[a]]) > len(seqs[order[b]]) })
+
+	index := make(map[uint64][]int32, 1024)
+	kept := make([]int, 0, n)
+
+	find := func(s []byte) int {
+		if len(s) < rmdupSeedK {
+			for _, j := range kept {
+				if bytes.Contains(seqs[j], s) {
+					return j
+				}
+			}
+			return -1
+		}
+		for _, j := range index[xxhash.Sum64(s[:rmdupSeedK])] {
+			if bytes.Contains(seqs[j], s) {
+				return int(j)
+			}
+		}
+		return -1
+	}
+
+	var p int
+	var h uint64
+	var l []int32
+	for _, i := range order {
+		s := seqs[i]
+		p = find(s)
+		if p < 0 && revs != nil {
+			if p = find(revs[i]); p >= 0 {
+				rcs[i] = true
+			}
+		}
+		parents[i] = p
+		if p >= 0 {
+			continue
+		}
+
+		kept = append(kept, i)
+		for k := 0; k+rmdupSeedK <= len(s); k++ {
+			h = xxhash.Sum64(s[k : k+rmdupSeedK])
+			l = index[h]
+			if len(l) > 0 && l[len(l)-1] == int32(i) {
+				continue
+			}
+			index[h] = append(l, int32(i))
+		}
+	}
+	return parents, rcs
 }
 
 type listOfStringSlice struct {
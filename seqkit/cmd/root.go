@@ -80,4 +80,6 @@ func init() {
 	RootCmd.PersistentFlags().BoolP("quiet", "", false, "be quiet and do not show extra information")
 	RootCmd.PersistentFlags().IntP("alphabet-guess-seq-length", "", 10000, "length of sequence prefix of the first FASTA record based on which seqkit guesses the sequence type (0 for whole seq)")
 	RootCmd.PersistentFlags().StringP("infile-list", "", "", "file of input files list (one file per line), if given, they are appended to files from cli arguments")
+	RootCmd.PersistentFlags().StringP("eof-check", "", "warn", `end-of-stream integrity check of BAM and BGZF-compressed inputs (off|warn|fail)`)
+	RootCmd.PersistentFlags().BoolP("eof-check-gzip", "", false, "also check plain gzip inputs for truncation by decompressing them before processing (slow for big files)")
 }
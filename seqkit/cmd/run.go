@@ -0,0 +1,202 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "run a user-defined command alias",
+	Long: `run a user-defined command alias
+
+Aliases are read from ~/.seqkit/aliases.yml (or the file given by --alias-file).
+An alias is either a command line (without the leading "seqkit"):
+
+    sorted-ids: seq -n -i
+
+or a BAM toolbox pipeline, which is expanded to "bam -T '<YAML>'":
+
+    my-qc:
+      Toolbox:
+        AccStats:
+          Tsv: "-"
+        Sink: True
+
+Arguments following the alias name are appended to the expanded command line:
+
+    seqkit run my-qc input.bam
+
+`,
+	Args: cobra.ArbitraryArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		aliasFile := getFlagString(cmd, "alias-file")
+		list := getFlagBool(cmd, "list")
+
+		aliases, err := LoadAliases(aliasFile)
+		checkError(err)
+
+		if list {
+			names := make([]string, 0, len(aliases))
+			for n := range aliases {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				fmt.Printf("%s\t%s\n", n, strings.Join(aliases[n], " "))
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			checkError(fmt.Errorf("no alias given"))
+		}
+		expanded, ok := aliases[args[0]]
+		if !ok {
+			checkError(fmt.Errorf("alias not found in %s: %s", aliasFile, args[0]))
+		}
+
+		self, err := os.Executable()
+		checkError(err)
+		c := exec.Command(self, append(expanded, args[1:]...)...)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err = c.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			checkError(err)
+		}
+	},
+}
+
+// LoadAliases reads alias definitions from a YAML file and expands them to
+// argument lists. A missing file results in an empty alias set.
+func LoadAliases(file string) (map[string][]string, error) {
+	aliases := make(map[string][]string)
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return aliases, nil
+		}
+		return nil, err
+	}
+	var conf yaml.MapSlice
+	if err = yaml.Unmarshal(b, &conf); err != nil {
+		return nil, fmt.Errorf("parse alias file %s: %s", file, err)
+	}
+	for _, item := range conf {
+		name := fmt.Sprint(item.Key)
+		switch v := item.Value.(type) {
+		case string:
+			args, err := SplitCommandLine(v)
+			if err != nil {
+				return nil, fmt.Errorf("alias %s: %s", name, err)
+			}
+			aliases[name] = args
+		case yaml.MapSlice:
+			if len(v) != 1 || v[0].Key != "Toolbox" {
+				return nil, fmt.Errorf("alias %s: only a single Toolbox field is supported", name)
+			}
+			tb, err := yaml.Marshal(v[0].Value)
+			if err != nil {
+				return nil, fmt.Errorf("alias %s: %s", name, err)
+			}
+			aliases[name] = []string{"bam", "-T", string(tb)}
+		default:
+			return nil, fmt.Errorf("alias %s: invalid definition", name)
+		}
+	}
+	return aliases, nil
+}
+
+// SplitCommandLine splits a command line into arguments, honoring single and
+// double quotes and backslash escapes the way a POSIX shell does.
+func SplitCommandLine(s string) ([]string, error) {
+	args := make([]string, 0, 8)
+	var cur strings.Builder
+	inArg := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\') {
+				i++
+				cur.WriteByte(s[i])
+			} else {
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in: %s", s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+func init() {
+	RootCmd.AddCommand(runCmd)
+	defaultAliasFile, err := homedir.Expand("~/.seqkit/aliases.yml")
+	checkError(err)
+	runCmd.Flags().StringP("alias-file", "", defaultAliasFile, "YAML file with alias definitions")
+	runCmd.Flags().BoolP("list", "l", false, "list available aliases")
+	runCmd.Flags().SetInterspersed(false)
+}
@@ -0,0 +1,194 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"", []string{}, false},
+		{"   ", []string{}, false},
+		{"seq -n -i", []string{"seq", "-n", "-i"}, false},
+		{"  seq\t-n \n -i  ", []string{"seq", "-n", "-i"}, false},
+		{`grep -p 'a b'`, []string{"grep", "-p", "a b"}, false},
+		{`grep -p "a b"`, []string{"grep", "-p", "a b"}, false},
+		{`grep -p ''`, []string{"grep", "-p", ""}, false},
+		{`grep -p ""`, []string{"grep", "-p", ""}, false},
+		{`a'b c'd`, []string{"ab cd"}, false},
+		{`'a "b" c'`, []string{`a "b" c`}, false},
+		{`"a 'b' c"`, []string{`a 'b' c`}, false},
+		{`"a \"b\" \\c"`, []string{`a "b" \c`}, false},
+		{`"a \n b"`, []string{`a \n b`}, false},
+		{`'a \' b`, []string{`a \`, "b"}, false},
+		{`a\ b c`, []string{"a b", "c"}, false},
+		{`a\\b`, []string{`a\b`}, false},
+		{`\'a\'`, []string{`'a'`}, false},
+		{`a\`, []string{`a\`}, false},
+		{`grep -p "A{2,}"`, []string{"grep", "-p", "A{2,}"}, false},
+		{`"unterminated`, nil, true},
+		{`'unterminated`, nil, true},
+	}
+	for _, test := range tests {
+		got, err := SplitCommandLine(test.in)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %q", test.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	dir := t.TempDir()
+
+	aliases, err := LoadAliases(filepath.Join(dir, "missing.yml"))
+	if err != nil || len(aliases) != 0 {
+		t.Errorf("missing file: got %v, %v, want no aliases", aliases, err)
+	}
+
+	file := filepath.Join(dir, "aliases.yml")
+	conf := `ids: seq -n -i
+motif: grep -s -r -p "A{4,}"
+my-qc:
+  Toolbox:
+    AccStats:
+      Tsv: "-"
+    Sink: true
+`
+	if err = ioutil.WriteFile(file, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	aliases, err = LoadAliases(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := map[string][]string{
+		"ids":   {"seq", "-n", "-i"},
+		"motif": {"grep", "-s", "-r", "-p", "A{4,}"},
+		"my-qc": {"bam", "-T", "AccStats:\n  Tsv: '-'\nSink: true\n"},
+	}
+	if !reflect.DeepEqual(aliases, want) {
+		t.Errorf("got %q, want %q", aliases, want)
+	}
+
+	for _, bad := range []string{
+		"a: seq 'unterminated\n",
+		"a:\n  Toolbox: {}\n  Other: {}\n",
+		"a:\n  Other: {}\n",
+		"a: [seq, -n]\n",
+		"a: b: c\n",
+	} {
+		if err = ioutil.WriteFile(file, []byte(bad), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = LoadAliases(file); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+// shellQuote quotes an argument for SplitCommandLine.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func FuzzSplitCommandLine(f *testing.F) {
+	f.Add(`grep -s -r -p "A{4,}"`)
+	f.Add(`'a \' b`)
+	f.Add(`"a \"b\" \\c" d\ e`)
+	f.Fuzz(func(t *testing.T, s string) {
+		args, err := SplitCommandLine(s)
+		if err != nil {
+			return
+		}
+		quoted := make([]string, len(args))
+		for i, a := range args {
+			quoted[i] = shellQuote(a)
+		}
+		again, err := SplitCommandLine(strings.Join(quoted, " "))
+		if err != nil {
+			t.Fatalf("quoted arguments %q: %s", quoted, err)
+		}
+		if !reflect.DeepEqual(args, again) {
+			t.Fatalf("got %q after quoting, want %q", again, args)
+		}
+	})
+}
+
+func FuzzLoadAliases(f *testing.F) {
+	f.Add([]byte("ids: seq -n -i\nmy-qc:\n  Toolbox:\n    AccStats:\n      Tsv: \"-\"\n    Sink: true\n"))
+	f.Add([]byte("a: [seq, -n]\nb: &x {Toolbox: *x}\n"))
+	f.Add([]byte("? [a, b]\n: seq\n1: 2\n"))
+	file := filepath.Join(f.TempDir(), "aliases.yml")
+	f.Fuzz(func(t *testing.T, b []byte) {
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		aliases, err := LoadAliases(file)
+		if err != nil {
+			return
+		}
+		var conf yaml.MapSlice
+		if err = yaml.Unmarshal(b, &conf); err != nil {
+			t.Fatal(err)
+		}
+		toolbox := make(map[string]bool)
+		for _, item := range conf {
+			_, toolbox[fmt.Sprint(item.Key)] = item.Value.(yaml.MapSlice)
+		}
+		if len(aliases) != len(toolbox) {
+			t.Fatalf("got %d aliases, want %d", len(aliases), len(toolbox))
+		}
+		for name, args := range aliases {
+			if !toolbox[name] {
+				continue
+			}
+			if len(args) != 3 || args[0] != "bam" || args[1] != "-T" {
+				t.Fatalf("alias %s: got %q", name, args)
+			}
+			var tb interface{}
+			if err = yaml.Unmarshal([]byte(args[2]), &tb); err != nil {
+				t.Fatalf("alias %s: invalid toolbox config %q: %s", name, args[2], err)
+			}
+		}
+	})
+}
@@ -21,11 +21,18 @@
 package cmd
 
 import (
+	"container/heap"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
 
+	"github.com/dustin/go-humanize"
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fastx"
 	"github.com/shenwei356/xopen"
@@ -35,12 +42,28 @@ import (
 // sampleCmd represents the sample command
 var sampleCmd = &cobra.Command{
 	Use:   "sample",
-	Short: "sample sequences by number or proportion",
-	Long: `sample sequences by number or proportion.
+	Short: "sample sequences by number, proportion or bases",
+	Long: `sample sequences by number, proportion or bases.
 
 Attention:
 1. Do not use '-n' on large FASTQ files, it loads all seqs into memory!
    use 'seqkit sample -p 0.1 seqs.fq.gz | seqkit head -n N' instead!
+2. Sampling by bases (-b/--bases), e.g., 500M for 500,000,000 bases, is
+   exact: it equals to shuffling all reads and keeping the first ones until
+   the target is reached. It is done in one pass with a reservoir of at
+   most the target bases (plus one read), so the target should fit in
+   memory. Sampled reads are outputted in their original order.
+3. Reads could also be ranked instead of randomly sampled (--by), keeping
+   the best N reads (--top) or the best reads until reaching a number of
+   bases (-b/--bases), like filtlong:
+     length    longer reads first
+     quality   the weighted geometric mean of the read length and the mean
+               quality (computed from error probabilities), i.e.,
+               length^(--length-weight) * quality^(--quality-weight)
+   Ties are broken by the order of appearance, and kept reads are outputted
+   in their original order. Candidate reads are kept in memory up to
+   --max-mem-bases, more are spilled to temporary files in --tmp-dir and
+   merged in the end.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -64,15 +87,111 @@ Attention:
 		twoPass := getFlagBool(cmd, "two-pass")
 		number := getFlagInt64(cmd, "number")
 		proportion := getFlagFloat64(cmd, "proportion")
+		basesStr := getFlagString(cmd, "bases")
+		var bases uint64
+		if basesStr != "" {
+			var err error
+			bases, err = humanize.ParseBytes(basesStr)
+			if err != nil || bases == 0 {
+				checkError(fmt.Errorf("invalid value of -b (--bases): %s", basesStr))
+			}
+			if number > 0 || proportion > 0 {
+				checkError(fmt.Errorf("flag -b (--bases) is not allowed along with -n (--number) or -p (--proportion)"))
+			}
+		}
+
+		by := getFlagString(cmd, "by")
+		top := getFlagNonNegativeInt(cmd, "top")
+		byQual := false
+		switch by {
+		case "":
+			if top > 0 {
+				checkError(fmt.Errorf("flag --top needs --by"))
+			}
+		case "length":
+		case "quality", "qual":
+			byQual = true
+		default:
+			checkError(fmt.Errorf(`invalid value of --by: %s, available: "length" or "quality"`, by))
+		}
 
 		file := files[0]
 
+		if by != "" {
+			if number > 0 || proportion > 0 {
+				checkError(fmt.Errorf("flag --by is not allowed along with -n (--number) or -p (--proportion)"))
+			}
+			if (top > 0) == (bases > 0) {
+				checkError(fmt.Errorf("one and only one of flags --top and -b (--bases) needed for --by"))
+			}
+			maxMemStr := getFlagString(cmd, "max-mem-bases")
+			maxMem, err := humanize.ParseBytes(maxMemStr)
+			if err != nil || maxMem == 0 {
+				checkError(fmt.Errorf("invalid value of --max-mem-bases: %s", maxMemStr))
+			}
+			qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
+			wLen := getFlagFloat64(cmd, "length-weight")
+			wQual := getFlagFloat64(cmd, "quality-weight")
+			if wLen < 0 || wQual < 0 {
+				checkError(fmt.Errorf("values of --length-weight and --quality-weight should not be negative"))
+			}
+
+			outfh, err := xopen.Wopen(outFile)
+			checkError(err)
+			defer outfh.Close()
+
+			if !quiet {
+				log.Infof("select reads by %s", by)
+			}
+
+			sel := newTopRecords(top, bases, maxMem, getFlagString(cmd, "tmp-dir"))
+			defer sel.clean()
+
+			var score float64
+			fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err := fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				} else if byQual {
+					checkError(fmt.Errorf("--by quality only works for FASTQ format"))
+				}
+
+				if byQual {
+					score = qualityLengthScore(len(record.Seq.Seq), avgQual(record.Seq, qBase), wLen, wQual)
+				} else {
+					score = float64(len(record.Seq.Seq))
+				}
+				sel.add(-score, record)
+			}
+
+			n, total := sel.output(alphabet, idRegexp, func(r *fastx.Record) {
+				r.FormatToWriter(outfh, config.LineWidth)
+			})
+			if bases > 0 && total < bases && !quiet {
+				log.Warningf("only %d bases in input, less than the target (%d)", total, bases)
+			}
+			if !quiet {
+				log.Infof("%d bases in %d sequences outputted", total, n)
+			}
+			return
+		}
+
 		if twoPass && isStdin(file) {
 			checkError(fmt.Errorf("two-pass mode (-2) will failed when reading from stdin. please disable flag: -2"))
 		}
 
-		if number == 0 && proportion == 0 {
-			checkError(fmt.Errorf("one of flags -n (--number) and -p (--proportion) needed"))
+		if number == 0 && proportion == 0 && bases == 0 {
+			checkError(fmt.Errorf("one of flags -n (--number), -p (--proportion), -b (--bases) and --by needed"))
 		}
 
 		if number < 0 {
@@ -91,6 +210,59 @@ Attention:
 		n := int64(0)
 		var record *fastx.Record
 		var fastxReader *fastx.Reader
+		if bases > 0 { // by bases
+			if !quiet {
+				log.Info("sample by bases")
+			}
+
+			h := &sampledRecords{}
+			var total, sum uint64
+			var idx int64
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+				idx++
+				sum += uint64(len(record.Seq.Seq))
+
+				key := rand.Float64()
+				if total >= bases && key >= (*h)[0].key { // would not be kept
+					continue
+				}
+				heap.Push(h, &sampledRecord{key: key, idx: idx, record: record.Clone()})
+				total += uint64(len(record.Seq.Seq))
+				// drop reads with largest keys as long as the target is still reached
+				for h.Len() > 1 && total-uint64(len((*h)[0].record.Seq.Seq)) >= bases {
+					total -= uint64(len(heap.Pop(h).(*sampledRecord).record.Seq.Seq))
+				}
+			}
+
+			if total < bases && !quiet {
+				log.Warningf("only %d bases in input, less than the target (%d)", sum, bases)
+			}
+
+			sort.Slice(*h, func(i, j int) bool { return (*h)[i].idx < (*h)[j].idx })
+			for _, r := range *h {
+				r.record.FormatToWriter(outfh, config.LineWidth)
+			}
+			n = int64(h.Len())
+			if !quiet {
+				log.Infof("%d bases in %d sequences outputted", total, n)
+			}
+			return
+		}
+
 		if number > 0 { // by number
 			if !quiet {
 				log.Info("sample by number")
@@ -202,5 +374,199 @@ func init() {
 	sampleCmd.Flags().Int64P("rand-seed", "s", 11, "rand seed")
 	sampleCmd.Flags().Int64P("number", "n", 0, "sample by number (result may not exactly match), DO NOT use on large FASTQ files.")
 	sampleCmd.Flags().Float64P("proportion", "p", 0, "sample by proportion")
+	sampleCmd.Flags().StringP("bases", "b", "", "sample reads until reaching this number of bases, with units K, M, G and T supported, e.g., 500M")
 	sampleCmd.Flags().BoolP("two-pass", "2", false, "2-pass mode read files twice to lower memory usage. Not allowed when reading from stdin")
+	sampleCmd.Flags().StringP("by", "", "", `rank reads by "length" or "quality" instead of random sampling, see the details above`)
+	sampleCmd.Flags().IntP("top", "", 0, "keep the best N reads for --by")
+	sampleCmd.Flags().Float64P("length-weight", "", 1, "weight of the read length for --by quality")
+	sampleCmd.Flags().Float64P("quality-weight", "", 1, "weight of the mean quality for --by quality")
+	sampleCmd.Flags().IntP("qual-ascii-base", "", 33, "ASCII BASE, 33 for Phred+33")
+	sampleCmd.Flags().StringP("max-mem-bases", "", "1G", "maximum bases of candidate reads kept in memory for --by, more are spilled to temporary files")
+	sampleCmd.Flags().StringP("tmp-dir", "", os.TempDir(), "directory for temporary files of --by")
+}
+
+// sampledRecord is a record with a random key, sampled reads are the ones
+// with the smallest keys.
+type sampledRecord struct {
+	key    float64
+	idx    int64
+	length uint64
+	record *fastx.Record
+}
+
+// before tells whether r ranks before b, by the key and then the order.
+func (r *sampledRecord) before(b *sampledRecord) bool {
+	if r.key == b.key {
+		return r.idx < b.idx
+	}
+	return r.key < b.key
+}
+
+// sampledRecords is a max-heap of sampledRecord by key.
+type sampledRecords []*sampledRecord
+
+func (h sampledRecords) Len() int            { return len(h) }
+func (h sampledRecords) Less(i, j int) bool  { return h[j].before(h[i]) }
+func (h sampledRecords) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sampledRecords) Push(x interface{}) { *h = append(*h, x.(*sampledRecord)) }
+func (h *sampledRecords) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// qualityLengthScore returns the logarithm of the weighted geometric mean of
+// the read length and the mean quality, which ranks the same as the mean.
+func qualityLengthScore(length int, qual float64, wLen, wQual float64) float64 {
+	if length == 0 || qual <= 0 {
+		return math.Inf(-1)
+	}
+	return wLen*math.Log(float64(length)) + wQual*math.Log(qual)
+}
+
+// topRecords keeps the reads with the smallest keys, either the top N ones
+// or the top ones until reaching the target bases.
+//
+// Only keys are kept for all candidates in a heap, while the candidate
+// reads are buffered in the order of appearance. When the buffer exceeds
+// the memory limit, reads falling out of the candidates are dropped, and
+// if still too many, the buffer is spilled to a temporary file. In the
+// end, all spilled files and the buffer are merged in order and the reads
+// still in the candidates are outputted.
+type topRecords struct {
+	top   int
+	bases uint64
+
+	h     sampledRecords // keys of candidates, records not included
+	total uint64         // bases of candidates
+	idx   int64
+
+	maxMem uint64
+	buf    []*sampledRecord
+	bufLen uint64
+
+	tmpDir string
+	dir    string
+	chunks []string
+	keys   [][]*sampledRecord // keys of reads in spilled files
+}
+
+func newTopRecords(top int, bases uint64, maxMem uint64, tmpDir string) *topRecords {
+	return &topRecords{top: top, bases: bases, maxMem: maxMem, tmpDir: tmpDir}
+}
+
+func (t *topRecords) full() bool {
+	if t.top > 0 {
+		return t.h.Len() >= t.top
+	}
+	return t.total >= t.bases
+}
+
+// kept tells whether a read is still one of the candidates.
+func (t *topRecords) kept(r *sampledRecord) bool {
+	return t.h.Len() > 0 && !t.h[0].before(r)
+}
+
+func (t *topRecords) add(key float64, record *fastx.Record) {
+	t.idx++
+	length := uint64(len(record.Seq.Seq))
+	k := &sampledRecord{key: key, idx: t.idx, length: length}
+	if t.full() && !k.before(t.h[0]) { // would not be kept
+		return
+	}
+
+	heap.Push(&t.h, k)
+	t.total += length
+	if t.top > 0 {
+		for t.h.Len() > t.top {
+			t.total -= heap.Pop(&t.h).(*sampledRecord).length
+		}
+	} else {
+		for t.h.Len() > 1 && t.total-t.h[0].length >= t.bases {
+			t.total -= heap.Pop(&t.h).(*sampledRecord).length
+		}
+	}
+
+	t.buf = append(t.buf, &sampledRecord{key: key, idx: t.idx, length: length, record: record.Clone()})
+	t.bufLen += length
+	if t.bufLen <= t.maxMem {
+		return
+	}
+
+	// drop reads not in the candidates any more
+	buf := t.buf[:0]
+	t.bufLen = 0
+	for _, r := range t.buf {
+		if t.kept(r) {
+			buf = append(buf, r)
+			t.bufLen += r.length
+		}
+	}
+	for i := len(buf); i < len(t.buf); i++ {
+		t.buf[i] = nil
+	}
+	t.buf = buf
+	if t.bufLen > t.maxMem/2 {
+		t.spill()
+	}
+}
+
+func (t *topRecords) spill() {
+	var err error
+	if t.dir == "" {
+		t.dir, err = ioutil.TempDir(t.tmpDir, "seqkit-sample")
+		checkError(err)
+	}
+	file := filepath.Join(t.dir, fmt.Sprintf("chunk_%d.fx", len(t.chunks)))
+	outfh, err := xopen.Wopen(file)
+	checkError(err)
+	keys := make([]*sampledRecord, len(t.buf))
+	for i, r := range t.buf {
+		r.record.FormatToWriter(outfh, 0)
+		keys[i] = &sampledRecord{key: r.key, idx: r.idx, length: r.length}
+	}
+	checkError(outfh.Close())
+
+	t.chunks = append(t.chunks, file)
+	t.keys = append(t.keys, keys)
+	t.buf = t.buf[:0]
+	t.bufLen = 0
+}
+
+// output calls fn for all kept reads in their original order, and returns
+// the number of reads and bases.
+func (t *topRecords) output(alphabet *seq.Alphabet, idRegexp string, fn func(*fastx.Record)) (int, uint64) {
+	var n int
+	var bases uint64
+	for i, file := range t.chunks {
+		keys := t.keys[i]
+		var j int
+		readBucket(alphabet, idRegexp, file, func(record *fastx.Record) {
+			if t.kept(keys[j]) {
+				fn(record)
+				n++
+				bases += keys[j].length
+			}
+			j++
+		})
+	}
+	for _, r := range t.buf {
+		if t.kept(r) {
+			fn(r.record)
+			n++
+			bases += r.length
+		}
+	}
+	return n, bases
+}
+
+func (t *topRecords) clean() {
+	if t.dir == "" {
+		return
+	}
+	if err := os.RemoveAll(t.dir); err != nil {
+		log.Warningf("fail to remove temporary directory: %s", t.dir)
+	}
 }
@@ -83,6 +83,10 @@ var sanaCmd = &cobra.Command{
 		defer outfh.Flush()
 		defer outfh.Close()
 
+		quarantine, err := NewQuarantine(getFlagString(cmd, "quarantine"), getFlagInt(cmd, "max-bad-records"))
+		checkError(err)
+		defer quarantine.Close()
+
 		for _, file := range files {
 			rawSeqChan := make(chan *simpleSeq, 10000)
 			ctrlChanIn, ctrlChanOut := NewRawSeqStreamFromFile(file, rawSeqChan, qBase, inFmt, allowGaps)
@@ -112,7 +116,11 @@ var sanaCmd = &cobra.Command{
 					outfh.WriteString(rawSeq.Format(outFmt) + "\n")
 				default:
 					fail++
-					log.Info("File: " + rawSeq.File + "\t" + rawSeq.String() + "\n")
+					if err = quarantine.Add(rawSeq.File, rawSeq.StartLine, rawSeq.Offset, rawSeq.Err, rawSeq.Seq); err != nil {
+						outfh.Close()
+						quarantine.Close()
+						checkError(err)
+					}
 				}
 			}
 			log.Info(fmt.Sprintf("File: %s\tPass records: %d\tDiscarded lines: %d\n", file, pass, fail))
@@ -128,6 +136,8 @@ func init() {
 	sanaCmd.Flags().StringP("format", "i", "fastq", "input and output format: fastq or fasta")
 	sanaCmd.Flags().BoolP("allow-gaps", "A", false, "allow gap character (-) in sequences")
 	sanaCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
+	sanaCmd.Flags().StringP("quarantine", "", "", "write discarded lines to this file in TSV format (file, line number, byte offset, error and content) instead of the log")
+	sanaCmd.Flags().IntP("max-bad-records", "", -1, "exit with error if more than N lines are discarded in total (-1 for no limit)")
 }
 
 // simpleSeq is a structure holding basic sequnce information with qualities.
@@ -138,6 +148,7 @@ type simpleSeq struct {
 	QBase     int
 	Err       error
 	StartLine int
+	Offset    int64 // 0-based byte offset of the first line in the file
 	File      string
 }
 
@@ -261,6 +272,7 @@ type FqlState struct {
 type FqLine struct {
 	Line     string
 	FqlState FqlState
+	Offset   int64
 }
 type FqLines []FqLine
 
@@ -311,7 +323,7 @@ func FqLinesToSimpleSeq(lines FqLines, qBase int, gaps bool) (*simpleSeq, error)
 	}
 	lh, ls, lp, lq := &lines[0], &lines[1], &lines[2], &lines[3]
 	if lh.FqlState.Header && ls.FqlState.Seq && lp.FqlState.Plus && lq.FqlState.Qual {
-		seq := &simpleSeq{lh.Line[1:], ls.Line, parseQuals(lq.Line, qBase), qBase, nil, -1, ""}
+		seq := &simpleSeq{lh.Line[1:], ls.Line, parseQuals(lq.Line, qBase), qBase, nil, -1, lh.Offset, ""}
 		seq.Err = ValidateSeq(seq, gaps)
 		if seq.Err != nil {
 			return nil, seq.Err
@@ -331,7 +343,7 @@ func FasLinesToSimpleSeq(lines FqLines) (*simpleSeq, error) {
 	if !lines[0].FqlState.Header {
 		return nil, errors.New("Missing header line! -> " + lines[0].Line)
 	}
-	s := &simpleSeq{Id: lines[0].Line[1:]}
+	s := &simpleSeq{Id: lines[0].Line[1:], Offset: lines[0].Offset}
 	for i := 1; i < len(lines); i++ {
 		if lines[i].FqlState.Invalid && !lines[i].FqlState.Seq {
 			return nil, errors.New("Invalid line structure!")
@@ -342,7 +354,7 @@ func FasLinesToSimpleSeq(lines FqLines) (*simpleSeq, error) {
 }
 
 // streamFastq reads records from a potentially incomplete fastq file.
-func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSeq, ctrlChanIn, ctrlChanOut chan SeqStreamCtrl, lineCounter *int, qBase int, gaps bool, final bool) (FqLines, error) {
+func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSeq, ctrlChanIn, ctrlChanOut chan SeqStreamCtrl, lineCounter *int, offset *int64, qBase int, gaps bool, final bool) (FqLines, error) {
 	var line []byte
 	var spaceShift int
 	var lastLine *FqLine
@@ -355,6 +367,8 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 			return sbuff, nil
 		}
 		line, err = r.ReadBytes('\n')
+		start := *offset
+		*offset += int64(len(line))
 		switch err {
 		case nil:
 			line = bytes.Trim(line, "\r\n\t ")
@@ -370,13 +384,14 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 			} else {
 				*lineCounter++
 				lineStr := string(line)
-				sbuff = append(sbuff, FqLine{lineStr, guessFqlState(line)})
+				sbuff = append(sbuff, FqLine{lineStr, guessFqlState(line), start})
 				lastLine = &sbuff[len(sbuff)-1]
 			}
 			if len(sbuff) == 4 && !lastLine.FqlState.Partial {
 				seq, err := FqLinesToSimpleSeq(sbuff, qBase, gaps)
 				if err == nil {
 					seq.StartLine = *lineCounter + spaceShift - 4
+					seq.File = name
 					if seq == nil {
 						panic("Sequence is nil!")
 					}
@@ -395,7 +410,7 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 					}
 					for j := 0; j < h; j++ {
 						ems := fmt.Sprintf("Discarded line: %s", err)
-						serr := &simpleSeq{StartLine: (spaceShift + *lineCounter - h + j + 1), Err: errors.New(ems), Seq: sbuff[j].Line, File: name}
+						serr := &simpleSeq{StartLine: (spaceShift + *lineCounter - h + j + 1), Err: errors.New(ems), Seq: sbuff[j].Line, Offset: sbuff[j].Offset, File: name}
 						out <- serr
 					}
 					sbuff = sbuff[h:]
@@ -406,7 +421,7 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 			line = bytes.TrimRight(line, "\n")
 			*lineCounter++
 			if len(line) > 0 {
-				sbuff = append(sbuff, FqLine{string(line), FqlState{Partial: true}})
+				sbuff = append(sbuff, FqLine{string(line), FqlState{Partial: true}, start})
 			}
 			if !final {
 				ctrlChanOut <- StreamEOF
@@ -420,7 +435,7 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 				if err != nil {
 					for il, l := range sbuff {
 						ems := fmt.Sprintf("Discarded line: %s", err)
-						serr := &simpleSeq{StartLine: (spaceShift + *lineCounter - 4 + il + 1), Err: errors.New(ems), Seq: l.Line, File: name}
+						serr := &simpleSeq{StartLine: (spaceShift + *lineCounter - 4 + il + 1), Err: errors.New(ems), Seq: l.Line, Offset: l.Offset, File: name}
 						out <- serr
 						sbuff = sbuff[:0]
 					}
@@ -429,6 +444,7 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 						panic("Sequence is nil!")
 					}
 					seq.StartLine = *lineCounter + spaceShift - 4
+					seq.File = name
 					out <- seq
 					sbuff = sbuff[:0]
 				}
@@ -443,7 +459,7 @@ func streamFastq(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 }
 
 // streamFastq reads records from a potentially incomplete fasta file.
-func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSeq, ctrlChanIn, ctrlChanOut chan SeqStreamCtrl, lineCounter *int, gaps bool, final bool) (FqLines, error) {
+func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSeq, ctrlChanIn, ctrlChanOut chan SeqStreamCtrl, lineCounter *int, offset *int64, gaps bool, final bool) (FqLines, error) {
 	var line []byte
 	var spaceShift int
 	var lastLine *FqLine
@@ -456,6 +472,8 @@ func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 			return sbuff, nil
 		}
 		line, err = r.ReadBytes('\n')
+		start := *offset
+		*offset += int64(len(line))
 		switch err {
 		case nil:
 			line = bytes.TrimRight(line, "\n\t ")
@@ -471,7 +489,7 @@ func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 			} else {
 				*lineCounter++
 				lineStr := string(line)
-				sbuff = append(sbuff, FqLine{lineStr, guessFasState(line, gaps)})
+				sbuff = append(sbuff, FqLine{lineStr, guessFasState(line, gaps), start})
 				lastLine = &sbuff[len(sbuff)-1]
 			}
 			if len(sbuff) >= 2 && !lastLine.FqlState.Partial {
@@ -479,12 +497,13 @@ func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 					seq, err := FasLinesToSimpleSeq(sbuff[:len(sbuff)-1])
 					if err == nil {
 						seq.StartLine = spaceShift + *lineCounter - len(sbuff) - 1
+						seq.File = name
 						out <- seq
 						sbuff = sbuff[len(sbuff)-1:]
 					} else {
 						for j := 0; j < len(sbuff)-1; j++ {
 							ems := fmt.Sprintf("Discarded line: %s", err)
-							serr := &simpleSeq{StartLine: spaceShift + *lineCounter - len(sbuff) - 1 + j, Err: errors.New(ems), Seq: sbuff[j].Line, File: name}
+							serr := &simpleSeq{StartLine: spaceShift + *lineCounter - len(sbuff) - 1 + j, Err: errors.New(ems), Seq: sbuff[j].Line, Offset: sbuff[j].Offset, File: name}
 							out <- serr
 						}
 					}
@@ -502,7 +521,7 @@ func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 				if !final {
 					state.Partial = true
 				}
-				sbuff = append(sbuff, FqLine{string(line), state})
+				sbuff = append(sbuff, FqLine{string(line), state, start})
 			}
 
 			if !final {
@@ -517,12 +536,13 @@ func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 				seq, err := FasLinesToSimpleSeq(sbuff[:len(sbuff)])
 				if err == nil {
 					seq.StartLine = spaceShift + *lineCounter - len(sbuff) - 1
+					seq.File = name
 					out <- seq
 					sbuff = sbuff[:0]
 				} else {
 					for j := 0; j < len(sbuff)-1; j++ {
 						ems := fmt.Sprintf("Discarded line: %s", err)
-						serr := &simpleSeq{StartLine: spaceShift + *lineCounter - len(sbuff) - 1 + j, Err: errors.New(ems), Seq: sbuff[j].Line, File: name}
+						serr := &simpleSeq{StartLine: spaceShift + *lineCounter - len(sbuff) - 1 + j, Err: errors.New(ems), Seq: sbuff[j].Line, Offset: sbuff[j].Offset, File: name}
 						out <- serr
 					}
 				}
@@ -540,6 +560,7 @@ func streamFasta(name string, r *bufio.Reader, sbuff FqLines, out chan *simpleSe
 // NewRawSeqStream initializes a new channel for reading fastq records in a robust way.
 func NewRawFastqStream(name string, inFh *xopen.Reader, inReader *bufio.Reader, seqChan chan *simpleSeq, qBase int, id string, ctrlChanIn, ctrlChanOut chan SeqStreamCtrl, gaps bool) chan *simpleSeq {
 	lineCounter := 0
+	var offset int64
 
 	go func() {
 		sbuff := make(FqLines, 0, 1000)
@@ -564,16 +585,16 @@ func NewRawFastqStream(name string, inFh *xopen.Reader, inReader *bufio.Reader,
 
 				}
 				if cmd == StreamTry {
-					sbuff, err = streamFastq(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, &lineCounter, qBase, gaps, false)
+					sbuff, err = streamFastq(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, &lineCounter, &offset, qBase, gaps, false)
 					if err != nil {
 						log.Fatal(err)
 					}
 
 				} else if cmd == StreamQuit {
-					sbuff, err = streamFastq(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, &lineCounter, qBase, gaps, true)
+					sbuff, err = streamFastq(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, &lineCounter, &offset, qBase, gaps, true)
 					for _, l := range sbuff {
 						ems := fmt.Sprintf("Discarded line: %s", err)
-						serr := &simpleSeq{Err: errors.New(ems), StartLine: lineCounter, Seq: l.Line, File: name}
+						serr := &simpleSeq{Err: errors.New(ems), StartLine: lineCounter, Seq: l.Line, Offset: l.Offset, File: name}
 						seqChan <- serr
 					}
 					ctrlChanOut <- StreamExited
@@ -597,6 +618,7 @@ func NewRawFastaStream(name string, inFh *xopen.Reader, inReader *bufio.Reader,
 		sbuff := make(FqLines, 0, 1000)
 		var err error
 		lineCounter := new(int)
+		offset := new(int64)
 
 	MAIN_FA:
 		for {
@@ -617,16 +639,16 @@ func NewRawFastaStream(name string, inFh *xopen.Reader, inReader *bufio.Reader,
 
 				}
 				if cmd == StreamTry {
-					sbuff, err = streamFasta(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, lineCounter, gaps, false)
+					sbuff, err = streamFasta(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, lineCounter, offset, gaps, false)
 					if err != nil {
 						log.Fatal(err)
 					}
 
 				} else if cmd == StreamQuit {
-					sbuff, err = streamFasta(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, lineCounter, gaps, true)
+					sbuff, err = streamFasta(name, inReader, sbuff, seqChan, ctrlChanIn, ctrlChanOut, lineCounter, offset, gaps, true)
 					for i, l := range sbuff {
 						ems := fmt.Sprintf("Discarded line: %s", err)
-						serr := &simpleSeq{Err: errors.New(ems), StartLine: *lineCounter - i, Seq: l.Line, File: name}
+						serr := &simpleSeq{Err: errors.New(ems), StartLine: *lineCounter - i, Seq: l.Line, Offset: l.Offset, File: name}
 						seqChan <- serr
 					}
 					ctrlChanOut <- StreamExited
@@ -21,16 +21,23 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/iafan/cwalk"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
+	"io/ioutil"
+	"math"
 	"os"
 	"os/signal"
 	ospath "path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -76,6 +83,9 @@ var scatCmd = &cobra.Command{
 		findOnly := getFlagBool(cmd, "find-only")
 		delta := getFlagInt(cmd, "delta") * 1024
 		reStr := getFlagString(cmd, "regexp")
+		yieldFile := getFlagString(cmd, "yield-stats")
+		yieldInterval := getFlagString(cmd, "yield-interval")
+		resumeFile := getFlagString(cmd, "resume")
 		var err error
 		gzNr := 0
 		if gzOnly {
@@ -117,13 +127,25 @@ var scatCmd = &cobra.Command{
 			log.Info("No directories given to watch! Exiting.")
 			os.Exit(1)
 		}
-		LaunchFxWatchers(dirs, ctrlChan, reFilter, inFmt, outFmt, qBase, allowGaps, delta, timeLimit, dropString, waitPid, findOnly, outfh)
+		var yield *YieldTracker
+		if yieldFile != "" {
+			yield, err = NewYieldTracker(yieldFile, yieldInterval)
+			checkError(err)
+			log.Info("Writing per-barcode yield summaries to:", yieldFile)
+		}
+		var resume *ResumeState
+		if resumeFile != "" {
+			resume, err = NewResumeState(resumeFile)
+			checkError(err)
+			log.Info("Resuming from and saving streaming state to:", resumeFile)
+		}
+		LaunchFxWatchers(dirs, ctrlChan, reFilter, inFmt, outFmt, qBase, allowGaps, delta, timeLimit, dropString, waitPid, findOnly, outfh, yield, resume)
 
 	},
 }
 
 // LaunchFxWatchers launches fastx watcher goroutines on multiple input directories.
-func LaunchFxWatchers(dirs []string, ctrlChan WatchCtrlChan, re *regexp.Regexp, inFmt, outFmt string, qBase int, allowGaps bool, delta int, timeout string, dropString string, waitPid int, findOnly bool, outw *xopen.Writer) {
+func LaunchFxWatchers(dirs []string, ctrlChan WatchCtrlChan, re *regexp.Regexp, inFmt, outFmt string, qBase int, allowGaps bool, delta int, timeout string, dropString string, waitPid int, findOnly bool, outw *xopen.Writer, yield *YieldTracker, resume *ResumeState) {
 	allSeqChans := make([]chan *simpleSeq, len(dirs))
 	allInCtrlChans := make([]WatchCtrlChan, len(dirs))
 	allOutCtrlChans := make([]WatchCtrlChan, len(dirs))
@@ -154,7 +176,17 @@ func LaunchFxWatchers(dirs []string, ctrlChan WatchCtrlChan, re *regexp.Regexp,
 		log.Info("Will exit after being inactive for", timeout)
 	}
 
-	pass, fail := 0, 0
+	var yieldTicker <-chan time.Time
+	if yield != nil {
+		yieldTicker = time.NewTicker(yield.Interval).C
+	}
+
+	var resumeTicker <-chan time.Time
+	if resume != nil {
+		resumeTicker = time.NewTicker(RESUME_INTERVAL).C
+	}
+
+	pass, fail, skip := 0, 0, 0
 
 	sendQuitCmds := func() {
 		for i, cc := range allInCtrlChans {
@@ -194,6 +226,13 @@ MAIN:
 			}
 			outw.Flush()
 			continue MAIN
+		case <-yieldTicker:
+			checkError(yield.Save())
+			continue MAIN
+		case <-resumeTicker:
+			outw.Flush()
+			checkError(resume.Save())
+			continue MAIN
 		case <-ticker.C:
 			ticker.Stop()
 			ticker.C = nil
@@ -214,11 +253,18 @@ MAIN:
 						if rawSeq == nil {
 							log.Fatal("Trying to print nil sequence!")
 						}
+						if rawSeq.Err == nil && resume != nil && resume.Skip(rawSeq) {
+							skip++
+							continue CHAN
+						}
 						switch rawSeq.Err {
 						case nil:
 							pass++
 							outw.Write([]byte(rawSeq.Format(outFmt) + "\n"))
 							outw.Flush()
+							if yield != nil {
+								yield.Add(rawSeq)
+							}
 						default:
 							fail++
 							os.Stderr.WriteString("From file: " + rawSeq.File + "\t" + rawSeq.String() + "\n")
@@ -255,9 +301,225 @@ MAIN:
 	} //for evers
 
 	outw.Flush()
+	if yield != nil {
+		checkError(yield.Save())
+	}
+	if resume != nil {
+		checkError(resume.Save())
+		log.Info(fmt.Sprintf("Skipped records streamed in previous runs: %d", skip))
+	}
 	log.Info(fmt.Sprintf("Total stats:\tPass records: %d\tDiscarded lines: %d\n", pass, fail))
 }
 
+// barcodeDirRegexp matches the names of barcode directories created by MinKNOW.
+var barcodeDirRegexp = regexp.MustCompile(`^(barcode\d+|unclassified)$`)
+
+// BarcodeFromPath returns the barcode a file belongs to based on the closest
+// barcode directory in its path, or "none" if there is no such directory.
+func BarcodeFromPath(path string) string {
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(path)), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if barcodeDirRegexp.MatchString(parts[i]) {
+			return parts[i]
+		}
+	}
+	return "none"
+}
+
+// BarcodeYield holds the summary of the reads of a barcode.
+type BarcodeYield struct {
+	Barcode  string
+	Reads    int
+	Bases    int
+	MinLen   int
+	MaxLen   int
+	MeanLen  float64
+	N50      int
+	MeanQual float64
+
+	lengths   map[int]int // histogram of read lengths
+	qualSum   float64
+	qualReads int // reads with base qualities
+}
+
+// YieldTracker maintains per-barcode yield summaries of streamed records and
+// saves them to a TSV or JSON (.json suffix) file.
+type YieldTracker struct {
+	File     string
+	Interval time.Duration
+	Yields   map[string]*BarcodeYield
+}
+
+// NewYieldTracker creates a new yield tracker saving to file at the given interval.
+func NewYieldTracker(file string, interval string) (*YieldTracker, error) {
+	d, err := time.ParseDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	return &YieldTracker{File: file, Interval: d, Yields: make(map[string]*BarcodeYield)}, nil
+}
+
+// Add registers a record.
+func (t *YieldTracker) Add(s *simpleSeq) {
+	bc := BarcodeFromPath(s.File)
+	y, ok := t.Yields[bc]
+	if !ok {
+		y = &BarcodeYield{Barcode: bc, MinLen: -1, lengths: make(map[int]int)}
+		t.Yields[bc] = y
+	}
+	l := len(s.Seq)
+	y.Reads++
+	y.Bases += l
+	if y.MinLen < 0 || l < y.MinLen {
+		y.MinLen = l
+	}
+	if l > y.MaxLen {
+		y.MaxLen = l
+	}
+	y.lengths[l]++
+	if len(s.Qual) > 0 {
+		y.qualSum += meanPhred(s.Qual)
+		y.qualReads++
+	}
+}
+
+// meanPhred calculates the mean quality of a read by averaging the error probabilities.
+func meanPhred(quals []int) float64 {
+	var p float64
+	for _, q := range quals {
+		p += math.Pow(10, -float64(q)/10)
+	}
+	return -10 * math.Log10(p/float64(len(quals)))
+}
+
+// Summary returns the yield summaries ordered by barcode.
+func (t *YieldTracker) Summary() []*BarcodeYield {
+	res := make([]*BarcodeYield, 0, len(t.Yields))
+	for _, y := range t.Yields {
+		y.MeanLen = float64(y.Bases) / float64(y.Reads)
+		y.MeanQual = 0
+		if y.qualReads > 0 {
+			y.MeanQual = y.qualSum / float64(y.qualReads)
+		}
+		lengths := make([]int, 0, len(y.lengths))
+		for l := range y.lengths {
+			lengths = append(lengths, l)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
+		sum := 0
+		for _, l := range lengths {
+			sum += l * y.lengths[l]
+			if sum*2 >= y.Bases {
+				y.N50 = l
+				break
+			}
+		}
+		res = append(res, y)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Barcode < res[j].Barcode })
+	return res
+}
+
+// Save writes the summaries to a temporary file and renames it, so readers of
+// the file never see a partial report.
+func (t *YieldTracker) Save() error {
+	summary := t.Summary()
+	var out []byte
+	if strings.HasSuffix(t.File, ".json") {
+		var err error
+		out, err = json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		out = append(out, '\n')
+	} else {
+		var b strings.Builder
+		b.WriteString("Barcode\tReads\tBases\tMinLen\tMaxLen\tMeanLen\tN50\tMeanQual\n")
+		for _, y := range summary {
+			b.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\t%d\t%.2f\t%d\t%.2f\n", y.Barcode, y.Reads, y.Bases, y.MinLen, y.MaxLen, y.MeanLen, y.N50, y.MeanQual))
+		}
+		out = []byte(b.String())
+	}
+	tmp := t.File + ".tmp"
+	if err := ioutil.WriteFile(tmp, out, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.File)
+}
+
+// RESUME_INTERVAL is the interval for saving the resume state.
+const RESUME_INTERVAL = 5 * time.Second
+
+// ResumeState keeps the number of records streamed from each file,
+// so that a restarted scat skips the records already written out.
+type ResumeState struct {
+	File string
+	Done map[string]int // records streamed in previous runs
+	Seen map[string]int // records seen in this run
+}
+
+// NewResumeState loads the resume state from file, if it exists.
+func NewResumeState(file string) (*ResumeState, error) {
+	r := &ResumeState{File: file, Done: make(map[string]int), Seen: make(map[string]int)}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return r, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		items := strings.Split(line, "\t")
+		if len(items) != 2 {
+			return nil, fmt.Errorf("invalid resume state file: %s, line %d: %s", file, i+1, line)
+		}
+		n, err := strconv.Atoi(items[1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid record number in resume state file: %s, line %d: %s", file, i+1, line)
+		}
+		r.Done[items[0]] = n
+	}
+	return r, nil
+}
+
+// Skip registers a record and tells if it was streamed in a previous run.
+func (r *ResumeState) Skip(s *simpleSeq) bool {
+	r.Seen[s.File]++
+	return r.Seen[s.File] <= r.Done[s.File]
+}
+
+// Save writes the number of streamed records per file.
+func (r *ResumeState) Save() error {
+	counts := make(map[string]int, len(r.Done))
+	for f, n := range r.Done {
+		counts[f] = n
+	}
+	for f, n := range r.Seen {
+		if n > counts[f] {
+			counts[f] = n
+		}
+	}
+	files := make([]string, 0, len(counts))
+	for f := range counts {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+
+	var b strings.Builder
+	b.WriteString("#File\tRecords\n")
+	for _, f := range files {
+		b.WriteString(fmt.Sprintf("%s\t%d\n", f, counts[f]))
+	}
+	tmp := r.File + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.File)
+}
+
 type WatchedFx struct {
 	Name        string
 	LastSize    int64
@@ -573,4 +835,7 @@ func init() {
 	scatCmd.Flags().IntP("delta", "d", 5, "minimum size increase in kilobytes to trigger parsing")
 	scatCmd.Flags().StringP("drop-time", "D", "500ms", "Notification drop interval")
 	scatCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
+	scatCmd.Flags().StringP("yield-stats", "y", "", "periodically write per-barcode yield summaries to this TSV or JSON (.json suffix) file")
+	scatCmd.Flags().StringP("yield-interval", "Y", "30s", "interval for writing the per-barcode yield summaries")
+	scatCmd.Flags().StringP("resume", "R", "", "keep the number of records streamed from each file in this file, and skip them when restarted")
 }
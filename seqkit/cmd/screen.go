@@ -0,0 +1,314 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// screenCmd represents the screen command
+var screenCmd = &cobra.Command{
+	Use:   "screen",
+	Short: "screen reads for contamination against reference sketches",
+	Long: `screen reads for contamination against reference sketches
+
+Reads are classified against reference sketches created by "seqkit sketch",
+e.g., sketches of human, E. coli, phiX and custom genomes. All k-mer hashes
+of a read not greater than the maximum hash of a reference sketch (2^64/S
+for FracMinHash sketches, and the largest hash for MinHash sketches) are
+compared, and the containment of the read in the reference is:
+
+  containment = shared hashes / compared hashes
+
+A read is assigned to the reference with the highest containment (ties
+broken by shared hashes), if the containment and the number of shared
+hashes reach -m/--min-containment and -n/--min-shared. Other reads are
+clean.
+
+FracMinHash sketches with small scaled factors (e.g., "seqkit sketch -S 10")
+are recommended, because few hashes of short reads fall in MinHash sketches
+of large genomes.
+
+Output columns of per-reference counts (tab-delimited):
+  ref, reads, bases, percentage of reads
+
+Columns of per-read assignments (-a/--assignments):
+  read, ref, containment, shared (shared/compared hashes)
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		refFiles := getFlagStringSlice(cmd, "ref-sketch")
+		if len(refFiles) == 0 {
+			checkError(fmt.Errorf("flag -r/--ref-sketch needed"))
+		}
+		minContainment := getFlagFloat64(cmd, "min-containment")
+		if minContainment < 0 || minContainment > 1 {
+			checkError(fmt.Errorf("value of flag -m/--min-containment should be in range of [0, 1]"))
+		}
+		minShared := getFlagPositiveInt(cmd, "min-shared")
+		cleanFile := getFlagString(cmd, "clean-out")
+		contamFile := getFlagString(cmd, "contaminated-out")
+		assignFile := getFlagString(cmd, "assignments")
+
+		var params sketchParams
+		var refs []sketchEntry
+		for i, file := range refFiles {
+			p, entries, err := readSketches(file)
+			checkError(err)
+			if i == 0 {
+				params = p
+			} else {
+				checkError(params.compatible(p))
+			}
+			refs = append(refs, entries...)
+		}
+		if len(refs) == 0 {
+			checkError(fmt.Errorf("no sketches found in reference sketch files"))
+		}
+
+		idx := newScreenIndex(params, refs)
+		if !quiet {
+			log.Infof("%d reference sketches loaded", len(refs))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var cleanfh, contamfh, assignfh *xopen.Writer
+		if cleanFile != "" {
+			cleanfh, err = xopen.Wopen(cleanFile)
+			checkError(err)
+			defer cleanfh.Close()
+		}
+		if contamFile != "" {
+			contamfh, err = xopen.Wopen(contamFile)
+			checkError(err)
+			defer contamfh.Close()
+		}
+		if assignFile != "" {
+			assignfh, err = xopen.Wopen(assignFile)
+			checkError(err)
+			defer assignfh.Close()
+			assignfh.WriteString("read\tref\tcontainment\tshared\n")
+		}
+
+		reads := make([]int, len(refs)+1) // the last one for clean reads
+		bases := make([]int, len(refs)+1)
+		var total int
+
+		var hit screenHit
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+				}
+
+				hit = idx.classify(record.Seq.Seq, minContainment, minShared)
+				total++
+				if hit.ref < 0 {
+					reads[len(refs)]++
+					bases[len(refs)] += len(record.Seq.Seq)
+					if cleanfh != nil {
+						record.FormatToWriter(cleanfh, config.LineWidth)
+					}
+				} else {
+					reads[hit.ref]++
+					bases[hit.ref] += len(record.Seq.Seq)
+					if contamfh != nil {
+						record.FormatToWriter(contamfh, config.LineWidth)
+					}
+				}
+				if assignfh != nil {
+					if hit.ref < 0 {
+						assignfh.WriteString(fmt.Sprintf("%s\t%s\t%.6f\t%d/%d\n", record.ID, "-", hit.containment, hit.shared, hit.compared))
+					} else {
+						assignfh.WriteString(fmt.Sprintf("%s\t%s\t%.6f\t%d/%d\n", record.ID, refs[hit.ref].name, hit.containment, hit.shared, hit.compared))
+					}
+				}
+			}
+			config.LineWidth = lineWidth
+		}
+
+		order := make([]int, len(refs))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool { return reads[order[i]] > reads[order[j]] })
+		order = append(order, len(refs))
+
+		outfh.WriteString("ref\treads\tbases\tpercentage\n")
+		var name string
+		var pct float64
+		for _, i := range order {
+			if i == len(refs) {
+				name = "clean"
+			} else {
+				name = refs[i].name
+			}
+			pct = 0
+			if total > 0 {
+				pct = float64(reads[i]) * 100 / float64(total)
+			}
+			outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%.2f\n", name, reads[i], bases[i], pct))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(screenCmd)
+
+	screenCmd.Flags().StringSliceP("ref-sketch", "r", []string{}, "reference sketch file(s) created by \"seqkit sketch\"")
+	screenCmd.Flags().Float64P("min-containment", "m", 0.1, "minimum containment of a read in a reference")
+	screenCmd.Flags().IntP("min-shared", "n", 1, "minimum number of hashes shared by a read and a reference")
+	screenCmd.Flags().StringP("clean-out", "c", "", "write clean reads to this file")
+	screenCmd.Flags().StringP("contaminated-out", "C", "", "write contaminated reads to this file")
+	screenCmd.Flags().StringP("assignments", "a", "", "write per-read assignments to this file")
+}
+
+// screenIndex maps hashes of reference sketches to the references.
+type screenIndex struct {
+	params  sketchParams
+	maxHash []uint64 // maximum comparable hash of every reference
+	maxAll  uint64
+	index   map[uint64][]int
+	hashes  []uint64 // buffer of read hashes
+	seen    map[uint64]struct{}
+	shared  []int
+	touched []int
+}
+
+func newScreenIndex(p sketchParams, refs []sketchEntry) *screenIndex {
+	idx := &screenIndex{
+		params:  p,
+		maxHash: make([]uint64, len(refs)),
+		index:   make(map[uint64][]int),
+		seen:    make(map[uint64]struct{}),
+		shared:  make([]int, len(refs)),
+	}
+	for i, r := range refs {
+		if p.scaled > 0 {
+			idx.maxHash[i] = ^uint64(0) / p.scaled
+		} else if len(r.hashes) > 0 {
+			idx.maxHash[i] = r.hashes[len(r.hashes)-1]
+		}
+		if idx.maxHash[i] > idx.maxAll {
+			idx.maxAll = idx.maxHash[i]
+		}
+		for _, h := range r.hashes {
+			idx.index[h] = append(idx.index[h], i)
+		}
+	}
+	return idx
+}
+
+type screenHit struct {
+	ref         int // -1 for no hit
+	containment float64
+	shared      int
+	compared    int
+}
+
+// classify returns the best reference of a sequence.
+func (idx *screenIndex) classify(s []byte, minContainment float64, minShared int) screenHit {
+	idx.hashes = idx.hashes[:0]
+	for h := range idx.seen {
+		delete(idx.seen, h)
+	}
+	for _, i := range idx.touched {
+		idx.shared[i] = 0
+	}
+	idx.touched = idx.touched[:0]
+
+	ForEachKmer(s, idx.params.k, idx.params.canonical, func(code uint64, pos int) {
+		h := hash64(code)
+		if h > idx.maxAll {
+			return
+		}
+		if _, ok := idx.seen[h]; ok {
+			return
+		}
+		idx.seen[h] = struct{}{}
+		idx.hashes = append(idx.hashes, h)
+		for _, i := range idx.index[h] {
+			if idx.shared[i] == 0 {
+				idx.touched = append(idx.touched, i)
+			}
+			idx.shared[i]++
+		}
+	})
+
+	best := screenHit{ref: -1}
+	if len(idx.touched) == 0 {
+		best.compared = len(idx.hashes)
+		return best
+	}
+	sort.Slice(idx.hashes, func(i, j int) bool { return idx.hashes[i] < idx.hashes[j] })
+
+	var n int
+	var c float64
+	for _, i := range idx.touched {
+		n = sort.Search(len(idx.hashes), func(j int) bool { return idx.hashes[j] > idx.maxHash[i] })
+		if n == 0 {
+			continue
+		}
+		c = float64(idx.shared[i]) / float64(n)
+		if c > best.containment || (c == best.containment && idx.shared[i] > best.shared) {
+			best = screenHit{ref: i, containment: c, shared: idx.shared[i], compared: n}
+		}
+	}
+	if best.ref >= 0 && (best.containment < minContainment || best.shared < minShared) {
+		best.ref = -1
+	}
+	return best
+}
@@ -26,6 +26,8 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 
 	// "runtime/debug"
@@ -33,6 +35,7 @@ import (
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fastx"
 	"github.com/shenwei356/util/byteutil"
+	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
 
@@ -45,6 +48,33 @@ var seqCmd = &cobra.Command{
 Attentions:
 
   1. This command outputs plain text even when out file ends with ".gz".
+  2. Input files ending with ".tar", ".tar.gz" or ".tgz" are read as
+     archives of FASTA/Q files, extracting one file at a time to a
+     temporary file.
+
+Basic read cleanup, applied before other transformations and filters
+in the order:
+
+  1. cropping fixed numbers of bases from the 5' and 3' ends (-5, -3),
+  2. quality trimming of the 3' end by a sliding window (--trim-qual,
+     --trim-window), the same as "seqkit trim -q",
+  3. truncating sequences to a maximum length (--trim-to-length),
+  4. filtering by the length (-m, -M) and the average quality (-Q, -R,
+     --min-avg-qual) of the cleaned sequences.
+
+Note that -Q/--min-qual and -R/--max-qual use the arithmetic mean of the
+qualities, while --min-avg-qual averages the error probabilities, which is
+more sensitive to low quality bases.
+
+Homopolymer compression (--compress-homopolymers):
+
+  Runs of identical bases (case-insensitive) are collapsed into single
+  bases, after all other transformations. The highest quality of a run is
+  kept for FASTQ. The runs can be saved to a tab-delimited file
+  (--hp-runs-file) with columns of ID, original length, and 0-based
+  positions and lengths of runs longer than 1 in the compressed sequence
+  ("pos:len,..."), which could be used to restore the sequences with
+  --expand-homopolymers, where qualities of the runs are repeated.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -77,6 +107,38 @@ Attentions:
 		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
 		minQual := getFlagFloat64(cmd, "min-qual")
 		maxQual := getFlagFloat64(cmd, "max-qual")
+		crop5 := getFlagNonNegativeInt(cmd, "crop5")
+		crop3 := getFlagNonNegativeInt(cmd, "crop3")
+		trimQual := getFlagFloat64(cmd, "trim-qual")
+		trimWindow := getFlagPositiveInt(cmd, "trim-window")
+		trimToLength := getFlagNonNegativeInt(cmd, "trim-to-length")
+		minAvgQual := getFlagFloat64(cmd, "min-avg-qual")
+		compressHP := getFlagBool(cmd, "compress-homopolymers")
+		hpRunsFile := getFlagString(cmd, "hp-runs-file")
+		expandHPFile := getFlagString(cmd, "expand-homopolymers")
+		if hpRunsFile != "" && !compressHP {
+			checkError(fmt.Errorf("flag --hp-runs-file only works with --compress-homopolymers"))
+		}
+		if compressHP && expandHPFile != "" {
+			checkError(fmt.Errorf("flags --compress-homopolymers and --expand-homopolymers are not compatible"))
+		}
+		var hpRunsfh *xopen.Writer
+		if hpRunsFile != "" {
+			var err error
+			hpRunsfh, err = xopen.Wopen(hpRunsFile)
+			checkError(err)
+			defer hpRunsfh.Close()
+		}
+		var hpRuns map[string]*hpRunList
+		if expandHPFile != "" {
+			var err error
+			hpRuns, err = readHPRuns(expandHPFile)
+			checkError(err)
+		}
+		var qualProbs [256]float64
+		if minAvgQual > 0 {
+			qualProbs = qualErrorProbs(qBase)
+		}
 
 		if gapLetters == "" {
 			checkError(fmt.Errorf("value of flag -G (--gap-letters) should not be empty"))
@@ -161,10 +223,10 @@ Attentions:
 		var text []byte
 		var b *bytes.Buffer
 		var record *fastx.Record
-		var fastxReader *fastx.Reader
+		var fastxReader FastxRecordReader
 
 		for _, file := range files {
-			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			fastxReader, err = NewFastxRecordReader(alphabet, file, idRegexp)
 			checkError(err)
 
 			checkSeqType = true
@@ -184,7 +246,7 @@ Attentions:
 				}
 
 				if checkSeqType {
-					isFastq = fastxReader.IsFastq
+					isFastq = readerIsFastq(fastxReader)
 					if isFastq {
 						config.LineWidth = 0
 						printQual = true
@@ -192,10 +254,25 @@ Attentions:
 					checkSeqType = false
 				}
 
+				if hpRuns != nil {
+					runs, ok := hpRuns[string(record.ID)]
+					if !ok {
+						checkError(fmt.Errorf("homopolymer runs not found for sequence: %s", record.ID))
+					}
+					checkError(expandHomopolymers(record.Seq, runs))
+				}
+
 				if removeGaps {
 					record.Seq.RemoveGapsInplace(gapLetters)
 				}
 
+				if crop5 > 0 || crop3 > 0 || trimQual > 0 || trimToLength > 0 {
+					if !isFastq && trimQual > 0 {
+						checkError(fmt.Errorf("FASTA format has no quality. So do not use flag --trim-qual"))
+					}
+					seqCleanup(record.Seq, crop5, crop3, qBase, trimQual, trimWindow, trimToLength)
+				}
+
 				if minLen >= 0 && len(record.Seq.Seq) < minLen {
 					continue
 				}
@@ -204,6 +281,15 @@ Attentions:
 					continue
 				}
 
+				if minAvgQual > 0 {
+					if !isFastq {
+						checkError(fmt.Errorf("FASTA format has no quality. So do not use flag --min-avg-qual"))
+					}
+					if seqAvgQual(record.Seq.Qual, &qualProbs) < minAvgQual {
+						continue
+					}
+				}
+
 				if minQual > 0 || maxQual > 0 {
 					avgQual := record.Seq.AvgQual(qBase)
 					if minQual > 0 && avgQual < minQual {
@@ -260,6 +346,12 @@ Attentions:
 					}
 					sequence = sequence.ComplementInplace()
 				}
+				if compressHP {
+					runs := compressHomopolymers(sequence)
+					if hpRunsfh != nil {
+						hpRunsfh.WriteString(fmt.Sprintf("%s\t%s\n", record.ID, runs))
+					}
+				}
 
 				if printSeq {
 					if dna2rna {
@@ -365,6 +457,178 @@ Attentions:
 
 var pageSize = syscall.Getpagesize()
 
+// seqCleanup crops the ends of a sequence, trims the low quality 3' end
+// and truncates it to the maximum length (0 for no limit).
+func seqCleanup(s *seq.Seq, crop5, crop3 int, qBase int, trimQual float64, trimWindow int, maxLen int) {
+	start, end := crop5, len(s.Seq)-crop3
+	if end < start {
+		end = start
+	}
+	if start > len(s.Seq) {
+		start, end = len(s.Seq), len(s.Seq)
+	}
+	hasQual := len(s.Qual) == len(s.Seq)
+	if trimQual > 0 && hasQual && end > start {
+		end = start + qualWindowCut(s.Qual[start:end], qBase, trimQual, trimWindow)
+	}
+	if maxLen > 0 && end-start > maxLen {
+		end = start + maxLen
+	}
+	s.Seq = s.Seq[start:end]
+	if hasQual {
+		s.Qual = s.Qual[start:end]
+	}
+}
+
+// hpRunList is the runs of a homopolymer-compressed sequence.
+type hpRunList struct {
+	length int   // original length
+	pos    []int // 0-based positions of runs longer than 1
+	lens   []int // lengths of the runs
+}
+
+func (l *hpRunList) String() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(l.length))
+	b.WriteByte('\t')
+	for i, p := range l.pos {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.Itoa(p))
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(l.lens[i]))
+	}
+	return b.String()
+}
+
+// compressHomopolymers collapses runs of identical bases (case-insensitive)
+// into single bases, keeping the highest qualities of the runs.
+func compressHomopolymers(s *seq.Seq) *hpRunList {
+	runs := &hpRunList{length: len(s.Seq)}
+	hasQual := len(s.Qual) == len(s.Seq)
+	var j int // the position in compressed sequence
+	for i := 0; i < len(s.Seq); i++ {
+		if j > 0 && hpEqualBase(s.Seq[i], s.Seq[j-1]) {
+			if hasQual && s.Qual[i] > s.Qual[j-1] {
+				s.Qual[j-1] = s.Qual[i]
+			}
+			if n := len(runs.pos); n > 0 && runs.pos[n-1] == j-1 {
+				runs.lens[n-1]++
+			} else {
+				runs.pos = append(runs.pos, j-1)
+				runs.lens = append(runs.lens, 2)
+			}
+			continue
+		}
+		s.Seq[j] = s.Seq[i]
+		if hasQual {
+			s.Qual[j] = s.Qual[i]
+		}
+		j++
+	}
+	s.Seq = s.Seq[:j]
+	if hasQual {
+		s.Qual = s.Qual[:j]
+	}
+	return runs
+}
+
+func hpEqualBase(a, b byte) bool {
+	return a == b || a|0x20 == b|0x20 && a|0x20 >= 'a' && a|0x20 <= 'z'
+}
+
+// expandHomopolymers restores a homopolymer-compressed sequence.
+func expandHomopolymers(s *seq.Seq, runs *hpRunList) error {
+	hasQual := len(s.Qual) == len(s.Seq)
+	seq2 := make([]byte, 0, runs.length)
+	var qual2 []byte
+	if hasQual {
+		qual2 = make([]byte, 0, runs.length)
+	}
+	var k, n int
+	for i, b := range s.Seq {
+		n = 1
+		if k < len(runs.pos) && runs.pos[k] == i {
+			n = runs.lens[k]
+			k++
+		}
+		for ; n > 0; n-- {
+			seq2 = append(seq2, b)
+			if hasQual {
+				qual2 = append(qual2, s.Qual[i])
+			}
+		}
+	}
+	if k != len(runs.pos) || len(seq2) != runs.length {
+		return fmt.Errorf("homopolymer runs do not match the sequence (length: %d)", len(s.Seq))
+	}
+	s.Seq = seq2
+	if hasQual {
+		s.Qual = qual2
+	}
+	return nil
+}
+
+// readHPRuns reads homopolymer runs saved by --hp-runs-file.
+func readHPRuns(file string) (map[string]*hpRunList, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	m := make(map[string]*hpRunList)
+	var line string
+	var items []string
+	var p, l int
+	var e error
+	for {
+		line, err = fh.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			items = strings.Split(line, "\t")
+			if len(items) != 3 {
+				return nil, fmt.Errorf("invalid homopolymer runs: %s", line)
+			}
+			runs := &hpRunList{}
+			if runs.length, e = strconv.Atoi(items[1]); e != nil {
+				return nil, fmt.Errorf("invalid homopolymer runs: %s", line)
+			}
+			if items[2] != "" {
+				for _, r := range strings.Split(items[2], ",") {
+					if _, e = fmt.Sscanf(r, "%d:%d", &p, &l); e != nil {
+						return nil, fmt.Errorf("invalid homopolymer run: %s", r)
+					}
+					runs.pos = append(runs.pos, p)
+					runs.lens = append(runs.lens, l)
+				}
+			}
+			m[items[0]] = runs
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// seqAvgQual returns the average quality of a read by averaging the error
+// probabilities.
+func seqAvgQual(qual []byte, probs *[256]float64) float64 {
+	if len(qual) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, q := range qual {
+		sum += probs[q]
+	}
+	return errorProbToPhred(sum / float64(len(qual)))
+}
+
 func init() {
 	RootCmd.AddCommand(seqCmd)
 
@@ -388,4 +652,13 @@ func init() {
 	seqCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
 	seqCmd.Flags().Float64P("min-qual", "Q", -1, "only print sequences with average quality qreater or equal than this limit (-1 for no limit)")
 	seqCmd.Flags().Float64P("max-qual", "R", -1, "only print sequences with average quality less than this limit (-1 for no limit)")
+	seqCmd.Flags().IntP("crop5", "5", 0, "number of bases cropped from the 5' end")
+	seqCmd.Flags().IntP("crop3", "3", 0, "number of bases cropped from the 3' end")
+	seqCmd.Flags().Float64P("trim-qual", "", 0, "minimum mean quality of the sliding windows for trimming the 3' end (0 for no quality trimming)")
+	seqCmd.Flags().IntP("trim-window", "", 4, "size of the sliding window of --trim-qual")
+	seqCmd.Flags().IntP("trim-to-length", "", 0, "truncate sequences to this length after cropping and quality trimming (0 for no limit)")
+	seqCmd.Flags().BoolP("compress-homopolymers", "", false, "compress homopolymers, see details above")
+	seqCmd.Flags().StringP("hp-runs-file", "", "", "save homopolymer runs of --compress-homopolymers to this file")
+	seqCmd.Flags().StringP("expand-homopolymers", "", "", "restore homopolymer-compressed sequences with runs in this file")
+	seqCmd.Flags().Float64P("min-avg-qual", "", -1, "only print sequences with average quality (of error probabilities) greater or equal than this limit (-1 for no limit)")
 }
@@ -341,11 +341,7 @@ func (a *AlignedSeq) String() string {
 		return fmt.Sprintf("%.0f", a.Score)
 	}
 	fmap["MapQual"] = func(a *AlignedSeq) string {
-		mq := -10 * math.Log10(1-a.Score/a.Query.NullScore)
-		if math.IsInf(mq, 1) {
-			mq = 60
-		}
-		return fmt.Sprintf("%.2f", mq)
+		return fmt.Sprintf("%.2f", a.MapQual())
 	}
 	fmap["Acc"] = func(a *AlignedSeq) string {
 		diff := 0
@@ -382,6 +378,61 @@ func (a *AlignedSeq) String() string {
 	return strings.Join(tmp, "\t")
 }
 
+// MapQual returns the phred-scaled mapping quality of the alignment, capped at 60.
+func (a *AlignedSeq) MapQual() float64 {
+	mq := -10 * math.Log10(1-a.Score/a.Query.NullScore)
+	if math.IsInf(mq, 1) {
+		mq = 60
+	}
+	return mq
+}
+
+// PAF generates a PAF line of the alignment, with the query as the PAF query
+// and the reference of the given length as the PAF target.
+func (a *AlignedSeq) PAF(refLen int) string {
+	qLen := len(a.Query.Seq)
+	qStart, qEnd := a.QueryStart, a.QueryEnd
+	if a.Query.Strand == "-" {
+		qStart, qEnd = qLen-a.QueryEnd, qLen-a.QueryStart
+	}
+	var matches int
+	for i, rb := range []byte(a.RefAln) {
+		if rb != '-' && rb == a.QueryAln[i] {
+			matches++
+		}
+	}
+	return fmt.Sprintf("%s\t%d\t%d\t%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\tAS:i:%.0f",
+		a.Query.Name, qLen, qStart, qEnd, a.Query.Strand,
+		a.Ref.Name, refLen, a.RefStart, a.RefEnd,
+		matches, len(a.RefAln), int(a.MapQual()+0.5), a.Score)
+}
+
+// InferOrientation infers the orientation of a reference from the strands of
+// its hits, weighted by the alignment scores. It returns "+" or "-", "?" for
+// ambiguous cases and "." if there are no hits.
+func InferOrientation(hits []*AlignedSeq) (orientation string, nPlus, nMinus int, plusScore, minusScore float64) {
+	for _, h := range hits {
+		if h.Query.Strand == "-" {
+			nMinus++
+			minusScore += h.Score
+		} else {
+			nPlus++
+			plusScore += h.Score
+		}
+	}
+	switch {
+	case len(hits) == 0:
+		orientation = "."
+	case plusScore > minusScore:
+		orientation = "+"
+	case minusScore > plusScore:
+		orientation = "-"
+	default:
+		orientation = "?"
+	}
+	return
+}
+
 func (a *AlignedSeq) AlnString() string {
 	return fmt.Sprintf("@\t%s\t+\t%d\t%d\t%s\n@\t%s\t%s\t%d\t%d\t%s", a.RefAln, a.RefStart, a.RefEnd, a.Ref.Name, a.QueryAln, a.Query.Strand, a.QueryStart, a.QueryEnd, a.Query.Name)
 }
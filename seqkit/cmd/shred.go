@@ -0,0 +1,175 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// shredCmd represents the shred command
+var shredCmd = &cobra.Command{
+	Use:   "shred",
+	Short: "shred sequences into fixed-size or normally-distributed fragments",
+	Long: `shred sequences into fixed-size or normally-distributed fragments
+
+Sequences are cut into consecutive fragments of -l/--length bases, or with
+lengths drawn from a normal distribution with a standard deviation of
+-L/--length-sd. Every fragment starts -O/--overlap bases before the end of
+the previous one. The last fragment of a sequence is dropped if it's
+shorter than -m/--min-len.
+
+With -r/--random-strand, every fragment is reverse complemented with a
+probability of 0.5.
+
+Fragments are named "<seqID>_shred:<start>-<end>:<strand>" with 1-based
+coordinates on the original sequence.
+
+Shredding with an overlap of length-1 produces all subsequences of the
+length, which can be mapped back to the genome to compute mappability
+tracks.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		length := getFlagPositiveInt(cmd, "length")
+		sd := getFlagFloat64(cmd, "length-sd")
+		overlap := getFlagNonNegativeInt(cmd, "overlap")
+		minLen := getFlagPositiveInt(cmd, "min-len")
+		randomStrand := getFlagBool(cmd, "random-strand")
+		seed := getFlagInt64(cmd, "rand-seed")
+
+		if sd < 0 {
+			checkError(fmt.Errorf("value of flag -L/--length-sd should not be negative"))
+		}
+		if sd == 0 && overlap >= length {
+			checkError(fmt.Errorf("value of flag -O/--overlap should be smaller than -l/--length"))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		rng := rand.New(rand.NewSource(seed))
+		fragLen := func() int {
+			if sd == 0 {
+				return length
+			}
+			l := int(rng.NormFloat64()*sd + float64(length) + 0.5)
+			if l <= overlap {
+				return overlap + 1
+			}
+			return l
+		}
+
+		var n, nFrags int
+		var start, end int
+		var strand byte
+		var frag *fastx.Record
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+				n++
+
+				l := len(record.Seq.Seq)
+				for start = 0; start < l; start = end - overlap {
+					end = start + fragLen()
+					if end > l {
+						end = l
+					}
+					if end-start < minLen {
+						break
+					}
+
+					strand = '+'
+					name := []byte(fmt.Sprintf("%s_shred:%d-%d:", record.ID, start+1, end))
+					if len(record.Seq.Qual) > 0 {
+						frag, _ = fastx.NewRecordWithQualWithoutValidation(record.Seq.Alphabet,
+							[]byte{}, name, []byte{}, record.Seq.Seq[start:end], record.Seq.Qual[start:end])
+					} else {
+						frag, _ = fastx.NewRecordWithoutValidation(record.Seq.Alphabet,
+							[]byte{}, name, []byte{}, record.Seq.Seq[start:end])
+					}
+					if randomStrand && rng.Intn(2) == 1 {
+						frag.Seq = frag.Seq.RevCom()
+						strand = '-'
+					}
+					frag.Name = append(frag.Name, strand)
+					frag.FormatToWriter(outfh, config.LineWidth)
+					nFrags++
+
+					if end == l {
+						break
+					}
+				}
+			}
+			config.LineWidth = lineWidth
+		}
+
+		if !quiet {
+			log.Infof("%d sequences shredded into %d fragments", n, nFrags)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(shredCmd)
+
+	shredCmd.Flags().IntP("length", "l", 1000, "(mean) fragment length")
+	shredCmd.Flags().Float64P("length-sd", "L", 0, "standard deviation of fragment lengths (0 for fixed length)")
+	shredCmd.Flags().IntP("overlap", "O", 0, "overlap between adjacent fragments")
+	shredCmd.Flags().IntP("min-len", "m", 1, "minimum length of the last fragments")
+	shredCmd.Flags().BoolP("random-strand", "r", false, "reverse complement fragments randomly")
+	shredCmd.Flags().Int64P("rand-seed", "s", 11, "rand seed")
+}
@@ -0,0 +1,393 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// simCmd represents the sim command
+var simCmd = &cobra.Command{
+	Use:   "sim",
+	Short: "simulate reads from reference sequences",
+	Long: `simulate reads from reference sequences
+
+Reads are sampled uniformly from both strands of the reference sequences,
+which are chosen with probabilities proportional to their lengths. The
+number of reads is given by -n/--num-reads, or computed from the target
+coverage (-C/--coverage) as coverage * total length / mean read length.
+
+Read length distributions (-d/--length-dist):
+  fixed       all reads have the length of -l/--length
+  normal      normal distribution with mean -l/--length and SD -L/--length-sd
+  lognormal   log-normal distribution with mean -l/--length and SD
+              -L/--length-sd, a long right tail like nanopore reads
+  Reads are no longer than the reference sequences.
+
+Error profiles (-p/--profile) set default values of the length and error
+rates, which could be overwritten by the corresponding flags:
+  illumina    fixed length of 150 bp, 0.1% substitutions, 0.005% indels
+  ont         log-normal lengths (mean 5000 bp, SD 4000 bp), 4% substitutions,
+              3% insertions and 3% deletions
+  none        fixed length of 150 bp, no errors
+
+At every reference base of a read, a random base is inserted before it with
+the insertion rate, then it is deleted with the deletion rate, or
+substituted with the substitution rate. Base qualities are computed from
+the total error rate, while erroneous bases have a quality of 5.
+
+Read names are "sim_N ref:start-end:strand" (1-based coordinates).
+The ground-truth alignments could be saved in PAF or SAM format
+(-a/--aln-file, by the file extension or -f/--aln-format), with CIGARs
+in the orientation of the reference.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		profile := getFlagString(cmd, "profile")
+		p, ok := simProfiles[profile]
+		if !ok {
+			checkError(fmt.Errorf("invalid value of flag -p/--profile: %s, available: illumina, ont, none", profile))
+		}
+		if cmd.Flags().Changed("length-dist") {
+			p.dist = getFlagString(cmd, "length-dist")
+		}
+		if cmd.Flags().Changed("length") {
+			p.length = float64(getFlagPositiveInt(cmd, "length"))
+		}
+		if cmd.Flags().Changed("length-sd") {
+			p.sd = getFlagFloat64(cmd, "length-sd")
+		}
+		if cmd.Flags().Changed("sub-rate") {
+			p.sub = getFlagFloat64(cmd, "sub-rate")
+		}
+		if cmd.Flags().Changed("ins-rate") {
+			p.ins = getFlagFloat64(cmd, "ins-rate")
+		}
+		if cmd.Flags().Changed("del-rate") {
+			p.del = getFlagFloat64(cmd, "del-rate")
+		}
+		switch p.dist {
+		case "fixed", "normal", "lognormal":
+		default:
+			checkError(fmt.Errorf("invalid value of flag -d/--length-dist: %s, available: fixed, normal, lognormal", p.dist))
+		}
+		if p.sd < 0 {
+			checkError(fmt.Errorf("value of flag -L/--length-sd should not be negative"))
+		}
+		if p.sub < 0 || p.ins < 0 || p.del < 0 || p.sub+p.ins+p.del >= 1 {
+			checkError(fmt.Errorf("error rates should be non-negative with a sum less than 1"))
+		}
+
+		numReads := getFlagNonNegativeInt(cmd, "num-reads")
+		coverage := getFlagFloat64(cmd, "coverage")
+		if (numReads == 0) == (coverage <= 0) {
+			checkError(fmt.Errorf("one of flags -n/--num-reads and -C/--coverage needed"))
+		}
+		seed := getFlagInt64(cmd, "rand-seed")
+		forwardOnly := getFlagBool(cmd, "forward-only")
+		alnFile := getFlagString(cmd, "aln-file")
+		alnFormat := getFlagString(cmd, "aln-format")
+		if alnFile != "" && alnFormat == "" {
+			switch strings.ToLower(filepath.Ext(strings.TrimSuffix(alnFile, ".gz"))) {
+			case ".sam":
+				alnFormat = "sam"
+			default:
+				alnFormat = "paf"
+			}
+		}
+		if alnFormat != "" && alnFormat != "paf" && alnFormat != "sam" {
+			checkError(fmt.Errorf("invalid value of flag -f/--aln-format: %s, available: paf, sam", alnFormat))
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		var refs []*fastx.Record
+		for _, file := range files {
+			records, err := fastx.GetSeqs(file, alphabet, config.Threads, 10, idRegexp)
+			checkError(err)
+			for _, r := range records {
+				if len(r.Seq.Seq) > 0 {
+					refs = append(refs, r)
+				}
+			}
+		}
+		if len(refs) == 0 {
+			checkError(fmt.Errorf("no reference sequences given"))
+		}
+		cumLens := make([]int, len(refs))
+		var total int
+		for i, r := range refs {
+			total += len(r.Seq.Seq)
+			cumLens[i] = total
+		}
+		if numReads == 0 {
+			numReads = int(math.Ceil(coverage * float64(total) / p.length))
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+
+		var alnfh *xopen.Writer
+		if alnFile != "" {
+			alnfh, err = xopen.Wopen(alnFile)
+			checkError(err)
+			defer alnfh.Close()
+			if alnFormat == "sam" {
+				alnfh.WriteString("@HD\tVN:1.6\tSO:unsorted\n")
+				for _, r := range refs {
+					alnfh.WriteString(fmt.Sprintf("@SQ\tSN:%s\tLN:%d\n", r.ID, len(r.Seq.Seq)))
+				}
+				alnfh.WriteString("@PG\tID:seqkit\tPN:seqkit\tCL:seqkit sim\n")
+			}
+		}
+
+		sim := newReadSimulator(p, rand.New(rand.NewSource(seed)))
+		var bases int
+		var name string
+		var r *simRead
+		for i := 1; i <= numReads; i++ {
+			k := sort.SearchInts(cumLens, sim.rng.Intn(total)+1)
+			ref := refs[k]
+			r = sim.simulate(ref.Seq.Seq, forwardOnly)
+			bases += len(r.seq)
+
+			name = fmt.Sprintf("sim_%d", i)
+			outfh.WriteString(fmt.Sprintf("@%s %s:%d-%d:%c\n%s\n+\n%s\n", name, ref.ID, r.start+1, r.end, r.strand, r.seq, r.qual))
+
+			switch alnFormat {
+			case "paf":
+				alnfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\t%c\t%s\t%d\t%d\t%d\t%d\t%d\t60\tNM:i:%d\tcg:Z:%s\n",
+					name, len(r.seq), 0, len(r.seq), r.strand, ref.ID, len(ref.Seq.Seq), r.start, r.end,
+					r.matches, r.alnLen, r.edits, r.cigar))
+			case "sam":
+				flag := 0
+				fwdSeq, fwdQual := r.seq, r.qual
+				if r.strand == '-' {
+					flag = 16
+					fwdSeq = []byte(RevCompDNA(string(r.seq)))
+					fwdQual = reverseBytes(r.qual)
+				}
+				alnfh.WriteString(fmt.Sprintf("%s\t%d\t%s\t%d\t60\t%s\t*\t0\t0\t%s\t%s\tNM:i:%d\n",
+					name, flag, ref.ID, r.start+1, r.cigar, fwdSeq, fwdQual, r.edits))
+			}
+		}
+
+		if !quiet {
+			log.Infof("%d reads (%d bases) simulated from %d sequences (%d bases), coverage: %.2f",
+				numReads, bases, len(refs), total, float64(bases)/float64(total))
+		}
+	},
+}
+
+// simProfile is the length distribution and error rates of reads.
+type simProfile struct {
+	dist          string
+	length, sd    float64
+	sub, ins, del float64
+}
+
+var simProfiles = map[string]simProfile{
+	"illumina": {dist: "fixed", length: 150, sub: 0.001, ins: 0.00005, del: 0.00005},
+	"ont":      {dist: "lognormal", length: 5000, sd: 4000, sub: 0.04, ins: 0.03, del: 0.03},
+	"none":     {dist: "fixed", length: 150},
+}
+
+// simRead is a simulated read with its ground-truth alignment.
+type simRead struct {
+	seq, qual  []byte // in the orientation of the read
+	start, end int    // 0-based, half-open on the reference
+	strand     byte
+	cigar      string // in the orientation of the reference
+	matches    int
+	alnLen     int
+	edits      int
+}
+
+type readSimulator struct {
+	p   simProfile
+	rng *rand.Rand
+
+	// parameters of log-normal distribution
+	mu, sigma float64
+
+	qualOK byte
+	buf    []byte
+	ops    []byte
+}
+
+const simErrQual = 5
+
+func newReadSimulator(p simProfile, rng *rand.Rand) *readSimulator {
+	s := &readSimulator{p: p, rng: rng}
+	if p.dist == "lognormal" {
+		s.sigma = math.Sqrt(math.Log(1 + p.sd*p.sd/(p.length*p.length)))
+		s.mu = math.Log(p.length) - s.sigma*s.sigma/2
+	}
+	q := 41.0
+	if e := p.sub + p.ins + p.del; e > 0 {
+		q = math.Min(41, math.Round(errorProbToPhred(e)))
+	}
+	s.qualOK = byte(q) + 33
+	return s
+}
+
+// readLength draws a read length from the length distribution.
+func (s *readSimulator) readLength() int {
+	var l float64
+	switch s.p.dist {
+	case "normal":
+		l = s.rng.NormFloat64()*s.p.sd + s.p.length
+	case "lognormal":
+		l = math.Exp(s.rng.NormFloat64()*s.sigma + s.mu)
+	default:
+		l = s.p.length
+	}
+	if l < 1 {
+		return 1
+	}
+	return int(l + 0.5)
+}
+
+// simulate samples a read from a reference sequence.
+func (s *readSimulator) simulate(ref []byte, forwardOnly bool) *simRead {
+	l := s.readLength()
+	if l > len(ref) {
+		l = len(ref)
+	}
+	r := &simRead{strand: '+'}
+	r.start = s.rng.Intn(len(ref) - l + 1)
+	r.end = r.start + l
+	if !forwardOnly && s.rng.Intn(2) == 1 {
+		r.strand = '-'
+	}
+
+	// errors are introduced along the forward strand of the reference
+	rs := make([]byte, 0, l+l/10)
+	qual := make([]byte, 0, l+l/10)
+	s.ops = s.ops[:0]
+	var x float64
+	var b byte
+	for _, c := range ref[r.start:r.end] {
+		if s.p.ins > 0 && s.rng.Float64() < s.p.ins {
+			rs = append(rs, "ACGT"[s.rng.Intn(4)])
+			qual = append(qual, simErrQual+33)
+			s.ops = append(s.ops, 'I')
+			r.edits++
+		}
+		x = s.rng.Float64()
+		switch {
+		case x < s.p.del:
+			s.ops = append(s.ops, 'D')
+			r.edits++
+		case x < s.p.del+s.p.sub:
+			b = "ACGT"[s.rng.Intn(3)]
+			if b == c&^0x20 { // a different base
+				b = 'T'
+			}
+			rs = append(rs, b)
+			qual = append(qual, simErrQual+33)
+			s.ops = append(s.ops, 'M')
+			r.edits++
+		default:
+			rs = append(rs, c)
+			qual = append(qual, s.qualOK)
+			s.ops = append(s.ops, 'M')
+			r.matches++
+		}
+	}
+
+	// deletions at the ends are not a part of the alignment
+	ops := s.ops
+	for len(ops) > 0 && ops[0] == 'D' {
+		ops = ops[1:]
+		r.start++
+		r.edits--
+	}
+	for len(ops) > 0 && ops[len(ops)-1] == 'D' {
+		ops = ops[:len(ops)-1]
+		r.end--
+		r.edits--
+	}
+	r.alnLen = len(ops)
+	r.cigar = simCigar(ops)
+
+	if r.strand == '-' {
+		rs = []byte(RevCompDNA(string(rs)))
+		qual = reverseBytes(qual)
+	}
+	r.seq, r.qual = rs, qual
+	return r
+}
+
+func simCigar(ops []byte) string {
+	var buf bytes.Buffer
+	for k := 0; k < len(ops); {
+		l := k + 1
+		for l < len(ops) && ops[l] == ops[k] {
+			l++
+		}
+		buf.WriteString(strconv.Itoa(l - k))
+		buf.WriteByte(ops[k])
+		k = l
+	}
+	return buf.String()
+}
+
+func init() {
+	RootCmd.AddCommand(simCmd)
+
+	simCmd.Flags().IntP("num-reads", "n", 0, "number of reads")
+	simCmd.Flags().Float64P("coverage", "C", 0, "target coverage, used when -n/--num-reads is not given")
+	simCmd.Flags().StringP("profile", "p", "illumina", "error profile: illumina, ont or none, see details above")
+	simCmd.Flags().StringP("length-dist", "d", "fixed", "read length distribution: fixed, normal or lognormal")
+	simCmd.Flags().IntP("length", "l", 150, "(mean) read length")
+	simCmd.Flags().Float64P("length-sd", "L", 0, "standard deviation of read lengths")
+	simCmd.Flags().Float64P("sub-rate", "", 0.001, "substitution rate")
+	simCmd.Flags().Float64P("ins-rate", "", 0.00005, "insertion rate")
+	simCmd.Flags().Float64P("del-rate", "", 0.00005, "deletion rate")
+	simCmd.Flags().BoolP("forward-only", "F", false, "only simulate reads from the forward strands")
+	simCmd.Flags().Int64P("rand-seed", "s", 11, "rand seed")
+	simCmd.Flags().StringP("aln-file", "a", "", "save ground-truth alignments to this file")
+	simCmd.Flags().StringP("aln-format", "f", "", "format of -a/--aln-file: paf or sam (default by the file extension, paf for others)")
+}
@@ -0,0 +1,326 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// sketchCmd represents the sketch command
+var sketchCmd = &cobra.Command{
+	Use:   "sketch",
+	Short: "sketch sequences with MinHash/FracMinHash and estimate distances",
+	Long: `sketch sequences with MinHash/FracMinHash and estimate distances
+
+Every input file (or every sequence with -i/--individual) is sketched from
+its (canonical) k-mers with the MurmurHash3 finalizer:
+
+  MinHash      the -s/--sketch-size smallest hashes, as in Mash (default)
+  FracMinHash  hashes not greater than 2^64/S with -S/--scaled S, as in
+               sourmash, for which sketch sizes grow with sequence sizes
+
+Sketches of the same parameters can be compared with "seqkit sketch dist".
+
+Output format (tab-delimited):
+  a header line: #seqkit-sketch, k=, size=, scaled= and canonical=
+  one line per sketch: name, number of bases, and space-separated hashes
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		params := sketchParams{
+			k:         getFlagPositiveInt(cmd, "kmer-size"),
+			size:      getFlagPositiveInt(cmd, "sketch-size"),
+			scaled:    uint64(getFlagNonNegativeInt(cmd, "scaled")),
+			canonical: !getFlagBool(cmd, "only-positive-strand"),
+		}
+		if params.k > 32 {
+			checkError(fmt.Errorf("value of flag -k/--kmer-size should be in range of [1, 32]"))
+		}
+		if params.scaled > 0 {
+			params.size = 0
+		}
+		individual := getFlagBool(cmd, "individual")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+		params.write(outfh)
+
+		var n int
+		var sk *sketcher
+		var bases int
+		var record *fastx.Record
+		var fastxReader *fastx.Reader
+		for _, file := range files {
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			if !individual {
+				sk, bases = newSketcher(params), 0
+			}
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if individual {
+					sk = newSketcher(params)
+					sk.addSeq(record.Seq.Seq)
+					sketchEntry{string(record.ID), len(record.Seq.Seq), sk.hashes()}.write(outfh)
+					n++
+					continue
+				}
+				sk.addSeq(record.Seq.Seq)
+				bases += len(record.Seq.Seq)
+			}
+			if !individual {
+				sketchEntry{file, bases, sk.hashes()}.write(outfh)
+				n++
+			}
+		}
+
+		if !quiet {
+			log.Infof("%d sketches computed", n)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sketchCmd)
+
+	sketchCmd.Flags().IntP("kmer-size", "k", 21, "k-mer size (<= 32)")
+	sketchCmd.Flags().IntP("sketch-size", "s", 1000, "number of the minimum k-mer hashes of MinHash sketches")
+	sketchCmd.Flags().IntP("scaled", "S", 0, "scaled factor of FracMinHash sketches, overriding -s/--sketch-size")
+	sketchCmd.Flags().BoolP("only-positive-strand", "P", false, "sketch k-mers of the positive strand instead of canonical k-mers")
+	sketchCmd.Flags().BoolP("individual", "i", false, "sketch every sequence instead of every file")
+}
+
+// sketchParams are parameters of sketches, which should be the same for
+// comparison, except for the sketch size.
+type sketchParams struct {
+	k         int
+	size      int    // 0 for FracMinHash
+	scaled    uint64 // 0 for MinHash
+	canonical bool
+}
+
+const sketchMagic = "#seqkit-sketch"
+
+func (p sketchParams) write(w io.Writer) {
+	fmt.Fprintf(w, "%s\tk=%d\tsize=%d\tscaled=%d\tcanonical=%t\n", sketchMagic, p.k, p.size, p.scaled, p.canonical)
+}
+
+// compatible returns an error if sketches of p and q can't be compared.
+func (p sketchParams) compatible(q sketchParams) error {
+	if p.k != q.k || p.canonical != q.canonical || (p.scaled == 0) != (q.scaled == 0) || (p.scaled > 0 && p.scaled != q.scaled) {
+		return fmt.Errorf("incompatible sketch parameters: k=%d, scaled=%d, canonical=%t vs k=%d, scaled=%d, canonical=%t",
+			p.k, p.scaled, p.canonical, q.k, q.scaled, q.canonical)
+	}
+	return nil
+}
+
+type sketchEntry struct {
+	name   string
+	bases  int
+	hashes []uint64 // sorted
+}
+
+func (e sketchEntry) write(w io.Writer) {
+	var b strings.Builder
+	b.WriteString(e.name)
+	b.WriteByte('\t')
+	b.WriteString(strconv.Itoa(e.bases))
+	b.WriteByte('\t')
+	for i, h := range e.hashes {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatUint(h, 10))
+	}
+	b.WriteByte('\n')
+	io.WriteString(w, b.String())
+}
+
+// readSketches reads a sketch file outputted by "seqkit sketch".
+func readSketches(file string) (sketchParams, []sketchEntry, error) {
+	var p sketchParams
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return p, nil, err
+	}
+	defer fh.Close()
+
+	var entries []sketchEntry
+	var items []string
+	var header bool
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 0, 1<<20), 1<<30)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" {
+			continue
+		}
+		items = strings.Split(line, "\t")
+		if !header {
+			if items[0] != sketchMagic {
+				return p, nil, fmt.Errorf("invalid sketch file: %s", file)
+			}
+			for _, item := range items[1:] {
+				kv := strings.SplitN(item, "=", 2)
+				if len(kv) != 2 {
+					return p, nil, fmt.Errorf("invalid sketch header: %s", line)
+				}
+				switch kv[0] {
+				case "k":
+					p.k, err = strconv.Atoi(kv[1])
+				case "size":
+					p.size, err = strconv.Atoi(kv[1])
+				case "scaled":
+					p.scaled, err = strconv.ParseUint(kv[1], 10, 64)
+				case "canonical":
+					p.canonical, err = strconv.ParseBool(kv[1])
+				}
+				if err != nil {
+					return p, nil, fmt.Errorf("invalid sketch header: %s", line)
+				}
+			}
+			header = true
+			continue
+		}
+		if len(items) != 3 {
+			return p, nil, fmt.Errorf("invalid sketch line in %s: %s", file, items[0])
+		}
+		e := sketchEntry{name: items[0]}
+		if e.bases, err = strconv.Atoi(items[1]); err != nil {
+			return p, nil, fmt.Errorf("invalid sketch line in %s: %s", file, items[0])
+		}
+		fields := strings.Fields(items[2])
+		e.hashes = make([]uint64, len(fields))
+		for i, f := range fields {
+			if e.hashes[i], err = strconv.ParseUint(f, 10, 64); err != nil {
+				return p, nil, fmt.Errorf("invalid hash in %s: %s", file, f)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return p, nil, err
+	}
+	if !header {
+		return p, nil, fmt.Errorf("invalid sketch file: %s", file)
+	}
+	return p, entries, nil
+}
+
+// sketcher keeps the bottom-s hashes, or hashes below a threshold for
+// FracMinHash, of k-mers added in a stream.
+type sketcher struct {
+	params  sketchParams
+	maxHash uint64
+	heap    uint64MaxHeap
+	set     map[uint64]struct{}
+}
+
+func newSketcher(p sketchParams) *sketcher {
+	s := &sketcher{params: p, set: make(map[uint64]struct{})}
+	if p.scaled > 0 {
+		s.maxHash = math.MaxUint64 / p.scaled
+	}
+	return s
+}
+
+func (s *sketcher) addSeq(sequence []byte) {
+	ForEachKmer(sequence, s.params.k, s.params.canonical, func(code uint64, pos int) {
+		s.add(hash64(code))
+	})
+}
+
+func (s *sketcher) add(h uint64) {
+	if s.params.scaled > 0 {
+		if h <= s.maxHash {
+			s.set[h] = struct{}{}
+		}
+		return
+	}
+	if _, ok := s.set[h]; ok {
+		return
+	}
+	if len(s.heap) < s.params.size {
+		heap.Push(&s.heap, h)
+		s.set[h] = struct{}{}
+	} else if h < s.heap[0] {
+		delete(s.set, s.heap[0])
+		s.heap[0] = h
+		heap.Fix(&s.heap, 0)
+		s.set[h] = struct{}{}
+	}
+}
+
+// hashes returns the sorted hashes.
+func (s *sketcher) hashes() []uint64 {
+	hashes := make([]uint64, 0, len(s.set))
+	for h := range s.set {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes
+}
+
+type uint64MaxHeap []uint64
+
+func (h uint64MaxHeap) Len() int            { return len(h) }
+func (h uint64MaxHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h uint64MaxHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *uint64MaxHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+func (h *uint64MaxHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[0 : n-1]
+	return x
+}
@@ -0,0 +1,123 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// sketchDistCmd represents the sketch dist command
+var sketchDistCmd = &cobra.Command{
+	Use:   "dist",
+	Short: "estimate pairwise distances between sketches",
+	Long: `estimate pairwise distances between sketches
+
+Sketches in the sketch files are compared pairwise, or against sketches
+in -r/--ref-file if given. For MinHash sketches, the Jaccard index is
+estimated from the bottom-s hashes of the union of two sketches, where s
+is the smaller sketch size. For FracMinHash sketches, all hashes are used.
+Mash distances and ANI are computed as:
+
+  distance = -ln(2J/(1+J))/k,  ANI = 1 - distance
+
+Output columns (tab-delimited):
+  query, ref, jaccard, shared (shared/compared hashes), distance, ANI
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		outFile := config.OutFile
+		runtime.GOMAXPROCS(config.Threads)
+
+		refFile := getFlagString(cmd, "ref-file")
+		maxDist := getFlagFloat64(cmd, "max-dist")
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		var params sketchParams
+		var queries []sketchEntry
+		for i, file := range files {
+			p, entries, err := readSketches(file)
+			checkError(err)
+			if i == 0 {
+				params = p
+			} else {
+				checkError(params.compatible(p))
+				if p.size < params.size {
+					params.size = p.size
+				}
+			}
+			queries = append(queries, entries...)
+		}
+
+		refs := queries
+		if refFile != "" {
+			p, entries, err := readSketches(refFile)
+			checkError(err)
+			checkError(params.compatible(p))
+			if p.size < params.size {
+				params.size = p.size
+			}
+			refs = entries
+		}
+
+		outfh, err := xopen.Wopen(outFile)
+		checkError(err)
+		defer outfh.Close()
+		outfh.WriteString("query\tref\tjaccard\tshared\tdistance\tANI\n")
+
+		var shared, n, size int
+		var jaccard, ani float64
+		for i, q := range queries {
+			for j, r := range refs {
+				if refFile == "" && j <= i {
+					continue
+				}
+				size = params.size
+				if params.scaled > 0 {
+					size = len(q.hashes) + len(r.hashes)
+				}
+				shared, n = sketchShared(q.hashes, r.hashes, size)
+				jaccard = 0
+				if n > 0 {
+					jaccard = float64(shared) / float64(n)
+				}
+				ani = mashANI(jaccard, params.k)
+				if 1-ani > maxDist {
+					continue
+				}
+				outfh.WriteString(fmt.Sprintf("%s\t%s\t%.6f\t%d/%d\t%.6f\t%.6f\n",
+					q.name, r.name, jaccard, shared, n, 1-ani, ani))
+			}
+		}
+	},
+}
+
+func init() {
+	sketchCmd.AddCommand(sketchDistCmd)
+
+	sketchDistCmd.Flags().StringP("ref-file", "r", "", "compare sketches against sketches in this file instead of pairwise")
+	sketchDistCmd.Flags().Float64P("max-dist", "d", 1, "only output pairs with distances not greater than this value")
+}
@@ -23,6 +23,7 @@ package cmd
 import (
 	"fmt"
 	"io"
+	"math"
 	"runtime"
 
 	"github.com/shenwei356/bio/seq"
@@ -37,6 +38,16 @@ var slidingCmd = &cobra.Command{
 	Short: "sliding sequences, circular genome supported",
 	Long: `sliding sequences, circular genome supported
 
+With flag -S/--stats, statistics of every window are outputted in a
+tab-delimited table instead of sequences, which can be used as
+genome-scan tracks:
+
+  GC        GC content (%)
+  entropy   Shannon entropy (bits) of A, C, G, T/U bases
+  masked    percentage of soft-masked (lower case) and N bases
+
+Base counts are updated incrementally between overlapping windows.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -51,6 +62,7 @@ var slidingCmd = &cobra.Command{
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
 		greedy := getFlagBool(cmd, "greedy")
+		stats := getFlagBool(cmd, "stats")
 		circular := getFlagBool(cmd, "circular-genome") || getFlagBool(cmd, "circular")
 		step := getFlagInt(cmd, "step")
 		window := getFlagInt(cmd, "window")
@@ -68,6 +80,11 @@ var slidingCmd = &cobra.Command{
 		checkError(err)
 		defer outfh.Close()
 
+		if stats {
+			outfh.WriteString("seqID\tstart\tend\tlength\tGC\tentropy\tmasked\n")
+		}
+		var counter windowCounter
+
 		var sequence, s, qual, q []byte
 		var r *fastx.Record
 		var originalLen, l, end, e int
@@ -98,6 +115,7 @@ var slidingCmd = &cobra.Command{
 				if end < 0 {
 					end = 0
 				}
+				counter.reset()
 				for i := 0; i <= end; i += step {
 					e = i + window
 					if e > originalLen {
@@ -125,6 +143,16 @@ var slidingCmd = &cobra.Command{
 						}
 					}
 
+					if stats {
+						if circular {
+							counter.slide(sequence, i, i+window)
+						} else {
+							counter.slide(sequence, i, i+len(s))
+						}
+						outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\t%s\n", record.ID, i+1, e, counter.format()))
+						continue
+					}
+
 					if len(qual) > 0 {
 						r, _ = fastx.NewRecordWithQualWithoutValidation(record.Seq.Alphabet,
 							[]byte{}, []byte(fmt.Sprintf("%s_sliding:%d-%d", record.ID, i+1, e)), []byte{}, s, q)
@@ -149,4 +177,72 @@ func init() {
 	slidingCmd.Flags().BoolP("greedy", "g", false, "greedy mode, i.e., exporting last subsequences even shorter than windows size")
 	slidingCmd.Flags().BoolP("circular-genome", "C", false, "circular genome (same to -c/--circular)")
 	slidingCmd.Flags().BoolP("circular", "c", false, "circular genome (same to -C/--circular-genome)")
+	slidingCmd.Flags().BoolP("stats", "S", false, "output statistics (GC content, entropy and masked bases) of windows in tab-delimited format instead of sequences")
+}
+
+// windowCounter counts bases of a window, which could be moved forward
+// by only counting the bases leaving and entering it.
+type windowCounter struct {
+	counts     [256]int
+	start, end int // virtual positions, which could exceed the sequence length for circular genomes
+}
+
+func (c *windowCounter) reset() {
+	c.counts = [256]int{}
+	c.start, c.end = 0, 0
+}
+
+// slide moves the window to [start, end), positions >= len(sequence)
+// wrap around to the beginning of the sequence.
+func (c *windowCounter) slide(sequence []byte, start, end int) {
+	l := len(sequence)
+	var i int
+	if start < c.end && start >= c.start && end >= c.end { // overlapped
+		for i = c.start; i < start; i++ {
+			c.counts[sequence[i%l]]--
+		}
+		for i = c.end; i < end; i++ {
+			c.counts[sequence[i%l]]++
+		}
+	} else {
+		c.counts = [256]int{}
+		for i = start; i < end; i++ {
+			c.counts[sequence[i%l]]++
+		}
+	}
+	c.start, c.end = start, end
+}
+
+// format returns length, GC content, entropy and percentage of masked bases.
+func (c *windowCounter) format() string {
+	n := c.end - c.start
+	if n == 0 {
+		return "0\t0.00\t0.0000\t0.00"
+	}
+	gc := c.counts['G'] + c.counts['g'] + c.counts['C'] + c.counts['c']
+
+	var acgt int
+	var bases [4]int
+	for i, b := range []byte("ACGT") {
+		bases[i] = c.counts[b] + c.counts[b+32]
+		if b == 'T' {
+			bases[i] += c.counts['U'] + c.counts['u']
+		}
+		acgt += bases[i]
+	}
+	var entropy, p float64
+	for _, m := range bases {
+		if m > 0 {
+			p = float64(m) / float64(acgt)
+			entropy -= p * math.Log2(p)
+		}
+	}
+
+	masked := c.counts['N']
+	for b := 'a'; b <= 'z'; b++ {
+		masked += c.counts[b]
+	}
+
+	return fmt.Sprintf("%d\t%.2f\t%.4f\t%.2f", n,
+		float64(gc)/float64(n)*100, entropy, float64(masked)/float64(n)*100)
 }
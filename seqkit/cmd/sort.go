@@ -22,14 +22,20 @@ package cmd
 
 import (
 	"bytes"
+	"container/heap"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/dustin/go-humanize"
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fai"
 	"github.com/shenwei356/bio/seqio/fastx"
@@ -55,6 +61,15 @@ seqkit will write the sequences to tempory files, and create FASTA index.
 Secondly, seqkit sorts sequence by head and length information
 and extracts sequences by FASTA index.
 
+Sorting by a number in the head (-e/--num-regexp), e.g., cluster sizes
+with "size=(\d+)", places sequences without the number at the end.
+
+For files larger than RAM, FASTQ included, use -x (--external) for an
+external merge sort: chunks of --chunk-bases bases are sorted in memory
+and saved to temporary files in --tmp-dir, which are merged in the end.
+Records with equal keys keep their input order, and duplicated IDs are
+allowed with -x or -e.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -83,6 +98,10 @@ and extracts sequences by FASTA index.
 			checkError(fmt.Errorf("flag -k (--keep-temp) must be used with flag -2 (--two-pass)"))
 		}
 
+		numRegexp := getFlagString(cmd, "num-regexp")
+		byNum := numRegexp != ""
+		external := getFlagBool(cmd, "external")
+
 		n := 0
 		if bySeq {
 			n++
@@ -93,8 +112,107 @@ and extracts sequences by FASTA index.
 		if byLength {
 			n++
 		}
+		if byNum {
+			n++
+		}
 		if n > 1 {
-			checkError(fmt.Errorf("only one of the flags -l (--by-length), -n (--by-name) and -s (--by-seq) is allowed"))
+			checkError(fmt.Errorf("only one of the flags -l (--by-length), -n (--by-name), -s (--by-seq) and -e (--num-regexp) is allowed"))
+		}
+
+		if byNum || external {
+			if twoPass {
+				checkError(fmt.Errorf("flag -2 (--two-pass) is not allowed along with -e (--num-regexp) or -x (--external)"))
+			}
+
+			var numRe *regexp.Regexp
+			if byNum {
+				var err error
+				numRe, err = regexp.Compile(numRegexp)
+				checkError(err)
+				if numRe.NumSubexp() < 1 {
+					checkError(fmt.Errorf("the regular expression of -e (--num-regexp) needs a capture group: %s", numRegexp))
+				}
+			}
+
+			chunkBases := uint64(math.MaxUint64)
+			if external {
+				chunkBasesStr := getFlagString(cmd, "chunk-bases")
+				var err error
+				chunkBases, err = humanize.ParseBytes(chunkBasesStr)
+				if err != nil || chunkBases == 0 {
+					checkError(fmt.Errorf("invalid value of --chunk-bases: %s", chunkBasesStr))
+				}
+			}
+
+			var nUnparsed int
+			key := func(record *fastx.Record) sortKey {
+				var k sortKey
+				switch {
+				case byLength:
+					k.num = float64(len(record.Seq.Seq))
+				case byNum:
+					k.num = math.Inf(1)
+					if m := numRe.FindSubmatch(record.Name); m != nil {
+						if v, err := strconv.ParseFloat(string(m[1]), 64); err == nil {
+							k.num = v
+						}
+					}
+				case bySeq:
+					k.str = string(record.Seq.Seq)
+				case byName:
+					k.str = string(record.Name)
+				default:
+					k.str = string(record.ID)
+				}
+				if ignoreCase && k.str != "" {
+					k.str = strings.ToLower(k.str)
+				}
+				return k
+			}
+			stringutil.NaturalOrder = inNaturalOrder
+			less := func(a, b sortKey) bool {
+				// records without a parsed number are kept at the end, also with -r
+				if byNum {
+					ua, ub := math.IsInf(a.num, 1), math.IsInf(b.num, 1)
+					if ua || ub {
+						return !ua && ub
+					}
+				}
+				if reverse {
+					a, b = b, a
+				}
+				switch {
+				case byLength || byNum:
+					return a.num < b.num
+				case bySeq:
+					return a.str < b.str
+				}
+				// the same comparison as sorting in memory, natural order with -N
+				return stringutil.String2ByteSliceList{{Key: a.str}, {Key: b.str}}.Less(0, 1)
+			}
+
+			outfh, err := xopen.Wopen(outFile)
+			checkError(err)
+			defer outfh.Close()
+
+			n := mergeSortRecords(files, alphabet, idRegexp, key, less, chunkBases,
+				getFlagString(cmd, "tmp-dir"), quiet, func(record *fastx.Record, k sortKey, isFastq bool) {
+					if byNum && math.IsInf(k.num, 1) {
+						nUnparsed++
+					}
+					if isFastq {
+						config.LineWidth = 0
+						fastx.ForcelyOutputFastq = true
+					}
+					record.FormatToWriter(outfh, config.LineWidth)
+				})
+			if byNum && nUnparsed > 0 && !quiet {
+				log.Warningf("no number parsed from the headers of %d sequences, they are placed at the end", nUnparsed)
+			}
+			if !quiet {
+				log.Infof("%d sequences sorted", n)
+			}
+			return
 		}
 
 		byID := true
@@ -442,4 +560,162 @@ func init() {
 	sortCmd.Flags().BoolP("two-pass", "2", false, "two-pass mode read files twice to lower memory usage. (only for FASTA format)")
 	sortCmd.Flags().BoolP("keep-temp", "k", false, "keep tempory FASTA and .fai file when using 2-pass mode")
 	sortCmd.Flags().IntP("seq-prefix-length", "L", 10000, "length of sequence prefix on which seqkit sorts by sequences (0 for whole sequence)")
+
+	sortCmd.Flags().StringP("num-regexp", "e", "", `by a number captured from the full head by this regular expression, e.g., "size=(\d+)"`)
+	sortCmd.Flags().BoolP("external", "x", false, "external merge sort, sorting chunks of --chunk-bases in memory and merging them from temporary files, for files larger than RAM")
+	sortCmd.Flags().StringP("chunk-bases", "", "1G", "maximum bases of a chunk sorted in memory in external mode")
+	sortCmd.Flags().StringP("tmp-dir", "", os.TempDir(), "directory for temporary files of external mode")
+}
+
+// sortKey is the key of a record in mergeSortRecords.
+type sortKey struct {
+	str string  // ID, full name or sequence
+	num float64 // length or number parsed from the head
+}
+
+type sortedRecord struct {
+	key    sortKey
+	record *fastx.Record
+	chunk  int
+}
+
+// mergeSortRecords sorts records of all files in chunks of at most
+// chunkBases bases, chunks are saved in temporary files and merged if
+// there are more than one. Records of equal keys keep their input order.
+// It calls fn for sorted records and returns the number of records.
+func mergeSortRecords(files []string, alphabet *seq.Alphabet, idRegexp string,
+	key func(*fastx.Record) sortKey, less func(a, b sortKey) bool,
+	chunkBases uint64, tmpDir string, quiet bool,
+	fn func(record *fastx.Record, key sortKey, isFastq bool)) int {
+
+	var isFastq bool
+	var buf []*sortedRecord
+	var bufBases uint64
+	var dir string
+	var chunks []string
+	defer func() {
+		if dir == "" {
+			return
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warningf("fail to remove temporary directory: %s", dir)
+		}
+	}()
+
+	sortBuf := func() {
+		sort.SliceStable(buf, func(i, j int) bool { return less(buf[i].key, buf[j].key) })
+	}
+	spill := func() {
+		var err error
+		if dir == "" {
+			dir, err = ioutil.TempDir(tmpDir, "seqkit-sort")
+			checkError(err)
+		}
+		sortBuf()
+		file := filepath.Join(dir, fmt.Sprintf("chunk_%d.fx", len(chunks)))
+		outfh, err := xopen.Wopen(file)
+		checkError(err)
+		for _, r := range buf {
+			r.record.FormatToWriter(outfh, 0)
+		}
+		checkError(outfh.Close())
+		if !quiet {
+			log.Infof("%d sequences sorted and saved to temporary file: %s", len(buf), file)
+		}
+		chunks = append(chunks, file)
+		buf = buf[:0]
+		bufBases = 0
+	}
+
+	var n int
+	for _, file := range files {
+		fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+		checkError(err)
+		for {
+			record, err := fastxReader.Read()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				checkError(err)
+				break
+			}
+			if fastxReader.IsFastq {
+				isFastq = true
+				fastx.ForcelyOutputFastq = true
+			}
+			n++
+
+			buf = append(buf, &sortedRecord{key: key(record), record: record.Clone()})
+			bufBases += uint64(len(record.Seq.Seq))
+			if bufBases >= chunkBases {
+				spill()
+			}
+		}
+	}
+
+	if len(chunks) == 0 { // all in memory
+		sortBuf()
+		for _, r := range buf {
+			fn(r.record, r.key, isFastq)
+		}
+		return n
+	}
+	if len(buf) > 0 {
+		spill()
+	}
+
+	if !quiet {
+		log.Infof("merge %d temporary files ...", len(chunks))
+	}
+	readers := make([]*fastx.Reader, len(chunks))
+	h := &sortedRecords{less: less}
+	next := func(i int) {
+		record, err := readers[i].Read()
+		if err != nil {
+			if err == io.EOF {
+				return
+			}
+			checkError(err)
+		}
+		heap.Push(h, &sortedRecord{key: key(record), record: record, chunk: i})
+	}
+	for i, file := range chunks {
+		var err error
+		readers[i], err = fastx.NewReader(alphabet, file, idRegexp)
+		checkError(err)
+		next(i)
+	}
+	for h.Len() > 0 {
+		r := heap.Pop(h).(*sortedRecord)
+		fn(r.record, r.key, isFastq)
+		next(r.chunk)
+	}
+	return n
+}
+
+// sortedRecords is a min-heap of the heads of sorted chunks.
+type sortedRecords struct {
+	records []*sortedRecord
+	less    func(a, b sortKey) bool
+}
+
+func (h sortedRecords) Len() int { return len(h.records) }
+func (h sortedRecords) Less(i, j int) bool {
+	a, b := h.records[i], h.records[j]
+	if h.less(a.key, b.key) {
+		return true
+	}
+	if h.less(b.key, a.key) {
+		return false
+	}
+	return a.chunk < b.chunk
+}
+func (h sortedRecords) Swap(i, j int)       { h.records[i], h.records[j] = h.records[j], h.records[i] }
+func (h *sortedRecords) Push(x interface{}) { h.records = append(h.records, x.(*sortedRecord)) }
+func (h *sortedRecords) Pop() interface{} {
+	n := len(h.records)
+	x := h.records[n-1]
+	h.records = h.records[:n-1]
+	return x
 }
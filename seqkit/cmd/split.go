@@ -27,10 +27,12 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/dustin/go-humanize"
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fai"
 	"github.com/shenwei356/bio/seqio/fastx"
@@ -42,9 +44,15 @@ import (
 // splitCmd represents the split command
 var splitCmd = &cobra.Command{
 	Use:   "split",
-	Short: "split sequences into files by id/seq region/size/parts (mainly for FASTA)",
+	Short: "split sequences into files by id/seq region/size/parts/bases (mainly for FASTA)",
 	Long: fmt.Sprintf(`split sequences into files by name ID, subsequence of given region,
-part size or number of parts.
+part size, number of parts or number of bases.
+
+Splitting by bases (-b/--by-bases) and by sequences (--by-chrom) stream the
+input, and the latter names files after the sequence IDs, e.g., chr1.fasta,
+which is handy for assemblies. Use -z/--gzip for gzipped outputs, and
+-m/--manifest to list output files, numbers of sequences and bases in
+$outdir/manifest.tsv.
 
 If you just want to split by parts or sizes, please use "seqkit split2",
 which also apply for paired- and single-end FASTQ.
@@ -80,6 +88,18 @@ Examples:
 
 		byID := getFlagBool(cmd, "by-id")
 		region := getFlagString(cmd, "by-region")
+		byChrom := getFlagBool(cmd, "by-chrom")
+		basesStr := getFlagString(cmd, "by-bases")
+		var bases uint64
+		if basesStr != "" {
+			var err error
+			bases, err = humanize.ParseBytes(basesStr)
+			if err != nil || bases == 0 {
+				checkError(fmt.Errorf("invalid value of -b (--by-bases): %s", basesStr))
+			}
+		}
+		gzipped := getFlagBool(cmd, "gzip")
+		writeManifest := getFlagBool(cmd, "manifest")
 		twoPass := getFlagBool(cmd, "two-pass")
 		keepTemp := getFlagBool(cmd, "keep-temp")
 		if keepTemp && !twoPass {
@@ -106,6 +126,14 @@ Examples:
 			}
 		}
 
+		// extension of output files
+		ext := func() string {
+			if gzipped && !strings.HasSuffix(fileExt, ".gz") {
+				return fileExt + ".gz"
+			}
+			return fileExt
+		}
+
 		renameFileExt := true
 		var outfile string
 		var record *fastx.Record
@@ -133,9 +161,136 @@ Examples:
 			}
 		}
 
+		var manifest *splitManifest
+		if writeManifest && !dryRun {
+			manifest = &splitManifest{}
+			defer manifest.write(filepath.Join(outdir, "manifest.tsv"), quiet)
+		}
+
 		var outfh *xopen.Writer
 		var err error
 
+		if bases > 0 {
+			if !quiet {
+				log.Infof("split into files with at most %d bases", bases)
+			}
+
+			i, n, nb := 0, 0, uint64(0)
+			newPart := func() {
+				if outfh != nil {
+					checkError(outfh.Close())
+				}
+				if n > 0 {
+					if !quiet {
+						log.Infof("write %d sequences to file: %s\n", n, outfile)
+					}
+					manifest.add(outfile, n, int(nb))
+				}
+				i++
+				n, nb = 0, 0
+				outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, ext()))
+				if !dryRun {
+					outfh, err = xopen.Wopen(outfile)
+					checkError(err)
+				}
+			}
+
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+				if renameFileExt && isstdin {
+					if fastxReader.IsFastq {
+						fileExt = suffixFQ
+					} else {
+						fileExt = suffixFA
+					}
+					renameFileExt = false
+				}
+
+				// a sequence longer than the budget makes a file by itself
+				if i == 0 || (n > 0 && nb+uint64(len(record.Seq.Seq)) > bases) {
+					newPart()
+				}
+				if !dryRun {
+					record.FormatToWriter(outfh, config.LineWidth)
+				}
+				n++
+				nb += uint64(len(record.Seq.Seq))
+			}
+			if n > 0 {
+				if !quiet {
+					log.Infof("write %d sequences to file: %s\n", n, outfile)
+				}
+				manifest.add(outfile, n, int(nb))
+			}
+			if outfh != nil {
+				checkError(outfh.Close())
+			}
+			return
+		}
+
+		if byChrom {
+			if !quiet {
+				log.Info("split into one file per sequence, named after the ID")
+			}
+
+			written := make(map[string]struct{})
+			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if fastxReader.IsFastq {
+					config.LineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+				if renameFileExt && isstdin {
+					if fastxReader.IsFastq {
+						fileExt = suffixFQ
+					} else {
+						fileExt = suffixFA
+					}
+					renameFileExt = false
+				}
+
+				outfile = filepath.Join(outdir, pathutil.RemoveInvalidPathChars(string(record.ID), "__")+ext())
+				if _, ok := written[outfile]; ok {
+					checkError(fmt.Errorf(`duplicated sequence ID: %s, please use "-i/--by-id" to gather sequences of the same ID`, record.ID))
+				}
+				written[outfile] = struct{}{}
+
+				if !dryRun {
+					outfh, err = xopen.Wopen(outfile)
+					checkError(err)
+					record.FormatToWriter(outfh, config.LineWidth)
+					checkError(outfh.Close())
+				}
+				manifest.add(outfile, 1, len(record.Seq.Seq))
+			}
+			if !quiet {
+				log.Infof("%d sequences written to separate files in: %s", len(written), outdir)
+			}
+			return
+		}
+
 		if size > 0 {
 			if !twoPass {
 				if !quiet {
@@ -171,15 +326,15 @@ Examples:
 					}
 					records = append(records, record.Clone())
 					if len(records) == size {
-						outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, fileExt))
-						writeSeqs(records, outfile, config.LineWidth, quiet, dryRun)
+						outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, ext()))
+						writeSplitSeqs(records, outfile, config.LineWidth, quiet, dryRun, manifest)
 						i++
 						records = []*fastx.Record{}
 					}
 				}
 				if len(records) > 0 {
-					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, fileExt))
-					writeSeqs(records, outfile, config.LineWidth, quiet, dryRun)
+					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, ext()))
+					writeSplitSeqs(records, outfile, config.LineWidth, quiet, dryRun, manifest)
 				}
 
 				return
@@ -244,13 +399,13 @@ Examples:
 
 			n := 1
 			if len(IDs) > 0 {
-				outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, fileExt))
+				outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, ext()))
 				if !dryRun {
 					outfh, err = xopen.Wopen(outfile)
 					checkError(err)
 				}
 			}
-			j := 0
+			j, nb := 0, 0
 			var record *fastx.Record
 			for _, chr := range IDs {
 				if !dryRun {
@@ -264,14 +419,17 @@ Examples:
 					checkError(err)
 
 					record.FormatToWriter(outfh, config.LineWidth)
+					nb += len(sequence)
 				}
 				j++
 				if j == size {
 					if !quiet {
 						log.Infof("write %d sequences to file: %s\n", j, outfile)
 					}
+					manifest.add(outfile, j, nb)
+					nb = 0
 					n++
-					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, fileExt))
+					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, ext()))
 					if !dryRun {
 						outfh.Close()
 						outfh, err = xopen.Wopen(outfile)
@@ -280,8 +438,11 @@ Examples:
 					j = 0
 				}
 			}
-			if j > 0 && !quiet {
-				log.Infof("write %d sequences to file: %s\n", j, outfile)
+			if j > 0 {
+				if !quiet {
+					log.Infof("write %d sequences to file: %s\n", j, outfile)
+				}
+				manifest.add(outfile, j, nb)
 			}
 			if !dryRun {
 				outfh.Close()
@@ -338,15 +499,15 @@ Examples:
 					}
 					records = append(records, record)
 					if len(records) == size {
-						outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, fileExt))
-						writeSeqs(records, outfile, config.LineWidth, quiet, dryRun)
+						outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, ext()))
+						writeSplitSeqs(records, outfile, config.LineWidth, quiet, dryRun, manifest)
 						i++
 						records = []*fastx.Record{}
 					}
 				}
 				if len(records) > 0 {
-					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, fileExt))
-					writeSeqs(records, outfile, config.LineWidth, quiet, dryRun)
+					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i, ext()))
+					writeSplitSeqs(records, outfile, config.LineWidth, quiet, dryRun, manifest)
 				}
 				return
 			}
@@ -422,13 +583,13 @@ Examples:
 
 			n := 1
 			if len(IDs) > 0 {
-				outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, fileExt))
+				outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, ext()))
 				if !dryRun {
 					outfh, err = xopen.Wopen(outfile)
 					checkError(err)
 				}
 			}
-			j := 0
+			j, nb := 0, 0
 			var record *fastx.Record
 			for _, chr := range IDs {
 				if !dryRun {
@@ -442,14 +603,17 @@ Examples:
 					checkError(err)
 
 					record.FormatToWriter(outfh, config.LineWidth)
+					nb += len(sequence)
 				}
 				j++
 				if j == size {
 					if !quiet {
 						log.Infof("write %d sequences to file: %s\n", j, outfile)
 					}
+					manifest.add(outfile, j, nb)
+					nb = 0
 					n++
-					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, fileExt))
+					outfile = filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), n, ext()))
 					if !dryRun {
 						outfh.Close()
 						outfh, err = xopen.Wopen(outfile)
@@ -458,8 +622,11 @@ Examples:
 					j = 0
 				}
 			}
-			if j > 0 && !quiet {
-				log.Infof("write %d sequences to file: %s\n", j, outfile)
+			if j > 0 {
+				if !quiet {
+					log.Infof("write %d sequences to file: %s\n", j, outfile)
+				}
+				manifest.add(outfile, j, nb)
 			}
 			if !dryRun {
 				outfh.Close()
@@ -514,8 +681,8 @@ Examples:
 				for id, records := range recordsByID {
 					outfile = filepath.Join(outdir, fmt.Sprintf("%s.id_%s%s",
 						filepath.Base(fileName),
-						pathutil.RemoveInvalidPathChars(id, "__"), fileExt))
-					writeSeqs(records, outfile, config.LineWidth, quiet, dryRun)
+						pathutil.RemoveInvalidPathChars(id, "__"), ext()))
+					writeSplitSeqs(records, outfile, config.LineWidth, quiet, dryRun, manifest)
 				}
 				return
 			}
@@ -608,11 +775,12 @@ Examples:
 					var err error
 					outfile := filepath.Join(outdir, fmt.Sprintf("%s.id_%s%s",
 						filepath.Base(fileName),
-						pathutil.RemoveInvalidPathChars(id, "__"), fileExt))
+						pathutil.RemoveInvalidPathChars(id, "__"), ext()))
 
 					if !dryRun {
 						outfh, err = xopen.Wopen(outfile)
 						checkError(err)
+						var nb int
 						for _, chr := range _IDs {
 							r, ok := faidx.Index[chr]
 							if !ok {
@@ -624,7 +792,9 @@ Examples:
 							checkError(err)
 
 							record.FormatToWriter(outfh, config.LineWidth)
+							nb += len(sequence)
 						}
+						manifest.add(outfile, len(_IDs), nb)
 					}
 
 					if !quiet {
@@ -705,8 +875,8 @@ Examples:
 
 				var outfile string
 				for subseq, records := range recordsBySeqs {
-					outfile = filepath.Join(outdir, fmt.Sprintf("%s.region_%d:%d_%s%s", filepath.Base(fileName), start, end, subseq, fileExt))
-					writeSeqs(records, outfile, config.LineWidth, quiet, dryRun)
+					outfile = filepath.Join(outdir, fmt.Sprintf("%s.region_%d:%d_%s%s", filepath.Base(fileName), start, end, subseq, ext()))
+					writeSplitSeqs(records, outfile, config.LineWidth, quiet, dryRun, manifest)
 				}
 				return
 			}
@@ -808,12 +978,13 @@ Examples:
 					var outfh *xopen.Writer
 					var err error
 
-					outfile := filepath.Join(outdir, fmt.Sprintf("%s.region_%d:%d_%s%s", filepath.Base(fileName), start, end, subseq, fileExt))
+					outfile := filepath.Join(outdir, fmt.Sprintf("%s.region_%d:%d_%s%s", filepath.Base(fileName), start, end, subseq, ext()))
 
 					if !dryRun {
 						outfh, err = xopen.Wopen(outfile)
 						checkError(err)
 
+						var nb int
 						for _, chr := range chrs {
 							r, ok := faidx.Index[chr]
 							if !ok {
@@ -825,7 +996,9 @@ Examples:
 							checkError(err)
 
 							record.FormatToWriter(outfh, config.LineWidth)
+							nb += len(sequence)
 						}
+						manifest.add(outfile, len(chrs), nb)
 					}
 					if !quiet {
 						log.Infof("write %d sequences to file: %s\n", len(chrs), outfile)
@@ -840,7 +1013,7 @@ Examples:
 			return
 		}
 
-		checkError(fmt.Errorf(`one of flags should be given: -s/-p/-i/-r. type "seqkit split -h" for help`))
+		checkError(fmt.Errorf(`one of flags should be given: -s/-p/-i/-r/-b/--by-chrom. type "seqkit split -h" for help`))
 	},
 }
 
@@ -852,6 +1025,10 @@ func init() {
 	splitCmd.Flags().BoolP("by-id", "i", false, "split squences according to sequence ID")
 	splitCmd.Flags().StringP("by-region", "r", "", "split squences according to subsequence of given region. "+
 		`e.g 1:12 for first 12 bases, -12:-1 for last 12 bases. type "seqkit split -h" for more examples`)
+	splitCmd.Flags().StringP("by-bases", "b", "", "split sequences into multi parts with at most this number of bases, with units K, M, G and T supported, e.g., 2G")
+	splitCmd.Flags().BoolP("by-chrom", "", false, "split sequences into one file per sequence (e.g., chromosomes of an assembly), named after the sequence ID")
+	splitCmd.Flags().BoolP("gzip", "z", false, "compress output files with gzip")
+	splitCmd.Flags().BoolP("manifest", "m", false, `write a tab-delimited manifest of output files, numbers of sequences and bases to "manifest.tsv" in the output directory`)
 	splitCmd.Flags().BoolP("two-pass", "2", false, "two-pass mode read files twice to lower memory usage. (only for FASTA format)")
 	splitCmd.Flags().BoolP("dry-run", "d", false, "dry run, just print message and no files will be created.")
 	splitCmd.Flags().BoolP("keep-temp", "k", false, "keep tempory FASTA and .fai file when using 2-pass mode")
@@ -859,5 +1036,51 @@ func init() {
 	splitCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
 }
 
+// splitManifest records output files of split.
+type splitManifest struct {
+	sync.Mutex
+	rows []splitManifestRow
+}
+
+type splitManifestRow struct {
+	file  string
+	seqs  int
+	bases int
+}
+
+// add records an output file, it does nothing for a nil manifest.
+func (m *splitManifest) add(file string, seqs int, bases int) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.rows = append(m.rows, splitManifestRow{file: file, seqs: seqs, bases: bases})
+	m.Unlock()
+}
+
+func (m *splitManifest) write(file string, quiet bool) {
+	sort.Slice(m.rows, func(i, j int) bool { return m.rows[i].file < m.rows[j].file })
+	outfh, err := xopen.Wopen(file)
+	checkError(err)
+	defer outfh.Close()
+
+	outfh.WriteString("file\tseqs\tbases\n")
+	for _, r := range m.rows {
+		outfh.WriteString(fmt.Sprintf("%s\t%d\t%d\n", filepath.Base(r.file), r.seqs, r.bases))
+	}
+	if !quiet {
+		log.Infof("manifest of %d files saved to %s", len(m.rows), file)
+	}
+}
+
+func writeSplitSeqs(records []*fastx.Record, file string, lineWidth int, quiet bool, dryRun bool, manifest *splitManifest) {
+	writeSeqs(records, file, lineWidth, quiet, dryRun)
+	var bases int
+	for _, record := range records {
+		bases += len(record.Seq.Seq)
+	}
+	manifest.add(file, len(records), bases)
+}
+
 var suffixFA = ".fasta"
 var suffixFQ = ".fastq"
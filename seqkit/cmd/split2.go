@@ -27,8 +27,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
-	"sync"
 
+	"github.com/pkg/errors"
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fai"
 	"github.com/shenwei356/bio/seqio/fastx"
@@ -49,6 +49,19 @@ occupation and fast speed.
 The file extensions of output are automatically detected and created
 according to the input files.
 
+Input files ending with ".tar", ".tar.gz" or ".tgz" are read as archives
+of FASTA/Q files. With --tar-out, the parts are written into a (gzipped)
+tar archive instead of the output directory, note that the parts are kept
+in memory until they are complete.
+
+Paired-end reads (-1/--read1 and -2/--read2) are splitted synchronously:
+parts are assigned to read pairs in a deterministic way, round-robin for
+-p/--by-part and by the total length of both mates for -l/--by-length, so
+mates always land in the same part. Mate names are checked.
+
+Names of output files could be customized with --part-template, e.g.,
+"{name}_part{num}.fq.gz", which is handy for scatter/gather in workflows.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) > 1 {
@@ -88,6 +101,21 @@ according to the input files.
 
 		outdir := getFlagString(cmd, "out-dir")
 		force := getFlagBool(cmd, "force")
+		tarOut := getFlagString(cmd, "tar-out")
+		template := getFlagString(cmd, "part-template")
+		if !strings.Contains(template, "{num}") {
+			checkError(fmt.Errorf("placeholder {num} needed in --part-template: %s", template))
+		}
+
+		var tarWriter *TarWriter
+		if tarOut != "" {
+			var err error
+			tarWriter, err = NewTarWriter(tarOut)
+			checkError(err)
+			if outdir == "" { // no directory in the archive
+				outdir = "."
+			}
+		}
 
 		if size == 0 && parts == 0 && length == 0 {
 			checkError(fmt.Errorf(`one of flags should be given: -s/-p. type "seqkit split2 -h" for help`))
@@ -143,11 +171,23 @@ according to the input files.
 			}
 		}
 
-		var wg sync.WaitGroup
-		for _, file := range files {
+		// settings of output files of every input file
+		type split2Input struct {
+			file     string
+			fileName string
+			fileExt  string
+		}
+		inputs := make([]*split2Input, len(files))
+		for k, file := range files {
 			isstdin := isStdin(file)
 			var fileName, fileExt string
-			if isstdin {
+			if isTarFile(file) {
+				fileName, fileExt = filepathTrimExtension(file)
+				fileName = strings.TrimSuffix(fileName, ".tar")
+				if outdir == "" {
+					outdir = file + ".split"
+				}
+			} else if isstdin {
 				fileName, fileExt = "stdin", ".fastx"
 				if outdir == "" {
 					outdir = "stdin.split"
@@ -159,8 +199,13 @@ according to the input files.
 				}
 			}
 
+			if tarWriter != nil {
+				fileExt = strings.TrimSuffix(fileExt, ".gz")
+			}
+			inputs[k] = &split2Input{file: file, fileName: filepath.Base(fileName), fileExt: fileExt}
+
 			pwd, _ := os.Getwd()
-			if outdir != "./" && outdir != "." && pwd != filepath.Clean(outdir) {
+			if tarWriter == nil && outdir != "./" && outdir != "." && pwd != filepath.Clean(outdir) {
 				existed, err := pathutil.DirExists(outdir)
 				checkError(err)
 				if existed {
@@ -178,149 +223,118 @@ according to the input files.
 					checkError(os.MkdirAll(outdir, 0755))
 				}
 			}
+		}
+		if len(inputs) == 2 && partFile(template, inputs[0].fileName, 1, inputs[0].fileExt) ==
+			partFile(template, inputs[1].fileName, 1, inputs[1].fileExt) {
+			checkError(fmt.Errorf("output files of read1 and read2 are the same, please add {name} to --part-template: %s", template))
+		}
+
+		newParts := func(in *split2Input) *split2Parts {
+			return &split2Parts{
+				outdir:     outdir,
+				template:   template,
+				fileName:   in.fileName,
+				fileExt:    in.fileExt,
+				sequential: parts == 0,
+				tarWriter:  tarWriter,
+				quiet:      quiet,
+			}
+		}
 
-			wg.Add(1)
-			go func(file string) {
-				defer wg.Done()
-
-				renameFileExt := true
-				var record *fastx.Record
-				var fastxReader *fastx.Reader
-				var err error
-
-				var outfhs []*xopen.Writer
-				var counts []int
-				var outfiles []string
-
-				if size > 0 || length > 0 { // by size or by length
-					outfhs = make([]*xopen.Writer, 0, 10)
-					counts = make([]int, 0, 10)
-					outfiles = make([]string, 0, 10)
-				} else if parts > 0 { // by part
-					outfhs = make([]*xopen.Writer, 0, parts)
-					counts = make([]int, 0, parts)
-					outfiles = make([]string, 0, parts)
+		// updateExt sets the extension of outputs for stdin and tar files.
+		updateExt := func(in *split2Input, reader FastxRecordReader) {
+			if isStdin(in.file) || isTarFile(in.file) {
+				if readerIsFastq(reader) {
+					in.fileExt = suffixFQ
 				} else {
-					checkError(fmt.Errorf(`one of flags should be given: -s/-p. type "seqkit split2 -h" for help`))
+					in.fileExt = suffixFA
 				}
+			}
+		}
 
-				var outfh *xopen.Writer
-				fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
-				checkError(err)
-				i := 0      // nth part
-				j := 0      // nth record
-				var n int64 // length sum
-				for {
-					record, err = fastxReader.Read()
-					if err != nil {
-						if err == io.EOF {
-							break
-						}
-						checkError(err)
-						break
-					}
-					if fastxReader.IsFastq {
+		if len(inputs) == 2 { // paired-end reads are splitted synchronously
+			reader1, err := NewFastxRecordReader(alphabet, inputs[0].file, idRegexp)
+			checkError(err)
+			reader2, err := NewFastxRecordReader(alphabet, inputs[1].file, idRegexp)
+			checkError(err)
+
+			chooser := &split2Chooser{size: size, parts: parts, length: length}
+			var parts1, parts2 *split2Parts
+			var record1, record2 *fastx.Record
+			var err1, err2 error
+			for {
+				record1, err1 = reader1.Read()
+				record2, err2 = reader2.Read()
+				if err1 == io.EOF && err2 == io.EOF {
+					break
+				}
+				if err1 == io.EOF || err2 == io.EOF {
+					checkError(fmt.Errorf("unequal numbers of reads in %s and %s", inputs[0].file, inputs[1].file))
+				}
+				checkError(errors.Wrap(err1, inputs[0].file))
+				checkError(errors.Wrap(err2, inputs[1].file))
+
+				if parts1 == nil {
+					if readerIsFastq(reader1) {
 						config.LineWidth = 0
 						fastx.ForcelyOutputFastq = true
 					}
-
-					if renameFileExt && isstdin {
-						if len(record.Seq.Qual) > 0 {
-							fileExt = suffixFQ
-						} else {
-							fileExt = suffixFA
-						}
-						renameFileExt = false
-					}
-					n += int64(len(record.Seq.Seq))
-
-					if size > 0 {
-						if j == size {
-							outfhs[i].Close()
-							if !quiet {
-								log.Infof("write %d sequences to file: %s\n", counts[i], outfiles[i])
-							}
-							outfhs[i] = nil
-
-							i++
-							var outfh2 *xopen.Writer
-							outfile := filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i+1, fileExt))
-							outfh2, err = xopen.Wopen(outfile)
-							checkError(err)
-
-							outfhs = append(outfhs, outfh2)
-							counts = append(counts, 0)
-							outfiles = append(outfiles, outfile)
-
-							j = 0
-						}
-					} else if length > 0 {
-						if n >= length {
-							outfhs[i].Close()
-							if !quiet {
-								log.Infof("write %d sequences to file: %s\n", counts[i], outfiles[i])
-							}
-							outfhs[i] = nil
-
-							i++
-							var outfh2 *xopen.Writer
-							outfile := filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i+1, fileExt))
-							outfh2, err = xopen.Wopen(outfile)
-							checkError(err)
-
-							outfhs = append(outfhs, outfh2)
-							counts = append(counts, 0)
-							outfiles = append(outfiles, outfile)
-
-							n = 0
-						}
-					}
-
-					if i+1 > len(outfhs) || outfhs[i] == nil {
-						var outfh2 *xopen.Writer
-						outfile := filepath.Join(outdir, fmt.Sprintf("%s.part_%03d%s", filepath.Base(fileName), i+1, fileExt))
-						outfh2, err = xopen.Wopen(outfile)
-						checkError(err)
-
-						outfhs = append(outfhs, outfh2)
-						counts = append(counts, 0)
-						outfiles = append(outfiles, outfile)
-					}
-					outfh = outfhs[i]
-
-					record.FormatToWriter(outfh, config.LineWidth)
-
-					counts[i]++
-
-					if size > 0 {
-						j++ // increase size
-					} else if parts > 0 {
-						i++
-						if i == parts { // reset index
-							i = 0
-						}
-					} else {
-
-					}
+					updateExt(inputs[0], reader1)
+					updateExt(inputs[1], reader2)
+					parts1, parts2 = newParts(inputs[0]), newParts(inputs[1])
+				}
+				if pairName(record1) != pairName(record2) {
+					checkError(fmt.Errorf("mate names differ: %s (%s) and %s (%s)",
+						record1.ID, inputs[0].file, record2.ID, inputs[1].file))
 				}
 
-				// for by-size/length: only log last part,
-				// for by-parts: log all parts.
-				for i, outfh := range outfhs {
-					if outfh == nil {
-						continue
+				i := chooser.next(len(record1.Seq.Seq) + len(record2.Seq.Seq))
+				parts1.write(i, record1, config.LineWidth)
+				parts2.write(i, record2, config.LineWidth)
+			}
+			if parts1 != nil {
+				parts1.close()
+				parts2.close()
+			}
+		} else {
+			in := inputs[0]
+			fastxReader, err := NewFastxRecordReader(alphabet, in.file, idRegexp)
+			checkError(err)
+
+			chooser := &split2Chooser{size: size, parts: parts, length: length}
+			var _parts *split2Parts
+			var record *fastx.Record
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
 					}
-					outfh.Close()
-
-					if !quiet {
-						log.Infof("write %d sequences to file: %s\n", counts[i], outfiles[i])
+					checkError(err)
+					break
+				}
+				if _parts == nil {
+					if readerIsFastq(fastxReader) {
+						config.LineWidth = 0
+						fastx.ForcelyOutputFastq = true
 					}
+					updateExt(in, fastxReader)
+					_parts = newParts(in)
 				}
 
-			}(file)
+				_parts.write(chooser.next(len(record.Seq.Seq)), record, config.LineWidth)
+			}
+			if _parts != nil {
+				_parts.close()
+			}
 		}
 
-		wg.Wait()
+		if tarWriter != nil {
+			checkError(tarWriter.Close())
+			if !quiet {
+				log.Infof("parts saved to tar archive: %s", tarOut)
+			}
+		}
 	},
 }
 
@@ -334,4 +348,114 @@ func init() {
 	split2Cmd.Flags().StringP("by-length", "l", "", "split sequences into chunks of N bases, supports K/M/G suffix")
 	split2Cmd.Flags().StringP("out-dir", "O", "", "output directory (default value is $infile.split)")
 	split2Cmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+	split2Cmd.Flags().StringP("part-template", "", "{name}.part_{num}{ext}", "template of output file names, with placeholders {name} (input file name without extension), {num} (part number) and {ext} (extension of input file)")
+	split2Cmd.Flags().String("tar-out", "", "write parts into this tar archive (gzipped if ending with .gz) instead of the output directory")
+}
+
+// split2Chooser assigns records, or read pairs, to parts.
+type split2Chooser struct {
+	size   int
+	parts  int
+	length int64
+
+	i int   // nth part
+	j int   // nth record in the part
+	n int64 // length sum
+}
+
+// next returns the part index of a record or a read pair of given length.
+func (c *split2Chooser) next(length int) int {
+	if c.size > 0 {
+		if c.j == c.size {
+			c.i++
+			c.j = 0
+		}
+		c.j++
+		return c.i
+	}
+	if c.length > 0 {
+		c.n += int64(length)
+		if c.n >= c.length {
+			c.i++
+			c.n = 0
+		}
+		return c.i
+	}
+	i := c.i
+	c.i++
+	if c.i == c.parts { // reset index
+		c.i = 0
+	}
+	return i
+}
+
+// partFile returns the output file name of a part from a template.
+func partFile(template string, name string, num int, ext string) string {
+	return strings.NewReplacer(
+		"{name}", name,
+		"{num}", fmt.Sprintf("%03d", num),
+		"{ext}", ext,
+	).Replace(template)
+}
+
+// split2Parts writes records to parts of an input file. For splitting by
+// size or length, a part is closed once the next part is created.
+type split2Parts struct {
+	outdir     string
+	template   string
+	fileName   string
+	fileExt    string
+	sequential bool
+	tarWriter  *TarWriter
+	quiet      bool
+
+	outfhs   []io.WriteCloser
+	counts   []int
+	outfiles []string
+}
+
+func (p *split2Parts) write(i int, record *fastx.Record, lineWidth int) {
+	for len(p.outfhs) <= i {
+		if n := len(p.outfhs); p.sequential && n > 0 {
+			p.closePart(n - 1)
+		}
+		outfile := filepath.Join(p.outdir, partFile(p.template, p.fileName, len(p.outfhs)+1, p.fileExt))
+		var outfh io.WriteCloser
+		if p.tarWriter != nil {
+			w, err := newTarPartWriter(p.tarWriter, outfile)
+			checkError(err)
+			outfh = w
+		} else {
+			w, err := xopen.Wopen(outfile)
+			checkError(err)
+			outfh = w
+		}
+		p.outfhs = append(p.outfhs, outfh)
+		p.counts = append(p.counts, 0)
+		p.outfiles = append(p.outfiles, outfile)
+	}
+
+	if p.tarWriter != nil {
+		p.outfhs[i].Write(record.Format(lineWidth))
+	} else {
+		record.FormatToWriter(p.outfhs[i].(*xopen.Writer), lineWidth)
+	}
+	p.counts[i]++
+}
+
+func (p *split2Parts) closePart(i int) {
+	if p.outfhs[i] == nil {
+		return
+	}
+	checkError(p.outfhs[i].Close())
+	p.outfhs[i] = nil
+	if !p.quiet {
+		log.Infof("write %d sequences to file: %s\n", p.counts[i], p.outfiles[i])
+	}
+}
+
+func (p *split2Parts) close() {
+	for i := range p.outfhs {
+		p.closePart(i)
+	}
 }
@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
@@ -48,6 +49,22 @@ var statCmd = &cobra.Command{
 	Short:   "simple statistics of FASTA/Q files",
 	Long: `simple statistics of FASTA/Q files
 
+Statistics of sequence lengths and contents by -a/--all:
+  1. Q1, Q2, Q3: quartiles of sequence lengths.
+  2. N50, N90: the minimum length of the longest sequences covering 50%
+     and 90% of the total length. L50: the number of these sequences for N50.
+  3. auN: area under the Nx curve, i.e., sum of squared lengths / sum_len.
+  4. sum_gap, num_gaps: numbers of gap letters (-G/--gap-letters) and runs
+     of them, e.g., use -G N for scaffolds.
+  5. GC(%): percentage of G and C bases.
+
+Statistics of FASTQ quality by -a/--all:
+  1. Q20(%), Q30(%): percentages of bases with quality >= 20 and >= 30.
+  2. AvgQual: mean of the average qualities of all reads, where the average
+     quality of a read is computed in probability space, i.e., the mean of
+     the error probabilities of all bases converted back to a Phred score.
+  3. Q20_bases, Q30_bases: yields of bases with quality >= 20 and >= 30.
+
 Tips:
   1. For lots of small files (especially on SDD), use big value of '-j' to
      parallelize counting.
@@ -72,9 +89,22 @@ Tips:
 			}
 		}
 		gapLettersBytes := []byte(gapLetters)
+		var isGap [256]bool
+		for _, c := range gapLettersBytes {
+			isGap[c] = true
+		}
 
 		all := getFlagBool(cmd, "all")
-		tabular := getFlagBool(cmd, "tabular")
+		outFormat := getFlagString(cmd, "format")
+		switch outFormat {
+		case "pretty", "tsv", "json":
+		default:
+			checkError(fmt.Errorf("invalid value of flag --format: %s, available values: pretty, tsv, json", outFormat))
+		}
+		if getFlagBool(cmd, "tabular") {
+			outFormat = "tsv"
+		}
+		tabular := outFormat == "tsv"
 		skipErr := getFlagBool(cmd, "skip-err")
 		fqEncoding := parseQualityEncoding(getFlagString(cmd, "fq-encoding"))
 		basename := getFlagBool(cmd, "basename")
@@ -100,7 +130,7 @@ Tips:
 				"max_len",
 			}
 			if all {
-				colnames = append(colnames, []string{"Q1", "Q2", "Q3", "sum_gap", "N50", "Q20(%)", "Q30(%)"}...)
+				colnames = append(colnames, []string{"Q1", "Q2", "Q3", "sum_gap", "N50", "Q20(%)", "Q30(%)", "AvgQual", "Q20_bases", "Q30_bases", "N90", "L50", "auN", "GC(%)", "num_gaps"}...)
 			}
 			outfh.WriteString(strings.Join(colnames, "\t") + "\n")
 		}
@@ -131,34 +161,7 @@ Tips:
 					if !tabular {
 						statInfos = append(statInfos, info)
 					} else {
-						if !all {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\n",
-								info.file,
-								info.format,
-								info.t,
-								info.num,
-								info.lenSum,
-								info.lenMin,
-								info.lenAvg,
-								info.lenMax))
-						} else {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\t%.1f\t%.1f\t%.1f\t%d\t%d\t%.2f\t%.2f\n",
-								info.file,
-								info.format,
-								info.t,
-								info.num,
-								info.lenSum,
-								info.lenMin,
-								info.lenAvg,
-								info.lenMax,
-								info.Q1,
-								info.Q2,
-								info.Q3,
-								info.gapSum,
-								info.N50,
-								info.q20,
-								info.q30))
-						}
+						outfh.WriteString(info.tabularRow(all))
 					}
 					id++
 				} else { // check bufferd result
@@ -167,34 +170,7 @@ Tips:
 							if !tabular {
 								statInfos = append(statInfos, info1)
 							} else {
-								if !all {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\n",
-										info1.file,
-										info1.format,
-										info1.t,
-										info1.num,
-										info1.lenSum,
-										info1.lenMin,
-										info1.lenAvg,
-										info1.lenMax))
-								} else {
-									outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\t%.1f\t%.1f\t%.1f\t%d\t%d\t%.2f\t%.2f\n",
-										info1.file,
-										info1.format,
-										info1.t,
-										info1.num,
-										info1.lenSum,
-										info1.lenMin,
-										info1.lenAvg,
-										info1.lenMax,
-										info1.Q1,
-										info1.Q2,
-										info1.Q3,
-										info1.gapSum,
-										info1.N50,
-										info1.q20,
-										info1.q30))
-								}
+								outfh.WriteString(info1.tabularRow(all))
 							}
 
 							delete(buf, info1.id)
@@ -220,34 +196,7 @@ Tips:
 					if !tabular {
 						statInfos = append(statInfos, info)
 					} else {
-						if !all {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\n",
-								info.file,
-								info.format,
-								info.t,
-								info.num,
-								info.lenSum,
-								info.lenMin,
-								info.lenAvg,
-								info.lenMax))
-						} else {
-							outfh.WriteString(fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\t%.1f\t%.1f\t%.1f\t%d\t%d\t%.2f\t%.2f\n",
-								info.file,
-								info.format,
-								info.t,
-								info.num,
-								info.lenSum,
-								info.lenMin,
-								info.lenAvg,
-								info.lenMax,
-								info.Q1,
-								info.Q2,
-								info.Q3,
-								info.gapSum,
-								info.N50,
-								info.q20,
-								info.q30))
-						}
+						outfh.WriteString(info.tabularRow(all))
 					}
 				}
 			}
@@ -296,6 +245,18 @@ Tips:
 				var q20, q30 int64
 				var q byte
 				var encodeOffset int = fqEncoding.Offset()
+				var errProbs [256]float64
+				var errProbSum, readQualSum float64
+				var numFastq uint64
+				if all {
+					errProbs = qualErrorProbs(encodeOffset)
+				}
+				var gcSum, gapNum uint64
+				var lenSquareSum float64
+				var lenCounts map[uint64]uint64
+				if all {
+					lenCounts = make(map[uint64]uint64, 1024)
+				}
 				var seqFormat, t string
 				var record *fastx.Record
 				var fastxReader *fastx.Reader
@@ -349,7 +310,8 @@ Tips:
 					lensStats.Add(uint64(len(record.Seq.Seq)))
 
 					if all {
-						if fastxReader.IsFastq {
+						if fastxReader.IsFastq && len(record.Seq.Qual) > 0 {
+							errProbSum = 0
 							for _, q = range record.Seq.Qual {
 								if int(q)-encodeOffset >= 20 {
 									q20++
@@ -357,10 +319,17 @@ Tips:
 										q30++
 									}
 								}
+								errProbSum += errProbs[q]
 							}
+							readQualSum += errorProbToPhred(errProbSum / float64(len(record.Seq.Qual)))
+							numFastq++
 						}
 
 						gapSum += uint64(byteutil.CountBytes(record.Seq.Seq, gapLettersBytes))
+						gapNum += countGapRuns(record.Seq.Seq, &isGap)
+						gcSum += uint64(byteutil.CountBytes(record.Seq.Seq, gcLetters))
+						lenSquareSum += float64(len(record.Seq.Seq)) * float64(len(record.Seq.Seq))
+						lenCounts[uint64(len(record.Seq.Seq))]++
 					}
 				}
 
@@ -374,13 +343,23 @@ Tips:
 					t = fastxReader.Alphabet().String()
 				}
 
-				var n50 uint64
+				var n50, n90 uint64
 				var l50 int
 				var q1, q2, q3 float64
+				var auN, gc float64
 				if all {
 					n50 = lensStats.N50()
 					l50 = lensStats.L50()
 					q1, q2, q3 = lensStats.Q1(), lensStats.Q2(), lensStats.Q3()
+					n90 = lengthNx(lenCounts, lensStats.Sum(), 90)
+					if lensStats.Sum() > 0 {
+						auN = math.Round(lenSquareSum/float64(lensStats.Sum()), 1)
+						gc = math.Round(float64(gcSum)/float64(lensStats.Sum())*100, 2)
+					}
+				}
+				var avgReadQual float64
+				if numFastq > 0 {
+					avgReadQual = readQualSum / float64(numFastq)
 				}
 
 				select {
@@ -399,7 +378,8 @@ Tips:
 						0, 0, 0, 0,
 						0, 0, 0, 0,
 						0, 0, 0,
-						0, 0,
+						0, 0, 0, 0, 0,
+						0, 0, 0, 0,
 						nil, id}
 				} else {
 					if basename {
@@ -413,6 +393,8 @@ Tips:
 						math.Round(lensStats.Mean(), 1), lensStats.Max(), n50, l50,
 						q1, q2, q3,
 						math.Round(float64(q20)/float64(lensStats.Sum())*100, 2), math.Round(float64(q30)/float64(lensStats.Sum())*100, 2),
+						math.Round(avgReadQual, 2), q20, q30,
+						n90, auN, gc, gapNum,
 						nil, id}
 				}
 			}(file, id)
@@ -433,6 +415,18 @@ Tips:
 			return
 		}
 
+		if outFormat == "json" {
+			records := make([]statRecord, len(statInfos))
+			for i, info := range statInfos {
+				records[i] = info.record(all)
+			}
+			data, err := json.MarshalIndent(records, "", "  ")
+			checkError(err)
+			outfh.Write(data)
+			outfh.WriteString("\n")
+			return
+		}
+
 		// format output
 		columns := []prettytable.Column{
 			{Header: "file"},
@@ -453,7 +447,14 @@ Tips:
 				{Header: "N50", AlignRight: true},
 				{Header: "Q20(%)", AlignRight: true},
 				{Header: "Q30(%)", AlignRight: true},
-				// {Header: "L50", AlignRight: true},
+				{Header: "AvgQual", AlignRight: true},
+				{Header: "Q20_bases", AlignRight: true},
+				{Header: "Q30_bases", AlignRight: true},
+				{Header: "N90", AlignRight: true},
+				{Header: "L50", AlignRight: true},
+				{Header: "auN", AlignRight: true},
+				{Header: "GC(%)", AlignRight: true},
+				{Header: "num_gaps", AlignRight: true},
 			}...)
 		}
 
@@ -490,7 +491,14 @@ Tips:
 					humanize.Comma(int64(info.N50)),
 					humanize.Commaf(info.q20),
 					humanize.Commaf(info.q30),
-					// humanize.Comma(info.L50),
+					humanize.Commaf(info.avgQual),
+					humanize.Comma(info.q20Bases),
+					humanize.Comma(info.q30Bases),
+					humanize.Comma(int64(info.N90)),
+					humanize.Comma(int64(info.L50)),
+					humanize.Commaf(info.auN),
+					humanize.Commaf(info.gc),
+					humanize.Comma(int64(info.gapNum)),
 				)
 			}
 		}
@@ -520,16 +528,173 @@ type statInfo struct {
 	q20 float64
 	q30 float64
 
+	avgQual  float64
+	q20Bases int64
+	q30Bases int64
+
+	N90    uint64
+	auN    float64
+	gc     float64
+	gapNum uint64
+
 	err error
 	id  uint64
 }
 
+// tabularRow returns a row of the tab-delimited output.
+func (info statInfo) tabularRow(all bool) string {
+	if !all {
+		return fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\n",
+			info.file,
+			info.format,
+			info.t,
+			info.num,
+			info.lenSum,
+			info.lenMin,
+			info.lenAvg,
+			info.lenMax)
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\t%.1f\t%.1f\t%.1f\t%d\t%d\t%.2f\t%.2f\t%.2f\t%d\t%d\t%d\t%d\t%.1f\t%.2f\t%d\n",
+		info.file,
+		info.format,
+		info.t,
+		info.num,
+		info.lenSum,
+		info.lenMin,
+		info.lenAvg,
+		info.lenMax,
+		info.Q1,
+		info.Q2,
+		info.Q3,
+		info.gapSum,
+		info.N50,
+		info.q20,
+		info.q30,
+		info.avgQual,
+		info.q20Bases,
+		info.q30Bases,
+		info.N90,
+		info.L50,
+		info.auN,
+		info.gc,
+		info.gapNum)
+}
+
+// statRecord is the JSON output of a file.
+type statRecord struct {
+	File    string  `json:"file"`
+	Format  string  `json:"format"`
+	Type    string  `json:"type"`
+	NumSeqs uint64  `json:"num_seqs"`
+	SumLen  uint64  `json:"sum_len"`
+	MinLen  uint64  `json:"min_len"`
+	AvgLen  float64 `json:"avg_len"`
+	MaxLen  uint64  `json:"max_len"`
+
+	*statRecordAll
+}
+
+// statRecordAll holds the statistics output with -a/--all.
+type statRecordAll struct {
+	Q1       float64 `json:"Q1"`
+	Q2       float64 `json:"Q2"`
+	Q3       float64 `json:"Q3"`
+	SumGap   uint64  `json:"sum_gap"`
+	N50      uint64  `json:"N50"`
+	Q20      float64 `json:"Q20(%)"`
+	Q30      float64 `json:"Q30(%)"`
+	AvgQual  float64 `json:"AvgQual"`
+	Q20Bases int64   `json:"Q20_bases"`
+	Q30Bases int64   `json:"Q30_bases"`
+	N90      uint64  `json:"N90"`
+	L50      int     `json:"L50"`
+	AuN      float64 `json:"auN"`
+	GC       float64 `json:"GC(%)"`
+	NumGaps  uint64  `json:"num_gaps"`
+}
+
+func (info statInfo) record(all bool) statRecord {
+	r := statRecord{
+		File:    info.file,
+		Format:  info.format,
+		Type:    info.t,
+		NumSeqs: info.num,
+		SumLen:  info.lenSum,
+		MinLen:  info.lenMin,
+		AvgLen:  info.lenAvg,
+		MaxLen:  info.lenMax,
+	}
+	if all {
+		r.statRecordAll = &statRecordAll{
+			Q1:       info.Q1,
+			Q2:       info.Q2,
+			Q3:       info.Q3,
+			SumGap:   info.gapSum,
+			N50:      info.N50,
+			Q20:      info.q20,
+			Q30:      info.q30,
+			AvgQual:  info.avgQual,
+			Q20Bases: info.q20Bases,
+			Q30Bases: info.q30Bases,
+			N90:      info.N90,
+			L50:      info.L50,
+			AuN:      info.auN,
+			GC:       info.gc,
+			NumGaps:  info.gapNum,
+		}
+	}
+	return r
+}
+
+var gcLetters = []byte("GCgc")
+
+// countGapRuns counts the runs of gap letters in a sequence.
+func countGapRuns(s []byte, isGap *[256]bool) uint64 {
+	var n uint64
+	var inGap bool
+	for _, c := range s {
+		if isGap[c] {
+			if !inGap {
+				n++
+				inGap = true
+			}
+		} else {
+			inGap = false
+		}
+	}
+	return n
+}
+
+// lengthNx returns the Nx (e.g., N90) of sequence lengths given as counts
+// of every length, i.e., the length of the shortest sequences in the
+// longest ones covering x percent of the total length.
+func lengthNx(lenCounts map[uint64]uint64, sum uint64, x float64) uint64 {
+	if sum == 0 {
+		return 0
+	}
+	lens := make(sortutil.Uint64Slice, 0, len(lenCounts))
+	for l := range lenCounts {
+		lens = append(lens, l)
+	}
+	sort.Sort(sort.Reverse(lens))
+	target := float64(sum) * x / 100
+	var cum uint64
+	for _, l := range lens {
+		cum += l * lenCounts[l]
+		if float64(cum) >= target {
+			return l
+		}
+	}
+	return lens[len(lens)-1]
+}
+
 func init() {
 	RootCmd.AddCommand(statCmd)
 
-	statCmd.Flags().BoolP("tabular", "T", false, "output in machine-friendly tabular format")
+	statCmd.Flags().BoolP("tabular", "T", false, "output in machine-friendly tabular format, the same as --format tsv")
+	statCmd.Flags().StringP("format", "", "pretty", "output format: pretty, tsv or json")
 	statCmd.Flags().StringP("gap-letters", "G", "- .", "gap letters")
-	statCmd.Flags().BoolP("all", "a", false, "all statistics, including quartiles of seq length, sum_gap, N50")
+	statCmd.Flags().BoolP("all", "a", false, "all statistics, including quartiles of seq length, sum_gap, N50, N90, L50, auN, GC content, number of gaps, and Q20/Q30 and average read quality of FASTQ")
 	statCmd.Flags().BoolP("skip-err", "e", false, "skip error, only show warning message")
 	statCmd.Flags().StringP("fq-encoding", "E", "sanger", `fastq quality encoding. available values: 'sanger', 'solexa', 'illumina-1.3+', 'illumina-1.5+', 'illumina-1.8+'.`)
 	statCmd.Flags().BoolP("basename", "b", false, "only output basename of files")
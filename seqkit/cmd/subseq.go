@@ -40,11 +40,17 @@ import (
 // subseqCmd represents the subseq command
 var subseqCmd = &cobra.Command{
 	Use:   "subseq",
-	Short: "get subsequences by region/gtf/bed, including flanking sequences",
-	Long: fmt.Sprintf(`get subsequences by region/gtf/bed, including flanking sequences.
+	Short: "get subsequences by region/gtf/bed/gff, including flanking sequences",
+	Long: fmt.Sprintf(`get subsequences by region/gtf/bed/gff, including flanking sequences.
 
 Recommendation: use plain FASTA file, so seqkit could utilize FASTA index.
 
+Spliced sequences (e.g., transcripts and CDSs) are extracted from BED12
+records with flag --spliced, or from features of a type (--gff-type) in
+GFF3 grouped by their Parent attributes. Blocks (exons) are concatenated,
+reverse-complemented for the negative strand, and optionally translated
+with flag --translate, where the phase of the 5'-most CDS is respected.
+
 The definition of region is 1-based and with some custom design.
 
 Examples:
@@ -79,6 +85,11 @@ Examples:
 
 		gtfFile := getFlagString(cmd, "gtf")
 		bedFile := getFlagString(cmd, "bed")
+		gffFile := getFlagString(cmd, "gff")
+		gffType := getFlagString(cmd, "gff-type")
+		spliced := getFlagBool(cmd, "spliced")
+		translate := getFlagBool(cmd, "translate")
+		translTable := getFlagPositiveInt(cmd, "transl-table")
 		gtfTag := getFlagString(cmd, "gtf-tag")
 		choosedFeatures := getFlagStringSlice(cmd, "feature")
 		choosedFeatures2 := make([]string, len(choosedFeatures))
@@ -99,6 +110,21 @@ Examples:
 					" one of flags -u (--up-stream) and -d (--down-stream) should be given"))
 			}
 		}
+		if spliced && bedFile == "" {
+			checkError(fmt.Errorf("flag --spliced only works with flag --bed"))
+		}
+		useSpliced := region == "" && gtfFile == "" && (gffFile != "" || (bedFile != "" && spliced))
+		if useSpliced {
+			if upStream > 0 || downStream > 0 || onlyFlank {
+				checkError(fmt.Errorf("flags -u (--up-stream), -d (--down-stream) and -f (--only-flank) are not supported" +
+					" for spliced extraction with --gff or --bed --spliced"))
+			}
+		} else if translate {
+			checkError(fmt.Errorf("flag --translate only works with --gff or --bed --spliced"))
+		}
+		if _, ok := seq.CodonTables[translTable]; translate && !ok {
+			checkError(fmt.Errorf("invalid translate table: %d", translTable))
+		}
 		if region != "" {
 			if upStream > 0 || downStream > 0 || onlyFlank {
 				checkError(fmt.Errorf("when flag -r (--region) given," +
@@ -119,6 +145,7 @@ Examples:
 
 		var gtfFeaturesMap map[string]type2gtfFeatures
 		var bedFeatureMap map[string][]BedFeature
+		var splicedFeatureMap map[string][]*SplicedFeature
 
 		if region != "" {
 			if !reRegion.MatchString(region) {
@@ -165,6 +192,42 @@ Examples:
 			if !quiet {
 				log.Infof("%d GTF features loaded", len(features))
 			}
+		} else if useSpliced {
+			var features []*SplicedFeature
+			if gffFile != "" {
+				if !quiet {
+					log.Info("read GFF3 file ...")
+				}
+				features, err = ReadGFF3SplicedFeatures(gffFile, chrs, gffType)
+				checkError(err)
+			} else {
+				if !quiet {
+					log.Info("read BED file ...")
+				}
+				Threads = config.Threads // threads of ReadBedFeatures
+
+				var bedFeatures []BedFeature
+				if len(chrs) > 0 {
+					bedFeatures, err = ReadBedFilteredFeatures(bedFile, chrs)
+				} else {
+					bedFeatures, err = ReadBedFeatures(bedFile)
+				}
+				checkError(err)
+				features = make([]*SplicedFeature, len(bedFeatures))
+				for i, feature := range bedFeatures {
+					features[i] = BedFeature2SplicedFeature(feature)
+				}
+			}
+
+			splicedFeatureMap = make(map[string][]*SplicedFeature)
+			var chr string
+			for _, feature := range features {
+				chr = strings.ToLower(feature.Chr)
+				splicedFeatureMap[chr] = append(splicedFeatureMap[chr], feature)
+			}
+			if !quiet {
+				log.Infof("%d spliced features loaded", len(features))
+			}
 		} else if bedFile != "" {
 			if !quiet {
 				log.Info("read BED file ...")
@@ -264,6 +327,31 @@ Examples:
 								onlyFlank, upStream, downStream, gtfTag)
 						}
 
+						continue
+					} else if useSpliced {
+						for chr := range splicedFeatureMap {
+							if len(chrs) > 0 { // selected chrs
+								if _, ok := chrsMap[strings.ToLower(chr)]; !ok {
+									continue
+								}
+							}
+
+							chr = string(id2name[chr])
+
+							r, ok := faidx.Index[chr]
+							if !ok {
+								log.Warningf(`sequence (%s) not found in file: %s`, chr, file)
+								continue
+							}
+
+							subseq := subseqByFaix(faidx, chr, r, 1, -1)
+							record, err := fastx.NewRecord(alphabet2, fastx.ParseHeadID(idRe, []byte(chr)), []byte(chr), []byte{}, subseq)
+							checkError(err)
+
+							subseqBySplicedFeatures(outfh, record, config.LineWidth,
+								splicedFeatureMap, translate, translTable)
+						}
+
 						continue
 					} else if bedFile != "" {
 						for chr := range bedFeatureMap {
@@ -330,6 +418,15 @@ Examples:
 						gtfFeaturesMap, choosedFeatures,
 						onlyFlank, upStream, downStream, gtfTag)
 
+				} else if useSpliced {
+					seqname := strings.ToLower(string(record.ID))
+					if _, ok := splicedFeatureMap[seqname]; !ok {
+						continue
+					}
+
+					subseqBySplicedFeatures(outfh, record, config.LineWidth,
+						splicedFeatureMap, translate, translTable)
+
 				} else if bedFile != "" {
 					seqname := strings.ToLower(string(record.ID))
 					if _, ok := bedFeatureMap[seqname]; !ok {
@@ -557,10 +654,63 @@ func subSeqByBEDFile(outfh *xopen.Writer, record *fastx.Record, lineWidth int,
 	}
 }
 
+// subseqBySplicedFeatures concatenates blocks (exons) of every feature,
+// reverse-complements them for features on the negative strand and
+// optionally translates them.
+func subseqBySplicedFeatures(outfh *xopen.Writer, record *fastx.Record, lineWidth int,
+	featureMap map[string][]*SplicedFeature, translate bool, translTable int) {
+	seqname := strings.ToLower(string(record.ID))
+
+	var outname string
+	var newRecord *fastx.Record
+	var protein *seq.Seq
+	var err error
+	for _, feature := range featureMap[seqname] {
+		if feature.End() > len(record.Seq.Seq) {
+			log.Warningf("feature %s (%d-%d) out of range of sequence %s (%d bp), ignored",
+				feature.Name, feature.Start(), feature.End(), record.ID, len(record.Seq.Seq))
+			continue
+		}
+
+		var subseq, qual []byte
+		for _, b := range feature.Blocks {
+			subseq = append(subseq, record.Seq.Seq[b[0]-1:b[1]]...)
+			if len(record.Seq.Qual) > 0 {
+				qual = append(qual, record.Seq.Qual[b[0]-1:b[1]]...)
+			}
+		}
+
+		outname = fmt.Sprintf("%s_%d-%d:%s %s", record.ID, feature.Start(), feature.End(), feature.Strand, feature.Name)
+		if len(qual) > 0 && !translate {
+			newRecord, err = fastx.NewRecordWithQualWithoutValidation(record.Seq.Alphabet, []byte(outname), []byte(outname), []byte{}, subseq, qual)
+		} else {
+			newRecord, err = fastx.NewRecordWithoutValidation(record.Seq.Alphabet, []byte(outname), []byte(outname), []byte{}, subseq)
+		}
+		checkError(err)
+		if feature.Strand == "-" {
+			newRecord.Seq.RevComInplace()
+		}
+
+		if !translate {
+			outfh.Write(newRecord.Format(lineWidth))
+			continue
+		}
+
+		if feature.Phase < len(newRecord.Seq.Seq) {
+			newRecord.Seq.Seq = newRecord.Seq.Seq[feature.Phase:]
+		}
+		protein, err = newRecord.Seq.Translate(translTable, 1, false, false, true, false)
+		checkError(err)
+		outfh.WriteString(">" + outname + "\n")
+		outfh.Write(byteutil.WrapByteSlice(protein.Seq, lineWidth))
+		outfh.WriteString("\n")
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(subseqCmd)
 
-	subseqCmd.Flags().StringSliceP("chr", "", []string{}, "select limited sequence with sequence IDs when using --gtf or --bed (multiple value supported, case ignored)")
+	subseqCmd.Flags().StringSliceP("chr", "", []string{}, "select limited sequence with sequence IDs when using --gtf, --bed or --gff (multiple value supported, case ignored)")
 	subseqCmd.Flags().StringP("region", "r", "", "by region. "+
 		"e.g 1:12 for first 12 bases, -12:-1 for last 12 bases,"+
 		` 13:-1 for cutting first 12 bases. type "seqkit subseq -h" for more examples`)
@@ -571,5 +721,10 @@ func init() {
 	subseqCmd.Flags().IntP("down-stream", "d", 0, "down stream length")
 	subseqCmd.Flags().BoolP("only-flank", "f", false, "only return up/down stream sequence")
 	subseqCmd.Flags().StringP("bed", "", "", "by tab-delimited BED file")
+	subseqCmd.Flags().BoolP("spliced", "", false, "concatenate blocks (exons) of BED12 records instead of extracting the whole regions")
+	subseqCmd.Flags().StringP("gff", "", "", "by GFF3 file, features of --gff-type are grouped by their parents and concatenated")
+	subseqCmd.Flags().StringP("gff-type", "", "exon", "feature type to concatenate when using --gff, e.g., exon or CDS (case ignored)")
+	subseqCmd.Flags().BoolP("translate", "", false, "translate spliced sequences (--gff or --bed --spliced) to proteins")
+	subseqCmd.Flags().IntP("transl-table", "", 1, `translate table/genetic code, type 'seqkit translate --help' for more details`)
 	subseqCmd.Flags().StringP("gtf-tag", "", "gene_id", `output this tag as sequence comment`)
 }
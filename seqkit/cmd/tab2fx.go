@@ -21,12 +21,15 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"runtime"
+	"strconv"
 	"strings"
 
-	"github.com/shenwei356/breader"
 	"github.com/shenwei356/util/byteutil"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
@@ -38,6 +41,22 @@ var tab2faCmd = &cobra.Command{
 	Short: "convert tabular format to FASTA/Q format",
 	Long: `convert tabular format (first two/three columns) to FASTA/Q format
 
+Columns of IDs, sequences and qualities can be chosen by 1-based indexes
+or column names with --id-col, --seq-col and --qual-col, where column names
+require a header line, which can also be skipped with -H/--header-line.
+When columns are given by names, qualities are only read from --qual-col
+explicitly given. Records with non-empty qualities are outputted in FASTQ
+format.
+
+CSV files (-C/--csv, or files with the suffix .csv or .csv.gz) are parsed
+with quoted fields supported, e.g., exported by R or pandas.
+
+Malformed rows (missing the ID or sequence column, qualities not matching
+the sequence length, or broken CSV quoting) stop the program by default.
+With --max-bad-records they are skipped and reported with the line number
+and byte offset in the log or the --quarantine file, until more than the
+given number of bad rows are seen.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		config := getConfigs(cmd)
@@ -48,102 +67,235 @@ var tab2faCmd = &cobra.Command{
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
 		commentPrefixes := getFlagStringSlice(cmd, "comment-line-prefix")
+		idCol := getFlagString(cmd, "id-col")
+		seqCol := getFlagString(cmd, "seq-col")
+		qualCol := getFlagString(cmd, "qual-col")
+		hasHeader := getFlagBool(cmd, "header-line")
+		isCSV := getFlagBool(cmd, "csv")
+
+		cols := []string{idCol, seqCol, qualCol}
+		var byName bool
+		for i, c := range cols {
+			if n, err := strconv.Atoi(c); err != nil {
+				byName = true
+			} else if n < 0 || (n == 0 && i < 2) {
+				checkError(fmt.Errorf("invalid column index: %d", n))
+			}
+		}
+		if byName {
+			hasHeader = true
+			if !cmd.Flags().Changed("qual-col") {
+				cols[2] = "0"
+			}
+		}
 
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
 		defer outfh.Close()
 
-		type Slice []string
-		fn := func(line string) (interface{}, bool, error) {
-			line = strings.TrimRight(line, "\r\n")
-
-			if line == "" {
-				return "", false, nil
+		quarantine, err := NewQuarantine(getFlagString(cmd, "quarantine"), getFlagInt(cmd, "max-bad-records"))
+		checkError(err)
+		defer quarantine.Close()
+		addBadRow := func(file string, line int, offset int64, err error, content string) {
+			if err = quarantine.Add(file, line, offset, err, content); err != nil {
+				outfh.Close()
+				quarantine.Close()
+				checkError(err)
 			}
-			// check comment line
-			isCommentLine := false
+		}
+
+		isComment := func(line string) bool {
 			for _, p := range commentPrefixes {
 				if strings.HasPrefix(line, p) {
-					isCommentLine = true
-					break
+					return true
 				}
 			}
-			if isCommentLine {
-				return "", false, nil
-			}
-
-			items := strings.Split(line, "\t")
-			if len(items) < 2 {
-				return Slice(items), false, fmt.Errorf("at least two columns needed: %s", line)
-			}
-			if len(items) > 2 {
-				return Slice(items[0:3]), true, nil
-			}
-			return Slice(items[0:2]), true, nil
+			return false
 		}
 
 		for _, file := range files {
-			reader, err := breader.NewBufferedReader(file, config.Threads, 10, fn)
+			rows, err := newTab2fxRowReader(file, isCSV || isCSVFile(file), isComment)
 			checkError(err)
+
+			var idx [3]int // 0-based column indexes, -1 for missing quality column
+			if hasHeader {
+				header, err := rows.next(false)
+				if err == io.EOF {
+					rows.close()
+					continue
+				}
+				checkError(err)
+				for i, c := range cols {
+					idx[i], err = tab2fxColumn(header, c)
+					if err != nil && i < 2 {
+						checkError(fmt.Errorf("%s: %s", file, err))
+					}
+				}
+			} else {
+				for i, c := range cols {
+					idx[i], _ = tab2fxColumn(nil, c)
+				}
+			}
 			var text []byte
 			var b *bytes.Buffer
+			var items []string
+			var id, sequence, qual string
 			isFastq := false
-			for chunk := range reader.Ch {
-				if chunk.Err != nil {
-					checkError(chunk.Err)
+			for {
+				items, err = rows.next(true)
+				if err == io.EOF {
 					break
 				}
-				for _, data := range chunk.Data {
-					items := data.(Slice)
-					if len(items) == 3 && (len(items[2]) > 0 || isFastq) { // fastq
-						isFastq = true
-						outfh.WriteString(fmt.Sprintf("@%s\n", items[0]))
+				if perr, ok := err.(*csv.ParseError); ok {
+					addBadRow(file, perr.StartLine, rows.offset, perr.Err, "")
+					continue
+				}
+				checkError(err)
+				if len(items) <= idx[0] || len(items) <= idx[1] {
+					addBadRow(file, rows.line, rows.offset, fmt.Errorf("column of ID or sequence missing"), strings.Join(items, "\t"))
+					continue
+				}
+				id, sequence = items[idx[0]], items[idx[1]]
+				qual = ""
+				if idx[2] >= 0 && idx[2] < len(items) {
+					qual = items[idx[2]]
+				}
+				if len(qual) > 0 && len(qual) != len(sequence) {
+					addBadRow(file, rows.line, rows.offset, fmt.Errorf("lengths of sequence (%d) and qualities (%d) mismatch", len(sequence), len(qual)), strings.Join(items, "\t"))
+					continue
+				}
 
-						// 	outfh.Write(byteutil.WrapByteSlice([]byte(items[1]), lineWidth))
+				if len(qual) > 0 || isFastq { // fastq
+					isFastq = true
+					outfh.WriteString(fmt.Sprintf("@%s\n", id))
+					outfh.WriteString(sequence) // seq
+					outfh.WriteString("\n+\n")
+					outfh.WriteString(qual) // qual
+					outfh.WriteString("\n")
+				} else {
+					outfh.WriteString(fmt.Sprintf(">%s\n", id))
 
-						// if bufferedByteSliceWrapper == nil {
-						// 	bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(items[1]), lineWidth)
-						// }
-						// text, b = bufferedByteSliceWrapper.Wrap([]byte(items[1]), lineWidth)
-						// outfh.Write(text)
-						// outfh.Flush()
-						// bufferedByteSliceWrapper.Recycle(b)
+					if bufferedByteSliceWrapper == nil {
+						bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(sequence), lineWidth)
+					}
+					text, b = bufferedByteSliceWrapper.Wrap([]byte(sequence), lineWidth)
+					outfh.Write(text)
+					outfh.Flush()
+					bufferedByteSliceWrapper.Recycle(b)
 
-						outfh.WriteString(items[1]) // seq
+					outfh.WriteString("\n")
+				}
+			}
+			rows.close()
+		}
+	},
+}
 
-						outfh.WriteString("\n+\n")
+func init() {
+	RootCmd.AddCommand(tab2faCmd)
+	tab2faCmd.Flags().StringSliceP("comment-line-prefix", "p", []string{"#", "//"}, "comment line prefix")
+	tab2faCmd.Flags().StringP("id-col", "", "1", "column of IDs, 1-based index or column name")
+	tab2faCmd.Flags().StringP("seq-col", "", "2", "column of sequences, 1-based index or column name")
+	tab2faCmd.Flags().StringP("qual-col", "", "3", "column of qualities, 1-based index or column name, 0 for none")
+	tab2faCmd.Flags().BoolP("header-line", "H", false, "the first line is a header line")
+	tab2faCmd.Flags().BoolP("csv", "C", false, "input is in CSV format")
+	tab2faCmd.Flags().StringP("quarantine", "", "", "write malformed rows to this file in TSV format (file, line number, byte offset, error and content) instead of the log")
+	tab2faCmd.Flags().IntP("max-bad-records", "", 0, "skip up to N malformed rows in total and exit with error after that (-1 for no limit)")
+}
+
+// isCSVFile checks the suffix of a file.
+func isCSVFile(file string) bool {
+	file = strings.ToLower(file)
+	return strings.HasSuffix(file, ".csv") || strings.HasSuffix(file, ".csv.gz")
+}
 
-						// outfh.Write(byteutil.WrapByteSlice([]byte(items[2]), lineWidth))
+// tab2fxColumn returns the 0-based index of a column given by a 1-based
+// index or a column name in the header. -1 is returned for "0".
+// The leading "#" of the first column name is optional, e.g., the header
+// line of fx2tab.
+func tab2fxColumn(header []string, col string) (int, error) {
+	if i, err := strconv.Atoi(col); err == nil {
+		return i - 1, nil
+	}
+	for i, name := range header {
+		if name == col || (i == 0 && strings.TrimPrefix(name, "#") == col) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("column not found: %s", col)
+}
 
-						// text, b = bufferedByteSliceWrapper.Wrap([]byte(items[2]), lineWidth)
-						// outfh.Write(text)
-						// outfh.Flush()
-						// bufferedByteSliceWrapper.Recycle(b)
+// tab2fxRowReader reads rows of a TSV or CSV file, skipping blank lines,
+// and comment lines if needed.
+type tab2fxRowReader struct {
+	fh        *xopen.Reader
+	scanner   *bufio.Scanner
+	csv       *csv.Reader
+	isComment func(string) bool
 
-						outfh.WriteString(items[2]) // qual
+	line       int   // 1-based line number of the last row
+	offset     int64 // 0-based byte offset of the last row
+	nextOffset int64 // byte offset of the next line (TSV)
+}
 
-						outfh.WriteString("\n")
-					} else {
-						outfh.WriteString(fmt.Sprintf(">%s\n", items[0]))
+func newTab2fxRowReader(file string, isCSV bool, isComment func(string) bool) (*tab2fxRowReader, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	r := &tab2fxRowReader{fh: fh, isComment: isComment}
+	if isCSV {
+		r.csv = csv.NewReader(fh)
+		r.csv.FieldsPerRecord = -1
+		r.csv.ReuseRecord = true
+	} else {
+		r.scanner = bufio.NewScanner(fh)
+		r.scanner.Buffer(make([]byte, 0, 1<<20), 1<<30)
+		r.scanner.Split(r.scanLines)
+	}
+	return r, nil
+}
 
-						// outfh.Write(byteutil.WrapByteSlice([]byte(items[1]), lineWidth))
-						if bufferedByteSliceWrapper == nil {
-							bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(items[1]), lineWidth)
-						}
-						text, b = bufferedByteSliceWrapper.Wrap([]byte(items[1]), lineWidth)
-						outfh.Write(text)
-						outfh.Flush()
-						bufferedByteSliceWrapper.Recycle(b)
+// scanLines is bufio.ScanLines keeping track of the line numbers and
+// byte offsets.
+func (r *tab2fxRowReader) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if token != nil {
+		r.line++
+		r.offset = r.nextOffset
+	}
+	r.nextOffset += int64(advance)
+	return advance, token, err
+}
 
-						outfh.WriteString("\n")
-					}
-				}
+func (r *tab2fxRowReader) next(skipComments bool) ([]string, error) {
+	if r.csv != nil {
+		for {
+			r.offset = r.csv.InputOffset()
+			items, err := r.csv.Read()
+			if err != nil {
+				return nil, err
 			}
+			r.line, _ = r.csv.FieldPos(0)
+			if len(items) == 0 || (len(items) == 1 && items[0] == "") || (skipComments && r.isComment(items[0])) {
+				continue
+			}
+			return items, nil
 		}
-	},
+	}
+	for r.scanner.Scan() {
+		line := strings.TrimRight(r.scanner.Text(), "\r\n")
+		if line == "" || (skipComments && r.isComment(line)) {
+			continue
+		}
+		return strings.Split(line, "\t"), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
 }
 
-func init() {
-	RootCmd.AddCommand(tab2faCmd)
-	tab2faCmd.Flags().StringSliceP("comment-line-prefix", "p", []string{"#", "//"}, "comment line prefix")
+func (r *tab2fxRowReader) close() {
+	r.fh.Close()
 }
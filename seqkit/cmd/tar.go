@@ -0,0 +1,247 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+)
+
+// isTarFile checks if a file is a (gzipped) tar archive by its extension.
+func isTarFile(file string) bool {
+	f := strings.ToLower(file)
+	return strings.HasSuffix(f, ".tar") || strings.HasSuffix(f, ".tar.gz") || strings.HasSuffix(f, ".tgz")
+}
+
+// FastxRecordReader is implemented by *fastx.Reader and *TarFastxReader.
+type FastxRecordReader interface {
+	Read() (*fastx.Record, error)
+	Alphabet() *seq.Alphabet
+}
+
+// NewFastxRecordReader returns a reader of sequence records from a sequence
+// file or from a tar archive of sequence files.
+func NewFastxRecordReader(alphabet *seq.Alphabet, file string, idRegexp string) (FastxRecordReader, error) {
+	if isTarFile(file) {
+		return NewTarFastxReader(alphabet, file, idRegexp)
+	}
+	return fastx.NewReader(alphabet, file, idRegexp)
+}
+
+// readerIsFastq checks if the last record read by a reader was in FASTQ format.
+func readerIsFastq(r FastxRecordReader) bool {
+	switch v := r.(type) {
+	case *fastx.Reader:
+		return v.IsFastq
+	case *TarFastxReader:
+		return v.IsFastq
+	}
+	return false
+}
+
+// TarFastxReader reads FASTA/FASTQ records from all regular files in a
+// (gzipped) tar archive. Every file is copied to a temporary file, from which
+// the records are read by a fastx.Reader, so no more than one file of the
+// archive is extracted at a time.
+type TarFastxReader struct {
+	IsFastq bool   // format of the last record
+	Entry   string // name of the current file in the archive
+
+	fh       *xopen.Reader
+	tr       *tar.Reader
+	alphabet *seq.Alphabet
+	idRegexp string
+	reader   *fastx.Reader // reader of the current file
+	tmpFile  string
+}
+
+// NewTarFastxReader opens a tar archive for reading sequence records.
+func NewTarFastxReader(alphabet *seq.Alphabet, file string, idRegexp string) (*TarFastxReader, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	return &TarFastxReader{fh: fh, tr: tar.NewReader(fh), alphabet: alphabet, idRegexp: idRegexp}, nil
+}
+
+// Alphabet returns the alphabet of the sequences, which is guessed from the
+// first record if it was not given.
+func (r *TarFastxReader) Alphabet() *seq.Alphabet {
+	if r.alphabet == nil {
+		return seq.Unlimit
+	}
+	return r.alphabet
+}
+
+// removeTmpFile deletes the temporary copy of the current file.
+func (r *TarFastxReader) removeTmpFile() {
+	if r.tmpFile != "" {
+		os.Remove(r.tmpFile)
+		r.tmpFile = ""
+	}
+	r.reader = nil
+}
+
+// nextEntry copies the next regular file of the archive to a temporary file
+// and opens it. Gzipped files are detected by xopen.
+func (r *TarFastxReader) nextEntry() error {
+	r.removeTmpFile()
+	for {
+		hdr, err := r.tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				r.fh.Close()
+			}
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			continue
+		}
+		r.Entry = hdr.Name
+
+		fh, err := ioutil.TempFile("", "seqkit-tar-")
+		if err != nil {
+			return err
+		}
+		r.tmpFile = fh.Name()
+		_, err = io.Copy(fh, r.tr)
+		if e := fh.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			r.removeTmpFile()
+			return fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+
+		r.reader, err = fastx.NewReader(r.alphabet, r.tmpFile, r.idRegexp)
+		if err != nil {
+			r.removeTmpFile()
+			return fmt.Errorf("%s: %s", hdr.Name, err)
+		}
+		return nil
+	}
+}
+
+// Read returns the next record in the archive or io.EOF.
+func (r *TarFastxReader) Read() (*fastx.Record, error) {
+	for {
+		if r.reader == nil {
+			if err := r.nextEntry(); err != nil {
+				return nil, err
+			}
+		}
+		record, err := r.reader.Read()
+		if err != nil {
+			if err == io.EOF {
+				r.removeTmpFile()
+				continue
+			}
+			return nil, fmt.Errorf("%s: %s", r.Entry, err)
+		}
+		r.IsFastq = r.reader.IsFastq
+		if r.alphabet == nil {
+			// the alphabet guessed from the first file is used for all files
+			r.alphabet = r.reader.Alphabet()
+		}
+		return record, nil
+	}
+}
+
+// TarWriter writes files into a tar archive, which is gzipped if the file
+// name ends with ".gz". It is safe for concurrent use.
+type TarWriter struct {
+	fh *xopen.Writer
+	tw *tar.Writer
+	mu sync.Mutex
+}
+
+// NewTarWriter creates a tar archive ("-" for stdout).
+func NewTarWriter(file string) (*TarWriter, error) {
+	fh, err := xopen.Wopen(file)
+	if err != nil {
+		return nil, err
+	}
+	return &TarWriter{fh: fh, tw: tar.NewWriter(fh)}, nil
+}
+
+// WriteFile adds a file of the given size to the archive, reading its content from r.
+func (w *TarWriter) WriteFile(name string, size int64, r io.Reader) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w.tw, r, size)
+	return err
+}
+
+// Close finishes the archive.
+func (w *TarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.fh.Close()
+}
+
+// tarPartWriter writes the content of a file to a temporary file and adds it
+// to the archive when closed, as the size of a file must be known before its
+// content is written to the tar stream.
+type tarPartWriter struct {
+	*bufio.Writer
+	fh   *os.File
+	name string
+	tw   *TarWriter
+}
+
+func newTarPartWriter(tw *TarWriter, name string) (*tarPartWriter, error) {
+	fh, err := ioutil.TempFile("", "seqkit-tar-part-")
+	if err != nil {
+		return nil, err
+	}
+	return &tarPartWriter{Writer: bufio.NewWriterSize(fh, os.Getpagesize()*16), fh: fh, name: name, tw: tw}, nil
+}
+
+func (w *tarPartWriter) Close() error {
+	defer os.Remove(w.fh.Name())
+	defer w.fh.Close()
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	size, err := w.fh.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.fh.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.tw.WriteFile(w.name, size, w.fh)
+}
@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -58,6 +59,11 @@ Note:
         MGR -> R
         YTR -> L
 
+  2. With flag --orf, ORFs from start codons (ATG) to stop codons in the
+     selected frames, not shorter than --orf-min-len amino acids, are
+     outputted, with their locations, strands and frames in headers.
+     Stop codons are not included in the translations.
+
 Translate Tables/Genetic Codes:
 
     # https://www.ncbi.nlm.nih.gov/Taxonomy/taxonomyhome.html/index.cgi?chapter=tgencodes
@@ -101,22 +107,7 @@ Translate Tables/Genetic Codes:
 		if _, ok := seq.CodonTables[translTable]; !ok {
 			checkError(fmt.Errorf("invalid translate table: %d", translTable))
 		}
-		_frames := getFlagStringSlice(cmd, "frame")
-		frames := make([]int, 0, len(_frames))
-		for _, _frame := range _frames {
-			frame, err := strconv.Atoi(_frame)
-			if err != nil {
-				checkError(fmt.Errorf("invalid frame(s): %s. available: 1, 2, 3, -1, -2, -3, and 6 for all. multiple frames should be separated by comma", _frame))
-			}
-			if !(frame == 1 || frame == 2 || frame == 3 || frame == -1 || frame == -2 || frame == -3 || frame == 6) {
-				checkError(fmt.Errorf("invalid frame: %d. available: 1, 2, 3, -1, -2, -3, and 6 for all", frame))
-			}
-			if frame == 6 {
-				frames = []int{1, 2, 3, -1, -2, -3}
-				break
-			}
-			frames = append(frames, frame)
-		}
+		frames := parseFrames(getFlagStringSlice(cmd, "frame"))
 		trim := getFlagBool(cmd, "trim")
 		clean := getFlagBool(cmd, "clean")
 		allowUnknownCodon := getFlagBool(cmd, "allow-unknown-codon")
@@ -124,6 +115,13 @@ Translate Tables/Genetic Codes:
 		listTable := getFlagInt(cmd, "list-transl-table")
 		listTableAmb := getFlagInt(cmd, "list-transl-table-with-amb-codons")
 		appendFrame := getFlagBool(cmd, "append-frame")
+		stopAtFirstStop := getFlagBool(cmd, "stop-at-first-stop")
+		orfMode := getFlagBool(cmd, "orf")
+		orfMinLen := getFlagNonNegativeInt(cmd, "orf-min-len")
+		if orfMode && stopAtFirstStop {
+			checkError(fmt.Errorf("flag --stop-at-first-stop is not needed for --orf"))
+		}
+		orfStarts, _ := parseStartCodons("ATG")
 
 		outfh, err := xopen.Wopen(outFile)
 		checkError(err)
@@ -159,6 +157,8 @@ Translate Tables/Genetic Codes:
 		var fastxReader *fastx.Reader
 		var _seq *seq.Seq
 		var frame int
+		var orfs []orfHit
+		var orf orfHit
 		once := true
 		for _, file := range files {
 			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
@@ -182,6 +182,21 @@ Translate Tables/Genetic Codes:
 					once = false
 				}
 
+				if orfMode {
+					orfs, err = findORFs(record.Seq, translTable, frames, orfStarts, orfMinLen, false)
+					checkError(err)
+					for _, orf = range orfs {
+						if markInitCodonAsM && len(orf.Protein) > 0 && orf.Protein[0] != 'M' {
+							orf.Protein = append([]byte{'M'}, orf.Protein[1:]...)
+						}
+						outfh.WriteString(fmt.Sprintf(">%s_orf:%d-%d:%s frame=%d length=%d\n",
+							record.ID, orf.Start, orf.End, orf.Strand, orf.Frame, len(orf.Protein)))
+						outfh.Write(byteutil.WrapByteSlice(orf.Protein, config.LineWidth))
+						outfh.WriteString("\n")
+					}
+					continue
+				}
+
 				for _, frame = range frames {
 					_seq, err = record.Seq.Translate(translTable, frame, trim, clean, allowUnknownCodon, markInitCodonAsM)
 					if err != nil {
@@ -193,6 +208,12 @@ Translate Tables/Genetic Codes:
 					}
 					checkError(err)
 
+					if stopAtFirstStop {
+						if i := bytes.IndexByte(_seq.Seq, '*'); i >= 0 {
+							_seq.Seq = _seq.Seq[:i]
+						}
+					}
+
 					if appendFrame {
 						outfh.WriteString(fmt.Sprintf(">%s_frame=%d %s\n", record.ID, frame, record.Desc))
 					} else {
@@ -219,4 +240,26 @@ func init() {
 	translateCmd.Flags().IntP("list-transl-table", "l", -1, "show details of translate table N, 0 for all")
 	translateCmd.Flags().IntP("list-transl-table-with-amb-codons", "L", -1, "show details of translate table N (including ambigugous codons), 0 for all. ")
 	translateCmd.Flags().BoolP("append-frame", "F", false, "append frame information to sequence ID")
+	translateCmd.Flags().BoolP("stop-at-first-stop", "", false, "truncate translations at the first stop codon")
+	translateCmd.Flags().BoolP("orf", "", false, "output ORFs (from ATG to stop codon) of the selected frames instead of whole translations")
+	translateCmd.Flags().IntP("orf-min-len", "", 30, "minimum length of ORFs (amino acids, stop codon not included) with --orf")
+}
+
+// parseFrames parses frames of translation, 6 for all six frames.
+func parseFrames(_frames []string) []int {
+	frames := make([]int, 0, len(_frames))
+	for _, _frame := range _frames {
+		frame, err := strconv.Atoi(_frame)
+		if err != nil {
+			checkError(fmt.Errorf("invalid frame(s): %s. available: 1, 2, 3, -1, -2, -3, and 6 for all. multiple frames should be separated by comma", _frame))
+		}
+		if !(frame == 1 || frame == 2 || frame == 3 || frame == -1 || frame == -2 || frame == -3 || frame == 6) {
+			checkError(fmt.Errorf("invalid frame: %d. available: 1, 2, 3, -1, -2, -3, and 6 for all", frame))
+		}
+		if frame == 6 {
+			return []int{1, 2, 3, -1, -2, -3}
+		}
+		frames = append(frames, frame)
+	}
+	return frames
 }
@@ -0,0 +1,419 @@
+// Copyright © 2016-2019 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+// trimCmd represents the trim command
+var trimCmd = &cobra.Command{
+	Use:   "trim",
+	Short: "trim fixed lengths, adapters/primers and low quality ends of reads",
+	Long: `trim fixed lengths, adapters/primers and low quality ends of reads
+
+The steps are applied in the order:
+
+  1. removing fixed numbers of bases from the 5' and 3' ends (-5, -3),
+  2. removing 5' adapters/primers (-g/--front) and everything before them,
+  3. removing 3' adapters (-a/--adapter) and everything after them,
+  4. quality trimming of the 3' end by a sliding window (-q, -W): the read
+     is cut at the first window with a mean quality below the threshold.
+
+Adapters are located by semi-global alignment allowing a fraction of edits
+(-e/--error-rate) of the aligned adapter length. 3' adapters may be
+partially present at the end of the reads with at least --min-overlap
+bases, and 5' adapters at the start of the reads.
+
+Paired-end reads are given by -1/--read1 and -2/--read2 and written to
+-O/--out-dir, mates are trimmed independently (with -A/-G for read2 if
+given) and pairs with any mate shorter than -m/--min-len are dropped.
+
+The trimmed bases of every read can be saved to a tab-delimited file by
+--stats.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := getConfigs(cmd)
+		alphabet := config.Alphabet
+		idRegexp := config.IDRegexp
+		lineWidth := config.LineWidth
+		outFile := config.OutFile
+		quiet := config.Quiet
+		seq.AlphabetGuessSeqLengthThreshold = config.AlphabetGuessSeqLength
+		seq.ValidateSeq = false
+		runtime.GOMAXPROCS(config.Threads)
+
+		opt := &trimOptions{
+			crop5:      getFlagNonNegativeInt(cmd, "trim5"),
+			crop3:      getFlagNonNegativeInt(cmd, "trim3"),
+			front:      upperStrings(getFlagStringSlice(cmd, "front")),
+			adapters:   upperStrings(getFlagStringSlice(cmd, "adapter")),
+			errorRate:  getFlagFloat64(cmd, "error-rate"),
+			minOverlap: getFlagPositiveInt(cmd, "min-overlap"),
+			minQual:    getFlagFloat64(cmd, "min-qual"),
+			window:     getFlagPositiveInt(cmd, "window"),
+		}
+		opt2 := *opt
+		if front2 := getFlagStringSlice(cmd, "front2"); len(front2) > 0 {
+			opt2.front = upperStrings(front2)
+		}
+		if adapters2 := getFlagStringSlice(cmd, "adapter2"); len(adapters2) > 0 {
+			opt2.adapters = upperStrings(adapters2)
+		}
+		minLen := getFlagNonNegativeInt(cmd, "min-len")
+		statsFile := getFlagString(cmd, "stats")
+		read1 := getFlagString(cmd, "read1")
+		read2 := getFlagString(cmd, "read2")
+		outdir := getFlagString(cmd, "out-dir")
+		force := getFlagBool(cmd, "force")
+
+		if opt.errorRate < 0 || opt.errorRate >= 1 {
+			checkError(fmt.Errorf("value of flag -e/--error-rate should be in range of [0, 1)"))
+		}
+
+		var statsWriter *bufio.Writer
+		if statsFile != "" {
+			statsFh, err := xopen.Wopen(statsFile)
+			checkError(err)
+			defer statsFh.Close()
+			statsWriter = bufio.NewWriter(statsFh)
+			defer statsWriter.Flush()
+			statsWriter.WriteString("read\tmate\tlength\ttrimmed5\ttrimmed3\tadapter\tkept\n")
+		}
+
+		var n, kept int
+		var bases, keptBases int
+		if read1 != "" || read2 != "" {
+			if read1 == "" || read2 == "" {
+				checkError(fmt.Errorf("flag -1/--read1 and -2/--read2 needed"))
+			}
+			if len(args) > 0 {
+				checkError(fmt.Errorf("no positional arugments are allowed with flag -1/--read1 and -2/--read2"))
+			}
+			prepareOutDir(outdir, force)
+
+			reader1, err := fastx.NewReader(alphabet, read1, idRegexp)
+			checkError(errors.Wrap(err, read1))
+			reader2, err := fastx.NewReader(alphabet, read2, idRegexp)
+			checkError(errors.Wrap(err, read2))
+			outfh1, err := xopen.Wopen(filepath.Join(outdir, filepath.Base(read1)))
+			checkError(err)
+			defer outfh1.Close()
+			outfh2, err := xopen.Wopen(filepath.Join(outdir, filepath.Base(read2)))
+			checkError(err)
+			defer outfh2.Close()
+
+			for {
+				record1, err1 := reader1.Read()
+				record2, err2 := reader2.Read()
+				if err1 == io.EOF && err2 == io.EOF {
+					break
+				}
+				if err1 == io.EOF || err2 == io.EOF {
+					checkError(fmt.Errorf("unequal numbers of reads in %s and %s", read1, read2))
+				}
+				checkError(err1)
+				checkError(err2)
+				if reader1.IsFastq {
+					lineWidth = 0
+					fastx.ForcelyOutputFastq = true
+				}
+
+				bases += len(record1.Seq.Seq) + len(record2.Seq.Seq)
+				res1 := trimRead(record1, opt)
+				res2 := trimRead(record2, &opt2)
+				ok := len(record1.Seq.Seq) >= minLen && len(record2.Seq.Seq) >= minLen
+				if statsWriter != nil {
+					res1.write(statsWriter, record1, 1, ok)
+					res2.write(statsWriter, record2, 2, ok)
+				}
+				n++
+				if !ok {
+					continue
+				}
+				kept++
+				keptBases += len(record1.Seq.Seq) + len(record2.Seq.Seq)
+				record1.FormatToWriter(outfh1, lineWidth)
+				record2.FormatToWriter(outfh2, lineWidth)
+			}
+		} else {
+			files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+			outfh, err := xopen.Wopen(outFile)
+			checkError(err)
+			defer outfh.Close()
+
+			for _, file := range files {
+				fastxReader, err := fastx.NewReader(alphabet, file, idRegexp)
+				checkError(err)
+				var record *fastx.Record
+				for {
+					record, err = fastxReader.Read()
+					if err != nil {
+						if err == io.EOF {
+							break
+						}
+						checkError(err)
+						break
+					}
+					if fastxReader.IsFastq {
+						lineWidth = 0
+						fastx.ForcelyOutputFastq = true
+					}
+
+					bases += len(record.Seq.Seq)
+					res := trimRead(record, opt)
+					ok := len(record.Seq.Seq) >= minLen
+					if statsWriter != nil {
+						res.write(statsWriter, record, 0, ok)
+					}
+					n++
+					if !ok {
+						continue
+					}
+					kept++
+					keptBases += len(record.Seq.Seq)
+					record.FormatToWriter(outfh, lineWidth)
+				}
+			}
+		}
+
+		if !quiet {
+			log.Infof("%d of %d reads (pairs) kept, %d of %d bases", kept, n, keptBases, bases)
+		}
+	},
+}
+
+type trimOptions struct {
+	crop5, crop3 int
+	front        []string
+	adapters     []string
+	errorRate    float64
+	minOverlap   int
+	minQual      float64
+	window       int
+}
+
+// trimResult holds the bases removed from the ends of a read.
+type trimResult struct {
+	length   int
+	trimmed5 int
+	trimmed3 int
+	adapter  string
+}
+
+func (r *trimResult) write(w *bufio.Writer, record *fastx.Record, mate int, kept bool) {
+	adapter := r.adapter
+	if adapter == "" {
+		adapter = "-"
+	}
+	w.WriteString(fmt.Sprintf("%s\t%d\t%d\t%d\t%d\t%s\t%v\n", record.ID, mate, r.length, r.trimmed5, r.trimmed3, adapter, kept))
+}
+
+// trimRead trims a record in place.
+func trimRead(record *fastx.Record, opt *trimOptions) *trimResult {
+	s := record.Seq.Seq
+	res := &trimResult{length: len(s)}
+	start := opt.crop5
+	if start > len(s) {
+		start = len(s)
+	}
+	end := len(s) - opt.crop3
+	if end < start {
+		end = start
+	}
+
+	for _, a := range opt.front {
+		if pos, ok := findFrontAdapter(s[start:end], []byte(a), opt.errorRate, opt.minOverlap); ok {
+			start += pos
+			res.adapter = a
+			break
+		}
+	}
+	for _, a := range opt.adapters {
+		if pos, ok := find3Adapter(s[start:end], []byte(a), opt.errorRate, opt.minOverlap); ok {
+			end = start + pos
+			if res.adapter != "" {
+				res.adapter += ","
+			}
+			res.adapter += a
+			break
+		}
+	}
+	if opt.minQual > 0 && len(record.Seq.Qual) == len(s) {
+		end = start + qualWindowCut(record.Seq.Qual[start:end], 33, opt.minQual, opt.window)
+	}
+
+	res.trimmed5, res.trimmed3 = start, len(s)-end
+	record.Seq.Seq = s[start:end]
+	if len(record.Seq.Qual) > 0 {
+		record.Seq.Qual = record.Seq.Qual[start:end]
+	}
+	return res
+}
+
+// find3Adapter returns the start of a 3' adapter in a read, which is either
+// fully contained in the read or overlaps its end by at least minOverlap
+// bases, aligned semi-globally with at most errorRate edits per aligned
+// adapter base. The longest alignment with the fewest errors is chosen.
+func find3Adapter(s []byte, adapter []byte, errorRate float64, minOverlap int) (int, bool) {
+	m, n := len(adapter), len(s)
+	if m == 0 || n == 0 {
+		return 0, false
+	}
+	// edit distances of adapter[:i] aligned to a read suffix ending at j,
+	// and the start of the alignment in the read
+	prev, cur := make([]int, m+1), make([]int, m+1)
+	prevStart, curStart := make([]int, m+1), make([]int, m+1)
+	for i := 0; i <= m; i++ {
+		prev[i], prevStart[i] = i, 0
+	}
+	bestLen, bestErr, bestPos := 0, 0, -1
+	check := func(i, errs, start int) {
+		if i < minOverlap || float64(errs) > errorRate*float64(i) {
+			return
+		}
+		if i > bestLen || (i == bestLen && errs < bestErr) {
+			bestLen, bestErr, bestPos = i, errs, start
+		}
+	}
+	check(m, prev[m], 0)
+	for j := 1; j <= n; j++ {
+		cur[0], curStart[0] = 0, j
+		c := s[j-1]
+		if c >= 'a' {
+			c -= 'a' - 'A'
+		}
+		for i := 1; i <= m; i++ {
+			cost := 1
+			if adapter[i-1] == c {
+				cost = 0
+			}
+			cur[i], curStart[i] = prev[i-1]+cost, prevStart[i-1]
+			if d := prev[i] + 1; d < cur[i] { // insertion in the read
+				cur[i], curStart[i] = d, prevStart[i]
+			}
+			if d := cur[i-1] + 1; d < cur[i] { // deletion in the read
+				cur[i], curStart[i] = d, curStart[i-1]
+			}
+		}
+		check(m, cur[m], curStart[m])
+		if j == n { // partial adapters at the end of the read
+			for i := minOverlap; i < m; i++ {
+				check(i, cur[i], curStart[i])
+			}
+		}
+		prev, cur = cur, prev
+		prevStart, curStart = curStart, prevStart
+	}
+	return bestPos, bestPos >= 0
+}
+
+// findFrontAdapter returns the end of a 5' adapter in a read, which is either
+// fully contained in the read or overlaps its start.
+func findFrontAdapter(s []byte, adapter []byte, errorRate float64, minOverlap int) (int, bool) {
+	pos, ok := find3Adapter(reverseBytes(s), reverseBytes(adapter), errorRate, minOverlap)
+	if !ok {
+		return 0, false
+	}
+	return len(s) - pos, true
+}
+
+func reverseBytes(s []byte) []byte {
+	r := make([]byte, len(s))
+	for i, b := range s {
+		r[len(s)-1-i] = b
+	}
+	return r
+}
+
+// qualWindowCut returns the length of a read after cutting it at the first
+// window with a mean quality below minQual, at the first low quality base
+// of the window, and removing the low quality bases at the end. base is the
+// ASCII offset of the qualities.
+func qualWindowCut(qual []byte, base int, minQual float64, window int) int {
+	if window > len(qual) {
+		window = len(qual)
+	}
+	threshold := minQual * float64(window)
+	sum := 0
+	end := len(qual)
+	for i, q := range qual {
+		sum += int(q) - base
+		if i >= window {
+			sum -= int(qual[i-window]) - base
+		}
+		if i >= window-1 && float64(sum) < threshold {
+			// keep the good bases at the start of the window
+			end = i - window + 1
+			for float64(int(qual[end])-base) >= minQual {
+				end++
+			}
+			break
+		}
+	}
+	for end > 0 && float64(int(qual[end-1])-base) < minQual {
+		end--
+	}
+	return end
+}
+
+func upperStrings(list []string) []string {
+	res := make([]string, 0, len(list))
+	for _, s := range list {
+		if s != "" {
+			res = append(res, strings.ToUpper(s))
+		}
+	}
+	return res
+}
+
+func init() {
+	RootCmd.AddCommand(trimCmd)
+
+	trimCmd.Flags().IntP("trim5", "5", 0, "number of bases removed from the 5' end")
+	trimCmd.Flags().IntP("trim3", "3", 0, "number of bases removed from the 3' end")
+	trimCmd.Flags().StringSliceP("front", "g", []string{}, "5' adapters/primers, removed with the bases before them (the first found)")
+	trimCmd.Flags().StringSliceP("adapter", "a", []string{}, "3' adapters, removed with the bases after them (the first found)")
+	trimCmd.Flags().StringSliceP("front2", "G", []string{}, "5' adapters/primers of read2 (default the same as -g/--front)")
+	trimCmd.Flags().StringSliceP("adapter2", "A", []string{}, "3' adapters of read2 (default the same as -a/--adapter)")
+	trimCmd.Flags().Float64P("error-rate", "e", 0.1, "maximum fraction of edits in the aligned adapters")
+	trimCmd.Flags().IntP("min-overlap", "", 3, "minimum overlap of partial adapters at the read ends")
+	trimCmd.Flags().Float64P("min-qual", "q", 0, "minimum mean quality of the sliding windows (0 for no quality trimming)")
+	trimCmd.Flags().IntP("window", "W", 4, "size of the sliding window of quality trimming")
+	trimCmd.Flags().IntP("min-len", "m", 0, "minimum length of the trimmed reads")
+	trimCmd.Flags().StringP("stats", "", "", "save trimmed bases of every read to this tab-delimited file")
+	trimCmd.Flags().StringP("read1", "1", "", "(gzipped) read1 file of paired-end reads")
+	trimCmd.Flags().StringP("read2", "2", "", "(gzipped) read2 file of paired-end reads")
+	trimCmd.Flags().StringP("out-dir", "O", "trimmed", "output directory of paired-end reads")
+	trimCmd.Flags().BoolP("force", "f", false, "overwrite output directory")
+}
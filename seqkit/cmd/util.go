@@ -544,3 +544,69 @@ func checkFileFormat(format string) {
 		log.Fatal("Invalid format specified:", format)
 	}
 }
+
+var baseCode2bit = [256]int8{}
+
+func init() {
+	for i := range baseCode2bit {
+		baseCode2bit[i] = -1
+	}
+	for _, b := range []byte("Aa") {
+		baseCode2bit[b] = 0
+	}
+	for _, b := range []byte("Cc") {
+		baseCode2bit[b] = 1
+	}
+	for _, b := range []byte("Gg") {
+		baseCode2bit[b] = 2
+	}
+	for _, b := range []byte("TtUu") {
+		baseCode2bit[b] = 3
+	}
+}
+
+// ForEachKmer calls fn with the 2-bit encoding of every k-mer (k <= 32) of a
+// nucleotide sequence, skipping k-mers containing non-ACGT bases. The second
+// argument of fn is the 0-based position of the k-mer. If canonical is true,
+// the smaller of the forward and reverse complement encodings is used.
+func ForEachKmer(s []byte, k int, canonical bool, fn func(code uint64, pos int)) {
+	if k <= 0 || k > 32 || len(s) < k {
+		return
+	}
+	mask := uint64(1)<<uint(2*k) - 1
+	if k == 32 {
+		mask = ^uint64(0)
+	}
+	shift := uint(2 * (k - 1))
+	var fwd, rev uint64
+	var valid int
+	for i, b := range s {
+		c := baseCode2bit[b]
+		if c < 0 {
+			valid = 0
+			fwd, rev = 0, 0
+			continue
+		}
+		fwd = (fwd<<2 | uint64(c)) & mask
+		rev = rev>>2 | uint64(3-c)<<shift
+		valid++
+		if valid < k {
+			continue
+		}
+		code := fwd
+		if canonical && rev < fwd {
+			code = rev
+		}
+		fn(code, i-k+1)
+	}
+}
+
+// DecodeKmer returns the bases of a k-mer encoded by ForEachKmer.
+func DecodeKmer(code uint64, k int) []byte {
+	kmer := make([]byte, k)
+	for i := k - 1; i >= 0; i-- {
+		kmer[i] = "ACGT"[code&3]
+		code >>= 2
+	}
+	return kmer
+}
@@ -26,6 +26,7 @@ import (
 	"io"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
@@ -74,7 +75,11 @@ var watchCmd = &cobra.Command{
 		validateSeq := getFlagBool(cmd, "validate-seq")
 		validateSeqLength := getFlagValidateSeqLength(cmd, "validate-seq-length")
 		qBase := getFlagPositiveInt(cmd, "qual-ascii-base")
-		_ = qBase
+		follow := getFlagBool(cmd, "follow")
+		idleTime, err := time.ParseDuration(getFlagString(cmd, "idle-time"))
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of flag -I/--idle-time: %s", err))
+		}
 
 		fields := strings.Split(fieldsText, ",")
 
@@ -173,11 +178,122 @@ var watchCmd = &cobra.Command{
 		var fastxReader *fastx.Reader
 		var count int
 
-		field := fields[0]
+		hists := make([]*thist.Hist, len(fields))
+		resetHists := func() {
+			for i, field := range fields {
+				hists[i] = thist.NewHist([]float64{}, fmap[field].Title, binMode, printBins, true)
+			}
+		}
+		resetHists()
+
+		imgFiles := make([]string, len(fields))
+		if printPdf != "" {
+			for i, field := range fields {
+				if len(fields) == 1 {
+					imgFiles[i] = printPdf
+					continue
+				}
+				ext := filepath.Ext(printPdf)
+				imgFiles[i] = strings.TrimSuffix(printPdf, ext) + "." + field + ext
+			}
+		}
+
+		report := func() {
+			if printDump {
+				for _, h := range hists {
+					os.Stderr.Write([]byte(h.Dump()))
+				}
+			} else if !printQuiet {
+				os.Stderr.Write([]byte(thist.ClearScreenString()))
+				for _, h := range hists {
+					os.Stderr.Write([]byte(h.Draw()))
+				}
+			}
+			if printPdf != "" {
+				for i, h := range hists {
+					h.SaveImage(imgFiles[i])
+				}
+			}
+		}
+
+		process := func(record *fastx.Record) {
+			for i, field := range fields {
+				hists[i].Update(transform(fmap[field].Generate(record)))
+			}
+			count++
+
+			if printFreq > 0 && count%printFreq == 0 {
+				report()
+				time.Sleep(time.Duration(printDelay) * time.Second)
+				if printReset {
+					resetHists()
+				}
+			}
+
+			if !pass {
+				return
+			}
 
-		h := thist.NewHist([]float64{}, fmap[field].Title, binMode, printBins, true)
+			head = record.Name
+
+			if isFastq {
+				outfh.WriteString("@")
+				outfh.Write(head)
+				outfh.WriteString("\n")
+			} else {
+				outfh.WriteString(">")
+				outfh.Write(head)
+				outfh.WriteString("\n")
+			}
+
+			sequence = record.Seq
+
+			if len(sequence.Seq) <= pageSize {
+				outfh.Write(byteutil.WrapByteSlice(sequence.Seq, config.LineWidth))
+			} else {
+				if bufferedByteSliceWrapper == nil {
+					bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(sequence.Seq), config.LineWidth)
+				}
+				text, b = bufferedByteSliceWrapper.Wrap(sequence.Seq, config.LineWidth)
+				outfh.Write(text)
+				outfh.Flush()
+				bufferedByteSliceWrapper.Recycle(b)
+			}
+
+			outfh.WriteString("\n")
+
+			if printQual {
+				outfh.WriteString("+\n")
+
+				if len(sequence.Qual) <= pageSize {
+					outfh.Write(byteutil.WrapByteSlice(sequence.Qual, config.LineWidth))
+				} else {
+					if bufferedByteSliceWrapper == nil {
+						bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(sequence.Qual), config.LineWidth)
+					}
+					text, b = bufferedByteSliceWrapper.Wrap(sequence.Qual, config.LineWidth)
+					outfh.Write(text)
+					outfh.Flush()
+					bufferedByteSliceWrapper.Recycle(b)
+				}
+
+				outfh.WriteString("\n")
+			}
+		}
 
 		for _, file := range files {
+			if follow {
+				if isStdin(file) {
+					checkError(fmt.Errorf("flag -F/--follow does not support stdin, which is streamed anyway"))
+				}
+				isFastq = true
+				printQual = true
+				config.LineWidth = 0
+				followFastq(file, qBase, idleTime, process)
+				config.LineWidth = lineWidth
+				continue
+			}
+
 			fastxReader, err = fastx.NewReader(alphabet, file, idRegexp)
 			checkError(err)
 
@@ -202,95 +318,14 @@ var watchCmd = &cobra.Command{
 					checkSeqType = false
 				}
 
-				p := transform(fmap[field].Generate(record))
-				count++
-				h.Update(p)
-
-				if printFreq > 0 && count%printFreq == 0 {
-					if printDump {
-						os.Stderr.Write([]byte(h.Dump()))
-					} else {
-						if !printQuiet {
-							os.Stderr.Write([]byte(thist.ClearScreenString()))
-							os.Stderr.Write([]byte(h.Draw()))
-						}
-					}
-					if printPdf != "" {
-						h.SaveImage(printPdf)
-					}
-					time.Sleep(time.Duration(printDelay) * time.Second)
-					if printReset {
-						h = thist.NewHist([]float64{}, fmap[field].Title, binMode, printBins, true)
-					}
-				}
-
-				if !pass {
-					continue
-				}
-
-				head = record.Name
-
-				if isFastq {
-					outfh.WriteString("@")
-					outfh.Write(head)
-					outfh.WriteString("\n")
-				} else {
-					outfh.WriteString(">")
-					outfh.Write(head)
-					outfh.WriteString("\n")
-				}
-
-				sequence = record.Seq
-
-				if len(sequence.Seq) <= pageSize {
-					outfh.Write(byteutil.WrapByteSlice(sequence.Seq, config.LineWidth))
-				} else {
-					if bufferedByteSliceWrapper == nil {
-						bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(sequence.Seq), config.LineWidth)
-					}
-					text, b = bufferedByteSliceWrapper.Wrap(sequence.Seq, config.LineWidth)
-					outfh.Write(text)
-					outfh.Flush()
-					bufferedByteSliceWrapper.Recycle(b)
-				}
-
-				outfh.WriteString("\n")
-
-				if printQual {
-					outfh.WriteString("+\n")
-
-					if len(sequence.Qual) <= pageSize {
-						outfh.Write(byteutil.WrapByteSlice(sequence.Qual, config.LineWidth))
-					} else {
-						if bufferedByteSliceWrapper == nil {
-							bufferedByteSliceWrapper = byteutil.NewBufferedByteSliceWrapper2(1, len(sequence.Qual), config.LineWidth)
-						}
-						text, b = bufferedByteSliceWrapper.Wrap(sequence.Qual, config.LineWidth)
-						outfh.Write(text)
-						outfh.Flush()
-						bufferedByteSliceWrapper.Recycle(b)
-					}
-
-					outfh.WriteString("\n")
-				}
-
+				process(record)
 			} // record
 			config.LineWidth = lineWidth
 
 		} //file
 
 		if printFreq < 0 || count%printFreq != 0 {
-			if printDump {
-				os.Stderr.Write([]byte(h.Dump()))
-			} else {
-				if !printQuiet {
-					os.Stderr.Write([]byte(thist.ClearScreenString()))
-					os.Stderr.Write([]byte(h.Draw()))
-				}
-			}
-			if printPdf != "" {
-				h.SaveImage(printPdf)
-			}
+			report()
 		}
 
 		outfh.Close()
@@ -303,7 +338,7 @@ func init() {
 	watchCmd.Flags().BoolP("validate-seq", "v", false, "validate bases according to the alphabet")
 	watchCmd.Flags().BoolP("pass", "x", false, "pass through mode (write input to stdout)")
 	watchCmd.Flags().BoolP("log", "L", false, "log10(x+1) transform numeric values")
-	watchCmd.Flags().StringP("fields", "f", "ReadLen", "target fields, available values: ReadLen, MeanQual, GC, GCSkew")
+	watchCmd.Flags().StringP("fields", "f", "ReadLen", "target fields (comma-separated), available values: ReadLen, MeanQual, GC, GCSkew")
 	watchCmd.Flags().IntP("validate-seq-length", "V", 10000, "length of sequence to validate (0 for whole seq)")
 	watchCmd.Flags().IntP("qual-ascii-base", "b", 33, "ASCII BASE, 33 for Phred+33")
 	watchCmd.Flags().IntP("bins", "B", -1, "number of histogram bins")
@@ -313,6 +348,66 @@ func init() {
 	watchCmd.Flags().BoolP("dump", "y", false, "print histogram data to stderr instead of plotting")
 	watchCmd.Flags().BoolP("list-fields", "H", false, "print out a list of available fields")
 	watchCmd.Flags().IntP("delay", "W", 1, "sleep this many seconds after online plotting")
-	watchCmd.Flags().StringP("img", "O", "", "save histogram to this PDF/image file")
+	watchCmd.Flags().StringP("img", "O", "", "save histogram to this PDF/image file, field names are inserted before the extension for multiple fields")
+	watchCmd.Flags().BoolP("follow", "F", false, "follow growing FASTQ files (e.g., written by a live basecaller), like \"tail -f\"")
+	watchCmd.Flags().StringP("idle-time", "I", "5m", "stop following a file after no new records appeared for this period")
+
+}
 
+// followFastq streams records from a growing FASTQ file,
+// until no new records appeared for the idle period.
+func followFastq(file string, qBase int, idle time.Duration, fn func(*fastx.Record)) {
+	seqChan := make(chan *simpleSeq, 1000)
+	ctrlIn, ctrlOut := NewRawSeqStreamFromFile(file, seqChan, qBase, "fastq", false)
+	if ctrlIn == nil {
+		checkError(fmt.Errorf("failed to follow file: %s", file))
+	}
+
+	handle := func(s *simpleSeq) {
+		if s.Err != nil {
+			log.Warningf("%s: line %d: %s", file, s.StartLine, s.Err)
+			return
+		}
+		qual := make([]byte, len(s.Qual))
+		for i, q := range s.Qual {
+			qual[i] = byte(q + qBase)
+		}
+		record, err := fastx.NewRecordWithQualWithoutValidation(seq.Unlimit,
+			[]byte(s.Id), []byte(s.Id), []byte{}, []byte(s.Seq), qual)
+		checkError(err)
+		fn(record)
+	}
+
+	lastSeen := time.Now()
+	quit := false
+	ctrlIn <- StreamTry
+	for {
+		select {
+		case s := <-seqChan:
+			lastSeen = time.Now()
+			handle(s)
+		case fb := <-ctrlOut:
+			switch fb {
+			case StreamEOF:
+				if !quit && time.Since(lastSeen) >= idle {
+					quit = true
+					ctrlIn <- StreamQuit
+					continue
+				}
+				time.Sleep(10 * BIG_SLEEP)
+				ctrlIn <- StreamTry
+			case StreamExited:
+				for {
+					select {
+					case s := <-seqChan:
+						handle(s)
+					default:
+						return
+					}
+				}
+			default:
+				log.Fatal("Invalid feedback on channel: ", int(fb))
+			}
+		}
+	}
 }
@@ -0,0 +1,280 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shenwei356/xopen"
+)
+
+// Feature is an annotated interval with 0-based, half-open coordinates.
+type Feature struct {
+	Chrom  string
+	Start  int
+	End    int
+	Strand byte // '+', '-' or '.'
+	Type   string
+	Name   string
+}
+
+// Annotation is an index of features supporting overlap queries.
+type Annotation struct {
+	chroms map[string]*featureIndex
+}
+
+// featureIndex keeps the features of a chromosome sorted by start, along with
+// the running maximum of their ends, so that a query can stop scanning as soon
+// as no earlier feature can reach the query start.
+type featureIndex struct {
+	features []*Feature
+	maxEnd   []int
+}
+
+// Query returns the features overlapping the 0-based, half-open interval
+// [start, end) of a chromosome, in order of their start positions.
+func (a *Annotation) Query(chrom string, start, end int) []*Feature {
+	idx, ok := a.chroms[chrom]
+	if !ok {
+		return nil
+	}
+	// the first feature starting at or after the query end
+	i := sort.Search(len(idx.features), func(i int) bool { return idx.features[i].Start >= end })
+	var res []*Feature
+	for i--; i >= 0 && idx.maxEnd[i] > start; i-- {
+		if idx.features[i].End > start {
+			res = append(res, idx.features[i])
+		}
+	}
+	for l, r := 0, len(res)-1; l < r; l, r = l+1, r-1 {
+		res[l], res[r] = res[r], res[l]
+	}
+	return res
+}
+
+// Chroms returns the sorted names of the annotated chromosomes.
+func (a *Annotation) Chroms() []string {
+	chroms := make([]string, 0, len(a.chroms))
+	for c := range a.chroms {
+		chroms = append(chroms, c)
+	}
+	sort.Strings(chroms)
+	return chroms
+}
+
+// Features returns the features of a chromosome sorted by start.
+func (a *Annotation) Features(chrom string) []*Feature {
+	if idx, ok := a.chroms[chrom]; ok {
+		return idx.features
+	}
+	return nil
+}
+
+func newAnnotation(features []*Feature) *Annotation {
+	a := &Annotation{chroms: make(map[string]*featureIndex)}
+	for _, f := range features {
+		idx, ok := a.chroms[f.Chrom]
+		if !ok {
+			idx = &featureIndex{}
+			a.chroms[f.Chrom] = idx
+		}
+		idx.features = append(idx.features, f)
+	}
+	for _, idx := range a.chroms {
+		sort.SliceStable(idx.features, func(i, j int) bool { return idx.features[i].Start < idx.features[j].Start })
+		idx.maxEnd = make([]int, len(idx.features))
+		max := 0
+		for i, f := range idx.features {
+			if f.End > max {
+				max = f.End
+			}
+			idx.maxEnd[i] = max
+		}
+	}
+	return a
+}
+
+// LoadAnnotation reads a GTF, GFF or BED file (optionally gzipped), the
+// format is determined by the file extension.
+func LoadAnnotation(file string) (*Annotation, error) {
+	base := strings.ToLower(file)
+	if strings.HasSuffix(base, ".gz") {
+		base = base[:len(base)-3]
+	}
+	var parse func(fields []string) (*Feature, error)
+	switch filepath.Ext(base) {
+	case ".gtf", ".gff", ".gff2", ".gff3":
+		parse = parseGffLine
+	case ".bed":
+		parse = parseBedLine
+	default:
+		return nil, fmt.Errorf("toolbox: unsupported annotation format (.gtf, .gff, .gff3 or .bed expected): %s", file)
+	}
+
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var features []*Feature
+	reader := bufio.NewReader(fh)
+	lineNum := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		lineNum++
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' && !strings.HasPrefix(line, "track") && !strings.HasPrefix(line, "browser") {
+			f, perr := parse(strings.Split(line, "\t"))
+			if perr != nil {
+				return nil, fmt.Errorf("toolbox: %s line %d: %s", file, lineNum, perr)
+			}
+			features = append(features, f)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return newAnnotation(features), nil
+}
+
+func parseGffLine(fields []string) (*Feature, error) {
+	if len(fields) < 9 {
+		return nil, fmt.Errorf("9 columns expected, %d given", len(fields))
+	}
+	start, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %s", fields[3])
+	}
+	end, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %s", fields[4])
+	}
+	return &Feature{
+		Chrom:  fields[0],
+		Start:  start - 1,
+		End:    end,
+		Strand: strandByte(fields[6]),
+		Type:   fields[2],
+		Name:   gffName(fields[8]),
+	}, nil
+}
+
+// gffName returns the gene name or ID from GTF (key "value";) or
+// GFF3 (key=value;) attributes.
+func gffName(attrs string) string {
+	values := make(map[string]string)
+	for _, attr := range strings.Split(attrs, ";") {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		var k, v string
+		if i := strings.IndexByte(attr, '='); i >= 0 {
+			k, v = attr[:i], attr[i+1:]
+		} else if i := strings.IndexByte(attr, ' '); i >= 0 {
+			k, v = attr[:i], strings.Trim(strings.TrimSpace(attr[i+1:]), `"`)
+		} else {
+			continue
+		}
+		values[k] = v
+	}
+	for _, k := range []string{"gene_name", "Name", "gene_id", "transcript_id", "ID"} {
+		if v, ok := values[k]; ok {
+			return v
+		}
+	}
+	return ""
+}
+
+func parseBedLine(fields []string) (*Feature, error) {
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("at least 3 columns expected, %d given", len(fields))
+	}
+	start, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %s", fields[1])
+	}
+	end, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %s", fields[2])
+	}
+	f := &Feature{Chrom: fields[0], Start: start, End: end, Strand: '.'}
+	if len(fields) > 3 {
+		f.Name = fields[3]
+	}
+	if len(fields) > 5 {
+		f.Strand = strandByte(fields[5])
+	}
+	return f, nil
+}
+
+func strandByte(s string) byte {
+	if s == "+" || s == "-" {
+		return s[0]
+	}
+	return '.'
+}
+
+// AnnotationRegistry loads annotation files on first use and shares them
+// between the tools of a pipeline, so every file is parsed only once.
+// It is safe for concurrent use.
+type AnnotationRegistry struct {
+	mu    sync.Mutex
+	files map[string]*annotationEntry
+}
+
+type annotationEntry struct {
+	once sync.Once
+	ann  *Annotation
+	err  error
+}
+
+// NewAnnotationRegistry returns an empty registry.
+func NewAnnotationRegistry() *AnnotationRegistry {
+	return &AnnotationRegistry{files: make(map[string]*annotationEntry)}
+}
+
+// Get returns the annotation loaded from a file. Concurrent calls for the
+// same file wait for a single load.
+func (r *AnnotationRegistry) Get(file string) (*Annotation, error) {
+	r.mu.Lock()
+	e, ok := r.files[file]
+	if !ok {
+		e = &annotationEntry{}
+		r.files[file] = e
+	}
+	r.mu.Unlock()
+	e.once.Do(func() {
+		e.ann, e.err = LoadAnnotation(file)
+	})
+	return e.ann, e.err
+}
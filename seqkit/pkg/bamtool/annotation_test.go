@@ -0,0 +1,125 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	testGtf = "../../../tests/toolbox/tiny.gtf"
+	testBed = "../../../tests/toolbox/tiny.bed"
+)
+
+func featureNames(features []*Feature) string {
+	names := make([]string, len(features))
+	for i, f := range features {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestLoadAnnotation(t *testing.T) {
+	gtf, err := LoadAnnotation(testGtf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chroms := strings.Join(gtf.Chroms(), ","); chroms != "ctg1,ctg2" {
+		t.Errorf("chroms: got %s", chroms)
+	}
+	f := gtf.Features("ctg1")
+	if len(f) != 1 || f[0].Start != 140 || f[0].End != 160 || f[0].Strand != '+' || f[0].Type != "gene" || f[0].Name != "geneA" {
+		t.Errorf("GTF feature: got %+v", f)
+	}
+	if names := featureNames(gtf.Features("ctg2")); names != "geneB,g2" {
+		t.Errorf("GTF names: got %s", names)
+	}
+
+	bed, err := LoadAnnotation(testBed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = bed.Features("ctg2")
+	if len(f) != 1 || f[0].Start != 0 || f[0].End != 25 || f[0].Strand != '-' || f[0].Name != "ampB" {
+		t.Errorf("BED feature: got %+v", f)
+	}
+
+	if _, err = LoadAnnotation("annotation.txt"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestAnnotationQuery(t *testing.T) {
+	a := newAnnotation([]*Feature{
+		{Chrom: "c", Start: 0, End: 100, Name: "long"},
+		{Chrom: "c", Start: 10, End: 20, Name: "a"},
+		{Chrom: "c", Start: 30, End: 40, Name: "b"},
+		{Chrom: "c", Start: 35, End: 50, Name: "c"},
+		{Chrom: "d", Start: 0, End: 10, Name: "d"},
+	})
+	tests := []struct {
+		chrom      string
+		start, end int
+		want       string
+	}{
+		{"c", 0, 5, "long"},
+		{"c", 20, 30, "long"}, // half-open intervals do not overlap a or b
+		{"c", 19, 31, "long,a,b"},
+		{"c", 38, 39, "long,b,c"},
+		{"c", 45, 60, "long,c"},
+		{"c", 100, 200, ""},
+		{"d", 5, 6, "d"},
+		{"e", 0, 10, ""},
+	}
+	for _, test := range tests {
+		if got := featureNames(a.Query(test.chrom, test.start, test.end)); got != test.want {
+			t.Errorf("Query(%s, %d, %d): got %q, want %q", test.chrom, test.start, test.end, got, test.want)
+		}
+	}
+}
+
+func TestAnnotationRegistry(t *testing.T) {
+	reg := NewAnnotationRegistry()
+	anns := make([]*Annotation, 8)
+	var wg sync.WaitGroup
+	for i := range anns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ann, err := reg.Get(testBed)
+			if err != nil {
+				t.Error(err)
+			}
+			anns[i] = ann
+		}(i)
+	}
+	wg.Wait()
+	for _, ann := range anns[1:] {
+		if ann != anns[0] {
+			t.Fatal("the annotation is loaded more than once")
+		}
+	}
+	if _, err := reg.Get("missing.bed"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package bamtool implements a streaming pipeline of tools acting on BAM
+// records (the seqkit bam toolbox). Tools are registered in a Toolshed and
+// connected through channels, in the order given in a YAML configuration.
+//
+// A custom tool reads records from its input channel, sends the records to
+// be kept to its output channel and closes the output channel at the end:
+//
+//	bamtool.Register(bamtool.Tool{
+//		Name:   "MinMapQ",
+//		Desc:   "filter records by mapping quality",
+//		Params: []string{"Min"},
+//		Use: func(p *bamtool.Params) {
+//			min, _ := p.Yaml.Get("Min").Int()
+//			for r := range p.InChan {
+//				if int(r.MapQ) >= min {
+//					p.OutChan <- r
+//				}
+//			}
+//			close(p.OutChan)
+//		},
+//	})
+//
+//	shed := bamtool.NewToolshed()
+//	conf, tools, err := bamtool.LoadConfig([]byte("{MinMapQ: {Min: 10}}"), shed)
+//	in, reader, err := bamtool.NewReaderChan("input.bam", 5000, 1<<17, 4, bam.None)
+//	out, done, err := bamtool.NewWriterChan("-", reader.Header(), 5000, 1<<17, 4, -1)
+//	err = shed.Pipeline(conf, tools, in, out, bamtool.Options{ChanCap: 5000, Threads: 4})
+//	<-done
+//
+// Tools needing a GTF, GFF or BED annotation should load it through
+// Params.Annotations, which is shared by all tools of a pipeline, so that the
+// file is parsed only once:
+//
+//	ann, err := p.Annotations.Get(file)
+//	for _, f := range ann.Query(r.Ref.Name(), r.Pos, r.End()) {
+//		...
+//	}
+package bamtool
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/biogo/hts/sam"
+	syaml "github.com/smallfish/simpleyaml"
+)
+
+// Tool is a named stage of a BAM toolbox pipeline.
+type Tool struct {
+	Name string
+	Desc string
+	// Use consumes records from Params.InChan and must close Params.OutChan when done.
+	Use func(params *Params)
+	// Params lists the valid configuration fields of the tool, nil disables validation.
+	Params []string
+	// NeedsVarData is set for tools using the sequences, qualities or
+	// auxiliary tags of the records, which are not decoded in count-only mode.
+	NeedsVarData bool
+}
+
+// Params holds the configuration and the channels of a running tool.
+type Params struct {
+	Yaml    *syaml.Yaml
+	InChan  chan *sam.Record
+	OutChan chan *sam.Record
+	Header  *sam.Header
+	Quiet   bool
+	Silent  bool
+	Threads int
+	Rank    int
+	Shed    Toolshed
+	// CountOnly is set when the sequences, qualities and tags of the records are not decoded.
+	CountOnly bool
+	// Sink is set when the records are not written at the end of the pipeline.
+	Sink bool
+	// Annotations is shared by the tools of a pipeline, see AnnotationRegistry.
+	Annotations *AnnotationRegistry
+}
+
+// Toolshed is a collection of tools indexed by name.
+type Toolshed map[string]Tool
+
+func (s Toolshed) String() string {
+	tools := make([]string, 0, len(s))
+	for t, _ := range s {
+		tools = append(tools, t)
+	}
+	sort.Strings(tools)
+	res := "Tool\tDescription\n"
+	res += "----\t-----------\n"
+	for _, t := range tools {
+		res += fmt.Sprintf("%s\t%s\n", s[t].Name, s[t].Desc)
+	}
+	return res
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(Toolshed)
+)
+
+// Register makes a tool available in the toolsheds created by NewToolshed.
+// It panics if a tool with the same name is already registered.
+func Register(tool Tool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[tool.Name]; dup {
+		panic("bamtool: Register called twice for tool " + tool.Name)
+	}
+	registry[tool.Name] = tool
+}
+
+// NewToolshed returns a toolshed holding all registered tools.
+func NewToolshed() Toolshed {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	ts := make(Toolshed, len(registry))
+	for k, v := range registry {
+		ts[k] = v
+	}
+	return ts
+}
+
+// Options are the settings shared by all tools of a pipeline.
+type Options struct {
+	Header  *sam.Header
+	Quiet   bool
+	Silent  bool
+	Threads int
+	ChanCap int
+	// CountOnly signals the tools that the records lack sequences, qualities and tags.
+	CountOnly bool
+	// Sink signals the tools that the records are discarded at the end of the pipeline.
+	Sink bool
+	// Annotations is shared by all tools, a new registry is created if nil.
+	Annotations *AnnotationRegistry
+}
+
+// Pipeline starts the tools in the given order, feeding the records from
+// inChan to the first tool and connecting the last tool to outChan.
+// The tools run in their own goroutines, the end of the pipeline is signaled
+// by the closing of outChan.
+func (s Toolshed) Pipeline(conf *syaml.Yaml, tools []string, inChan, outChan chan *sam.Record, opts Options) error {
+	if len(tools) == 0 {
+		return fmt.Errorf("toolbox: no tool specified")
+	}
+	for _, tool := range tools {
+		if _, ok := s[tool]; !ok {
+			return fmt.Errorf("toolbox: unknown tool: %s", tool)
+		}
+	}
+	if opts.Annotations == nil {
+		opts.Annotations = NewAnnotationRegistry()
+	}
+	nextIn := inChan
+	for rank, tool := range tools {
+		nextOut := make(chan *sam.Record, opts.ChanCap)
+		if rank == len(tools)-1 {
+			nextOut = outChan
+		}
+		params := &Params{
+			Yaml:        conf.Get(tool),
+			InChan:      nextIn,
+			OutChan:     nextOut,
+			Header:      opts.Header,
+			Quiet:       opts.Quiet,
+			Silent:      opts.Silent,
+			Threads:     opts.Threads,
+			Rank:        rank,
+			Shed:        s,
+			CountOnly:   opts.CountOnly,
+			Sink:        opts.Sink,
+			Annotations: opts.Annotations,
+		}
+		nextIn = nextOut
+		go s[tool].Use(params)
+	}
+	return nil
+}
+
+// ErrorHandler is called with errors occurring in the goroutines of the
+// reader and writer channels. The default handler prints the error and exits.
+var ErrorHandler = func(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(-1)
+}
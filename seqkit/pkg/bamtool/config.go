@@ -0,0 +1,202 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"fmt"
+	"sort"
+
+	syaml "github.com/smallfish/simpleyaml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SchemaVersion is the version of the toolbox YAML schema
+// understood by this package. Configs without a SchemaVersion
+// field are treated as version 1.
+const SchemaVersion = 2
+
+// ParamFields are the top level configuration fields which are not tools.
+var ParamFields = map[string]bool{
+	"Sink":          true,
+	"SchemaVersion": true,
+	"ReaderThreads": true,
+	"WriterThreads": true,
+	"CompressLevel": true,
+	"SortOutput":    true,
+	"SortChunk":     true,
+}
+
+// Migration upgrades a config from one schema version to the next.
+type Migration func(conf yaml.MapSlice) yaml.MapSlice
+
+// migrations holds the migrations from version N (the key) to N+1.
+var migrations = map[int]Migration{
+	1: migrateStranded,
+}
+
+// RegisterMigration registers the migration of configs from version to version+1.
+func RegisterMigration(version int, m Migration) {
+	migrations[version] = m
+}
+
+// LoadConfig parses a toolbox YAML config, migrates it to the current
+// schema version and validates the tools and their parameters against the toolshed.
+// It returns the parsed config and the tools in pipeline order.
+func LoadConfig(b []byte, shed Toolshed) (*syaml.Yaml, []string, error) {
+	var conf yaml.MapSlice
+	if err := yaml.Unmarshal(b, &conf); err != nil {
+		return nil, nil, err
+	}
+
+	version := 1
+	for _, item := range conf {
+		if item.Key != "SchemaVersion" {
+			continue
+		}
+		v, ok := item.Value.(int)
+		if !ok {
+			return nil, nil, fmt.Errorf("toolbox: invalid SchemaVersion: %v", item.Value)
+		}
+		version = v
+	}
+	if version < 1 || version > SchemaVersion {
+		return nil, nil, fmt.Errorf("toolbox: unsupported SchemaVersion %d (supported: 1-%d)", version, SchemaVersion)
+	}
+	conf, err := migrateConfig(conf, version, SchemaVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateConfig(conf, shed); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(conf))
+	for _, item := range conf {
+		if k, ok := item.Key.(string); ok && !ParamFields[k] {
+			keys = append(keys, k)
+		}
+	}
+
+	nb, err := yaml.Marshal(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	y, err := syaml.NewYaml(nb)
+	return y, keys, err
+}
+
+// migrateConfig applies the registered migrations to upgrade a config
+// from one schema version to another. Every step must have a migration.
+func migrateConfig(conf yaml.MapSlice, from, to int) (yaml.MapSlice, error) {
+	for v := from; v < to; v++ {
+		m, ok := migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("toolbox: no migration from SchemaVersion %d to %d", v, v+1)
+		}
+		conf = m(conf)
+	}
+	return setMapSliceValue(conf, "SchemaVersion", to), nil
+}
+
+// migrateStranded renames the Stranded parameter of AlnContext to StrandAware
+// (version 1 to 2).
+func migrateStranded(conf yaml.MapSlice) yaml.MapSlice {
+	for _, item := range conf {
+		if item.Key != "AlnContext" {
+			continue
+		}
+		params, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		for j, p := range params {
+			if p.Key == "Stranded" {
+				params[j].Key = "StrandAware"
+			}
+		}
+	}
+	return conf
+}
+
+// validateConfig checks that all tools exist and only use the parameters they declare.
+func validateConfig(conf yaml.MapSlice, shed Toolshed) error {
+	for _, item := range conf {
+		name, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("toolbox: invalid key: %v", item.Key)
+		}
+		if ParamFields[name] {
+			continue
+		}
+		tool, ok := shed[name]
+		if !ok {
+			return fmt.Errorf("toolbox: unknown tool: %s", name)
+		}
+		if tool.Params == nil {
+			continue
+		}
+		params, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		valid := make(map[string]bool, len(tool.Params))
+		for _, p := range tool.Params {
+			valid[p] = true
+		}
+		for _, p := range params {
+			pn, _ := p.Key.(string)
+			if !valid[pn] {
+				known := append([]string{}, tool.Params...)
+				sort.Strings(known)
+				return fmt.Errorf("toolbox: unknown parameter for %s: %v (valid parameters: %v)", name, p.Key, known)
+			}
+		}
+	}
+	return nil
+}
+
+// setMapSliceValue sets the value of a key in a MapSlice, appending it if missing.
+func setMapSliceValue(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// OrderedMapKeys returns the top level keys of a YAML mapping in the order
+// they appear in the document, which defines the order of the pipeline.
+func OrderedMapKeys(b []byte) ([]string, error) {
+	var ms yaml.MapSlice
+	if err := yaml.Unmarshal(b, &ms); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(ms))
+	for _, item := range ms {
+		if k, ok := item.Key.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
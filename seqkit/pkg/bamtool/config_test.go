@@ -0,0 +1,147 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func testShed() Toolshed {
+	return Toolshed{
+		"Count":  Tool{Name: "Count"},
+		"Filter": Tool{Name: "Filter", Params: []string{"MinMapQ"}},
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = map[int]Migration{}
+
+	// a fake migration renaming a tool parameter between versions 1 and 2
+	RegisterMigration(1, func(conf yaml.MapSlice) yaml.MapSlice {
+		for i, item := range conf {
+			if item.Key != "Filter" {
+				continue
+			}
+			params := item.Value.(yaml.MapSlice)
+			for j, p := range params {
+				if p.Key == "MinQ" {
+					params[j].Key = "MinMapQ"
+				}
+			}
+			conf[i].Value = params
+		}
+		return conf
+	})
+
+	var conf yaml.MapSlice
+	if err := yaml.Unmarshal([]byte("Filter: {MinQ: 10}\nCount:\n"), &conf); err != nil {
+		t.Fatal(err)
+	}
+	conf, err := migrateConfig(conf, 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err = validateConfig(conf, testShed()); err != nil {
+		t.Errorf("migrated config is invalid: %s", err)
+	}
+	b, err := yaml.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); !strings.Contains(s, "MinMapQ: 10") || !strings.Contains(s, "SchemaVersion: 2") {
+		t.Errorf("migration not applied:\n%s", s)
+	}
+
+	// no migration registered from version 2 to 3
+	if _, err = migrateConfig(conf, 1, 3); err == nil {
+		t.Error("expected an error for a missing migration step")
+	}
+}
+
+func TestMigrateStranded(t *testing.T) {
+	shed := Toolshed{"AlnContext": Tool{Name: "AlnContext", Params: []string{"Tsv", "StrandAware"}}}
+	y, _, err := LoadConfig([]byte("AlnContext: {Tsv: x.tsv, Stranded: true}\n"), shed)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if v, err := y.Get("AlnContext").Get("StrandAware").Bool(); err != nil || !v {
+		t.Errorf("Stranded not renamed to StrandAware: %v, %v", v, err)
+	}
+
+	// version 2 configs are not migrated
+	if _, _, err = LoadConfig([]byte("SchemaVersion: 2\nAlnContext: {Stranded: true}\n"), shed); err == nil {
+		t.Error("expected an error for Stranded in a version 2 config")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	_, tools, err := LoadConfig([]byte("SchemaVersion: 1\nFilter: {MinMapQ: 10}\nSink: true\nCount:\n"), testShed())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(tools, ",") != "Filter,Count" {
+		t.Errorf("tools: got %v, want [Filter Count]", tools)
+	}
+
+	if _, _, err = LoadConfig([]byte("Count:\n"), testShed()); err != nil {
+		t.Errorf("config without SchemaVersion: unexpected error: %s", err)
+	}
+
+	bad := []string{
+		"SchemaVersion: 0\nCount:\n",
+		"SchemaVersion: 3\nCount:\n",
+		"SchemaVersion: \"x\"\nCount:\n",
+		"Unknown:\n",
+		"Filter: {MinQ: 10}\n",
+	}
+	for _, c := range bad {
+		if _, _, err = LoadConfig([]byte(c), testShed()); err == nil {
+			t.Errorf("expected an error for config: %q", c)
+		}
+	}
+}
+
+func FuzzLoadConfig(f *testing.F) {
+	f.Add([]byte("SchemaVersion: 1\nFilter: {MinMapQ: 10}\nSink: true\nCount:\n"))
+	f.Add([]byte("{Filter: {MinMapQ: 10}, Count: {}, Sink: True}"))
+	f.Add([]byte("SchemaVersion: [1]\nFilter: [MinMapQ]\n"))
+	f.Add([]byte("Filter: {MinMapQ: 10, MinMapQ: 20}\n"))
+	f.Add([]byte("? [Count]\n: x\n"))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		y, tools, err := LoadConfig(b, testShed())
+		if err != nil {
+			return
+		}
+		if y == nil {
+			t.Fatal("nil config without an error")
+		}
+		for _, tool := range tools {
+			if _, ok := testShed()[tool]; !ok {
+				t.Errorf("unknown tool accepted: %s", tool)
+			}
+		}
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// NewReaderChan reads BAM records from a file ("-" for stdin, HTTP(S) and S3
+// URLs are streamed by OpenRemote) into a channel, which is closed at the end
+// of the input. The omit argument is passed to bam.Reader.Omit, so
+// bam.AllVariableLengthData skips decoding the sequences, qualities and
+// optional fields when they are not needed downstream.
+func NewReaderChan(inFile string, cp int, buff int, threads int, omit int) (chan *sam.Record, *bam.Reader, error) {
+	outChan := make(chan *sam.Record, cp)
+	var fh io.ReadCloser = os.Stdin
+	var err error
+	if IsRemote(inFile) {
+		fh, err = OpenRemote(inFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if inFile != "-" {
+		fh, err = os.Open(inFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	r, err := bam.NewReader(bufio.NewReaderSize(fh, buff), threads)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Omit(omit)
+	go func() {
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				close(outChan)
+				return
+			}
+			if err != nil {
+				close(outChan)
+				ErrorHandler(err)
+				return
+			}
+			outChan <- rec
+		}
+	}()
+	return outChan, r, nil
+}
+
+// NewSinkChan returns a channel discarding all records and a channel
+// signaling when the input channel was closed.
+func NewSinkChan(cp int) (chan *sam.Record, chan bool) {
+	outChan := make(chan *sam.Record, cp)
+	doneChan := make(chan bool, 0)
+	go func() {
+		for rec := range outChan {
+			_ = rec
+		}
+		doneChan <- true
+	}()
+
+	return outChan, doneChan
+}
+
+// NewWriterChan returns a channel writing records to a BAM file ("-" for
+// stdout) and a channel signaling when all records were written. The output
+// is written to a temporary file next to outFile, which is renamed when
+// complete, so an interrupted run never leaves a truncated BAM file behind.
+// The temporary file is removed if writing fails.
+// The BGZF compression level is 0 to 9, or -1 for the default level.
+func NewWriterChan(outFile string, head *sam.Header, cp int, buff int, threads int, level int) (chan *sam.Record, chan bool, error) {
+	if level < -1 || level > 9 {
+		return nil, nil, fmt.Errorf("invalid compression level: %d", level)
+	}
+	outChan := make(chan *sam.Record, cp)
+	doneChan := make(chan bool, 0)
+	fh, err := os.Stdout, error(nil)
+	var tmpFile string
+	if outFile != "-" {
+		tmpFile = fmt.Sprintf("%s.tmp%d", outFile, os.Getpid())
+		fh, err = os.OpenFile(tmpFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	bio := bufio.NewWriterSize(fh, buff)
+	w, err := bam.NewWriterLevel(bio, head, level, threads)
+	if err != nil {
+		if tmpFile != "" {
+			fh.Close()
+			os.Remove(tmpFile)
+		}
+		return nil, nil, err
+	}
+	// the temporary file is removed before reporting an error, as
+	// ErrorHandler might exit
+	fail := func(err error) {
+		if tmpFile != "" {
+			fh.Close()
+			os.Remove(tmpFile)
+		}
+		ErrorHandler(err)
+	}
+	go func() {
+		defer func() {
+			doneChan <- true
+		}()
+		for rec := range outChan {
+			if err := w.Write(rec); err != nil {
+				fail(err)
+				for range outChan {
+				}
+				return
+			}
+		}
+		if err := w.Close(); err != nil {
+			fail(err)
+			return
+		}
+		if err := bio.Flush(); err != nil {
+			fail(err)
+			return
+		}
+		if tmpFile != "" {
+			if err := fh.Close(); err != nil {
+				fail(err)
+				return
+			}
+			if err := os.Rename(tmpFile, outFile); err != nil {
+				fail(err)
+				return
+			}
+		}
+	}()
+	return outChan, doneChan, nil
+}
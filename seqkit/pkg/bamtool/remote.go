@@ -0,0 +1,121 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// RemoteRetries is the number of times a remote input is reopened, at the
+// position reached, after the connection was lost.
+var RemoteRetries = 5
+
+// IsRemote tells whether a file name is an HTTP(S) or S3 URL.
+func IsRemote(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") || strings.HasPrefix(file, "s3://")
+}
+
+// S3ToHTTPS translates an s3://bucket/key URL to the HTTPS URL of the object.
+// The endpoint is taken from the environment variable AWS_ENDPOINT_URL
+// (path-style URL), or is the virtual-hosted AWS endpoint of the region in
+// AWS_REGION. Requests are not signed, so the object must be public, or a
+// presigned HTTPS URL should be used instead. Other URLs are returned as is.
+func S3ToHTTPS(url string) string {
+	if !strings.HasPrefix(url, "s3://") {
+		return url
+	}
+	path := strings.TrimPrefix(url, "s3://")
+	bucket, key := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(endpoint, "/"), bucket, key)
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, key)
+	}
+	return fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucket, key)
+}
+
+// OpenRemote streams an HTTP(S) or S3 URL. If the connection is lost, the
+// download is resumed with a ranged request from the last position read.
+func OpenRemote(url string) (io.ReadCloser, error) {
+	r := &remoteReader{url: S3ToHTTPS(url)}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type remoteReader struct {
+	url     string
+	body    io.ReadCloser
+	pos     int64
+	retries int
+}
+
+func (r *remoteReader) open() error {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return err
+	}
+	if r.pos > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.pos))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if (r.pos == 0 && resp.StatusCode != http.StatusOK) || (r.pos > 0 && resp.StatusCode != http.StatusPartialContent) {
+		resp.Body.Close()
+		return fmt.Errorf("failed to open %s: %s", r.url, resp.Status)
+	}
+	r.body = resp.Body
+	return nil
+}
+
+func (r *remoteReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.pos += int64(n)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	// resume after a lost connection
+	r.body.Close()
+	for r.retries < RemoteRetries {
+		r.retries++
+		time.Sleep(time.Duration(r.retries) * time.Second)
+		if oerr := r.open(); oerr == nil {
+			return n, nil
+		}
+	}
+	return n, fmt.Errorf("reading %s: %s", r.url, err)
+}
+
+func (r *remoteReader) Close() error {
+	return r.body.Close()
+}
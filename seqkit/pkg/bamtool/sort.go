@@ -0,0 +1,251 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package bamtool
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// DefaultSortChunk is the default number of records sorted in memory before
+// spilling them to a temporary BAM file.
+const DefaultSortChunk = 1000000
+
+// recordLess returns the ordering function of records for a sort order
+// ("coordinate" or "name").
+func recordLess(sortBy string) (func(a, b *sam.Record) bool, sam.SortOrder, error) {
+	switch sortBy {
+	case "coordinate":
+		return func(a, b *sam.Record) bool {
+			ra, rb := a.RefID(), b.RefID()
+			if ra != rb {
+				// unmapped records with no reference go to the end
+				if ra < 0 {
+					return false
+				}
+				if rb < 0 {
+					return true
+				}
+				return ra < rb
+			}
+			if a.Pos != b.Pos {
+				return a.Pos < b.Pos
+			}
+			return a.Flags&sam.Reverse < b.Flags&sam.Reverse
+		}, sam.Coordinate, nil
+	case "name":
+		return func(a, b *sam.Record) bool {
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Flags&(sam.Read1|sam.Read2) < b.Flags&(sam.Read1|sam.Read2)
+		}, sam.QueryName, nil
+	}
+	return nil, 0, fmt.Errorf("toolbox: invalid sort order: %s", sortBy)
+}
+
+// NewSortingWriterChan returns a channel writing records sorted by coordinate
+// or name to a BAM file ("-" for stdout) and a channel signaling when all
+// records were written. Records are sorted in chunks of chunkSize records,
+// which are spilled to temporary BAM files and merged at the end of the input.
+// The sort order in the @HD line of the header is updated accordingly.
+func NewSortingWriterChan(outFile string, head *sam.Header, cp int, buff int, threads int, level int, sortBy string, chunkSize int) (chan *sam.Record, chan bool, error) {
+	less, order, err := recordLess(sortBy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultSortChunk
+	}
+	sortedHead := head.Clone()
+	sortedHead.SortOrder = order
+	outChan, outDone, err := NewWriterChan(outFile, sortedHead, cp, buff, threads, level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inChan := make(chan *sam.Record, cp)
+	doneChan := make(chan bool, 0)
+	go func() {
+		var tmpDir string
+		var chunks []string
+		records := make([]*sam.Record, 0, chunkSize)
+		sortRecords := func() {
+			sort.SliceStable(records, func(i, j int) bool { return less(records[i], records[j]) })
+		}
+		for rec := range inChan {
+			records = append(records, rec)
+			if len(records) < chunkSize {
+				continue
+			}
+			if tmpDir == "" {
+				dir, err := ioutil.TempDir("", "seqkit_bam_sort")
+				if err != nil {
+					ErrorHandler(err)
+				}
+				tmpDir = dir
+			}
+			sortRecords()
+			chunk := filepath.Join(tmpDir, fmt.Sprintf("chunk_%d.bam", len(chunks)))
+			if err := writeChunk(chunk, head, records, threads); err != nil {
+				ErrorHandler(err)
+			}
+			chunks = append(chunks, chunk)
+			records = records[:0]
+		}
+		sortRecords()
+
+		if len(chunks) == 0 {
+			for _, rec := range records {
+				outChan <- rec
+			}
+		} else {
+			if err := mergeChunks(chunks, records, less, outChan, threads); err != nil {
+				ErrorHandler(err)
+			}
+			os.RemoveAll(tmpDir)
+		}
+		close(outChan)
+		<-outDone
+		doneChan <- true
+	}()
+	return inChan, doneChan, nil
+}
+
+// writeChunk writes sorted records to a temporary BAM file.
+func writeChunk(file string, head *sam.Header, records []*sam.Record, threads int) error {
+	fh, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(fh)
+	w, err := bam.NewWriter(bw, head, threads)
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+// mergeItem is the current record of a sorted source during merging.
+type mergeItem struct {
+	rec *sam.Record
+	src int
+}
+
+type mergeHeap struct {
+	items []mergeItem
+	less  func(a, b *sam.Record) bool
+}
+
+func (h *mergeHeap) Len() int { return len(h.items) }
+func (h *mergeHeap) Less(i, j int) bool {
+	if h.less(h.items[i].rec, h.items[j].rec) {
+		return true
+	}
+	if h.less(h.items[j].rec, h.items[i].rec) {
+		return false
+	}
+	return h.items[i].src < h.items[j].src // keep the input order of equal records
+}
+func (h *mergeHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *mergeHeap) Push(x interface{}) { h.items = append(h.items, x.(mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items = h.items[:n-1]
+	return item
+}
+
+// mergeChunks merges the sorted chunk files and the last in-memory chunk.
+func mergeChunks(chunks []string, last []*sam.Record, less func(a, b *sam.Record) bool, outChan chan *sam.Record, threads int) error {
+	readers := make([]*bam.Reader, len(chunks))
+	for i, chunk := range chunks {
+		fh, err := os.Open(chunk)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		readers[i], err = bam.NewReader(bufio.NewReader(fh), threads)
+		if err != nil {
+			return err
+		}
+		defer readers[i].Close()
+	}
+	lastIdx := 0
+	next := func(src int) (*sam.Record, error) {
+		if src == len(readers) {
+			if lastIdx == len(last) {
+				return nil, io.EOF
+			}
+			lastIdx++
+			return last[lastIdx-1], nil
+		}
+		return readers[src].Read()
+	}
+
+	h := &mergeHeap{less: less}
+	for src := 0; src <= len(readers); src++ {
+		rec, err := next(src)
+		if err == io.EOF {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h.items = append(h.items, mergeItem{rec, src})
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		item := h.items[0]
+		outChan <- item.rec
+		rec, err := next(item.src)
+		if err == io.EOF {
+			heap.Pop(h)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		h.items[0] = mergeItem{rec, item.src}
+		heap.Fix(h, 0)
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package bigwig writes bigWig files of bedGraph-type intervals, as specified
+// in the supplement of Kent et al. (2010) "BigWig and BigBed: enabling
+// browsing of large distributed datasets", Bioinformatics 26(17).
+//
+// The whole file is assembled in memory (the data sections compressed), no
+// zoom levels are written.
+package bigwig
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	bigWigMagic    = 0x888FFC26
+	chromTreeMagic = 0x78CA8C91
+	cirTreeMagic   = 0x2468ACE0
+	version        = 4
+	headerSize     = 64
+	summarySize    = 40
+
+	// ItemsPerSection is the maximum number of intervals in a data section.
+	ItemsPerSection = 1024
+	// BlockSize is the maximum number of children of an index node.
+	BlockSize = 256
+)
+
+// Chrom is a reference sequence.
+type Chrom struct {
+	Name string
+	Size uint32
+}
+
+// Interval is a bedGraph item with 0-based, half-open coordinates.
+type Interval struct {
+	Start uint32
+	End   uint32
+	Value float32
+}
+
+// section is a compressed data block and its index entry.
+type section struct {
+	chromID    uint32
+	start, end uint32
+	data       []byte
+	offset     uint64
+	rawSize    int
+}
+
+// Write writes a bigWig file. The intervals of each chromosome must be
+// sorted and non-overlapping, chromosomes without intervals are allowed.
+func Write(w io.Writer, chroms []Chrom, data map[string][]Interval) error {
+	chroms = append([]Chrom(nil), chroms...)
+	sort.Slice(chroms, func(i, j int) bool { return chroms[i].Name < chroms[j].Name })
+	keySize := 1
+	for i, c := range chroms {
+		if i > 0 && c.Name == chroms[i-1].Name {
+			return fmt.Errorf("bigwig: duplicated chromosome: %s", c.Name)
+		}
+		if len(c.Name) > keySize {
+			keySize = len(c.Name)
+		}
+	}
+
+	// data sections and the total summary
+	var sections []*section
+	var covered uint64
+	minVal, maxVal, sum, sumSquares := math.Inf(1), math.Inf(-1), 0.0, 0.0
+	maxRaw := 0
+	for id, c := range chroms {
+		items := data[c.Name]
+		for i, it := range items {
+			if it.End <= it.Start || it.End > c.Size {
+				return fmt.Errorf("bigwig: invalid interval %s:%d-%d", c.Name, it.Start, it.End)
+			}
+			if i > 0 && it.Start < items[i-1].End {
+				return fmt.Errorf("bigwig: unsorted or overlapping intervals at %s:%d", c.Name, it.Start)
+			}
+			l := float64(it.End - it.Start)
+			v := float64(it.Value)
+			covered += uint64(it.End - it.Start)
+			minVal = math.Min(minVal, v)
+			maxVal = math.Max(maxVal, v)
+			sum += v * l
+			sumSquares += v * v * l
+		}
+		for i := 0; i < len(items); i += ItemsPerSection {
+			j := i + ItemsPerSection
+			if j > len(items) {
+				j = len(items)
+			}
+			s, err := newSection(uint32(id), items[i:j])
+			if err != nil {
+				return err
+			}
+			if s.rawSize > maxRaw {
+				maxRaw = s.rawSize
+			}
+			sections = append(sections, s)
+		}
+	}
+	if covered == 0 {
+		minVal, maxVal = 0, 0
+	}
+
+	chromTreeOffset := uint64(headerSize + summarySize)
+	chromLevels := chromTreeLevels(len(chroms))
+	chromTreeSize := uint64(32)
+	for _, nodes := range chromLevels {
+		for _, n := range nodes {
+			chromTreeSize += uint64(4 + n*(keySize+8))
+		}
+	}
+	dataOffset := chromTreeOffset + chromTreeSize
+	offset := dataOffset + 8
+	for _, s := range sections {
+		s.offset = offset
+		offset += uint64(len(s.data))
+	}
+	indexOffset := offset
+
+	buf := new(bytes.Buffer)
+	le := binary.LittleEndian
+	put := func(v interface{}) { binary.Write(buf, le, v) }
+
+	// header
+	put(uint32(bigWigMagic))
+	put(uint16(version))
+	put(uint16(0)) // zoom levels
+	put(chromTreeOffset)
+	put(dataOffset)
+	put(indexOffset)
+	put(uint16(0)) // field count
+	put(uint16(0)) // defined field count
+	put(uint64(0)) // autoSql offset
+	put(uint64(headerSize))
+	put(uint32(maxRaw))
+	put(uint64(0)) // extension offset
+
+	// total summary
+	put(covered)
+	put(minVal)
+	put(maxVal)
+	put(sum)
+	put(sumSquares)
+
+	// chromosome B+ tree
+	writeChromTree(buf, chroms, keySize, chromLevels, chromTreeOffset)
+
+	// data
+	put(uint64(len(sections)))
+	for _, s := range sections {
+		buf.Write(s.data)
+	}
+
+	writeIndex(buf, sections, indexOffset)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// chromTreeLevels returns the number of items of the nodes of the
+// chromosome B+ tree for n chromosomes, level by level from the leaves.
+func chromTreeLevels(n int) [][]int {
+	var levels [][]int
+	for {
+		var nodes []int
+		for i := 0; i < n; i += BlockSize {
+			if n-i < BlockSize {
+				nodes = append(nodes, n-i)
+			} else {
+				nodes = append(nodes, BlockSize)
+			}
+		}
+		if len(nodes) == 0 {
+			nodes = []int{0}
+		}
+		levels = append(levels, nodes)
+		if len(nodes) == 1 {
+			return levels
+		}
+		n = len(nodes)
+	}
+}
+
+// writeChromTree writes the chromosome B+ tree level by level from the root.
+// Every item of a non-leaf node holds the first key of its child.
+func writeChromTree(buf *bytes.Buffer, chroms []Chrom, keySize int, levels [][]int, offset uint64) {
+	le := binary.LittleEndian
+	put := func(v interface{}) { binary.Write(buf, le, v) }
+	key := func(name string) []byte {
+		k := make([]byte, keySize)
+		copy(k, name)
+		return k
+	}
+
+	put(uint32(chromTreeMagic))
+	put(uint32(BlockSize))
+	put(uint32(keySize))
+	put(uint32(8))
+	put(uint64(len(chroms)))
+	put(uint64(0))
+
+	// offsets of the first node of every level
+	levelOffsets := make([]uint64, len(levels))
+	offset += 32
+	for l := len(levels) - 1; l >= 0; l-- {
+		levelOffsets[l] = offset
+		for _, n := range levels[l] {
+			offset += uint64(4 + n*(keySize+8))
+		}
+	}
+
+	// the number of chromosomes below a node of a level
+	span := 1
+	spans := make([]int, len(levels))
+	for l := range levels {
+		spans[l] = span
+		span *= BlockSize
+	}
+
+	for l := len(levels) - 1; l >= 0; l-- {
+		childOffset, child := uint64(0), 0
+		if l > 0 {
+			childOffset = levelOffsets[l-1]
+		}
+		first := 0 // index of the first chromosome below the node
+		for _, n := range levels[l] {
+			if l == 0 {
+				put(uint8(1))
+			} else {
+				put(uint8(0))
+			}
+			put(uint8(0))
+			put(uint16(n))
+			for i := 0; i < n; i++ {
+				if l == 0 {
+					buf.Write(key(chroms[first+i].Name))
+					put(uint32(first + i))
+					put(chroms[first+i].Size)
+					continue
+				}
+				buf.Write(key(chroms[first+i*spans[l]].Name))
+				put(childOffset)
+				childOffset += uint64(4 + levels[l-1][child]*(keySize+8))
+				child++
+			}
+			first += n * spans[l]
+		}
+	}
+}
+
+func newSection(chromID uint32, items []Interval) (*section, error) {
+	raw := new(bytes.Buffer)
+	le := binary.LittleEndian
+	s := &section{chromID: chromID, start: items[0].Start, end: items[len(items)-1].End}
+	for _, v := range []interface{}{chromID, s.start, s.end, uint32(0), uint32(0), uint8(1), uint8(0), uint16(len(items))} {
+		binary.Write(raw, le, v)
+	}
+	for _, it := range items {
+		binary.Write(raw, le, it)
+	}
+	s.rawSize = raw.Len()
+
+	comp := new(bytes.Buffer)
+	zw := zlib.NewWriter(comp)
+	if _, err := zw.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	s.data = comp.Bytes()
+	return s, nil
+}
+
+// rNode is a node of the R tree index covering a range of sections.
+type rNode struct {
+	startChrom, startBase, endChrom, endBase uint32
+	children                                 []*rNode
+	sec                                      *section
+}
+
+// writeIndex writes the R tree index of the data sections, the tree is
+// built bottom up with at most BlockSize children per node and written
+// level by level from the root.
+func writeIndex(buf *bytes.Buffer, sections []*section, indexOffset uint64) {
+	le := binary.LittleEndian
+	put := func(v interface{}) { binary.Write(buf, le, v) }
+
+	level := make([]*rNode, len(sections))
+	for i, s := range sections {
+		level[i] = &rNode{startChrom: s.chromID, startBase: s.start, endChrom: s.chromID, endBase: s.end, sec: s}
+	}
+	var levels [][]*rNode // from the leaves to the root
+	for {
+		var parents []*rNode
+		for i := 0; i < len(level); i += BlockSize {
+			j := i + BlockSize
+			if j > len(level) {
+				j = len(level)
+			}
+			first, last := level[i], level[j-1]
+			parents = append(parents, &rNode{startChrom: first.startChrom, startBase: first.startBase,
+				endChrom: last.endChrom, endBase: last.endBase, children: level[i:j]})
+		}
+		levels = append(levels, parents)
+		if len(parents) <= 1 {
+			break
+		}
+		level = parents
+	}
+	root := &rNode{}
+	if len(levels[len(levels)-1]) == 1 {
+		root = levels[len(levels)-1][0]
+	}
+
+	put(uint32(cirTreeMagic))
+	put(uint32(BlockSize))
+	put(uint64(len(sections)))
+	put(root.startChrom)
+	put(root.startBase)
+	put(root.endChrom)
+	put(root.endBase)
+	put(indexOffset) // end of the data
+	put(uint32(ItemsPerSection))
+	put(uint32(0))
+
+	// offsets of the nodes, the root level first
+	offset := indexOffset + 48
+	offsets := make(map[*rNode]uint64)
+	for l := len(levels) - 1; l >= 0; l-- {
+		for _, n := range levels[l] {
+			offsets[n] = offset
+			if l == 0 {
+				offset += uint64(4 + 32*len(n.children))
+			} else {
+				offset += uint64(4 + 24*len(n.children))
+			}
+		}
+	}
+
+	for l := len(levels) - 1; l >= 0; l-- {
+		for _, n := range levels[l] {
+			if l == 0 {
+				put(uint8(1))
+			} else {
+				put(uint8(0))
+			}
+			put(uint8(0))
+			put(uint16(len(n.children)))
+			for _, c := range n.children {
+				put(c.startChrom)
+				put(c.startBase)
+				put(c.endChrom)
+				put(c.endBase)
+				if l == 0 {
+					put(c.sec.offset)
+					put(uint64(len(c.sec.data)))
+				} else {
+					put(offsets[c])
+				}
+			}
+		}
+	}
+	if len(sections) == 0 { // an empty leaf as the root
+		put(uint8(1))
+		put(uint8(0))
+		put(uint16(0))
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package samstats provides alignment accuracy and identity statistics of
+// SAM/BAM records.
+//
+// The accuracy of an alignment is defined as
+//
+//	Acc = (1 - NM / (M + I + D)) * 100
+//
+// where NM is the edit distance from the NM tag (mismatches plus inserted and
+// deleted bases), M is the number of aligned bases (M, = and X CIGAR
+// operations), I is the number of inserted and D the number of deleted bases.
+// Clipped and skipped (N) bases are not part of the alignment length. This is
+// the "BLAST identity" of the alignment expressed in percent.
+package samstats
+
+import (
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+)
+
+// AlnDetails holds the alignment statistics of a record.
+type AlnDetails struct {
+	Match         int     // aligned bases without the mismatches (MatchMismatch - Mismatch)
+	Mismatch      int     // edit distance from the NM tag
+	MatchMismatch int     // bases in M, = and X operations
+	Insertion     int     // inserted bases
+	Deletion      int     // deleted bases
+	Skip          int     // skipped reference bases (N operations)
+	Len           int     // alignment length: MatchMismatch + Insertion + Deletion
+	Acc           float64 // alignment accuracy in percent
+	WAcc          float64 // accuracy weighted by the alignment length: Acc * Len
+}
+
+// EditDistance returns the value of the NM tag of a record.
+func EditDistance(r *sam.Record) (int, error) {
+	aux, ok := r.Tag([]byte("NM"))
+	if !ok {
+		return 0, fmt.Errorf("no NM tag: %s", r.Name)
+	}
+	switch v := aux.Value().(type) {
+	case int:
+		return v, nil
+	case int8:
+		return int(v), nil
+	case int16:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case uint:
+		return int(v), nil
+	case uint8:
+		return int(v), nil
+	case uint16:
+		return int(v), nil
+	case uint32:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("could not parse NM tag: %s", aux.String())
+}
+
+// CigarCounts returns the number of aligned (M, = and X), inserted, deleted
+// and skipped bases of a CIGAR.
+func CigarCounts(c sam.Cigar) (matchMismatch, ins, del, skip int) {
+	for _, op := range c {
+		switch op.Type() {
+		case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+			matchMismatch += op.Len()
+		case sam.CigarInsertion:
+			ins += op.Len()
+		case sam.CigarDeletion:
+			del += op.Len()
+		case sam.CigarSkipped:
+			skip += op.Len()
+		}
+	}
+	return
+}
+
+// GetAlnDetails calculates the alignment statistics of a record, which must
+// have an NM tag.
+func GetAlnDetails(r *sam.Record) (*AlnDetails, error) {
+	nm, err := EditDistance(r)
+	if err != nil {
+		return nil, err
+	}
+	res := new(AlnDetails)
+	res.MatchMismatch, res.Insertion, res.Deletion, res.Skip = CigarCounts(r.Cigar)
+	res.Mismatch = nm
+	res.Len = res.MatchMismatch + res.Insertion + res.Deletion
+	res.Match = res.MatchMismatch - res.Mismatch
+	res.Acc = (1.0 - float64(nm)/float64(res.Len)) * 100
+	res.WAcc = res.Acc * float64(res.Len)
+	return res, nil
+}
+
+// Accuracy returns the alignment accuracy of a record in percent.
+func Accuracy(r *sam.Record) (float64, error) {
+	info, err := GetAlnDetails(r)
+	if err != nil {
+		return 0, err
+	}
+	return info.Acc, nil
+}
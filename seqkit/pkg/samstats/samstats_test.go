@@ -0,0 +1,103 @@
+// Copyright © 2020 Oxford Nanopore Technologies, 2020 Botond Sipos.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package samstats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func newRecord(t *testing.T, cigar string, aux ...sam.Aux) *sam.Record {
+	c, err := sam.ParseCigar([]byte(cigar))
+	if err != nil {
+		t.Fatalf("parse cigar %s: %s", cigar, err)
+	}
+	return &sam.Record{Name: "r", Cigar: c, AuxFields: aux}
+}
+
+func nmTag(t *testing.T, v interface{}) sam.Aux {
+	a, err := sam.NewAux(sam.NewTag("NM"), v)
+	if err != nil {
+		t.Fatalf("new NM tag: %s", err)
+	}
+	return a
+}
+
+func TestGetAlnDetails(t *testing.T) {
+	tests := []struct {
+		cigar string
+		nm    interface{}
+		want  AlnDetails
+	}{
+		{"10M", 0, AlnDetails{Match: 10, MatchMismatch: 10, Len: 10, Acc: 100}},
+		{"10M", 2, AlnDetails{Match: 8, Mismatch: 2, MatchMismatch: 10, Len: 10, Acc: 80}},
+		{"4=1X5=", uint8(1), AlnDetails{Match: 9, Mismatch: 1, MatchMismatch: 10, Len: 10, Acc: 90}},
+		{"5M2I3M", 2, AlnDetails{Match: 6, Mismatch: 2, MatchMismatch: 8, Insertion: 2, Len: 10, Acc: 80}},
+		{"5M5D5M", int32(5), AlnDetails{Match: 5, Mismatch: 5, MatchMismatch: 10, Deletion: 5, Len: 15, Acc: 100 * (1 - 5.0/15)}},
+		{"3S10M2S", 1, AlnDetails{Match: 9, Mismatch: 1, MatchMismatch: 10, Len: 10, Acc: 90}},
+		{"5H10M5H", 0, AlnDetails{Match: 10, MatchMismatch: 10, Len: 10, Acc: 100}},
+		{"5M100N5M", 0, AlnDetails{Match: 10, MatchMismatch: 10, Skip: 100, Len: 10, Acc: 100}},
+		{"2S3=1X1I2D3=4H", uint16(4), AlnDetails{Match: 3, Mismatch: 4, MatchMismatch: 7, Insertion: 1, Deletion: 2, Len: 10, Acc: 60}},
+	}
+	for _, test := range tests {
+		r := newRecord(t, test.cigar, nmTag(t, test.nm))
+		got, err := GetAlnDetails(r)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.cigar, err)
+			continue
+		}
+		want := test.want
+		want.WAcc = want.Acc * float64(want.Len)
+		if got.Match != want.Match || got.Mismatch != want.Mismatch ||
+			got.MatchMismatch != want.MatchMismatch || got.Insertion != want.Insertion ||
+			got.Deletion != want.Deletion || got.Skip != want.Skip || got.Len != want.Len ||
+			math.Abs(got.Acc-want.Acc) > 1e-9 || math.Abs(got.WAcc-want.WAcc) > 1e-9 {
+			t.Errorf("%s NM:%v: got %+v, want %+v", test.cigar, test.nm, *got, want)
+		}
+
+		acc, err := Accuracy(r)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.cigar, err)
+		} else if math.Abs(acc-want.Acc) > 1e-9 {
+			t.Errorf("%s: accuracy %f, want %f", test.cigar, acc, want.Acc)
+		}
+	}
+}
+
+func TestEditDistanceErrors(t *testing.T) {
+	if _, err := EditDistance(newRecord(t, "10M")); err == nil {
+		t.Error("expected an error for a record without NM tag")
+	}
+	if _, err := EditDistance(newRecord(t, "10M", nmTag(t, "x"))); err == nil {
+		t.Error("expected an error for a non-integer NM tag")
+	}
+	if _, err := EditDistance(newRecord(t, "10M", nmTag(t, float32(1)))); err == nil {
+		t.Error("expected an error for a float NM tag")
+	}
+	if _, err := GetAlnDetails(newRecord(t, "10M")); err == nil {
+		t.Error("GetAlnDetails: expected an error for a record without NM tag")
+	}
+	if _, err := Accuracy(newRecord(t, "10M", nmTag(t, "x"))); err == nil {
+		t.Error("Accuracy: expected an error for an unparsable NM tag")
+	}
+}
@@ -64,6 +64,26 @@ run seq_type fun
 assert_in_stdout "DNA"
 assert_in_stdout "FASTQ"
 
+# quality statistics
+fun() {
+    echo -e "@r1\nACGT\n+\nIIII\n@r2\nACGT\n+\n++++" | $app stat -a -T
+}
+run stats_qual fun
+assert_equal "$(cut -f 14-18 $STDOUT_FILE | sed 1d)" "50.00	50.00	25.00	4	4"
+
+# assembly statistics
+fun() {
+    echo -e ">a\nACGTNNNNGC\n>b\nGGGCCC\n>c\nAAAA" | $app stat -a -T -G N
+}
+run stats_assembly fun
+assert_equal "$(cut -f 12,13,19-23 $STDOUT_FILE | sed 1d)" "4	10	4	1	7.6	50.00	1"
+
+fun() {
+    echo -e ">a\nACGT" | $app stat --format json
+}
+run stats_json fun
+assert_in_stdout '"num_seqs": 1,'
+
 
 # ------------------------------------------------------------
 
@@ -105,6 +125,25 @@ fun() {
 run seq_rna2dna fun
 assert_in_stdout "TCATATGCTTGTCTCAAAGATTA"
 
+# read cleanup
+fun() {
+    echo -e "@r\nACGTACGTACGT\n+\nIIIIIIIII###" | $app seq -5 2 --trim-qual 20
+}
+run seq_cleanup fun
+assert_equal "$(cat $STDOUT_FILE | paste -s -d ' ')" "@r GTACGTA + IIIIIII"
+assert_equal "$(echo -e "@r\nACGTACGTACGT\n+\nIIIIIIIII###" | $app seq -3 1 --trim-to-length 4 -s)" "ACGT"
+assert_equal "$(echo -e "@r\nACGTACGTACGT\n+\nIIIIIIIII###" | $app seq --min-avg-qual 20 | wc -l)" "0"
+
+# homopolymer compression
+fun() {
+    echo -e ">seq\nAAACGTTTTAAC" | $app seq --compress-homopolymers --hp-runs-file hp.runs
+}
+run seq_hpc fun
+assert_in_stdout "ACGTAC"
+assert_equal "$(cut -f 2,3 hp.runs)" "12	0:3,3:4,4:2"
+assert_equal "$(cat $STDOUT_FILE | $app seq --expand-homopolymers hp.runs -s)" "AAACGTTTTAAC"
+rm -f hp.runs
+
 # ------------------------------------------------------------
 #                         subseq
 # ------------------------------------------------------------
@@ -173,6 +212,29 @@ fun () {
 run subseq_gtf fun
 assert_equal $(echo -e "acg\nACG" | md5sum | cut -d" " -f 1) $(cat $STDOUT_FILE | md5sum | cut -d" " -f 1)
 
+# ------------------------------------------------------------
+# spliced, bed12 and gff3
+
+fun () {
+    testseq | $app subseq --bed <(echo -e "seq\t0\t10\ttx\t0\t-\t0\t10\t0\t2\t2,2,\t0,8,") --spliced | $app seq -s -w 0
+}
+run subseq_bed12_spliced fun
+assert_equal NAgt $(cat $STDOUT_FILE)
+
+gff="seq\ttest\tCDS\t1\t3\t.\t+\t0\tID=c1;Parent=t1\nseq\ttest\tCDS\t6\t8\t.\t+\t0\tID=c2;Parent=t1\n"
+
+fun () {
+    testseq | $app subseq --gff <(echo -ne $gff) --gff-type CDS | $app seq -s -w 0
+}
+run subseq_gff3_spliced fun
+assert_equal acgACG $(cat $STDOUT_FILE)
+
+fun () {
+    testseq | $app subseq --gff <(echo -ne $gff) --gff-type CDS --translate | $app seq -s -w 0
+}
+run subseq_gff3_translate fun
+assert_equal TT $(cat $STDOUT_FILE)
+
 
 
 # ------------------------------------------------------------
@@ -187,9 +249,58 @@ fun () {
 run sliding fun
 assert_equal $(echo -e "acgtn\nACGTN" | md5sum | cut -d" " -f 1) $(cat $STDOUT_FILE | md5sum | cut -d" " -f 1)
 
+fun () {
+    testseq | $app sliding -W 5 -s 5 -S
+}
+run sliding_stats fun
+assert_equal "$(cut -f 5,7 $STDOUT_FILE | paste -s -d ' ')" "$(echo -e "GC\tmasked 40.00\t100.00 40.00\t20.00")"
+
+# ------------------------------------------------------------
+#                                 shred
+# ------------------------------------------------------------
+fun () {
+    echo -e ">seq\nACGTACGTAC" | $app shred -l 4 -O 1
+}
+run shred fun
+assert_equal "$(cat $STDOUT_FILE | paste -s -d ' ')" ">seq_shred:1-4:+ ACGT >seq_shred:4-7:+ TACG >seq_shred:7-10:+ GTAC"
+assert_equal "$(echo -e ">seq\nACGTACGTAC" | $app shred -l 4 -m 4 | $app seq -n | paste -s -d ' ')" "seq_shred:1-4:+ seq_shred:5-8:+"
+assert_equal "$($app shred -l 100 -O 99 -m 100 tests/mouse-p53-cds.fna | $app seq -s | sort -u | md5sum)" "$($app sliding -W 100 -s 1 tests/mouse-p53-cds.fna | $app seq -s | sort -u | md5sum)"
+
+
+# ------------------------------------------------------------
+#                                 translate
+# ------------------------------------------------------------
+
+fun () {
+    echo -e ">s\nCCATGAAATTTGGGTAACC" | $app translate -f 6 --orf --orf-min-len 3
+}
+run translate_orf fun
+assert_equal "$(cat $STDOUT_FILE | paste -s -d ' ')" ">s_orf:3-17:+ frame=3 length=4 MKFG"
+
+fun () {
+    echo -e ">s\nATGAAATAGTTT" | $app translate --stop-at-first-stop | $app seq -s
+}
+run translate_stop_at_first_stop fun
+assert_equal $(cat $STDOUT_FILE) MK
+
+# ------------------------------------------------------------
+#                                 orfscan
+# ------------------------------------------------------------
+
+fun () {
+    echo -e ">s\nCCATGAAAATGGGGTAACC" | $app orfscan -m 1 --bed
+}
+run orfscan fun
+assert_equal "$(cat $STDOUT_FILE)" "$(echo -e "s\t2\t17\ts_orf1\t4\t+")"
+
+fun () {
+    echo -e ">s\nCCATGAAAATGGGGTAACC" | $app orfscan -m 1 -n --bed | cut -f 2,3
+}
+run orfscan_nested fun
+assert_equal "$(cat $STDOUT_FILE | paste -s -d ' ')" "$(echo -e "2\t17 8\t17")"
 
 # ------------------------------------------------------------
-#                            fq2fa, fx2tab, tab2fx
+#                            fq2fa, fx2tab, tab2fx, fq2bam
 # ------------------------------------------------------------
 
 file=tests/hairpin.fa
@@ -200,11 +311,65 @@ fun () {
 run fx2tab_tab2fx fun
 assert_equal $(cat $STDOUT_FILE | md5sum | cut -d" " -f 1) $($app seq $file | md5sum | cut -d" " -f 1)
 
+fun () {
+    echo -e 'length,sequence,name\n4,ACGT,"seq1"\n3,"TTG",seq2' | $app tab2fx -C --id-col name --seq-col sequence
+}
+run tab2fx_columns fun
+assert_equal "$($app fx2tab $STDOUT_FILE | paste -s -d ' ')" "seq1	ACGT	 seq2	TTG	"
+assert_equal "$($app fx2tab -H $file | head -n 3 | $app tab2fx --id-col name --seq-col seq --qual-col qual | md5sum)" "$($app head -n 2 $file | md5sum)"
+
+# malformed rows stop tab2fx, unless skipped with --max-bad-records
+fun () {
+    printf "r1\tACGT\tIIII\nr2\nr3\tACGT\tII\nr4\tGG\tII\n" | $app tab2fx
+}
+run tab2fx_bad_row fun
+assert_exit_code 1
+assert_in_stderr "column of ID or sequence missing"
+
+fun () {
+    printf "r1\tACGT\tIIII\nr2\nr3\tACGT\tII\nr4\tGG\tII\n" > tab2fx_bad.tsv
+    $app tab2fx --max-bad-records 2 --quarantine tab2fx_quarantine.tsv tab2fx_bad.tsv
+}
+run tab2fx_quarantine fun
+assert_equal "$($app seq -n $STDOUT_FILE | paste -s -d ,)" "r1,r4"
+assert_equal "$(sed 1d tab2fx_quarantine.tsv | cut -f 2,3,5 | paste -s -d ,)" "2	13	r2,3	16	r3\tACGT\tII"
+
+fun () {
+    $app tab2fx --max-bad-records 1 tab2fx_bad.tsv
+}
+run tab2fx_max_bad_records fun
+assert_exit_code 1
+assert_in_stderr "more than 1 bad records, the last one at tab2fx_bad.tsv:3 (byte 16)"
+rm -f tab2fx_bad.tsv tab2fx_quarantine.tsv
 
 file=tests/reads_1.fq.gz
 run fq2fa $app fq2fa $file
 assert_equal $(cat $STDOUT_FILE | md5sum | cut -d" " -f 1) $($app fx2tab $file | cut -f 1,2 | $app tab2fx | md5sum | cut -d" " -f 1)
 
+fun () {
+    echo -e "@r1\nACGTACGT\n+\nII##II#I" | $app fq2fa -q 20
+}
+run fq2fa_mask fun
+assert_equal $($app seq -s $STDOUT_FILE) "ACgtACgT"
+assert_equal $(echo -e "@r1\nACGTACGT\n+\nII##II#I" | $app fq2fa -q 20 -N | $app seq -s) "ACNNACNT"
+
+fun () {
+    $app fq2bam -r run1 -s s1 tests/reads_1.fq.gz -o fq2bam.bam
+    $app bam -T '{ToFastx: {File: "fq2bam.fq"}, Sink: True}' fq2bam.bam
+    echo -e "@r1 ch=12 start_time=2020-01-01T00:00:00Z\nACGT\n+\nIIII" | $app fq2bam -t ch:ch,start_time:st -o fq2bam_tags.bam
+    $app bam -T '{ToFastx: {File: "fq2bam_tags.fq", Tags: ["ch", "st"]}, Sink: True}' fq2bam_tags.bam
+}
+run fq2bam fun
+assert_equal "$($app fx2tab fq2bam.fq | md5sum)" "$($app fx2tab tests/reads_1.fq.gz | sed 's/ [^\t]*//' | md5sum)"
+assert_equal "$($app seq -n fq2bam_tags.fq)" "r1	ch:i:12	st:Z:2020-01-01T00:00:00Z"
+rm -f fq2bam.bam fq2bam.fq fq2bam_tags.bam fq2bam_tags.fq
+
+fun () {
+    echo -e "@r1\nACGTTTTTNA\n+\nIIIII#####" | $app fx2tab -n -i -Q -p -A -M -C
+}
+run fx2tab_columns fun
+assert_equal "$(cat $STDOUT_FILE)" "r1	0.5000	0.5000	5	1	bdc86f5ca42e4ae897c2f03d09981db5	9b581384"
+
 READS_FQ=tests/pcs109_5k.fq
 NANO_FQ_TSV=tests/pcs109_5k_fq_NanoPlot.tsv
 
@@ -264,10 +429,28 @@ run grep_by_regexp_list $app grep -r -n -f list $file
 assert_equal $($app fx2tab $STDOUT_FILE | wc -l) $($app seq -n $file | grep -E "Homo|Mus" | wc -l)
 rm list
 
+fun() {
+    echo -e ">a\nTTTACGACGGTTT\n>b\nTTTTTTTTTTTTT" | $app grep -s -d -m 1 -I -p ACGTRCGG
+}
+run grep_degenerate_indel fun
+assert_equal $($app seq -n $STDOUT_FILE) a
+
 # ------------------------------------------------------------
 #                       locate
 # ------------------------------------------------------------
 
+fun() {
+    echo -e ">s\nTTTACGACGGTTT" | $app locate -P -m 1 -I -p ACGTACGG -M
+}
+run locate_indel fun
+assert_equal "$(sed -n 2p $STDOUT_FILE | cut -f 5,6)" "$(echo -e "4\t10")"
+
+fun() {
+    echo -e ">seq\nagctggagctacc" | $app locate -p agc -m 1 --bed
+}
+run locate_bed_score fun
+assert_equal "$(grep -P '\t10\t13\t' $STDOUT_FILE | cut -f 5,6)" "$(echo -e "1\t+")"
+
 
 # ------------------------------------------------------------
 #                       rmdup
@@ -294,6 +477,70 @@ run "rmdup -s" fun
 assert_in_stderr "9 duplicated records removed"
 assert_equal $(cat $STDOUT_FILE | md5sum | cut -d" " -f 1) $(testseq | md5sum | cut -d" " -f 1)
 
+strand_seqs() {
+    echo -e ">s1\nACGTACGTAAACCCGGGTTTACGTAC\n>s2\nGTAAACCCGGGTTT\n>s3\nGTACGTAAACCCGGGTTTACGTACGT"
+}
+fun() {
+    strand_seqs | $app rmdup -s -b -c rmdup.cluster
+}
+run "rmdup -s -b" fun
+assert_in_stderr "1 duplicated records removed"
+assert_equal "$(sed 1d rmdup.cluster | paste -s -d ,)" "s1	s1	rep,s2	s2	rep,s3	s1	dup-rc"
+
+fun() {
+    strand_seqs | $app rmdup -C -b -c rmdup.cluster
+}
+run "rmdup -C" fun
+assert_in_stderr "2 duplicated or contained records removed"
+assert_equal "$(cat $STDOUT_FILE | $app seq -n)" "s1"
+assert_equal "$(sed 1d rmdup.cluster | cut -f 3 | paste -s -d ,)" "rep,contained,dup-rc"
+rm -f rmdup.cluster
+
+# ------------------------------------------------------------
+#                       dedup
+# ------------------------------------------------------------
+
+fun() {
+    $app range -r 1:2 tests/hairpin.fa > dedup.fa
+    $app head -n 1 tests/hairpin.fa | $app mutate -p 20:A | $app replace -p '^(\S+)' -r '${1}_mut' >> dedup.fa
+    $app dedup -D dedup_num.txt dedup.fa
+}
+run dedup fun
+assert_in_stderr "1 near-duplicated records removed"
+assert_equal "$($app seq -n -i $STDOUT_FILE | paste -s -d ' ')" "$($app range -r 1:2 tests/hairpin.fa | $app seq -n -i | paste -s -d ' ')"
+assert_equal "$(cut -f 1 dedup_num.txt)" "2"
+rm dedup.fa dedup_num.txt
+
+# ------------------------------------------------------------
+#                       cluster
+# ------------------------------------------------------------
+
+fun() {
+    $app range -r 1:2 tests/hairpin.fa > cluster.fa
+    $app head -n 1 tests/hairpin.fa | $app mutate -p 20:A | $app replace -p '^(\S+)' -r '${1}_mut' >> cluster.fa
+    $app cluster -j 2 -c cluster.tsv cluster.fa
+}
+run cluster fun
+assert_in_stderr "2 clusters"
+assert_equal "$($app seq -n -i $STDOUT_FILE | paste -s -d ' ')" "$($app range -r 1:2 tests/hairpin.fa | $app seq -n -i | paste -s -d ' ')"
+assert_equal "$(sed 1d cluster.tsv | cut -f 2 | paste -s -d ' ')" "$(sed 1d cluster.tsv | head -n 1 | cut -f 2) $(sed -n 3p cluster.tsv | cut -f 2) $(sed 1d cluster.tsv | head -n 1 | cut -f 2)"
+assert_equal "$(sed -n 4p cluster.tsv | cut -f 3)" "$($app head -n 1 tests/hairpin.fa | $app seq -n -i)"
+rm cluster.fa cluster.tsv
+
+# ------------------------------------------------------------
+#                       collapse
+# ------------------------------------------------------------
+
+umireads() {
+    echo -e "@r1_AACC\nACGTACGT\n+\nIIIIIIII\n@r2_AACC\nACGAACGT\n+\nIIII#III\n@r3_AACC\nACGTACGT\n+\nIIIIIIII\n@r4_GGTT\nTTTTCCCC\n+\nIIIIIIII"
+}
+fun() {
+    umireads | $app collapse
+}
+run collapse fun
+assert_equal "$($app fx2tab -n $STDOUT_FILE | cut -f 1,2 | paste -s -d ' ')" "AACC size=3	ACGTACGT GGTT size=1	TTTTCCCC"
+assert_equal "$(umireads | $app collapse -m poa -s 2 | $app fx2tab | cut -f 1,2)" "AACC size=3	ACGTACGT"
+
 # ------------------------------------------------------------
 #                       common
 # ------------------------------------------------------------
@@ -307,6 +554,38 @@ fun() {
 }
 run common fun
 assert_equal $(cat t.c | $app stat -a | md5sum | cut -d" " -f 1) $(cat t.2 | $app stat -a | md5sum | cut -d" " -f 1)
+
+fun() {
+    $app common -d -P 3 t.1 t.2 > t.d
+}
+run common_disk fun
+assert_equal $(cat t.d | $app stat -a | md5sum | cut -d" " -f 1) $(cat t.c | $app stat -a | md5sum | cut -d" " -f 1)
+rm t.*
+
+echo -e ">a\nACGT\n>b\nAAAA" > t.1
+echo -e ">c\nACGT\n>d\nCCCC" > t.2
+fun() {
+    $app common -s -O union -M t.m t.1 t.2 > t.u
+}
+run common_set_op fun
+assert_equal "$($app seq -n t.u | paste -s -d ,)" "a,b,d"
+assert_equal "$(sed 1d t.m | paste -s -d ,)" "a	1	1,b	1	0,d	0	1"
+assert_equal "$($app common -s -O difference t.1 t.2 | $app seq -n)" "b"
+rm t.*
+
+# ------------------------------------------------------------
+#                       compare
+# ------------------------------------------------------------
+
+echo -e ">s1 x\nACGT\n>s2\nAAAA\n>s3\nCCCC" > t.1
+echo -e ">s1 y\nACGT\n>s2\nAAAT\n>s4\nGGGG" > t.2
+fun() {
+    $app compare t.1 t.2 -R t.removed -A t.added > t.c
+}
+run compare fun
+assert_equal "$(sed 1d t.c | cut -f 1,2,4 | paste -s -d ,)" "~	s1	desc,~	s2	seq,+	.	.,-	s3	."
+assert_equal "$($app seq -i -n t.removed t.added | paste -s -d ,)" "s3,s4"
+assert_equal "$($app compare -D t.1 t.2 | sed 1d | cut -f 1 | paste -s -d ,)" "~,+,-"
 rm t.*
 
 
@@ -327,12 +606,256 @@ assert_equal $(ls stdin.split/* | wc -l  | tail -n 1) $(testseq | $app seq -n -i
 assert_equal $(cat stdin.split/* | $app stat -a | md5sum | cut -d" " -f 1) $(testseq | $app stat -a | md5sum | cut -d" " -f 1)
 rm -r stdin.split
 
+fun() {
+    testseq | $app split -b 2K -m -f
+}
+run split_by_bases fun
+assert_equal $(awk 'NR > 1 && $3 > 2000 && $2 > 1' stdin.split/manifest.tsv | wc -l) 0
+assert_equal $(awk 'NR > 1 {s+=$2} END {print s}' stdin.split/manifest.tsv) 100
+assert_equal $(cat stdin.split/*.fasta | $app stat -a | md5sum | cut -d" " -f 1) $(testseq | $app stat -a | md5sum | cut -d" " -f 1)
+rm -r stdin.split
+
+fun() {
+    testseq | $app split --by-chrom -z -f
+}
+run split_by_chrom fun
+assert_equal "$(ls stdin.split/ | sed 's/.fasta.gz$//' | sort)" "$(testseq | $app seq -n -i | sort)"
+rm -r stdin.split
+
+# mates land in the same part
+fun() {
+    $app split2 -1 tests/reads_1.fq.gz -2 tests/reads_2.fq.gz -l 100K -O split2_out -f --part-template "{name}_part{num}.fq.gz"
+}
+run split2_paired_template fun
+assert_equal "$(ls split2_out | head -n 2 | paste -s -d ' ')" "reads_1_part001.fq.gz reads_1_part002.fq.gz"
+assert_equal "$($app seq -n -i split2_out/reads_1_part002.fq.gz | sed 's/\/[12]$//' | md5sum)" "$($app seq -n -i split2_out/reads_2_part002.fq.gz | sed 's/\/[12]$//' | md5sum)"
+rm -r split2_out
+
+# ------------------------------------------------------------
+#                       idx
+# ------------------------------------------------------------
+
+fun() {
+    mkdir -p idx_refs
+    cp tests/hairpin.fa idx_refs/
+    $app idx idx_refs > idx_before.tsv
+    $app idx -c idx_refs > idx_create.tsv
+    touch -d '+1 min' idx_refs/hairpin.fa
+    $app idx idx_refs > idx_stale.tsv
+    $app idx --clean idx_refs > idx_clean.tsv
+}
+run idx fun
+assert_equal "$(sed 1d idx_before.tsv | cut -f 3,4)" "missing	-"
+assert_equal "$(sed 1d idx_create.tsv | cut -f 3,4)" "missing	created"
+assert_equal "$(sed 1d idx_stale.tsv | cut -f 3)" "stale"
+assert_equal "$(ls idx_refs)" "hairpin.fa"
+rm -r idx_refs idx_before.tsv idx_create.tsv idx_stale.tsv idx_clean.tsv
+
+# a corrupt .gzi declaring a huge number of entries is reported as invalid
+fun() {
+    mkdir -p idx_bgzf
+    cp tests/toolbox/tiny_ref.fa.gz idx_bgzf/
+    $app idx -c idx_bgzf > idx_bgzf_create.tsv
+    printf '\xff\xff\xff\xff\xff\xff\xff\x7f' > idx_bgzf/tiny_ref.fa.gz.gzi
+    $app idx idx_bgzf > idx_bgzf_check.tsv
+}
+run idx_gzi fun
+assert_exit_code 0
+assert_equal "$(sed 1d idx_bgzf_create.tsv | cut -f 2,4 | paste -s -d ,)" "idx_bgzf/tiny_ref.fa.gz.seqkit.fai	created,idx_bgzf/tiny_ref.fa.gz.gzi	created"
+assert_equal "$(grep '\.gzi' idx_bgzf_check.tsv | cut -f 3)" "invalid"
+rm -r idx_bgzf idx_bgzf_create.tsv idx_bgzf_check.tsv
+
+# ------------------------------------------------------------
+#                       part
+# ------------------------------------------------------------
+
+fun() {
+    $app part -n 3 -O part1 tests/reads_1.fq.gz
+    $app shuffle tests/reads_1.fq.gz -o shuffled.fq.gz
+    $app part -n 3 -O part2 shuffled.fq.gz
+}
+run part fun
+assert_equal "$(cat part1/* | $app seq -n | sort | md5sum)" "$(zcat tests/reads_1.fq.gz | $app seq -n | sort | md5sum)"
+assert_equal "$($app seq -n part1/reads_1.part_002.fq.gz | sort | md5sum)" "$($app seq -n part2/shuffled.part_002.fq.gz | sort | md5sum)"
+rm -r part1 part2 shuffled.fq.gz
+
+# ------------------------------------------------------------
+#                       demux
+# ------------------------------------------------------------
+
+fun() {
+    echo -e "s1\tAAAAAA\ns2\tCCCCCC\tGGGGGG" > demux_sheet.tsv
+    echo -e "@r1\nTAAAAAAGATTACA\n+\nIIIIIIIIIIIIII\n@r2\nCCCCACGATTACAGGGGGG\n+\nIIIIIIIIIIIIIIIIIII\n@r3\nTTTTTTGATTACA\n+\nIIIIIIIIIIIII\n@r4\nCCCCCCGATTACA\n+\nIIIIIIIIIIIII" > demux.fq
+    $app demux -b demux_sheet.tsv -m 1 --window5 7 -T demux.fq -O demux_out
+}
+run demux fun
+assert_equal "$($app fx2tab demux_out/s1.fq | cut -f 1,2)" "r1	GATTACA"
+assert_equal "$($app fx2tab demux_out/s2.fq | cut -f 1,2)" "r2	GATTACA"
+assert_equal "$($app seq -n demux_out/unassigned.fq | paste -s -d ' ')" "r3 r4"
+assert_equal "$(cut -f 1,2 demux_out/summary.tsv | sed 1d | paste -s -d ,)" "s1	1,s2	1,unassigned	2"
+rm -r demux_sheet.tsv demux.fq demux_out
+
+# ------------------------------------------------------------
+#                       pair interleave/deinterleave
+# ------------------------------------------------------------
+
+fun() {
+    $app pair interleave -1 tests/reads_1.fq.gz -2 tests/reads_2.fq.gz -o interleaved.fq
+    $app pair deinterleave interleaved.fq -1 deinterleaved_1.fq -2 deinterleaved_2.fq
+}
+run pair_interleave fun
+assert_equal "$($app seq -n deinterleaved_1.fq | md5sum)" "$($app seq -n tests/reads_1.fq.gz | md5sum)"
+assert_equal "$($app seq -n deinterleaved_2.fq | md5sum)" "$($app seq -n tests/reads_2.fq.gz | md5sum)"
+
+fun() {
+    $app shuffle -s 1 tests/reads_2.fq.gz | $app head -n 2000 > shuffled_2.fq
+    $app pair interleave -r -1 tests/reads_1.fq.gz -2 shuffled_2.fq --orphans orphans.fq -o interleaved.fq
+}
+run pair_interleave_repair fun
+assert_equal "$($app seq -n interleaved.fq | wc -l)" "4000"
+assert_equal "$($app seq -n orphans.fq | wc -l)" "500"
+rm interleaved.fq deinterleaved_1.fq deinterleaved_2.fq shuffled_2.fq orphans.fq
+
+# ------------------------------------------------------------
+#                       repair-pairs
+# ------------------------------------------------------------
+
+fun() {
+    $app shuffle -s 1 tests/reads_1.fq.gz | $app head -n 2200 > shuffled_1.fq
+    $app shuffle -s 2 tests/reads_2.fq.gz | $app head -n 2000 > shuffled_2.fq
+    $app repair-pairs -1 shuffled_1.fq -2 shuffled_2.fq -O repaired --buckets 4
+}
+run repair_pairs fun
+assert_equal "$($app seq -n repaired/shuffled_1.fq | md5sum)" "$($app seq -n repaired/shuffled_2.fq | sed 's/ 2:/ 1:/' | md5sum)"
+assert_equal "$(cat repaired/shuffled_1.fq repaired/shuffled_1.singletons.fq | $app seq -n | wc -l)" "2200"
+assert_equal "$(cat repaired/shuffled_2.fq repaired/shuffled_2.singletons.fq | $app seq -n | wc -l)" "2000"
+rm -r shuffled_1.fq shuffled_2.fq repaired
+
+# ------------------------------------------------------------
+#                       trim
+# ------------------------------------------------------------
+
+fun() {
+    echo -e "@r1\nACGTACGTACGTAGATCGGAAGTTTT\n+\nIIIIIIIIIIIIIIIIIIIIIIIIII\n@r2\nACGTACGTACGTAGATC\n+\nIIIIIIIIIIIIIIIII\n@r3\nACGTACGTACGTACGT\n+\nIIIIIIIIIIII###I\n@r4\nACGTAGATCG\n+\nIIIIIIIIII" > trim.fq
+    $app trim -a AGATCGGAAG -q 20 -m 8 --stats trim.tsv trim.fq > trimmed.fq
+}
+run trim fun
+assert_equal "$($app fx2tab trimmed.fq | cut -f 1,2 | paste -s -d ,)" "r1	ACGTACGTACGT,r2	ACGTACGTACGT,r3	ACGTACGTACGT"
+assert_equal "$(sed 1d trim.tsv | cut -f 1,5,6,7 | paste -s -d ,)" "r1	14	AGATCGGAAG	true,r2	5	AGATCGGAAG	true,r3	4	-	true,r4	6	AGATCGGAAG	false"
+rm trim.fq trim.tsv trimmed.fq
+
+# reads shorter than the bases removed from the ends
+fun() {
+    echo -e "@r1\nACGTACGTAC\n+\nIIIIIIIIII\n@r2\nACG\n+\nIII" | $app trim -5 5 -3 3 -m 1 --stats trim.tsv
+}
+run trim_short_reads fun
+assert_equal "$($app fx2tab $STDOUT_FILE | cut -f 1,2)" "r1	CG"
+assert_equal "$(sed 1d trim.tsv | cut -f 1,4,5,7 | paste -s -d ,)" "r1	5	3	true,r2	3	0	false"
+rm trim.tsv
+
+# ------------------------------------------------------------
+#                       filter
+# ------------------------------------------------------------
+
+fun() {
+    echo -e "@r1\nACGTACGTACGT\n+\n##IIIIIIIIII\n@r2\nACGTACGTACGT\n+\n##########II\n@r3\nACGT\n+\nIIII\n@r4\nGGGGCCCCGCGC\n+\nIIIIIIIIIIII" > filter.fq
+    $app filter -Q 20 -m 8 --head-crop 2 --max-gc 60 --summary filter.tsv filter.fq > filtered.fq
+}
+run filter fun
+assert_equal "$($app fx2tab filtered.fq | cut -f 1,2)" "r1	GTACGTACGT"
+assert_equal "$(sed 1d filter.tsv)" "filter.fq	4	40	1	10	3	30"
+rm filter.fq filter.tsv filtered.fq
+
+# ------------------------------------------------------------
+#                       plot
+# ------------------------------------------------------------
+
+fun() {
+    $app plot -F svg -O plot_out tests/reads_1.fq.gz
+}
+run plot fun
+assert_equal "$(ls plot_out | paste -s -d ' ')" "gc.svg length.svg qual.svg yield.svg"
+rm -r plot_out
+
+# ------------------------------------------------------------
+#                       tar archives
+# ------------------------------------------------------------
+
+fun() {
+    tar -czf reads.tar.gz -C tests reads_1.fq.gz reads_2.fq.gz
+    $app seq -n reads.tar.gz > tar_names.txt
+    $app split2 -s 1000 --tar-out reads.split.tar reads.tar.gz
+}
+run tar fun
+assert_equal "$(cat tar_names.txt | md5sum)" "$(zcat tests/reads_1.fq.gz tests/reads_2.fq.gz | $app seq -n | md5sum)"
+assert_equal "$(tar -tf reads.split.tar | paste -s -d ,)" "reads.part_001.fastq,reads.part_002.fastq,reads.part_003.fastq,reads.part_004.fastq,reads.part_005.fastq"
+assert_equal "$(tar -xOf reads.split.tar | $app seq -n | md5sum)" "$(cat tar_names.txt | md5sum)"
+assert_equal "$($app fx2tab -n -l reads.tar.gz | md5sum)" "$(zcat tests/reads_1.fq.gz tests/reads_2.fq.gz | $app fx2tab -n -l | md5sum)"
+assert_equal "$($app head -n 3 reads.tar.gz | $app seq -n | md5sum)" "$(head -n 3 tar_names.txt | md5sum)"
+assert_equal "$($app grep -n -r -p ' 2:' reads.tar.gz | $app seq -n | md5sum)" "$(zcat tests/reads_2.fq.gz | $app seq -n | md5sum)"
+rm reads.tar.gz reads.split.tar tar_names.txt
+
+# ------------------------------------------------------------
+#                       run
+# ------------------------------------------------------------
+RUN_HOME=$(mktemp -d)
+mkdir -p $RUN_HOME/.seqkit
+cp tests/aliases.yml $RUN_HOME/.seqkit/aliases.yml
+fun(){
+    HOME=$RUN_HOME $app run ids tests/hairpin.fa
+}
+run run_alias fun
+assert_equal "$(cat $STDOUT_FILE | md5sum)" "$($app seq -n -i tests/hairpin.fa | md5sum)"
+assert_equal "$(HOME=$RUN_HOME $app run -l | cut -f 1 | paste -s -d ,)" "acc,ids,motif"
+assert_equal "$(HOME=$RUN_HOME $app run motif tests/hairpin.fa | md5sum)" "$($app grep -s -r -p 'A{4,}' tests/hairpin.fa | md5sum)"
+assert_equal "$(HOME=$RUN_HOME $app run acc tests/toolbox/tiny.bam 2>&1 | grep -A 1 '^AccMean' | tail -n 1)" "$(echo -e '97.032\t96.905')"
+
+fun(){
+    HOME=$RUN_HOME $app run no-such-alias tests/hairpin.fa
+}
+run run_alias_missing fun
+assert_exit_code 1
+rm -r $RUN_HOME
+
+# ------------------------------------------------------------
+#                       sim
+# ------------------------------------------------------------
+fun(){
+    $app sim -p none -F -n 20 -l 50 tests/mouse-p53-cds.fna -a sim.paf > sim.fq
+}
+run sim fun
+assert_equal "$($app fx2tab -n -l sim.fq | cut -f 2 | sort -u)" "50"
+assert_equal "$(cut -f 5,10,11,14 sim.paf | sort -u)" "+	50	50	cg:Z:50M"
+assert_equal "$($app sim -p none -F -n 20 -l 50 tests/mouse-p53-cds.fna | md5sum)" "$(cat sim.fq | md5sum)"
+assert_equal "$($app sim -p ont -l 200 -L 50 -n 10 tests/mouse-p53-cds.fna -a sim.sam | $app seq -n | wc -l)" "10"
+assert_equal "$(grep -v '^@' sim.sam | wc -l)" "10"
+rm -f sim.fq sim.paf sim.sam
+
 # ------------------------------------------------------------
 #                       sample
 # ------------------------------------------------------------
 file=tests/hairpin.fa
 assert_equal $(cat $file | $app sample -p 0.1 | $app stat -a | md5sum | cut -d" " -f 1) $(cat $file | $app sample -p 0.1 | $app stat -a | md5sum | cut -d" " -f 1)
 
+fun () {
+    $app sample -b 10K $file | $app fx2tab -n -l | awk '{s+=$NF} END {print (s >= 10000 && s < 10000 + 180)}'
+}
+run sample_by_bases fun
+assert_equal $(cat $STDOUT_FILE) 1
+
+fun () {
+    $app sample --by length --top 5 $file | $app fx2tab -n -l | cut -f 2 | sort -n
+}
+run sample_by_length_top fun
+assert_equal "$(cat $STDOUT_FILE)" "$($app fx2tab -n -l $file | cut -f 2 | sort -nr | head -n 5 | sort -n)"
+
+# spilling candidates to temporary files gives the same result
+fun () {
+    $app sample --by quality -b 100K --max-mem-bases 10K tests/pcs109_5k.fq
+}
+run sample_by_quality_spill fun
+assert_equal "$(cat $STDOUT_FILE | md5sum)" "$($app sample --by quality -b 100K tests/pcs109_5k.fq | md5sum)"
+
 
 # ------------------------------------------------------------
 #                       head
@@ -359,6 +882,51 @@ testseq() {
 }
 assert_equal $(testseq | $app rename | $app seq -n -i | tail -n 1)  seq_2
 
+echo -e "seq_2\nseq_3" > reserved.txt
+assert_equal "$(testseq | $app rename -r reserved.txt | $app seq -n -i | paste -s -d ' ')" "seq_4 seq_5"
+rm reserved.txt
+
+fun() {
+    echo -e "@r/1\nA\n+\nI\n@s/1\nC\n+\nI\n@r/1\nG\n+\nI" > rename_1.fq
+    echo -e "@r/2\nT\n+\nI\n@s/2\nG\n+\nI\n@r/2\nC\n+\nI" > rename_2.fq
+    $app rename -1 rename_1.fq -2 rename_2.fq -O renamed_pe
+}
+run rename_paired fun
+assert_equal "$($app seq -n -i renamed_pe/rename_1.fq | paste -s -d ' ')" "r/1 s/1 r_2/1"
+assert_equal "$($app seq -n -i renamed_pe/rename_2.fq | paste -s -d ' ')" "r/2 s/2 r_2/2"
+rm -r rename_1.fq rename_2.fq renamed_pe
+
+fun() {
+    testseq | $app rename -T "{id}_x{nr}" -s 0 --pad 2 --out-map rename_map.tsv
+}
+run rename_template fun
+assert_equal "$($app seq -n -i $STDOUT_FILE | paste -s -d ' ')" "seq_x00 seq_x01"
+assert_equal "$(cut -f 2 rename_map.tsv | paste -s -d ' ')" "seq_x00 seq_x01"
+rm rename_map.tsv
+
+echo -e "seq_x1" > reserved.txt
+assert_equal "$(testseq | $app rename -T "{id}_x{nr}" -r reserved.txt | $app seq -n -i | paste -s -d ' ')" "seq_x2 seq_x3"
+rm reserved.txt
+
+fun() {
+    echo -e ">seq\na\n>other\ng\n>seq\nc" > rename_dup.fa
+    $app rename --rename-first rename_dup.fa
+}
+run rename_first fun
+assert_equal "$($app seq -n -i $STDOUT_FILE | paste -s -d ' ')" "seq_1 other seq_2"
+rm rename_dup.fa
+
+fun() {
+    echo -e ">seq\na" > rename_f1.fa
+    echo -e ">seq\nc" > rename_f2.fa
+    $app rename rename_f1.fa rename_f2.fa > rename_global.fa
+    $app rename --prefix-file rename_f1.fa rename_f2.fa > rename_prefix.fa
+}
+run rename_multiple_files fun
+assert_equal "$($app seq -n -i rename_global.fa | paste -s -d ' ')" "seq seq_2"
+assert_equal "$($app seq -n -i rename_prefix.fa | paste -s -d ' ')" "rename_f1_seq rename_f2_seq"
+rm rename_f1.fa rename_f2.fa rename_global.fa rename_prefix.fa
+
 
 
 # ------------------------------------------------------------
@@ -381,6 +949,163 @@ assert_equal $(cat $STDOUT_FILE | $app seq -s) "ACGTNacgtn"
 
 
 
+# ------------------------------------------------------------
+#                       mask
+# ------------------------------------------------------------
+maskseq() {
+    echo -e ">seq1\nACGTACGTACGTAAAAAAAAAAAAAAAAACGT"
+}
+fun(){
+    maskseq | $app mask -b <(echo -e "SEQ1\t4\t8")
+}
+run mask fun
+assert_equal $(cat $STDOUT_FILE | $app seq -s) "ACGTacgtACGTAAAAAAAAAAAAAAAAACGT"
+assert_equal $(maskseq | $app mask -b <(echo -e "seq1\t4\t8") -l dust -H -B mask.bed | $app seq -s) "ACGTNNNNACGTNNNNNNNNNNNNNNNNNCGT"
+assert_equal "$(cut -f 2,3 mask.bed | paste -s -d ' ')" "4	8 12	29"
+rm mask.bed
+
+
+
+# ------------------------------------------------------------
+#                       mutate
+# ------------------------------------------------------------
+mutseqs() {
+    echo -ne ">1\nACTGNactgn\n>2\nactgnACTGN\n"
+}
+fun(){
+    mutseqs | $app mutate -d 2-3 -i 5:xx -i 0:yy --quiet
+}
+run mutate_indels fun
+assert_equal "$(cat $STDOUT_FILE | $app seq -s | paste -s -d ' ')" "yyAGNxxactgn yyagnxxACTGN"
+assert_equal "$(mutseqs | $app mutate -m <(echo -e "1\t2\tC\tG\n1\t4\tGN\tG\n1\t8\tt\ttAA") --quiet | $app seq -s | paste -s -d ' ')" "AGTGactAAgn actgnACTGN"
+
+# ------------------------------------------------------------
+#                       bisulfite
+# ------------------------------------------------------------
+fun(){
+    echo -e ">seq\nACGTCAGTCTTA" | $app bisulfite --cpg 1 -m bs.meth
+}
+run bisulfite fun
+assert_equal "$(cat $STDOUT_FILE | $app seq -w 0 | paste -s -d ' ')" ">seq_CT ACGTTAGTTTTA >seq_GA ACGTCAATCTTA"
+assert_equal "$(sed 1d bs.meth | cut -f 2,4,5 | paste -s -d ,)" "2	CpG	1,5	CHG	0,9	CHH	0,3	CpG	1,7	CHG	0"
+assert_equal "$(echo -e ">seq\nACGTCAGTCTTA" | $app bisulfite --cpg 1 -S top -P | $app seq -s)" "TAAAACTAACGT"
+rm -f bs.meth
+
+
+
+# ------------------------------------------------------------
+#                       consensus-from-vcf
+# ------------------------------------------------------------
+testvcf() {
+    echo -e "##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tS1\tS2"
+    echo -e "chr1\t2\t.\tC\tG\t.\tPASS\t.\tGT\t0|1\t1/1"
+    echo -e "chr1\t4\t.\tGN\tG\t.\tPASS\t.\tGT\t1|1\t0/0"
+    echo -e "chr1\t8\t.\tT\tTAA,TC\t.\tq10\t.\tGT\t2|1\t./."
+}
+testvcf | gzip -c > t.vcf.gz
+fun(){
+    echo -e ">chr1\nACTGNACTGN" | $app consensus-from-vcf -v t.vcf.gz
+}
+run consensus_from_vcf fun
+assert_equal $(cat $STDOUT_FILE | $app seq -s) "AGTGACTAAGN"
+assert_equal $(echo -e ">chr1\nACTGNACTGN" | $app consensus-from-vcf -v t.vcf.gz -s S1 -H 1 -c t.chain | $app seq -s) "ACTGACTCGN"
+assert_equal "$(grep -v chain t.chain | paste -s -d ' ')" "4	1	0 3	0	1 2 "
+assert_equal $(echo -e ">chr1\nACTGNACTGN" | $app consensus-from-vcf -v t.vcf.gz -s S2 -P | $app seq -s) "AGTGNACTGN"
+rm t.vcf.gz t.chain
+
+
+
+# ------------------------------------------------------------
+#                       kmer
+# ------------------------------------------------------------
+fun(){
+    echo -e ">seq\nACGTACGT\n>seq2\nACGNACGT" | $app kmer -k 3
+}
+run kmer fun
+assert_equal "$(cat $STDOUT_FILE | paste -s -d ' ')" "ACG	7 GTA	2"
+assert_equal "$(echo -e ">seq\nACGTACGT" | $app kmer -k 3 -P -j 2 | paste -s -d ' ')" "ACG	2 CGT	2 GTA	1 TAC	1"
+assert_equal "$(echo -e ">seq\nACGTACGT" | $app kmer -k 3 -H | paste -s -d ' ')" "2	1 4	1"
+assert_equal "$(echo -e ">seq\nACGTACGT" | $app kmer -k 3 -J -m 3 | paste -s -d ' ')" ">4 ACG"
+
+
+
+# ------------------------------------------------------------
+#                       sketch
+# ------------------------------------------------------------
+file=tests/hairpin.fa
+fun(){
+    $app head -n 2 $file | $app sketch -i -k 11 -s 100 > t.sketch
+}
+run sketch fun
+assert_equal $(grep -c -v '^#' t.sketch) 2
+assert_equal "$($app head -n 1 $file | $app sketch -i -k 11 -s 100 | $app sketch dist -r t.sketch - | awk 'NR == 2 {print $6}')" "1.000000"
+assert_equal "$($app sketch dist t.sketch | wc -l)" 2
+rm t.sketch
+
+fun () {
+    $app head -n 1 $file | $app sketch -S 5 -k 15 > t.sketch
+    $app head -n 2 $file | $app screen -r t.sketch -a t.assign
+}
+run screen fun
+assert_equal "$(awk '$1 == "clean" {print $2}' $STDOUT_FILE)" 1
+assert_equal "$(sed -n 2p t.assign | cut -f 3)" "1.000000"
+rm t.sketch t.assign
+
+
+
+# ------------------------------------------------------------
+#                       complexity
+# ------------------------------------------------------------
+fun(){
+    echo -e ">s1\nACGTACGTAC\n>s2\nACGGGGGGTTNNNNNAAAAAc" | $app complexity
+}
+run complexity fun
+assert_equal "$(sed 1d $STDOUT_FILE | paste -s -d ' ')" "s1	10	1.9710	0.6512	1	0.0000 s2	21	1.8113	0.3905	6	0.5238"
+assert_equal "$(echo -e ">s1\nAAAAAAAAAA" | $app complexity -W 6 -s 3 | sed 1d | cut -f 2,3,7 | paste -s -d ' ')" "1	6	6 4	9	6 7	10	4"
+
+
+
+fun () {
+    $app watch -Q -x -f ReadLen,MeanQual tests/reads_1.fq.gz
+}
+run watch_fields fun
+assert_equal $($app seq -n $STDOUT_FILE | wc -l) $($app seq -n tests/reads_1.fq.gz | wc -l)
+
+fun () {
+    mkdir -p scat_dir; cp tests/reads_1.fq.gz scat_dir/
+    $app scat -f -R scat.state scat_dir
+}
+run scat_resume fun
+assert_equal $($app seq -n $STDOUT_FILE | wc -l) $($app seq -n tests/reads_1.fq.gz | wc -l)
+assert_equal $($app scat -f -R scat.state scat_dir 2>/dev/null | wc -l) 0
+rm -rf scat_dir scat.state
+
+fun () {
+    echo -e ">p1_F\nccc\n>p1_R\nttt\n>p2_LEFT\nTRC\n>p2_RIGHT\nWGG" > primers.fa
+    echo -e ">seq1\nACGCCCACTGAAATGA\n>seq2\nACGTACGGTCAGATCCA" | $app amplicon -p primers.fa -S amplicon.stats --bed
+}
+run amplicon_primer_fasta fun
+assert_equal "$(cut -f 1,4 $STDOUT_FILE | paste -s -d ,)" "seq1	p1,seq2	p2"
+assert_equal "$(sed 1d amplicon.stats | cut -f 1,4 | paste -s -d ,)" "p1	1,p2	1"
+assert_equal "$(echo -e ">seq\nacgcccactgaaatga" | $app amplicon -F cYcacA -R ttcagt -m 1)" "$(echo -e ">seq\ncccactgaa")"
+rm -f primers.fa amplicon.stats
+
+fun () {
+    $app head -n 1 tests/mouse-p53-cds.fna | $app subseq -r 21:100 > start.fa
+    $app head -n 1 tests/mouse-p53-cds.fna | $app restart -i 11 | $app seq -r -p | $app rotate -q start.fa --quiet
+}
+run restart_start_query fun
+assert_equal "$($app seq -s $STDOUT_FILE)" "$($app head -n 1 tests/mouse-p53-cds.fna | $app restart -i 21 | $app seq -s)"
+rm -f start.fa
+
+fun () {
+    echo -e ">seq\nGGGGCCCCAATT" | $app gcskew -W 4 -s 4 -r gcskew.oriter
+}
+run gcskew fun
+assert_equal "$(sed 1d $STDOUT_FILE | cut -f 4,5 | paste -s -d ,)" "1.000000	1.000000,-1.000000	0.000000,0.000000	0.000000"
+assert_equal "$(sed 1d gcskew.oriter | cut -f 3,4)" "1	4"
+rm -f gcskew.oriter
+
 # ------------------------------------------------------------
 #                       shuffle and sort
 # ------------------------------------------------------------
@@ -405,6 +1130,28 @@ assert_equal $(cat $file | $app stat -a | md5sum | cut -d" " -f 1) $(cat t.sort.
 assert_equal $(cat $file | $app stat -a | md5sum | cut -d" " -f 1) $(cat t.sort.s | $app stat -a | md5sum | cut -d" " -f 1)
 rm t.sort.*
 
+# external sort gives the same order as in-memory sort
+fun () {
+    $app sort -l -x --chunk-bases 5K $file | $app fx2tab -n -l
+}
+run sort_external fun
+assert_equal "$(cut -f 2 $STDOUT_FILE | md5sum)" "$($app fx2tab -n -l $file | cut -f 2 | sort -n | md5sum)"
+assert_equal "$($app sort -x --chunk-bases 5K $file | $app seq -n | md5sum)" "$($app sort $file | $app seq -n | md5sum)"
+
+fun () {
+    $app sort -e 'MI(\d+)' $file | $app seq -n | sed -r 's/.*MI0*([0-9]+).*/\1/'
+}
+run sort_by_num_regexp fun
+assert_equal "$(cat $STDOUT_FILE | md5sum)" "$(cat $STDOUT_FILE | sort -n | md5sum)"
+
+# headers without a number stay at the end with -r
+testseq() {
+    echo -e ">r2 n=5\na\n>r1\nc\n>r10 n=12\ng"
+}
+assert_equal "$(testseq | $app sort -e 'n=(\d+)' -r | $app seq -n -i | paste -s -d ' ')" "r10 r2 r1"
+# natural order agrees between in-memory and external sort
+assert_equal "$(testseq | $app sort -N -x | $app seq -n -i | paste -s -d ' ')" "$(testseq | $app sort -N | $app seq -n -i | paste -s -d ' ')"
+
 #-------------------------------------------------------------
 #                       bam
 #-------------------------------------------------------------
@@ -531,6 +1278,485 @@ cmp tests/bundler_stats_merged.tsv tests/bundler_stats_bulk.tsv
 assert_equal $? 0
 rm -fr tests/bundler_test tests/bundler_stats_merged.tsv tests/bundler_stats_bulk.tsv 
 
+# ------------------------------------------------------------
+#                       bam toolbox
+# ------------------------------------------------------------
+# tiny.bam is generated from tiny.sam: read1 and read2 have poly(T)/poly(A)
+# reference context at their start/end, the remaining four records do not.
+TINY_BAM=tests/toolbox/tiny.bam
+TINY_REF=tests/toolbox/tiny_ref.fa
+CTX_PARAMS="Ref: \"$TINY_REF\", LeftShift: -10, RightShift: 10, RegexStart: \"T{4,}\", RegexEnd: \"A{4,}\""
+
+# single tool as a sink
+fun(){
+    $app bam -T '{AccStats: {Tsv: "tb_acc.tsv"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_acc_stats fun
+assert_equal "$(sed -n 2p tb_acc.tsv)" "$(echo -e '97.032\t96.905')"
+rm -f tb_acc.tsv
+
+# AlnContext -> AccStats -> writer
+fun(){
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx.tsv\", $CTX_PARAMS}, AccStats: {Tsv: \"tb_acc.tsv\"}}" $TINY_BAM > tb_out.bam
+    $app bam -T '{Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' tb_out.bam
+}
+run bam_toolbox_pipeline fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read1,read2"
+assert_equal "$(sed -n 2p tb_acc.tsv)" "$(echo -e '95.316\t95.385')"
+assert_equal $(sed 1d tb_ctx.tsv | wc -l) 12
+assert_equal "$(awk '$7 == 1 && $6 != "-"' tb_ctx.tsv | cut -f 1,4,6 | paste -s -d ,)" "read1	start	T{4,},read1	end	A{4,},read2	end	A{4,}"
+rm -f tb_ctx.tsv tb_acc.tsv tb_dump.tsv tb_out.bam
+
+# inverted AlnContext -> AccStats -> writer, the order of the tools matters
+fun(){
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx.tsv\", Invert: True, $CTX_PARAMS}, AccStats: {Tsv: \"tb_acc.tsv\"}}" $TINY_BAM > tb_out.bam
+    $app bam -T '{Dump: {Tsv: "tb_dump.tsv", Fields: ["Read", "Ref", "Pos"]}, Sink: True}' tb_out.bam
+}
+run bam_toolbox_pipeline_invert fun
+assert_equal "$(sed 1d tb_dump.tsv | cut -f 1 | paste -s -d ,)" "read3,read6,read5,read4"
+assert_equal "$(sed -n 2p tb_acc.tsv)" "$(echo -e '97.890\t97.826')"
+assert_equal $(sed 1d tb_ctx.tsv | wc -l) 12
+assert_equal "$(awk '$4 == "start" && $7 == 0' tb_ctx.tsv | cut -f 1 | paste -s -d ,)" "read1,read2"
+rm -f tb_ctx.tsv tb_acc.tsv tb_dump.tsv tb_out.bam
+
+# strand-aware AlnContext matches the contexts in read orientation
+fun(){
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx.tsv\", StrandAware: True, $CTX_PARAMS}, Sink: True}" $TINY_BAM
+}
+run bam_toolbox_aln_context_strand_aware fun
+assert_equal "$(sed 1d tb_ctx.tsv | awk '$6 != "-"' | cut -f 1-4 | paste -s -d ,)" "read1	ctg1	1	start,read1	ctg1	1	end,read2	ctg1	-1	start"
+rm -f tb_ctx.tsv
+
+# context windows reaching over the contig start are clamped
+fun(){
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx.tsv\", Ref: \"$TINY_REF\", LeftShift: -30, RightShift: 10, RegexStart: \"^GCTAAA\"}, Sink: True}" $TINY_BAM
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx_nm.tsv\", Ref: \"$TINY_REF\", LeftShift: -30, RightShift: 10, RegexStart: \"^GCTAAA\", OutOfRangeNoMatch: True}, Sink: True}" $TINY_BAM
+}
+run bam_toolbox_aln_context_clamp fun
+assert_equal "$(awk '$4 == "start" && $7 == 1' tb_ctx.tsv | cut -f 1 | paste -s -d ,)" "read3,read6"
+assert_equal "$(awk '$7 == 1' tb_ctx_nm.tsv | wc -l)" "0"
+rm -f tb_ctx.tsv tb_ctx_nm.tsv
+
+# the reference cache gives the same contexts when the records switch between contigs (name order)
+# and for a bgzip-compressed reference
+fun(){
+    mkdir -p tb_ref_gz
+    cp tests/toolbox/tiny_ref.fa.gz tb_ref_gz/
+    $app bam -T '{SortOutput: "name", AccStats: {Tsv: "tb_acc.tsv"}}' $TINY_BAM > tb_name.bam
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx.tsv\", $CTX_PARAMS}, Sink: True}" $TINY_BAM
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx_name.tsv\", $CTX_PARAMS}, Sink: True}" tb_name.bam
+    $app bam -T "{AlnContext: {Tsv: \"tb_ctx_gz.tsv\", Ref: \"tb_ref_gz/tiny_ref.fa.gz\", LeftShift: -10, RightShift: 10, RegexStart: \"T{4,}\", RegexEnd: \"A{4,}\"}, Sink: True}" $TINY_BAM
+}
+run bam_toolbox_ref_cache fun
+assert_equal $(sed 1d tb_ctx_name.tsv | wc -l) 12
+assert_equal "$(sed 1d tb_ctx_name.tsv | sort)" "$(sed 1d tb_ctx.tsv | sort)"
+assert_equal "$(cat tb_ctx_gz.tsv)" "$(cat tb_ctx.tsv)"
+rm -rf tb_ref_gz tb_acc.tsv tb_name.bam tb_ctx.tsv tb_ctx_name.tsv tb_ctx_gz.tsv
+
+# the written BAM must be readable by the toolbox again and keep the records intact
+fun(){
+    $app bam -T '{AccStats: {Tsv: "tb_acc1.tsv"}}' $TINY_BAM > tb_out.bam
+    $app bam -T '{AccStats: {Tsv: "tb_acc2.tsv"}, Sink: True}' tb_out.bam
+}
+run bam_toolbox_roundtrip fun
+cmp tb_acc1.tsv tb_acc2.tsv
+assert_equal $? 0
+rm -f tb_acc1.tsv tb_acc2.tsv tb_out.bam $TINY_REF.seqkit.fai
+
+# reader and writer threads set independently from the flags and the config
+fun(){
+    $app bam --reader-threads 2 -T '{WriterThreads: 3, AccStats: {Tsv: "tb_acc1.tsv"}}' $TINY_BAM > tb_out.bam
+    $app bam --writer-threads 1 -T '{ReaderThreads: 4, AccStats: {Tsv: "tb_acc2.tsv"}, Sink: True}' tb_out.bam
+}
+run bam_toolbox_io_threads fun
+cmp tb_acc1.tsv tb_acc2.tsv
+assert_equal $? 0
+rm -f tb_acc1.tsv tb_acc2.tsv tb_out.bam
+
+# output to a named file with a compression level
+fun(){
+    $app bam --compress-level 1 -o tb_out.bam -T '{AccStats: {Tsv: "tb_acc1.tsv"}}' $TINY_BAM
+    $app bam -T '{AccStats: {Tsv: "tb_acc2.tsv"}, Sink: True}' tb_out.bam
+}
+run bam_toolbox_out_file fun
+cmp tb_acc1.tsv tb_acc2.tsv
+assert_equal $? 0
+assert_equal "$(ls tb_out.bam.tmp* 2> /dev/null | wc -l)" "0"
+rm -f tb_acc1.tsv tb_acc2.tsv tb_out.bam
+
+# sorted output, spilling chunks of two records to temporary files
+fun(){
+    $app bam -T '{SortOutput: "name", SortChunk: 2, AccStats: {Tsv: "tb_acc.tsv"}}' $TINY_BAM > tb_name.bam
+    $app bam -T '{Dump: {Tsv: "tb_dump_name.tsv", Fields: ["Read"]}, SortOutput: "coordinate", SortChunk: 4}' tb_name.bam > tb_coord.bam
+    $app bam -T '{Dump: {Tsv: "tb_dump_coord.tsv", Fields: ["Read"]}, Sink: True}' tb_coord.bam
+}
+run bam_toolbox_sort_output fun
+assert_equal "$(sed 1d tb_dump_name.tsv | paste -s -d ,)" "read1,read2,read3,read4,read5,read6"
+assert_equal "$(sed 1d tb_dump_coord.tsv | paste -s -d ,)" "read3,read6,read1,read2,read5,read4"
+rm -f tb_acc.tsv tb_name.bam tb_coord.bam tb_dump_name.tsv tb_dump_coord.tsv
+
+# BaseQualityFilter
+fun(){
+    $app bam -T '{BaseQualityFilter: {MinMeanQual: 21.95}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_base_quality_filter fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read6,read1"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{BaseQualityFilter: {TrimQual: 15}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read", "Pos", "LeftHardClip", "RightHardClip"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_base_quality_trim fun
+assert_equal "$(sed 1d tb_dump.tsv | cut -f 2 | paste -s -d ,)" "20,31,51,61,21,31"
+assert_equal "$(sed 1d tb_dump.tsv | cut -f 3,4 | tr '\t' ':' | paste -s -d ,)" "1:0,1:0,1:0,1:0,1:1,1:1"
+rm -f tb_dump.tsv
+
+# EndMismatch
+fun(){
+    $app bam -T "{EndMismatch: {Ref: \"$TINY_REF\", Window: 5, Tsv: \"tb_mm.tsv\"}, Sink: True}" $TINY_BAM
+}
+run bam_toolbox_end_mismatch fun
+assert_equal $(sed 1d tb_mm.tsv | wc -l) 120
+assert_equal $(sed 1d tb_mm.tsv | awk '{s += $4} END {print s}') 0
+assert_equal $(awk '$1 == "Start" && $2 == 0 && $3 == "A>C" {print $5}' tb_mm.tsv) 5
+assert_equal $(awk '$1 == "End" && $2 == 0 && $3 == "A>C" {print $5}' tb_mm.tsv) 6
+rm -f tb_mm.tsv $TINY_REF.seqkit.fai
+
+# Dedup, tiny_dup.bam has a secondary copy of read1 and a copy of read4 under a different name
+TINY_DUP_BAM=tests/toolbox/tiny_dup.bam
+fun(){
+    $app bam -T '{Dedup: {By: "name"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read", "MapQual"]}, Sink: True}' $TINY_DUP_BAM
+}
+run bam_toolbox_dedup_name fun
+assert_equal "$(sed 1d tb_dump.tsv | cut -f 1 | paste -s -d ,)" "read3,read6,read1,read2,read5,read4,read4dup"
+assert_equal $(grep -w read1 tb_dump.tsv | cut -f 2) 60
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{Dedup: {By: "signature"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_DUP_BAM
+}
+run bam_toolbox_dedup_signature fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read3,read6,read1,read2,read5,read4"
+rm -f tb_dump.tsv
+
+# schema version and parameter validation
+fun(){
+    $app bam -T '{SchemaVersion: 1, AccStats: {Tsv: "tb_acc.tsv"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_schema_version fun
+assert_exit_code 0
+assert_equal "$(sed -n 2p tb_acc.tsv)" "$(echo -e '97.032\t96.905')"
+rm -f tb_acc.tsv
+
+fun(){
+    $app bam -T '{SchemaVersion: 999, AccStats: {Tsv: "-"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_schema_version_unsupported fun
+assert_in_stderr "unsupported SchemaVersion"
+
+fun(){
+    $app bam -T '{AccStats: {Tvs: "-"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_unknown_param fun
+assert_in_stderr "unknown parameter for AccStats"
+
+# Exec
+fun(){
+    $app bam -T '{Exec: {Cmd: "awk '"'"'/^@/ || $5 >= 60'"'"'"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read", "Acc"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_exec fun
+assert_equal "$(sed 1d tb_dump.tsv | cut -f 1 | paste -s -d ,)" "read3,read1,read2,read5,read4"
+assert_equal "$(grep -w read2 tb_dump.tsv | cut -f 2)" "92.632"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{Script: {Code: "if r.mapq < 60 then return false end; r.name = r.name .. \"_\" .. r.ref"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_script fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read3_ctg1,read1_ctg1,read2_ctg1,read5_ctg2,read4_ctg2"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{SizeGuard: {Tsv: "tb_size.tsv", MaxSize: 190, Top: 2}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_size_guard fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read3,read5,read4"
+assert_equal "$(sed 1d tb_size.tsv | cut -f 1,2 | paste -s -d ,)" "read2	200,read6	199"
+rm -f tb_dump.tsv tb_size.tsv
+
+fun(){
+    $app bam -c tb_counts.tsv -Q $TINY_BAM
+    $app bam --count-only -c tb_counts_fast.tsv -Q $TINY_BAM
+}
+run bam_count_only fun
+assert_equal "$(cat tb_counts_fast.tsv)" "$(cat tb_counts.tsv)"
+rm -f tb_counts.tsv tb_counts_fast.tsv
+
+fun(){
+    $app bam --count-only -T '{ToBed: {Tsv: "tb_fast.bed"}, Sink: True}' $TINY_BAM
+    $app bam -T '{ToBed: {Tsv: "tb.bed"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_count_only fun
+assert_exit_code 0
+assert_equal "$(cat tb_fast.bed)" "$(cat tb.bed)"
+rm -f tb.bed tb_fast.bed
+
+fun(){
+    $app bam --count-only -T '{AccStats: {Tsv: "-"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_count_only_var_data fun
+assert_exit_code 1
+assert_in_stderr "--count-only can not be used with AccStats"
+
+fun(){
+    $app bam --count-only -T '{Dedup: {By: "signature", UmiTag: "RX"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_count_only_umi fun
+assert_exit_code 1
+
+fun(){
+    $app bam -T '{AccStats: {Tsv: "-"}, Sink: "maybe"}' $TINY_BAM
+}
+run bam_toolbox_bad_sink fun
+assert_exit_code 1
+assert_in_stderr "Sink must be True or False"
+
+# truncated BAM and gzip inputs
+fun(){
+    head -c 500 $TINY_BAM > truncated.bam
+    $app bam -c tb_counts.tsv --eof-check fail truncated.bam
+}
+run bam_eof_check_fail fun
+assert_exit_code 1
+assert_in_stderr "missing BGZF EOF marker"
+
+fun(){
+    head -c 5000 tests/hairpin.fa.gz > truncated.fa.gz
+    $app stats --eof-check-gzip truncated.fa.gz
+}
+run gzip_eof_check_warn fun
+assert_in_stderr "truncated gzip file"
+rm -f truncated.bam tb_counts.tsv truncated.fa.gz
+
+fun(){
+    $app bam -T '{MergeMates: {Tools: [{Script: {Code: "return r.mapq >= 30"}}]}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_DUP_BAM
+}
+run bam_toolbox_merge_mates fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read3,read2,read5,read4,read4dup"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{StratifiedSample: {Total: 3, Tsv: "tb_strata.tsv"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Ref"]}, Sink: True}' $TINY_BAM
+    $app bam -T '{StratifiedSample: {Total: 4, Mode: "equal", Tsv: "tb_strata_eq.tsv"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_stratified_sample fun
+assert_equal "$(sed 1d tb_strata.tsv | paste -s -d ,)" "ctg1	4	2,ctg2	2	1"
+assert_equal "$(sed 1d tb_dump.tsv | sort | uniq -c | awk '{print $2 ":" $1}' | paste -s -d ,)" "ctg1:2,ctg2:1"
+assert_equal "$(sed 1d tb_strata_eq.tsv | paste -s -d ,)" "ctg1	4	2,ctg2	2	2"
+rm -f tb_strata.tsv tb_strata_eq.tsv tb_dump.tsv
+
+fun(){
+    $app bam -T '{ToPaf: {Tsv: "tb_out.paf", Cigar: True, Cs: True, Ref: "'$TINY_REF'"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_to_paf fun
+assert_equal "$(grep -w read1 tb_out.paf | cut -f 1-14)" "read1	100	0	100	+	ctg1	200	50	150	98	100	60	tp:A:P	NM:i:2"
+assert_equal "$(grep -w read2 tb_out.paf | cut -f 1-12,15)" "read2	95	0	95	-	ctg1	200	60	150	88	95	60	cg:Z:40M5I50M"
+assert_equal "$(grep -w read3 tb_out.paf | cut -f 3,4,16)" "10	70	cs:Z::60"
+rm -f tb_out.paf $TINY_REF.seqkit.fai
+
+# the secondary alignment of read1 has no SEQ, so it gets no cs tag
+fun(){
+    $app bam -T '{ToPaf: {Tsv: "tb_out.paf", Cs: True, Ref: "'$TINY_REF'"}, Sink: True}' tests/toolbox/tiny_noseq.bam
+}
+run bam_toolbox_to_paf_no_seq fun
+assert_equal "$(cut -f 1-9,13 tb_out.paf | paste -s -d ,)" "read1	100	0	100	+	ctg1	200	50	150	tp:A:P,read1	40	0	40	+	ctg2	150	30	65	tp:A:S"
+assert_equal "$(grep -c cs:Z: tb_out.paf)" "1"
+rm -f tb_out.paf $TINY_REF.seqkit.fai
+
+fun(){
+    $app bam -T '{Composition: {Tsv: "tb_comp.tsv"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_composition fun
+assert_equal "$(sed 1d tb_comp.tsv | cut -f 1 | paste -s -d ,)" "read3,read1,read2,read5,read4"
+assert_equal "$(grep -w read3 tb_comp.tsv)" "read3	70	38.571	0.086	22	11	16	21	0"
+assert_equal "$(grep -w read2 tb_comp.tsv)" "read2	95	41.053	0.063	26	19	20	30	0"
+rm -f tb_comp.tsv
+
+fun(){
+    $app bam -T '{ToBed: {Tsv: "tb_out.bed"}, Sink: True}' $TINY_BAM
+    $app bam -T '{ToBed: {Tsv: "tb_split.bed", Split: True}, Sink: True}' tests/pcs109_5k_spliced.bam
+    $app bam -T '{ToBed: {Tsv: "tb_out12.bed", Bed12: True}, Sink: True}' tests/pcs109_5k_spliced.bam
+}
+run bam_toolbox_to_bed fun
+assert_equal "$(cat tb_out.bed | paste -s -d ,)" "ctg1	20	80	read3	60	+,ctg1	50	150	read1	60	+,ctg1	60	150	read2	60	-,ctg2	20	102	read5	60	-,ctg2	30	110	read4	60	+"
+assert_equal "$(wc -l < tb_split.bed)" "$(awk '{s+=$10} END{print s}' tb_out12.bed)"
+rm -f tb_out.bed tb_split.bed tb_out12.bed
+
+# OnTarget
+fun(){
+    $app bam -T '{OnTarget: {Annotation: "tests/toolbox/tiny.bed"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_on_target fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read1,read2,read5"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{OnTarget: {Annotation: "tests/toolbox/tiny.bed", Invert: True}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_on_target_invert fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read3,read6,read4"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{OnTarget: {Annotation: "tests/toolbox/tiny.bed", MinOverlap: 10}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_on_target_min_overlap fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read1,read2"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{OnTarget: {Annotation: "tests/toolbox/tiny.gtf"}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+    $app bam -T '{OnTarget: {Annotation: "tests/toolbox/tiny.gtf", Types: ["exon"]}, Dump: {Tsv: "tb_dump_exon.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_on_target_gtf fun
+assert_equal "$(sed 1d tb_dump.tsv | paste -s -d ,)" "read1,read2,read5,read4"
+assert_equal "$(sed 1d tb_dump_exon.tsv | paste -s -d ,)" "read5"
+rm -f tb_dump.tsv tb_dump_exon.tsv
+
+fun(){
+    $app bam --count-only -T '{OnTarget: {Annotation: "tests/toolbox/tiny.bed"}, ToBed: {Tsv: "tb_on_target.bed"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_on_target_count_only fun
+assert_equal "$(cut -f 4 tb_on_target.bed | paste -s -d ,)" "read1,read2,read5"
+rm -f tb_on_target.bed
+
+fun(){
+    $app bam -T '{OnTarget: {Annotation: "tests/toolbox/tiny.txt"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_on_target_bad_format fun
+assert_exit_code 1
+assert_in_stderr "unsupported annotation format"
+
+fun(){
+    $app bam -T '{SpliceStats: {Tsv: "tb_splice.tsv", Summary: "tb_splice_sum.tsv", Ref: "'$TINY_REF'"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_splice_stats fun
+assert_equal "$(sed 1d tb_splice.tsv | cut -f 1,5 | paste -s -d ,)" "read3	0,read1	0,read2	0,read5	0,read4	0"
+assert_equal "$(sed 1d tb_splice_sum.tsv | cut -f 1-3,8)" "5	0	0	NA"
+rm -f tb_splice.tsv tb_splice_sum.tsv
+
+fun(){
+    $app bam -T '{ToBigWig: {File: "tb_cov.bw"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_to_bigwig fun
+assert_equal "$(head -c 4 tb_cov.bw | od -An -tx1 | tr -d ' ')" "26fc8f88"
+rm -f tb_cov.bw
+
+fun(){
+    $app bam -T '{TripletSpectrum: {Tsv: "tb_spectrum.tsv", Ref: "'$TINY_REF'"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_triplet_spectrum fun
+assert_equal "$(sed 1d tb_spectrum.tsv | wc -l)" "96"
+assert_equal "$(sed -n '2p;97p' tb_spectrum.tsv | cut -f 1,2 | paste -s -d ,)" "A[C>A]A	C>A,T[T>G]T	T>G"
+rm -f tb_spectrum.tsv
+
+fun(){
+    $app bam -T '{QualCalibration: {Tsv: "tb_calib.tsv", Summary: "tb_calib_sum.tsv"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_qual_calibration fun
+assert_equal "$(sed 1d tb_calib.tsv | cut -f 1,2 | paste -s -d ,)" "read3	60,read1	100,read2	95,read5	80,read4	80"
+assert_equal "$(sed 1d tb_calib_sum.tsv | awk '{s+=$4} END{print s}')" "5"
+rm -f tb_calib.tsv tb_calib_sum.tsv
+
+fun(){
+    $app bam -T '{QualBin: {Edges: [0], Values: [20]}, BaseQualityFilter: {MinMeanQual: 19.5}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+    $app bam -T '{QualBin: {Edges: [0], Values: [20]}, BaseQualityFilter: {MinMeanQual: 20.5}, Dump: {Tsv: "tb_dump2.tsv", Fields: ["Read"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_qual_bin fun
+assert_equal "$(sed 1d tb_dump.tsv | wc -l)" "6"
+assert_equal "$(sed 1d tb_dump2.tsv | wc -l)" "0"
+rm -f tb_dump.tsv tb_dump2.tsv
+
+fun(){
+    $app bam -T '{Consensus: {Fasta: "tb_cons.fa", Bed: "tb_cons.bed", MinDepth: 1}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_consensus fun
+assert_equal "$($app fx2tab -n -l tb_cons.fa | paste -s -d ,)" "ctg1	200,ctg2	150"
+assert_equal "$(cut -f 1,4 tb_cons.bed | paste -s -d ,)" "ctg1	depth,ctg1	depth,ctg2	depth,ctg2	depth"
+rm -f tb_cons.fa tb_cons.bed
+
+# references without records are masked as a whole
+fun(){
+    $app bam -T '{Script: {Code: "return r.ref ~= \"ctg1\""}, Consensus: {Fasta: "tb_cons.fa", Bed: "tb_cons.bed", MinDepth: 1}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_consensus_uncovered fun
+assert_equal "$($app fx2tab -n -l tb_cons.fa | paste -s -d ,)" "ctg1	200,ctg2	150"
+assert_equal "$($app grep -n -p ctg1 tb_cons.fa | $app seq -s -w 0 | tr -d 'N\n' | wc -c)" "0"
+assert_equal "$(head -n 1 tb_cons.bed)" "$(echo -e 'ctg1\t0\t200\tdepth')"
+rm -f tb_cons.fa tb_cons.bed
+
+# masked positions take lower case reference bases
+fun(){
+    $app consensus -r $TINY_REF -d 1 -b cons.bed $TINY_BAM > cons.fa
+}
+run consensus fun
+assert_equal "$($app fx2tab -n -l cons.fa | paste -s -d ,)" "ctg1	200,ctg2	150"
+assert_equal "$($app seq -s -w 0 cons.fa | tr -d 'ACGTN\n' | wc -c)" "$(awk '{s+=$3-$2} END {print s}' cons.bed)"
+assert_equal "$($app consensus -r $TINY_REF -d 1 -n $TINY_BAM | $app seq -s -w 0 | tr -d 'ACGTN\n' | wc -c)" "0"
+rm -f cons.fa cons.bed
+
+fun(){
+    $app bam -T '{StripSeq: {}, Dump: {Tsv: "tb_dump.tsv", Fields: ["Read", "ReadSeq"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_strip_seq fun
+assert_equal "$(sed 1d tb_dump.tsv | awk '$2 == ""' | cut -f 1)" "read6"
+rm -f tb_dump.tsv
+
+fun(){
+    $app bam -T '{MultiQC: {Json: "tb_mqc.json", Sample: "tiny"}, Sink: True}' $TINY_BAM
+    $app bam -T '{MultiQC: {Json: "tb_fail_mqc.json", MinAcc: 100}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_multiqc fun
+assert_equal "$(grep -o -e '"tiny"' -e '"Records": [0-9]*' -e '"Secondary": [0-9]*' -e '"Verdict": "[A-Z]*"' tb_mqc.json | paste -s -d ,)" '"tiny","Verdict": "PASS","Records": 6,"Secondary": 1'
+assert_equal "$(grep -o '"Failed": "[A-Za-z]*"' tb_fail_mqc.json)" '"Failed": "MinAcc"'
+rm -f tb_mqc.json tb_fail_mqc.json
+
+fun(){
+    $app bam -T '{Rate: {Tsv: "tb_rate.tsv", Interval: 1}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_rate fun
+assert_equal "$(head -n 1 tb_rate.tsv | cut -f 2,5)" "RecordsPerSec	TotalRecords"
+assert_equal "$(tail -n 1 tb_rate.tsv | cut -f 5)" "6"
+rm -f tb_rate.tsv
+
+fun(){
+    $app bam -T '{ToFastx: {File: "tb_reads.fq", Tags: ["NM"]}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_to_fastx fun
+assert_equal "$($app seq -n tb_reads.fq | paste -s -d ,)" "read3	NM:i:0,read1	NM:i:2,read2	NM:i:7,read5	NM:i:2,read4	NM:i:4"
+assert_equal "$($app grep -p read2 tb_reads.fq | $app seq -s)" "$(awk '$1 == "read2" {print ">"$1"\n"$10}' tests/toolbox/tiny.sam | $app seq -r -p -s)"
+rm -f tb_reads.fq
+
+fun(){
+    $app bam -T '{ToFastx: {File: "-"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_to_fastx_stdout fun
+assert_equal "$($app seq -n $STDOUT_FILE | paste -s -d ,)" "read3,read1,read2,read5,read4"
+
+fun(){
+    $app bam -T '{ToFastx: {File: "-"}}' $TINY_BAM
+}
+run bam_toolbox_to_fastx_stdout_no_sink fun
+assert_exit_code 1
+assert_in_stderr "with Sink: True"
+
+fun(){
+    $app bam -T '{ToFastx: {Format: "fasta"}, Sink: True}' $TINY_BAM
+}
+run bam_toolbox_to_fastx_no_file fun
+assert_exit_code 1
+assert_in_stderr "File is required"
+
 # ------------------------------------------------------------
 #                       fish
 # ------------------------------------------------------------
@@ -550,6 +1776,27 @@ cmp seqkit_fish.tsv tests/pcs109_5k_fish_regression.tsv
 assert_exit_code 0
 rm seqkit_fish.tsv
 
+fun(){
+    echo -e ">r1\nAAAAACGATCGGAAGAGCAAAAA\n>r2\nTTTTTGCTCTTCCGATCGTTTTT\n>r3\nCCCCCCCCCCCCCCCC" \
+        | $app fish -F AGATCGGAAGAGC -P fish.paf -O fish.orient 2> /dev/null
+}
+run fish_paf_orientation fun
+assert_equal "$(cut -f 1,5,6 fish.paf | paste -s -d ,)" "q0	+	r1,q0	-	r2"
+assert_equal "$(cut -f 1,6 fish.orient | sed 1d | paste -s -d ,)" "r1	+,r2	-,r3	."
+rm -f fish.paf fish.orient
+
+# ------------------------------------------------------------
+#                       align
+# ------------------------------------------------------------
+
+fun(){
+    echo -e ">ref\nACGTACGTACGT\n>q1\nACGTACCGTACGT\n>q2\nGGGACGTACGTACGTGGG" \
+        | $app align -m semi-global > align.tsv
+}
+run align fun
+assert_equal "$(sed 1d align.tsv | cut -f 1,5,13 | paste -s -d ,)" "q1	17	5M1I7M,q2	24	3S12M3S"
+rm -f align.tsv
+
 # ------------------------------------------------------------
 #                       sana
 # ------------------------------------------------------------
@@ -575,6 +1822,23 @@ cmp tests/sana_output.fq tests/sana_ground.fq
 assert_equal $? 0
 rm -f tests/sana_output.fq tests/sana_test_input.fq
 
+# quarantine of malformed records
+fun(){
+	printf "@r1\nACGT\n+\nIIII\n@r2\nAC!T\n+\nIIII\n@r3\nACGT\n+\nIIII\n" > sana_broken.fq
+	$app sana --quarantine sana_bad.tsv sana_broken.fq > sana_rescued.fq
+}
+run sana_quarantine fun
+assert_equal "$($app seq -n sana_rescued.fq | paste -s -d ,)" "r1,r3"
+assert_equal "$(sed 1d sana_bad.tsv | wc -l)" "4"
+assert_equal "$(sed 1d sana_bad.tsv | cut -f 2,3 | paste -s -d ,)" "5	16,6	20,7	25,8	27"
+
+fun(){
+	$app sana --max-bad-records 2 sana_broken.fq > sana_rescued.fq
+}
+run sana_max_bad_records fun
+assert_exit_code 1
+rm -f sana_broken.fq sana_rescued.fq sana_bad.tsv
+
 # Regression test for sana/fasta empty file issue
 fun(){
 	$app  sana -j 2 -i fasta tests/empty.fx
@@ -688,6 +1952,35 @@ cmp tests/sorted_scat_find.fq tests/sorted_scat_test_all.fq
 assert_equal $? 0
 rm -f tests/sorted_scat_output.fq tests/sorted_scat_test_all.fq tests/sorted_scat_find.fq tests/scat_test_all_sana.fq
 
+# per-barcode yield summaries
+fun(){
+	BASE=tests/scat_test_yield
+	rm -fr $BASE
+	mkdir -p $BASE/fastq_pass/barcode01 $BASE/fastq_pass/barcode02
+	echo -e "@r1\nACGTACGTAC\n+\n++++++++++\n@r2\nACGTA\n+\n+++++" > $BASE/fastq_pass/barcode01/a.fastq
+	echo -e "@r3\nACGTACGTACGTACGTACGT\n+\n++++++++++++++++++++" > $BASE/fastq_pass/barcode02/b.fastq
+	$app scat -f -i fastq -y tests/scat_yield.tsv $BASE > /dev/null
+	rm -fr $BASE
+}
+run scat_yield fun
+assert_equal "$(cut -f 1-7 tests/scat_yield.tsv | sed 1d | paste -s -d ,)" "barcode01	2	15	5	10	7.50	10,barcode02	1	20	20	20	20.00	20"
+assert_equal "$(cut -f 8 tests/scat_yield.tsv | sed 1d | paste -s -d ,)" "10.00,10.00"
+rm -f tests/scat_yield.tsv
+
+# ------------------------------------------------------------
+#                       classify
+# ------------------------------------------------------------
+
+fun(){
+    $app seq -r -p -t dna tests/toolbox/tiny_ref.fa | $app replace -p "$" -r _rc > cls_reads.fa
+    (cat tests/toolbox/tiny_ref.fa; head -n 2 tests/hsa.fa) >> cls_reads.fa
+    $app classify -k 15 -r tests/toolbox/tiny_ref.fa -s cls_summary.tsv cls_reads.fa > cls_out.tsv
+}
+run classify fun
+assert_equal "$(cut -f 2 cls_out.tsv | sed 1d | paste -s -d ,)" "ctg1,ctg2,ctg1,ctg2,unclassified"
+assert_equal "$(sed 1d cls_summary.tsv | cut -f 2 | paste -s -d ,)" "2,2,1"
+rm -f cls_reads.fa cls_out.tsv cls_summary.tsv
+
 # ------------------------------------------------------------
 #                       faidx
 # ------------------------------------------------------------
@@ -717,4 +2010,17 @@ fun(){
 }
 run faidx_region fun
 assert_equal $($app grep -p $ref $file | $app subseq -r 5:-5 | $app seq -s -w 0) $(cat $outFile | $app seq -s -w 0)
-rm $idFile $outFile
+
+fun(){
+    $app faidx $file "${ref}:1-1,0:-" > $outFile
+}
+run faidx_region_strand fun
+assert_equal $($app grep -p $ref $file | $app subseq -r 1:10 | $app seq -r -p -t rna | $app seq -s -w 0) $(cat $outFile | $app seq -s -w 0)
+
+fun(){
+    echo -e "${ref}\t0\t10\tr1\t0\t+\n${ref}:11-20" > regions.txt
+    $app faidx $file -l regions.txt > $outFile
+}
+run faidx_region_file fun
+assert_equal $($app grep -p $ref $file | $app subseq -r 1:20 | $app seq -s -w 0) $(cat $outFile | $app seq -s -w 0 | paste -s -d '')
+rm regions.txt $idFile $outFile